@@ -755,7 +755,8 @@ const refreshConversations = async () => {
       newAlerts.forEach((c) => alertedHumanUsers.add(c.user_id));
       playAlertBeep();
       const notifBody = newAlerts.length === 1
-        ? `ลูกค้า ...${newAlerts[0].user_id.slice(-8)} ต้องการคุยกับเจ้าหน้าที่`
+        ? `ลูกค้า ...${newAlerts[0].user_id.slice(-8)} ต้องการคุยกับเจ้าหน้าที่` +
+          (newAlerts[0].handoff_summary ? `\n${newAlerts[0].handoff_summary}` : "")
         : `ลูกค้า ${newAlerts.length} รายต้องการคุยกับเจ้าหน้าที่`;
       if (Notification.permission === "granted") {
         new Notification("🆘 NCS Admin", { body: notifBody, icon: "" });
@@ -788,6 +789,7 @@ function renderConvList() {
       const shortId = c.user_id ? c.user_id.slice(-8) : "?";
       const displayName = c.nickname || c.display_name || `…${shortId}`;
       const alertBadge = c.wants_human ? '<span class="alert-badge">🆘 ขอคุย</span>' : "";
+      const urgentBadge = c.urgent ? '<span class="alert-badge">⚡ ด่วน</span>' : "";
       const takeoverBadge = c.takeover
         ? '<span class="mode-badge human">👤 Admin</span>'
         : '<span class="mode-badge ai">🤖 AI</span>';
@@ -795,6 +797,10 @@ function renderConvList() {
         ? escapeHtml(c.last_message).slice(0, 55) + (c.last_message.length > 55 ? "…" : "")
         : "<em>ไม่มีข้อความ</em>";
       const msgCount = `<small>${c.message_count} ข้อความ</small>`;
+      const totals = c.turn_totals || {};
+      const turnCost = totals.turns
+        ? `<small title="โทเคนรวม / เวลาตอบเฉลี่ย">🪙 ${totals.tokens.toLocaleString()} · ⏱ ${formatLatency(totals.avg_latency_ms)}</small>`
+        : "";
       const lastSeen = c.last_seen ? `<small>${c.last_seen.replace("T", " ")}</small>` : "";
       // Unread badge: new messages since admin last read this conversation
       const lastRead = convState.lastReadTimestamp[c.user_id];
@@ -803,10 +809,10 @@ function renderConvList() {
       return `<div class="conv-item${isActive ? " active" : ""}${hasUnread ? " has-unread" : ""}" data-uid="${escapeAttr(c.user_id)}">
         <div class="conv-item-top">
           <span class="conv-uid">${escapeHtml(displayName)}</span>
-          ${unreadBadge}${alertBadge}${takeoverBadge}
+          ${unreadBadge}${urgentBadge}${alertBadge}${takeoverBadge}
         </div>
         <div class="conv-item-preview">${lastMsg}</div>
-        <div class="conv-item-meta">${msgCount} ${lastSeen}</div>
+        <div class="conv-item-meta">${msgCount} ${turnCost} ${lastSeen}</div>
       </div>`;
     })
     .join("");
@@ -816,6 +822,27 @@ function renderConvList() {
   });
 }
 
+function formatLatency(ms) {
+  return ms >= 1000 ? `${(ms / 1000).toFixed(1)}s` : `${ms || 0}ms`;
+}
+
+const turnPathLabels = { assistant: "AI", cache: "cache", faq: "FAQ", fast_path: "fast-path" };
+
+// turnMeta shows what an AI reply cost: answering path, tokens, tool calls and latency.
+function turnMeta(t) {
+  const parts = [turnPathLabels[t.path] || t.path];
+  if (t.model) parts.push(t.model);
+  if (t.variant) parts.push(`A/B: ${t.variant}`);
+  if (t.input_tokens || t.output_tokens) {
+    parts.push(`🪙 ${(t.input_tokens || 0).toLocaleString()} in / ${(t.output_tokens || 0).toLocaleString()} out`);
+  }
+  if (t.tools && t.tools.length) parts.push(`🔧 ${t.tools.join(", ")}`);
+  parts.push(`⏱ ${formatLatency(t.latency_ms)}`);
+  if (t.error) parts.push(`⚠️ ${t.error}`);
+  const slow = t.latency_ms >= 15000 || (t.input_tokens || 0) + (t.output_tokens || 0) >= 20000;
+  return `<div class="bubble-turn${slow ? " expensive" : ""}">${escapeHtml(parts.join(" · "))}</div>`;
+}
+
 async function selectConversation(userId) {
   convState.selectedUserId = userId;
   convState.forceScrollBottom = true; // always scroll to bottom when opening a conversation
@@ -887,7 +914,15 @@ function renderConvThread(conv) {
   const lastRead = convState.lastReadTimestamp[conv.user_id];
   let unreadDividerAdded = false;
 
-  convEls.messages.innerHTML = msgs
+  // Handoff brief pinned above the transcript while the customer waits for staff
+  const handoffCard = conv.wants_human && conv.handoff
+    ? `<div class="handoff-card">
+        <div class="handoff-title">📋 สรุปก่อนรับเรื่อง <small>(${escapeHtml(conv.handoff.reason || "")})</small></div>
+        <div class="handoff-text">${escapeHtml(conv.handoff.text)}</div>
+      </div>`
+    : "";
+
+  convEls.messages.innerHTML = handoffCard + msgs
     .map((m) => {
       const cls =
         m.role === "customer" ? "bubble-customer" : m.role === "admin" ? "bubble-admin" : "bubble-ai";
@@ -904,6 +939,7 @@ function renderConvThread(conv) {
         <div class="bubble ${cls}">
           <div class="bubble-label">${label} <span class="bubble-time">${escapeHtml(time)}</span></div>
           <div class="bubble-text">${escapeHtml(m.text)}</div>
+          ${m.turn ? turnMeta(m.turn) : ""}
         </div>
       </div>`;
     })
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Answers are cached per customer, keyed by the question with case, punctuation and spacing
+// normalized away, so "ซักโซฟาราคาเท่าไร?" asked again later in the day is answered without a
+// model call. Each customer keeps the ANSWER_CACHE_SIZE most recently used answers for
+// ANSWER_CACHE_SECONDS. Some turns are never cached:
+//   - questions about free slots, bookings or dates ("คิวว่างพรุ่งนี้ไหม"), whose answer changes
+//     over time (timeSensitivePattern);
+//   - short replies like "ได้ค่ะ" or "ok", whose meaning depends on the previous message;
+//   - turns that called a tool reading bookings, slots or the customer's account; only the
+//     reference tools in cacheableTools leave a turn cacheable;
+//   - turns with a failed tool call, and turns with photos (see vision_cache.go).
+//
+// Answers quoting prices are dropped when the price list changes (invalidatePricedAnswers).
+// With an FAQ store (faq_cache.go), questions that are worded differently match as well.
+
+// cachedAnswer is the assistant's answer to a normalized question.
+type cachedAnswer struct {
+	Answer string
+	At     time.Time
+	Vector []float32 // embedding of the question, when the FAQ store is in use
+}
+
+// answerCache is one customer's cached answers. order lists the keys from least to most
+// recently used.
+type answerCache struct {
+	entries map[string]cachedAnswer
+	order   []string
+}
+
+// userAnswerCache maps a customer to their cached answers. Guarded by userThreadLock.
+var userAnswerCache = make(map[string]*answerCache)
+
+// minCachedQuestionLetters skips replies too short to stand on their own. Thai vowel and tone
+// marks don't count, so "ใช่ครับ" has 5 letters and "ราคาเท่าไหร่" has 10.
+const minCachedQuestionLetters = 8
+
+var timeSensitivePattern = regexp.MustCompile(`(?i)ว่าง|คิว|จอง|นัด|เลื่อน|ยกเลิก|วันนี้|พรุ่งนี้|มะรืน|ตอนนี้|สัปดาห์|อาทิตย์|เดือน|วันที่|โอน|สลิป|สมาชิก|สัญญา|บัตรกำนัล|available|booking|book|today|tomorrow|slot|\d{1,2}[/-]\d{1,2}`)
+
+// cacheableTools are the tools whose results depend only on the price list and the
+// knowledge base, not on the date or the customer.
+var cacheableTools = map[string]bool{
+	"get_ncs_pricing":               true,
+	"compare_services":              true,
+	"get_membership_info":           true,
+	"get_image_analysis_guidance":   true,
+	"get_workflow_step_instruction": true,
+}
+
+func answerCacheEnabled() bool {
+	return appConfig.AnswerCacheTTL > 0
+}
+
+// answerCacheKey normalizes a question for lookup. ok is false when the question is not
+// cacheable.
+func answerCacheKey(message string) (key string, ok bool) {
+	if !answerCacheEnabled() || timeSensitivePattern.MatchString(normalizeInboundText(message)) {
+		return "", false
+	}
+	text, ok := questionText(message)
+	if !ok {
+		return "", false
+	}
+	// Thai doesn't separate words, so spaces are dropped rather than collapsed
+	return strings.ReplaceAll(text, " ", ""), true
+}
+
+// questionText returns the message in lower case without punctuation, or false for photo
+// turns and replies too short to stand on their own.
+func questionText(message string) (string, bool) {
+	if imageDataURLPattern.MatchString(message) {
+		return "", false
+	}
+	text := strings.ToLower(normalizeInboundText(message))
+	letters := 0
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsPunct(r), unicode.IsSymbol(r):
+			return ' '
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			letters++
+		}
+		return r
+	}, text)
+	if letters < minCachedQuestionLetters {
+		return "", false
+	}
+	return strings.Join(strings.Fields(text), " "), true
+}
+
+// cachedAnswerFor returns the customer's stored answer to the same question.
+func cachedAnswerFor(userId, message string) (string, bool) {
+	key, ok := answerCacheKey(message)
+	if !ok {
+		return "", false
+	}
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	c := userAnswerCache[userId]
+	if c == nil {
+		appMetrics.inc("answer_cache_misses")
+		return "", false
+	}
+	a, ok := c.entries[key]
+	if !ok || time.Since(a.At) > appConfig.AnswerCacheTTL {
+		if ok {
+			c.remove(key)
+		}
+		appMetrics.inc("answer_cache_misses")
+		return "", false
+	}
+	c.touch(key)
+	appMetrics.inc("answer_cache_hits")
+	return a.Answer, true
+}
+
+// storeCachedAnswer caches the answer to a turn that used only the given tools, evicting the
+// customer's least recently used answer when the cache is full. vector is the question's
+// embedding, if it was looked up.
+func storeCachedAnswer(userId, message, answer string, tools []string, vector []float32) {
+	key, ok := answerCacheKey(message)
+	if !ok || answer == "" {
+		return
+	}
+	for _, tool := range tools {
+		if !cacheableTools[tool] {
+			return
+		}
+	}
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	c := userAnswerCache[userId]
+	if c == nil {
+		c = &answerCache{entries: make(map[string]cachedAnswer)}
+		userAnswerCache[userId] = c
+	}
+	c.entries[key] = cachedAnswer{Answer: answer, At: time.Now(), Vector: vector}
+	c.touch(key)
+	for len(c.order) > appConfig.AnswerCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// touch marks key as the most recently used.
+func (c *answerCache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+func (c *answerCache) remove(key string) {
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeMatching drops the answers drop returns true for and reports how many went.
+func (c *answerCache) removeMatching(drop func(cachedAnswer) bool) int {
+	removed := 0
+	for key, a := range c.entries {
+		if drop(a) {
+			c.remove(key)
+			removed++
+		}
+	}
+	return removed
+}
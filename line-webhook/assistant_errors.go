@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Failures travel up the stack as the types below, and this file is the one place that turns
+// them into what the customer is told and how loudly they are logged. Error text is for logs
+// and the model; customers only ever get the replies in errorReplies.
+
+// UpstreamError is a failed call to an external service (OpenAI, LINE, Apps Script).
+type UpstreamError struct {
+	Service    string
+	StatusCode int // 0 when no HTTP response was received
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s returned HTTP %d: %v", e.Service, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s request failed: %v", e.Service, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// ToolError is a tool call the model made that could not be executed as asked.
+// The model still receives a text result and can recover, so it does not fail the turn.
+type ToolError struct {
+	Tool string
+	Err  error
+}
+
+func (e *ToolError) Error() string { return fmt.Sprintf("tool %s: %v", e.Tool, e.Err) }
+
+func (e *ToolError) Unwrap() error { return e.Err }
+
+// TimeoutError is an operation that ran out of time.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string { return fmt.Sprintf("%s timed out: %v", e.Op, e.Err) }
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+var errNoAssistantReply = errors.New("no assistant text in response")
+
+// classifyRequestError wraps a transport error as a TimeoutError or UpstreamError.
+func classifyRequestError(service string, err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{Op: service, Err: err}
+	}
+	return &UpstreamError{Service: service, Err: err}
+}
+
+// errorKind names the error class for metrics and logs.
+func errorKind(err error) string {
+	var upstream *UpstreamError
+	var tool *ToolError
+	var timeout *TimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		return "timeout"
+	case errors.As(err, &upstream):
+		return "upstream"
+	case errors.As(err, &tool):
+		return "tool"
+	}
+	return "internal"
+}
+
+// errorReplies are the customer-facing replies for a failed turn, in Thai and English.
+var errorReplies = map[string][2]string{
+	"timeout": {"ขออภัยค่ะ ระบบใช้เวลาตอบนานกว่าปกติ กรุณาส่งข้อความอีกครั้งนะคะ 🙏",
+		"Sorry, this is taking longer than usual. Please send your message again. 🙏"},
+	"unavailable": {"ขออภัยค่ะ ระบบตอบกลับอัตโนมัติขัดข้องชั่วคราว กรุณาลองใหม่อีกครั้งในอีกสักครู่นะคะ 🙏",
+		"Sorry, our assistant is briefly unavailable. Please try again in a few minutes. 🙏"},
+	"internal": {"ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้งหรือติดต่อเจ้าหน้าที่",
+		"Sorry, something went wrong on our side. Please try again or contact our staff."},
+}
+
+// assistantErrorMessage is the customer-facing reply for a failed assistant turn, in the
+// language the customer writes ("th" or "en").
+func assistantErrorMessage(err error, lang string) string {
+	reply := errorReplies["internal"]
+	var upstream *UpstreamError
+	switch {
+	case errorKind(err) == "timeout":
+		reply = errorReplies["timeout"]
+	case errors.As(err, &upstream) && upstream.Service == "openai" && upstreamUnavailable(upstream):
+		reply = errorReplies["unavailable"]
+	}
+	if lang == "en" {
+		return reply[1]
+	}
+	return reply[0]
+}
+
+// upstreamUnavailable reports whether the service is down or overloaded rather than
+// refusing the request.
+func upstreamUnavailable(e *UpstreamError) bool {
+	return e.StatusCode == 0 || e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// errorLogLevel is how loudly to log a failure:
+//   - Info: expected outcomes, such as a cancelled turn or a price the list doesn't have;
+//   - Warn: timeouts, outages of a service and other tool failures the model recovers from;
+//   - Error: requests a service refused (a key, quota or payload to fix) and internal bugs.
+func errorLogLevel(err error) slog.Level {
+	var upstream *UpstreamError
+	var tool *ToolError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, pricing.ErrNotFound):
+		return slog.LevelInfo
+	case errorKind(err) == "timeout":
+		return slog.LevelWarn
+	case errors.As(err, &upstream):
+		if upstreamUnavailable(upstream) {
+			return slog.LevelWarn
+		}
+		return slog.LevelError
+	case errors.As(err, &tool):
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MarketingAttribution records where a conversation came from (first touch wins).
+type MarketingAttribution struct {
+	Source   string    `json:"source"`             // e.g. "richmenu", "facebook", "flyer"
+	Campaign string    `json:"campaign,omitempty"` // e.g. "songkran-2025"
+	Code     string    `json:"code,omitempty"`     // greeting code the customer typed, if any
+	At       time.Time `json:"at"`
+}
+
+// CampaignCode maps a greeting code printed on ads/flyers to its source and campaign.
+type CampaignCode struct {
+	Source   string `json:"source"`
+	Campaign string `json:"campaign,omitempty"`
+}
+
+var campaignCodesFile = "campaign_codes.json"
+
+var (
+	campaignCodeLock sync.RWMutex
+	campaignCodes    = map[string]CampaignCode{} // keyed by upper-case code
+)
+
+// attributeConversation sets the conversation's source unless it already has one.
+// Caller must hold userThreadLock.
+func attributeConversation(conv *UserConversation, source, campaign, code string) {
+	if conv.Attribution != nil || source == "" {
+		return
+	}
+	conv.Attribution = &MarketingAttribution{Source: source, Campaign: campaign, Code: code, At: time.Now()}
+	appMetrics.inc("attributed_conversations")
+	log.Printf("Attributed user %s to source %s campaign %s", conv.UserID, source, campaign)
+}
+
+// matchCampaignCode finds a registered greeting code among the words of a message.
+func matchCampaignCode(msg string) (string, CampaignCode, bool) {
+	campaignCodeLock.RLock()
+	defer campaignCodeLock.RUnlock()
+	if len(campaignCodes) == 0 {
+		return "", CampaignCode{}, false
+	}
+	for _, word := range strings.Fields(strings.ToUpper(msg)) {
+		word = strings.Trim(word, "#.,!?:;\"'()")
+		if cc, ok := campaignCodes[word]; ok {
+			return word, cc, true
+		}
+	}
+	return "", CampaignCode{}, false
+}
+
+// attributionFromPostback reads source/campaign parameters from rich menu or
+// campaign postback data such as "source=richmenu&campaign=songkran".
+func attributionFromPostback(data string) (source, campaign string) {
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return "", ""
+	}
+	source = values.Get("source")
+	if source == "" {
+		source = values.Get("utm_source")
+	}
+	campaign = values.Get("campaign")
+	if campaign == "" {
+		campaign = values.Get("utm_campaign")
+	}
+	return source, campaign
+}
+
+func loadCampaignCodes() {
+	data, err := os.ReadFile(campaignCodesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read campaign codes file: %v", err)
+		}
+		return
+	}
+	codes := map[string]CampaignCode{}
+	if err := json.Unmarshal(data, &codes); err != nil {
+		log.Printf("Failed to parse campaign codes file: %v", err)
+		return
+	}
+	campaignCodeLock.Lock()
+	campaignCodes = normalizeCampaignCodes(codes)
+	campaignCodeLock.Unlock()
+}
+
+func normalizeCampaignCodes(codes map[string]CampaignCode) map[string]CampaignCode {
+	result := make(map[string]CampaignCode, len(codes))
+	for code, cc := range codes {
+		result[strings.ToUpper(strings.TrimSpace(code))] = cc
+	}
+	return result
+}
+
+func handleGetCampaignCodes(c *fiber.Ctx) error {
+	campaignCodeLock.RLock()
+	defer campaignCodeLock.RUnlock()
+	return c.JSON(campaignCodes)
+}
+
+func handleReplaceCampaignCodes(c *fiber.Ctx) error {
+	var incoming map[string]CampaignCode
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	for code, cc := range incoming {
+		if strings.TrimSpace(code) == "" || strings.ContainsAny(code, " \t\n") {
+			return respondError(c, fiber.StatusBadRequest, fmt.Sprintf("invalid code '%s'", code))
+		}
+		if cc.Source == "" {
+			return respondError(c, fiber.StatusBadRequest, fmt.Sprintf("code '%s' needs a source", code))
+		}
+	}
+	codes := normalizeCampaignCodes(incoming)
+	data, err := json.MarshalIndent(codes, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save campaign codes")
+	}
+	if err := os.WriteFile(campaignCodesFile, data, 0644); err != nil {
+		log.Printf("Failed to save campaign codes: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save campaign codes")
+	}
+	campaignCodeLock.Lock()
+	campaignCodes = codes
+	campaignCodeLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "codes": codes})
+}
+
+// SourceFunnel is the conversion funnel for one source/campaign.
+type SourceFunnel struct {
+	Source        string  `json:"source"`
+	Campaign      string  `json:"campaign"`
+	Conversations int     `json:"conversations"`
+	Quoted        int     `json:"quoted"`
+	Booked        int     `json:"booked"`
+	Completed     int     `json:"completed"`
+	QuoteRate     float64 `json:"quote_rate"`
+	BookingRate   float64 `json:"booking_rate"`
+}
+
+// handleGetSourceAnalytics reports per-source funnels. Unattributed conversations count as "direct".
+// Optional ?since=YYYY-MM-DD limits to conversations attributed (or first seen) on or after that date.
+func handleGetSourceAnalytics(c *fiber.Ctx) error {
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "since must be YYYY-MM-DD")
+		}
+		since = t
+	}
+
+	booked := map[string]bool{}
+	completed := map[string]bool{}
+	bookingLock.Lock()
+	for _, b := range bookings {
+		if b.Status != "cancelled" {
+			booked[b.UserID] = true
+		}
+		if b.Status == "completed" {
+			completed[b.UserID] = true
+		}
+	}
+	bookingLock.Unlock()
+
+	funnels := map[string]*SourceFunnel{}
+	userThreadLock.Lock()
+	for _, conv := range userConversations {
+		source, campaign := "direct", ""
+		if conv.Attribution != nil {
+			if conv.Attribution.At.Before(since) {
+				continue
+			}
+			source, campaign = conv.Attribution.Source, conv.Attribution.Campaign
+		} else if !since.IsZero() && len(conv.Messages) > 0 && conv.Messages[0].Timestamp < since.Format("2006-01-02") {
+			continue
+		}
+		key := source + "|" + campaign
+		f, ok := funnels[key]
+		if !ok {
+			f = &SourceFunnel{Source: source, Campaign: campaign}
+			funnels[key] = f
+		}
+		f.Conversations++
+		if !conv.Profile.LastQuoteAt.IsZero() {
+			f.Quoted++
+		}
+		if booked[conv.UserID] {
+			f.Booked++
+		}
+		if completed[conv.UserID] {
+			f.Completed++
+		}
+	}
+	userThreadLock.Unlock()
+
+	result := make([]SourceFunnel, 0, len(funnels))
+	for _, f := range funnels {
+		if f.Conversations > 0 {
+			f.QuoteRate = float64(f.Quoted) / float64(f.Conversations)
+			f.BookingRate = float64(f.Booked) / float64(f.Conversations)
+		}
+		result = append(result, *f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Conversations > result[j].Conversations })
+	return c.JSON(result)
+}
+
+// recordPostbackAttribution attributes a user from rich menu or campaign postback data.
+func recordPostbackAttribution(userId, data string) {
+	source, campaign := attributionFromPostback(data)
+	if userId == "" || source == "" {
+		return
+	}
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		conv = &UserConversation{UserID: userId}
+		userConversations[userId] = conv
+	}
+	attributeConversation(conv, source, campaign, "")
+	userThreadLock.Unlock()
+	go saveConversations()
+}
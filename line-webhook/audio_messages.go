@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Voice notes (LINE audio messages, m4a) are downloaded, stored next to customer files under
+// audio/<userId>/ and transcribed in Thai. The transcript goes into the buffer like a typed
+// message, marked as spoken so the assistant allows for recognition mistakes.
+
+// transcriptionPrompt primes the recognizer with the words customers use most.
+const transcriptionPrompt = "ลูกค้าสอบถามบริการทำความสะอาดของ NCS เช่น ซักที่นอน ซักโซฟา ผ้าม่าน พรม ราคา จองคิว วันว่าง มัดจำ ที่อยู่"
+
+func transcriptionModel() string {
+	return appConfig.TranscribeModel
+}
+
+// handleInboundAudio stores and transcribes a voice note and returns the message text that
+// goes into the conversation.
+func handleInboundAudio(userId, messageID string, durationMs int) string {
+	data, err := downloadLineContent(userId, messageID)
+	if err != nil {
+		log.Printf("Failed to download audio %s from %s: %v", messageID, userId, err)
+		appMetrics.inc("audio_transcription_errors")
+		return "ลูกค้าส่งข้อความเสียง (ระบบเปิดไฟล์เสียงไม่ได้ ให้ขอโทษและขอให้ลูกค้าพิมพ์ข้อความแทน)"
+	}
+	appMetrics.inc("inbound_audio")
+	appMetrics.add("inbound_audio_seconds", int64(durationMs/1000))
+
+	ctx, cancel := context.WithTimeout(withUsageUser(context.Background(), userId), 60*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("audio/%s/%s.m4a", userId, messageID)
+	if err := coldStore.Put(ctx, key, data); err != nil {
+		log.Printf("Failed to store audio %s from %s: %v", messageID, userId, err)
+	}
+
+	text, err := transcribeAudio(ctx, messageID+".m4a", data)
+	if err != nil || strings.TrimSpace(text) == "" {
+		log.Printf("Failed to transcribe audio %s from %s: %v", messageID, userId, err)
+		appMetrics.inc("audio_transcription_errors")
+		return "ลูกค้าส่งข้อความเสียง (ถอดเสียงไม่สำเร็จ ให้ขอโทษและขอให้ลูกค้าพิมพ์ข้อความแทน)"
+	}
+	debugf(userId, "Transcribed %d ms voice note: %s", durationMs, logContent(text))
+	return "ลูกค้าส่งข้อความเสียง (ถอดเสียงอัตโนมัติ อาจมีคำผิด): " + strings.TrimSpace(text)
+}
+
+func transcribeAudio(ctx context.Context, filename string, data []byte) (string, error) {
+	client, err := newOpenAIClient(0)
+	if err != nil {
+		return "", err
+	}
+	text, err := client.Transcribe(ctx, &openai.TranscriptionRequest{
+		Model:    transcriptionModel(),
+		Filename: filename,
+		Audio:    data,
+		Language: "th",
+		Prompt:   transcriptionPrompt,
+	})
+	return text, openAIError(err)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"ncs-chatbot/line-webhook/thaidate"
+)
+
+// AvailabilityResult is one month of free appointment slots, whatever calendar backend it
+// came from. Days are in date order, past days and days without free slots are left out,
+// and slots are normalised to "HH:MM-HH:MM".
+type AvailabilityResult struct {
+	Month  string         `json:"month"`  // the Thai month-year that was requested, e.g. "ตุลาคม 2569"
+	Source string         `json:"source"` // the slotsParsers entry that read the response
+	Days   []AvailableDay `json:"days"`
+}
+
+// AvailableDay is the free time slots of one day.
+type AvailableDay struct {
+	Date  time.Time `json:"date"`
+	Slots []string  `json:"slots"`
+}
+
+// slotsParsers read a scheduling backend response into free slots per date. They report
+// false when the body is not in their format. A branch picks one with slots_format;
+// SLOTS_FORMAT sets the default.
+var slotsParsers = map[string]func(body string) (map[time.Time][]string, bool){
+	"apps_script": parseAppsScriptSlots,
+	"sheets":      parseSheetsSlots,
+	"calendar":    parseCalendarSlots,
+}
+
+// branchSlotsFormat returns the response format of a branch calendar; the zero Branch uses
+// the default calendar.
+func branchSlotsFormat(b Branch) string {
+	if b.SlotsFormat != "" {
+		return b.SlotsFormat
+	}
+	return appConfig.SlotsFormat
+}
+
+// errEmptySlotData is an empty scheduling response, which usually means the month's sheet
+// has not been created yet.
+var errEmptySlotData = errors.New("empty slot data")
+
+// postSchedulingAction posts a write ({"action": ...}) to a branch's Apps Script calendar and
+// returns the response body. The zero Branch is the default calendar.
+func postSchedulingAction(b Branch, payload map[string]interface{}) ([]byte, error) {
+	base := appConfig.SlotsURL
+	if b.SlotsURL != "" {
+		base = b.SlotsURL
+	}
+	data, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(base, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, classifyRequestError("scheduling", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &UpstreamError{Service: "scheduling", StatusCode: resp.StatusCode, Err: errors.New(string(body))}
+	}
+	return body, nil
+}
+
+// thaiMonthYear names the month of t as the scheduling sheet does, e.g. "ตุลาคม 2569".
+func thaiMonthYear(t time.Time) string {
+	return thaidate.MonthKey(t)
+}
+
+// slotMonth reads a month as the customer put it ("เดือนหน้า", "ต.ค. 69", "ต้นเดือนหน้า") as
+// the name of its sheet. A month it can't read is passed on as is.
+func slotMonth(month string) string {
+	if key, ok := thaidate.NormalizeMonth(month, bangkokNow()); ok {
+		return key
+	}
+	appMetrics.inc("slot_months_unparsed")
+	log.Printf("Could not read month %q, asking the calendar for it as is", month)
+	return month
+}
+
+// parseAvailability reads a provider's response with the given format's parser. A body the
+// parser doesn't recognise is a *SlotFormatError.
+func parseAvailability(provider, body, format, thaiMonthYear string) (AvailabilityResult, error) {
+	parse, ok := slotsParsers[format]
+	if !ok {
+		return AvailabilityResult{}, fmt.Errorf("unknown slots format %q", format)
+	}
+	byDate, ok := parse(body)
+	if !ok {
+		appMetrics.inc("slot_invalid_responses")
+		return AvailabilityResult{}, &SlotFormatError{Provider: provider, Format: format, Body: body}
+	}
+	return newAvailabilityResult(thaiMonthYear, format, byDate), nil
+}
+
+// newAvailabilityResult drops past and empty days, normalises slots and sorts by date.
+func newAvailabilityResult(thaiMonthYear, source string, byDate map[time.Time][]string) AvailabilityResult {
+	today := bangkokNow()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	result := AvailabilityResult{Month: thaiMonthYear, Source: source, Days: []AvailableDay{}}
+	for d, slots := range byDate {
+		if d.Before(today) || len(slots) == 0 {
+			continue
+		}
+		result.Days = append(result.Days, AvailableDay{Date: d, Slots: uniqueSortedSlots(slots)})
+	}
+	sort.Slice(result.Days, func(i, j int) bool { return result.Days[i].Date.Before(result.Days[j].Date) })
+	return result
+}
+
+// parseSheetsSlots reads a Google Sheets API values response: rows of a date followed by
+// cells holding times or "time: status". Header and other rows without a date are skipped.
+func parseSheetsSlots(body string) (map[time.Time][]string, bool) {
+	var resp struct {
+		Values [][]interface{} `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Values == nil {
+		return nil, false
+	}
+	byDate := map[time.Time][]string{}
+	recognised := false
+	for _, row := range resp.Values {
+		if len(row) == 0 {
+			continue
+		}
+		cell, _ := row[0].(string)
+		d, ok := parseSlotDate(cell)
+		if !ok {
+			continue
+		}
+		recognised = true
+		for _, v := range row[1:] {
+			byDate[d] = append(byDate[d], slotStrings(v)...)
+		}
+	}
+	return byDate, recognised
+}
+
+// parseCalendarSlots reads a Google Calendar events list where each event is an open slot.
+// Events whose title marks them full (e.g. "เต็ม") or that are cancelled are skipped.
+func parseCalendarSlots(body string) (map[time.Time][]string, bool) {
+	type eventTime struct {
+		DateTime string `json:"dateTime"`
+	}
+	var resp struct {
+		Kind  string `json:"kind"`
+		Items []struct {
+			Summary string    `json:"summary"`
+			Status  string    `json:"status"`
+			Start   eventTime `json:"start"`
+			End     eventTime `json:"end"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Kind != "calendar#events" {
+		return nil, false
+	}
+	loc := bangkokNow().Location()
+	byDate := map[time.Time][]string{}
+	for _, ev := range resp.Items {
+		if ev.Status == "cancelled" || slotIsFull(ev.Summary) {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, ev.Start.DateTime)
+		if err != nil {
+			continue // all-day events are not slots
+		}
+		end, err := time.Parse(time.RFC3339, ev.End.DateTime)
+		if err != nil {
+			continue
+		}
+		start, end = start.In(loc), end.In(loc)
+		d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		byDate[d] = append(byDate[d], start.Format("15:04")+"-"+end.Format("15:04"))
+	}
+	return byDate, true
+}
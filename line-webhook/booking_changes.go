@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// BookingChange is one audit entry for a field changed after the booking was made.
+type BookingChange struct {
+	At    time.Time `json:"at"`
+	By    string    `json:"by"` // "customer" (in chat) or "staff"
+	Field string    `json:"field"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+}
+
+// bookingChangeFields are the audited fields, with their Thai labels.
+var bookingChangeFields = map[string]string{
+	"address":       "ที่อยู่",
+	"contact_phone": "เบอร์ติดต่อ",
+	"date":          "วันที่",
+	"time_slot":     "เวลา",
+	"status":        "สถานะ",
+}
+
+// recordBookingChanges appends audit entries for changed fields. Caller must hold bookingLock.
+func recordBookingChanges(b *Booking, by string, before Booking) []BookingChange {
+	var changes []BookingChange
+	now := time.Now()
+	if b.Address != before.Address {
+		changes = append(changes, BookingChange{At: now, By: by, Field: "address", From: before.Address, To: b.Address})
+	}
+	if b.ContactPhone != before.ContactPhone {
+		changes = append(changes, BookingChange{At: now, By: by, Field: "contact_phone", From: before.ContactPhone, To: b.ContactPhone})
+	}
+	if b.Date != before.Date {
+		changes = append(changes, BookingChange{At: now, By: by, Field: "date", From: before.Date, To: b.Date})
+	}
+	if b.TimeSlot != before.TimeSlot {
+		changes = append(changes, BookingChange{At: now, By: by, Field: "time_slot", From: before.TimeSlot, To: b.TimeSlot})
+	}
+	if b.Status != before.Status {
+		changes = append(changes, BookingChange{At: now, By: by, Field: "status", From: before.Status, To: b.Status})
+	}
+	b.Changes = append(b.Changes, changes...)
+	return changes
+}
+
+// notifyBookingChanges tells the branch team that will do the job what changed, followed by
+// note when the team has something to do about it.
+func notifyBookingChanges(b Booking, changes []BookingChange, note string) {
+	if len(changes) == 0 {
+		return
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "✏️ ลูกค้าแก้ไขคิว %s (%s", b.ID, formatThaiDate(b.Date))
+	if b.TimeSlot != "" {
+		fmt.Fprintf(&msg, " %s", b.TimeSlot)
+	}
+	msg.WriteString(")")
+	for _, c := range changes {
+		from, to := c.From, c.To
+		if c.Field == "date" {
+			from, to = formatThaiDate(from), formatThaiDate(to)
+		}
+		fmt.Fprintf(&msg, "\n%s: %s → %s", bookingChangeFields[c.Field], orDash(from), to)
+	}
+	if note != "" {
+		msg.WriteString("\n" + note)
+	}
+	for _, id := range branchStaffRecipients(b.UserID) {
+		if err := pushLineMessageWithPriority(id, msg.String(), pushTransactional, "booking_change"); err != nil {
+			log.Printf("Failed to notify %s about booking %s change: %v", id, b.ID, err)
+		}
+	}
+	emitOutboundEvent("booking.updated", b.UserID, b)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// bookingForChange finds the user's confirmed booking that can still be changed; with no ID
+// it picks the only upcoming one.
+func bookingForChange(userId, bookingID string) (*Booking, string) {
+	today := bangkokNow().Format("2006-01-02")
+	var candidates []*Booking
+	for _, b := range bookings {
+		if b.UserID == userId && b.Status == "confirmed" && b.Date >= today && (bookingID == "" || b.ID == bookingID) {
+			candidates = append(candidates, b)
+		}
+	}
+	switch {
+	case len(candidates) == 0:
+		return nil, "ไม่พบคิวที่ยืนยันแล้วของลูกค้าที่ยังไม่ถึงวันนัด ใช้ get_my_booking เพื่อดูเลขคิว"
+	case len(candidates) > 1:
+		return nil, "ลูกค้ามีหลายคิว ถามลูกค้าว่าต้องการแก้คิวไหน แล้วส่ง booking_id จาก get_my_booking"
+	case candidates[0].Date == today:
+		return nil, "วันนี้เป็นวันนัดแล้ว แก้ไขผ่านแชทไม่ได้ แจ้งลูกค้าว่าเจ้าหน้าที่จะติดต่อกลับเพื่อประสานกับทีมงาน"
+	}
+	return candidates[0], ""
+}
+
+// validateBookingDetails normalises the requested address and phone.
+func validateBookingDetails(address, phone string) (string, string, string) {
+	address = strings.Join(strings.Fields(address), " ")
+	if address == "" && strings.TrimSpace(phone) == "" {
+		return "", "", "ระบุที่อยู่ใหม่หรือเบอร์ติดต่อใหม่อย่างน้อยหนึ่งอย่าง"
+	}
+	if address != "" && (utf8.RuneCountInString(address) < 10 || utf8.RuneCountInString(address) > 300) {
+		return "", "", "ที่อยู่ไม่ครบถ้วน ขอบ้านเลขที่ ถนน/ซอย แขวง/ตำบล เขต/อำเภอ และจังหวัดจากลูกค้า"
+	}
+	normalizedPhone := ""
+	if strings.TrimSpace(phone) != "" {
+		if normalizedPhone = extractThaiPhone(phone); normalizedPhone == "" {
+			return "", "", "เบอร์โทรไม่ถูกต้อง ขอเบอร์ 9-10 หลักจากลูกค้าอีกครั้ง"
+		}
+	}
+	return address, normalizedPhone, ""
+}
+
+func updateBookingDetailsSummary(args map[string]interface{}) string {
+	address, _ := args["address"].(string)
+	phone, _ := args["contact_phone"].(string)
+	bookingID, _ := args["booking_id"].(string)
+	var b strings.Builder
+	b.WriteString("แก้ไขคิว")
+	if bookingID != "" {
+		b.WriteString(" " + bookingID)
+	}
+	if address != "" {
+		b.WriteString("\n📍 ที่อยู่ใหม่: " + address)
+	}
+	if phone != "" {
+		b.WriteString("\n📞 เบอร์ติดต่อใหม่: " + phone)
+	}
+	return b.String()
+}
+
+// updateBookingDetails handles the confirmed update_booking_details tool: the customer changes
+// the address or contact phone of an upcoming booking, and the branch team is told.
+func updateBookingDetails(userId, bookingID, address, phone string) (string, error) {
+	address, phone, problem := validateBookingDetails(address, phone)
+	if problem != "" {
+		return problem, &ToolError{Tool: "update_booking_details", Err: fmt.Errorf("invalid details")}
+	}
+
+	bookingLock.Lock()
+	booking, problem := bookingForChange(userId, bookingID)
+	if booking == nil {
+		bookingLock.Unlock()
+		return problem, &ToolError{Tool: "update_booking_details", Err: fmt.Errorf("booking %q not changeable", bookingID)}
+	}
+	before := *booking
+	if address != "" {
+		booking.Address = address
+	}
+	if phone != "" {
+		booking.ContactPhone = phone
+	}
+	changes := recordBookingChanges(booking, "customer", before)
+	if len(changes) > 0 {
+		booking.UpdatedAt = time.Now()
+	}
+	result := *booking
+	bookingLock.Unlock()
+
+	if len(changes) == 0 {
+		return "ข้อมูลที่ลูกค้าให้ตรงกับในคิวอยู่แล้ว ไม่มีการเปลี่ยนแปลง", nil
+	}
+	go saveBookings()
+	notifyBookingChanges(result, changes, "")
+	log.Printf("User %s changed %d field(s) of booking %s", userId, len(changes), result.ID)
+	appMetrics.inc("booking_self_service_changes")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "แก้ไขคิว %s เรียบร้อยแล้ว และแจ้งทีมงานแล้ว", result.ID)
+	if address != "" {
+		b.WriteString("\n📍 ที่อยู่: " + result.Address)
+		if br, ok := branchForText(normalizeInboundText(address)); ok {
+			if current, ok := customerBranch(userId); ok && current.ID != br.ID {
+				b.WriteString("\nที่อยู่ใหม่อยู่ในพื้นที่สาขาอื่น แจ้งลูกค้าว่าเจ้าหน้าที่จะตรวจสอบคิวและราคาอีกครั้ง")
+			}
+		}
+	}
+	if phone != "" {
+		b.WriteString("\n📞 เบอร์ติดต่อ: " + result.ContactPhone)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// The create_booking tool turns an agreed quote and a free slot into a confirmed booking.
+// The slot is checked against the branch calendar and, for apps_script calendars, reserved
+// there with {"action": "book_slot", ...}; the script answers {"ok": true} or
+// {"ok": false, "error": "..."} when the slot has just been taken. Other calendar formats are
+// read-only, so staff are asked to enter the booking themselves.
+
+// ChatBookingItem is one line of a create_booking call, priced as quoted to the customer.
+type ChatBookingItem struct {
+	ServiceType  string `json:"service_type"`
+	ItemType     string `json:"item_type"`
+	Size         string `json:"size,omitempty"`
+	Quantity     int    `json:"quantity"`
+	Price        int    `json:"price"`                   // line total in baht
+	CustomerType string `json:"customer_type,omitempty"` // as passed to get_ncs_pricing
+	PromoCode    string `json:"promo_code,omitempty"`
+}
+
+// chatBookingItems resolves the tool's item names to pricing keys and checks every line
+// against the price list the customer was quoted from: the branch's prices, the verified
+// customer type and any running promotion. A line whose price is not one of the quoted
+// amounts times its quantity is rejected, so the model can't book a price it made up.
+func chatBookingItems(engine *pricing.Engine, userId string, items []ChatBookingItem) ([]BookingItem, int, error) {
+	if len(items) == 0 {
+		return nil, 0, fmt.Errorf("at least one item is required")
+	}
+	if engine.Config == nil {
+		return nil, 0, fmt.Errorf("pricing is not loaded")
+	}
+	var result []BookingItem
+	total := 0
+	for _, it := range items {
+		serviceKey := engine.ServiceKey(it.ServiceType)
+		if serviceKey == "" {
+			return nil, 0, fmt.Errorf("unknown service '%s'", it.ServiceType)
+		}
+		itemKey := engine.ItemKey(it.ItemType)
+		if itemKey == "" {
+			return nil, 0, fmt.Errorf("unknown item '%s'", it.ItemType)
+		}
+		qty := it.Quantity
+		if qty <= 0 {
+			qty = 1
+		}
+		customerType, _ := verifiedCustomerType(userId, it.CustomerType)
+		quote, ok := engine.QuoteItem(pricing.QuoteRequest{ServiceType: it.ServiceType, ItemType: it.ItemType, Size: it.Size,
+			CustomerType: customerType, PromoCode: it.PromoCode, Today: bangkokNow().Format("2006-01-02")})
+		if !ok {
+			return nil, 0, fmt.Errorf("no single price for '%s' size '%s'; call get_ncs_pricing with the size first", it.ItemType, it.Size)
+		}
+		if !matchesQuote(quote, qty, it.Price) {
+			return nil, 0, fmt.Errorf("price %d for %s %s x%d does not match the price list; quote it again with get_ncs_pricing (%s)",
+				it.Price, quote.Item, quote.Size, qty, quotedLineTotals(quote, qty))
+		}
+		sizeKey := engine.SizeKey(it.Size, engine.Config.Items[itemKey].Sizes)
+		if sizeKey == "" {
+			sizeKey = engine.ExtractSize(it.ItemType + " " + it.Size).SizeKey
+		}
+		result = append(result, BookingItem{ServiceKey: serviceKey, ItemKey: itemKey, SizeKey: sizeKey, Quantity: qty, Price: it.Price})
+		total += it.Price
+	}
+	return result, total, nil
+}
+
+// quotedUnitPrices are the per-item amounts a quote offers: each price tier and the
+// promotion price.
+func quotedUnitPrices(quote pricing.ItemQuote) []int {
+	var units []int
+	for _, n := range []int{quote.Price.FullPrice, quote.Price.Discount35, quote.Price.Discount50, quote.PromoPrice} {
+		if n > 0 {
+			units = append(units, n)
+		}
+	}
+	return units
+}
+
+// matchesQuote reports whether price is qty times one of the quoted amounts.
+func matchesQuote(quote pricing.ItemQuote, qty, price int) bool {
+	for _, unit := range quotedUnitPrices(quote) {
+		if unit*qty == price {
+			return true
+		}
+	}
+	return false
+}
+
+// quotedLineTotals lists the line totals the model may book, e.g. "2,400 or 1,200 baht".
+func quotedLineTotals(quote pricing.ItemQuote, qty int) string {
+	var totals []string
+	for _, unit := range quotedUnitPrices(quote) {
+		totals = append(totals, pricing.FormatNumber(unit*qty))
+	}
+	return strings.Join(totals, " or ") + " baht"
+}
+
+func createBookingSummary(args map[string]interface{}) string {
+	date, _ := args["date"].(string)
+	slot, _ := args["time_slot"].(string)
+	address, _ := args["address"].(string)
+	deposit, _ := args["deposit_amount"].(float64)
+	var b strings.Builder
+	fmt.Fprintf(&b, "จองคิววันที่ %s เวลา %s", formatThaiDate(bookingDate(date)), slot)
+	total := 0
+	if items, ok := args["items"].([]interface{}); ok {
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			service, _ := item["service_type"].(string)
+			name, _ := item["item_type"].(string)
+			size, _ := item["size"].(string)
+			qty, _ := item["quantity"].(float64)
+			price, _ := item["price"].(float64)
+			if qty == 0 {
+				qty = 1
+			}
+			fmt.Fprintf(&b, "\n• %s %s %s x%d = %s บาท", service, name, size, int(qty), pricing.FormatNumber(int(price)))
+			total += int(price)
+		}
+	}
+	fmt.Fprintf(&b, "\n💰 ยอดรวม %s บาท มัดจำ %s บาท", pricing.FormatNumber(total), pricing.FormatNumber(int(deposit)))
+	if address != "" {
+		b.WriteString("\n📍 " + address)
+	}
+	return b.String()
+}
+
+// slotStillFree reports whether the branch calendar lists timeSlot as free on date.
+func slotStillFree(userId, date, timeSlot string) (bool, error) {
+	day, _ := time.Parse("2006-01-02", date)
+	month := thaiMonthYear(day)
+	b, _ := customerBranch(userId)
+	availability, err := branchSlotProvider(b).MonthSlots(context.Background(), month)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range availability.Days {
+		if d.Date.Format("2006-01-02") != date {
+			continue
+		}
+		for _, s := range d.Slots {
+			if s == timeSlot {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// reserveSlot books the slot in the branch calendar: in the scheduling sheet, held until the
+// deposit is paid, or through an apps_script calendar. It reports false when the calendar
+// can't be written, or the script didn't answer {"ok": true}, and staff must enter the booking.
+func reserveSlot(b Branch, booking *Booking) (bool, error) {
+	defer invalidateSlotMonth(b, bookingMonth(booking.Date))
+	if w := branchSlotWriter(b); w != nil {
+		err := w.HoldSlot(context.Background(), booking.Date, booking.TimeSlot, booking.ID, booking.DepositStatus == "pending")
+		return err == nil, err
+	}
+	if branchSlotsFormat(b) != "apps_script" {
+		return false, nil
+	}
+	day, _ := time.Parse("2006-01-02", booking.Date)
+	body, err := postSchedulingAction(b, map[string]interface{}{
+		"action":     "book_slot",
+		"sheet":      thaiMonthYear(day),
+		"date":       booking.Date,
+		"time_slot":  booking.TimeSlot,
+		"booking_id": booking.ID,
+		"address":    booking.Address,
+		"items":      len(booking.Items),
+	})
+	if err != nil {
+		return false, err
+	}
+	var reply struct {
+		OK    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil || reply.OK == nil {
+		// without an explicit answer the slot may not be held, so staff must check it
+		log.Printf("Unexpected book_slot reply for %s: %s", booking.ID, truncateRunes(string(body), 200))
+		return false, nil
+	}
+	if !*reply.OK {
+		return false, fmt.Errorf("%w: %s", errSlotTaken, reply.Error)
+	}
+	return true, nil
+}
+
+var errSlotTaken = errors.New("slot is no longer free")
+
+// bookableSlot checks a booking tool's date and time slot, and that the slot is still free in
+// the customer's branch calendar. It returns the slot as the calendar writes it, or the
+// message for the model with the error.
+func bookableSlot(tool, userId, date, timeSlot string) (string, string, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil || date < bangkokNow().Format("2006-01-02") {
+		return "", "วันที่ต้องเป็นรูปแบบ YYYY-MM-DD และไม่ใช่วันที่ผ่านมาแล้ว", &ToolError{Tool: tool, Err: fmt.Errorf("invalid date %q", date)}
+	}
+	found := slotTimePattern.FindString(convertThaiDigits(timeSlot))
+	if found == "" {
+		return "", "time_slot ต้องเป็นช่วงเวลาจาก get_available_slots_with_months เช่น 09:00-12:00", &ToolError{Tool: tool, Err: fmt.Errorf("invalid time slot %q", timeSlot)}
+	}
+	timeSlot = uniqueSortedSlots([]string{found})[0]
+	free, err := slotStillFree(userId, date, timeSlot)
+	if err != nil {
+		return "", flagSchedulingFallback(userId), err
+	}
+	if !free {
+		return "", "ช่วงเวลานี้ไม่ว่างแล้ว ให้เรียก get_available_slots_with_months อีกครั้งแล้วเสนอเวลาอื่นให้ลูกค้า", &ToolError{Tool: tool, Err: errSlotTaken}
+	}
+	return timeSlot, "", nil
+}
+
+// holdBookingSlot reserves the booking's slot in the customer's branch calendar. reserved is
+// false when the calendar can't be written and staff must enter the booking; on error the
+// message tells the model what to do instead.
+func holdBookingSlot(tool, userId string, booking *Booking) (reserved bool, msg string, err error) {
+	branch, _ := customerBranch(userId)
+	reserved, err = reserveSlot(branch, booking)
+	if errors.Is(err, errSlotTaken) {
+		return false, "ช่วงเวลานี้เพิ่งถูกจองไป ให้เรียก get_available_slots_with_months อีกครั้งแล้วเสนอเวลาอื่นให้ลูกค้า", &ToolError{Tool: tool, Err: err}
+	}
+	if err != nil {
+		return false, flagSchedulingFallback(userId), err
+	}
+	return reserved, "", nil
+}
+
+// createBooking handles the confirmed create_booking tool: it checks the slot is still free,
+// reserves it in the calendar and records the booking with its deposit pending. Simulated users
+// stop after the slot check.
+func createBooking(userId, date, timeSlot, address string, items []ChatBookingItem, depositAmount int) (string, error) {
+	toolErr := func(msg string, err error) (string, error) {
+		return msg, &ToolError{Tool: "create_booking", Err: err}
+	}
+	bookingItems, total, err := chatBookingItems(pricingEngineFor(userId), userId, items)
+	if err != nil {
+		return toolErr("ข้อมูลรายการไม่ถูกต้อง: "+err.Error(), err)
+	}
+	if depositAmount < 0 || depositAmount > total {
+		return toolErr(fmt.Sprintf("มัดจำต้องอยู่ระหว่าง 0 ถึงยอดรวม %s บาท", pricing.FormatNumber(total)), fmt.Errorf("deposit %d outside 0..%d", depositAmount, total))
+	}
+	timeSlot, msg, err := bookableSlot("create_booking", userId, date, timeSlot)
+	if err != nil {
+		return msg, err
+	}
+
+	if isSimulatedUser(userId) {
+		// everything below reserves, stores or announces the booking
+		return fmt.Sprintf("[simulation] จองคิววันที่ %s เวลา %s เรียบร้อย ยอดรวม %s บาท มัดจำ %s บาท (บทสนทนาจำลอง ไม่มีการลงคิวจริง)",
+			formatThaiDate(date), timeSlot, pricing.FormatNumber(total), pricing.FormatNumber(depositAmount)), nil
+	}
+
+	now := time.Now()
+	depositStatus := "pending"
+	if depositAmount == 0 {
+		depositStatus = "waived"
+	}
+	booking := &Booking{
+		ID:            fmt.Sprintf("bk_%d", now.UnixNano()),
+		UserID:        userId,
+		Date:          date,
+		TimeSlot:      timeSlot,
+		Address:       strings.TrimSpace(address),
+		Items:         bookingItems,
+		Total:         total,
+		DepositAmount: depositAmount,
+		DepositStatus: depositStatus,
+		Status:        "confirmed",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	reserved, msg, err := holdBookingSlot("create_booking", userId, booking)
+	if err != nil {
+		return msg, err
+	}
+
+	bookingLock.Lock()
+	bookings = append(bookings, booking)
+	bookingLock.Unlock()
+	go saveBookings()
+	applyBookingToProfile(booking, "")
+	emitOutboundEvent("booking.created", userId, *booking)
+	appMetrics.inc("chat_bookings_created")
+	log.Printf("Created booking %s for user %s on %s %s", booking.ID, userId, date, timeSlot)
+
+	alert := fmt.Sprintf("📅 คิวใหม่จากแชท %s\n%s\n%s", booking.ID, createBookingAlertLine(booking), booking.Address)
+	if !reserved {
+		alert += "\n⚠️ ปฏิทินนี้ลงคิวอัตโนมัติไม่ได้ กรุณาลงคิวในปฏิทินด้วย"
+	}
+	go alertStaff(userId, alert, "booking")
+
+	result := fmt.Sprintf("จองคิวเลขที่ %s วันที่ %s เวลา %s เรียบร้อย ยอดรวม %s บาท",
+		booking.ID, formatThaiDate(date), timeSlot, pricing.FormatNumber(total))
+	if depositAmount > 0 {
+		payment := createPayment(userId, "deposit", booking.ID, depositAmount)
+		result += fmt.Sprintf(" มัดจำ %s บาท (ยังไม่ได้ชำระ) แจ้งเลขคิวให้ลูกค้าพร้อมวิธีชำระมัดจำ:\n%s", pricing.FormatNumber(depositAmount), paymentInstructions(payment))
+	} else {
+		result += " ไม่ต้องชำระมัดจำ แจ้งเลขคิวให้ลูกค้า"
+	}
+	return result, nil
+}
+
+func createBookingAlertLine(b *Booking) string {
+	return fmt.Sprintf("%s %s: %s (%s บาท, มัดจำ %s บาท)", formatThaiDate(b.Date), b.TimeSlot,
+		bookingItemNames(b), pricing.FormatNumber(b.Total), pricing.FormatNumber(b.DepositAmount))
+}
+
+// bookingItemNames lists the booking's items for staff, e.g. "ที่นอน 6 ฟุต x1, โซฟา 3 ที่นั่ง x2".
+func bookingItemNames(b *Booking) string {
+	names := make([]string, 0, len(b.Items))
+	for _, item := range b.Items {
+		names = append(names, fmt.Sprintf("%s x%d", itemDisplayName(item), item.Quantity))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+func TestChatBookingItemsChecksQuotedPrice(t *testing.T) {
+	if err := loadPricingConfig(); err != nil {
+		t.Fatal(err)
+	}
+	engine := pricingEngine()
+	quote, ok := engine.QuoteItem(pricing.QuoteRequest{ServiceType: "washing", ItemType: "curtain", Size: "sqm", Today: bangkokNow().Format("2006-01-02")})
+	if !ok {
+		t.Fatal("no quote for washing a curtain per sqm")
+	}
+	unit := quote.Price.BestPrice()
+	item := func(qty, price int) ChatBookingItem {
+		return ChatBookingItem{ServiceType: "washing", ItemType: "ม่าน", Size: "ตร.ม.", Quantity: qty, Price: price}
+	}
+
+	tests := []struct {
+		name      string
+		items     []ChatBookingItem
+		wantTotal int
+		wantErr   string
+	}{
+		{"quoted price", []ChatBookingItem{item(1, unit)}, unit, ""},
+		{"line total for the quantity", []ChatBookingItem{item(4, 4*unit)}, 4 * unit, ""},
+		{"two lines", []ChatBookingItem{item(1, unit), item(2, 2*unit)}, 3 * unit, ""},
+		{"unit price with a quantity", []ChatBookingItem{item(3, unit)}, 0, "does not match the price list"},
+		{"made-up price", []ChatBookingItem{item(1, unit-1)}, 0, "does not match the price list"},
+		{"no price", []ChatBookingItem{item(1, 0)}, 0, "does not match the price list"},
+		{"no size", []ChatBookingItem{{ServiceType: "washing", ItemType: "sofa", Price: unit}}, 0, "call get_ncs_pricing with the size"},
+		{"unknown item", []ChatBookingItem{{ServiceType: "washing", ItemType: "car", Price: unit}}, 0, "unknown item"},
+		{"no items", nil, 0, "at least one item"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := chatBookingItems(engine, "test-user", tt.items)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("chatBookingItems() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("chatBookingItems() error: %v", err)
+			}
+			if total != tt.wantTotal || len(got) != len(tt.items) {
+				t.Errorf("chatBookingItems() = %d item(s), total %d, want %d, %d", len(got), total, len(tt.items), tt.wantTotal)
+			}
+			for _, b := range got {
+				if b.ServiceKey != "washing" || b.ItemKey != "curtain" || b.SizeKey != "per_sqm" {
+					t.Errorf("chatBookingItems() item = %+v, want washing/curtain/per_sqm", b)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Customers can cancel or move an upcoming booking in chat with the cancel_booking and
+// reschedule_booking tools, as long as the visit starts at least bookingChangeNotice from now;
+// later changes go to staff. For apps_script calendars a move first reserves the new slot with
+// book_slot and then frees the old one with {"action": "cancel_slot", "sheet", "date",
+// "time_slot", "booking_id"}, so a move that fails leaves the booking where it was. Other
+// calendar formats are read-only, and the team is asked to update the calendar.
+
+const bookingChangeNotice = 24 * time.Hour
+
+// bookingStartsAt is when the visit starts: the start of its time slot, or the start of the
+// day when the slot has no time.
+func bookingStartsAt(date, timeSlot string) (time.Time, error) {
+	loc := bangkokNow().Location()
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if found := slotTimePattern.FindString(convertThaiDigits(timeSlot)); found != "" {
+		start, _, _ := strings.Cut(uniqueSortedSlots([]string{found})[0], "-")
+		if t, err := time.ParseInLocation("2006-01-02 15:04", date+" "+start, loc); err == nil {
+			return t, nil
+		}
+	}
+	return day, nil
+}
+
+// hasBookingNotice reports whether a visit on date and timeSlot is far enough ahead to be
+// changed in chat.
+func hasBookingNotice(date, timeSlot string) bool {
+	start, err := bookingStartsAt(date, timeSlot)
+	return err == nil && start.Sub(bangkokNow()) >= bookingChangeNotice
+}
+
+const bookingNoticeProblem = "คิวนี้เหลือเวลาไม่ถึง 24 ชั่วโมงก่อนเริ่มงาน ยกเลิกหรือเลื่อนผ่านแชทไม่ได้ แจ้งลูกค้าว่าเจ้าหน้าที่จะติดต่อกลับเพื่อประสานกับทีมงาน"
+
+// releaseSlot frees the booking's slot in the scheduling sheet or an apps_script calendar. It
+// reports false when the calendar can't be written and staff must update it.
+func releaseSlot(b Branch, booking Booking) (bool, error) {
+	defer invalidateSlotMonth(b, bookingMonth(booking.Date))
+	if w := branchSlotWriter(b); w != nil {
+		err := w.ReleaseSlot(context.Background(), booking.Date, booking.TimeSlot, booking.ID)
+		return err == nil, err
+	}
+	if branchSlotsFormat(b) != "apps_script" {
+		return false, nil
+	}
+	day, _ := time.Parse("2006-01-02", booking.Date)
+	body, err := postSchedulingAction(b, map[string]interface{}{
+		"action":     "cancel_slot",
+		"sheet":      thaiMonthYear(day),
+		"date":       booking.Date,
+		"time_slot":  booking.TimeSlot,
+		"booking_id": booking.ID,
+	})
+	if err != nil {
+		return false, err
+	}
+	var reply struct {
+		OK    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.OK != nil && !*reply.OK {
+		return false, fmt.Errorf("scheduling script refused cancel_slot: %s", reply.Error)
+	}
+	return true, nil
+}
+
+func cancelBookingSummary(args map[string]interface{}) string {
+	bookingID, _ := args["booking_id"].(string)
+	reason, _ := args["reason"].(string)
+	var b strings.Builder
+	b.WriteString("ยกเลิกคิว")
+	if bookingID != "" {
+		b.WriteString(" " + bookingID)
+	}
+	if reason != "" {
+		b.WriteString("\n📝 เหตุผล: " + reason)
+	}
+	return b.String()
+}
+
+func rescheduleBookingSummary(args map[string]interface{}) string {
+	bookingID, _ := args["booking_id"].(string)
+	date, _ := args["date"].(string)
+	slot, _ := args["time_slot"].(string)
+	var b strings.Builder
+	b.WriteString("เลื่อนคิว")
+	if bookingID != "" {
+		b.WriteString(" " + bookingID)
+	}
+	fmt.Fprintf(&b, "\n📅 วันใหม่: %s เวลา %s", formatThaiDate(bookingDate(date)), slot)
+	return b.String()
+}
+
+// cancelBooking handles the confirmed cancel_booking tool: the booking is cancelled, its slot
+// freed, an unpaid deposit request withdrawn and the branch team told. Refunds of paid
+// deposits are left to staff.
+func cancelBooking(userId, bookingID, reason string) (string, error) {
+	toolErr := func(msg string, err error) (string, error) {
+		return msg, &ToolError{Tool: "cancel_booking", Err: err}
+	}
+	reason = strings.Join(strings.Fields(reason), " ")
+
+	bookingLock.Lock()
+	booking, problem := bookingForChange(userId, bookingID)
+	if booking == nil {
+		bookingLock.Unlock()
+		return toolErr(problem, fmt.Errorf("booking %q not changeable", bookingID))
+	}
+	if !hasBookingNotice(booking.Date, booking.TimeSlot) {
+		bookingLock.Unlock()
+		return toolErr(bookingNoticeProblem, fmt.Errorf("booking %s starts within %s", booking.ID, bookingChangeNotice))
+	}
+	before := *booking
+	booking.Status = "cancelled"
+	if reason != "" {
+		booking.Notes = strings.TrimSpace(booking.Notes + "\nลูกค้ายกเลิก: " + reason)
+	}
+	changes := recordBookingChanges(booking, "customer", before)
+	booking.UpdatedAt = time.Now()
+	result := *booking
+	bookingLock.Unlock()
+
+	go saveBookings()
+	applyBookingToProfile(&result, before.Status)
+	if result.ContractID != "" {
+		releaseContractUsage(result.ContractID, result.ID)
+	}
+	depositWithdrawn := cancelPendingPayment("deposit", result.ID)
+
+	var notes []string
+	if reason != "" {
+		notes = append(notes, "เหตุผล: "+reason)
+	}
+	branch, _ := customerBranch(userId)
+	if released, err := releaseSlot(branch, result); err != nil {
+		log.Printf("Failed to free the slot of cancelled booking %s: %v", result.ID, err)
+		notes = append(notes, "⚠️ คืนช่วงเวลาในปฏิทินอัตโนมัติไม่สำเร็จ กรุณาลบคิวในปฏิทินด้วย")
+	} else if !released {
+		notes = append(notes, "⚠️ ปฏิทินนี้แก้ไขอัตโนมัติไม่ได้ กรุณาลบคิวในปฏิทินด้วย")
+	}
+	if result.DepositStatus == "paid" && result.DepositAmount > 0 {
+		notes = append(notes, fmt.Sprintf("💰 ลูกค้าชำระมัดจำแล้ว %s บาท กรุณาติดต่อลูกค้าเรื่องการคืนเงิน", pricing.FormatNumber(result.DepositAmount)))
+	}
+	notifyBookingChanges(result, changes, strings.Join(notes, "\n"))
+	appMetrics.inc("chat_bookings_cancelled")
+	log.Printf("User %s cancelled booking %s", userId, result.ID)
+
+	msg := fmt.Sprintf("ยกเลิกคิว %s วันที่ %s เรียบร้อยแล้ว และแจ้งทีมงานแล้ว", result.ID, formatThaiDate(result.Date))
+	switch {
+	case result.DepositStatus == "paid" && result.DepositAmount > 0:
+		msg += fmt.Sprintf(" ลูกค้าชำระมัดจำไว้ %s บาท แจ้งลูกค้าว่าเจ้าหน้าที่จะติดต่อเรื่องมัดจำ", pricing.FormatNumber(result.DepositAmount))
+	case depositWithdrawn:
+		msg += " ไม่ต้องชำระมัดจำของคิวนี้แล้ว"
+	}
+	return msg, nil
+}
+
+// rescheduleBooking handles the confirmed reschedule_booking tool: the new slot is checked and
+// reserved, the old one freed, and the booking moved.
+func rescheduleBooking(userId, bookingID, date, timeSlot string) (string, error) {
+	toolErr := func(msg string, err error) (string, error) {
+		return msg, &ToolError{Tool: "reschedule_booking", Err: err}
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil || date < bangkokNow().Format("2006-01-02") {
+		return toolErr("วันที่ต้องเป็นรูปแบบ YYYY-MM-DD และไม่ใช่วันที่ผ่านมาแล้ว", fmt.Errorf("invalid date %q", date))
+	}
+	found := slotTimePattern.FindString(convertThaiDigits(timeSlot))
+	if found == "" {
+		return toolErr("time_slot ต้องเป็นช่วงเวลาจาก get_available_slots_with_months เช่น 09:00-12:00", fmt.Errorf("invalid time slot %q", timeSlot))
+	}
+	timeSlot = uniqueSortedSlots([]string{found})[0]
+	if !hasBookingNotice(date, timeSlot) {
+		return toolErr("วันเวลาใหม่ต้องห่างจากตอนนี้อย่างน้อย 24 ชั่วโมง ให้เสนอวันเวลาอื่น หรือแจ้งว่าเจ้าหน้าที่จะติดต่อกลับหากลูกค้าต้องการคิวด่วน", fmt.Errorf("new slot %s %s within %s", date, timeSlot, bookingChangeNotice))
+	}
+
+	bookingLock.Lock()
+	booking, problem := bookingForChange(userId, bookingID)
+	if booking == nil {
+		bookingLock.Unlock()
+		return toolErr(problem, fmt.Errorf("booking %q not changeable", bookingID))
+	}
+	if !hasBookingNotice(booking.Date, booking.TimeSlot) {
+		bookingLock.Unlock()
+		return toolErr(bookingNoticeProblem, fmt.Errorf("booking %s starts within %s", booking.ID, bookingChangeNotice))
+	}
+	old := *booking
+	bookingLock.Unlock()
+
+	if old.Date == date && old.TimeSlot == timeSlot {
+		return "คิวนี้อยู่ในวันเวลานี้อยู่แล้ว ไม่มีการเปลี่ยนแปลง", nil
+	}
+	free, err := slotStillFree(userId, date, timeSlot)
+	if err != nil {
+		return flagSchedulingFallback(userId), err
+	}
+	if !free {
+		return toolErr("ช่วงเวลานี้ไม่ว่างแล้ว ให้เรียก get_available_slots_with_months อีกครั้งแล้วเสนอเวลาอื่นให้ลูกค้า", errSlotTaken)
+	}
+
+	branch, _ := customerBranch(userId)
+	moved := old
+	moved.Date, moved.TimeSlot = date, timeSlot
+	reserved, err := reserveSlot(branch, &moved)
+	if errors.Is(err, errSlotTaken) {
+		return toolErr("ช่วงเวลานี้เพิ่งถูกจองไป ให้เรียก get_available_slots_with_months อีกครั้งแล้วเสนอเวลาอื่นให้ลูกค้า", err)
+	}
+	if err != nil {
+		return flagSchedulingFallback(userId), err
+	}
+	var notes []string
+	if !reserved {
+		notes = append(notes, "⚠️ ปฏิทินนี้แก้ไขอัตโนมัติไม่ได้ กรุณาย้ายคิวในปฏิทินด้วย")
+	} else if _, err := releaseSlot(branch, old); err != nil {
+		log.Printf("Failed to free the old slot of rescheduled booking %s: %v", old.ID, err)
+		notes = append(notes, fmt.Sprintf("⚠️ คืนช่วงเวลาเดิม (%s %s) ในปฏิทินอัตโนมัติไม่สำเร็จ กรุณาลบในปฏิทินด้วย", formatThaiDate(old.Date), old.TimeSlot))
+	}
+
+	bookingLock.Lock()
+	if booking.Status != "confirmed" || booking.Date != old.Date || booking.TimeSlot != old.TimeSlot {
+		// staff changed the booking while the calendar was being updated
+		bookingLock.Unlock()
+		log.Printf("Booking %s changed during reschedule to %s %s; left for staff", old.ID, date, timeSlot)
+		go alertStaff(userId, fmt.Sprintf("⚠️ ลูกค้าขอเลื่อนคิว %s เป็น %s %s แต่คิวถูกแก้ไขระหว่างดำเนินการ กรุณาตรวจสอบคิวและปฏิทิน", old.ID, formatThaiDate(date), timeSlot), "booking_change")
+		return toolErr("คิวนี้เพิ่งถูกแก้ไขโดยเจ้าหน้าที่ แจ้งลูกค้าว่าเจ้าหน้าที่จะติดต่อกลับเพื่อยืนยันวันเวลา", fmt.Errorf("booking %s changed concurrently", old.ID))
+	}
+	booking.Date, booking.TimeSlot = date, timeSlot
+	changes := recordBookingChanges(booking, "customer", old)
+	booking.UpdatedAt = time.Now()
+	result := *booking
+	bookingLock.Unlock()
+
+	go saveBookings()
+	notifyBookingChanges(result, changes, strings.Join(notes, "\n"))
+	appMetrics.inc("chat_bookings_rescheduled")
+	log.Printf("User %s moved booking %s from %s %s to %s %s", userId, result.ID, old.Date, old.TimeSlot, date, timeSlot)
+
+	return fmt.Sprintf("เลื่อนคิว %s เป็นวันที่ %s เวลา %s เรียบร้อยแล้ว และแจ้งทีมงานแล้ว", result.ID, formatThaiDate(date), timeSlot), nil
+}
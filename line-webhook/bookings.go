@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+	"ncs-chatbot/line-webhook/thaidate"
+)
+
+// BookingItem is one thing to clean in a booking. Keys refer to pricing_config.json.
+type BookingItem struct {
+	ServiceKey string `json:"service_key"`
+	ItemKey    string `json:"item_key"`
+	SizeKey    string `json:"size_key,omitempty"`
+	Quantity   int    `json:"quantity"`
+	Price      int    `json:"price"` // total for this line in baht
+}
+
+// Booking is a scheduled service visit.
+type Booking struct {
+	ID              string          `json:"id"`
+	UserID          string          `json:"user_id"`
+	Date            string          `json:"date"`      // YYYY-MM-DD
+	TimeSlot        string          `json:"time_slot"` // e.g. "09:00-12:00"
+	Address         string          `json:"address,omitempty"`
+	ContactPhone    string          `json:"contact_phone,omitempty"` // phone the team calls on the day, if not the profile phone
+	Items           []BookingItem   `json:"items"`
+	Total           int             `json:"total"`
+	DepositAmount   int             `json:"deposit_amount"`
+	DepositStatus   string          `json:"deposit_status"` // "pending", "paid" or "waived"
+	Status          string          `json:"status"`         // "confirmed", "completed" or "cancelled"
+	VoucherCode     string          `json:"voucher_code,omitempty"`
+	VoucherDiscount int             `json:"voucher_discount,omitempty"` // baht deducted from Total by the gift voucher
+	ContractID      string          `json:"contract_id,omitempty"`      // set when the booking uses contract items
+	Notes           string          `json:"notes,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Changes         []BookingChange `json:"changes,omitempty"` // audit trail of changes after booking
+}
+
+var bookingsFile = "bookings.json"
+
+var (
+	bookingLock sync.Mutex
+	bookings    []*Booking
+)
+
+var (
+	validDepositStatuses = map[string]bool{"pending": true, "paid": true, "waived": true}
+	validBookingStatuses = map[string]bool{"confirmed": true, "completed": true, "cancelled": true}
+)
+
+// preparationChecklists are shown to customers before the visit, keyed by item key.
+var preparationChecklists = map[string][]string{
+	"mattress": {"ถอดผ้าปูที่นอนและปลอกหมอนออกก่อนทีมงานมาถึง", "เว้นพื้นที่รอบที่นอนให้ทีมงานทำงานสะดวก"},
+	"sofa":     {"เก็บหมอนอิงและของบนโซฟาออก", "แจ้งทีมงานหากโซฟาเป็นหนังแท้หรือผ้าพิเศษ"},
+	"curtain":  {"แจ้งทีมงานหากต้องการให้ถอดม่านลงมาซัก", "เก็บของที่วางใกล้หน้าต่างออก"},
+}
+
+var generalChecklist = []string{"เตรียมปลั๊กไฟใกล้จุดทำงาน", "เตรียมที่จอดรถสำหรับทีมงาน (ถ้ามี)"}
+
+func (b *Booking) validate() error {
+	if b.UserID == "" {
+		return fmt.Errorf("user_id is required")
+	}
+	if _, err := time.Parse("2006-01-02", b.Date); err != nil {
+		return fmt.Errorf("date must be YYYY-MM-DD")
+	}
+	if len(b.Items) == 0 {
+		return fmt.Errorf("at least one item is required")
+	}
+	for i, item := range b.Items {
+		if item.ItemKey == "" || item.ServiceKey == "" {
+			return fmt.Errorf("items[%d]: service_key and item_key are required", i)
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("items[%d]: quantity must be positive", i)
+		}
+	}
+	if !validDepositStatuses[b.DepositStatus] {
+		return fmt.Errorf("deposit_status must be pending, paid or waived")
+	}
+	if !validBookingStatuses[b.Status] {
+		return fmt.Errorf("status must be confirmed, completed or cancelled")
+	}
+	return nil
+}
+
+// upcomingBookings returns the user's confirmed bookings from today on, earliest first.
+func upcomingBookings(userId string) []Booking {
+	today := bangkokNow().Format("2006-01-02")
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	var result []Booking
+	for _, b := range bookings {
+		if b.UserID == userId && b.Status == "confirmed" && b.Date >= today {
+			result = append(result, *b)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+	return result
+}
+
+// itemDisplayName resolves item and size keys to their Thai names where possible.
+func itemDisplayName(item BookingItem) string {
+	name := item.ItemKey
+	if pricingConfig != nil {
+		if cfg, ok := pricingConfig.Items[item.ItemKey]; ok {
+			name = cfg.Name
+			if size, ok := cfg.Sizes[item.SizeKey]; ok {
+				name += " " + size.Name
+			}
+		}
+	}
+	return name
+}
+
+// describeMyBookings handles the get_my_booking tool.
+func describeMyBookings(userId string) string {
+	upcoming := upcomingBookings(userId)
+	if len(upcoming) == 0 {
+		return "ไม่พบคิวที่จองไว้ของลูกค้าในระบบ แจ้งลูกค้าตามนี้และเสนอให้ตรวจสอบวันว่างหรือให้เจ้าหน้าที่ตรวจสอบให้ ห้ามเดาวันนัดจากบทสนทนา"
+	}
+
+	var b strings.Builder
+	for i, booking := range upcoming {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "คิวเลขที่ %s\n📅 วันที่ %s", booking.ID, formatThaiDate(booking.Date))
+		if booking.TimeSlot != "" {
+			fmt.Fprintf(&b, " เวลา %s", booking.TimeSlot)
+		}
+		if booking.Address != "" {
+			fmt.Fprintf(&b, "\n📍 %s", booking.Address)
+		}
+		if booking.ContactPhone != "" {
+			fmt.Fprintf(&b, "\n📞 %s", booking.ContactPhone)
+		}
+		b.WriteString("\n🧹 รายการ:")
+		seen := map[string]bool{}
+		var checklist []string
+		for _, item := range booking.Items {
+			fmt.Fprintf(&b, "\n• %s x%d", itemDisplayName(item), item.Quantity)
+			if !seen[item.ItemKey] {
+				seen[item.ItemKey] = true
+				checklist = append(checklist, preparationChecklists[item.ItemKey]...)
+			}
+		}
+		fmt.Fprintf(&b, "\n💰 ยอดรวม %s บาท", pricing.FormatNumber(booking.Total))
+		if booking.VoucherCode != "" {
+			fmt.Fprintf(&b, " (หักบัตรของขวัญ %s แล้ว %s บาท)", booking.VoucherCode, pricing.FormatNumber(booking.VoucherDiscount))
+		}
+		switch booking.DepositStatus {
+		case "paid":
+			fmt.Fprintf(&b, "\n✅ ชำระมัดจำแล้ว %s บาท", pricing.FormatNumber(booking.DepositAmount))
+		case "waived":
+			b.WriteString("\n✅ ไม่ต้องชำระมัดจำ")
+		default:
+			fmt.Fprintf(&b, "\n⏳ ยังไม่ได้ชำระมัดจำ %s บาท", pricing.FormatNumber(booking.DepositAmount))
+		}
+		b.WriteString("\n📝 การเตรียมตัว:")
+		for _, step := range append(checklist, generalChecklist...) {
+			fmt.Fprintf(&b, "\n- %s", step)
+		}
+	}
+	return b.String()
+}
+
+// formatThaiDate renders YYYY-MM-DD as "5 ตุลาคม 2567" (Buddhist era).
+func formatThaiDate(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return fmt.Sprintf("%d %s %d", t.Day(), thaidate.MonthNames[t.Month()-1], t.Year()+543)
+}
+
+// bookingDate reads the date argument of the booking tools: YYYY-MM-DD, or the customer's own
+// phrasing passed through by the model ("เสาร์หน้า", "15 ต.ค. 69"). A date it can't read is
+// returned as is for the tool to reject.
+func bookingDate(date string) string {
+	if iso, ok := thaidate.NormalizeDate(date, bangkokNow()); ok {
+		return iso
+	}
+	return date
+}
+
+// applyBookingToProfile keeps the customer profile in step with their bookings.
+func applyBookingToProfile(b *Booking, previousStatus string) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[b.UserID]
+	if !ok {
+		conv = &UserConversation{UserID: b.UserID}
+		userConversations[b.UserID] = conv
+	}
+	if previousStatus == "" {
+		conv.Profile.LastBookingAt = b.CreatedAt
+	}
+	if b.Status == "completed" && previousStatus != "completed" {
+		if b.Date > conv.Profile.LastServiceDate {
+			conv.Profile.LastServiceDate = b.Date
+		}
+		conv.Profile.TotalSpend += b.Total
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+}
+
+func saveBookings() {
+	bookingLock.Lock()
+	data, err := json.Marshal(bookings)
+	bookingLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal bookings: %v", err)
+		return
+	}
+	if err := os.WriteFile(bookingsFile, data, 0644); err != nil {
+		log.Printf("Failed to save bookings: %v", err)
+	}
+}
+
+func loadBookings() {
+	data, err := os.ReadFile(bookingsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read bookings file: %v", err)
+		}
+		return
+	}
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	if err := json.Unmarshal(data, &bookings); err != nil {
+		log.Printf("Failed to parse bookings file: %v", err)
+	}
+}
+
+func handleGetBookings(c *fiber.Ctx) error {
+	userId := c.Query("user_id")
+	status := c.Query("status")
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	result := make([]Booking, 0, len(bookings))
+	for _, b := range bookings {
+		if (userId == "" || b.UserID == userId) && (status == "" || b.Status == status) {
+			result = append(result, *b)
+		}
+	}
+	return c.JSON(result)
+}
+
+// handleCreateBooking records a booking made by staff outside the chat.
+func handleCreateBooking(c *fiber.Ctx) error {
+	var b Booking
+	if err := c.BodyParser(&b); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if b.DepositStatus == "" {
+		b.DepositStatus = "pending"
+	}
+	if b.Status == "" {
+		b.Status = "confirmed"
+	}
+	if err := b.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	b.ID = fmt.Sprintf("bk_%d", time.Now().UnixNano())
+	b.CreatedAt = time.Now()
+	b.UpdatedAt = b.CreatedAt
+
+	bookingLock.Lock()
+	bookings = append(bookings, &b)
+	bookingLock.Unlock()
+	go saveBookings()
+	applyBookingToProfile(&b, "")
+	emitOutboundEvent("booking.created", b.UserID, b)
+	log.Printf("Created booking %s for user %s on %s", b.ID, b.UserID, b.Date)
+	return c.JSON(b)
+}
+
+// handleUpdateBooking replaces a booking, e.g. to record the deposit or mark it completed.
+func handleUpdateBooking(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var incoming Booking
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	bookingLock.Lock()
+	var existing *Booking
+	for _, b := range bookings {
+		if b.ID == id {
+			existing = b
+		}
+	}
+	if existing == nil {
+		bookingLock.Unlock()
+		return respondError(c, fiber.StatusNotFound, "booking not found")
+	}
+	previousStatus := existing.Status
+	incoming.ID = existing.ID
+	incoming.CreatedAt = existing.CreatedAt
+	incoming.UpdatedAt = time.Now()
+	incoming.Changes = existing.Changes
+	recordBookingChanges(&incoming, "staff", *existing)
+	*existing = incoming
+	bookingLock.Unlock()
+
+	go saveBookings()
+	applyBookingToProfile(&incoming, previousStatus)
+	emitOutboundEvent("booking.updated", incoming.UserID, incoming)
+	if incoming.ContractID != "" && incoming.Status == "cancelled" && previousStatus != "cancelled" {
+		releaseContractUsage(incoming.ContractID, incoming.ID)
+	}
+	return c.JSON(incoming)
+}
+
+// checkBookingDeposits flags confirmed bookings in the next 3 days whose deposit is still pending.
+func checkBookingDeposits(ctx context.Context) ([]ReconciliationIssue, error) {
+	now := bangkokNow()
+	today := now.Format("2006-01-02")
+	horizon := now.AddDate(0, 0, 3).Format("2006-01-02")
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	var issues []ReconciliationIssue
+	for _, b := range bookings {
+		if b.Status == "confirmed" && b.DepositStatus == "pending" && b.Date >= today && b.Date <= horizon {
+			issues = append(issues, ReconciliationIssue{
+				Ref:     b.ID,
+				Message: fmt.Sprintf("คิววันที่ %s ยังไม่ได้รับมัดจำ %s บาท", b.Date, pricing.FormatNumber(b.DepositAmount)),
+			})
+		}
+	}
+	return issues, nil
+}
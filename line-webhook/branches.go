@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Branch is an NCS branch with its own calendar, staff team and pricing. Service-area
+// polygons refer to branches by ID through their "branch" property.
+type Branch struct {
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Keywords           []string `json:"keywords"`                       // provinces/districts that route an address to this branch
+	SlotsURL           string   `json:"slots_url,omitempty"`            // scheduling endpoint; the month is sent as ?sheet=
+	SlotsFormat        string   `json:"slots_format,omitempty"`         // response format of slots_url: apps_script, sheets or calendar
+	Team               []string `json:"team,omitempty"`                 // staff LINE user IDs alerted about this branch's customers
+	PriceAdjustPercent float64  `json:"price_adjust_percent,omitempty"` // e.g. 10 for prices 10% above the base config
+	Default            bool     `json:"default,omitempty"`              // used when nothing identifies the customer's branch
+}
+
+var branchesFile = "branches.json"
+
+var (
+	branchLock sync.RWMutex
+	branches   []Branch
+
+	// branchPricing caches price-adjusted configs per branch for the current pricingConfig
+	branchPricingLock   sync.Mutex
+	branchPricingBase   *pricing.Config
+	branchPricingScaled = map[string]*pricing.Config{}
+)
+
+func findBranch(id string) (Branch, bool) {
+	branchLock.RLock()
+	defer branchLock.RUnlock()
+	for _, b := range branches {
+		if b.ID == id {
+			return b, true
+		}
+	}
+	return Branch{}, false
+}
+
+func defaultBranch() (Branch, bool) {
+	branchLock.RLock()
+	defer branchLock.RUnlock()
+	for _, b := range branches {
+		if b.Default {
+			return b, true
+		}
+	}
+	return Branch{}, false
+}
+
+// branchForText finds a branch whose keywords appear in an address or message.
+func branchForText(normalized string) (Branch, bool) {
+	lower := strings.ToLower(normalized)
+	branchLock.RLock()
+	defer branchLock.RUnlock()
+	for _, b := range branches {
+		for _, kw := range b.Keywords {
+			if kw != "" && strings.Contains(lower, strings.ToLower(kw)) {
+				return b, true
+			}
+		}
+	}
+	return Branch{}, false
+}
+
+// branchForLocation picks the branch owning the service area that contains the point, or
+// whose provinces the address names.
+func branchForLocation(lat, lng float64, address string) (Branch, bool) {
+	area, ok := locateServiceArea(lat, lng, address)
+	if !ok {
+		return Branch{}, false
+	}
+	return findBranch(area.Branch)
+}
+
+// assignBranchLocked records the customer's branch. Caller must hold userThreadLock.
+func assignBranchLocked(conv *UserConversation, b Branch) {
+	if conv.Profile.Branch == b.ID {
+		return
+	}
+	log.Printf("Routing user %s to branch %s", conv.UserID, b.ID)
+	conv.Profile.Branch = b.ID
+	delete(userAnswerCache, conv.UserID) // cached answers may carry another branch's prices
+}
+
+// customerBranch returns the branch serving the user, falling back to the branch of their
+// LINE channel and then the default branch.
+func customerBranch(userId string) (Branch, bool) {
+	userThreadLock.Lock()
+	id := ""
+	if conv, ok := userConversations[userId]; ok {
+		id = conv.Profile.Branch
+	}
+	userThreadLock.Unlock()
+	if ch, ok := channelFor(userId); ok && id == "" {
+		id = ch.Branch
+	}
+	if id != "" {
+		if b, ok := findBranch(id); ok {
+			return b, true
+		}
+	}
+	return defaultBranch()
+}
+
+// slotsURLFor builds the scheduling request for the user's branch calendar.
+func slotsURLFor(userId, thaiMonthYear string) string {
+	b, _ := customerBranch(userId)
+	return branchSlotsURL(b, thaiMonthYear)
+}
+
+// branchSlotsURL builds the scheduling request for a branch calendar; the zero Branch uses
+// the default calendar (SLOTS_URL).
+func branchSlotsURL(b Branch, thaiMonthYear string) string {
+	base := appConfig.SlotsURL
+	if b.SlotsURL != "" {
+		base = b.SlotsURL
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "sheet=" + url.QueryEscape(thaiMonthYear)
+}
+
+// branchStaffRecipients returns the branch team for the customer, or the global staff list.
+func branchStaffRecipients(userId string) []string {
+	if b, ok := customerBranch(userId); ok && len(b.Team) > 0 {
+		return b.Team
+	}
+	return staffAlertRecipients()
+}
+
+// pricingEngineFor returns the pricing engine with the user's branch price adjustment applied.
+func pricingEngineFor(userId string) *pricing.Engine {
+	engine := pricingEngine()
+	b, ok := customerBranch(userId)
+	if !ok || b.PriceAdjustPercent == 0 || pricingConfig == nil {
+		return engine
+	}
+	branchPricingLock.Lock()
+	defer branchPricingLock.Unlock()
+	if branchPricingBase != pricingConfig {
+		branchPricingBase = pricingConfig
+		branchPricingScaled = map[string]*pricing.Config{}
+	}
+	cfg, ok := branchPricingScaled[b.ID]
+	if !ok {
+		scaled, err := pricingConfig.Scaled(b.PriceAdjustPercent)
+		if err != nil {
+			log.Printf("Failed to apply price adjustment for branch %s: %v", b.ID, err)
+			return engine
+		}
+		cfg = scaled
+		branchPricingScaled[b.ID] = cfg
+	}
+	engine.Config = cfg
+	return engine
+}
+
+func validateBranches(list []Branch) error {
+	seen := map[string]bool{}
+	defaults := 0
+	for i, b := range list {
+		if strings.TrimSpace(b.ID) == "" || strings.TrimSpace(b.Name) == "" {
+			return fmt.Errorf("branch %d: id and name are required", i)
+		}
+		if seen[b.ID] {
+			return fmt.Errorf("duplicate branch id '%s'", b.ID)
+		}
+		seen[b.ID] = true
+		if b.PriceAdjustPercent <= -100 {
+			return fmt.Errorf("branch '%s': price_adjust_percent must be above -100", b.ID)
+		}
+		if b.SlotsURL != "" && !strings.HasPrefix(b.SlotsURL, "https://") {
+			return fmt.Errorf("branch '%s': slots_url must be https", b.ID)
+		}
+		if _, ok := slotsParsers[b.SlotsFormat]; b.SlotsFormat != "" && !ok {
+			return fmt.Errorf("branch '%s': slots_format must be apps_script, sheets or calendar", b.ID)
+		}
+		if b.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("only one branch can be the default")
+	}
+	return nil
+}
+
+func loadBranches() {
+	data, err := os.ReadFile(branchesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read branches file: %v", err)
+		}
+		return
+	}
+	var list []Branch
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Failed to parse branches file: %v", err)
+		return
+	}
+	if err := validateBranches(list); err != nil {
+		log.Printf("Invalid branches file: %v", err)
+		return
+	}
+	branchLock.Lock()
+	branches = list
+	branchLock.Unlock()
+	log.Printf("Loaded %d branches", len(list))
+}
+
+func handleGetBranches(c *fiber.Ctx) error {
+	branchLock.RLock()
+	defer branchLock.RUnlock()
+	if branches == nil {
+		return c.JSON([]Branch{})
+	}
+	return c.JSON(branches)
+}
+
+func handleReplaceBranches(c *fiber.Ctx) error {
+	var incoming []Branch
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := validateBranches(incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save branches")
+	}
+	if err := os.WriteFile(branchesFile, data, 0644); err != nil {
+		log.Printf("Failed to save branches: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save branches")
+	}
+	branchLock.Lock()
+	branches = incoming
+	branchLock.Unlock()
+	branchPricingLock.Lock()
+	branchPricingScaled = map[string]*pricing.Config{}
+	branchPricingLock.Unlock()
+	invalidatePricedAnswers("branches replaced") // price adjustments may have changed
+	return c.JSON(fiber.Map{"status": "ok", "branches": incoming})
+}
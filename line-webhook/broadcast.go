@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type BroadcastRequest struct {
+	Segment string `json:"segment"`
+	Message string `json:"message"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+// handleSendBroadcast pushes a message to every user in a segment. Broadcasts are
+// non-essential, so they are deferred automatically when the LINE quota runs low.
+func handleSendBroadcast(c *fiber.Ctx) error {
+	var req BroadcastRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		return respondError(c, fiber.StatusBadRequest, "message is required")
+	}
+	seg, ok := findSegment(req.Segment)
+	if !ok {
+		return respondError(c, fiber.StatusBadRequest, "unknown segment '"+req.Segment+"'")
+	}
+	members := segmentMembers(seg)
+	if req.DryRun {
+		return c.JSON(fiber.Map{"status": "dry_run", "segment": seg.ID, "recipients": len(members)})
+	}
+
+	go func() {
+		failed := 0
+		for _, m := range members {
+			if err := pushLineMessageWithPriority(m.UserID, req.Message, pushNonEssential, "broadcast:"+seg.ID); err != nil {
+				failed++
+				log.Printf("Broadcast to %s failed: %v", m.UserID, err)
+			}
+		}
+		log.Printf("Broadcast to segment %s finished: %d recipients, %d failed", seg.ID, len(members), failed)
+		appMetrics.add("broadcast_messages", int64(len(members)-failed))
+	}()
+	return c.JSON(fiber.Map{"status": "sending", "segment": seg.ID, "recipients": len(members)})
+}
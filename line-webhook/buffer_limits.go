@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The message buffer collects a customer's messages before one assistant turn; how long it
+// waits is decided in message_batching.go. BUFFER_MAX_MESSAGES and BUFFER_MAX_CHARS cap one
+// turn: reaching either flushes the buffer at once, and a turn never takes more than the caps
+// allow; the rest is answered in the next turn. 0 turns a cap off.
+
+const bufferLimitAck = "ได้รับข้อความแล้วค่ะ ขอเวลาตรวจสอบสักครู่นะคะ 🙏"
+
+func bufferMaxMessages() int {
+	return appConfig.BufferMaxMessages
+}
+
+func bufferMaxChars() int {
+	return appConfig.BufferMaxChars
+}
+
+// bufferedChars counts the text of a buffered message. Photos are buffered as data URLs,
+// which only count as a message.
+func bufferedChars(msg string) int {
+	if strings.Contains(msg, "data:image") {
+		return 0
+	}
+	return utf8.RuneCountInString(msg)
+}
+
+// bufferAtLimit reports whether msgs have reached either cap.
+func bufferAtLimit(msgs []string) bool {
+	maxMsgs, maxChars := bufferMaxMessages(), bufferMaxChars()
+	if maxMsgs > 0 && len(msgs) >= maxMsgs {
+		return true
+	}
+	chars := 0
+	for _, m := range msgs {
+		chars += bufferedChars(m)
+	}
+	return maxChars > 0 && chars >= maxChars
+}
+
+// splitBufferAtLimit returns the oldest messages that fit in one turn and the rest. The
+// first message is always taken, however long, so the buffer keeps moving.
+func splitBufferAtLimit(msgs []string) (turn, rest []string) {
+	maxMsgs, maxChars := bufferMaxMessages(), bufferMaxChars()
+	chars := 0
+	for i, m := range msgs {
+		chars += bufferedChars(m)
+		if i > 0 && ((maxMsgs > 0 && i >= maxMsgs) || (maxChars > 0 && chars > maxChars)) {
+			return msgs[:i], msgs[i:]
+		}
+	}
+	return msgs, nil
+}
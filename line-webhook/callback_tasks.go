@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CallbackTask asks staff to phone a customer the bot could not help.
+type CallbackTask struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Phone       string    `json:"phone"`
+	Reason      string    `json:"reason"`
+	Status      string    `json:"status"` // "open" or "done"
+	CreatedAt   time.Time `json:"created_at"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+}
+
+var callbackTasksFile = "callback_tasks.json"
+
+var (
+	callbackTaskLock sync.Mutex
+	callbackTasks    []*CallbackTask
+)
+
+const (
+	failureApologyMessage = "ขออภัยค่ะ 🙏 ตอนนี้ระบบตอบกลับอัตโนมัติขัดข้อง ทีมงานจะโทรกลับไปดูแลโดยตรงนะคะ\n" +
+		"📞 รบกวนพิมพ์เบอร์โทรศัพท์ 10 หลักสำหรับติดต่อกลับ (เช่น 0812345678) ค่ะ"
+	failurePhoneRetryMessage = "รบกวนพิมพ์เบอร์โทรศัพท์ 10 หลัก (เช่น 0812345678) เพื่อให้ทีมงานโทรกลับนะคะ 🙏"
+	failureCallbackCreated   = "ได้รับเบอร์ %s แล้วค่ะ 🙏 ทีมงานจะโทรกลับโดยเร็วที่สุดนะคะ ขอบคุณที่รอค่ะ"
+	failureCallbackWaiting   = "ทีมงานได้รับเรื่องแล้วและจะโทรกลับโดยเร็วที่สุดนะคะ 🙏"
+)
+
+// failureEscalationThreshold is the number of consecutive failed AI turns before escalation.
+func failureEscalationThreshold() int {
+	return appConfig.FailureEscalationThreshold
+}
+
+var thaiPhonePattern = regexp.MustCompile(`(?:\+?66|0)[\s-]?\d{1,2}[\s-]?\d{3}[\s-]?\d{3,4}`)
+
+// extractThaiPhone returns a phone number in 0XXXXXXXXX form, or "" if none is found.
+func extractThaiPhone(text string) string {
+	m := thaiPhonePattern.FindString(convertThaiDigits(text))
+	if m == "" {
+		return ""
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, m)
+	if strings.HasPrefix(digits, "66") {
+		digits = "0" + digits[2:]
+	}
+	if len(digits) < 9 || len(digits) > 10 {
+		return ""
+	}
+	return digits
+}
+
+// recordAssistantOutcome updates the failure streak for a user and reports whether the
+// conversation has just crossed the escalation threshold.
+func recordAssistantOutcome(userId string, failed bool) bool {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		return false
+	}
+	if !failed {
+		conv.ConsecutiveFailures = 0
+		return false
+	}
+	conv.ConsecutiveFailures++
+	appMetrics.inc("assistant_failed_turns")
+	if conv.FailureEscalated || conv.ConsecutiveFailures < failureEscalationThreshold() {
+		return false
+	}
+	conv.FailureEscalated = true
+	if !conv.WantsHuman {
+		go startHandoffSummary(userId, "AI ตอบไม่สำเร็จติดต่อกันหลายครั้ง")
+	}
+	conv.WantsHuman = true
+	log.Printf("User %s hit %d consecutive AI failures; escalating to callback", userId, conv.ConsecutiveFailures)
+	appMetrics.inc("assistant_failure_escalations")
+	return true
+}
+
+// handleEscalatedTurn answers a customer whose conversation is paused after repeated failures,
+// without calling OpenAI. It collects a phone number and opens a callback task.
+func handleEscalatedTurn(userId, message string) string {
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	hasTask := conv != nil && conv.CallbackTaskID != ""
+	userThreadLock.Unlock()
+	if hasTask {
+		return failureCallbackWaiting
+	}
+
+	phone := extractThaiPhone(message)
+	if phone == "" {
+		return failurePhoneRetryMessage
+	}
+	task := createCallbackTask(userId, phone, "ระบบ AI ตอบไม่สำเร็จติดต่อกันหลายครั้ง")
+	claimImportedCustomer(userId, phone)
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.CallbackTaskID = task.ID
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	return fmt.Sprintf(failureCallbackCreated, phone)
+}
+
+func createCallbackTask(userId, phone, reason string) *CallbackTask {
+	task := &CallbackTask{
+		ID:        fmt.Sprintf("cb_%d", time.Now().UnixNano()),
+		UserID:    userId,
+		Phone:     phone,
+		Reason:    reason,
+		Status:    "open",
+		CreatedAt: time.Now(),
+	}
+	callbackTaskLock.Lock()
+	callbackTasks = append(callbackTasks, task)
+	callbackTaskLock.Unlock()
+	go saveCallbackTasks()
+	log.Printf("Created callback task %s for user %s", task.ID, userId)
+	appMetrics.inc("callback_tasks_created")
+	return task
+}
+
+// resetFailureEscalation re-enables the AI for a conversation. Caller must hold userThreadLock.
+func resetFailureEscalation(conv *UserConversation) {
+	conv.ConsecutiveFailures = 0
+	conv.FailureEscalated = false
+	conv.CallbackTaskID = ""
+}
+
+func saveCallbackTasks() {
+	callbackTaskLock.Lock()
+	data, err := json.Marshal(callbackTasks)
+	callbackTaskLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal callback tasks: %v", err)
+		return
+	}
+	if err := os.WriteFile(callbackTasksFile, data, 0644); err != nil {
+		log.Printf("Failed to save callback tasks: %v", err)
+	}
+}
+
+func loadCallbackTasks() {
+	data, err := os.ReadFile(callbackTasksFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read callback tasks file: %v", err)
+		}
+		return
+	}
+	callbackTaskLock.Lock()
+	defer callbackTaskLock.Unlock()
+	if err := json.Unmarshal(data, &callbackTasks); err != nil {
+		log.Printf("Failed to parse callback tasks file: %v", err)
+	}
+}
+
+func handleGetCallbackTasks(c *fiber.Ctx) error {
+	status := c.Query("status")
+	callbackTaskLock.Lock()
+	defer callbackTaskLock.Unlock()
+	result := make([]CallbackTask, 0, len(callbackTasks))
+	for _, t := range callbackTasks {
+		if status == "" || t.Status == status {
+			result = append(result, *t)
+		}
+	}
+	return c.JSON(result)
+}
+
+// handleCompleteCallbackTask marks a callback done and hands the conversation back to the AI.
+func handleCompleteCallbackTask(c *fiber.Ctx) error {
+	id := c.Params("id")
+	callbackTaskLock.Lock()
+	var task *CallbackTask
+	for _, t := range callbackTasks {
+		if t.ID == id {
+			task = t
+		}
+	}
+	if task != nil {
+		task.Status = "done"
+		task.CompletedAt = time.Now()
+	}
+	callbackTaskLock.Unlock()
+	if task == nil {
+		return respondError(c, fiber.StatusNotFound, "callback task not found")
+	}
+	userThreadLock.Lock()
+	if conv, ok := userConversations[task.UserID]; ok && conv.CallbackTaskID == id {
+		resetFailureEscalation(conv)
+	}
+	userThreadLock.Unlock()
+	go saveCallbackTasks()
+	go saveConversations()
+	return c.JSON(fiber.Map{"status": "ok"})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// One server can host several LINE Official Accounts, e.g. one per branch. channels.json lists
+// them; webhook events are matched to a channel by their "destination" (the bot's user ID).
+// Tokens and secrets stay in the environment, keyed by channel ID:
+// LINE_CHANNEL_ACCESS_TOKEN_<ID> and LINE_CHANNEL_SECRET_<ID> (ID upper-cased, dashes as
+// underscores). A channel can also set the branch of its customers (pricing, calendar, staff
+// team), the model and the instructions file. Without channels.json, or for channels without
+// their own credentials, the plain LINE_CHANNEL_ACCESS_TOKEN and LINE_CHANNEL_SECRET are used.
+// With channels.json, webhooks for destinations it doesn't list are rejected, so the main
+// account must be listed too.
+
+// LineChannel is one LINE Official Account served by this server.
+type LineChannel struct {
+	ID               string `json:"id"`
+	Name             string `json:"name,omitempty"`
+	Destination      string `json:"destination"`                 // bot user ID sent as the webhook "destination"
+	Branch           string `json:"branch,omitempty"`            // branch of customers without one of their own
+	Model            string `json:"model,omitempty"`             // overrides the run params model
+	InstructionsFile string `json:"instructions_file,omitempty"` // replaces gpt_instructions.md
+
+	instructions string
+}
+
+var channelsFile = "channels.json"
+
+var (
+	channelLock  sync.RWMutex
+	lineChannels []LineChannel
+	userChannels = make(map[string]string) // user ID -> channel ID
+)
+
+func channelEnvSuffix(id string) string {
+	return strings.ToUpper(strings.ReplaceAll(id, "-", "_"))
+}
+
+func (ch LineChannel) accessToken() string {
+	return appConfig.Value("LINE_CHANNEL_ACCESS_TOKEN_" + channelEnvSuffix(ch.ID))
+}
+
+func (ch LineChannel) secret() string {
+	return appConfig.Value("LINE_CHANNEL_SECRET_" + channelEnvSuffix(ch.ID))
+}
+
+func validateChannels(list []LineChannel) error {
+	seenID := make(map[string]bool)
+	seenDest := make(map[string]bool)
+	for i, ch := range list {
+		if ch.ID == "" || ch.Destination == "" {
+			return fmt.Errorf("channel %d: id and destination are required", i)
+		}
+		if seenID[ch.ID] {
+			return fmt.Errorf("duplicate channel id '%s'", ch.ID)
+		}
+		if seenDest[ch.Destination] {
+			return fmt.Errorf("duplicate destination '%s'", ch.Destination)
+		}
+		seenID[ch.ID] = true
+		seenDest[ch.Destination] = true
+	}
+	return nil
+}
+
+// loadChannels reads channels.json and restores which channel each conversation came through.
+// Call after loadConversationsFromFile.
+func loadChannels() {
+	data, err := os.ReadFile(channelsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read channels file: %v", err)
+		}
+		return
+	}
+	var list []LineChannel
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Failed to parse channels file: %v", err)
+		return
+	}
+	if err := validateChannels(list); err != nil {
+		log.Printf("Invalid channels file: %v", err)
+		return
+	}
+	for i := range list {
+		if list[i].accessToken() == "" {
+			log.Printf("WARNING: LINE_CHANNEL_ACCESS_TOKEN_%s not set; channel %s uses LINE_CHANNEL_ACCESS_TOKEN", channelEnvSuffix(list[i].ID), list[i].ID)
+		}
+		if list[i].secret() == "" {
+			log.Printf("WARNING: LINE_CHANNEL_SECRET_%s not set; channel %s webhooks are checked with LINE_CHANNEL_SECRET", channelEnvSuffix(list[i].ID), list[i].ID)
+		}
+		if list[i].InstructionsFile == "" {
+			continue
+		}
+		text, err := os.ReadFile(list[i].InstructionsFile)
+		if err != nil {
+			log.Printf("Failed to read instructions for channel %s: %v", list[i].ID, err)
+			continue
+		}
+		list[i].instructions = string(text)
+	}
+
+	userThreadLock.Lock()
+	restored := make(map[string]string)
+	for userId, conv := range userConversations {
+		if conv.Channel != "" {
+			restored[userId] = conv.Channel
+		}
+	}
+	userThreadLock.Unlock()
+
+	channelLock.Lock()
+	lineChannels = list
+	for userId, id := range restored {
+		userChannels[userId] = id
+	}
+	channelLock.Unlock()
+	log.Printf("Loaded %d LINE channels", len(list))
+}
+
+// channelForDestination finds the channel of a webhook by its destination.
+func channelForDestination(destination string) (LineChannel, bool) {
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	for _, ch := range lineChannels {
+		if ch.Destination == destination {
+			return ch, true
+		}
+	}
+	return LineChannel{}, false
+}
+
+// setUserChannel records the channel a user wrote through. It must be set before anything
+// is sent to the user, so it is called first thing for every event.
+func setUserChannel(userId, channelID string) {
+	channelLock.Lock()
+	userChannels[userId] = channelID
+	channelLock.Unlock()
+}
+
+// noteChannelLocked stores the user's channel on the conversation so it survives restarts.
+// Caller must hold userThreadLock.
+func noteChannelLocked(conv *UserConversation) {
+	if id := userChannelID(conv.UserID); id != "" {
+		conv.Channel = id
+	}
+}
+
+func userChannelID(userId string) string {
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	return userChannels[userId]
+}
+
+// channelFor returns the channel the user wrote through.
+func channelFor(userId string) (LineChannel, bool) {
+	id := userChannelID(userId)
+	if id == "" {
+		return LineChannel{}, false
+	}
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	for _, ch := range lineChannels {
+		if ch.ID == id {
+			return ch, true
+		}
+	}
+	return LineChannel{}, false
+}
+
+// lineAccessToken returns the access token for calls about the user, falling back to
+// LINE_CHANNEL_ACCESS_TOKEN.
+func lineAccessToken(userId string) string {
+	if ch, ok := channelFor(userId); ok {
+		if token := ch.accessToken(); token != "" {
+			return token
+		}
+	}
+	return appConfig.LineAccessToken
+}
+
+// lineChannelSecret returns the secret that signs webhooks sent to the destination, falling
+// back to LINE_CHANNEL_SECRET. When channels.json lists channels, a destination it doesn't
+// list is unknown and reports false.
+func lineChannelSecret(destination string) (string, bool) {
+	if ch, ok := channelForDestination(destination); ok {
+		if secret := ch.secret(); secret != "" {
+			return secret, true
+		}
+		return appConfig.LineChannelSecret, true
+	}
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	if len(lineChannels) > 0 {
+		return "", false
+	}
+	return appConfig.LineChannelSecret, true
+}
+
+// unsignedLineChannels names the accounts whose webhooks have no secret to check.
+func unsignedLineChannels() []string {
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	if len(lineChannels) == 0 {
+		if appConfig.LineChannelSecret == "" {
+			return []string{"LINE_CHANNEL_SECRET"}
+		}
+		return nil
+	}
+	var missing []string
+	for _, ch := range lineChannels {
+		if ch.secret() == "" && appConfig.LineChannelSecret == "" {
+			missing = append(missing, "LINE_CHANNEL_SECRET_"+channelEnvSuffix(ch.ID))
+		}
+	}
+	return missing
+}
+
+// ChannelStatus is a channel as shown to admins, without its credentials.
+type ChannelStatus struct {
+	LineChannel
+	HasAccessToken  bool `json:"has_access_token"`
+	HasSecret       bool `json:"has_secret"`
+	HasInstructions bool `json:"has_instructions"`
+	Customers       int  `json:"customers"`
+}
+
+func handleGetChannels(c *fiber.Ctx) error {
+	channelLock.RLock()
+	defer channelLock.RUnlock()
+	counts := make(map[string]int)
+	for _, id := range userChannels {
+		counts[id]++
+	}
+	list := make([]ChannelStatus, 0, len(lineChannels))
+	for _, ch := range lineChannels {
+		list = append(list, ChannelStatus{
+			LineChannel:     ch,
+			HasAccessToken:  ch.accessToken() != "",
+			HasSecret:       ch.secret() != "",
+			HasInstructions: ch.instructions != "",
+			Customers:       counts[ch.ID],
+		})
+	}
+	return c.JSON(list)
+}
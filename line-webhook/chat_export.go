@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chatExportCooldown limits how often a customer can export their history.
+const chatExportCooldown = 10 * time.Minute
+
+// chatExportTTL is how long an export link works. The file is deleted afterwards, whatever
+// the retention policy says.
+const chatExportTTL = time.Hour
+
+var lastChatExport = make(map[string]time.Time) // userId -> last export; guarded by userThreadLock
+
+// chatTranscript renders the customer's own conversation as plain text. Only the
+// requesting user's messages are ever read, so the export needs no staff approval.
+func chatTranscript(conv *UserConversation) string {
+	var b strings.Builder
+	b.WriteString("ประวัติการสนทนากับ NCS\n")
+	name := conv.DisplayName
+	if name == "" {
+		name = "คุณลูกค้า"
+	}
+	fmt.Fprintf(&b, "ลูกค้า: %s\n", name)
+	fmt.Fprintf(&b, "ส่งออกเมื่อ: %s\n", strings.Replace(getBangkokTime(), "T", " ", 1))
+	b.WriteString("เอกสารนี้มีข้อมูลส่วนบุคคล กรุณาอย่าส่งต่อลิงก์ให้ผู้อื่น\n\n")
+	roles := map[string]string{"customer": "คุณ", "ai": "NCS", "admin": "เจ้าหน้าที่ NCS"}
+	for _, m := range conv.Messages {
+		role, ok := roles[m.Role]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", strings.Replace(m.Timestamp, "T", " ", 1), role, m.original())
+	}
+	return b.String()
+}
+
+// exportMyChatHistory stores the customer's transcript as a text file and queues the link
+// with the reply. The returned text is the tool output for the model.
+func exportMyChatHistory(userId string) (string, error) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok || len(conv.Messages) == 0 {
+		userThreadLock.Unlock()
+		return "ยังไม่มีประวัติการสนทนาให้ส่งออก", nil
+	}
+	if last, ok := lastChatExport[userId]; ok && time.Since(last) < chatExportCooldown {
+		userThreadLock.Unlock()
+		return "ลูกค้าเพิ่งขอประวัติการสนทนาไปเมื่อสักครู่ ลิงก์เดิมยังใช้ได้ แจ้งให้ลูกค้าดูข้อความก่อนหน้า", nil
+	}
+	transcript := chatTranscript(conv)
+	userThreadLock.Unlock()
+
+	name := newMediaName("chat", "txt") // private: see privateMediaPrefix
+	url, err := mediaStore.Put(name, "text/plain; charset=utf-8", []byte(transcript))
+	if err != nil {
+		log.Printf("Failed to store chat export for %s: %v", userId, err)
+		return "ไม่สามารถสร้างไฟล์ประวัติการสนทนาได้ในขณะนี้ แจ้งลูกค้าว่าเจ้าหน้าที่จะส่งให้ภายหลัง", &ToolError{Tool: "export_my_chat_history", Err: err}
+	}
+	url = signMediaURL(url, name, time.Now().Add(chatExportTTL))
+	time.AfterFunc(chatExportTTL, func() { removeChatExport(name) })
+
+	userThreadLock.Lock()
+	lastChatExport[userId] = time.Now()
+	userThreadLock.Unlock()
+	queueReplyAttachment(userId, map[string]interface{}{
+		"type": "text",
+		"text": "📄 ประวัติการสนทนาของคุณลูกค้า: " + url + "\nลิงก์นี้ใช้ได้ 1 ชั่วโมง และเป็นข้อมูลส่วนบุคคล กรุณาอย่าส่งต่อให้ผู้อื่นนะคะ",
+	})
+	log.Printf("Chat history exported for user %s", userId)
+	appMetrics.inc("chat_exports")
+	return "สร้างไฟล์ประวัติการสนทนาแล้ว ลิงก์จะถูกส่งให้ลูกค้าพร้อมข้อความตอบกลับนี้โดยอัตโนมัติ ไม่ต้องใส่ลิงก์เอง", nil
+}
+
+func removeChatExport(name string) {
+	if err := os.Remove(filepath.Join(mediaDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to delete expired chat export %s: %v", name, err)
+	}
+}
+
+// startChatExportCleanup deletes exports whose link has expired, including those left by a
+// previous process, whose timers didn't run.
+func startChatExportCleanup() {
+	go func() {
+		for {
+			entries, _ := os.ReadDir(mediaDir)
+			for _, e := range entries {
+				if !isPrivateMedia(e.Name()) {
+					continue
+				}
+				if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > chatExportTTL {
+					removeChatExport(e.Name())
+				}
+			}
+			time.Sleep(10 * time.Minute)
+		}
+	}()
+}
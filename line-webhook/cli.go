@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCommand executes a one-off maintenance subcommand instead of starting the server, e.g.
+//
+//	go run . import-pricing -dry-run prices.csv
+func runCommand(args []string) error {
+	switch args[0] {
+	case "import-pricing":
+		fs := flag.NewFlagSet("import-pricing", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "validate the spreadsheet without saving")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: import-pricing [-dry-run] <file.csv|file.xlsx>")
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", fs.Arg(0), err)
+		}
+		report, _, err := runPricingImport(fs.Arg(0), data, *dryRun)
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+	case "import-customers":
+		fs := flag.NewFlagSet("import-customers", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "validate the customer list without saving")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: import-customers [-dry-run] <file.csv|file.xlsx>")
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", fs.Arg(0), err)
+		}
+		// run while the server is stopped: it rewrites conversations.json
+		loadConversationsFromFile()
+		loadBranches()
+		loadImportedCustomers()
+		report, err := runCustomerImport(fs.Arg(0), data, *dryRun)
+		if err != nil {
+			return err
+		}
+		return printJSON(report)
+	case "seed-slots":
+		fs := flag.NewFlagSet("seed-slots", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "show the generated month without creating it")
+		branch := fs.String("branch", "", "branch ID; the default calendar when empty")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: seed-slots [-dry-run] [-branch id] \"<Thai month> <B.E. year>\"")
+		}
+		loadBranches()
+		loadSlotTemplate()
+		b, err := resolveSeedBranch(*branch)
+		if err != nil {
+			return err
+		}
+		result, err := seedSlotMonth(b, fs.Arg(0), *dryRun)
+		if err != nil {
+			return err
+		}
+		return printJSON(result)
+	case "sync-knowledge":
+		fs := flag.NewFlagSet("sync-knowledge", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "list the changes without uploading")
+		dir := fs.String("dir", knowledgeDir(), "directory holding the company documents")
+		store := fs.String("vector-store", knowledgeVectorStoreID(), "vector store ID; a new store is created when empty")
+		fs.Parse(args[1:])
+		report, err := syncKnowledge(*dir, *store, *dryRun)
+		if report != nil {
+			printJSON(report)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,610 @@
+// Package config loads the webhook's core settings once at startup, from the environment
+// and an optional YAML file, and checks them before the server starts. Settings that are
+// missing or malformed are reported together by Validate instead of failing later inside
+// a customer's turn.
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSlotsURL is the scheduling Apps Script deployment used when SLOTS_URL is not set.
+const DefaultSlotsURL = "https://script.google.com/macros/s/AKfycbwfSkwsgO56UdPHqa-KCxO7N-UDzkiMIBVjBTd0k8sowLtm7wORC-lN32IjAwtOVqMxQw/exec"
+
+// Config holds the settings the request paths need. The env variable of each field is
+// given in its comment; the same names are used as keys in the config file.
+type Config struct {
+	LineAccessToken   string // LINE_CHANNEL_ACCESS_TOKEN, required
+	LineChannelSecret string // LINE_CHANNEL_SECRET
+	OpenAIAPIKey      string // CHATGPT_API_KEY, required
+	OpenAIBaseURL     string // OPENAI_BASE_URL
+	AdminToken        string // ADMIN_API_TOKEN; the admin API is disabled without it
+	PIIEncryptionKey  string // PII_ENCRYPTION_KEY, 32 bytes in base64; keeps originals of masked messages
+
+	AllowUnsignedWebhooks bool // ALLOW_UNSIGNED_WEBHOOKS; starts without a channel secret and accepts unsigned /webhook requests (local testing only)
+
+	Port        string // PORT, default 8080
+	DataDir     string // DATA_DIR
+	PricingFile string // PRICING_CONFIG_FILE, default pricing_config.json
+	SlotsURL    string // SLOTS_URL, default DefaultSlotsURL
+
+	SlotsProvider      string        // SLOTS_PROVIDER: apps_script (default) or sheets_api
+	SlotsTimeout       time.Duration // SLOTS_TIMEOUT_SECONDS, default 30
+	SlotsRetryAttempts int           // SLOTS_RETRY_ATTEMPTS, default 2
+	SlotsCacheTTL      time.Duration // SLOTS_CACHE_SECONDS, default 60; 0 disables
+	GoogleSheetsID     string        // GOOGLE_SHEETS_ID, required by sheets_api
+	GoogleSheetsAPIKey string        // GOOGLE_SHEETS_API_KEY, read-only sheets_api access
+
+	GoogleServiceAccountFile string // GOOGLE_SERVICE_ACCOUNT_FILE, sheets_api reads and writes
+
+	BufferWindow      time.Duration // BUFFER_WINDOW_SECONDS, default 8
+	BufferTypingExtra time.Duration // BUFFER_TYPING_EXTRA_SECONDS, default 7
+	BufferMaxWait     time.Duration // BUFFER_MAX_WAIT_SECONDS, default 30
+	AssistantTimeout  time.Duration // ASSISTANT_TIMEOUT_SECONDS, default 300
+	LatencyBudget     time.Duration // ASSISTANT_LATENCY_BUDGET_SECONDS, default 15; 0 disables
+	AssistantNotice   time.Duration // ASSISTANT_NOTICE_SECONDS, default 30; 0 disables
+	AnswerCacheTTL    time.Duration // ANSWER_CACHE_SECONDS, default 1800; 0 disables
+	AnswerCacheSize   int           // ANSWER_CACHE_SIZE, answers kept per customer, default 20
+
+	EmbeddingModel    string  // EMBEDDING_MODEL, default text-embedding-3-small
+	FAQMatchThreshold float64 // FAQ_MATCH_THRESHOLD, cosine similarity 0-1, default 0.85
+
+	LLMFallbackBaseURL string        // LLM_FALLBACK_BASE_URL; failover is off without it
+	LLMFallbackAPIKey  string        // LLM_FALLBACK_API_KEY, required with LLM_FALLBACK_BASE_URL
+	LLMFallbackBackend string        // LLM_FALLBACK_BACKEND: chat_completions (default) or responses
+	LLMFallbackModel   string        // LLM_FALLBACK_MODEL; the primary's model names when empty
+	FailoverErrors     int           // LLM_FAILOVER_ERRORS, default 3
+	FailoverLatency    time.Duration // LLM_FAILOVER_LATENCY_SECONDS, default 60
+	FailoverCooldown   time.Duration // LLM_FAILOVER_COOLDOWN_SECONDS, default 300
+
+	PublicBaseURL string // PUBLIC_BASE_URL, where /media is reachable; media links fail without it
+	KnowledgeDir  string // KNOWLEDGE_DIR, default knowledge
+	VectorStoreID string // OPENAI_VECTOR_STORE_ID; file search is off without it
+	DatabaseURL   string // DATABASE_URL, Postgres for the conversation log
+
+	LogLevel           string            // LOG_LEVEL: info (default) or debug
+	LogFormat          string            // LOG_FORMAT: json (default) or text
+	LogRedactContent   bool              // LOG_REDACT_CONTENT
+	LogMaskPII         bool              // LOG_MASK_PII, default true
+	OTLPTracesEndpoint string            // OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+	OTLPEndpoint       string            // OTEL_EXPORTER_OTLP_ENDPOINT; /v1/traces is appended
+	OTLPHeaders        map[string]string // OTEL_EXPORTER_OTLP_HEADERS, comma-separated key=value pairs
+	OTelServiceName    string            // OTEL_SERVICE_NAME, default ncs-line-webhook
+	ReadyzOpenAIPing   bool              // READYZ_OPENAI_PING
+
+	LLMBackend          string                // LLM_BACKEND: responses (default) or chat_completions
+	ResponsesMode       string                // OPENAI_RESPONSES_MODE: foreground (default) or background
+	OpenAIWebhookSecret string                // OPENAI_WEBHOOK_SECRET; background responses are polled without it
+	TranscribeModel     string                // TRANSCRIBE_MODEL, default whisper-1
+	ContextSummaryModel string                // CONTEXT_SUMMARY_MODEL, default gpt-4.1-mini
+	ContextSummaryAfter int                   // CONTEXT_SUMMARY_AFTER, default 40; 0 disables
+	ContextIdleDays     int                   // CONTEXT_IDLE_DAYS, default 30; 0 disables
+	ModelPrices         map[string][2]float64 // MODEL_PRICES, model=input/output USD per million tokens
+
+	InflightPolicy        string        // INFLIGHT_MESSAGE_POLICY: cancel (default) or queue
+	BufferMaxMessages     int           // BUFFER_MAX_MESSAGES, default 10; 0 disables
+	BufferMaxChars        int           // BUFFER_MAX_CHARS, default 2000; 0 disables
+	MaxConcurrentRuns     int           // MAX_CONCURRENT_RUNS, default 0 (no limit)
+	QueueUpdateInterval   time.Duration // QUEUE_UPDATE_SECONDS, default 45
+	TurnWorkers           int           // TURN_WORKERS, default 32
+	HTTPRetryAttempts     int           // HTTP_RETRY_ATTEMPTS, default 3
+	PricingReloadInterval time.Duration // PRICING_RELOAD_SECONDS, default 30; 0 disables
+
+	SlotsFormat         string // SLOTS_FORMAT: apps_script (default), sheets or calendar
+	SlotSeedMonthsAhead int    // SLOT_SEED_MONTHS_AHEAD, default 2
+	SlotAutoSeed        bool   // SLOT_AUTO_SEED
+
+	GroupTriggerPrefix         string   // GROUP_TRIGGER_PREFIX
+	StaffAlertUserIDs          []string // STAFF_ALERT_LINE_USER_IDS
+	FailureEscalationThreshold int      // AI_FAILURE_ESCALATION_THRESHOLD, default 3
+	ModerationStrikeLimit      int      // MODERATION_STRIKE_LIMIT, default 3; 0 never flags
+	ModerationBlocklist        []string // MODERATION_BLOCKLIST
+	ModerationOpenAI           bool     // MODERATION_OPENAI
+	LinePushQuota              int      // LINE_MONTHLY_PUSH_QUOTA; -1 (default) uses the quota LINE reports
+	LineQuotaReservePercent    int      // LINE_QUOTA_RESERVE_PERCENT, 0-100, default 10
+
+	MembershipFee      int    // MEMBERSHIP_FEE in baht; 0 (default) is not sold in chat
+	UrgentSurcharge    int    // URGENT_SURCHARGE in baht, default 500
+	HighSpenderMin     int    // SEGMENT_HIGH_SPENDER_MIN in baht, default 10000
+	QuoteValidDays     int    // QUOTE_VALID_DAYS, default 14
+	QuoteFontFile      string // QUOTE_FONT_FILE; quotations can't be drawn without it
+	PromptPayID        string // PROMPTPAY_ID
+	PaymentBankAccount string // PAYMENT_BANK_ACCOUNT
+	SlipVerifyURL      string // SLIP_VERIFY_URL
+	SlipVerifyAPIKey   string // SLIP_VERIFY_API_KEY
+	SlipOCRAutoApprove bool   // SLIP_OCR_AUTO_APPROVE
+
+	NPSSurveyDelayDays   int      // NPS_SURVEY_DELAY_DAYS, default 30
+	NPSWindowDays        int      // NPS_WINDOW_DAYS, default 90
+	NPSAlertThreshold    int      // NPS_ALERT_THRESHOLD, -100 to 100, default 30
+	NPSAlertMinResponses int      // NPS_ALERT_MIN_RESPONSES, default 10
+	NPSAlertUserIDs      []string // NPS_ALERT_LINE_USER_IDS; the staff list when empty
+
+	MembersDatabaseURL string // MEMBERS_DATABASE_URL
+	MembersTable       string // MEMBERS_TABLE, default ncs_family_members
+	MembersSheetURL    string // MEMBERS_SHEET_URL
+
+	ArchiveAfterMonths int    // ARCHIVE_AFTER_MONTHS; 0 (default) disables archival
+	ArchiveBucket      string // ARCHIVE_BUCKET; the local archive dir is used without it
+	ArchiveRegion      string // ARCHIVE_REGION, default auto
+	ArchiveEndpoint    string // ARCHIVE_ENDPOINT, default https://s3.<region>.amazonaws.com
+	ArchiveAccessKey   string // ARCHIVE_ACCESS_KEY, required with ARCHIVE_BUCKET
+	ArchiveSecretKey   string // ARCHIVE_SECRET_KEY, required with ARCHIVE_BUCKET
+
+	file     map[string]string // values from the config file
+	problems []string
+}
+
+// Defaults returns the configuration with every optional setting at its default.
+func Defaults() *Config {
+	return &Config{
+		Port:               "8080",
+		PricingFile:        "pricing_config.json",
+		SlotsURL:           DefaultSlotsURL,
+		SlotsProvider:      "apps_script",
+		SlotsTimeout:       30 * time.Second,
+		SlotsRetryAttempts: 2,
+		SlotsCacheTTL:      60 * time.Second,
+		BufferWindow:       8 * time.Second,
+		BufferTypingExtra:  7 * time.Second,
+		BufferMaxWait:      30 * time.Second,
+		AssistantTimeout:   300 * time.Second,
+		LatencyBudget:      15 * time.Second,
+		AssistantNotice:    30 * time.Second,
+		AnswerCacheTTL:     1800 * time.Second,
+		AnswerCacheSize:    20,
+		EmbeddingModel:     "text-embedding-3-small",
+		FAQMatchThreshold:  0.85,
+		LLMFallbackBackend: "chat_completions",
+		FailoverErrors:     3,
+		FailoverLatency:    60 * time.Second,
+		FailoverCooldown:   300 * time.Second,
+
+		KnowledgeDir:          "knowledge",
+		LogLevel:              "info",
+		LogFormat:             "json",
+		LogMaskPII:            true,
+		OTelServiceName:       "ncs-line-webhook",
+		LLMBackend:            "responses",
+		ResponsesMode:         "foreground",
+		TranscribeModel:       "whisper-1",
+		ContextSummaryModel:   "gpt-4.1-mini",
+		ContextSummaryAfter:   40,
+		ContextIdleDays:       30,
+		InflightPolicy:        "cancel",
+		BufferMaxMessages:     10,
+		BufferMaxChars:        2000,
+		QueueUpdateInterval:   45 * time.Second,
+		TurnWorkers:           32,
+		HTTPRetryAttempts:     3,
+		PricingReloadInterval: 30 * time.Second,
+
+		SlotsFormat:                "apps_script",
+		SlotSeedMonthsAhead:        2,
+		FailureEscalationThreshold: 3,
+		ModerationStrikeLimit:      3,
+		LinePushQuota:              -1,
+		LineQuotaReservePercent:    10,
+		UrgentSurcharge:            500,
+		HighSpenderMin:             10000,
+		QuoteValidDays:             14,
+		NPSSurveyDelayDays:         30,
+		NPSWindowDays:              90,
+		NPSAlertThreshold:          30,
+		NPSAlertMinResponses:       10,
+		MembersTable:               "ncs_family_members",
+		ArchiveRegion:              "auto",
+	}
+}
+
+// Load reads the config file at path, if any, and then the environment, which takes
+// precedence. Only a missing or unreadable file is an error here; bad values are reported
+// by Validate.
+func Load(path string) (*Config, error) {
+	c := Defaults()
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c.file = values
+	}
+
+	c.stringVar(&c.LineAccessToken, "LINE_CHANNEL_ACCESS_TOKEN")
+	c.stringVar(&c.LineChannelSecret, "LINE_CHANNEL_SECRET")
+	c.boolVar(&c.AllowUnsignedWebhooks, "ALLOW_UNSIGNED_WEBHOOKS")
+	c.stringVar(&c.OpenAIAPIKey, "CHATGPT_API_KEY")
+	c.stringVar(&c.OpenAIBaseURL, "OPENAI_BASE_URL")
+	c.stringVar(&c.AdminToken, "ADMIN_API_TOKEN")
+	c.stringVar(&c.PIIEncryptionKey, "PII_ENCRYPTION_KEY")
+	c.stringVar(&c.DataDir, "DATA_DIR")
+	c.stringVar(&c.Port, "PORT")
+	c.stringVar(&c.PricingFile, "PRICING_CONFIG_FILE")
+	c.stringVar(&c.SlotsURL, "SLOTS_URL")
+	c.stringVar(&c.SlotsProvider, "SLOTS_PROVIDER")
+	c.secondsVar(&c.SlotsTimeout, "SLOTS_TIMEOUT_SECONDS", 1)
+	c.intVar(&c.SlotsRetryAttempts, "SLOTS_RETRY_ATTEMPTS", 0)
+	c.secondsVar(&c.SlotsCacheTTL, "SLOTS_CACHE_SECONDS", 0)
+	c.stringVar(&c.GoogleSheetsID, "GOOGLE_SHEETS_ID")
+	c.stringVar(&c.GoogleSheetsAPIKey, "GOOGLE_SHEETS_API_KEY")
+	c.stringVar(&c.GoogleServiceAccountFile, "GOOGLE_SERVICE_ACCOUNT_FILE")
+	c.secondsVar(&c.BufferWindow, "BUFFER_WINDOW_SECONDS", 0)
+	c.secondsVar(&c.BufferTypingExtra, "BUFFER_TYPING_EXTRA_SECONDS", 0)
+	c.secondsVar(&c.BufferMaxWait, "BUFFER_MAX_WAIT_SECONDS", 0)
+	c.secondsVar(&c.AssistantTimeout, "ASSISTANT_TIMEOUT_SECONDS", 1)
+	c.secondsVar(&c.LatencyBudget, "ASSISTANT_LATENCY_BUDGET_SECONDS", 0)
+	c.secondsVar(&c.AssistantNotice, "ASSISTANT_NOTICE_SECONDS", 0)
+	c.secondsVar(&c.AnswerCacheTTL, "ANSWER_CACHE_SECONDS", 0)
+	c.intVar(&c.AnswerCacheSize, "ANSWER_CACHE_SIZE", 1)
+	c.stringVar(&c.EmbeddingModel, "EMBEDDING_MODEL")
+	c.fractionVar(&c.FAQMatchThreshold, "FAQ_MATCH_THRESHOLD")
+	c.stringVar(&c.LLMFallbackBaseURL, "LLM_FALLBACK_BASE_URL")
+	c.stringVar(&c.LLMFallbackAPIKey, "LLM_FALLBACK_API_KEY")
+	c.stringVar(&c.LLMFallbackBackend, "LLM_FALLBACK_BACKEND")
+	c.stringVar(&c.LLMFallbackModel, "LLM_FALLBACK_MODEL")
+	c.intVar(&c.FailoverErrors, "LLM_FAILOVER_ERRORS", 1)
+	c.secondsVar(&c.FailoverLatency, "LLM_FAILOVER_LATENCY_SECONDS", 1)
+	c.secondsVar(&c.FailoverCooldown, "LLM_FAILOVER_COOLDOWN_SECONDS", 1)
+
+	c.stringVar(&c.PublicBaseURL, "PUBLIC_BASE_URL")
+	c.PublicBaseURL = strings.TrimRight(c.PublicBaseURL, "/")
+	c.stringVar(&c.KnowledgeDir, "KNOWLEDGE_DIR")
+	c.stringVar(&c.VectorStoreID, "OPENAI_VECTOR_STORE_ID")
+	c.stringVar(&c.DatabaseURL, "DATABASE_URL")
+
+	c.choiceVar(&c.LogLevel, "LOG_LEVEL", "info", "debug")
+	c.choiceVar(&c.LogFormat, "LOG_FORMAT", "json", "text")
+	c.boolVar(&c.LogRedactContent, "LOG_REDACT_CONTENT")
+	c.boolVar(&c.LogMaskPII, "LOG_MASK_PII")
+	c.stringVar(&c.OTLPTracesEndpoint, "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	c.stringVar(&c.OTLPEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	c.pairsVar(&c.OTLPHeaders, "OTEL_EXPORTER_OTLP_HEADERS")
+	c.stringVar(&c.OTelServiceName, "OTEL_SERVICE_NAME")
+	c.boolVar(&c.ReadyzOpenAIPing, "READYZ_OPENAI_PING")
+
+	c.choiceVar(&c.LLMBackend, "LLM_BACKEND", "responses", "chat_completions")
+	c.choiceVar(&c.ResponsesMode, "OPENAI_RESPONSES_MODE", "foreground", "background")
+	c.stringVar(&c.OpenAIWebhookSecret, "OPENAI_WEBHOOK_SECRET")
+	c.stringVar(&c.TranscribeModel, "TRANSCRIBE_MODEL")
+	c.stringVar(&c.ContextSummaryModel, "CONTEXT_SUMMARY_MODEL")
+	c.intVar(&c.ContextSummaryAfter, "CONTEXT_SUMMARY_AFTER", 0)
+	c.intVar(&c.ContextIdleDays, "CONTEXT_IDLE_DAYS", 0)
+	c.modelPricesVar(&c.ModelPrices, "MODEL_PRICES")
+
+	c.choiceVar(&c.InflightPolicy, "INFLIGHT_MESSAGE_POLICY", "cancel", "queue")
+	c.intVar(&c.BufferMaxMessages, "BUFFER_MAX_MESSAGES", 0)
+	c.intVar(&c.BufferMaxChars, "BUFFER_MAX_CHARS", 0)
+	c.intVar(&c.MaxConcurrentRuns, "MAX_CONCURRENT_RUNS", 0)
+	c.secondsVar(&c.QueueUpdateInterval, "QUEUE_UPDATE_SECONDS", 1)
+	c.intVar(&c.TurnWorkers, "TURN_WORKERS", 1)
+	c.intVar(&c.HTTPRetryAttempts, "HTTP_RETRY_ATTEMPTS", 0)
+	c.secondsVar(&c.PricingReloadInterval, "PRICING_RELOAD_SECONDS", 0)
+
+	c.choiceVar(&c.SlotsFormat, "SLOTS_FORMAT", "apps_script", "sheets", "calendar")
+	c.intVar(&c.SlotSeedMonthsAhead, "SLOT_SEED_MONTHS_AHEAD", 0)
+	c.boolVar(&c.SlotAutoSeed, "SLOT_AUTO_SEED")
+
+	c.stringVar(&c.GroupTriggerPrefix, "GROUP_TRIGGER_PREFIX")
+	c.listVar(&c.StaffAlertUserIDs, "STAFF_ALERT_LINE_USER_IDS")
+	c.intVar(&c.FailureEscalationThreshold, "AI_FAILURE_ESCALATION_THRESHOLD", 1)
+	c.intVar(&c.ModerationStrikeLimit, "MODERATION_STRIKE_LIMIT", 0)
+	c.listVar(&c.ModerationBlocklist, "MODERATION_BLOCKLIST")
+	c.boolVar(&c.ModerationOpenAI, "MODERATION_OPENAI")
+	c.intVar(&c.LinePushQuota, "LINE_MONTHLY_PUSH_QUOTA", 0)
+	c.intVar(&c.LineQuotaReservePercent, "LINE_QUOTA_RESERVE_PERCENT", 0)
+
+	c.intVar(&c.MembershipFee, "MEMBERSHIP_FEE", 0)
+	c.intVar(&c.UrgentSurcharge, "URGENT_SURCHARGE", 0)
+	c.intVar(&c.HighSpenderMin, "SEGMENT_HIGH_SPENDER_MIN", 1)
+	c.intVar(&c.QuoteValidDays, "QUOTE_VALID_DAYS", 1)
+	c.stringVar(&c.QuoteFontFile, "QUOTE_FONT_FILE")
+	c.stringVar(&c.PromptPayID, "PROMPTPAY_ID")
+	c.stringVar(&c.PaymentBankAccount, "PAYMENT_BANK_ACCOUNT")
+	c.stringVar(&c.SlipVerifyURL, "SLIP_VERIFY_URL")
+	c.stringVar(&c.SlipVerifyAPIKey, "SLIP_VERIFY_API_KEY")
+	c.boolVar(&c.SlipOCRAutoApprove, "SLIP_OCR_AUTO_APPROVE")
+
+	c.intVar(&c.NPSSurveyDelayDays, "NPS_SURVEY_DELAY_DAYS", 0)
+	c.intVar(&c.NPSWindowDays, "NPS_WINDOW_DAYS", 1)
+	c.intVar(&c.NPSAlertThreshold, "NPS_ALERT_THRESHOLD", -100)
+	c.intVar(&c.NPSAlertMinResponses, "NPS_ALERT_MIN_RESPONSES", 1)
+	c.listVar(&c.NPSAlertUserIDs, "NPS_ALERT_LINE_USER_IDS")
+
+	c.stringVar(&c.MembersDatabaseURL, "MEMBERS_DATABASE_URL")
+	c.stringVar(&c.MembersTable, "MEMBERS_TABLE")
+	c.stringVar(&c.MembersSheetURL, "MEMBERS_SHEET_URL")
+
+	c.intVar(&c.ArchiveAfterMonths, "ARCHIVE_AFTER_MONTHS", 0)
+	c.stringVar(&c.ArchiveBucket, "ARCHIVE_BUCKET")
+	c.stringVar(&c.ArchiveRegion, "ARCHIVE_REGION")
+	c.stringVar(&c.ArchiveEndpoint, "ARCHIVE_ENDPOINT")
+	c.ArchiveEndpoint = strings.TrimRight(c.ArchiveEndpoint, "/")
+	c.stringVar(&c.ArchiveAccessKey, "ARCHIVE_ACCESS_KEY")
+	c.stringVar(&c.ArchiveSecretKey, "ARCHIVE_SECRET_KEY")
+	return c, nil
+}
+
+// Value returns a setting whose name is only known at run time, such as the credentials of
+// a channel added in channels.json, from the environment or the config file.
+func (c *Config) Value(key string) string {
+	if v, set := os.LookupEnv(key); set {
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(c.file[key])
+}
+
+// Validate reports every missing required setting and malformed value, one per line.
+func (c *Config) Validate() error {
+	problems := append([]string(nil), c.problems...)
+	if c.LineAccessToken == "" {
+		problems = append(problems, "LINE_CHANNEL_ACCESS_TOKEN is required")
+	}
+	if c.OpenAIAPIKey == "" {
+		problems = append(problems, "CHATGPT_API_KEY is required")
+	}
+	if n, err := strconv.Atoi(c.Port); err != nil || n <= 0 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT %q is not a port number", c.Port))
+	}
+	if !strings.HasPrefix(c.SlotsURL, "https://") {
+		problems = append(problems, fmt.Sprintf("SLOTS_URL %q must be an https:// URL", c.SlotsURL))
+	}
+	switch c.SlotsProvider {
+	case "apps_script":
+	case "sheets_api":
+		if c.GoogleSheetsID == "" || (c.GoogleSheetsAPIKey == "" && c.GoogleServiceAccountFile == "") {
+			problems = append(problems, "SLOTS_PROVIDER=sheets_api needs GOOGLE_SHEETS_ID and GOOGLE_SERVICE_ACCOUNT_FILE or GOOGLE_SHEETS_API_KEY")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("SLOTS_PROVIDER %q must be apps_script or sheets_api", c.SlotsProvider))
+	}
+	if c.PIIEncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.PIIEncryptionKey); err != nil || len(key) != 32 {
+			problems = append(problems, "PII_ENCRYPTION_KEY must be 32 bytes in base64")
+		}
+	}
+	if c.OpenAIBaseURL != "" && !strings.HasPrefix(c.OpenAIBaseURL, "http://") && !strings.HasPrefix(c.OpenAIBaseURL, "https://") {
+		problems = append(problems, fmt.Sprintf("OPENAI_BASE_URL %q must be an http(s) URL", c.OpenAIBaseURL))
+	}
+	if c.LLMFallbackBaseURL != "" {
+		if !strings.HasPrefix(c.LLMFallbackBaseURL, "http://") && !strings.HasPrefix(c.LLMFallbackBaseURL, "https://") {
+			problems = append(problems, fmt.Sprintf("LLM_FALLBACK_BASE_URL %q must be an http(s) URL", c.LLMFallbackBaseURL))
+		}
+		if c.LLMFallbackAPIKey == "" {
+			problems = append(problems, "LLM_FALLBACK_BASE_URL needs LLM_FALLBACK_API_KEY")
+		}
+		if c.LLMFallbackBackend != "chat_completions" && c.LLMFallbackBackend != "responses" {
+			problems = append(problems, fmt.Sprintf("LLM_FALLBACK_BACKEND %q must be chat_completions or responses", c.LLMFallbackBackend))
+		}
+	}
+	for _, u := range []struct{ key, url string }{
+		{"PUBLIC_BASE_URL", c.PublicBaseURL},
+		{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", c.OTLPTracesEndpoint},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", c.OTLPEndpoint},
+		{"SLIP_VERIFY_URL", c.SlipVerifyURL},
+		{"MEMBERS_SHEET_URL", c.MembersSheetURL},
+		{"ARCHIVE_ENDPOINT", c.ArchiveEndpoint},
+	} {
+		if u.url != "" && !strings.HasPrefix(u.url, "http://") && !strings.HasPrefix(u.url, "https://") {
+			problems = append(problems, fmt.Sprintf("%s %q must be an http(s) URL", u.key, u.url))
+		}
+	}
+	if c.LineQuotaReservePercent > 100 {
+		problems = append(problems, fmt.Sprintf("LINE_QUOTA_RESERVE_PERCENT %d must be at most 100", c.LineQuotaReservePercent))
+	}
+	if c.NPSAlertThreshold > 100 {
+		problems = append(problems, fmt.Sprintf("NPS_ALERT_THRESHOLD %d must be at most 100", c.NPSAlertThreshold))
+	}
+	if c.ArchiveBucket != "" && (c.ArchiveAccessKey == "" || c.ArchiveSecretKey == "") {
+		problems = append(problems, "ARCHIVE_BUCKET needs ARCHIVE_ACCESS_KEY and ARCHIVE_SECRET_KEY")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
+
+func (c *Config) stringVar(dest *string, key string) {
+	if v := c.Value(key); v != "" {
+		*dest = v
+	}
+}
+
+// boolVar reads true or false.
+func (c *Config) boolVar(dest *bool, key string) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s %q must be true or false", key, v))
+		return
+	}
+	*dest = b
+}
+
+// choiceVar reads one of choices, in any case.
+func (c *Config) choiceVar(dest *string, key string, choices ...string) {
+	v := strings.ToLower(c.Value(key))
+	if v == "" {
+		return
+	}
+	for _, choice := range choices {
+		if v == choice {
+			*dest = v
+			return
+		}
+	}
+	c.problems = append(c.problems, fmt.Sprintf("%s %q must be one of %s", key, v, strings.Join(choices, ", ")))
+}
+
+// listVar reads a comma-separated list, skipping empty entries.
+func (c *Config) listVar(dest *[]string, key string) {
+	var list []string
+	for _, item := range strings.Split(c.Value(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) > 0 {
+		*dest = list
+	}
+}
+
+// pairsVar reads comma-separated key=value pairs.
+func (c *Config) pairsVar(dest *map[string]string, key string) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			c.problems = append(c.problems, fmt.Sprintf("%s entry %q must be key=value", key, strings.TrimSpace(pair)))
+			continue
+		}
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	*dest = pairs
+}
+
+// modelPricesVar reads comma-separated model=input/output prices, e.g. gpt-4.1-mini=0.40/1.60.
+func (c *Config) modelPricesVar(dest *map[string][2]float64, key string) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	prices := make(map[string][2]float64)
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, pair, ok := strings.Cut(entry, "=")
+		in, out, ok2 := strings.Cut(pair, "/")
+		inPrice, err1 := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		outPrice, err2 := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if !ok || !ok2 || strings.TrimSpace(name) == "" || err1 != nil || err2 != nil || inPrice < 0 || outPrice < 0 {
+			c.problems = append(c.problems, fmt.Sprintf("%s entry %q must be model=input/output", key, entry))
+			continue
+		}
+		prices[strings.TrimSpace(name)] = [2]float64{inPrice, outPrice}
+	}
+	*dest = prices
+}
+
+// secondsVar reads a whole number of seconds no smaller than min.
+func (c *Config) secondsVar(dest *time.Duration, key string, min int) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		c.problems = append(c.problems, fmt.Sprintf("%s %q must be a whole number of seconds, at least %d", key, v, min))
+		return
+	}
+	*dest = time.Duration(n) * time.Second
+}
+
+// intVar reads a whole number no smaller than min.
+func (c *Config) intVar(dest *int, key string, min int) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		c.problems = append(c.problems, fmt.Sprintf("%s %q must be a whole number, at least %d", key, v, min))
+		return
+	}
+	*dest = n
+}
+
+// fractionVar reads a number between 0 and 1.
+func (c *Config) fractionVar(dest *float64, key string) {
+	v := c.Value(key)
+	if v == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		c.problems = append(c.problems, fmt.Sprintf("%s %q must be a number between 0 and 1", key, v))
+		return
+	}
+	*dest = f
+}
+
+// readFile reads a flat YAML mapping of setting names to values:
+//
+//	# comments and blank lines are skipped
+//	BUFFER_WINDOW_SECONDS: 5
+//	slots_url: "https://script.google.com/macros/s/.../exec"
+//
+// Keys are the env variable names, in either case. Nested mappings and lists are rejected.
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || raw[0] == ' ' || raw[0] == '\t' || strings.HasPrefix(text, "- ") {
+			return nil, fmt.Errorf("config file %s:%d: expected KEY: value", path, line)
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		value, err := yamlScalar(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("config file %s:%d: %s: %v", path, line, key, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	return values, nil
+}
+
+// yamlScalar unquotes a value and drops a trailing comment.
+func yamlScalar(v string) (string, error) {
+	if strings.HasPrefix(v, `"`) {
+		end := strings.LastIndex(v, `"`)
+		if end == 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return strconv.Unquote(v[:end+1])
+	}
+	if strings.HasPrefix(v, "'") {
+		end := strings.LastIndex(v, "'")
+		if end == 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return strings.ReplaceAll(v[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") || strings.HasPrefix(v, "|") || strings.HasPrefix(v, ">") {
+		return "", errors.New("only single-line scalar values are supported")
+	}
+	return v, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every turn sends the conversation history to the model, so a customer who comes back after
+// months would pay for (and be answered in the light of) a long-finished chat. Once a
+// conversation has been idle for CONTEXT_IDLE_DAYS, the messages since the last reset are
+// stored as a session transcript at sessions/<userId>/<last seen>.json.gz in the archive
+// storage, and the model history starts over from the next message. The messages stay in the
+// conversation for staff; only what the model sees is reset.
+
+// contextIdleDays reads CONTEXT_IDLE_DAYS (default 30); 0 disables the reset.
+func contextIdleDays() int {
+	return appConfig.ContextIdleDays
+}
+
+// SessionTranscript is the part of a conversation the model saw before a reset.
+type SessionTranscript struct {
+	UserID   string                `json:"user_id"`
+	From     string                `json:"from,omitempty"`    // previous reset or summary, "" for the first session
+	Summary  string                `json:"summary,omitempty"` // of the messages before From
+	Messages []ConversationMessage `json:"messages"`
+}
+
+func sessionKey(userId, lastSeen string) string {
+	day := strings.ReplaceAll(strings.SplitN(lastSeen, "T", 2)[0], "-", "")
+	return "sessions/" + fileNameUnsafe.ReplaceAllString(userId, "_") + "/" + day + ".json.gz"
+}
+
+// contextMessages returns the messages the model sees: those after the last reset or summary.
+// Caller must hold userThreadLock.
+func contextMessages(conv *UserConversation) []ConversationMessage {
+	if conv.ContextFrom == "" {
+		return conv.Messages
+	}
+	for i, m := range conv.Messages {
+		if m.Timestamp >= conv.ContextFrom {
+			return conv.Messages[i:]
+		}
+	}
+	return nil
+}
+
+// runContextReset resets the model history of conversations idle for at least days and
+// returns how many were reset.
+func runContextReset(ctx context.Context, days int) (int, error) {
+	cutoff := bangkokNow().AddDate(0, 0, -days).Format("2006-01-02T15:04:05")
+	type candidate struct {
+		key      string
+		lastSeen string
+		data     []byte // transcript JSON, marshalled under the lock
+	}
+	candidates := make(map[string]candidate)
+	userThreadLock.Lock()
+	for uid, conv := range userConversations {
+		if isSimulatedUser(uid) || conv.Takeover || conv.WantsHuman || conv.LastSeen == "" || conv.LastSeen >= cutoff {
+			continue
+		}
+		msgs := contextMessages(conv)
+		if len(msgs) == 0 {
+			continue // already reset
+		}
+		data, err := json.Marshal(SessionTranscript{UserID: uid, From: conv.ContextFrom, Summary: conv.ContextSummary, Messages: msgs})
+		if err != nil {
+			log.Printf("Failed to marshal session transcript of %s: %v", uid, err)
+			continue
+		}
+		candidates[uid] = candidate{key: sessionKey(uid, conv.LastSeen), lastSeen: conv.LastSeen, data: data}
+	}
+	userThreadLock.Unlock()
+
+	reset := 0
+	defer func() {
+		if reset > 0 {
+			saveConversations()
+			appMetrics.add("contexts_reset", int64(reset))
+			log.Printf("Reset the model history of %d conversation(s) idle since before %s", reset, cutoff)
+		}
+	}()
+	for uid, cand := range candidates {
+		if err := ctx.Err(); err != nil {
+			return reset, err
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(cand.data); err != nil {
+			return reset, fmt.Errorf("failed to compress session of %s: %w", uid, err)
+		}
+		if err := gz.Close(); err != nil {
+			return reset, fmt.Errorf("failed to compress session of %s: %w", uid, err)
+		}
+		if err := coldStore.Put(ctx, cand.key, buf.Bytes()); err != nil {
+			return reset, fmt.Errorf("failed to upload session of %s: %w", uid, err)
+		}
+
+		// Reset only if the customer didn't come back during the upload
+		userThreadLock.Lock()
+		if conv, ok := userConversations[uid]; ok && conv.LastSeen == cand.lastSeen {
+			conv.ContextFrom = getBangkokTime()
+			conv.ContextSummary = ""
+			delete(userAnswerCache, uid)
+			reset++
+		}
+		userThreadLock.Unlock()
+	}
+	return reset, nil
+}
+
+// startContextResetJob resets idle conversations daily at 03:15 Bangkok time, between
+// archival and the retention purge.
+func startContextResetJob() {
+	days := contextIdleDays()
+	if days == 0 {
+		return
+	}
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 3, 15, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			if _, err := runContextReset(ctx, days); err != nil {
+				log.Printf("Context reset failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// handleRunContextReset runs the reset now; ?days= overrides CONTEXT_IDLE_DAYS.
+func handleRunContextReset(c *fiber.Ctx) error {
+	days := c.QueryInt("days", contextIdleDays())
+	if days <= 0 {
+		return respondError(c, fiber.StatusBadRequest, "days must be set (query or CONTEXT_IDLE_DAYS)")
+	}
+	reset, err := runContextReset(c.Context(), days)
+	if err != nil {
+		log.Printf("Context reset failed: %v", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error(), "reset": reset})
+	}
+	return c.JSON(fiber.Map{"status": "ok", "reset": reset})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Long conversations make every turn slower and more expensive. When the model history of a
+// conversation passes CONTEXT_SUMMARY_AFTER messages, everything but the last
+// contextSummaryKeep messages is summarized with a cheap model (CONTEXT_SUMMARY_MODEL). The
+// summary replaces those messages in the model history: it is sent as a developer note before
+// the kept messages, and the next summary folds it in. Staff still see every message.
+
+const contextSummaryKeep = 12
+
+const contextSummaryInstructions = `สรุปบทสนทนาระหว่างลูกค้ากับบอทของ NCS (บริการทำความสะอาดที่นอน โซฟา ม่าน พรม) เป็นภาษาไทยไม่เกิน 15 บรรทัด เพื่อให้บอทคุยต่อได้โดยไม่ต้องอ่านข้อความเก่า
+เก็บข้อมูลที่ยังต้องใช้: บริการและรายการที่สนใจ ขนาด/จำนวน ราคาที่เสนอไปแล้ว วันนัดหรือการจอง ที่อยู่/พื้นที่ ข้อกังวลหรือปัญหาที่ลูกค้าแจ้ง และสิ่งที่บอทรับปากไว้
+ถ้ามีสรุปเดิมมาด้วย ให้รวมเข้าไปในสรุปใหม่ ห้ามเดาข้อมูลที่ไม่มีในบทสนทนา`
+
+// contextSummaryAfter reads CONTEXT_SUMMARY_AFTER (default 40); 0 disables summaries.
+func contextSummaryAfter() int {
+	n := appConfig.ContextSummaryAfter
+	if n > 0 && n <= contextSummaryKeep {
+		return contextSummaryKeep + 1
+	}
+	return n
+}
+
+func contextSummaryModel() string {
+	return appConfig.ContextSummaryModel
+}
+
+var (
+	contextSummaryLock sync.Mutex
+	contextSummarizing = make(map[string]bool)
+)
+
+// maybeSummarizeContext summarizes the older part of the user's model history once it is over
+// the limit. Run it in a goroutine after a turn has replied.
+func maybeSummarizeContext(userId string) {
+	after := contextSummaryAfter()
+	if after == 0 || isSimulatedUser(userId) {
+		return
+	}
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		userThreadLock.Unlock()
+		return
+	}
+	msgs := contextMessages(conv)
+	if len(msgs) < after {
+		userThreadLock.Unlock()
+		return
+	}
+	older := make([]ConversationMessage, len(msgs)-contextSummaryKeep)
+	copy(older, msgs)
+	keepFrom := msgs[len(msgs)-contextSummaryKeep].Timestamp
+	previous, contextFrom := conv.ContextSummary, conv.ContextFrom
+	userThreadLock.Unlock()
+
+	contextSummaryLock.Lock()
+	if contextSummarizing[userId] {
+		contextSummaryLock.Unlock()
+		return
+	}
+	contextSummarizing[userId] = true
+	contextSummaryLock.Unlock()
+	defer func() {
+		contextSummaryLock.Lock()
+		delete(contextSummarizing, userId)
+		contextSummaryLock.Unlock()
+	}()
+
+	input := transcriptText(older)
+	if previous != "" {
+		input = "สรุปเดิม:\n" + previous + "\n\nข้อความต่อจากนั้น:\n" + input
+	}
+	ctx, cancel := context.WithTimeout(withUsageUser(context.Background(), userId), 60*time.Second)
+	summary, err := requestOpenAIText(ctx, contextSummaryModel(), contextSummaryInstructions, input)
+	cancel()
+	if err != nil {
+		log.Printf("Context summary for %s failed (%s): %v", userId, errorKind(err), err)
+		appMetrics.inc("context_summary_failures")
+		return
+	}
+
+	userThreadLock.Lock()
+	// a reset or another summary in the meantime makes this one stale
+	if conv, ok := userConversations[userId]; ok && conv.ContextFrom == contextFrom {
+		conv.ContextSummary = summary
+		conv.ContextFrom = keepFrom
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	log.Printf("Summarized %d older messages of %s", len(older), userId)
+	appMetrics.inc("context_summaries")
+}
+
+// contextSummaryNote introduces the summary of the messages the model no longer sees.
+func contextSummaryNote(summary string) string {
+	return "สรุปบทสนทนาก่อนหน้านี้กับลูกค้า (ข้อความเก่ากว่านี้ไม่ได้แสดง):\n" + summary
+}
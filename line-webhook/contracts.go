@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Contract is a prepaid annual package (2–5 items/year) that the customer books against
+// instead of paying per visit.
+type Contract struct {
+	ID                string          `json:"id"`
+	UserID            string          `json:"user_id"`
+	ServiceKey        string          `json:"service_key"` // pricing service the contract covers
+	Items             int             `json:"items"`       // items included per contract year
+	Price             int             `json:"price"`       // baht paid for the contract
+	StartDate         string          `json:"start_date"`  // YYYY-MM-DD
+	ExpiresOn         string          `json:"expires_on"`  // YYYY-MM-DD, last day bookings may fall on
+	Status            string          `json:"status"`      // "active", "expired" or "cancelled"
+	Usages            []ContractUsage `json:"usages"`
+	RenewedBy         string          `json:"renewed_by,omitempty"` // ID of the follow-up contract
+	RenewalRemindedAt time.Time       `json:"renewal_reminded_at,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// ContractUsage records items of a contract consumed by one booking.
+type ContractUsage struct {
+	BookingID string `json:"booking_id"`
+	Date      string `json:"date"`
+	Items     int    `json:"items"`
+}
+
+// contractRenewalWindow is how long before expiry the customer is reminded to renew.
+const contractRenewalWindow = 30 * 24 * time.Hour
+
+var contractsFile = "contracts.json"
+
+var (
+	contractLock sync.Mutex
+	contracts    []*Contract
+)
+
+func (c *Contract) itemsUsed() int {
+	used := 0
+	for _, u := range c.Usages {
+		used += u.Items
+	}
+	return used
+}
+
+func (c *Contract) remaining() int {
+	return c.Items - c.itemsUsed()
+}
+
+// activeContracts returns the user's active contracts that still have items left, soonest expiry first.
+func activeContracts(userId string) []Contract {
+	today := bangkokNow().Format("2006-01-02")
+	contractLock.Lock()
+	defer contractLock.Unlock()
+	var result []Contract
+	for _, c := range contracts {
+		if c.UserID == userId && c.Status == "active" && c.ExpiresOn >= today && c.remaining() > 0 {
+			result = append(result, *c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ExpiresOn < result[j].ExpiresOn })
+	return result
+}
+
+func contractServiceName(serviceKey string) string {
+	if pricingConfig != nil {
+		if svc, ok := pricingConfig.Services[serviceKey]; ok {
+			return svc.Name
+		}
+	}
+	return serviceKey
+}
+
+// describeMyContracts handles the get_my_contracts tool.
+func describeMyContracts(userId string) string {
+	active := activeContracts(userId)
+	if len(active) == 0 {
+		return "ลูกค้าไม่มีสัญญาที่ยังใช้สิทธิ์ได้ ใช้ get_ncs_pricing เสนอราคาตามปกติ"
+	}
+	var b strings.Builder
+	for i, c := range active {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "สัญญาเลขที่ %s บริการ%s\nใช้ไปแล้ว %d จาก %d ชิ้น (เหลือ %d ชิ้น)\nใช้สิทธิ์ได้ถึง %s",
+			c.ID, contractServiceName(c.ServiceKey), c.itemsUsed(), c.Items, c.remaining(), formatThaiDate(c.ExpiresOn))
+	}
+	b.WriteString("\nจองบริการที่อยู่ในสัญญาด้วย book_with_contract โดยไม่ต้องเสนอราคา")
+	return b.String()
+}
+
+// contractQuoteNote reminds the model not to quote full price to a customer with a usable contract.
+func contractQuoteNote(userId, serviceType string) string {
+	serviceKey := pricingEngine().ServiceKey(serviceType)
+	for _, c := range activeContracts(userId) {
+		if c.ServiceKey == serviceKey {
+			return fmt.Sprintf("\n📄 ลูกค้ามีสัญญา %s เหลือสิทธิ์ %d ชิ้น (ถึง %s) ให้จองด้วย book_with_contract แทนการคิดราคาเต็ม",
+				c.ID, c.remaining(), formatThaiDate(c.ExpiresOn))
+		}
+	}
+	return ""
+}
+
+// ContractBookingItem is one item the customer wants serviced under a contract.
+type ContractBookingItem struct {
+	ItemType string `json:"item_type"`
+	Size     string `json:"size,omitempty"`
+	Quantity int    `json:"quantity"`
+}
+
+// contractBookingItems resolves the tool's item names to pricing keys.
+func contractBookingItems(serviceKey string, items []ContractBookingItem) ([]BookingItem, int, error) {
+	if len(items) == 0 {
+		return nil, 0, fmt.Errorf("at least one item is required")
+	}
+	engine := pricingEngine()
+	var result []BookingItem
+	count := 0
+	for _, it := range items {
+		itemKey := engine.ItemKey(it.ItemType)
+		if itemKey == "" {
+			return nil, 0, fmt.Errorf("unknown item '%s'", it.ItemType)
+		}
+		qty := it.Quantity
+		if qty <= 0 {
+			qty = 1
+		}
+		sizeKey := ""
+		if it.Size != "" {
+			sizeKey = engine.SizeKey(it.Size, pricingConfig.Items[itemKey].Sizes)
+		}
+		result = append(result, BookingItem{ServiceKey: serviceKey, ItemKey: itemKey, SizeKey: sizeKey, Quantity: qty})
+		count += qty
+	}
+	return result, count, nil
+}
+
+func bookWithContractSummary(args map[string]interface{}) string {
+	date, _ := args["date"].(string)
+	slot, _ := args["time_slot"].(string)
+	var parts []string
+	if items, ok := args["items"].([]interface{}); ok {
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			name, _ := item["item_type"].(string)
+			qty, _ := item["quantity"].(float64)
+			if qty == 0 {
+				qty = 1
+			}
+			parts = append(parts, fmt.Sprintf("%s x%d", name, int(qty)))
+		}
+	}
+	return fmt.Sprintf("จองคิววันที่ %s %s ใช้สิทธิ์สัญญา: %s (ไม่มีค่าใช้จ่ายเพิ่ม)", formatThaiDate(bookingDate(date)), slot, strings.Join(parts, ", "))
+}
+
+// bookWithContract handles the confirmed book_with_contract tool: it checks and reserves the
+// slot like create_booking and creates a booking paid for by the customer's contract,
+// recording the items used. The items are given back if the slot can't be reserved.
+func bookWithContract(userId, contractID, date, timeSlot, address string, items []ContractBookingItem) (string, error) {
+	toolErr := func(msg string, err error) (string, error) {
+		return msg, &ToolError{Tool: "book_with_contract", Err: err}
+	}
+	timeSlot, msg, err := bookableSlot("book_with_contract", userId, date, timeSlot)
+	if err != nil {
+		return msg, err
+	}
+
+	contractLock.Lock()
+	var contract *Contract
+	for _, c := range contracts {
+		if c.UserID != userId || c.Status != "active" || (contractID != "" && c.ID != contractID) {
+			continue
+		}
+		if date < c.StartDate || date > c.ExpiresOn || c.remaining() == 0 {
+			continue
+		}
+		if contract == nil || c.ExpiresOn < contract.ExpiresOn {
+			contract = c
+		}
+	}
+	if contract == nil {
+		contractLock.Unlock()
+		return toolErr("ไม่พบสัญญาที่ใช้สิทธิ์ได้ในวันที่นี้ (อาจหมดอายุหรือใช้สิทธิ์ครบแล้ว) ตรวจสอบด้วย get_my_contracts หรือเสนอราคาตามปกติ", fmt.Errorf("no usable contract on %s", date))
+	}
+	bookingItems, count, err := contractBookingItems(contract.ServiceKey, items)
+	if err != nil {
+		contractLock.Unlock()
+		return toolErr("ข้อมูลรายการไม่ถูกต้อง: "+err.Error(), err)
+	}
+	if count > contract.remaining() {
+		contractLock.Unlock()
+		return toolErr(fmt.Sprintf("สัญญาเหลือสิทธิ์ %d ชิ้น แต่ขอจอง %d ชิ้น ส่วนที่เกินให้เสนอราคาแยกด้วย get_ncs_pricing", contract.remaining(), count), fmt.Errorf("contract has %d items left, %d requested", contract.remaining(), count))
+	}
+
+	now := time.Now()
+	booking := &Booking{
+		ID:            fmt.Sprintf("bk_%d", now.UnixNano()),
+		UserID:        userId,
+		Date:          date,
+		TimeSlot:      timeSlot,
+		Address:       strings.TrimSpace(address),
+		Items:         bookingItems,
+		DepositStatus: "waived",
+		Status:        "confirmed",
+		ContractID:    contract.ID,
+		Notes:         "ใช้สิทธิ์สัญญา " + contract.ID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	// claimed before reserving so a second booking can't spend the same items meanwhile
+	contract.Usages = append(contract.Usages, ContractUsage{BookingID: booking.ID, Date: date, Items: count})
+	remaining := contract.remaining()
+	contractLock.Unlock()
+
+	reserved, msg, err := holdBookingSlot("book_with_contract", userId, booking)
+	if err != nil {
+		releaseContractUsage(booking.ContractID, booking.ID)
+		return msg, err
+	}
+
+	bookingLock.Lock()
+	bookings = append(bookings, booking)
+	bookingLock.Unlock()
+	go saveContracts()
+	go saveBookings()
+	applyBookingToProfile(booking, "")
+	emitOutboundEvent("booking.created", booking.UserID, *booking)
+	appMetrics.inc("contract_bookings")
+	log.Printf("Booked %d items against contract %s for user %s on %s", count, booking.ContractID, userId, date)
+
+	alert := fmt.Sprintf("📅 คิวใหม่จากสัญญา %s %s\n%s %s: %s\n%s", booking.ContractID, booking.ID,
+		formatThaiDate(date), timeSlot, bookingItemNames(booking), booking.Address)
+	if !reserved {
+		alert += "\n⚠️ ปฏิทินนี้ลงคิวอัตโนมัติไม่ได้ กรุณาลงคิวในปฏิทินด้วย"
+	}
+	go alertStaff(userId, alert, "booking")
+
+	return fmt.Sprintf("จองคิวเลขที่ %s วันที่ %s %s เรียบร้อย ใช้สิทธิ์สัญญา %d ชิ้น เหลืออีก %d ชิ้น ไม่มีค่าใช้จ่ายเพิ่ม",
+		booking.ID, formatThaiDate(date), timeSlot, count, remaining), nil
+}
+
+// releaseContractUsage gives back the items of a cancelled contract booking.
+func releaseContractUsage(contractID, bookingID string) {
+	contractLock.Lock()
+	defer contractLock.Unlock()
+	for _, c := range contracts {
+		if c.ID != contractID {
+			continue
+		}
+		for i, u := range c.Usages {
+			if u.BookingID == bookingID {
+				c.Usages = append(c.Usages[:i], c.Usages[i+1:]...)
+				go saveContracts()
+				return
+			}
+		}
+	}
+}
+
+// runContractMaintenance expires lapsed contracts and reminds customers whose contract
+// ends within the renewal window.
+func runContractMaintenance() {
+	now := bangkokNow()
+	today := now.Format("2006-01-02")
+	reminderHorizon := now.Add(contractRenewalWindow).Format("2006-01-02")
+
+	type reminder struct {
+		userId string
+		text   string
+	}
+	var reminders []reminder
+	contractLock.Lock()
+	for _, c := range contracts {
+		if c.Status != "active" {
+			continue
+		}
+		if c.ExpiresOn < today {
+			c.Status = "expired"
+			log.Printf("Contract %s for user %s expired with %d unused items", c.ID, c.UserID, c.remaining())
+			continue
+		}
+		if c.RenewedBy == "" && c.RenewalRemindedAt.IsZero() && c.ExpiresOn <= reminderHorizon {
+			c.RenewalRemindedAt = time.Now()
+			text := fmt.Sprintf("สัญญาบริการ%sของคุณลูกค้าจะหมดอายุวันที่ %s ค่ะ", contractServiceName(c.ServiceKey), formatThaiDate(c.ExpiresOn))
+			if left := c.remaining(); left > 0 {
+				text += fmt.Sprintf(" ยังเหลือสิทธิ์อีก %d ชิ้น จองคิวก่อนหมดอายุได้เลยนะคะ", left)
+			}
+			text += " หากต้องการต่ออายุสัญญาตอบกลับข้อความนี้ได้เลย เจ้าหน้าที่จะดำเนินการให้ค่ะ 🙏"
+			reminders = append(reminders, reminder{c.UserID, text})
+		}
+	}
+	contractLock.Unlock()
+	go saveContracts()
+
+	for _, r := range reminders {
+		if err := pushLineMessageWithPriority(r.userId, r.text, pushTransactional, "contract_renewal"); err != nil {
+			log.Printf("Failed to send contract renewal reminder to %s: %v", r.userId, err)
+		}
+	}
+	appMetrics.add("contract_renewal_reminders", int64(len(reminders)))
+}
+
+// startContractJob runs contract maintenance every day at 10:00 Bangkok time.
+func startContractJob() {
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+			runContractMaintenance()
+		}
+	}()
+}
+
+// checkContractUsage flags contract bookings whose contract no longer records them.
+func checkContractUsage(ctx context.Context) ([]ReconciliationIssue, error) {
+	recorded := map[string]bool{}
+	contractLock.Lock()
+	for _, c := range contracts {
+		for _, u := range c.Usages {
+			recorded[u.BookingID] = true
+		}
+	}
+	contractLock.Unlock()
+
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	var issues []ReconciliationIssue
+	for _, b := range bookings {
+		if b.ContractID != "" && b.Status != "cancelled" && !recorded[b.ID] {
+			issues = append(issues, ReconciliationIssue{
+				Ref:     b.ID,
+				Message: fmt.Sprintf("คิวใช้สิทธิ์สัญญา %s แต่สัญญาไม่ได้บันทึกการใช้สิทธิ์", b.ContractID),
+			})
+		}
+	}
+	return issues, nil
+}
+
+func saveContracts() {
+	contractLock.Lock()
+	data, err := json.Marshal(contracts)
+	contractLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal contracts: %v", err)
+		return
+	}
+	if err := os.WriteFile(contractsFile, data, 0644); err != nil {
+		log.Printf("Failed to save contracts: %v", err)
+	}
+}
+
+func loadContracts() {
+	data, err := os.ReadFile(contractsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read contracts file: %v", err)
+		}
+		return
+	}
+	contractLock.Lock()
+	defer contractLock.Unlock()
+	if err := json.Unmarshal(data, &contracts); err != nil {
+		log.Printf("Failed to parse contracts file: %v", err)
+	}
+}
+
+func handleGetContracts(c *fiber.Ctx) error {
+	userId := c.Query("user_id")
+	status := c.Query("status")
+	contractLock.Lock()
+	defer contractLock.Unlock()
+	result := make([]Contract, 0, len(contracts))
+	for _, ct := range contracts {
+		if (userId == "" || ct.UserID == userId) && (status == "" || ct.Status == status) {
+			result = append(result, *ct)
+		}
+	}
+	return c.JSON(result)
+}
+
+type CreateContractRequest struct {
+	UserID     string `json:"user_id"`
+	ServiceKey string `json:"service_key"`
+	Items      int    `json:"items"`
+	Price      int    `json:"price,omitempty"` // defaults to the contract package sale price
+	StartDate  string `json:"start_date,omitempty"`
+}
+
+// newContract builds a one-year contract, pricing it from the contract package when no price is given.
+func newContract(req CreateContractRequest) (*Contract, error) {
+	if req.UserID == "" {
+		return nil, fmt.Errorf("user_id is required")
+	}
+	if req.Items < 1 {
+		return nil, fmt.Errorf("items must be positive")
+	}
+	if req.StartDate == "" {
+		req.StartDate = bangkokNow().Format("2006-01-02")
+	}
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, fmt.Errorf("start_date must be YYYY-MM-DD")
+	}
+	if req.Price == 0 {
+		if pricingConfig == nil {
+			return nil, fmt.Errorf("pricing config not loaded")
+		}
+		price, ok := pricingConfig.PackagePriceFor("contract", req.ServiceKey, req.Items)
+		if !ok {
+			return nil, fmt.Errorf("no contract price for %s x%d; pass price explicitly", req.ServiceKey, req.Items)
+		}
+		req.Price = price.SalePrice
+	}
+	now := time.Now()
+	return &Contract{
+		ID:         fmt.Sprintf("ct_%d", now.UnixNano()),
+		UserID:     req.UserID,
+		ServiceKey: req.ServiceKey,
+		Items:      req.Items,
+		Price:      req.Price,
+		StartDate:  req.StartDate,
+		ExpiresOn:  start.AddDate(1, 0, -1).Format("2006-01-02"),
+		Status:     "active",
+		Usages:     []ContractUsage{},
+		CreatedAt:  now,
+	}, nil
+}
+
+// addContract stores a sold contract and counts it towards the customer's lifetime spend.
+func addContract(ct *Contract) {
+	contractLock.Lock()
+	contracts = append(contracts, ct)
+	contractLock.Unlock()
+	go saveContracts()
+
+	userThreadLock.Lock()
+	conv, ok := userConversations[ct.UserID]
+	if !ok {
+		conv = &UserConversation{UserID: ct.UserID}
+		userConversations[ct.UserID] = conv
+	}
+	conv.Profile.TotalSpend += ct.Price
+	userThreadLock.Unlock()
+	go saveConversations()
+	emitOutboundEvent("contract.created", ct.UserID, *ct)
+	log.Printf("Created contract %s for user %s: %d items of %s until %s", ct.ID, ct.UserID, ct.Items, ct.ServiceKey, ct.ExpiresOn)
+}
+
+// handleCreateContract records a contract sold by staff.
+func handleCreateContract(c *fiber.Ctx) error {
+	var req CreateContractRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if _, ok := pricingConfig.Services[req.ServiceKey]; !ok {
+		return respondError(c, fiber.StatusBadRequest, "unknown service_key")
+	}
+	ct, err := newContract(req)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	addContract(ct)
+	return c.JSON(ct)
+}
+
+// handleRenewContract starts a follow-up contract with the same terms, beginning the day
+// after the current one ends (or today if it has already expired).
+func handleRenewContract(c *fiber.Ctx) error {
+	id := c.Params("id")
+	contractLock.Lock()
+	var existing *Contract
+	for _, ct := range contracts {
+		if ct.ID == id {
+			existing = ct
+		}
+	}
+	if existing == nil {
+		contractLock.Unlock()
+		return respondError(c, fiber.StatusNotFound, "contract not found")
+	}
+	if existing.RenewedBy != "" {
+		contractLock.Unlock()
+		return respondError(c, fiber.StatusConflict, "contract already renewed by "+existing.RenewedBy)
+	}
+	start := bangkokNow().Format("2006-01-02")
+	if end, err := time.Parse("2006-01-02", existing.ExpiresOn); err == nil && existing.ExpiresOn >= start {
+		start = end.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+	req := CreateContractRequest{UserID: existing.UserID, ServiceKey: existing.ServiceKey, Items: existing.Items, StartDate: start}
+	contractLock.Unlock()
+
+	ct, err := newContract(req)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	contractLock.Lock()
+	existing.RenewedBy = ct.ID
+	contractLock.Unlock()
+	addContract(ct)
+
+	msg := fmt.Sprintf("ต่ออายุสัญญาบริการ%sเรียบร้อยแล้วค่ะ ได้รับสิทธิ์ %d ชิ้น ใช้ได้ตั้งแต่ %s ถึง %s ยอดชำระ %s บาท 🙏",
+		contractServiceName(ct.ServiceKey), ct.Items, formatThaiDate(ct.StartDate), formatThaiDate(ct.ExpiresOn), pricing.FormatNumber(ct.Price))
+	if err := pushLineMessageWithPriority(ct.UserID, msg, pushTransactional, "contract_renewal"); err != nil {
+		log.Printf("Failed to notify %s about contract renewal: %v", ct.UserID, err)
+	}
+	return c.JSON(ct)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ColdStore is object storage outside the hot data directory: compressed conversation
+// archives and documents customers send as files.
+type ColdStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// localColdStore writes archives under DATA_DIR/archive; used when no bucket is configured.
+type localColdStore struct{}
+
+var archiveDir = "archive"
+
+func (s *localColdStore) Put(ctx context.Context, key string, data []byte) error {
+	path := filepath.Join(archiveDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create archive dir: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (s *localColdStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(archiveDir, filepath.FromSlash(key)))
+}
+
+// s3ColdStore talks to any S3-compatible bucket with SigV4 signing. Google Cloud Storage
+// works through its interoperability endpoint (https://storage.googleapis.com) with HMAC keys.
+type s3ColdStore struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func (s *s3ColdStore) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3ColdStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3ColdStore) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+s.bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signingKey := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, classifyRequestError("cold_storage", err)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &UpstreamError{Service: "cold_storage", StatusCode: resp.StatusCode, Err: errors.New(strings.TrimSpace(string(msg)))}
+	}
+	return resp, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// newColdStore picks the bucket store when ARCHIVE_BUCKET is set, else the local archive dir.
+func newColdStore() ColdStore {
+	bucket := appConfig.ArchiveBucket
+	if bucket == "" {
+		return &localColdStore{}
+	}
+	region := appConfig.ArchiveRegion
+	endpoint := appConfig.ArchiveEndpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return &s3ColdStore{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		region:    region,
+		accessKey: appConfig.ArchiveAccessKey,
+		secretKey: appConfig.ArchiveSecretKey,
+	}
+}
+
+// ArchivedConversation is the hot-store index entry for a conversation moved to cold storage.
+type ArchivedConversation struct {
+	UserID       string    `json:"user_id"`
+	DisplayName  string    `json:"display_name"`
+	Nickname     string    `json:"nickname"`
+	LastSeen     string    `json:"last_seen"`
+	MessageCount int       `json:"message_count"`
+	Key          string    `json:"key"`
+	ArchivedAt   time.Time `json:"archived_at"`
+}
+
+var archivedConversationsFile = "archived_conversations.json"
+
+var (
+	coldStore             ColdStore = &localColdStore{}
+	archiveLock           sync.Mutex
+	archivedConversations = make(map[string]ArchivedConversation)
+)
+
+// archiveAfterMonths reads ARCHIVE_AFTER_MONTHS; 0 disables the archival job.
+func archiveAfterMonths() int {
+	return appConfig.ArchiveAfterMonths
+}
+
+func archiveKey(userId string) string {
+	return "conversations/" + userId + ".json.gz"
+}
+
+func loadArchivedConversations() {
+	data, err := os.ReadFile(archivedConversationsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read archived conversations file: %v", err)
+		}
+		return
+	}
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+	if err := json.Unmarshal(data, &archivedConversations); err != nil {
+		log.Printf("Failed to parse archived conversations file: %v", err)
+	}
+}
+
+// saveArchivedConversations persists the index. Caller must hold archiveLock.
+func saveArchivedConversations() {
+	data, err := json.MarshalIndent(archivedConversations, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal archived conversations: %v", err)
+		return
+	}
+	if err := os.WriteFile(archivedConversationsFile, data, 0644); err != nil {
+		log.Printf("Failed to save archived conversations: %v", err)
+	}
+}
+
+// runConversationArchival moves conversations idle for longer than the cutoff to cold
+// storage. Conversations waiting on staff are kept hot. It returns how many were archived.
+func runConversationArchival(ctx context.Context, months int) (int, error) {
+	cutoff := bangkokNow().AddDate(0, -months, 0)
+	type candidate struct {
+		entry ArchivedConversation
+		data  []byte // conversation JSON, marshalled under the lock
+	}
+	var candidates []candidate
+	userThreadLock.Lock()
+	for uid, conv := range userConversations {
+		if isSimulatedUser(uid) || conv.Takeover || conv.WantsHuman {
+			continue
+		}
+		seen, err := time.ParseInLocation("2006-01-02T15:04:05", conv.LastSeen, bangkokNow().Location())
+		if err != nil || !seen.Before(cutoff) {
+			continue
+		}
+		data, err := json.Marshal(conv)
+		if err != nil {
+			log.Printf("Failed to marshal conversation %s for archival: %v", uid, err)
+			continue
+		}
+		candidates = append(candidates, candidate{data: data, entry: ArchivedConversation{
+			UserID:       uid,
+			DisplayName:  conv.DisplayName,
+			Nickname:     conv.Nickname,
+			LastSeen:     conv.LastSeen,
+			MessageCount: len(conv.Messages),
+			Key:          archiveKey(uid),
+		}})
+	}
+	userThreadLock.Unlock()
+
+	archived := 0
+	defer func() {
+		if archived > 0 {
+			saveConversations()
+			appMetrics.add("conversations_archived", int64(archived))
+			log.Printf("Archived %d conversation(s) idle since before %s", archived, cutoff.Format("2006-01-02"))
+		}
+	}()
+	for _, cand := range candidates {
+		if err := ctx.Err(); err != nil {
+			return archived, err
+		}
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(cand.data); err != nil {
+			return archived, fmt.Errorf("failed to compress conversation %s: %w", cand.entry.UserID, err)
+		}
+		if err := gz.Close(); err != nil {
+			return archived, fmt.Errorf("failed to compress conversation %s: %w", cand.entry.UserID, err)
+		}
+		if err := coldStore.Put(ctx, cand.entry.Key, buf.Bytes()); err != nil {
+			return archived, fmt.Errorf("failed to upload conversation %s: %w", cand.entry.UserID, err)
+		}
+
+		// Drop from the hot store only if the customer didn't come back during the upload
+		userThreadLock.Lock()
+		current, ok := userConversations[cand.entry.UserID]
+		removed := ok && current.LastSeen == cand.entry.LastSeen
+		if removed {
+			delete(userConversations, cand.entry.UserID)
+			delete(userAnswerCache, cand.entry.UserID)
+		}
+		userThreadLock.Unlock()
+		if !removed {
+			continue
+		}
+		cand.entry.ArchivedAt = time.Now()
+		archiveLock.Lock()
+		archivedConversations[cand.entry.UserID] = cand.entry
+		saveArchivedConversations()
+		archiveLock.Unlock()
+		archived++
+	}
+	return archived, nil
+}
+
+// restoreArchivedConversation brings a conversation back into the hot store. If the
+// customer has chatted since archival, the archived history is put before the new messages.
+func restoreArchivedConversation(ctx context.Context, userId string) (*UserConversation, error) {
+	archiveLock.Lock()
+	entry, ok := archivedConversations[userId]
+	archiveLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("conversation %s is not archived", userId)
+	}
+	data, err := coldStore.Get(ctx, entry.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive: %w", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	var archived UserConversation
+	if err := json.NewDecoder(gz).Decode(&archived); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+
+	userThreadLock.Lock()
+	conv, exists := userConversations[userId]
+	if !exists {
+		conv = &archived
+		userConversations[userId] = conv
+	} else {
+		conv.Messages = append(archived.Messages, conv.Messages...)
+		const maxConvMessages = 200
+		if len(conv.Messages) > maxConvMessages {
+			conv.Messages = conv.Messages[len(conv.Messages)-maxConvMessages:]
+		}
+		if conv.Profile == (CustomerProfile{}) {
+			conv.Profile = archived.Profile
+		}
+		if conv.DisplayName == "" {
+			conv.DisplayName = archived.DisplayName
+		}
+		if conv.Nickname == "" {
+			conv.Nickname = archived.Nickname
+		}
+		if conv.Attribution == nil {
+			conv.Attribution = archived.Attribution
+		}
+	}
+	restored := *conv
+	userThreadLock.Unlock()
+	saveConversations()
+
+	archiveLock.Lock()
+	delete(archivedConversations, userId)
+	saveArchivedConversations()
+	archiveLock.Unlock()
+	appMetrics.inc("conversations_restored")
+	log.Printf("Restored archived conversation for %s (%d archived messages)", userId, len(archived.Messages))
+	return &restored, nil
+}
+
+// restoreOnReturn restores a returning customer's archived conversation in the background
+// and reports whether there was one.
+func restoreOnReturn(userId string) bool {
+	archiveLock.Lock()
+	_, ok := archivedConversations[userId]
+	archiveLock.Unlock()
+	if !ok {
+		return false
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := restoreArchivedConversation(ctx, userId); err != nil {
+			log.Printf("Failed to restore archived conversation for %s: %v", userId, err)
+		}
+	}()
+	return true
+}
+
+// startArchivalJob archives idle conversations daily at 03:00 Bangkok time when
+// ARCHIVE_AFTER_MONTHS is set.
+func startArchivalJob() {
+	months := archiveAfterMonths()
+	if months == 0 {
+		return
+	}
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 3, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			if _, err := runConversationArchival(ctx, months); err != nil {
+				log.Printf("Conversation archival failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+func handleGetArchivedConversations(c *fiber.Ctx) error {
+	archiveLock.Lock()
+	list := make([]ArchivedConversation, 0, len(archivedConversations))
+	for _, a := range archivedConversations {
+		list = append(list, a)
+	}
+	archiveLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen > list[j].LastSeen })
+	return c.JSON(list)
+}
+
+// handleRunArchival runs the archival job now; ?months= overrides ARCHIVE_AFTER_MONTHS.
+func handleRunArchival(c *fiber.Ctx) error {
+	months := c.QueryInt("months", archiveAfterMonths())
+	if months <= 0 {
+		return respondError(c, fiber.StatusBadRequest, "months must be set (query or ARCHIVE_AFTER_MONTHS)")
+	}
+	archived, err := runConversationArchival(c.Context(), months)
+	if err != nil {
+		log.Printf("Conversation archival failed: %v", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error(), "archived": archived})
+	}
+	return c.JSON(fiber.Map{"status": "ok", "archived": archived})
+}
+
+func handleRestoreArchivedConversation(c *fiber.Ctx) error {
+	userId := c.Params("userId")
+	archiveLock.Lock()
+	_, ok := archivedConversations[userId]
+	archiveLock.Unlock()
+	if !ok {
+		return respondError(c, fiber.StatusNotFound, "archived conversation not found")
+	}
+	conv, err := restoreArchivedConversation(c.Context(), userId)
+	if err != nil {
+		log.Printf("Failed to restore archived conversation for %s: %v", userId, err)
+		return respondError(c, fiber.StatusBadGateway, "unable to restore conversation")
+	}
+	return c.JSON(fiber.Map{"status": "ok", "conversation": conv})
+}
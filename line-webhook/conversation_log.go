@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every customer message, bot or staff reply, tool call and assistant run outcome is appended
+// to a conversation log for audits, handoff context and analytics. Unlike conversations.json,
+// which keeps the last 200 messages per customer for the model, the log keeps everything
+// until transcript_days purges it. With DATABASE_URL set (and the server built with
+// -tags postgres) the log is stored in Postgres; otherwise it is a JSON-lines file.
+
+// ConversationEvent is one entry in the conversation log.
+type ConversationEvent struct {
+	ID        int64     `json:"id,omitempty"`
+	UserID    string    `json:"user_id"`
+	RunID     string    `json:"run_id,omitempty"` // the assistant turn; the Responses API keeps no threads
+	Kind      string    `json:"kind"`             // "message", "tool_call" or "run"
+	Role      string    `json:"role,omitempty"`   // message: "customer", "ai" or "admin"
+	Content   string    `json:"content,omitempty"`
+	Tool      string    `json:"tool,omitempty"`
+	ToolArgs  string    `json:"tool_args,omitempty"`
+	Status    string    `json:"status,omitempty"` // tool_call and run: "ok" or the error kind
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationRepository stores the conversation log.
+type ConversationRepository interface {
+	Append(ctx context.Context, events []ConversationEvent) error
+	// History returns the user's latest events, oldest first.
+	History(ctx context.Context, userId string, limit int) ([]ConversationEvent, error)
+	// Purge removes events older than cutoff and reports how many there were.
+	Purge(ctx context.Context, cutoff time.Time, dryRun bool) (int, error)
+}
+
+var conversationLogFile = "conversation_log.jsonl"
+
+var (
+	conversationRepo   ConversationRepository = &fileConversationRepository{}
+	conversationEvents                        = make(chan ConversationEvent, 1024)
+)
+
+type runIDKey struct{}
+
+func withRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+func runIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// recordConversationEvent queues an event for the log writer. It never blocks, so it is safe
+// under userThreadLock; when the writer falls behind the event is dropped and counted.
+// Simulated conversations are not logged.
+func recordConversationEvent(e ConversationEvent) {
+	if isSimulatedUser(e.UserID) {
+		return
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.Content = imageDataURLPattern.ReplaceAllString(e.Content, "[image]")
+	select {
+	case conversationEvents <- e:
+	default:
+		appMetrics.inc("conversation_log_dropped")
+	}
+}
+
+// newConversationRepository opens Postgres when DATABASE_URL is set and a driver is compiled in.
+func newConversationRepository() ConversationRepository {
+	dsn := appConfig.DatabaseURL
+	if dsn == "" {
+		return &fileConversationRepository{}
+	}
+	if !slices.Contains(sql.Drivers(), "pgx") {
+		log.Printf("DATABASE_URL is set but the server was built without -tags postgres; logging conversations to %s", conversationLogFile)
+		return &fileConversationRepository{}
+	}
+	repo, err := openSQLConversationRepository("pgx", dsn)
+	if err != nil {
+		log.Printf("Failed to open the conversation database, logging to %s instead: %v", conversationLogFile, err)
+		return &fileConversationRepository{}
+	}
+	log.Printf("Logging conversations to Postgres")
+	return repo
+}
+
+// startConversationLog writes queued events in batches.
+func startConversationLog() {
+	conversationRepo = newConversationRepository()
+	go func() {
+		for e := range conversationEvents {
+			batch := []ConversationEvent{e}
+		drain:
+			for len(batch) < 100 {
+				select {
+				case next := <-conversationEvents:
+					batch = append(batch, next)
+				default:
+					break drain
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := conversationRepo.Append(ctx, batch); err != nil {
+				log.Printf("Failed to write %d conversation log event(s): %v", len(batch), err)
+				appMetrics.add("conversation_log_dropped", int64(len(batch)))
+			}
+			cancel()
+		}
+	}()
+}
+
+// fileConversationRepository appends events to a JSON-lines file. History and Purge read the
+// whole file, which is fine for a single server; use Postgres for anything larger.
+type fileConversationRepository struct {
+	mu     sync.Mutex
+	nextID int64
+}
+
+func (r *fileConversationRepository) Append(ctx context.Context, events []ConversationEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.OpenFile(conversationLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if r.nextID == 0 {
+			r.nextID = time.Now().UnixNano()
+		}
+		r.nextID++
+		e.ID = r.nextID
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// scan calls fn for every event in the file.
+func (r *fileConversationRepository) scan(fn func(e ConversationEvent, line []byte)) error {
+	f, err := os.Open(conversationLogFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e ConversationEvent
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			fn(e, sc.Bytes())
+		}
+	}
+	return sc.Err()
+}
+
+func (r *fileConversationRepository) History(ctx context.Context, userId string, limit int) ([]ConversationEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []ConversationEvent
+	err := r.scan(func(e ConversationEvent, _ []byte) {
+		if e.UserID == userId {
+			result = append(result, e)
+		}
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, err
+}
+
+func (r *fileConversationRepository) Purge(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept [][]byte
+	purged := 0
+	err := r.scan(func(e ConversationEvent, line []byte) {
+		if e.CreatedAt.Before(cutoff) {
+			purged++
+			return
+		}
+		kept = append(kept, append([]byte(nil), line...))
+	})
+	if err != nil || dryRun || purged == 0 {
+		return purged, err
+	}
+	tmpPath := conversationLogFile + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range kept {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	f.Close()
+	return purged, os.Rename(tmpPath, conversationLogFile)
+}
+
+// conversationLogSchema is created on start; every statement is idempotent.
+const conversationLogSchema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	user_id       TEXT PRIMARY KEY,
+	first_seen_at TIMESTAMPTZ NOT NULL,
+	last_event_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS conversation_events (
+	id         BIGSERIAL PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES conversations(user_id),
+	run_id     TEXT NOT NULL DEFAULT '',
+	kind       TEXT NOT NULL,
+	role       TEXT NOT NULL DEFAULT '',
+	content    TEXT NOT NULL DEFAULT '',
+	tool       TEXT NOT NULL DEFAULT '',
+	tool_args  TEXT NOT NULL DEFAULT '',
+	status     TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS conversation_events_user_idx ON conversation_events (user_id, id);
+CREATE INDEX IF NOT EXISTS conversation_events_run_idx ON conversation_events (run_id) WHERE run_id <> '';
+CREATE INDEX IF NOT EXISTS conversation_events_created_idx ON conversation_events (created_at);
+`
+
+// sqlConversationRepository stores the log in Postgres through database/sql.
+type sqlConversationRepository struct {
+	db *sql.DB
+}
+
+func openSQLConversationRepository(driver, dsn string) (*sqlConversationRepository, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, conversationLogSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return &sqlConversationRepository{db: db}, nil
+}
+
+func (r *sqlConversationRepository) Append(ctx context.Context, events []ConversationEvent) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range events {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO conversations (user_id, first_seen_at, last_event_at) VALUES ($1, $2, $2)
+			ON CONFLICT (user_id) DO UPDATE SET last_event_at = GREATEST(conversations.last_event_at, EXCLUDED.last_event_at)`,
+			e.UserID, e.CreatedAt); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO conversation_events (user_id, run_id, kind, role, content, tool, tool_args, status, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			e.UserID, e.RunID, e.Kind, e.Role, e.Content, e.Tool, e.ToolArgs, e.Status, e.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlConversationRepository) History(ctx context.Context, userId string, limit int) ([]ConversationEvent, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id, user_id, run_id, kind, role, content, tool, tool_args, status, created_at
+		FROM conversation_events WHERE user_id = $1 ORDER BY id DESC LIMIT $2`, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []ConversationEvent
+	for rows.Next() {
+		var e ConversationEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.RunID, &e.Kind, &e.Role, &e.Content, &e.Tool, &e.ToolArgs, &e.Status, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	slices.Reverse(result)
+	return result, rows.Err()
+}
+
+func (r *sqlConversationRepository) Purge(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	var n int64
+	if dryRun {
+		err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM conversation_events WHERE created_at < $1`, cutoff).Scan(&n)
+		return int(n), err
+	}
+	res, err := r.db.ExecContext(ctx, `DELETE FROM conversation_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, _ = res.RowsAffected()
+	return int(n), nil
+}
+
+func purgeConversationLog(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	return conversationRepo.Purge(ctx, cutoff, dryRun)
+}
+
+// handleGetConversationLog returns a customer's conversation log, oldest first (?limit=, default 500).
+func handleGetConversationLog(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "500"))
+	if err != nil || limit <= 0 {
+		return respondError(c, fiber.StatusBadRequest, "limit must be a positive number")
+	}
+	events, err := conversationRepo.History(c.Context(), c.Params("userId"), limit)
+	if err != nil {
+		log.Printf("Failed to read conversation log: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to read conversation log")
+	}
+	if events == nil {
+		events = []ConversationEvent{}
+	}
+	return c.JSON(events)
+}
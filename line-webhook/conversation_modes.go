@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A conversation is in one of three modes: sales (the 5-step booking workflow, the default),
+// aftercare (questions after a service) or complaint. Each mode has its own prompt, added to
+// every turn, and its own set of function tools, so post-service questions aren't funneled
+// into the sales workflow. The mode switches when a message matches one of a mode's phrases,
+// or when the customer taps a "action=mode&mode=..." button.
+
+const (
+	modeSales     = "sales"
+	modeAftercare = "aftercare"
+	modeComplaint = "complaint"
+)
+
+// conversationModeOrder decides which mode wins when a message matches several.
+var conversationModeOrder = []string{modeComplaint, modeAftercare, modeSales}
+
+// modeFollowUp is how long aftercare and complaint mode last after they were last entered or
+// matched; the conversation then returns to sales.
+const modeFollowUp = 48 * time.Hour
+
+// ConversationMode configures one mode.
+type ConversationMode struct {
+	Label   string   `json:"label"`             // quick reply label, max 20 characters
+	Intro   string   `json:"intro"`             // reply when the customer switches with a button
+	Prompt  string   `json:"prompt,omitempty"`  // added to the model input on every turn in this mode
+	Tools   []string `json:"tools,omitempty"`   // function tools offered; empty offers all
+	Phrases []string `json:"phrases,omitempty"` // switch to this mode when a message contains one
+}
+
+var conversationModesFile = "conversation_modes.json"
+
+var (
+	conversationModesLock sync.RWMutex
+	conversationModes     = map[string]ConversationMode{
+		modeSales: {
+			Label:   "จองบริการ/เช็คราคา",
+			Intro:   "ได้เลยค่ะ 😊 แจ้งรายการที่ต้องการทำความสะอาด (ที่นอน โซฟา ม่าน พรม) พร้อมขนาด หรือส่งรูปมาได้เลยนะคะ",
+			Phrases: []string{"จองคิว", "อยากจอง", "เช็คราคา", "ขอราคา", "ราคาเท่าไหร่", "ใบเสนอราคา"},
+		},
+		modeAftercare: {
+			Label: "หลังใช้บริการ",
+			Intro: "ยินดีดูแลหลังการบริการค่ะ 😊 สอบถามเรื่องการดูแลหลังทำความสะอาด การรับประกัน หรือปัญหาที่พบได้เลยนะคะ",
+			Prompt: "[ระบบ] โหมดดูแลหลังการบริการ: ลูกค้าใช้บริการไปแล้วและถามเรื่องหลังการบริการ (เช่น เวลาแห้ง กลิ่น คราบที่กลับมา การรับประกัน) " +
+				"ไม่ต้องทำตามขั้นตอนการขาย 5 ขั้น ไม่ต้องเสนอราคาหรือชวนจองเว้นแต่ลูกค้าขอเอง ใช้ get_my_booking เมื่อต้องอ้างอิงการจอง " +
+				"และถ้าต้องให้ช่างกลับไปแก้ไขให้แจ้งว่าจะประสานเจ้าหน้าที่ให้",
+			Tools: []string{"get_my_booking", "update_booking_details", "get_my_contracts", "book_with_contract",
+				"get_membership_info", "get_image_analysis_guidance", "annotate_customer_image", "export_my_chat_history"},
+			Phrases: []string{"หลังทำความสะอาด", "หลังซัก", "หลังใช้บริการ", "ยังไม่แห้ง", "ยังมีกลิ่น", "ยังเหม็น",
+				"คราบกลับมา", "คราบยังอยู่", "รับประกัน", "ดูแลหลัง"},
+		},
+		modeComplaint: {
+			Label: "แจ้งปัญหา/ร้องเรียน",
+			Intro: "ขออภัยในความไม่สะดวกค่ะ 🙏 รบกวนเล่ารายละเอียดปัญหา วันที่ใช้บริการ และส่งรูปประกอบ (ถ้ามี) เจ้าหน้าที่จะรับเรื่องและติดต่อกลับโดยเร็วค่ะ",
+			Prompt: "[ระบบ] โหมดรับเรื่องร้องเรียน: รับฟังและขออภัยอย่างจริงใจ เก็บรายละเอียดปัญหา วันที่ใช้บริการ รูปประกอบ และเบอร์ติดต่อ " +
+				"ห้ามเสนอราคา ชวนจอง หรือโต้แย้งลูกค้า ห้ามสัญญาการชดเชยหรือคืนเงินเอง แจ้งว่าเจ้าหน้าที่จะติดต่อกลับ",
+			Tools:   []string{"get_my_booking", "get_image_analysis_guidance"},
+			Phrases: []string{"ร้องเรียน", "ไม่พอใจ", "เสียหาย", "ทำพัง", "ทำขาด", "ขอเงินคืน", "คืนเงิน", "บริการแย่", "complain", "refund"},
+		},
+	}
+)
+
+func validateConversationModes(modes map[string]ConversationMode) error {
+	for _, key := range conversationModeOrder {
+		if _, ok := modes[key]; !ok {
+			return fmt.Errorf("mode %q is required", key)
+		}
+	}
+	for key, m := range modes {
+		if !slices.Contains(conversationModeOrder, key) {
+			return fmt.Errorf("unknown mode %q (expected sales, aftercare or complaint)", key)
+		}
+		if m.Label == "" || m.Intro == "" {
+			return fmt.Errorf("%s: label and intro are required", key)
+		}
+		if len([]rune(m.Label)) > 20 {
+			return fmt.Errorf("%s: label must be at most 20 characters", key)
+		}
+		for _, tool := range m.Tools {
+			if _, ok := toolSchemas[tool]; !ok {
+				return fmt.Errorf("%s: unknown tool %q", key, tool)
+			}
+		}
+	}
+	return nil
+}
+
+// conversationModeLocked returns the conversation's current mode. Caller holds userThreadLock.
+func conversationModeLocked(conv *UserConversation) string {
+	if conv == nil || conv.Mode == "" || (conv.Mode != modeSales && time.Since(conv.ModeSetAt) > modeFollowUp) {
+		return modeSales
+	}
+	return conv.Mode
+}
+
+// conversationModeFor returns the mode and its settings for a user's next turn.
+func conversationModeFor(userId string) (string, ConversationMode) {
+	userThreadLock.Lock()
+	mode := conversationModeLocked(userConversations[userId])
+	userThreadLock.Unlock()
+	conversationModesLock.RLock()
+	defer conversationModesLock.RUnlock()
+	return mode, conversationModes[mode]
+}
+
+// detectConversationMode returns the mode a normalized message asks for, or "".
+func detectConversationMode(msg string) string {
+	lower := strings.ToLower(msg)
+	conversationModesLock.RLock()
+	defer conversationModesLock.RUnlock()
+	for _, key := range conversationModeOrder {
+		for _, p := range conversationModes[key].Phrases {
+			if strings.Contains(lower, strings.ToLower(p)) {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// routeConversationMode switches the conversation to the mode a message asks for.
+// Caller must hold userThreadLock.
+func routeConversationMode(conv *UserConversation, msg string) {
+	if mode := detectConversationMode(msg); mode != "" {
+		setConversationModeLocked(conv, mode, msg)
+	}
+}
+
+// setConversationModeLocked switches the conversation's mode; entering complaint mode alerts
+// staff. Caller must hold userThreadLock.
+func setConversationModeLocked(conv *UserConversation, mode, message string) {
+	previous := conversationModeLocked(conv)
+	conv.Mode = mode
+	conv.ModeSetAt = time.Now()
+	if previous == mode {
+		return
+	}
+	log.Printf("Conversation %s switched from %s to %s mode", conv.UserID, previous, mode)
+	appMetrics.inc("conversation_mode_" + mode)
+	if mode == modeComplaint {
+		go alertStaff(conv.UserID, fmt.Sprintf("📣 ลูกค้าแจ้งปัญหา/ร้องเรียน: %s\n\"%s\"", conv.DisplayName, message), "complaint")
+	}
+}
+
+// modeQuickReplies offers a button for every mode other than the current one.
+func modeQuickReplies(current string) []QuickReplyOption {
+	conversationModesLock.RLock()
+	defer conversationModesLock.RUnlock()
+	var options []QuickReplyOption
+	for _, key := range []string{modeSales, modeAftercare, modeComplaint} {
+		if key == current {
+			continue
+		}
+		m := conversationModes[key]
+		options = append(options, QuickReplyOption{Label: m.Label, Text: m.Label, Data: "action=mode&mode=" + key})
+	}
+	return options
+}
+
+// handleModePostback switches the conversation's mode and replies with the mode's intro.
+func handleModePostback(userId, replyToken string, values url.Values) bool {
+	mode := values.Get("mode")
+	conversationModesLock.RLock()
+	m, ok := conversationModes[mode]
+	conversationModesLock.RUnlock()
+	if !ok {
+		return false
+	}
+	recordPostbackAction(userId, "[กดปุ่ม: "+m.Label+"]")
+	started := time.Now()
+	userThreadLock.Lock()
+	setConversationModeLocked(userConversations[userId], mode, m.Label)
+	userThreadLock.Unlock()
+	if staffHandling(userId) {
+		return true
+	}
+	msg := map[string]interface{}{"type": "text", "text": m.Intro}
+	attachQuickReplies(msg, modeQuickReplies(mode))
+	answerPostback(userId, replyToken, m.Intro, started, msg)
+	return true
+}
+
+func loadConversationModes() {
+	data, err := os.ReadFile(conversationModesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read conversation modes file: %v", err)
+		}
+		return
+	}
+	var modes map[string]ConversationMode
+	if err := json.Unmarshal(data, &modes); err != nil {
+		log.Printf("Failed to parse conversation modes file: %v", err)
+		return
+	}
+	if err := validateConversationModes(modes); err != nil {
+		log.Printf("Invalid conversation modes file: %v", err)
+		return
+	}
+	conversationModesLock.Lock()
+	conversationModes = modes
+	conversationModesLock.Unlock()
+}
+
+func handleGetConversationModes(c *fiber.Ctx) error {
+	conversationModesLock.RLock()
+	defer conversationModesLock.RUnlock()
+	return c.JSON(conversationModes)
+}
+
+func handleReplaceConversationModes(c *fiber.Ctx) error {
+	var incoming map[string]ConversationMode
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := validateConversationModes(incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save conversation modes")
+	}
+	if err := os.WriteFile(conversationModesFile, data, 0644); err != nil {
+		log.Printf("Failed to save conversation modes: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save conversation modes")
+	}
+	conversationModesLock.Lock()
+	conversationModes = incoming
+	conversationModesLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "modes": incoming})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportedCustomer is an existing customer loaded from records kept before the bot.
+// Phone-only records wait in importedCustomersFile until the phone is linked to a LINE user.
+type ImportedCustomer struct {
+	Phone          string    `json:"phone,omitempty"`
+	FullName       string    `json:"full_name,omitempty"`
+	MembershipTier string    `json:"membership_tier,omitempty"`
+	MemberSince    time.Time `json:"member_since,omitempty"`
+	ServiceDates   []string  `json:"service_dates,omitempty"` // YYYY-MM-DD, oldest first
+	TotalSpend     int       `json:"total_spend,omitempty"`
+	Branch         string    `json:"branch,omitempty"`
+	ImportedAt     time.Time `json:"imported_at"`
+}
+
+// CustomerImportReport summarises a bulk customer import.
+type CustomerImportReport struct {
+	RowsRead int                     `json:"rows_read"`
+	Updated  int                     `json:"updated"` // LINE users whose profile was filled in
+	Pending  int                     `json:"pending"` // phone-only records waiting to be linked
+	Errors   []PricingImportRowError `json:"errors,omitempty"`
+	DryRun   bool                    `json:"dry_run"`
+	Saved    bool                    `json:"saved"`
+}
+
+var importedCustomersFile = "imported_customers.json"
+
+var (
+	importedCustomerLock sync.Mutex
+	importedCustomers    = make(map[string]*ImportedCustomer) // phone -> record
+)
+
+// customerImportColumns maps accepted header spellings (lower-cased) to canonical column names.
+var customerImportColumns = map[string]string{
+	"line_user_id":       "user_id",
+	"user_id":            "user_id",
+	"userid":             "user_id",
+	"phone":              "phone",
+	"tel":                "phone",
+	"เบอร์โทร":           "phone",
+	"name":               "full_name",
+	"full_name":          "full_name",
+	"ชื่อ":               "full_name",
+	"membership_tier":    "membership_tier",
+	"tier":               "membership_tier",
+	"ระดับสมาชิก":        "membership_tier",
+	"member_since":       "member_since",
+	"service_dates":      "service_dates",
+	"past_service_dates": "service_dates",
+	"last_service_date":  "service_dates",
+	"วันที่ใช้บริการ": "service_dates",
+	"total_spend": "total_spend",
+	"ยอดใช้จ่าย":  "total_spend",
+	"branch":      "branch",
+	"สาขา":        "branch",
+}
+
+// noMembershipValues are tier cells that mean "not a member".
+var noMembershipValues = map[string]bool{"": true, "-": true, "no": true, "none": true, "ไม่ใช่": true, "ไม่เป็นสมาชิก": true}
+
+var lineUserIDPattern = regexp.MustCompile(`^U[0-9a-f]{32}$`)
+
+var importDateSeparators = regexp.MustCompile(`[;|,\s]+`)
+
+// parseImportDate accepts YYYY-MM-DD or D/M/YYYY; Buddhist-era years are converted.
+func parseImportDate(v string) (string, error) {
+	v = convertThaiDigits(strings.TrimSpace(v))
+	var t time.Time
+	var err error
+	if strings.Contains(v, "/") {
+		t, err = time.Parse("2/1/2006", v)
+	} else {
+		t, err = time.Parse("2006-01-02", v)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q (use YYYY-MM-DD or DD/MM/YYYY)", v)
+	}
+	if t.Year() > 2400 {
+		t = t.AddDate(-543, 0, 0)
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// parseCustomerRow turns a spreadsheet row into a record plus the LINE user it belongs to, if any.
+func parseCustomerRow(cell func(string) string) (string, ImportedCustomer, error) {
+	userId := cell("user_id")
+	if userId != "" && !lineUserIDPattern.MatchString(userId) {
+		return "", ImportedCustomer{}, fmt.Errorf("invalid LINE user id %q", userId)
+	}
+	rec := ImportedCustomer{FullName: cell("full_name"), ImportedAt: time.Now()}
+	if v := cell("phone"); v != "" {
+		if rec.Phone = extractThaiPhone(v); rec.Phone == "" {
+			return "", rec, fmt.Errorf("invalid phone %q", v)
+		}
+	}
+	if userId == "" && rec.Phone == "" {
+		return "", rec, fmt.Errorf("line_user_id or phone is required")
+	}
+	if tier := strings.ToLower(cell("membership_tier")); !noMembershipValues[tier] {
+		rec.MembershipTier = tier
+	}
+	if v := cell("member_since"); v != "" {
+		date, err := parseImportDate(v)
+		if err != nil {
+			return "", rec, fmt.Errorf("member_since: %w", err)
+		}
+		rec.MemberSince, _ = time.Parse("2006-01-02", date)
+	}
+	for _, v := range importDateSeparators.Split(cell("service_dates"), -1) {
+		if v == "" {
+			continue
+		}
+		date, err := parseImportDate(v)
+		if err != nil {
+			return "", rec, fmt.Errorf("service_dates: %w", err)
+		}
+		rec.ServiceDates = append(rec.ServiceDates, date)
+	}
+	sort.Strings(rec.ServiceDates)
+	if v := cell("total_spend"); v != "" {
+		spend, err := parseImportPrice(v)
+		if err != nil {
+			return "", rec, fmt.Errorf("total_spend: %w", err)
+		}
+		rec.TotalSpend = spend
+	}
+	if v := cell("branch"); v != "" {
+		b, ok := findBranch(v)
+		if !ok {
+			return "", rec, fmt.Errorf("unknown branch %q", v)
+		}
+		rec.Branch = b.ID
+	}
+	return userId, rec, nil
+}
+
+// mergeImportedCustomer fills a profile from an imported record without discarding what the
+// bot already knows: fields are only set when empty, and the latest service date wins.
+func mergeImportedCustomer(p *CustomerProfile, rec ImportedCustomer) {
+	if p.FullName == "" {
+		p.FullName = rec.FullName
+	}
+	if p.Phone == "" {
+		p.Phone = rec.Phone
+	}
+	if p.MembershipTier == "" && rec.MembershipTier != "" {
+		p.MembershipTier = rec.MembershipTier
+		p.MemberSince = rec.MemberSince
+	}
+	if n := len(rec.ServiceDates); n > 0 && rec.ServiceDates[n-1] > p.LastServiceDate {
+		p.LastServiceDate = rec.ServiceDates[n-1]
+	}
+	if rec.TotalSpend > p.TotalSpend {
+		p.TotalSpend = rec.TotalSpend
+	}
+	if p.Branch == "" {
+		p.Branch = rec.Branch
+	}
+	p.ImportedAt = rec.ImportedAt
+}
+
+// importCustomerRows applies spreadsheet rows onto conversations and the pending store.
+// With dryRun nothing is changed; rows with errors block the whole import.
+func importCustomerRows(rows [][]string, dryRun bool) CustomerImportReport {
+	report := CustomerImportReport{DryRun: dryRun}
+	if len(rows) == 0 {
+		report.Errors = append(report.Errors, PricingImportRowError{Row: 0, Message: "spreadsheet is empty"})
+		return report
+	}
+	columns := make(map[string]int)
+	for i, h := range rows[0] {
+		if name, ok := customerImportColumns[strings.ToLower(strings.TrimSpace(h))]; ok {
+			columns[name] = i
+		}
+	}
+	_, hasUser := columns["user_id"]
+	_, hasPhone := columns["phone"]
+	if !hasUser && !hasPhone {
+		report.Errors = append(report.Errors, PricingImportRowError{Row: 1, Message: "missing required column: line_user_id or phone"})
+		return report
+	}
+
+	byUser := make(map[string]ImportedCustomer)
+	var phoneOnly []ImportedCustomer
+	for i, row := range rows[1:] {
+		if isBlankRow(row) {
+			continue
+		}
+		report.RowsRead++
+		cell := func(name string) string {
+			idx, ok := columns[name]
+			if !ok || idx >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[idx])
+		}
+		userId, rec, err := parseCustomerRow(cell)
+		if err != nil {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: i + 2, Message: err.Error()})
+			continue
+		}
+		if userId != "" {
+			byUser[userId] = rec
+		} else {
+			phoneOnly = append(phoneOnly, rec)
+		}
+	}
+	if dryRun || len(report.Errors) > 0 {
+		report.Updated, report.Pending = len(byUser), len(phoneOnly)
+		return report
+	}
+
+	userThreadLock.Lock()
+	byPhone := make(map[string]*UserConversation)
+	for _, conv := range userConversations {
+		if conv.Profile.Phone != "" {
+			byPhone[conv.Profile.Phone] = conv
+		}
+	}
+	for userId, rec := range byUser {
+		conv, ok := userConversations[userId]
+		if !ok {
+			conv = &UserConversation{UserID: userId}
+			userConversations[userId] = conv
+		}
+		mergeImportedCustomer(&conv.Profile, rec)
+		delete(userAnswerCache, userId) // tier or branch may change the quoted price
+		report.Updated++
+	}
+	var pending []ImportedCustomer
+	for _, rec := range phoneOnly {
+		if conv, ok := byPhone[rec.Phone]; ok {
+			mergeImportedCustomer(&conv.Profile, rec)
+			delete(userAnswerCache, conv.UserID)
+			report.Updated++
+			continue
+		}
+		pending = append(pending, rec)
+	}
+	userThreadLock.Unlock()
+	saveConversations()
+
+	importedCustomerLock.Lock()
+	for i := range pending {
+		importedCustomers[pending[i].Phone] = &pending[i]
+	}
+	report.Pending = len(pending)
+	saveImportedCustomers()
+	importedCustomerLock.Unlock()
+
+	report.Saved = true
+	log.Printf("Imported customers: %d profiles updated, %d waiting for their phone to be linked", report.Updated, report.Pending)
+	appMetrics.add("customers_imported", int64(report.Updated+report.Pending))
+	return report
+}
+
+// claimImportedCustomer links a phone-only imported record to the LINE user who turned out to
+// own the phone. It reports whether a record was linked. Callers must not hold userThreadLock.
+func claimImportedCustomer(userId, phone string) bool {
+	if phone == "" {
+		return false
+	}
+	importedCustomerLock.Lock()
+	rec, ok := importedCustomers[phone]
+	if ok {
+		delete(importedCustomers, phone)
+		saveImportedCustomers()
+	}
+	importedCustomerLock.Unlock()
+	if !ok {
+		return false
+	}
+
+	userThreadLock.Lock()
+	if _, exists := userConversations[userId]; !exists {
+		userConversations[userId] = &UserConversation{UserID: userId}
+	}
+	mergeImportedCustomer(&userConversations[userId].Profile, *rec)
+	delete(userAnswerCache, userId)
+	userThreadLock.Unlock()
+	go saveConversations()
+	log.Printf("Linked imported customer %s to user %s", phone, userId)
+	appMetrics.inc("imported_customers_linked")
+	return true
+}
+
+func loadImportedCustomers() {
+	data, err := os.ReadFile(importedCustomersFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read imported customers file: %v", err)
+		}
+		return
+	}
+	importedCustomerLock.Lock()
+	defer importedCustomerLock.Unlock()
+	if err := json.Unmarshal(data, &importedCustomers); err != nil {
+		log.Printf("Failed to parse imported customers file: %v", err)
+	}
+}
+
+// saveImportedCustomers persists pending records. Caller must hold importedCustomerLock.
+func saveImportedCustomers() {
+	data, err := json.MarshalIndent(importedCustomers, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal imported customers: %v", err)
+		return
+	}
+	if err := os.WriteFile(importedCustomersFile, data, 0644); err != nil {
+		log.Printf("Failed to save imported customers: %v", err)
+	}
+}
+
+// runCustomerImport reads a CSV or XLSX customer list and imports it.
+func runCustomerImport(filename string, data []byte, dryRun bool) (CustomerImportReport, error) {
+	rows, err := readPricingSheet(filename, data)
+	if err != nil {
+		return CustomerImportReport{}, fmt.Errorf("failed to read spreadsheet: %w", err)
+	}
+	return importCustomerRows(rows, dryRun), nil
+}
+
+// handleImportCustomers accepts a multipart "file" upload (CSV or XLSX) or a raw CSV body.
+// Pass ?dry_run=true to get the validation report without saving.
+func handleImportCustomers(c *fiber.Ctx) error {
+	filename := "upload.csv"
+	var data []byte
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "unable to read uploaded file")
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return respondError(c, fiber.StatusBadRequest, "unable to read uploaded file")
+		}
+		filename = fh.Filename
+	} else {
+		data = c.Body()
+	}
+	if len(data) == 0 {
+		return respondError(c, fiber.StatusBadRequest, "spreadsheet file is required")
+	}
+
+	report, err := runCustomerImport(filename, data, c.QueryBool("dry_run"))
+	if err != nil {
+		log.Printf("Customer import failed: %v", err)
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	status := fiber.StatusOK
+	if len(report.Errors) > 0 {
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).JSON(report)
+}
+
+// handleGetImportedCustomers lists phone-only records not yet linked to a LINE user.
+func handleGetImportedCustomers(c *fiber.Ctx) error {
+	importedCustomerLock.Lock()
+	list := make([]ImportedCustomer, 0, len(importedCustomers))
+	for _, rec := range importedCustomers {
+		list = append(list, *rec)
+	}
+	importedCustomerLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Phone < list[j].Phone })
+	return c.JSON(fiber.Map{"pending": list, "count": len(list)})
+}
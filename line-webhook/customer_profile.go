@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CustomerProfile holds what we know about a customer beyond the chat transcript.
+// It feeds segmentation, member pricing and staff handoff.
+type CustomerProfile struct {
+	FullName        string          `json:"full_name,omitempty"`
+	Phone           string          `json:"phone,omitempty"`
+	MembershipTier  string          `json:"membership_tier,omitempty"` // "" when not a member
+	MemberSince     time.Time       `json:"member_since,omitempty"`
+	LastServiceDate string          `json:"last_service_date,omitempty"` // YYYY-MM-DD of the last completed service
+	TotalSpend      int             `json:"total_spend,omitempty"`       // lifetime spend in baht
+	LastQuoteAt     time.Time       `json:"last_quote_at,omitempty"`     // last time the bot quoted a price
+	LastBookingAt   time.Time       `json:"last_booking_at,omitempty"`   // last time a booking was made
+	Branch          string          `json:"branch,omitempty"`            // serving branch ID, from the customer's address or location
+	ImportedAt      time.Time       `json:"imported_at,omitempty"`       // filled in from a bulk customer import
+	LastLocation    *SharedLocation `json:"last_location,omitempty"`     // last LINE location the customer shared
+}
+
+// recordQuoteIssued notes that the customer received a price quote.
+func recordQuoteIssued(userId string) {
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.Profile.LastQuoteAt = time.Now()
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+}
+
+type UpdateProfileRequest struct {
+	Phone           *string `json:"phone"`
+	MembershipTier  *string `json:"membership_tier"`
+	LastServiceDate *string `json:"last_service_date"`
+	TotalSpend      *int    `json:"total_spend"`
+	Branch          *string `json:"branch"`
+}
+
+func (r UpdateProfileRequest) validate() error {
+	if r.LastServiceDate != nil && *r.LastServiceDate != "" {
+		if _, err := time.Parse("2006-01-02", *r.LastServiceDate); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "last_service_date must be YYYY-MM-DD")
+		}
+	}
+	if r.TotalSpend != nil && *r.TotalSpend < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "total_spend must not be negative")
+	}
+	if r.Branch != nil && *r.Branch != "" {
+		if _, ok := findBranch(*r.Branch); !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown branch")
+		}
+	}
+	return nil
+}
+
+// handleUpdateProfile lets staff correct or fill in a customer's profile (partial update).
+func handleUpdateProfile(c *fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId == "" {
+		return respondError(c, fiber.StatusBadRequest, "userId is required")
+	}
+	var req UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := req.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	userThreadLock.Lock()
+	if _, ok := userConversations[userId]; !ok {
+		userConversations[userId] = &UserConversation{UserID: userId}
+	}
+	profile := &userConversations[userId].Profile
+	if req.Phone != nil {
+		profile.Phone = strings.TrimSpace(*req.Phone)
+	}
+	if req.MembershipTier != nil {
+		profile.MembershipTier = strings.TrimSpace(*req.MembershipTier)
+	}
+	if req.LastServiceDate != nil {
+		profile.LastServiceDate = strings.TrimSpace(*req.LastServiceDate)
+	}
+	if req.TotalSpend != nil {
+		profile.TotalSpend = *req.TotalSpend
+	}
+	if req.Branch != nil {
+		profile.Branch = *req.Branch
+		delete(userAnswerCache, userId)
+	}
+	result := *profile
+	userThreadLock.Unlock()
+
+	if req.Phone != nil && claimImportedCustomer(userId, extractThaiPhone(result.Phone)) {
+		userThreadLock.Lock()
+		result = userConversations[userId].Profile
+		userThreadLock.Unlock()
+	}
+	go saveConversations()
+	return c.JSON(fiber.Map{"status": "ok", "profile": result})
+}
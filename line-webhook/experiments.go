@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// InstructionExperiment runs a second instructions variant ("B") next to gpt_instructions.md
+// ("A"). Each customer is assigned once and keeps the variant for the whole experiment.
+type InstructionExperiment struct {
+	ID           string    `json:"id"`
+	Enabled      bool      `json:"enabled"`
+	BShare       int       `json:"b_share"`                // percent of customers assigned to B, 1-99
+	Instructions string    `json:"instructions,omitempty"` // B replaces the instructions entirely...
+	Append       string    `json:"append,omitempty"`       // ...or adds to the end of them
+	Description  string    `json:"description,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// ExperimentAssignment is the variant a conversation was put in.
+type ExperimentAssignment struct {
+	ID      string    `json:"id"`
+	Variant string    `json:"variant"` // "A" or "B"
+	At      time.Time `json:"at"`
+}
+
+var instructionExperimentFile = "instruction_experiment.json"
+
+var (
+	experimentLock        sync.RWMutex
+	instructionExperiment *InstructionExperiment
+)
+
+func (e *InstructionExperiment) validate() error {
+	if strings.TrimSpace(e.ID) == "" {
+		return fmt.Errorf("id is required")
+	}
+	if e.BShare < 1 || e.BShare > 99 {
+		return fmt.Errorf("b_share must be between 1 and 99")
+	}
+	hasInstructions, hasAppend := strings.TrimSpace(e.Instructions) != "", strings.TrimSpace(e.Append) != ""
+	if hasInstructions == hasAppend {
+		return fmt.Errorf("set exactly one of instructions or append")
+	}
+	return nil
+}
+
+// experimentVariant picks a customer's variant from a hash, so the split is stable and even.
+func experimentVariant(experimentID, userId string, bShare int) string {
+	h := fnv.New32a()
+	h.Write([]byte(experimentID + ":" + userId))
+	if int(h.Sum32()%100) < bShare {
+		return "B"
+	}
+	return "A"
+}
+
+// instructionsFor returns the instructions for the user's next turn and the variant used
+// ("" when no experiment is running), assigning the user on their first turn. Channels with
+// their own instructions file use it in place of gpt_instructions.md.
+func instructionsFor(userId string) (string, string) {
+	base := systemInstructions
+	if ch, ok := channelFor(userId); ok && ch.instructions != "" {
+		base = ch.instructions
+	}
+	experimentLock.RLock()
+	exp := instructionExperiment
+	experimentLock.RUnlock()
+	if exp == nil || !exp.Enabled || isSimulatedUser(userId) {
+		return base, ""
+	}
+
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		userThreadLock.Unlock()
+		return base, ""
+	}
+	if conv.Experiment == nil || conv.Experiment.ID != exp.ID {
+		conv.Experiment = &ExperimentAssignment{ID: exp.ID, Variant: experimentVariant(exp.ID, userId, exp.BShare), At: time.Now()}
+		appMetrics.inc("experiment_assigned_" + conv.Experiment.Variant)
+		log.Printf("Assigned user %s to variant %s of experiment %s", userId, conv.Experiment.Variant, exp.ID)
+		go saveConversations()
+	}
+	variant := conv.Experiment.Variant
+	userThreadLock.Unlock()
+
+	switch {
+	case variant != "B":
+		return base, variant
+	case exp.Instructions != "":
+		return exp.Instructions, variant
+	default:
+		return base + "\n\n" + exp.Append, variant
+	}
+}
+
+func loadInstructionExperiment() {
+	data, err := os.ReadFile(instructionExperimentFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read instruction experiment file: %v", err)
+		}
+		return
+	}
+	var exp InstructionExperiment
+	if err := json.Unmarshal(data, &exp); err != nil {
+		log.Printf("Failed to parse instruction experiment file: %v", err)
+		return
+	}
+	if err := exp.validate(); err != nil {
+		log.Printf("Invalid instruction experiment file: %v", err)
+		return
+	}
+	experimentLock.Lock()
+	instructionExperiment = &exp
+	experimentLock.Unlock()
+}
+
+func handleGetInstructionExperiment(c *fiber.Ctx) error {
+	experimentLock.RLock()
+	defer experimentLock.RUnlock()
+	if instructionExperiment == nil {
+		return c.JSON(fiber.Map{})
+	}
+	return c.JSON(instructionExperiment)
+}
+
+// handleReplaceInstructionExperiment starts, changes or stops (enabled=false) the experiment.
+// A new id starts a fresh experiment: customers are re-assigned and the report starts over.
+func handleReplaceInstructionExperiment(c *fiber.Ctx) error {
+	var incoming InstructionExperiment
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	experimentLock.Lock()
+	defer experimentLock.Unlock()
+	if prev := instructionExperiment; prev != nil && prev.ID == incoming.ID {
+		if prev.BShare != incoming.BShare {
+			return respondError(c, fiber.StatusBadRequest, "b_share cannot change during an experiment; start a new id")
+		}
+		incoming.StartedAt = prev.StartedAt
+	} else {
+		incoming.StartedAt = time.Now()
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save experiment")
+	}
+	if err := os.WriteFile(instructionExperimentFile, data, 0644); err != nil {
+		log.Printf("Failed to save instruction experiment: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save experiment")
+	}
+	instructionExperiment = &incoming
+	log.Printf("Instruction experiment %s saved (enabled=%v, b_share=%d)", incoming.ID, incoming.Enabled, incoming.BShare)
+	return c.JSON(fiber.Map{"status": "ok", "experiment": incoming})
+}
+
+// VariantFunnel is the conversion funnel for one experiment variant. Outcomes only count
+// when they happened after the customer was assigned.
+type VariantFunnel struct {
+	Variant       string  `json:"variant"`
+	Conversations int     `json:"conversations"`
+	Quoted        int     `json:"quoted"`
+	Booked        int     `json:"booked"`
+	Completed     int     `json:"completed"`
+	QuoteRate     float64 `json:"quote_rate"`
+	BookingRate   float64 `json:"booking_rate"`
+	AvgTokens     float64 `json:"avg_tokens_per_turn"`
+}
+
+// handleGetExperimentReport compares the variants of the current (or ?id=) experiment.
+// booking_rate_p_value is a two-sided two-proportion z-test on B vs A booking rates.
+func handleGetExperimentReport(c *fiber.Ctx) error {
+	id := c.Query("id")
+	if id == "" {
+		experimentLock.RLock()
+		if instructionExperiment != nil {
+			id = instructionExperiment.ID
+		}
+		experimentLock.RUnlock()
+	}
+	if id == "" {
+		return respondError(c, fiber.StatusNotFound, "no experiment configured")
+	}
+
+	// latest booking per customer, so a booking made after assignment is never hidden by an older one
+	booked := map[string]time.Time{}
+	completed := map[string]time.Time{}
+	bookingLock.Lock()
+	for _, b := range bookings {
+		if b.Status == "cancelled" {
+			continue
+		}
+		if t, ok := booked[b.UserID]; !ok || b.CreatedAt.After(t) {
+			booked[b.UserID] = b.CreatedAt
+		}
+		if b.Status == "completed" {
+			if t, ok := completed[b.UserID]; !ok || b.CreatedAt.After(t) {
+				completed[b.UserID] = b.CreatedAt
+			}
+		}
+	}
+	bookingLock.Unlock()
+
+	funnels := map[string]*VariantFunnel{"A": {Variant: "A"}, "B": {Variant: "B"}}
+	turns := map[string]int{}
+	tokens := map[string]int{}
+	userThreadLock.Lock()
+	for _, conv := range userConversations {
+		a := conv.Experiment
+		if a == nil || a.ID != id {
+			continue
+		}
+		f, ok := funnels[a.Variant]
+		if !ok {
+			continue
+		}
+		f.Conversations++
+		if conv.Profile.LastQuoteAt.After(a.At) {
+			f.Quoted++
+		}
+		if t, ok := booked[conv.UserID]; ok && t.After(a.At) {
+			f.Booked++
+		}
+		if t, ok := completed[conv.UserID]; ok && t.After(a.At) {
+			f.Completed++
+		}
+		for _, m := range conv.Messages {
+			if m.Turn != nil && m.Turn.Variant == a.Variant {
+				turns[a.Variant]++
+				tokens[a.Variant] += m.Turn.totalTokens()
+			}
+		}
+	}
+	userThreadLock.Unlock()
+
+	for v, f := range funnels {
+		if f.Conversations > 0 {
+			f.QuoteRate = float64(f.Quoted) / float64(f.Conversations)
+			f.BookingRate = float64(f.Booked) / float64(f.Conversations)
+		}
+		if turns[v] > 0 {
+			f.AvgTokens = float64(tokens[v]) / float64(turns[v])
+		}
+	}
+	a, b := funnels["A"], funnels["B"]
+	result := fiber.Map{
+		"id":       id,
+		"variants": []VariantFunnel{*a, *b},
+	}
+	if p, ok := twoProportionPValue(a.Booked, a.Conversations, b.Booked, b.Conversations); ok {
+		result["booking_rate_p_value"] = p
+	}
+	return c.JSON(result)
+}
+
+// twoProportionPValue is the two-sided p-value that two conversion rates differ.
+func twoProportionPValue(x1, n1, x2, n2 int) (float64, bool) {
+	if n1 == 0 || n2 == 0 {
+		return 0, false
+	}
+	pooled := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 0, false
+	}
+	z := (float64(x2)/float64(n2) - float64(x1)/float64(n1)) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2), true
+}
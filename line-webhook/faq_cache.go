@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Common questions are answered from a curated FAQ store (faq.json, edited under
+// /admin/config/faq) without an assistant turn. Each entry lists how customers ask the
+// question; the phrasings and the incoming question are embedded (EMBEDDING_MODEL), and when
+// the closest phrasing has a cosine similarity of at least FAQ_MATCH_THRESHOLD the entry's
+// answer is sent as written. "โซฟาซักเท่าไหร่คะ" thus finds the entry asked as
+// "ซักโซฟาราคาเท่าไร", and the reply takes one embedding call instead of a model run.
+//
+// While the store has entries, the embedding is also compared with the customer's cached
+// answers (answer_cache.go), so a repeated question in other words gets the earlier answer.
+// An earlier answer with a price is only reused when both questions name the same numbers,
+// service, item and size; otherwise only the exact same question gets it back.
+// Without entries no embeddings are made. When the embedding call fails the turn goes to the
+// assistant as usual.
+//
+// Phrasing vectors are kept in faq_embeddings.json so a restart doesn't embed them again;
+// they are redone when EMBEDDING_MODEL changes.
+
+// FAQEntry is a curated answer and the questions it answers.
+type FAQEntry struct {
+	ID        string   `json:"id"`
+	Enabled   bool     `json:"enabled"`
+	Questions []string `json:"questions"` // ways customers ask it; more phrasings match more reliably
+	Answer    string   `json:"answer"`    // sent as written, so keep prices out or update it with them
+}
+
+// faqIndex is the vectors of the FAQ phrasings, as saved in faqEmbeddingsFile.
+type faqIndex struct {
+	Model   string               `json:"model"`
+	Vectors map[string][]float32 `json:"vectors"` // phrasing -> embedding
+}
+
+var (
+	faqFile           = "faq.json"
+	faqEmbeddingsFile = "faq_embeddings.json"
+)
+
+var (
+	faqLock    sync.RWMutex
+	faqEntries []FAQEntry
+	faqVectors faqIndex
+)
+
+// maxLineTextRunes is the length limit of a LINE text message.
+const maxLineTextRunes = 5000
+
+const faqEmbeddingTimeout = 5 * time.Second
+
+func validateFAQ(list []FAQEntry) error {
+	seen := map[string]bool{}
+	for i, e := range list {
+		if strings.TrimSpace(e.ID) == "" {
+			return fmt.Errorf("entry %d: id is required", i)
+		}
+		if seen[e.ID] {
+			return fmt.Errorf("duplicate entry id '%s'", e.ID)
+		}
+		seen[e.ID] = true
+		if len(e.Questions) == 0 {
+			return fmt.Errorf("entry '%s': at least one question is required", e.ID)
+		}
+		for _, q := range e.Questions {
+			if strings.TrimSpace(q) == "" {
+				return fmt.Errorf("entry '%s': questions must not be empty", e.ID)
+			}
+		}
+		if n := len([]rune(strings.TrimSpace(e.Answer))); n == 0 || n > maxLineTextRunes {
+			return fmt.Errorf("entry '%s': answer must have between 1 and %d characters", e.ID, maxLineTextRunes)
+		}
+	}
+	return nil
+}
+
+// embedTexts embeds texts with EMBEDDING_MODEL and records the tokens for the customer in ctx.
+func embedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	client, err := newOpenAIClient(30 * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	model := appConfig.EmbeddingModel
+	out, err := client.CreateEmbeddings(ctx, &openai.EmbeddingRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, openAIError(err)
+	}
+	if len(out.Vectors) != len(texts) {
+		return nil, &UpstreamError{Service: "openai", Err: fmt.Errorf("got %d embeddings for %d texts", len(out.Vectors), len(texts))}
+	}
+	recordUsage(usageUserFrom(ctx), model, out.InputTokens, 0)
+	return out.Vectors, nil
+}
+
+// indexFAQ returns the vectors of every phrasing in list, embedding those the current index
+// doesn't have.
+func indexFAQ(ctx context.Context, list []FAQEntry) (faqIndex, error) {
+	model := appConfig.EmbeddingModel
+	faqLock.RLock()
+	current := faqVectors
+	faqLock.RUnlock()
+	index := faqIndex{Model: model, Vectors: make(map[string][]float32)}
+	var missing []string
+	for _, e := range list {
+		for _, q := range e.Questions {
+			text := faqPhrasing(q)
+			if _, done := index.Vectors[text]; done {
+				continue
+			}
+			if v, ok := current.Vectors[text]; ok && current.Model == model {
+				index.Vectors[text] = v
+				continue
+			}
+			index.Vectors[text] = nil
+			missing = append(missing, text)
+		}
+	}
+	// The embeddings endpoint takes up to 2048 inputs per request
+	for start := 0; start < len(missing); start += 2048 {
+		batch := missing[start:min(start+2048, len(missing))]
+		vectors, err := embedTexts(ctx, batch)
+		if err != nil {
+			return faqIndex{}, err
+		}
+		for i, text := range batch {
+			index.Vectors[text] = vectors[i]
+		}
+	}
+	return index, nil
+}
+
+// faqPhrasing normalizes a question for embedding, so the FAQ phrasings and incoming
+// messages go through the same cleanup.
+func faqPhrasing(q string) string {
+	if text, ok := questionText(q); ok {
+		return text
+	}
+	return strings.ToLower(strings.Join(strings.Fields(normalizeInboundText(q)), " "))
+}
+
+func faqEnabled() bool {
+	faqLock.RLock()
+	defer faqLock.RUnlock()
+	return len(faqEntries) > 0
+}
+
+// similarAnswer is an answer found by lookupSimilarAnswer.
+type similarAnswer struct {
+	Answer string
+	Path   string // "faq" or "cache"
+	Match  string // FAQ entry ID, or the cached question
+	Score  float64
+}
+
+// lookupSimilarAnswer embeds the question and returns the best FAQ answer or, failing that,
+// the customer's cached answer to a question close enough to it. A cached answer with a price
+// is only reused for a question about the same thing (samePricedSubject). The question's vector is
+// returned either way, for storeCachedAnswer; it is nil when no embedding was made.
+func lookupSimilarAnswer(ctx context.Context, userId, message string) (similarAnswer, []float32, bool) {
+	if !faqEnabled() {
+		return similarAnswer{}, nil, false
+	}
+	text, ok := questionText(message)
+	if !ok {
+		return similarAnswer{}, nil, false
+	}
+	embedCtx, cancel := context.WithTimeout(withUsageUser(ctx, userId), faqEmbeddingTimeout)
+	defer cancel()
+	vectors, err := embedTexts(embedCtx, []string{text})
+	if err != nil {
+		loggerFrom(ctx).Warn("Question embedding failed; asking the assistant", "error", err)
+		appMetrics.inc("faq_embedding_errors")
+		return similarAnswer{}, nil, false
+	}
+	vector := vectors[0]
+	threshold := appConfig.FAQMatchThreshold
+
+	if best, ok := matchFAQ(vector); ok && best.Score >= threshold {
+		appMetrics.inc("faq_hits")
+		return best, vector, true
+	}
+	appMetrics.inc("faq_misses")
+
+	messageKey, ok := answerCacheKey(message)
+	if !ok {
+		return similarAnswer{}, vector, false
+	}
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	c := userAnswerCache[userId]
+	if c == nil {
+		return similarAnswer{}, vector, false
+	}
+	var best similarAnswer
+	var bestKey string
+	for key, a := range c.entries {
+		if a.Vector == nil || time.Since(a.At) > appConfig.AnswerCacheTTL {
+			continue
+		}
+		if bahtAmountPattern.MatchString(a.Answer) && !samePricedSubject(messageKey, key) {
+			continue
+		}
+		if score := cosineSimilarity(vector, a.Vector); score > best.Score {
+			best = similarAnswer{Answer: a.Answer, Path: "cache", Match: key, Score: score}
+			bestKey = key
+		}
+	}
+	if best.Score < threshold {
+		return similarAnswer{}, vector, false
+	}
+	c.touch(bestKey)
+	appMetrics.inc("answer_cache_similar_hits")
+	return best, vector, true
+}
+
+// questionNumberPattern finds the numbers in a question, e.g. the 5 and 2 of "ที่นอน 5 ฟุต 2 หลัง".
+var questionNumberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// samePricedSubject reports whether two cache keys ask about the same thing as far as a price
+// goes: the same numbers, service, item, size and quantity. Embeddings put "ที่นอน 5 ฟุต" and
+// "ที่นอน 6 ฟุต" close together, so similarity alone would replay the wrong quote.
+func samePricedSubject(a, b string) bool {
+	numbers := func(s string) []string {
+		return questionNumberPattern.FindAllString(strings.ReplaceAll(convertThaiDigits(s), ",", ""), -1)
+	}
+	if !slices.Equal(numbers(a), numbers(b)) {
+		return false
+	}
+	engine := pricingEngine()
+	if engine.Config == nil {
+		return false
+	}
+	return engine.ServiceIn(a) == engine.ServiceIn(b) && engine.ExtractSize(a) == engine.ExtractSize(b)
+}
+
+// matchFAQ returns the enabled entry with the phrasing closest to vector.
+func matchFAQ(vector []float32) (similarAnswer, bool) {
+	faqLock.RLock()
+	defer faqLock.RUnlock()
+	var best similarAnswer
+	found := false
+	for _, e := range faqEntries {
+		if !e.Enabled {
+			continue
+		}
+		for _, q := range e.Questions {
+			v := faqVectors.Vectors[faqPhrasing(q)]
+			if v == nil {
+				continue
+			}
+			if score := cosineSimilarity(vector, v); !found || score > best.Score {
+				best = similarAnswer{Answer: e.Answer, Path: "faq", Match: e.ID, Score: score}
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// loadFAQ reads the FAQ store and its saved vectors, and embeds any phrasings missing from
+// them in the background.
+func loadFAQ() {
+	data, err := os.ReadFile(faqFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read FAQ file: %v", err)
+		}
+		return
+	}
+	var list []FAQEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Failed to parse FAQ file: %v", err)
+		return
+	}
+	if err := validateFAQ(list); err != nil {
+		log.Printf("Invalid FAQ file: %v", err)
+		return
+	}
+	var index faqIndex
+	if data, err := os.ReadFile(faqEmbeddingsFile); err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			log.Printf("Failed to parse FAQ embeddings file; embedding the questions again: %v", err)
+			index = faqIndex{}
+		}
+	}
+	faqLock.Lock()
+	faqEntries = list
+	faqVectors = index
+	faqLock.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		updated, err := indexFAQ(ctx, list)
+		if err != nil {
+			log.Printf("Failed to embed FAQ questions; unembedded ones won't match: %v", err)
+			return
+		}
+		faqLock.Lock()
+		faqVectors = updated
+		faqLock.Unlock()
+		if err := saveFAQEmbeddings(updated); err != nil {
+			log.Printf("Failed to save FAQ embeddings: %v", err)
+		}
+	}()
+}
+
+func saveFAQEmbeddings(index faqIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmpPath := faqEmbeddingsFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, faqEmbeddingsFile)
+}
+
+func handleGetFAQ(c *fiber.Ctx) error {
+	faqLock.RLock()
+	defer faqLock.RUnlock()
+	if faqEntries == nil {
+		return c.JSON([]FAQEntry{})
+	}
+	return c.JSON(faqEntries)
+}
+
+// handleReplaceFAQ embeds the new phrasings before saving, so a store that can't be indexed
+// is rejected instead of silently not matching.
+func handleReplaceFAQ(c *fiber.Ctx) error {
+	var incoming []FAQEntry
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := validateFAQ(incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	index, err := indexFAQ(c.Context(), incoming)
+	if err != nil {
+		log.Printf("Failed to embed FAQ questions: %v", err)
+		return respondError(c, fiber.StatusBadGateway, "unable to embed FAQ questions: "+err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save FAQ")
+	}
+	if err := os.WriteFile(faqFile, data, 0644); err != nil {
+		log.Printf("Failed to save FAQ: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save FAQ")
+	}
+	if err := saveFAQEmbeddings(index); err != nil {
+		log.Printf("Failed to save FAQ embeddings: %v", err)
+	}
+	faqLock.Lock()
+	faqEntries = incoming
+	faqVectors = index
+	faqLock.Unlock()
+	log.Printf("FAQ updated: %d entries", len(incoming))
+	return c.JSON(fiber.Map{"status": "ok", "faq": incoming})
+}
+
+// handleMatchFAQ shows which entry a question would get and how close it is, for tuning the
+// phrasings and FAQ_MATCH_THRESHOLD. Body: {"text": "..."}.
+func handleMatchFAQ(c *fiber.Ctx) error {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Text) == "" {
+		return respondError(c, fiber.StatusBadRequest, "text is required")
+	}
+	vectors, err := embedTexts(c.Context(), []string{faqPhrasing(body.Text)})
+	if err != nil {
+		return respondError(c, fiber.StatusBadGateway, "unable to embed text: "+err.Error())
+	}
+	best, ok := matchFAQ(vectors[0])
+	if !ok {
+		return c.JSON(fiber.Map{"matched": false, "threshold": appConfig.FAQMatchThreshold})
+	}
+	return c.JSON(fiber.Map{
+		"matched":   best.Score >= appConfig.FAQMatchThreshold,
+		"id":        best.Match,
+		"score":     best.Score,
+		"threshold": appConfig.FAQMatchThreshold,
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSamePricedSubject(t *testing.T) {
+	if err := loadPricingConfig(); err != nil {
+		t.Fatal(err)
+	}
+	key := func(s string) string {
+		text, ok := questionText(s)
+		if !ok {
+			t.Fatalf("questionText(%q) found no question", s)
+		}
+		return strings.ReplaceAll(text, " ", "") // as answerCacheKey stores it
+	}
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"ที่นอน 6 ฟุต ซักเท่าไหร่คะ", "ซักที่นอน 6 ฟุต ราคาเท่าไร", true},
+		// spelled-out numbers aren't matched against digits, so these miss rather than risk it
+		{"ที่นอน 6 ฟุต ซักเท่าไหร่คะ", "ที่นอนหกฟุตซักเท่าไหร่", false},
+		{"ที่นอน 6 ฟุต ซักเท่าไหร่คะ", "ที่นอน 5 ฟุต ซักเท่าไหร่คะ", false},
+		{"ที่นอน 6 ฟุต 2 หลัง ซักเท่าไหร่", "ที่นอน 6 ฟุต 3 หลัง ซักเท่าไหร่", false},
+		{"ซักโซฟา 3 ที่นั่ง ราคาเท่าไหร่", "กำจัดเชื้อโรคโซฟา 3 ที่นั่ง ราคาเท่าไหร่", false},
+		{"ซักโซฟา 3 ที่นั่ง ราคาเท่าไหร่", "ซักที่นอน 3 ฟุต ราคาเท่าไหร่", false},
+		{"ซักโซฟา ๓ ที่นั่ง ราคาเท่าไหร่", "โซฟา 3 ที่นั่ง ซักราคาเท่าไร", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" / "+tt.b, func(t *testing.T) {
+			if got := samePricedSubject(key(tt.a), key(tt.b)); got != tt.want {
+				t.Errorf("samePricedSubject(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
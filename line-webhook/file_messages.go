@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxInboundFileBytes caps documents downloaded from LINE (LINE itself allows up to 300 MB).
+const maxInboundFileBytes = 20 << 20
+
+// maxDocumentTextRunes caps the extracted text sent for summarizing.
+const maxDocumentTextRunes = 30000
+
+const documentSummaryInstructions = `คุณช่วยทีม NCS (บริการทำความสะอาดที่นอน โซฟา ม่าน พรม) อ่านเอกสารที่ลูกค้าส่งมา เช่น หนังสืออนุญาตเข้างานของคอนโด แปลนห้อง หรือใบเสนอราคา
+สรุปเป็นภาษาไทยไม่เกิน 8 บรรทัด เน้นข้อมูลที่ใช้วางแผนงาน: ประเภทเอกสาร ชื่อโครงการ/อาคาร ห้อง วันเวลาที่อนุญาต ข้อกำหนดการเข้าพื้นที่ ขนาดห้องหรือจำนวนเฟอร์นิเจอร์ และชื่อ/เบอร์ผู้ติดต่อ
+ห้ามเดาข้อมูลที่ไม่มีในเอกสาร`
+
+var fileNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// downloadLineContent fetches the binary content of an inbound LINE message.
+func downloadLineContent(userId, messageID string) ([]byte, error) {
+	channelToken := lineAccessToken(userId)
+	if channelToken == "" {
+		return nil, fmt.Errorf("LINE channel access token not set")
+	}
+	req, err := http.NewRequest("GET", "https://api-data.line.me/v2/bot/message/"+messageID+"/content", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+channelToken)
+	resp, err := (&http.Client{Timeout: 60 * time.Second, Transport: lineTransport}).Do(req)
+	if err != nil {
+		return nil, classifyRequestError("line_content", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &UpstreamError{Service: "line_content", StatusCode: resp.StatusCode, Err: errors.New(string(body))}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxInboundFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInboundFileBytes {
+		return nil, fmt.Errorf("file is larger than %d MB", maxInboundFileBytes>>20)
+	}
+	return data, nil
+}
+
+// docxText pulls the paragraph text out of a Word .docx file.
+func docxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not a docx file: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		var b strings.Builder
+		dec := xml.NewDecoder(rc)
+		inText := false
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return strings.TrimSpace(b.String()), nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid document.xml: %w", err)
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				inText = t.Name.Local == "t"
+				if t.Name.Local == "tab" {
+					b.WriteString("\t")
+				}
+			case xml.EndElement:
+				inText = false
+				if t.Name.Local == "p" {
+					b.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					b.Write(t)
+				}
+			}
+		}
+	}
+	return "", errors.New("docx has no word/document.xml")
+}
+
+// documentSummaryInput builds the model input for a document: extracted text where we can
+// read it ourselves, or the PDF itself for the model to read.
+func documentSummaryInput(fileName string, data []byte) (interface{}, error) {
+	header := "ชื่อไฟล์: " + fileName
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".pdf":
+		return []interface{}{map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "input_text", "text": header},
+				map[string]interface{}{"type": "input_file", "filename": fileName, "file_data": "data:application/pdf;base64," + base64.StdEncoding.EncodeToString(data)},
+			},
+		}}, nil
+	case ".docx":
+		text, err := docxText(data)
+		if err != nil {
+			return nil, err
+		}
+		return header + "\n\n" + truncateRunes(text, maxDocumentTextRunes), nil
+	case ".txt", ".csv":
+		if !utf8.Valid(data) {
+			return nil, errors.New("text file is not UTF-8")
+		}
+		return header + "\n\n" + truncateRunes(string(data), maxDocumentTextRunes), nil
+	}
+	return nil, fmt.Errorf("unsupported file type %q", filepath.Ext(fileName))
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "\n…(ตัดทอน)"
+}
+
+// routeMediaMessage downloads a file or voice note and routes the text made from it like any
+// other message. It runs after the webhook request has answered: the download, summary or
+// transcription can take a minute, and LINE redelivers webhooks that aren't answered within
+// seconds (the redelivery would be skipped as a duplicate, losing the message).
+func routeMediaMessage(reqLog *slog.Logger, m inboundMessage, messageID, fileName string, durationMs int) {
+	if m.Type == "audio" {
+		m.Content = handleInboundAudio(m.UserID, messageID, durationMs)
+	} else {
+		m.Content = handleInboundFile(m.UserID, messageID, fileName)
+	}
+	routeInboundMessage(reqLog, m)
+}
+
+// handleInboundFile stores a document the customer sent and returns the message text that
+// goes into the conversation: the file name plus a summary the assistant can refer to.
+func handleInboundFile(userId, messageID, fileName string) string {
+	data, err := downloadLineContent(userId, messageID)
+	if err != nil {
+		log.Printf("Failed to download file %s from %s: %v", fileName, userId, err)
+		return fmt.Sprintf("ลูกค้าส่งไฟล์ \"%s\" (ระบบไม่สามารถเปิดไฟล์ได้ ให้ขอให้ลูกค้าส่งเป็นรูปภาพหรือพิมพ์รายละเอียดแทน)", fileName)
+	}
+	appMetrics.inc("inbound_files")
+
+	ctx, cancel := context.WithTimeout(withUsageUser(context.Background(), userId), 45*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("files/%s/%s-%s", userId, messageID, fileNameUnsafe.ReplaceAllString(fileName, "_"))
+	if err := coldStore.Put(ctx, key, data); err != nil {
+		log.Printf("Failed to store file %s from %s: %v", fileName, userId, err)
+	} else {
+		log.Printf("Stored file %s from %s as %s", fileName, userId, key)
+	}
+
+	input, err := documentSummaryInput(fileName, data)
+	if err != nil {
+		log.Printf("Cannot read file %s from %s: %v", fileName, userId, err)
+		return fmt.Sprintf("ลูกค้าส่งไฟล์ \"%s\" (ระบบอ่านเนื้อหาไฟล์ประเภทนี้ไม่ได้ เจ้าหน้าที่เปิดดูได้ภายหลัง ให้ถามลูกค้าว่าไฟล์เกี่ยวกับอะไร)", fileName)
+	}
+	summary, err := requestOpenAIText(ctx, "gpt-4.1-mini", documentSummaryInstructions, input)
+	if err != nil {
+		log.Printf("Failed to summarize file %s from %s: %v", fileName, userId, err)
+		appMetrics.inc("inbound_file_summary_errors")
+		return fmt.Sprintf("ลูกค้าส่งไฟล์ \"%s\" (สรุปเนื้อหาไม่สำเร็จ เจ้าหน้าที่เปิดดูได้ภายหลัง ให้ถามลูกค้าว่าไฟล์เกี่ยวกับอะไร)", fileName)
+	}
+	return fmt.Sprintf("ลูกค้าส่งไฟล์ \"%s\"\nสรุปเนื้อหาเอกสาร:\n%s", fileName, summary)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google APIs are called as a service account (GOOGLE_SERVICE_ACCOUNT_FILE, the JSON key
+// downloaded from the Cloud console). An access token is obtained with a signed JWT and kept
+// until shortly before it expires, so calendar reads don't pay for a token exchange.
+
+const googleSheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+type googleServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// googleCredentials is nil without GOOGLE_SERVICE_ACCOUNT_FILE.
+var googleCredentials *googleServiceAccount
+
+// loadGoogleServiceAccount reads GOOGLE_SERVICE_ACCOUNT_FILE, if set.
+func loadGoogleServiceAccount() error {
+	path := appConfig.GoogleServiceAccountFile
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sa googleServiceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return fmt.Errorf("%s: client_email and private_key are required", path)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return fmt.Errorf("%s: private_key is not PEM", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("%s: private_key is not an RSA key", path)
+	}
+	sa.key = key
+	googleCredentials = &sa
+	return nil
+}
+
+// AccessToken returns a token for the Sheets scope, exchanging a new JWT when the cached one
+// is about to expire.
+func (sa *googleServiceAccount) AccessToken(ctx context.Context) (string, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	if sa.token != "" && time.Until(sa.expiry) > time.Minute {
+		return sa.token, nil
+	}
+	assertion, err := sa.signedJWT(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return "", classifyRequestError("google_auth", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", &UpstreamError{Service: "google_auth", StatusCode: resp.StatusCode, Err: errors.New(truncateRunes(string(body), 300))}
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.AccessToken == "" {
+		return "", &UpstreamError{Service: "google_auth", StatusCode: resp.StatusCode, Err: errors.New("no access_token in the response")}
+	}
+	sa.token = out.AccessToken
+	sa.expiry = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	appMetrics.inc("google_tokens_issued")
+	return sa.token, nil
+}
+
+// signedJWT builds the RS256 assertion for the token exchange.
+func (sa *googleServiceAccount) signedJWT(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": googleSheetsScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CannedGreeting is sent instantly on a customer's very first message, while the
+// assistant turn runs as usual and is delivered by push afterwards.
+type CannedGreeting struct {
+	Enabled      bool               `json:"enabled"`
+	Text         string             `json:"text"`
+	QuickReplies []QuickReplyOption `json:"quick_replies,omitempty"`
+}
+
+// QuickReplyOption is a LINE quick reply button that sends Text when tapped. With Data set
+// it sends that postback instead, and Text is only shown in the chat.
+type QuickReplyOption struct {
+	Label string `json:"label"` // max 20 characters
+	Text  string `json:"text"`
+	Data  string `json:"data,omitempty"`
+}
+
+var greetingFile = "greeting.json"
+
+var (
+	greetingLock   sync.RWMutex
+	cannedGreeting = CannedGreeting{
+		Enabled: true,
+		Text:    "สวัสดีค่ะ ยินดีต้อนรับสู่ NCS บริการทำความสะอาดที่นอน โซฟา ม่าน และพรม 😊 กำลังดูข้อความของคุณลูกค้าอยู่ รอสักครู่นะคะ",
+		QuickReplies: []QuickReplyOption{
+			{Label: "เช็คราคา", Text: "ขอเช็คราคาค่ะ"},
+			{Label: "ดูวันว่าง", Text: "ขอดูวันว่างค่ะ"},
+			{Label: "หลังใช้บริการ", Text: "สอบถามหลังใช้บริการค่ะ", Data: "action=mode&mode=aftercare"},
+			{Label: "คุยกับเจ้าหน้าที่", Text: "ขอคุยกับเจ้าหน้าที่ค่ะ"},
+		},
+	}
+)
+
+func (g CannedGreeting) validate() error {
+	if g.Enabled && g.Text == "" {
+		return fmt.Errorf("text is required when the greeting is enabled")
+	}
+	if len(g.QuickReplies) > 13 {
+		return fmt.Errorf("LINE allows at most 13 quick replies")
+	}
+	for i, q := range g.QuickReplies {
+		if q.Label == "" || q.Text == "" {
+			return fmt.Errorf("quick_replies[%d]: label and text are required", i)
+		}
+		if len([]rune(q.Label)) > 20 {
+			return fmt.Errorf("quick_replies[%d]: label must be at most 20 characters", i)
+		}
+	}
+	return nil
+}
+
+// lineMessage renders the greeting as a LINE text message with quick reply buttons.
+func (g CannedGreeting) lineMessage() map[string]interface{} {
+	msg := map[string]interface{}{"type": "text", "text": g.Text}
+	attachQuickReplies(msg, g.QuickReplies)
+	return msg
+}
+
+// attachQuickReplies adds quick reply buttons to a LINE message object.
+func attachQuickReplies(msg map[string]interface{}, options []QuickReplyOption) {
+	if len(options) == 0 {
+		return
+	}
+	items := make([]map[string]interface{}, 0, len(options))
+	for _, q := range options {
+		action := map[string]interface{}{"type": "message", "label": q.Label, "text": q.Text}
+		if q.Data != "" {
+			action = map[string]interface{}{"type": "postback", "label": q.Label, "data": q.Data, "displayText": q.Text}
+		}
+		items = append(items, map[string]interface{}{"type": "action", "action": action})
+	}
+	msg["quickReply"] = map[string]interface{}{"items": items}
+}
+
+// sendFirstTimeGreeting replies to a new customer with the canned greeting and reports
+// whether the reply token was used, in which case the assistant answer must be pushed.
+func sendFirstTimeGreeting(userId, replyToken string) bool {
+	greetingLock.RLock()
+	g := cannedGreeting
+	greetingLock.RUnlock()
+	if !g.Enabled || replyToken == "" {
+		return false
+	}
+	replyOrPush(userId, replyToken, []map[string]interface{}{g.lineMessage()})
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.GreetedAt = time.Now()
+	}
+	userThreadLock.Unlock()
+	appMetrics.inc("first_time_greetings")
+	return true
+}
+
+// greetingSentNote tells the model the customer was already greeted, so it answers directly.
+func greetingSentNote() string {
+	return "[ระบบ] ลูกค้าได้รับข้อความต้อนรับอัตโนมัติแล้ว ไม่ต้องทักทายหรือแนะนำตัวซ้ำ ให้ตอบข้อความของลูกค้าได้เลย"
+}
+
+func loadGreeting() {
+	data, err := os.ReadFile(greetingFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read greeting file: %v", err)
+		}
+		return
+	}
+	var g CannedGreeting
+	if err := json.Unmarshal(data, &g); err != nil {
+		log.Printf("Failed to parse greeting file: %v", err)
+		return
+	}
+	if err := g.validate(); err != nil {
+		log.Printf("Invalid greeting file: %v", err)
+		return
+	}
+	greetingLock.Lock()
+	cannedGreeting = g
+	greetingLock.Unlock()
+}
+
+func handleGetGreeting(c *fiber.Ctx) error {
+	greetingLock.RLock()
+	defer greetingLock.RUnlock()
+	return c.JSON(cannedGreeting)
+}
+
+func handleReplaceGreeting(c *fiber.Ctx) error {
+	var incoming CannedGreeting
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save greeting")
+	}
+	if err := os.WriteFile(greetingFile, data, 0644); err != nil {
+		log.Printf("Failed to save greeting: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save greeting")
+	}
+	greetingLock.Lock()
+	cannedGreeting = incoming
+	greetingLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "greeting": incoming})
+}
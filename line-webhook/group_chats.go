@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// In LINE groups and multi-person chats the bot only answers messages addressed to it: ones
+// that @-mention the bot, or that start with GROUP_TRIGGER_PREFIX (e.g. "ncs"). Everything
+// else is ignored and never stored. Each group or room has its own conversation, keyed by
+// its group or room ID (IDs start with C and R, user IDs with U), so pushes, history and
+// takeover work as they do for one-to-one chats.
+
+// LineSource is the source of a webhook event.
+type LineSource struct {
+	Type    string `json:"type"` // user, group or room
+	UserID  string `json:"userId"`
+	GroupID string `json:"groupId"`
+	RoomID  string `json:"roomId"`
+}
+
+// conversationID is the key of the conversation the event belongs to.
+func (s LineSource) conversationID() string {
+	switch {
+	case s.GroupID != "":
+		return s.GroupID
+	case s.RoomID != "":
+		return s.RoomID
+	}
+	return s.UserID
+}
+
+// isGroupChat reports whether the conversation ID is a group or multi-person chat.
+func isGroupChat(id string) bool {
+	return strings.HasPrefix(id, "C") || strings.HasPrefix(id, "R")
+}
+
+// LineMention is a mention in a text message. Index and Length count UTF-16 code units.
+type LineMention struct {
+	Index  int    `json:"index"`
+	Length int    `json:"length"`
+	Type   string `json:"type"` // user or all
+	UserID string `json:"userId"`
+	IsSelf bool   `json:"isSelf"` // the bot was mentioned
+}
+
+func groupTriggerPrefix() string {
+	return appConfig.GroupTriggerPrefix
+}
+
+// addressedGroupText returns the text without the bot's mention or the trigger prefix, and
+// whether the message was addressed to the bot at all.
+func addressedGroupText(text string, mentions []LineMention) (string, bool) {
+	units := utf16.Encode([]rune(text))
+	mentioned := false
+	// removed from the end so earlier indexes stay valid
+	for i := len(mentions) - 1; i >= 0; i-- {
+		m := mentions[i]
+		if !m.IsSelf || m.Index < 0 || m.Index+m.Length > len(units) {
+			continue
+		}
+		units = append(units[:m.Index:m.Index], units[m.Index+m.Length:]...)
+		mentioned = true
+	}
+	if mentioned {
+		if rest := strings.TrimSpace(string(utf16.Decode(units))); rest != "" {
+			return rest, true
+		}
+		return text, true // only the mention; the assistant greets the group
+	}
+	prefix := groupTriggerPrefix()
+	trimmed := strings.TrimSpace(text)
+	if prefix != "" && len(trimmed) >= len(prefix) && strings.EqualFold(trimmed[:len(prefix)], prefix) {
+		if rest := strings.TrimSpace(trimmed[len(prefix):]); rest != "" {
+			return rest, true
+		}
+		return trimmed, true
+	}
+	return "", false
+}
+
+// lineChatNameURL is the LINE endpoint naming the conversation: the user's profile or the
+// group summary. Multi-person rooms have no name.
+func lineChatNameURL(id string) (string, bool) {
+	switch {
+	case strings.HasPrefix(id, "C"):
+		return "https://api.line.me/v2/bot/group/" + id + "/summary", true
+	case strings.HasPrefix(id, "R"):
+		return "", false
+	}
+	return "https://api.line.me/v2/bot/profile/" + id, true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// HandoffSummary is a short Thai brief for the agent picking up an escalated conversation.
+type HandoffSummary struct {
+	Text      string    `json:"text"`
+	Reason    string    `json:"reason"`
+	Generated bool      `json:"generated"` // false when the AI summary failed and a transcript excerpt is used
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const handoffSummaryInstructions = `สรุปบทสนทนาระหว่างลูกค้ากับแชทบอท NCS เพื่อส่งต่อให้เจ้าหน้าที่ ตอบเป็นภาษาไทย ไม่เกิน 6 บรรทัด ใช้หัวข้อต่อไปนี้ (ข้ามหัวข้อที่ไม่มีข้อมูล):
+• ความต้องการ:
+• สินค้า/ขนาด:
+• ราคาที่เสนอแล้ว:
+• วันนัด/สถานที่:
+• ติดขัดเรื่อง:
+ห้ามแต่งข้อมูลที่ไม่มีในบทสนทนา`
+
+// staffAlertRecipients returns the LINE user IDs that receive handoff alerts (STAFF_ALERT_LINE_USER_IDS, comma separated).
+func staffAlertRecipients() []string {
+	return appConfig.StaffAlertUserIDs
+}
+
+// startHandoffSummary summarizes the conversation for staff and sends the alert.
+// Call it in a goroutine when a conversation first becomes WantsHuman.
+func startHandoffSummary(userId, reason string) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		userThreadLock.Unlock()
+		return
+	}
+	msgs := conv.Messages
+	if len(msgs) > 30 {
+		msgs = msgs[len(msgs)-30:]
+	}
+	transcript := make([]ConversationMessage, len(msgs))
+	copy(transcript, msgs)
+	name := conv.Nickname
+	if name == "" {
+		name = conv.DisplayName
+	}
+	userThreadLock.Unlock()
+
+	summary := &HandoffSummary{Reason: reason, CreatedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(withUsageUser(context.Background(), userId), 30*time.Second)
+	text, err := summarizeTranscript(ctx, transcript)
+	cancel()
+	if err != nil {
+		log.Printf("Handoff summary for %s failed (%s): %v", userId, errorKind(err), err)
+		appMetrics.inc("handoff_summary_failures")
+		text = transcriptExcerpt(transcript, 5)
+	} else {
+		summary.Generated = true
+	}
+	summary.Text = text
+
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.Handoff = summary
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	appMetrics.inc("handoff_summaries")
+
+	if name == "" {
+		name = "…" + userId[max(0, len(userId)-8):]
+	}
+	alert := fmt.Sprintf("🆘 ลูกค้า %s ต้องการเจ้าหน้าที่ (%s)\n%s", name, reason, summary.Text)
+	alertStaff(userId, alert, "handoff")
+}
+
+// alertStaff pushes an alert about a customer to their branch team (or all staff).
+// Alerts about simulated customers are captured instead of sent.
+func alertStaff(customerId, alert, reason string) {
+	if captureLineMessage(customerId, "staff_alert", []map[string]interface{}{{"type": "text", "text": alert}}) {
+		return
+	}
+	for _, staffId := range branchStaffRecipients(customerId) {
+		if err := pushLineMessageWithPriority(staffId, alert, pushTransactional, reason); err != nil {
+			log.Printf("Failed to send %s alert to %s: %v", reason, staffId, err)
+		}
+	}
+}
+
+// summarizeTranscript asks the model for a handoff brief of the given messages.
+func summarizeTranscript(ctx context.Context, msgs []ConversationMessage) (string, error) {
+	return requestOpenAIText(ctx, "gpt-4.1-mini", handoffSummaryInstructions, transcriptText(msgs))
+}
+
+// transcriptText renders messages one per line with a Thai speaker label.
+func transcriptText(msgs []ConversationMessage) string {
+	var transcript strings.Builder
+	for _, m := range msgs {
+		role := map[string]string{"customer": "ลูกค้า", "ai": "บอท", "admin": "เจ้าหน้าที่"}[m.Role]
+		fmt.Fprintf(&transcript, "%s: %s\n", role, m.Text)
+	}
+	return transcript.String()
+}
+
+// requestOpenAIText runs a single tool-less model call and returns the output text.
+// input is a plain string or a list of input items.
+func requestOpenAIText(ctx context.Context, model, instructions string, input interface{}) (string, error) {
+	return requestOpenAI(ctx, model, instructions, input, nil)
+}
+
+// requestOpenAI is requestOpenAIText with an optional structured output schema.
+func requestOpenAI(ctx context.Context, model, instructions string, input interface{}, schema *openai.JSONSchema) (string, error) {
+	provider, err := newLLMProvider(0)
+	if err != nil {
+		return "", err
+	}
+	items, ok := input.([]interface{})
+	if !ok {
+		items = []interface{}{openai.Message{Role: "user", Content: input}}
+	}
+	resp, err := provider.Respond(ctx, &LLMRequest{Instructions: instructions, Input: items, Params: RunParams{Model: model}, Schema: schema})
+	if err != nil {
+		return "", err
+	}
+	recordUsage(usageUserFrom(ctx), model, resp.InputTokens, resp.OutputTokens)
+	if text := strings.TrimSpace(resp.Text); text != "" {
+		return text, nil
+	}
+	return "", &UpstreamError{Service: "openai", Err: errNoAssistantReply}
+}
+
+// transcriptExcerpt is the fallback brief: the customer's last few messages.
+func transcriptExcerpt(msgs []ConversationMessage, n int) string {
+	var lines []string
+	for i := len(msgs) - 1; i >= 0 && len(lines) < n; i-- {
+		if msgs[i].Role == "customer" {
+			lines = append([]string{"• " + msgs[i].Text}, lines...)
+		}
+	}
+	if len(lines) == 0 {
+		return "(ไม่มีข้อความจากลูกค้า)"
+	}
+	return "ข้อความล่าสุดของลูกค้า:\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /healthz answers as long as the process serves HTTP (liveness), and shows which model
+// endpoint new turns use (see llm_failover.go). GET /readyz checks what a customer turn needs
+// and answers 503 when something is missing, so load balancers and Kubernetes take the
+// instance out of rotation instead of customers finding out:
+//   - the pricing config is loaded;
+//   - LINE access tokens (the default and every channel's) and CHATGPT_API_KEY are set;
+//   - the conversation log and members databases answer, when configured;
+//   - with READYZ_OPENAI_PING=true, the OpenAI API answers (checked at most once a minute).
+
+// pinger is implemented by stores backed by a database.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func (r *sqlConversationRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (d *sqlMemberDirectory) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func handleHealthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok", "llm": llmFailover.status()})
+}
+
+func handleReadyz(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer cancel()
+	checks := readinessChecks(ctx)
+	status, code := "ready", fiber.StatusOK
+	for _, result := range checks {
+		if result != "ok" {
+			status, code = "not_ready", fiber.StatusServiceUnavailable
+		}
+	}
+	if code != fiber.StatusOK {
+		appMetrics.inc("readyz_failures")
+	}
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
+
+// readinessChecks returns "ok" or the problem for each check that applies.
+func readinessChecks(ctx context.Context) map[string]string {
+	checks := make(map[string]string)
+	checks["pricing_config"] = "ok"
+	if pricingConfig == nil {
+		checks["pricing_config"] = "not loaded"
+	}
+	checks["line_token"] = lineTokenProblem()
+	checks["openai_key"] = "ok"
+	if appConfig.OpenAIAPIKey == "" {
+		checks["openai_key"] = "CHATGPT_API_KEY not set"
+	}
+	if p, ok := conversationRepo.(pinger); ok {
+		checks["conversation_log_db"] = pingResult(ctx, p)
+	}
+	if p, ok := memberDirectory.(pinger); ok {
+		checks["members_db"] = pingResult(ctx, p)
+	}
+	if appConfig.ReadyzOpenAIPing {
+		checks["openai"] = openAIPingResult(ctx)
+	}
+	return checks
+}
+
+func pingResult(ctx context.Context, p pinger) string {
+	if err := p.Ping(ctx); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// lineTokenProblem checks the default access token and those of the configured channels.
+func lineTokenProblem() string {
+	var missing []string
+	if appConfig.LineAccessToken == "" {
+		missing = append(missing, "LINE_CHANNEL_ACCESS_TOKEN")
+	}
+	channelLock.RLock()
+	for _, ch := range lineChannels {
+		if ch.accessToken() == "" {
+			missing = append(missing, "LINE_CHANNEL_ACCESS_TOKEN_"+channelEnvSuffix(ch.ID))
+		}
+	}
+	channelLock.RUnlock()
+	if len(missing) > 0 {
+		return strings.Join(missing, ", ") + " not set"
+	}
+	return "ok"
+}
+
+var (
+	openAIPingLock sync.Mutex
+	openAIPingAt   time.Time
+	openAIPingLast string
+)
+
+// openAIPingResult lists models as a cheap authenticated call, reusing the answer for a minute
+// so frequent probes don't turn into API traffic.
+func openAIPingResult(ctx context.Context) string {
+	openAIPingLock.Lock()
+	defer openAIPingLock.Unlock()
+	if time.Since(openAIPingAt) < time.Minute {
+		return openAIPingLast
+	}
+	client, err := newOpenAIClient(5 * time.Second)
+	if err == nil {
+		_, err = client.Do(ctx, "GET", "/models", nil, "")
+	}
+	openAIPingAt, openAIPingLast = time.Now(), "ok"
+	if err != nil {
+		openAIPingLast = openAIError(err).Error()
+	}
+	return openAIPingLast
+}
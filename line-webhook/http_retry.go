@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OpenAI and LINE calls and calendar reads go through retryTransport, which retries answers
+// that mean "try again later" (5xx, and 429 unless the caller handles it) with jittered
+// exponential backoff. A Retry-After header longer than the computed delay is waited out; one
+// longer than the maximum delay ends the retries. Requests whose body can't be replayed are
+// not retried. LINE 429s are left to sendWithLineRateLimit, which also throttles the other
+// senders.
+
+const (
+	httpRetryBaseDelay = 500 * time.Millisecond // doubled on every retry
+	httpRetryMaxDelay  = 20 * time.Second
+)
+
+func httpRetryAttempts() int {
+	return appConfig.HTTPRetryAttempts
+}
+
+type retryTransport struct {
+	base     http.RoundTripper
+	service  string // names the http_retries_<service> metric
+	retry429 bool
+	attempts func() int // retries allowed; nil uses HTTP_RETRY_ATTEMPTS
+}
+
+var (
+	openAITransport = &retryTransport{base: http.DefaultTransport, service: "openai", retry429: true}
+	lineTransport   = &retryTransport{base: http.DefaultTransport, service: "line"}
+	// calendar reads only; writes are not idempotent and are never retried
+	schedulingTransport = &retryTransport{base: http.DefaultTransport, service: "scheduling", retry429: true,
+		attempts: func() int { return appConfig.SlotsRetryAttempts }}
+)
+
+func (t *retryTransport) retryable(status int) bool {
+	return status >= 500 || (t.retry429 && status == http.StatusTooManyRequests)
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := httpRetryAttempts()
+	if t.attempts != nil {
+		attempts = t.attempts()
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !t.retryable(resp.StatusCode) || attempt == attempts || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		wait := retryBackoff(attempt)
+		if after := retryAfter(resp.Header); after > httpRetryMaxDelay {
+			return resp, nil
+		} else if after > wait {
+			wait = after
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		log.Printf("%s %s returned %d; retrying in %s (attempt %d)", t.service, req.URL.Path, resp.StatusCode, wait.Round(time.Millisecond), attempt+1)
+		appMetrics.inc("http_retries_" + t.service)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryBackoff is the delay before retry attempt+1: a random point in the upper half of
+// base*2^attempt, capped at httpRetryMaxDelay.
+func retryBackoff(attempt int) time.Duration {
+	d := httpRetryBaseDelay << attempt
+	if d <= 0 || d > httpRetryMaxDelay {
+		d = httpRetryMaxDelay
+	}
+	return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// newRetryKey returns a random UUID for LINE's X-Line-Retry-Key, which makes a retried push
+// safe: LINE answers 409 instead of sending it twice.
+func newRetryKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
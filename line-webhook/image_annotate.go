@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"strings"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// annotationBox is a bounding box in normalized (0..1) image coordinates as returned by the model.
+type annotationBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (b annotationBox) validate() error {
+	if b.Width <= 0 || b.Height <= 0 {
+		return errors.New("box width and height must be positive")
+	}
+	if b.X < 0 || b.Y < 0 || b.X+b.Width > 1.001 || b.Y+b.Height > 1.001 {
+		return errors.New("box must be within the image (normalized 0..1)")
+	}
+	return nil
+}
+
+// customerImage is the most recent photo a user sent, kept so tools can reference it.
+type customerImage struct {
+	DataURL    string
+	Hash       string // imageHash, to find cached answers about the photo
+	ReceivedAt time.Time
+}
+
+var userLastImage = make(map[string]customerImage) // guarded by userThreadLock
+
+const customerImageTTL = 2 * time.Hour
+
+// rememberCustomerImage stores the latest image for a user and drops stale ones.
+// Caller must hold userThreadLock.
+func rememberCustomerImage(userId, dataURL string) {
+	now := time.Now()
+	for uid, img := range userLastImage {
+		if now.Sub(img.ReceivedAt) > customerImageTTL {
+			delete(userLastImage, uid)
+		}
+	}
+	userLastImage[userId] = customerImage{DataURL: dataURL, Hash: imageHash(dataURL), ReceivedAt: now}
+}
+
+// decodeDataURL returns the raw bytes of a base64 data URL.
+func decodeDataURL(dataURL string) ([]byte, error) {
+	idx := strings.Index(dataURL, ";base64,")
+	if !strings.HasPrefix(dataURL, "data:") || idx < 0 {
+		return nil, errors.New("not a base64 data URL")
+	}
+	return base64.StdEncoding.DecodeString(dataURL[idx+len(";base64,"):])
+}
+
+var (
+	annotationColor = color.RGBA{R: 0xE5, G: 0x39, B: 0x35, A: 0xFF}
+	labelTextColor  = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+)
+
+// annotateImage draws a bounding box and label onto the photo and returns it as JPEG.
+// The built-in bitmap font only covers ASCII, so labels should be in English.
+func annotateImage(data []byte, box annotationBox, label string) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	bounds := src.Bounds()
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, src, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	rect := image.Rect(
+		bounds.Min.X+int(box.X*float64(w)),
+		bounds.Min.Y+int(box.Y*float64(h)),
+		bounds.Min.X+int((box.X+box.Width)*float64(w)),
+		bounds.Min.Y+int((box.Y+box.Height)*float64(h)),
+	).Intersect(bounds)
+
+	thickness := w / 150
+	if thickness < 3 {
+		thickness = 3
+	}
+	drawRectOutline(canvas, rect, thickness, annotationColor)
+
+	if label = asciiLabel(label); label != "" {
+		drawLabel(canvas, rect, label, w)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, canvas, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("failed to encode annotated image: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func drawRectOutline(img *image.RGBA, r image.Rectangle, t int, c color.Color) {
+	fill := &image.Uniform{C: c}
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t).Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y).Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+t, r.Max.Y).Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Max.X-t, r.Min.Y, r.Max.X, r.Max.Y).Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+}
+
+// drawLabel renders the label with the 7x13 bitmap font on a small tile, scales it to suit
+// the photo resolution and places it above the box (or inside it when the box touches the top).
+func drawLabel(img *image.RGBA, box image.Rectangle, label string, imageWidth int) {
+	face := basicfont.Face7x13
+	const pad = 3
+	textW := font.MeasureString(face, label).Ceil()
+	tile := image.NewRGBA(image.Rect(0, 0, textW+pad*2, face.Height+pad*2))
+	draw.Draw(tile, tile.Bounds(), &image.Uniform{C: annotationColor}, image.Point{}, draw.Src)
+	d := &font.Drawer{
+		Dst:  tile,
+		Src:  &image.Uniform{C: labelTextColor},
+		Face: face,
+		Dot:  fixed.P(pad, pad+face.Ascent),
+	}
+	d.DrawString(label)
+
+	scale := imageWidth / 400
+	if scale < 1 {
+		scale = 1
+	}
+	size := image.Pt(tile.Bounds().Dx()*scale, tile.Bounds().Dy()*scale)
+	origin := image.Pt(box.Min.X, box.Min.Y-size.Y)
+	if origin.Y < img.Bounds().Min.Y {
+		origin.Y = box.Min.Y
+	}
+	dst := image.Rectangle{Min: origin, Max: origin.Add(size)}
+	xdraw.NearestNeighbor.Scale(img, dst, tile, tile.Bounds(), xdraw.Over, nil)
+}
+
+// asciiLabel strips characters the bitmap font cannot render.
+func asciiLabel(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 0x20 && r < 0x7f {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// makePreviewImage scales a JPEG down to LINE's recommended preview width.
+func makePreviewImage(data []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	const previewWidth = 240
+	b := src.Bounds()
+	if b.Dx() <= previewWidth {
+		return data, nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, previewWidth, b.Dy()*previewWidth/b.Dx()))
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, xdraw.Src, nil)
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// annotateCustomerImage handles the annotate_customer_image tool: it marks up the user's latest
+// photo, uploads the original and preview to the media store and queues an image message
+// to be sent together with the assistant's reply.
+func annotateCustomerImage(userId string, box annotationBox, itemLabel, serviceLabel string) (string, error) {
+	const failed = "ไม่สามารถสร้างรูปที่มีการระบุตำแหน่งได้ ให้ตอบลูกค้าด้วยข้อความตามปกติ"
+	if err := box.validate(); err != nil {
+		return "ไม่สามารถระบุตำแหน่งในรูปได้: " + err.Error(), &ToolError{Tool: "annotate_customer_image", Err: err}
+	}
+	userThreadLock.Lock()
+	img, ok := userLastImage[userId]
+	userThreadLock.Unlock()
+	if !ok {
+		return "ไม่พบรูปภาพล่าสุดของลูกค้า ไม่ต้องส่งรูปที่มีการระบุตำแหน่ง", &ToolError{Tool: "annotate_customer_image", Err: errors.New("no recent customer image")}
+	}
+	raw, err := decodeDataURL(img.DataURL)
+	if err != nil {
+		log.Printf("Failed to decode stored image for %s: %v", userId, err)
+		return failed, &ToolError{Tool: "annotate_customer_image", Err: err}
+	}
+
+	label := strings.TrimSpace(itemLabel)
+	if s := strings.TrimSpace(serviceLabel); s != "" {
+		label += " - " + s
+	}
+	annotated, err := annotateImage(raw, box, label)
+	if err != nil {
+		log.Printf("Failed to annotate image for %s: %v", userId, err)
+		return failed, &ToolError{Tool: "annotate_customer_image", Err: err}
+	}
+	preview, err := makePreviewImage(annotated)
+	if err != nil {
+		preview = annotated
+	}
+	originalURL, err := mediaStore.Put(newMediaName("annotated", "jpg"), "image/jpeg", annotated)
+	if err != nil {
+		log.Printf("Failed to store annotated image for %s: %v", userId, err)
+		return failed, &UpstreamError{Service: "media_store", Err: err}
+	}
+	previewURL, err := mediaStore.Put(newMediaName("annotated_preview", "jpg"), "image/jpeg", preview)
+	if err != nil {
+		previewURL = originalURL
+	}
+
+	queueReplyAttachment(userId, map[string]interface{}{
+		"type":               "image",
+		"originalContentUrl": originalURL,
+		"previewContentUrl":  previewURL,
+	})
+	appMetrics.inc("image_annotations")
+	return "ส่งรูปที่ระบุตำแหน่งสิ่งของและบริการที่แนะนำให้ลูกค้าพร้อมคำตอบนี้แล้ว อ้างอิงถึงรูปนี้ในคำตอบได้", nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// inflightRun is an assistant turn currently being processed for a user.
+type inflightRun struct {
+	cancel   context.CancelFunc
+	messages []string // inputs of this run, merged into the next one if it gets cancelled
+
+	// set when input was held back (the "queue" policy, or a merge that would exceed the
+	// buffer caps); flushed once this run finishes
+	queued           bool
+	queuedReplyToken string
+
+	// customer-ready tool output, sent early if the run exceeds its latency budget
+	partials     []string
+	partialReady chan struct{}
+}
+
+var userInflightRuns = make(map[string]*inflightRun) // guarded by userThreadLock
+
+// inflightPolicy decides what happens when a customer writes again while a run is in flight:
+// "cancel" (default) abandons the stale run and answers everything in one new run,
+// "queue" holds the new input until the current run has replied.
+func inflightPolicy() string {
+	return appConfig.InflightPolicy
+}
+
+// beginInflightRun registers a new run for the user. Under the queue policy, when another run
+// is active the messages are put back in the buffer, the job is held in the turn queue until
+// the buffer is flushed again, and queued=true is returned. Under the cancel policy the
+// active run is cancelled and its messages are prepended to msgs.
+func beginInflightRun(job *TurnJob, msgs []string) (ctx context.Context, run *inflightRun, merged []string, queued bool) {
+	userId, replyToken := job.UserID, job.ReplyToken
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+
+	if active, ok := userInflightRuns[userId]; ok {
+		if inflightPolicy() == "queue" || bufferAtLimit(append(append([]string{}, active.messages...), msgs...)) {
+			userMsgBuffer[userId] = append(msgs, userMsgBuffer[userId]...)
+			active.queued = true
+			active.queuedReplyToken = replyToken
+			job.held = true
+			userHeldTurnJobs[userId] = append(userHeldTurnJobs[userId], job)
+			log.Printf("Run in flight for user %s; queued %d message(s) until it completes", userId, len(msgs))
+			appMetrics.inc("inflight_runs_queued")
+			return nil, nil, nil, true
+		}
+		active.cancel()
+		msgs = append(append([]string{}, active.messages...), msgs...)
+		log.Printf("Cancelled in-flight run for user %s; merging %d message(s) into a new run", userId, len(msgs))
+		appMetrics.inc("inflight_runs_cancelled")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run = &inflightRun{cancel: cancel, messages: msgs, partialReady: make(chan struct{}, 1)}
+	userInflightRuns[userId] = run
+	return ctx, run, msgs, false
+}
+
+// finishInflightRun releases the run and, if input was queued behind it, flushes that input.
+func finishInflightRun(userId string, run *inflightRun) {
+	userThreadLock.Lock()
+	if userInflightRuns[userId] == run {
+		delete(userInflightRuns, userId)
+	}
+	queued, queuedToken := run.queued, run.queuedReplyToken
+	userThreadLock.Unlock()
+	run.cancel()
+
+	if queued {
+		go flushUserBuffer(userId, queuedToken)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// KeywordTrigger answers matching messages instantly with pre-built LINE messages
+// (brochure images, a price-list carousel) instead of an assistant turn.
+type KeywordTrigger struct {
+	ID        string                   `json:"id"`
+	Enabled   bool                     `json:"enabled"`
+	Keywords  []string                 `json:"keywords"`
+	Exact     bool                     `json:"exact,omitempty"`      // whole message must equal a keyword; default is "contains"
+	Messages  []map[string]interface{} `json:"messages,omitempty"`   // LINE message objects, e.g. image or flex
+	PriceList bool                     `json:"price_list,omitempty"` // append a Flex carousel built from the customer's prices
+}
+
+var keywordTriggersFile = "keyword_triggers.json"
+
+var (
+	keywordTriggerLock sync.RWMutex
+	keywordTriggers    []KeywordTrigger
+)
+
+func validateKeywordTriggers(list []KeywordTrigger) error {
+	seen := map[string]bool{}
+	for i, t := range list {
+		if strings.TrimSpace(t.ID) == "" {
+			return fmt.Errorf("trigger %d: id is required", i)
+		}
+		if seen[t.ID] {
+			return fmt.Errorf("duplicate trigger id '%s'", t.ID)
+		}
+		seen[t.ID] = true
+		if len(t.Keywords) == 0 {
+			return fmt.Errorf("trigger '%s': at least one keyword is required", t.ID)
+		}
+		for _, kw := range t.Keywords {
+			if strings.TrimSpace(kw) == "" {
+				return fmt.Errorf("trigger '%s': keywords must not be empty", t.ID)
+			}
+		}
+		count := len(t.Messages)
+		if t.PriceList {
+			count++
+		}
+		if count == 0 || count > 5 {
+			return fmt.Errorf("trigger '%s': must send between 1 and 5 messages", t.ID)
+		}
+		for j, m := range t.Messages {
+			if typ, _ := m["type"].(string); typ == "" {
+				return fmt.Errorf("trigger '%s': messages[%d] has no type", t.ID, j)
+			}
+		}
+	}
+	return nil
+}
+
+// matchKeywordTrigger returns the first enabled trigger whose keyword is in the message.
+func matchKeywordTrigger(normalized string) (KeywordTrigger, bool) {
+	msg := strings.ToLower(strings.TrimSpace(normalized))
+	keywordTriggerLock.RLock()
+	defer keywordTriggerLock.RUnlock()
+	for _, t := range keywordTriggers {
+		if !t.Enabled {
+			continue
+		}
+		for _, kw := range t.Keywords {
+			kw = strings.ToLower(normalizeInboundText(kw))
+			if (t.Exact && msg == kw) || (!t.Exact && strings.Contains(msg, kw)) {
+				return t, true
+			}
+		}
+	}
+	return KeywordTrigger{}, false
+}
+
+// answerKeywordTrigger replies to a matching message without an assistant turn and takes
+// the message back out of the buffer. It reports whether the message was answered.
+func answerKeywordTrigger(userId, replyToken, messageContent string) bool {
+	trigger, ok := matchKeywordTrigger(normalizeInboundText(messageContent))
+	if !ok {
+		return false
+	}
+	started := time.Now()
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	if conv != nil && conv.Takeover {
+		userThreadLock.Unlock()
+		return false // staff are handling the chat
+	}
+	userThreadLock.Unlock()
+
+	messages := append([]map[string]interface{}{}, trigger.Messages...)
+	if trigger.PriceList {
+		if flex, ok := priceListFlex(pricingEngineFor(userId).Config); ok {
+			messages = append(messages, flex)
+		}
+	}
+	if len(messages) == 0 {
+		return false
+	}
+	if replyToken != "" {
+		replyOrPush(userId, replyToken, messages)
+	} else if err := pushLineMessages(userId, messages); err != nil {
+		log.Printf("Failed to push keyword trigger %s to %s: %v", trigger.ID, userId, err)
+		return false
+	}
+
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+
+	userThreadLock.Lock()
+	buf := userMsgBuffer[userId]
+	if n := len(buf); n > 0 && buf[n-1] == messageContent {
+		userMsgBuffer[userId] = buf[:n-1]
+	}
+	if conv, ok := userConversations[userId]; ok {
+		conv.appendTurn(fmt.Sprintf("[ส่งข้อมูลอัตโนมัติ: %s]", trigger.ID), stats)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	log.Printf("Keyword trigger %s answered user %s", trigger.ID, userId)
+	appMetrics.inc("keyword_trigger_" + trigger.ID)
+	return true
+}
+
+// priceListFlex builds a carousel with one bubble per item listing regular new-customer prices.
+func priceListFlex(cfg *pricing.Config) (map[string]interface{}, bool) {
+	if cfg == nil {
+		return nil, false
+	}
+	sortedKeys := func(m map[string]bool) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	itemKeys, serviceKeys := map[string]bool{}, map[string]bool{}
+	for k := range cfg.Items {
+		itemKeys[k] = true
+	}
+	for k := range cfg.Services {
+		serviceKeys[k] = true
+	}
+
+	var bubbles []interface{}
+	for _, itemKey := range sortedKeys(itemKeys) {
+		item := cfg.Items[itemKey]
+		sizeKeys := map[string]bool{}
+		for k := range item.Sizes {
+			sizeKeys[k] = true
+		}
+		contents := []interface{}{
+			map[string]interface{}{"type": "text", "text": item.Name, "weight": "bold", "size": "lg"},
+		}
+		for _, serviceKey := range sortedKeys(serviceKeys) {
+			var rows []interface{}
+			for _, sizeKey := range sortedKeys(sizeKeys) {
+				price, ok := cfg.ItemPrice(serviceKey, itemKey, sizeKey, "new", "regular")
+				if !ok || price.FullPrice == 0 {
+					continue
+				}
+				text := pricing.FormatNumber(price.FullPrice) + " บาท"
+				best := price.Discount50
+				if best == 0 {
+					best = price.Discount35
+				}
+				if best > 0 {
+					text = fmt.Sprintf("%s → %s บาท", pricing.FormatNumber(price.FullPrice), pricing.FormatNumber(best))
+				}
+				rows = append(rows, map[string]interface{}{
+					"type": "box", "layout": "horizontal",
+					"contents": []interface{}{
+						map[string]interface{}{"type": "text", "text": item.Sizes[sizeKey].Name, "size": "sm", "flex": 2, "wrap": true},
+						map[string]interface{}{"type": "text", "text": text, "size": "sm", "flex": 3, "align": "end"},
+					},
+				})
+			}
+			if len(rows) == 0 {
+				continue
+			}
+			contents = append(contents,
+				map[string]interface{}{"type": "separator", "margin": "md"},
+				map[string]interface{}{"type": "text", "text": cfg.Services[serviceKey].Name, "weight": "bold", "size": "sm", "margin": "md", "color": "#1DB446"},
+			)
+			contents = append(contents, rows...)
+		}
+		if len(contents) == 1 {
+			continue
+		}
+		bubbles = append(bubbles, map[string]interface{}{
+			"type": "bubble",
+			"body": map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "contents": contents},
+		})
+		if len(bubbles) == 12 {
+			break // LINE carousel limit
+		}
+	}
+	if len(bubbles) == 0 {
+		return nil, false
+	}
+	return flexMessage("ราคาบริการ NCS", map[string]interface{}{"type": "carousel", "contents": bubbles}), true
+}
+
+func loadKeywordTriggers() {
+	data, err := os.ReadFile(keywordTriggersFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read keyword triggers file: %v", err)
+		}
+		return
+	}
+	var list []KeywordTrigger
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Failed to parse keyword triggers file: %v", err)
+		return
+	}
+	if err := validateKeywordTriggers(list); err != nil {
+		log.Printf("Invalid keyword triggers file: %v", err)
+		return
+	}
+	keywordTriggerLock.Lock()
+	keywordTriggers = list
+	keywordTriggerLock.Unlock()
+}
+
+func handleGetKeywordTriggers(c *fiber.Ctx) error {
+	keywordTriggerLock.RLock()
+	defer keywordTriggerLock.RUnlock()
+	if keywordTriggers == nil {
+		return c.JSON([]KeywordTrigger{})
+	}
+	return c.JSON(keywordTriggers)
+}
+
+func handleReplaceKeywordTriggers(c *fiber.Ctx) error {
+	var incoming []KeywordTrigger
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := validateKeywordTriggers(incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save keyword triggers")
+	}
+	if err := os.WriteFile(keywordTriggersFile, data, 0644); err != nil {
+		log.Printf("Failed to save keyword triggers: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save keyword triggers")
+	}
+	keywordTriggerLock.Lock()
+	keywordTriggers = incoming
+	keywordTriggerLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "triggers": incoming})
+}
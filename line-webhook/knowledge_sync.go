@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Company documents (service descriptions, chemical safety sheets, warranty terms) live in
+// KNOWLEDGE_DIR and are uploaded to an OpenAI vector store by `go run . sync-knowledge`.
+// With OPENAI_VECTOR_STORE_ID set, every assistant turn gets the file_search tool over that
+// store, so policy questions are answered from the documents instead of the model's memory.
+
+var knowledgeManifestFile = "knowledge_manifest.json"
+
+// knowledgeExtensions are the document types file search can index.
+var knowledgeExtensions = map[string]bool{
+	".pdf": true, ".md": true, ".txt": true, ".docx": true, ".pptx": true, ".html": true, ".json": true,
+}
+
+// knowledgeFile is one synced document: its content hash and the OpenAI file holding it.
+type knowledgeFile struct {
+	SHA256   string    `json:"sha256"`
+	FileID   string    `json:"file_id"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+type knowledgeManifest struct {
+	VectorStoreID string                   `json:"vector_store_id"`
+	Files         map[string]knowledgeFile `json:"files"` // by path relative to KNOWLEDGE_DIR
+}
+
+// KnowledgeSyncReport lists what a sync did, or would do with dry_run.
+type KnowledgeSyncReport struct {
+	VectorStoreID string   `json:"vector_store_id"`
+	DryRun        bool     `json:"dry_run"`
+	Uploaded      []string `json:"uploaded"`
+	Updated       []string `json:"updated"`
+	Removed       []string `json:"removed"`
+	Unchanged     int      `json:"unchanged"`
+	Skipped       []string `json:"skipped,omitempty"` // not a type file search can index
+}
+
+func knowledgeDir() string {
+	return appConfig.KnowledgeDir
+}
+
+func knowledgeVectorStoreID() string {
+	return appConfig.VectorStoreID
+}
+
+// assistantTools returns the tools offered to the model: the function tools from
+// gpt_functions.json named in allowed (all of them when allowed is empty), plus file search
+// over the knowledge store when one is configured.
+func assistantTools(allowed []string) []interface{} {
+	tools := make([]interface{}, 0, len(toolDefinitions)+1)
+	for _, t := range toolDefinitions {
+		if len(allowed) == 0 || slices.Contains(allowed, t.Name) {
+			tools = append(tools, t)
+		}
+	}
+	if id := knowledgeVectorStoreID(); id != "" {
+		tools = append(tools, map[string]interface{}{
+			"type":             "file_search",
+			"vector_store_ids": []string{id},
+		})
+	}
+	return tools
+}
+
+func loadKnowledgeManifest() knowledgeManifest {
+	m := knowledgeManifest{Files: map[string]knowledgeFile{}}
+	data, err := os.ReadFile(knowledgeManifestFile)
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Printf("Warning: could not parse %s: %v", knowledgeManifestFile, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]knowledgeFile{}
+	}
+	return m
+}
+
+func saveKnowledgeManifest(m knowledgeManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(knowledgeManifestFile, data, 0644)
+}
+
+// scanKnowledgeDir hashes every indexable document under dir, by slash-separated relative path.
+func scanKnowledgeDir(dir string) (hashes map[string]string, skipped []string, err error) {
+	hashes = map[string]string{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if !knowledgeExtensions[strings.ToLower(filepath.Ext(path))] {
+			skipped = append(skipped, rel)
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hashes[rel] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	return hashes, skipped, err
+}
+
+// syncKnowledge uploads new and changed documents to the vector store and removes documents
+// that were deleted or replaced. The store is created when none is configured yet.
+func syncKnowledge(dir, vectorStoreID string, dryRun bool) (*KnowledgeSyncReport, error) {
+	hashes, skipped, err := scanKnowledgeDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	manifest := loadKnowledgeManifest()
+	if vectorStoreID == "" {
+		vectorStoreID = manifest.VectorStoreID
+	}
+	if vectorStoreID != manifest.VectorStoreID {
+		// a different store holds none of the files recorded for the old one
+		manifest.Files = map[string]knowledgeFile{}
+	}
+	report := &KnowledgeSyncReport{VectorStoreID: vectorStoreID, DryRun: dryRun, Skipped: skipped}
+
+	paths := make([]string, 0, len(hashes))
+	for p := range hashes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var upload []string
+	for _, p := range paths {
+		switch f, ok := manifest.Files[p]; {
+		case !ok:
+			report.Uploaded = append(report.Uploaded, p)
+			upload = append(upload, p)
+		case f.SHA256 != hashes[p]:
+			report.Updated = append(report.Updated, p)
+			upload = append(upload, p)
+		default:
+			report.Unchanged++
+		}
+	}
+	var removed []string
+	for p := range manifest.Files {
+		if _, ok := hashes[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(removed)
+	report.Removed = removed
+	if dryRun {
+		return report, nil
+	}
+
+	client, err := newOpenAIClient(120 * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if vectorStoreID == "" {
+		id, err := client.CreateVectorStore(ctx, "NCS knowledge")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vector store: %w", openAIError(err))
+		}
+		vectorStoreID = id
+		report.VectorStoreID = id
+		log.Printf("Created vector store %s; set OPENAI_VECTOR_STORE_ID to use it", id)
+	}
+	manifest.VectorStoreID = vectorStoreID
+
+	// each step is saved as it succeeds, so a failed sync can simply be run again
+	for _, p := range upload {
+		fileID, err := uploadKnowledgeFile(ctx, client, vectorStoreID, filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			saveKnowledgeManifest(manifest)
+			return report, fmt.Errorf("failed to upload %s: %w", p, err)
+		}
+		if old, ok := manifest.Files[p]; ok {
+			deleteKnowledgeFile(ctx, client, vectorStoreID, old.FileID)
+		}
+		manifest.Files[p] = knowledgeFile{SHA256: hashes[p], FileID: fileID, SyncedAt: time.Now()}
+	}
+	for _, p := range removed {
+		deleteKnowledgeFile(ctx, client, vectorStoreID, manifest.Files[p].FileID)
+		delete(manifest.Files, p)
+	}
+	if err := saveKnowledgeManifest(manifest); err != nil {
+		return report, fmt.Errorf("failed to save %s: %w", knowledgeManifestFile, err)
+	}
+	return report, nil
+}
+
+// uploadKnowledgeFile uploads a document and adds it to the vector store, returning its file ID.
+func uploadKnowledgeFile(ctx context.Context, client *openai.Client, vectorStoreID, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fileID, err := client.UploadFile(ctx, filepath.Base(path), data, "assistants")
+	if err != nil {
+		return "", openAIError(err)
+	}
+	if err := client.AddVectorStoreFile(ctx, vectorStoreID, fileID); err != nil {
+		client.DeleteFile(ctx, fileID)
+		return "", openAIError(err)
+	}
+	return fileID, nil
+}
+
+// deleteKnowledgeFile detaches a document from the store and deletes the file. Failures are
+// only logged: a leftover file costs storage but does no harm.
+func deleteKnowledgeFile(ctx context.Context, client *openai.Client, vectorStoreID, fileID string) {
+	if err := client.RemoveVectorStoreFile(ctx, vectorStoreID, fileID); err != nil {
+		log.Printf("Failed to remove file %s from vector store: %v", fileID, err)
+	}
+	if err := client.DeleteFile(ctx, fileID); err != nil {
+		log.Printf("Failed to delete file %s: %v", fileID, err)
+	}
+}
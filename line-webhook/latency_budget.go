@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// latencyBudget is how long a turn may take before deterministic tool output is sent ahead
+// of the model's reply (ASSISTANT_LATENCY_BUDGET_SECONDS, default 15; 0 disables).
+func latencyBudget() time.Duration {
+	return appConfig.LatencyBudget
+}
+
+// assistantTimeout bounds a whole assistant turn, tool calls included
+// (ASSISTANT_TIMEOUT_SECONDS, default 300).
+func assistantTimeout() time.Duration {
+	return appConfig.AssistantTimeout
+}
+
+// stillWorkingAfter is how long a turn may run before the customer is told the answer is on
+// its way (ASSISTANT_NOTICE_SECONDS, default 30; 0 disables).
+func stillWorkingAfter() time.Duration {
+	return appConfig.AssistantNotice
+}
+
+const stillWorkingNotice = "กำลังตรวจสอบข้อมูลให้นะคะ รอสักครู่ค่ะ 🙏"
+
+// partialAnswerFormatters turn a tool result into a customer-ready message that can be sent
+// while the model is still composing. Tools not listed here never produce partial answers
+// from their result; get_ncs_pricing adds its own from the structured quote (quotePartial),
+// since its result carries instructions for the model.
+var partialAnswerFormatters = map[string]func(result string) (string, bool){
+	"get_available_slots_with_months": slotSchedulePartial,
+}
+
+// recordPartialAnswer keeps the formatted output of a successful tool call on the user's
+// in-flight run, for sending if the turn goes over its latency budget.
+func recordPartialAnswer(userId, tool, result string) {
+	format, ok := partialAnswerFormatters[tool]
+	if !ok {
+		return
+	}
+	if text, ok := format(result); ok {
+		addPartialAnswer(userId, text)
+	}
+}
+
+// addPartialAnswer keeps a customer-ready message on the user's in-flight run.
+func addPartialAnswer(userId, text string) {
+	if text == "" {
+		return
+	}
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	run, ok := userInflightRuns[userId]
+	if !ok {
+		return
+	}
+	run.partials = append(run.partials, text)
+	select {
+	case run.partialReady <- struct{}{}:
+	default:
+	}
+}
+
+func takePartialAnswers(run *inflightRun) string {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	text := strings.Join(run.partials, "\n\n")
+	run.partials = nil
+	return text
+}
+
+// getAssistantResponseWithinBudget runs the assistant turn within assistantTimeout. Once the
+// latency budget has passed, any tool output ready to show is sent straight away and the
+// model's reply follows as a second message. When nothing has been sent by stillWorkingAfter,
+// a short notice is. Either way the returned reply token is then empty, so the reply is pushed.
+func getAssistantResponseWithinBudget(ctx context.Context, run *inflightRun, userId, replyToken, message string) (string, string, error) {
+	timeout := assistantTimeout()
+	turnCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	respond := func() (string, error) {
+		text, err := getAssistantResponse(turnCtx, userId, message)
+		if err != nil && ctx.Err() == nil && errors.Is(turnCtx.Err(), context.DeadlineExceeded) {
+			err = &TimeoutError{Op: "assistant", Err: fmt.Errorf("no reply within %s: %w", timeout, err)}
+		}
+		return text, err
+	}
+
+	budget, notice := latencyBudget(), stillWorkingAfter()
+	if run == nil || (budget <= 0 && notice <= 0) {
+		text, err := respond()
+		return text, replyToken, err
+	}
+
+	type outcome struct {
+		text string
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		text, err := respond()
+		done <- outcome{text, err}
+	}()
+
+	var budgetC, noticeC <-chan time.Time
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		budgetC = timer.C
+	}
+	if notice > 0 {
+		timer := time.NewTimer(notice)
+		defer timer.Stop()
+		noticeC = timer.C
+	}
+	overBudget := false
+	sentAhead := false // a partial answer or the notice went out before the reply
+	for {
+		select {
+		case o := <-done:
+			return o.text, replyToken, o.err
+		case <-budgetC:
+			overBudget = true
+		case <-run.partialReady:
+		case <-noticeC:
+			if !sentAhead && ctx.Err() == nil {
+				log.Printf("Turn for user %s is still running after %s; sending a notice", userId, notice)
+				appMetrics.inc("still_working_notices")
+				deliverReply(userId, replyToken, stillWorkingNotice, nil)
+				replyToken = ""
+				sentAhead = true
+			}
+			continue
+		}
+		if !overBudget || ctx.Err() != nil {
+			continue
+		}
+		partial := takePartialAnswers(run)
+		if partial == "" {
+			continue
+		}
+		log.Printf("Turn for user %s is over its %s budget; sending tool output ahead of the reply", userId, budget)
+		appMetrics.inc("partial_answers_sent")
+		deliverReply(userId, replyToken, partial, nil)
+		replyToken = ""
+		sentAhead = true
+		userThreadLock.Lock()
+		if conv, ok := userConversations[userId]; ok {
+			conv.appendMessage("ai", partial)
+		}
+		userThreadLock.Unlock()
+	}
+}
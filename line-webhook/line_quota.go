@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// pushPriority decides whether a push may be deferred when the monthly quota runs low.
+// Reply API messages are free and never counted; only push messages consume quota.
+type pushPriority int
+
+const (
+	pushTransactional pushPriority = iota // admin replies, booking confirmations - always sent
+	pushNonEssential                      // campaigns, nudges, reminders - deferred near the limit
+)
+
+// deferredPush is a non-essential push held back until the quota resets.
+type deferredPush struct {
+	UserID   string    `json:"user_id"`
+	Message  string    `json:"message"`
+	Reason   string    `json:"reason"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// LineQuotaState tracks push consumption of one LINE channel for the current billing month
+// (Bangkok time). Each Official Account has its own quota; channels without an access token
+// of their own share the default channel's.
+type LineQuotaState struct {
+	Month        string         `json:"month"`       // YYYY-MM
+	LocalUsage   int            `json:"local_usage"` // messages counted by this service
+	LineUsage    int            `json:"line_usage"`  // totalUsage reported by LINE
+	LineLimit    int            `json:"line_limit"`  // quota reported by LINE (0 = unlimited/unknown)
+	LastSyncedAt time.Time      `json:"last_synced_at"`
+	Deferred     []deferredPush `json:"deferred"`
+}
+
+var lineQuotaFile = "line_quota.json"
+
+var (
+	lineQuotaLock sync.Mutex
+	lineQuotas    = map[string]*LineQuotaState{} // by quotaChannelID
+)
+
+func currentQuotaMonth() string {
+	return bangkokNow().Format("2006-01")
+}
+
+// quotaChannelID returns the channel whose quota a push to the user counts against: the
+// user's channel when it has its own access token, else "" for the default channel.
+func quotaChannelID(userId string) string {
+	if ch, ok := channelFor(userId); ok && ch.accessToken() != "" {
+		return ch.ID
+	}
+	return ""
+}
+
+// lineQuotaForLocked returns the channel's state, rolled over to the current month.
+// Caller must hold lineQuotaLock.
+func lineQuotaForLocked(channelID string) *LineQuotaState {
+	q := lineQuotas[channelID]
+	if q == nil {
+		q = &LineQuotaState{}
+		lineQuotas[channelID] = q
+	}
+	q.rollover(channelID)
+	return q
+}
+
+// quotaMetric names a per-channel metric; the default channel keeps the plain name.
+func quotaMetric(name, channelID string) string {
+	if channelID == "" {
+		return name
+	}
+	return name + "_" + channelID
+}
+
+// limit returns the effective monthly limit. LINE_MONTHLY_PUSH_QUOTA overrides the value
+// reported by the LINE quota API. Caller must hold lineQuotaLock.
+func (q *LineQuotaState) limit() int {
+	if appConfig.LinePushQuota >= 0 {
+		return appConfig.LinePushQuota
+	}
+	return q.LineLimit
+}
+
+// lineQuotaReservePercent is the share of the quota kept for transactional pushes.
+func lineQuotaReservePercent() int {
+	return appConfig.LineQuotaReservePercent
+}
+
+// used returns the higher of the local and LINE-reported counts so pushes sent from the
+// LINE console are accounted for too. Caller must hold lineQuotaLock.
+func (q *LineQuotaState) used() int {
+	if q.LineUsage > q.LocalUsage {
+		return q.LineUsage
+	}
+	return q.LocalUsage
+}
+
+// rollover resets the counters when a new month starts. Caller must hold lineQuotaLock.
+func (q *LineQuotaState) rollover(channelID string) {
+	month := currentQuotaMonth()
+	if q.Month == month {
+		return
+	}
+	if q.Month != "" {
+		log.Printf("LINE quota of channel %q rolled over %s -> %s (used %d)", channelID, q.Month, month, q.used())
+	}
+	q.Month = month
+	q.LocalUsage = 0
+	q.LineUsage = 0
+}
+
+// nonEssentialAllowed reports whether count more messages fit below the reserve.
+// Caller must hold lineQuotaLock.
+func (q *LineQuotaState) nonEssentialAllowed(count int) bool {
+	limit := q.limit()
+	if limit <= 0 {
+		return true
+	}
+	threshold := limit * (100 - lineQuotaReservePercent()) / 100
+	return q.used()+count <= threshold
+}
+
+// nonEssentialPushAllowed reports whether a non-essential push to the user fits the quota
+// of their channel.
+func nonEssentialPushAllowed(userId string) bool {
+	channelID := quotaChannelID(userId)
+	lineQuotaLock.Lock()
+	defer lineQuotaLock.Unlock()
+	return lineQuotaForLocked(channelID).nonEssentialAllowed(1)
+}
+
+// recordLinePush counts a delivered push request against the quota of the user's channel.
+// LINE bills a message per recipient, however many message objects the request carries.
+func recordLinePush(userId string) {
+	channelID := quotaChannelID(userId)
+	lineQuotaLock.Lock()
+	q := lineQuotaForLocked(channelID)
+	q.LocalUsage++
+	used := q.used()
+	lineQuotaLock.Unlock()
+	appMetrics.inc("line_push_sent")
+	appMetrics.setGauge(quotaMetric("line_quota_used", channelID), float64(used))
+	go saveLineQuotaState()
+}
+
+// pushLineMessageWithPriority sends a push, deferring non-essential messages when the
+// monthly quota is close to its limit. Transactional messages are always sent.
+func pushLineMessageWithPriority(userId, message string, priority pushPriority, reason string) error {
+	if priority == pushNonEssential && !isSimulatedUser(userId) {
+		channelID := quotaChannelID(userId)
+		lineQuotaLock.Lock()
+		q := lineQuotaForLocked(channelID)
+		allowed := q.nonEssentialAllowed(1)
+		if !allowed {
+			q.Deferred = append(q.Deferred, deferredPush{
+				UserID:   userId,
+				Message:  message,
+				Reason:   reason,
+				QueuedAt: time.Now(),
+			})
+		}
+		deferredCount := len(q.Deferred)
+		lineQuotaLock.Unlock()
+		if !allowed {
+			log.Printf("LINE quota near limit; deferred %s push to %s (%d deferred)", reason, userId, deferredCount)
+			appMetrics.inc("line_push_deferred")
+			appMetrics.setGauge(quotaMetric("line_push_deferred_pending", channelID), float64(deferredCount))
+			go saveLineQuotaState()
+			return nil
+		}
+	}
+	return pushLineMessagesAs(userId, []map[string]interface{}{{"type": "text", "text": message}}, priority)
+}
+
+// flushDeferredPushes sends each channel's queued non-essential pushes while its quota allows.
+func flushDeferredPushes() {
+	lineQuotaLock.Lock()
+	channelIDs := make([]string, 0, len(lineQuotas))
+	for id := range lineQuotas {
+		channelIDs = append(channelIDs, id)
+	}
+	lineQuotaLock.Unlock()
+
+	for _, channelID := range channelIDs {
+		for {
+			lineQuotaLock.Lock()
+			q := lineQuotaForLocked(channelID)
+			if len(q.Deferred) == 0 || !q.nonEssentialAllowed(1) {
+				remaining := len(q.Deferred)
+				lineQuotaLock.Unlock()
+				appMetrics.setGauge(quotaMetric("line_push_deferred_pending", channelID), float64(remaining))
+				break
+			}
+			next := q.Deferred[0]
+			q.Deferred = q.Deferred[1:]
+			lineQuotaLock.Unlock()
+
+			if err := pushLineMessagesAs(next.UserID, []map[string]interface{}{{"type": "text", "text": next.Message}}, pushNonEssential); err != nil {
+				log.Printf("Failed to send deferred %s push to %s: %v", next.Reason, next.UserID, err)
+			}
+			go saveLineQuotaState()
+		}
+	}
+}
+
+// syncLineQuotas refreshes the quota of the default channel and of every channel with its
+// own access token.
+func syncLineQuotas() {
+	tokens := map[string]string{"": appConfig.LineAccessToken}
+	channelLock.RLock()
+	for _, ch := range lineChannels {
+		if token := ch.accessToken(); token != "" {
+			tokens[ch.ID] = token
+		}
+	}
+	channelLock.RUnlock()
+	for channelID, token := range tokens {
+		if err := syncLineQuotaFromAPI(channelID, token); err != nil {
+			log.Printf("LINE quota sync of channel %q failed: %v", channelID, err)
+		}
+	}
+}
+
+// syncLineQuotaFromAPI refreshes one channel's limit and consumption from the LINE quota
+// endpoints.
+func syncLineQuotaFromAPI(channelID, channelToken string) error {
+	if channelToken == "" {
+		return nil
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	get := func(url string, dest interface{}) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+channelToken)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("LINE quota API %s returned status %d", url, resp.StatusCode)
+		}
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+
+	var quota struct {
+		Type  string `json:"type"` // "none" or "limited"
+		Value int    `json:"value"`
+	}
+	if err := get("https://api.line.me/v2/bot/message/quota", &quota); err != nil {
+		return err
+	}
+	var consumption struct {
+		TotalUsage int `json:"totalUsage"`
+	}
+	if err := get("https://api.line.me/v2/bot/message/quota/consumption", &consumption); err != nil {
+		return err
+	}
+
+	lineQuotaLock.Lock()
+	q := lineQuotaForLocked(channelID)
+	if quota.Type == "limited" {
+		q.LineLimit = quota.Value
+	} else {
+		q.LineLimit = 0
+	}
+	q.LineUsage = consumption.TotalUsage
+	q.LastSyncedAt = time.Now()
+	limit := q.limit()
+	used := q.used()
+	lineQuotaLock.Unlock()
+
+	appMetrics.setGauge(quotaMetric("line_quota_limit", channelID), float64(limit))
+	appMetrics.setGauge(quotaMetric("line_quota_used", channelID), float64(used))
+	go saveLineQuotaState()
+	return nil
+}
+
+// startLineQuotaMonitor periodically syncs usage with LINE and releases deferred pushes
+// once the quota frees up (typically at the start of a new month).
+func startLineQuotaMonitor() {
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for {
+			syncLineQuotas()
+			flushDeferredPushes()
+			<-ticker.C
+		}
+	}()
+}
+
+// lineQuotaFileData is the saved state. Files written before quotas were kept per channel
+// hold a single LineQuotaState, which is read as the default channel's.
+type lineQuotaFileData struct {
+	Channels map[string]*LineQuotaState `json:"channels"`
+}
+
+func saveLineQuotaState() {
+	// held through the write so concurrent saves don't share the temp file
+	lineQuotaLock.Lock()
+	defer lineQuotaLock.Unlock()
+	data, err := json.Marshal(lineQuotaFileData{Channels: lineQuotas})
+	if err != nil {
+		log.Printf("Failed to marshal LINE quota state: %v", err)
+		return
+	}
+	tmpPath := lineQuotaFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("Failed to save LINE quota state: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, lineQuotaFile); err != nil {
+		log.Printf("Failed to replace LINE quota file: %v", err)
+	}
+}
+
+func loadLineQuotaState() {
+	data, err := os.ReadFile(lineQuotaFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read LINE quota file: %v", err)
+		}
+		return
+	}
+	var saved lineQuotaFileData
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Failed to parse LINE quota file: %v", err)
+		return
+	}
+	if saved.Channels == nil {
+		var legacy LineQuotaState
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			log.Printf("Failed to parse LINE quota file: %v", err)
+			return
+		}
+		saved.Channels = map[string]*LineQuotaState{"": &legacy}
+	}
+	lineQuotaLock.Lock()
+	defer lineQuotaLock.Unlock()
+	for channelID, q := range saved.Channels {
+		if q != nil {
+			lineQuotas[channelID] = q
+			q.rollover(channelID)
+		}
+	}
+}
+
+// lineQuotaView is one channel's quota as shown by GET /admin/line-quota. Caller must hold
+// lineQuotaLock.
+func lineQuotaView(q *LineQuotaState) fiber.Map {
+	limit := q.limit()
+	used := q.used()
+	remaining := -1
+	if limit > 0 {
+		remaining = limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	return fiber.Map{
+		"month":                 q.Month,
+		"limit":                 limit,
+		"used":                  used,
+		"local_usage":           q.LocalUsage,
+		"line_usage":            q.LineUsage,
+		"remaining":             remaining,
+		"reserve_percent":       lineQuotaReservePercent(),
+		"non_essential_allowed": q.nonEssentialAllowed(1),
+		"deferred":              len(q.Deferred),
+		"last_synced_at":        q.LastSyncedAt,
+	}
+}
+
+// handleGetLineQuota shows the default channel's quota, with the channels that have their
+// own access token under "channels".
+func handleGetLineQuota(c *fiber.Ctx) error {
+	lineQuotaLock.Lock()
+	defer lineQuotaLock.Unlock()
+	view := lineQuotaView(lineQuotaForLocked(""))
+	channels := fiber.Map{}
+	for channelID := range lineQuotas {
+		if channelID != "" {
+			channels[channelID] = lineQuotaView(lineQuotaForLocked(channelID))
+		}
+	}
+	if len(channels) > 0 {
+		view["channels"] = channels
+	}
+	return c.JSON(view)
+}
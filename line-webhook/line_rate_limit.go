@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LINE answers 429 when the channel sends faster than its rate limit. Such sends are retried
+// with backoff. While the channel is throttled every send waits for the throttle to pass,
+// and non-essential pushes also wait until no transactional message is waiting, so replies
+// and confirmations go out before campaign messages.
+
+// lineRateLimitError is a 429 from the LINE messaging API.
+type lineRateLimitError struct {
+	RetryAfter time.Duration // from the Retry-After header; 0 when LINE didn't send one
+}
+
+func (e *lineRateLimitError) Error() string {
+	return "LINE rate limit exceeded"
+}
+
+// lineRateLimitFromResponse returns a *lineRateLimitError for a 429 response, nil otherwise.
+func lineRateLimitFromResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	err := &lineRateLimitError{}
+	if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+		err.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return err
+}
+
+const (
+	lineRateLimitRetries  = 5
+	lineRateLimitBackoff  = time.Second // doubled on every retry
+	lineRateLimitMaxDelay = time.Minute
+)
+
+var (
+	lineThrottleLock    sync.Mutex
+	lineThrottleCond    = sync.NewCond(&lineThrottleLock)
+	lineThrottledUntil  time.Time
+	lineUrgentWaiting   int // transactional sends waiting for the throttle to pass
+	lineThrottleWakeSet bool
+)
+
+// throttleLine records that LINE asked us to slow down for at least d.
+func throttleLine(d time.Duration) {
+	lineThrottleLock.Lock()
+	defer lineThrottleLock.Unlock()
+	if until := time.Now().Add(d); until.After(lineThrottledUntil) {
+		lineThrottledUntil = until
+	}
+	appMetrics.setGauge("line_throttled", 1)
+	if !lineThrottleWakeSet {
+		lineThrottleWakeSet = true
+		go wakeLineSendersAfterThrottle()
+	}
+}
+
+// wakeLineSendersAfterThrottle releases waiting senders once the throttle has passed.
+func wakeLineSendersAfterThrottle() {
+	for {
+		lineThrottleLock.Lock()
+		wait := time.Until(lineThrottledUntil)
+		if wait <= 0 {
+			lineThrottleWakeSet = false
+			appMetrics.setGauge("line_throttled", 0)
+			lineThrottleCond.Broadcast()
+			lineThrottleLock.Unlock()
+			return
+		}
+		lineThrottleLock.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// acquireLineCapacity blocks while the channel is throttled. Non-essential sends also wait
+// while a transactional send held back by the throttle has not gone out yet. release must be
+// called once the send is done.
+func acquireLineCapacity(priority pushPriority) (release func()) {
+	lineThrottleLock.Lock()
+	defer lineThrottleLock.Unlock()
+	blocked := func() bool {
+		return time.Now().Before(lineThrottledUntil) || (priority == pushNonEssential && lineUrgentWaiting > 0)
+	}
+	if !blocked() {
+		return func() {}
+	}
+	started := time.Now()
+	if priority == pushTransactional {
+		lineUrgentWaiting++
+	}
+	for blocked() {
+		lineThrottleCond.Wait()
+	}
+	appMetrics.observe("line_rate_limit_wait", time.Since(started))
+	if priority == pushNonEssential {
+		return func() {}
+	}
+	return func() {
+		lineThrottleLock.Lock()
+		lineUrgentWaiting--
+		lineThrottleCond.Broadcast()
+		lineThrottleLock.Unlock()
+	}
+}
+
+// sendWithLineRateLimit runs a LINE API call, retrying it with backoff while LINE answers 429.
+func sendWithLineRateLimit(kind string, priority pushPriority, send func() error) error {
+	delay := lineRateLimitBackoff
+	for attempt := 0; ; attempt++ {
+		release := acquireLineCapacity(priority)
+		err := send()
+		var rl *lineRateLimitError
+		if !errors.As(err, &rl) {
+			release()
+			return err
+		}
+		appMetrics.inc("line_rate_limited_" + kind)
+		if attempt == lineRateLimitRetries {
+			release()
+			log.Printf("LINE %s still rate limited after %d retries; giving up", kind, attempt)
+			appMetrics.inc("line_rate_limit_failures")
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+		wait := delay
+		if rl.RetryAfter > wait {
+			wait = rl.RetryAfter
+		}
+		if wait > lineRateLimitMaxDelay {
+			wait = lineRateLimitMaxDelay
+		}
+		log.Printf("LINE %s rate limited; retrying in %s (attempt %d)", kind, wait, attempt+1)
+		appMetrics.inc("line_rate_limit_retries")
+		throttleLine(wait) // before release, so waiting campaign pushes don't slip in
+		release()
+		delay *= 2
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookSignatureOK(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	cfg := *appConfig
+	appConfig = &cfg
+
+	body := []byte(`{"destination":"Ubot","events":[]}`)
+	tests := []struct {
+		name, secret, signature string
+		allowUnsigned           bool
+		want                    bool
+	}{
+		{"valid", "s3cret", sign("s3cret", body), false, true},
+		{"tampered", "s3cret", sign("s3cret", []byte(`{}`)), false, false},
+		{"missing", "s3cret", "", false, false},
+		{"no secret", "", sign("", body), false, false},
+		{"no secret, unsigned allowed", "", "", true, true},
+		{"unsigned allowed but secret set", "s3cret", "", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig.AllowUnsignedWebhooks = tt.allowUnsigned
+			if got := webhookSignatureOK(tt.secret, tt.signature, body); got != tt.want {
+				t.Errorf("webhookSignatureOK() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineChannelSecretUnknownDestination(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	cfg := *appConfig
+	appConfig = &cfg
+	appConfig.LineChannelSecret = "main-secret"
+
+	channelLock.Lock()
+	savedChannels := lineChannels
+	lineChannels = nil
+	channelLock.Unlock()
+	defer func() {
+		channelLock.Lock()
+		lineChannels = savedChannels
+		channelLock.Unlock()
+	}()
+
+	if secret, known := lineChannelSecret("Uany"); !known || secret != "main-secret" {
+		t.Errorf("without channels.json: lineChannelSecret() = %q, %v, want the main secret", secret, known)
+	}
+	channelLock.Lock()
+	lineChannels = []LineChannel{{ID: "main", Destination: "Umain"}}
+	channelLock.Unlock()
+	if secret, known := lineChannelSecret("Umain"); !known || secret != "main-secret" {
+		t.Errorf("listed channel without its own secret: lineChannelSecret() = %q, %v, want the main secret", secret, known)
+	}
+	if secret, known := lineChannelSecret("Uother"); known {
+		t.Errorf("unlisted destination: lineChannelSecret() = %q, %v, want unknown", secret, known)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The live view answers "who is waiting on us right now" for the admin dashboard: customers
+// whose messages are still in the buffer, whose turn is queued or running, who are waiting for
+// staff, or whose latest messages have no reply yet.
+
+// LiveConversation is the current state of one customer's conversation.
+type LiveConversation struct {
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+	LastSeen    string `json:"last_seen"`
+	// waiting_staff, queued, running, buffering, unanswered or idle
+	Status     string `json:"status"`
+	Takeover   bool   `json:"takeover"`
+	WantsHuman bool   `json:"wants_human"`
+	Urgent     bool   `json:"urgent"`
+
+	Buffered      []string              `json:"buffered"`                 // not yet handed to the assistant
+	Unanswered    []ConversationMessage `json:"unanswered"`               // customer messages after the last bot or staff reply
+	QueuePosition int                   `json:"queue_position,omitempty"` // place in the run queue (MAX_CONCURRENT_RUNS)
+	TurnJobs      int                   `json:"turn_jobs,omitempty"`      // turn jobs not finished yet
+}
+
+// liveQueueState snapshots the run queue positions and unfinished turn jobs per user. It takes
+// the queue locks on their own so they are never held together with userThreadLock.
+func liveQueueState() (positions map[string]int, jobs map[string]int) {
+	positions = make(map[string]int)
+	runQueueLock.Lock()
+	for i, w := range runWaiters {
+		if _, ok := positions[w.userId]; !ok {
+			positions[w.userId] = i + 1
+		}
+	}
+	runQueueLock.Unlock()
+
+	jobs = make(map[string]int)
+	turnQueueLock.Lock()
+	for _, job := range turnJobs {
+		jobs[job.UserID]++
+	}
+	turnQueueLock.Unlock()
+	return positions, jobs
+}
+
+// liveConversationLocked builds the live state of userId. Caller must hold userThreadLock.
+func liveConversationLocked(userId string, positions, jobs map[string]int) LiveConversation {
+	live := LiveConversation{
+		UserID:        userId,
+		Buffered:      []string{},
+		Unanswered:    []ConversationMessage{},
+		QueuePosition: positions[userId],
+		TurnJobs:      jobs[userId],
+	}
+	for _, msg := range userMsgBuffer[userId] {
+		live.Buffered = append(live.Buffered, imageDataURLPattern.ReplaceAllString(msg, "[image]"))
+	}
+	if conv, ok := userConversations[userId]; ok {
+		live.DisplayName = conv.DisplayName
+		live.Nickname = conv.Nickname
+		live.LastSeen = conv.LastSeen
+		live.Takeover = conv.Takeover
+		live.WantsHuman = conv.WantsHuman
+		live.Urgent = isUrgentLocked(conv)
+		start := len(conv.Messages)
+		for start > 0 && conv.Messages[start-1].Role == "customer" {
+			start--
+		}
+		for _, msg := range conv.Messages[start:] {
+			msg.Text = imageDataURLPattern.ReplaceAllString(msg.Text, "[image]")
+			live.Unanswered = append(live.Unanswered, msg)
+		}
+	}
+	_, running := userInflightRuns[userId]
+
+	switch {
+	case live.Takeover || live.WantsHuman:
+		live.Status = "waiting_staff"
+	case live.QueuePosition > 0 || (live.TurnJobs > 0 && !running):
+		live.Status = "queued"
+	case running:
+		live.Status = "running"
+	case len(live.Buffered) > 0:
+		live.Status = "buffering"
+	case len(live.Unanswered) > 0:
+		live.Status = "unanswered"
+	default:
+		live.Status = "idle"
+	}
+	return live
+}
+
+// handleGetLiveConversations lists conversations that need attention, urgent first and then
+// most recently active. ?minutes=N also includes idle conversations active in the last N minutes.
+func handleGetLiveConversations(c *fiber.Ctx) error {
+	minutes := c.QueryInt("minutes", 0)
+	if minutes < 0 {
+		return respondError(c, fiber.StatusBadRequest, "minutes must not be negative")
+	}
+	since := bangkokNow().Add(-time.Duration(minutes) * time.Minute)
+	positions, jobs := liveQueueState()
+
+	userThreadLock.Lock()
+	users := make(map[string]bool, len(userConversations))
+	for userId := range userConversations {
+		users[userId] = true
+	}
+	for userId, msgs := range userMsgBuffer {
+		if len(msgs) > 0 {
+			users[userId] = true
+		}
+	}
+	live := make([]LiveConversation, 0)
+	for userId := range users {
+		if isSimulatedUser(userId) {
+			continue
+		}
+		conv := liveConversationLocked(userId, positions, jobs)
+		if conv.Status == "idle" {
+			seen, err := time.ParseInLocation("2006-01-02T15:04:05", conv.LastSeen, since.Location())
+			if minutes == 0 || err != nil || seen.Before(since) {
+				continue
+			}
+		}
+		live = append(live, conv)
+	}
+	userThreadLock.Unlock()
+
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Urgent != live[j].Urgent {
+			return live[i].Urgent
+		}
+		return live[i].LastSeen > live[j].LastSeen
+	})
+	return c.JSON(live)
+}
+
+// handleGetPendingMessages returns one customer's buffered and unanswered messages and run state.
+func handleGetPendingMessages(c *fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId == "" {
+		return respondError(c, fiber.StatusBadRequest, "userId is required")
+	}
+	positions, jobs := liveQueueState()
+
+	userThreadLock.Lock()
+	_, known := userConversations[userId]
+	known = known || len(userMsgBuffer[userId]) > 0
+	live := liveConversationLocked(userId, positions, jobs)
+	userThreadLock.Unlock()
+
+	if !known {
+		return respondError(c, fiber.StatusNotFound, "conversation not found")
+	}
+	return c.JSON(live)
+}
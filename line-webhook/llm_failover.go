@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// When the primary model endpoint keeps failing, model calls move to a secondary one
+// (LLM_FALLBACK_BASE_URL: Azure OpenAI, a gateway, or any server speaking Chat Completions or
+// the Responses API), so an OpenAI outage doesn't take the bot down:
+//   - LLM_FAILOVER_ERRORS primary calls in a row that fail with a 5xx, a 429, a timeout or a
+//     connection error, or that take longer than LLM_FAILOVER_LATENCY_SECONDS, trip it;
+//   - from then on new turns and one-off calls use the fallback. A turn already running keeps
+//     its provider, since the tool calls it echoes back are in that provider's format;
+//   - after LLM_FAILOVER_COOLDOWN_SECONDS one call at a time tries the primary again. A
+//     success switches back; a failure restarts the cooldown.
+//
+// The state is shown under "llm" in /healthz. Without a fallback the failures are still
+// counted there, but every call stays on the primary.
+
+type llmFailoverState struct {
+	mu      sync.Mutex
+	strikes int       // primary calls failed or slow in a row
+	reason  string    // why the last one counted
+	since   time.Time // when calls moved to the fallback; zero while on the primary
+	probeAt time.Time // when a call last went to the primary during failover
+}
+
+var llmFailover llmFailoverState
+
+func llmFallbackConfigured() bool {
+	return appConfig.LLMFallbackBaseURL != ""
+}
+
+// useFallback reports whether a new call goes to the fallback. Once the cooldown has passed
+// it lets one call through to the primary per cooldown.
+func (s *llmFailoverState) useFallback() bool {
+	if !llmFallbackConfigured() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		return false
+	}
+	cooldown := appConfig.FailoverCooldown
+	if time.Since(s.since) >= cooldown && time.Since(s.probeAt) >= cooldown {
+		s.probeAt = time.Now()
+		log.Printf("Trying the primary model endpoint again after %s on the fallback", time.Since(s.since).Round(time.Second))
+		return false
+	}
+	return true
+}
+
+// record notes the outcome of a primary call. Errors that say nothing about the endpoint's
+// health, like a 400 or a cancelled turn, leave the count alone.
+func (s *llmFailoverState) record(err error, elapsed time.Duration) {
+	reason := primaryOutage(err)
+	if reason == "" && err == nil && elapsed > appConfig.FailoverLatency {
+		reason = fmt.Sprintf("answered after %s", elapsed.Round(time.Second))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason == "" {
+		if err != nil {
+			return
+		}
+		if !s.since.IsZero() {
+			log.Printf("Primary model endpoint answered again; switching back from the fallback")
+			appMetrics.inc("llm_failbacks")
+		}
+		s.strikes, s.reason, s.since, s.probeAt = 0, "", time.Time{}, time.Time{}
+		return
+	}
+	s.strikes++
+	s.reason = reason
+	appMetrics.inc("llm_primary_failures")
+	switch {
+	case !llmFallbackConfigured():
+	case !s.since.IsZero():
+		s.since = time.Now() // the retry failed: another cooldown
+	case s.strikes >= appConfig.FailoverErrors:
+		s.since = time.Now()
+		appMetrics.inc("llm_failovers")
+		log.Printf("Primary model endpoint failed %d calls in a row (%s); new turns use the fallback", s.strikes, reason)
+	}
+}
+
+// primaryOutage names the problem when err means the endpoint is down or overloaded.
+func primaryOutage(err error) string {
+	var timeout *TimeoutError
+	var upstream *UpstreamError
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return ""
+	case errors.As(err, &timeout):
+		return "timeout"
+	case errors.As(err, &upstream):
+		if upstream.StatusCode == 0 || upstream.StatusCode == 429 || upstream.StatusCode >= 500 {
+			return truncateRunes(upstream.Error(), 200)
+		}
+	}
+	return ""
+}
+
+// status is the "llm" part of /healthz.
+func (s *llmFailoverState) status() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := map[string]interface{}{
+		"provider":            "primary",
+		"fallback_configured": llmFallbackConfigured(),
+		"failing_calls":       s.strikes,
+	}
+	if s.reason != "" {
+		st["last_failure"] = s.reason
+	}
+	if !s.since.IsZero() {
+		st["provider"] = "fallback"
+		st["since"] = s.since
+	}
+	return st
+}
+
+// primaryLLMProvider reports the outcome of each call to llmFailover.
+type primaryLLMProvider struct {
+	LLMProvider
+}
+
+func (p *primaryLLMProvider) Respond(ctx context.Context, req *LLMRequest) (*LLMResponse, error) {
+	started := time.Now()
+	resp, err := p.LLMProvider.Respond(ctx, req)
+	llmFailover.record(err, time.Since(started))
+	return resp, err
+}
+
+// fallbackLLMProvider calls LLM_FALLBACK_BASE_URL, with LLM_FALLBACK_MODEL in place of the
+// configured model when set (Azure deployments have their own names). Background mode is a
+// primary-only setting.
+type fallbackLLMProvider struct {
+	LLMProvider
+	model string
+}
+
+func newFallbackLLMProvider(timeout time.Duration) LLMProvider {
+	client := newFallbackOpenAIClient(timeout)
+	if appConfig.LLMFallbackBackend == "responses" {
+		return &fallbackLLMProvider{&responsesProvider{api: client, foreground: true}, appConfig.LLMFallbackModel}
+	}
+	return &fallbackLLMProvider{&chatCompletionsProvider{api: client}, appConfig.LLMFallbackModel}
+}
+
+func (p *fallbackLLMProvider) Name() string { return "fallback_" + p.LLMProvider.Name() }
+
+func (p *fallbackLLMProvider) Respond(ctx context.Context, req *LLMRequest) (*LLMResponse, error) {
+	if p.model != "" {
+		r := *req
+		r.Params.Model = p.model
+		req = &r
+	}
+	appMetrics.inc("llm_fallback_calls")
+	return p.LLMProvider.Respond(ctx, req)
+}
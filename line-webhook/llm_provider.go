@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Assistant turns and the one-off model calls (handoff briefs, slips, documents) reach the
+// model through an LLMProvider chosen with LLM_BACKEND:
+//   - "responses" (default): the Responses API, including background mode;
+//   - "chat_completions": Chat Completions, for models or gateways that only offer it.
+//
+// Both are stateless: the history comes from conversations.json with every call. Chat
+// Completions has no file_search, so company documents are not searched in that mode.
+// When the primary endpoint keeps failing, calls move to LLM_FALLBACK_BASE_URL, see
+// llm_failover.go.
+
+// LLMRequest is one model call. Input starts with openai.Message items (or maps with "role"
+// and "content") and grows with the provider's Echo items and tool outputs during a turn.
+type LLMRequest struct {
+	Instructions string
+	Input        []interface{}
+	Tools        []interface{} // from assistantTools
+	Params       RunParams
+	Schema       *openai.JSONSchema // structured output the reply text must follow, if any
+}
+
+// LLMResponse is the answer to one call: tool calls to run, or the reply text.
+type LLMResponse struct {
+	Text         string
+	Calls        []LLMToolCall
+	Echo         []interface{} // the calls in the provider's input format, sent back before their outputs
+	InputTokens  int
+	OutputTokens int
+	Body         []byte // as received, for debug logging
+}
+
+type LLMToolCall struct {
+	ID        string
+	Name      string
+	Arguments json.RawMessage
+}
+
+type LLMProvider interface {
+	Name() string
+	Respond(ctx context.Context, req *LLMRequest) (*LLMResponse, error)
+	// ToolOutput is the input item answering the call with the given ID.
+	ToolOutput(callID, output string) interface{}
+}
+
+func llmBackend() string {
+	return appConfig.LLMBackend
+}
+
+// newLLMProvider returns the provider for a new turn or one-off call: the primary endpoint,
+// or the fallback while the primary is failing over.
+func newLLMProvider(timeout time.Duration) (LLMProvider, error) {
+	if llmFailover.useFallback() {
+		return newFallbackLLMProvider(timeout), nil
+	}
+	client, err := newOpenAIClient(timeout)
+	if err != nil {
+		return nil, err
+	}
+	if llmBackend() == "chat_completions" {
+		return &primaryLLMProvider{&chatCompletionsProvider{api: client}}, nil
+	}
+	return &primaryLLMProvider{&responsesProvider{api: client}}, nil
+}
+
+type responsesProvider struct {
+	api        openai.Responses
+	foreground bool // ignore OPENAI_RESPONSES_MODE=background
+}
+
+func (p *responsesProvider) Name() string { return "responses" }
+
+func (p *responsesProvider) Respond(ctx context.Context, req *LLMRequest) (*LLMResponse, error) {
+	r := &openai.ResponseRequest{Instructions: req.Instructions, Input: req.Input, Tools: req.Tools}
+	req.Params.applyTo(r)
+	if req.Schema != nil {
+		r.Text = openai.JSONSchemaText(req.Schema)
+	}
+	var resp *openai.Response
+	var err error
+	if p.foreground {
+		resp, err = p.api.CreateResponse(ctx, r)
+		err = openAIError(err)
+	} else {
+		resp, err = postResponse(ctx, p.api, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := &LLMResponse{InputTokens: resp.Usage.InputTokens, OutputTokens: resp.Usage.OutputTokens, Body: resp.Body}
+	for _, call := range resp.FunctionCalls() {
+		out.Calls = append(out.Calls, LLMToolCall{ID: call.CallID, Name: call.Name, Arguments: call.Arguments})
+	}
+	if len(out.Calls) == 0 {
+		out.Text = resp.OutputText()
+		return out, nil
+	}
+	// all output items go back with the outputs (Responses API requirement)
+	for _, item := range resp.Output {
+		out.Echo = append(out.Echo, item.Raw)
+	}
+	return out, nil
+}
+
+func (p *responsesProvider) ToolOutput(callID, output string) interface{} {
+	return openai.NewFunctionCallOutput(callID, output)
+}
+
+type chatCompletionsProvider struct {
+	api openai.ChatCompletions
+}
+
+func (p *chatCompletionsProvider) Name() string { return "chat_completions" }
+
+func (p *chatCompletionsProvider) Respond(ctx context.Context, req *LLMRequest) (*LLMResponse, error) {
+	r := &openai.ChatCompletionRequest{
+		Model:               req.Params.Model,
+		Messages:            []openai.ChatMessage{{Role: "system", Content: req.Instructions}},
+		Temperature:         req.Params.Temperature,
+		MaxCompletionTokens: req.Params.MaxOutputTokens,
+	}
+	if req.Schema != nil {
+		r.ResponseFormat = &openai.ChatFormat{Type: "json_schema", JSONSchema: req.Schema}
+	}
+	for _, item := range req.Input {
+		switch it := item.(type) {
+		case openai.ChatMessage:
+			r.Messages = append(r.Messages, it)
+		case openai.Message:
+			r.Messages = append(r.Messages, chatMessage(it.Role, it.Content))
+		case map[string]interface{}:
+			role, _ := it["role"].(string)
+			r.Messages = append(r.Messages, chatMessage(role, it["content"]))
+		}
+	}
+	for _, tool := range req.Tools {
+		// file_search has no Chat Completions counterpart and is left out
+		if def, ok := tool.(ToolDefinition); ok {
+			r.Tools = append(r.Tools, openai.ChatTool{Type: "function", Function: openai.ChatFunction{
+				Name: def.Name, Description: def.Description, Parameters: def.Parameters,
+			}})
+		}
+	}
+
+	completion, err := p.api.CreateChatCompletion(ctx, r)
+	if err != nil {
+		return nil, openAIError(err)
+	}
+	out := &LLMResponse{InputTokens: completion.Usage.PromptTokens, OutputTokens: completion.Usage.CompletionTokens, Body: completion.Body}
+	if len(completion.Choices) == 0 {
+		return out, nil
+	}
+	msg := completion.Choices[0].Message
+	for _, call := range msg.ToolCalls {
+		args := call.Function.Arguments
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		out.Calls = append(out.Calls, LLMToolCall{ID: call.ID, Name: call.Function.Name, Arguments: json.RawMessage(args)})
+	}
+	if len(out.Calls) > 0 {
+		out.Echo = []interface{}{msg}
+	} else if text, ok := msg.Content.(string); ok && strings.TrimSpace(text) != "" {
+		out.Text = text
+	}
+	return out, nil
+}
+
+func (p *chatCompletionsProvider) ToolOutput(callID, output string) interface{} {
+	return openai.ChatMessage{Role: "tool", ToolCallID: callID, Content: output}
+}
+
+// chatMessage converts a Responses API input message. Developer notes become system
+// messages, which every chat model accepts.
+func chatMessage(role string, content interface{}) openai.ChatMessage {
+	if role == "developer" {
+		role = "system"
+	}
+	parts, ok := content.([]interface{})
+	if !ok {
+		return openai.ChatMessage{Role: role, Content: content}
+	}
+	var converted []interface{}
+	for _, raw := range parts {
+		part, _ := raw.(map[string]interface{})
+		switch part["type"] {
+		case "input_text":
+			converted = append(converted, map[string]interface{}{"type": "text", "text": part["text"]})
+		case "input_image":
+			converted = append(converted, map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": part["image_url"]}})
+		case "input_file":
+			converted = append(converted, map[string]interface{}{"type": "file", "file": map[string]interface{}{"filename": part["filename"], "file_data": part["file_data"]}})
+		}
+	}
+	return openai.ChatMessage{Role: role, Content: converted}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// "Do you come to my area?" is one of the most common questions. When a customer shares a
+// LINE location, it is checked against the service areas (polygons first, then the provinces
+// named in the address) and answered instantly with whether NCS serves it and the travel
+// surcharge. The location stays in the conversation so the assistant can use it for booking,
+// and on the profile so later quotes include the area's travel surcharge.
+
+// SharedLocation is a LINE location message, kept on the profile so quotes can add the
+// travel surcharge of the customer's area.
+type SharedLocation struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Address   string    `json:"address,omitempty"`
+	SharedAt  time.Time `json:"shared_at"`
+}
+
+// rememberSharedLocation stores the location as the customer's last shared one.
+func rememberSharedLocation(userId, address string, lat, lng float64) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if ok {
+		conv.Profile.LastLocation = &SharedLocation{Latitude: lat, Longitude: lng, Address: address, SharedAt: time.Now()}
+	}
+	userThreadLock.Unlock()
+	if ok {
+		go saveConversations()
+	}
+}
+
+// travelSurchargeLine is appended to price quotes when the customer's last shared location
+// is in an area with a travel surcharge.
+func travelSurchargeLine(userId string) string {
+	match, ok := travelSurchargeFor(userId)
+	if !ok || match.Surcharge == 0 {
+		return ""
+	}
+	area := ""
+	if match.Name != "" {
+		area = " (" + match.Name + ")"
+	}
+	return fmt.Sprintf("\n🚗 ค่าเดินทางนอกพื้นที่%s: +%s บาท", area, pricing.FormatNumber(match.Surcharge))
+}
+
+// locationMessageText is what a location adds to the conversation.
+func locationMessageText(title, address string, lat, lng float64) string {
+	place := strings.TrimSpace(title + " " + address)
+	if place == "" {
+		return fmt.Sprintf("[ลูกค้าส่งตำแหน่ง: %.6f, %.6f]", lat, lng)
+	}
+	return fmt.Sprintf("[ลูกค้าส่งตำแหน่ง: %s (%.6f, %.6f)]", place, lat, lng)
+}
+
+// serviceAreaReply is the answer to a shared location.
+func serviceAreaReply(match ServiceAreaMatch, ok bool) string {
+	if !ok {
+		return "ขออภัยค่ะ ตำแหน่งนี้ยังอยู่นอกพื้นที่ให้บริการของ NCS ค่ะ 🙏 หากมีสถานที่อื่นที่ต้องการใช้บริการ ส่งตำแหน่งหรือที่อยู่มาให้ตรวจสอบได้เลยนะคะ"
+	}
+	area := "ตำแหน่งนี้"
+	if match.Name != "" {
+		area = "ตำแหน่งนี้ (" + match.Name + ")"
+	}
+	if match.Surcharge > 0 {
+		return fmt.Sprintf("%sอยู่ในพื้นที่ให้บริการของ NCS ค่ะ 😊 มีค่าเดินทางเพิ่ม %s บาทนะคะ สนใจบริการไหนแจ้งได้เลยค่ะ",
+			area, pricing.FormatNumber(match.Surcharge))
+	}
+	return area + "อยู่ในพื้นที่ให้บริการของ NCS ค่ะ 😊 ไม่มีค่าเดินทางเพิ่มค่ะ สนใจบริการไหนแจ้งได้เลยค่ะ"
+}
+
+// answerLocation replies to a shared location with the service-area check and reports whether
+// it was answered. Without configured service areas, or while staff have taken over, the
+// location is left to the assistant or staff.
+func answerLocation(userId, replyToken, messageContent, address string, lat, lng float64) bool {
+	if !hasServiceAreas() {
+		return false
+	}
+	started := time.Now()
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	if conv != nil && conv.Takeover {
+		userThreadLock.Unlock()
+		return false // staff are handling the chat
+	}
+	userThreadLock.Unlock()
+
+	match, ok := locateServiceArea(lat, lng, address)
+	branch, hasBranch := findBranch(match.Branch)
+	text := serviceAreaReply(match, ok)
+	messages := []map[string]interface{}{{"type": "text", "text": text}}
+	if replyToken != "" {
+		replyOrPush(userId, replyToken, messages)
+	} else if err := pushLineMessages(userId, messages); err != nil {
+		log.Printf("Failed to push service-area check to %s: %v", userId, err)
+		return false
+	}
+
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+	userThreadLock.Lock()
+	buf := userMsgBuffer[userId]
+	if n := len(buf); n > 0 && buf[n-1] == messageContent {
+		userMsgBuffer[userId] = buf[:n-1]
+	}
+	if conv, ok := userConversations[userId]; ok {
+		if ok && hasBranch {
+			assignBranchLocked(conv, branch)
+		}
+		conv.appendTurn(text, stats)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+
+	if ok {
+		log.Printf("Location from %s is in service area %s (surcharge %d)", userId, match.ID, match.Surcharge)
+		appMetrics.inc("location_checks_served")
+	} else {
+		log.Printf("Location from %s (%.5f, %.5f) is outside the service areas", userId, lat, lng)
+		appMetrics.inc("location_checks_outside")
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LogControls are runtime logging switches for investigating production issues. They are
+// kept in memory only: a restart goes back to LOG_LEVEL with capture and tracing off.
+type LogControls struct {
+	Level           string    `json:"level"`            // "info" or "debug"
+	CapturePayloads bool      `json:"capture_payloads"` // keep raw /webhook bodies for GET /admin/debug/payloads
+	TraceUsers      []string  `json:"trace_users"`      // users whose turns are logged at debug level
+	ExpiresAt       time.Time `json:"expires_at,omitempty"`
+}
+
+const maxCapturedPayloads = 200
+
+// CapturedPayload is one raw LINE webhook body.
+type CapturedPayload struct {
+	At   time.Time `json:"at"`
+	Body string    `json:"body"`
+}
+
+var (
+	logControlLock   sync.RWMutex
+	logControls      = defaultLogControls()
+	tracedUsers      = map[string]bool{}
+	capturedPayloads []CapturedPayload
+)
+
+func defaultLogControls() LogControls {
+	return LogControls{Level: appConfig.LogLevel, TraceUsers: []string{}}
+}
+
+// expireLogControlsLocked reverts to the defaults once the controls have expired.
+// Caller must hold logControlLock for writing.
+func expireLogControlsLocked() {
+	if logControls.ExpiresAt.IsZero() || time.Now().Before(logControls.ExpiresAt) {
+		return
+	}
+	log.Printf("Logging controls expired; back to level %s with capture and tracing off", defaultLogControls().Level)
+	logControls = defaultLogControls()
+	tracedUsers = map[string]bool{}
+	capturedPayloads = nil
+}
+
+func currentLogControls() LogControls {
+	logControlLock.Lock()
+	defer logControlLock.Unlock()
+	expireLogControlsLocked()
+	return logControls
+}
+
+// debugEnabled reports whether debug lines are logged, globally or for this user.
+func debugEnabled(userId string) bool {
+	logControlLock.RLock()
+	expired := !logControls.ExpiresAt.IsZero() && !time.Now().Before(logControls.ExpiresAt)
+	enabled := logControls.Level == "debug" || tracedUsers[userId]
+	logControlLock.RUnlock()
+	if expired {
+		return currentLogControls().Level == "debug"
+	}
+	return enabled
+}
+
+// debugf logs verbose detail (payloads, tool results, replies) only at debug level or
+// while userId is traced. Pass "" when the line is not about one user.
+func debugf(userId, format string, args ...interface{}) {
+	if !debugEnabled(userId) {
+		return
+	}
+	if userId == "" {
+		slog.Debug(fmt.Sprintf(format, args...))
+		return
+	}
+	slog.Debug(fmt.Sprintf(format, args...), "user_id", userId)
+}
+
+// captureWebhookPayload keeps the raw webhook body when capture is on.
+func captureWebhookPayload(body []byte) {
+	logControlLock.Lock()
+	defer logControlLock.Unlock()
+	expireLogControlsLocked()
+	if !logControls.CapturePayloads {
+		return
+	}
+	capturedPayloads = append(capturedPayloads, CapturedPayload{At: time.Now(), Body: string(body)})
+	if len(capturedPayloads) > maxCapturedPayloads {
+		capturedPayloads = capturedPayloads[len(capturedPayloads)-maxCapturedPayloads:]
+	}
+}
+
+func handleGetLogControls(c *fiber.Ctx) error {
+	return c.JSON(currentLogControls())
+}
+
+// handleReplaceLogControls changes the logging switches; expires_minutes makes them revert on
+// their own so capture and tracing are not left on by accident.
+func handleReplaceLogControls(c *fiber.Ctx) error {
+	var incoming struct {
+		LogControls
+		ExpiresMinutes int `json:"expires_minutes"`
+	}
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	controls := incoming.LogControls
+	controls.Level = strings.ToLower(strings.TrimSpace(controls.Level))
+	if controls.Level == "" {
+		controls.Level = "info"
+	}
+	if controls.Level != "info" && controls.Level != "debug" {
+		return respondError(c, fiber.StatusBadRequest, "level must be info or debug")
+	}
+	if incoming.ExpiresMinutes < 0 {
+		return respondError(c, fiber.StatusBadRequest, "expires_minutes must not be negative")
+	}
+	controls.ExpiresAt = time.Time{}
+	if incoming.ExpiresMinutes > 0 {
+		controls.ExpiresAt = time.Now().Add(time.Duration(incoming.ExpiresMinutes) * time.Minute)
+	}
+	traced := map[string]bool{}
+	users := []string{}
+	for _, id := range controls.TraceUsers {
+		if id = strings.TrimSpace(id); id != "" && !traced[id] {
+			traced[id] = true
+			users = append(users, id)
+		}
+	}
+	controls.TraceUsers = users
+
+	logControlLock.Lock()
+	logControls = controls
+	tracedUsers = traced
+	if !controls.CapturePayloads {
+		capturedPayloads = nil // raw payloads hold customer data; don't keep them around
+	}
+	logControlLock.Unlock()
+	log.Printf("Logging controls updated: level=%s capture_payloads=%v traced_users=%d expires_at=%v",
+		controls.Level, controls.CapturePayloads, len(users), controls.ExpiresAt)
+	return c.JSON(controls)
+}
+
+func handleGetCapturedPayloads(c *fiber.Ctx) error {
+	logControlLock.Lock()
+	defer logControlLock.Unlock()
+	expireLogControlsLocked()
+	list := make([]CapturedPayload, len(capturedPayloads))
+	copy(list, capturedPayloads)
+	return c.JSON(list)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
@@ -9,78 +11,34 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/config"
+	"ncs-chatbot/line-webhook/openai"
+	"ncs-chatbot/line-webhook/pricing"
 )
 
 //go:embed admin-ui
 var adminUI embed.FS
 
-// PricingConfig represents the JSON pricing configuration structure
-type PricingConfig struct {
-	Services      map[string]ServiceConfig      `json:"services"`
-	Items         map[string]ItemConfig         `json:"items"`
-	Packages      map[string]PackageConfig      `json:"packages"`
-	CustomerTypes map[string]CustomerTypeConfig `json:"customer_types"`
-}
-
-type ServiceConfig struct {
-	Name    string   `json:"name"`
-	Aliases []string `json:"aliases"`
-}
-
-type ItemConfig struct {
-	Name    string                `json:"name"`
-	Aliases []string              `json:"aliases"`
-	Sizes   map[string]SizeConfig `json:"sizes"`
-}
-
-type SizeConfig struct {
-	Name    string                                       `json:"name"`
-	Aliases []string                                     `json:"aliases"`
-	Pricing map[string]map[string]map[string]PriceConfig `json:"pricing"` // [service][customer][package]
-}
-
-type PriceConfig struct {
-	FullPrice  int `json:"full_price,omitempty"`
-	Discount35 int `json:"discount_35,omitempty"`
-	Discount50 int `json:"discount_50,omitempty"`
-}
-
-type PackageConfig struct {
-	Name         string                  `json:"name"`
-	Aliases      []string                `json:"aliases"`
-	Disinfection map[string]PackagePrice `json:"disinfection,omitempty"`
-	Washing      map[string]PackagePrice `json:"washing,omitempty"`
-}
-
-type PackagePrice struct {
-	FullPrice  int `json:"full_price"`
-	Discount   int `json:"discount"`
-	SalePrice  int `json:"sale_price"`
-	PerItem    int `json:"per_item"`
-	DepositMin int `json:"deposit_min,omitempty"`
-}
-
-type CustomerTypeConfig struct {
-	Name    string   `json:"name"`
-	Aliases []string `json:"aliases"`
-}
-
 // ConversationMessage stores a single message in a conversation history
 type ConversationMessage struct {
 	Role      string `json:"role"` // "customer", "ai", "admin"
 	Text      string `json:"text"`
-	Timestamp string `json:"timestamp"` // Bangkok time
+	Timestamp string `json:"timestamp"`        // Bangkok time
+	Sealed    string `json:"sealed,omitempty"` // encrypted original when Text has personal data masked (pii.go)
+	plain     string // original of a masked Text when there is no key to seal it; memory only
+
+	Turn *TurnStats `json:"turn,omitempty"` // cost and latency of the AI turn that produced this reply
 }
 
 // UserConversation tracks the full state for a LINE user conversation
@@ -93,20 +51,57 @@ type UserConversation struct {
 	WantsHuman      bool                  `json:"wants_human"` // customer requested a human
 	LastSeen        string                `json:"last_seen"`
 	LastAdminAction time.Time             `json:"last_admin_action"` // last time admin acted (takeover or reply)
+
+	ConsecutiveFailures int    `json:"consecutive_failures"`       // AI turns in a row that ended in an error
+	FailureEscalated    bool   `json:"failure_escalated"`          // AI paused; collecting phone for a callback
+	CallbackTaskID      string `json:"callback_task_id,omitempty"` // open callback task for this user
+
+	Profile CustomerProfile `json:"profile"`
+	Handoff *HandoffSummary `json:"handoff,omitempty"` // brief for staff, written when the customer is escalated
+
+	UrgentSince    time.Time `json:"urgent_since,omitempty"`     // priority flow started (urgent job)
+	UrgencyAlertAt time.Time `json:"urgency_alert_at,omitempty"` // last urgency/frustration alert sent to staff
+
+	Attribution *MarketingAttribution `json:"attribution,omitempty"` // marketing source of the conversation
+
+	GreetedAt time.Time `json:"greeted_at,omitempty"` // canned first-time greeting sent
+
+	Experiment *ExperimentAssignment `json:"experiment,omitempty"` // instructions A/B variant
+
+	Mode      string    `json:"mode,omitempty"`        // sales (default), aftercare or complaint
+	ModeSetAt time.Time `json:"mode_set_at,omitempty"` // last switch to or match of the mode
+
+	Channel string `json:"channel,omitempty"` // LINE channel the customer writes through (channels.go)
+
+	ContextFrom    string `json:"context_from,omitempty"`    // model history starts here after an idle reset or a summary
+	ContextSummary string `json:"context_summary,omitempty"` // stands in for the messages before ContextFrom (context_summary.go)
+
+	ModerationStrikes int  `json:"moderation_strikes,omitempty"` // turns blocked by moderation (moderation.go)
+	ModerationFlagged bool `json:"moderation_flagged,omitempty"` // repeat offender, marked for staff
 }
 
 func (c *UserConversation) appendMessage(role, text string) {
-	c.Messages = append(c.Messages, ConversationMessage{
+	masked, sealed := maskForStorage(text)
+	msg := ConversationMessage{
 		Role:      role,
-		Text:      text,
+		Text:      masked,
 		Timestamp: getBangkokTime(),
-	})
+		Sealed:    sealed,
+	}
+	if masked != text && sealed == "" {
+		msg.plain = text
+	}
+	c.Messages = append(c.Messages, msg)
+	recordConversationEvent(ConversationEvent{UserID: c.UserID, Kind: "message", Role: role, Content: masked})
 	const maxConvMessages = 200
 	if len(c.Messages) > maxConvMessages {
 		c.Messages = c.Messages[len(c.Messages)-maxConvMessages:]
 	}
 }
 
+// appConfig holds the settings loaded at startup; see package config.
+var appConfig = config.Defaults()
+
 var pricingConfigFile = "pricing_config.json"
 var conversationsFile = "conversations.json"
 
@@ -142,14 +137,15 @@ func loadConversationsFromFile() {
 	log.Printf("Loaded %d conversations from file", len(userConversations))
 }
 
-// fetchAndStoreLineDisplayName calls the LINE Profile API and stores the result.
-// Run as a goroutine; safe to ignore errors.
+// fetchAndStoreLineDisplayName calls the LINE Profile API (group summary for groups) and
+// stores the result. Run as a goroutine; safe to ignore errors.
 func fetchAndStoreLineDisplayName(userId string) {
-	lineToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
-	if lineToken == "" {
+	lineToken := lineAccessToken(userId)
+	nameURL, ok := lineChatNameURL(userId)
+	if lineToken == "" || !ok {
 		return
 	}
-	req, err := http.NewRequest("GET", "https://api.line.me/v2/bot/profile/"+userId, nil)
+	req, err := http.NewRequest("GET", nameURL, nil)
 	if err != nil {
 		return
 	}
@@ -162,8 +158,15 @@ func fetchAndStoreLineDisplayName(userId string) {
 	defer resp.Body.Close()
 	var profile struct {
 		DisplayName string `json:"displayName"`
+		GroupName   string `json:"groupName"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil || profile.DisplayName == "" {
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = profile.GroupName
+	}
+	if profile.DisplayName == "" {
 		return
 	}
 	userThreadLock.Lock()
@@ -174,22 +177,22 @@ func fetchAndStoreLineDisplayName(userId string) {
 	go saveConversations()
 }
 
-var pricingConfig *PricingConfig
+var pricingConfig *pricing.Config
 
 type UpdatePriceRequest struct {
-	ServiceKey  string      `json:"service_key"`
-	ItemKey     string      `json:"item_key"`
-	SizeKey     string      `json:"size_key"`
-	CustomerKey string      `json:"customer_key"`
-	PackageKey  string      `json:"package_key"`
-	Price       PriceConfig `json:"price"`
+	ServiceKey  string        `json:"service_key"`
+	ItemKey     string        `json:"item_key"`
+	SizeKey     string        `json:"size_key"`
+	CustomerKey string        `json:"customer_key"`
+	PackageKey  string        `json:"package_key"`
+	Price       pricing.Price `json:"price"`
 }
 
 type UpdatePromotionRequest struct {
-	PackageKey string       `json:"package_key"`
-	ServiceKey string       `json:"service_key"`
-	Quantity   int          `json:"quantity"`
-	Price      PackagePrice `json:"price"`
+	PackageKey string               `json:"package_key"`
+	ServiceKey string               `json:"service_key"`
+	Quantity   int                  `json:"quantity"`
+	Price      pricing.PackagePrice `json:"price"`
 }
 
 func (r *UpdatePriceRequest) normalize() {
@@ -216,7 +219,7 @@ func (r UpdatePriceRequest) validate() error {
 	if r.CustomerKey == "" {
 		return errors.New("customer_key is required")
 	}
-	if !priceHasValue(r.Price) {
+	if !r.Price.HasValue() {
 		return errors.New("price must include at least one value")
 	}
 	return nil
@@ -237,20 +240,12 @@ func (r UpdatePromotionRequest) validate() error {
 	if r.Quantity <= 0 {
 		return errors.New("quantity must be greater than zero")
 	}
-	if !packagePriceHasValue(r.Price) {
+	if !r.Price.HasValue() {
 		return errors.New("price must include at least one field")
 	}
 	return nil
 }
 
-func priceHasValue(p PriceConfig) bool {
-	return p.FullPrice > 0 || p.Discount35 > 0 || p.Discount50 > 0
-}
-
-func packagePriceHasValue(p PackagePrice) bool {
-	return p.FullPrice > 0 || p.Discount > 0 || p.SalePrice > 0 || p.PerItem > 0
-}
-
 // loadPricingConfig loads pricing configuration from JSON file
 func loadPricingConfig() error {
 	data, err := os.ReadFile(pricingConfigFile)
@@ -258,71 +253,46 @@ func loadPricingConfig() error {
 		return fmt.Errorf("failed to read pricing config: %v", err)
 	}
 
-	pricingConfig = &PricingConfig{}
-	if err := json.Unmarshal(data, pricingConfig); err != nil {
+	cfg, err := pricing.Parse(data)
+	if err != nil {
 		return fmt.Errorf("failed to parse pricing config: %v", err)
 	}
-	sanitizePricingConfig(pricingConfig)
+	pricingConfig = cfg
+	pricingFileSum = sha256.Sum256(data)
 
 	log.Println("Pricing configuration loaded successfully")
 	return nil
 }
 
-func sanitizePricingConfig(cfg *PricingConfig) {
-	if cfg == nil {
-		return
-	}
-	if cfg.Services == nil {
-		cfg.Services = make(map[string]ServiceConfig)
-	}
-	if cfg.Items == nil {
-		cfg.Items = make(map[string]ItemConfig)
-	}
-	if cfg.Packages == nil {
-		cfg.Packages = make(map[string]PackageConfig)
-	}
-	if cfg.CustomerTypes == nil {
-		cfg.CustomerTypes = make(map[string]CustomerTypeConfig)
-	}
-	for itemKey, item := range cfg.Items {
-		if item.Sizes == nil {
-			item.Sizes = make(map[string]SizeConfig)
-		}
-		for sizeKey, sizeCfg := range item.Sizes {
-			if sizeCfg.Pricing == nil {
-				sizeCfg.Pricing = make(map[string]map[string]map[string]PriceConfig)
-			}
-			for serviceKey, customerMap := range sizeCfg.Pricing {
-				if customerMap == nil {
-					sizeCfg.Pricing[serviceKey] = make(map[string]map[string]PriceConfig)
-					continue
-				}
-				for customerKey, packageMap := range customerMap {
-					if packageMap == nil {
-						customerMap[customerKey] = make(map[string]PriceConfig)
-					}
-				}
-			}
-			item.Sizes[sizeKey] = sizeCfg
-		}
-		cfg.Items[itemKey] = item
+// bahtAmountPattern matches prices in replies, e.g. "1,290 บาท", "฿990" or "990 baht".
+var bahtAmountPattern = regexp.MustCompile(`(?i)\d[\d,.]*\s*(บาท|฿|baht|thb)|฿\s*\d`)
+
+// activatePricingConfig makes cfg the live price list. Cached answers quoting prices are
+// dropped so the answer cache never replays an outdated price.
+func activatePricingConfig(cfg *pricing.Config, reason string) {
+	pricingConfig = cfg
+	invalidatePricedAnswers(reason)
+}
+
+// invalidatePricedAnswers removes cached answers that contain a Baht amount.
+func invalidatePricedAnswers(reason string) {
+	userThreadLock.Lock()
+	removed := 0
+	for _, c := range userAnswerCache {
+		removed += c.removeMatching(func(a cachedAnswer) bool { return bahtAmountPattern.MatchString(a.Answer) })
 	}
-	for pkgKey, pkg := range cfg.Packages {
-		if pkg.Disinfection == nil {
-			pkg.Disinfection = make(map[string]PackagePrice)
-		}
-		if pkg.Washing == nil {
-			pkg.Washing = make(map[string]PackagePrice)
-		}
-		cfg.Packages[pkgKey] = pkg
+	userThreadLock.Unlock()
+	if removed > 0 {
+		log.Printf("Pricing changed (%s); dropped %d cached answer(s) with prices", reason, removed)
+		appMetrics.add("cached_answers_invalidated", int64(removed))
 	}
 }
 
-func savePricingConfigToFile(cfg *PricingConfig) error {
+func savePricingConfigToFile(cfg *pricing.Config) error {
 	if cfg == nil {
 		return errors.New("pricing config is nil")
 	}
-	sanitizePricingConfig(cfg)
+	cfg.Sanitize()
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal pricing config: %w", err)
@@ -334,31 +304,16 @@ func savePricingConfigToFile(cfg *PricingConfig) error {
 	if err := os.Rename(tmpPath, pricingConfigFile); err != nil {
 		return fmt.Errorf("failed to replace pricing config: %w", err)
 	}
+	pricingFileSum = sha256.Sum256(data)
 	return nil
 }
 
-func clonePricingConfig(cfg *PricingConfig) (*PricingConfig, error) {
-	if cfg == nil {
-		return nil, errors.New("pricing config is nil")
-	}
-	data, err := json.Marshal(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to copy pricing config: %w", err)
-	}
-	clone := &PricingConfig{}
-	if err := json.Unmarshal(data, clone); err != nil {
-		return nil, fmt.Errorf("failed to rebuild clone: %w", err)
-	}
-	sanitizePricingConfig(clone)
-	return clone, nil
-}
-
 func respondError(c *fiber.Ctx, status int, message string) error {
 	return c.Status(status).JSON(fiber.Map{"error": message})
 }
 
 func adminAuthMiddleware(c *fiber.Ctx) error {
-	adminToken := os.Getenv("ADMIN_API_TOKEN")
+	adminToken := appConfig.AdminToken
 	if adminToken == "" {
 		log.Printf("ADMIN_API_TOKEN is not configured; rejecting admin request from %s", c.IP())
 		return respondError(c, fiber.StatusForbidden, "admin API is disabled")
@@ -378,16 +333,21 @@ func handleGetPricingConfig(c *fiber.Ctx) error {
 }
 
 func handleReplacePricingConfig(c *fiber.Ctx) error {
-	var incoming PricingConfig
+	var incoming pricing.Config
 	if err := c.BodyParser(&incoming); err != nil {
 		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
 	}
-	sanitizePricingConfig(&incoming)
+	incoming.Sanitize()
+	if err := incoming.Validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	pricingWriteLock.Lock()
+	defer pricingWriteLock.Unlock()
 	if err := savePricingConfigToFile(&incoming); err != nil {
 		log.Printf("Failed to persist pricing config: %v", err)
 		return respondError(c, fiber.StatusInternalServerError, "unable to save pricing config")
 	}
-	pricingConfig = &incoming
+	activatePricingConfig(&incoming, "config replaced")
 	return c.JSON(fiber.Map{
 		"status": "ok",
 		"config": pricingConfig,
@@ -406,19 +366,9 @@ func handleUpdatePriceEntry(c *fiber.Ctx) error {
 	if err := req.validate(); err != nil {
 		return respondError(c, fiber.StatusBadRequest, err.Error())
 	}
-	workingCopy, err := clonePricingConfig(pricingConfig)
-	if err != nil {
-		log.Printf("Failed to clone pricing config: %v", err)
-		return respondError(c, fiber.StatusInternalServerError, "unable to prepare pricing config")
-	}
-	if err := applyPriceUpdate(workingCopy, req); err != nil {
-		return respondError(c, fiber.StatusBadRequest, err.Error())
-	}
-	if err := savePricingConfigToFile(workingCopy); err != nil {
-		log.Printf("Failed to save pricing config: %v", err)
-		return respondError(c, fiber.StatusInternalServerError, "unable to persist pricing config")
+	if _, err := updatePricingConfig("price updated", func(cfg *pricing.Config) error { return applyPriceUpdate(cfg, req) }); err != nil {
+		return respondPricingError(c, err)
 	}
-	pricingConfig = workingCopy
 	return c.JSON(fiber.Map{
 		"status": "ok",
 		"price":  req.Price,
@@ -437,106 +387,41 @@ func handleUpdatePromotionEntry(c *fiber.Ctx) error {
 	if err := req.validate(); err != nil {
 		return respondError(c, fiber.StatusBadRequest, err.Error())
 	}
-	workingCopy, err := clonePricingConfig(pricingConfig)
-	if err != nil {
-		log.Printf("Failed to clone pricing config: %v", err)
-		return respondError(c, fiber.StatusInternalServerError, "unable to prepare pricing config")
-	}
-	if err := applyPromotionUpdate(workingCopy, req); err != nil {
-		return respondError(c, fiber.StatusBadRequest, err.Error())
-	}
-	if err := savePricingConfigToFile(workingCopy); err != nil {
-		log.Printf("Failed to save pricing config: %v", err)
-		return respondError(c, fiber.StatusInternalServerError, "unable to persist pricing config")
+	if _, err := updatePricingConfig("promotion updated", func(cfg *pricing.Config) error { return applyPromotionUpdate(cfg, req) }); err != nil {
+		return respondPricingError(c, err)
 	}
-	pricingConfig = workingCopy
 	return c.JSON(fiber.Map{
 		"status":    "ok",
 		"promotion": req.Price,
 	})
 }
 
-func applyPriceUpdate(cfg *PricingConfig, req UpdatePriceRequest) error {
-	service, ok := cfg.Services[req.ServiceKey]
-	if !ok {
-		return fmt.Errorf("unknown service_key '%s'", req.ServiceKey)
-	}
-	_ = service // service variable kept for future logging/validation
-	item, ok := cfg.Items[req.ItemKey]
-	if !ok {
-		return fmt.Errorf("unknown item_key '%s'", req.ItemKey)
-	}
-	sizeCfg, ok := item.Sizes[req.SizeKey]
-	if !ok {
-		return fmt.Errorf("unknown size_key '%s'", req.SizeKey)
-	}
-	if sizeCfg.Pricing == nil {
-		sizeCfg.Pricing = make(map[string]map[string]map[string]PriceConfig)
-	}
-	if _, ok := sizeCfg.Pricing[req.ServiceKey]; !ok {
-		sizeCfg.Pricing[req.ServiceKey] = make(map[string]map[string]PriceConfig)
-	}
-	customerPricing := sizeCfg.Pricing[req.ServiceKey]
-	if _, ok := customerPricing[req.CustomerKey]; !ok {
-		customerPricing[req.CustomerKey] = make(map[string]PriceConfig)
-	}
-	customerPricing[req.CustomerKey][req.PackageKey] = req.Price
-	sizeCfg.Pricing[req.ServiceKey] = customerPricing
-	item.Sizes[req.SizeKey] = sizeCfg
-	cfg.Items[req.ItemKey] = item
-	return nil
+func applyPriceUpdate(cfg *pricing.Config, req UpdatePriceRequest) error {
+	return cfg.SetItemPrice(req.ServiceKey, req.ItemKey, req.SizeKey, req.CustomerKey, req.PackageKey, req.Price)
 }
 
-func applyPromotionUpdate(cfg *PricingConfig, req UpdatePromotionRequest) error {
-	pkg, ok := cfg.Packages[req.PackageKey]
-	if !ok {
-		return fmt.Errorf("unknown package_key '%s'", req.PackageKey)
-	}
-	quantityKey := strconv.Itoa(req.Quantity)
-	switch req.ServiceKey {
-	case "disinfection":
-		if pkg.Disinfection == nil {
-			pkg.Disinfection = make(map[string]PackagePrice)
-		}
-		pkg.Disinfection[quantityKey] = req.Price
-	case "washing":
-		if pkg.Washing == nil {
-			pkg.Washing = make(map[string]PackagePrice)
-		}
-		pkg.Washing[quantityKey] = req.Price
-	default:
-		return fmt.Errorf("service_key '%s' is not supported for promotions", req.ServiceKey)
-	}
-	cfg.Packages[req.PackageKey] = pkg
-	return nil
+func applyPromotionUpdate(cfg *pricing.Config, req UpdatePromotionRequest) error {
+	return cfg.SetPackagePrice(req.PackageKey, req.ServiceKey, req.Quantity, req.Price)
 }
 
 // getBangkokTime returns current time in Asia/Bangkok in RFC3339 format (YYYY-MM-DDTHH:MM:SS) without timezone suffix.
 func getBangkokTime() string {
+	return bangkokNow().Format("2006-01-02T15:04:05")
+}
+
+// bangkokNow returns the current time in Asia/Bangkok, falling back to local time.
+func bangkokNow() time.Time {
 	loc, err := time.LoadLocation("Asia/Bangkok")
 	if err != nil {
-		// Fallback to local time if loading fails
-		return time.Now().Format("2006-01-02T15:04:05")
+		return time.Now()
 	}
-	return time.Now().In(loc).Format("2006-01-02T15:04:05")
+	return time.Now().In(loc)
 }
 
 // extractAndProcessPricingJSON extracts JSON pricing parameters from assistant response and calls getNCSPricing
 func extractAndProcessPricingJSON(response string) string {
 	log.Printf("Attempting to extract JSON from response: %s", response)
 
-	// Look for JSON pattern in the response
-	start := strings.Index(response, "{")
-	end := strings.LastIndex(response, "}")
-
-	if start == -1 || end == -1 || start >= end {
-		log.Printf("No valid JSON found in response")
-		return ""
-	}
-
-	jsonStr := response[start : end+1]
-	log.Printf("Extracted JSON string: %s", jsonStr)
-
 	var args struct {
 		ServiceType  string `json:"service_type"`
 		ItemType     string `json:"item_type"`
@@ -546,8 +431,9 @@ func extractAndProcessPricingJSON(response string) string {
 		Quantity     int    `json:"quantity"`
 	}
 
-	if err := json.Unmarshal([]byte(jsonStr), &args); err != nil {
-		log.Printf("Failed to parse extracted JSON: %v", err)
+	// the response may hold prose, code fences, several objects or a truncated one
+	if err := decodeModelJSON(response, &args); err != nil {
+		log.Printf("Failed to parse pricing JSON: %v", err)
 		return ""
 	}
 
@@ -555,24 +441,44 @@ func extractAndProcessPricingJSON(response string) string {
 		args.ServiceType, args.ItemType, args.Size, args.CustomerType, args.PackageType, args.Quantity)
 
 	// Call the pricing function with the extracted parameters
-	result := getNCSPricing(args.ServiceType, args.ItemType, args.Size, args.CustomerType, args.PackageType, args.Quantity)
-	log.Printf("Pricing function result: %s", result)
+	result, err := getNCSPricing(pricingEngine(), args.ServiceType, args.ItemType, args.Size, args.CustomerType, args.PackageType, args.Quantity, "")
+	log.Printf("Pricing function result (err=%v): %s", err, result)
 
 	return result
 }
 
 type LineEvent struct {
-	Events []struct {
-		Type       string `json:"type"`
-		ReplyToken string `json:"replyToken"`
-		Source     struct {
-			UserID string `json:"userId"`
-		} `json:"source"`
-		Message struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-			ID   string `json:"id"`
+	Destination string `json:"destination"` // user ID of the bot the events were sent to
+	Events      []struct {
+		Type            string `json:"type"`
+		WebhookEventID  string `json:"webhookEventId"`
+		DeliveryContext struct {
+			IsRedelivery bool `json:"isRedelivery"`
+		} `json:"deliveryContext"`
+		ReplyToken string     `json:"replyToken"`
+		Source     LineSource `json:"source"`
+		Message    struct {
+			Type    string `json:"type"`
+			Text    string `json:"text"`
+			Mention struct {
+				Mentionees []LineMention `json:"mentionees"`
+			} `json:"mention"` // text messages only
+			ID       string `json:"id"`
+			FileName string `json:"fileName"` // file messages only
+			Duration int    `json:"duration"` // audio messages only, in milliseconds
+			// sticker messages only
+			PackageID string   `json:"packageId"`
+			StickerID string   `json:"stickerId"`
+			Keywords  []string `json:"keywords"`
+			// location messages only
+			Title     string  `json:"title"`
+			Address   string  `json:"address"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
 		} `json:"message"`
+		Postback struct {
+			Data string `json:"data"`
+		} `json:"postback"`
 	} `json:"events"`
 }
 
@@ -590,34 +496,90 @@ var (
 
 	userThreadLock sync.Mutex
 
-	userLastQAMap = make(map[string]struct {
-		Question string
-		Answer   string
-	})
-
 	userMsgBuffer = make(map[string][]string) // buffer for each user
 	userMsgTimer  = make(map[string]*time.Timer)
 
+	userRequestIDs   = make(map[string]string) // /webhook request that last added to the buffer, for log correlation
+	userTraceParents = make(map[string]string) // span of that request, parent of the turn's spans (tracing.go)
+
 	userConversations = make(map[string]*UserConversation) // conversation history per user
 )
 
 func main() {
+	// Settings come from the environment and CONFIG_FILE; they are validated once the
+	// maintenance commands, which don't need tokens, are out of the way
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	appConfig = cfg
+	pricingConfigFile = appConfig.PricingFile
+	logControls = defaultLogControls()
+	setupLogging()
+
 	// Set data file paths from DATA_DIR env var (for persistent disk on Render etc.)
-	if dir := os.Getenv("DATA_DIR"); dir != "" {
+	if dir := appConfig.DataDir; dir != "" {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Printf("Warning: could not create DATA_DIR %s: %v", dir, err)
 		}
-		destPricing := filepath.Join(dir, "pricing_config.json")
-		// Auto-copy pricing_config.json to persistent disk on first deploy
-		if _, err := os.Stat(destPricing); os.IsNotExist(err) {
-			if src, err := os.ReadFile("pricing_config.json"); err == nil {
-				if err := os.WriteFile(destPricing, src, 0644); err == nil {
-					log.Printf("Auto-copied pricing_config.json to %s", destPricing)
+		// Auto-copy pricing_config.json to persistent disk on first deploy, unless
+		// PRICING_CONFIG_FILE points somewhere else
+		if pricingConfigFile == "pricing_config.json" {
+			destPricing := filepath.Join(dir, "pricing_config.json")
+			if _, err := os.Stat(destPricing); os.IsNotExist(err) {
+				if src, err := os.ReadFile("pricing_config.json"); err == nil {
+					if err := os.WriteFile(destPricing, src, 0644); err == nil {
+						log.Printf("Auto-copied pricing_config.json to %s", destPricing)
+					}
+				}
+			}
+			pricingConfigFile = destPricing
+		}
+		destRunParams := filepath.Join(dir, "run_params.json")
+		if _, err := os.Stat(destRunParams); os.IsNotExist(err) {
+			if src, err := os.ReadFile("run_params.json"); err == nil {
+				if err := os.WriteFile(destRunParams, src, 0644); err == nil {
+					log.Printf("Auto-copied run_params.json to %s", destRunParams)
 				}
 			}
 		}
-		pricingConfigFile = destPricing
+		runParamsFile = destRunParams
 		conversationsFile = filepath.Join(dir, "conversations.json")
+		lineQuotaFile = filepath.Join(dir, "line_quota.json")
+		mediaDir = filepath.Join(dir, "media")
+		serviceAreasFile = filepath.Join(dir, "service_areas.json")
+		callbackTasksFile = filepath.Join(dir, "callback_tasks.json")
+		reconciliationReportFile = filepath.Join(dir, "reconciliation_report.json")
+		bookingsFile = filepath.Join(dir, "bookings.json")
+		campaignCodesFile = filepath.Join(dir, "campaign_codes.json")
+		paymentsFile = filepath.Join(dir, "payments.json")
+		quotesFile = filepath.Join(dir, "quotes.json")
+		giftVouchersFile = filepath.Join(dir, "gift_vouchers.json")
+		contractsFile = filepath.Join(dir, "contracts.json")
+		outboundEndpointsFile = filepath.Join(dir, "outbound_webhooks.json")
+		outboundDeliveriesFile = filepath.Join(dir, "outbound_deliveries.json")
+		branchesFile = filepath.Join(dir, "branches.json")
+		greetingFile = filepath.Join(dir, "greeting.json")
+		channelsFile = filepath.Join(dir, "channels.json")
+		stickerRepliesFile = filepath.Join(dir, "sticker_replies.json")
+		archivedConversationsFile = filepath.Join(dir, "archived_conversations.json")
+		archiveDir = filepath.Join(dir, "archive")
+		npsFile = filepath.Join(dir, "nps.json")
+		keywordTriggersFile = filepath.Join(dir, "keyword_triggers.json")
+		faqFile = filepath.Join(dir, "faq.json")
+		faqEmbeddingsFile = filepath.Join(dir, "faq_embeddings.json")
+		importedCustomersFile = filepath.Join(dir, "imported_customers.json")
+		instructionExperimentFile = filepath.Join(dir, "instruction_experiment.json")
+		retentionPolicyFile = filepath.Join(dir, "retention_policy.json")
+		serviceComparisonFile = filepath.Join(dir, "service_comparison.json")
+		slotTemplateFile = filepath.Join(dir, "slot_template.json")
+		conversationModesFile = filepath.Join(dir, "conversation_modes.json")
+		knowledgeManifestFile = filepath.Join(dir, "knowledge_manifest.json")
+		turnQueueFile = filepath.Join(dir, "turn_queue.json")
+		paymentSlipsFile = filepath.Join(dir, "payment_slips.json")
+		conversationLogFile = filepath.Join(dir, "conversation_log.jsonl")
+		usageFile = filepath.Join(dir, "usage.json")
+		webhookEventsFile = filepath.Join(dir, "webhook_events.json")
 		log.Printf("Data directory: %s", dir)
 	}
 
@@ -625,6 +587,19 @@ func main() {
 	if err := loadPricingConfig(); err != nil {
 		log.Fatal("Failed to load pricing configuration:", err)
 	}
+	// Maintenance subcommands (e.g. import-pricing) run and exit without starting the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := appConfig.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadGoogleServiceAccount(); err != nil {
+		log.Fatalf("Failed to load Google service account: %v", err)
+	}
 	// Load AI system instructions and tool definitions for Responses API
 	if err := loadSystemInstructions(); err != nil {
 		log.Fatalf("Failed to load system instructions: %v", err)
@@ -634,6 +609,54 @@ func main() {
 	}
 	// Restore conversation history from previous run
 	loadConversationsFromFile()
+	loadChannels()
+	loadLineQuotaState()
+	loadServiceAreas()
+	loadBranches()
+	loadGreeting()
+	loadStickerConfig()
+	loadSlotTemplate()
+	loadConversationModes()
+	loadKeywordTriggers()
+	loadFAQ()
+	loadImportedCustomers()
+	loadInstructionExperiment()
+	loadCallbackTasks()
+	loadReconciliationReport()
+	loadBookings()
+	loadCampaignCodes()
+	loadPayments()
+	loadQuotations()
+	loadPaymentSlips()
+	loadGiftVouchers()
+	loadContracts()
+	loadOutboundWebhooks()
+	loadArchivedConversations()
+	loadNPS()
+	loadRetentionPolicy()
+	loadServiceComparison()
+	loadUsage()
+	loadWebhookEvents()
+	coldStore = newColdStore()
+	loadRunParams()
+	startTracing()
+	startConversationLog()
+	startMemberDirectory()
+	loadTurnQueue()
+	startTurnWorkers()
+	startLineQuotaMonitor()
+	startReconciliationJob()
+	startUsageReportJob()
+	startChatExportCleanup()
+	startWebhookEventPruneJob()
+	startContractJob()
+	startOutboundWorker()
+	startArchivalJob()
+	startContextResetJob()
+	startNPSJob()
+	startRetentionJob()
+	startPricingReloadWatcher()
+	requireWebhookSecrets()
 
 	// Auto-release admin takeover after 30 minutes of inactivity
 	go func() {
@@ -662,6 +685,9 @@ func main() {
 
 	app := fiber.New()
 
+	app.Get("/healthz", handleHealthz)
+	app.Get("/readyz", handleReadyz)
+
 	// Serve embedded admin UI files
 	app.Get("/admin-ui/", func(c *fiber.Ctx) error {
 		data, err := adminUI.ReadFile("admin-ui/index.html")
@@ -694,146 +720,542 @@ func main() {
 		return c.Redirect("/admin-ui/")
 	})
 
+	// Generated media (annotated photos) sent to customers as LINE image messages
+	app.Get("/media/:name", handleGetMedia)
+	app.Get("/quotes/:id", handleGetQuotation)
+
 	adminGroup := app.Group("/admin", adminAuthMiddleware)
 	adminGroup.Get("/config/pricing", handleGetPricingConfig)
 	adminGroup.Put("/config/pricing", handleReplacePricingConfig)
 	adminGroup.Post("/config/pricing/price", handleUpdatePriceEntry)
 	adminGroup.Post("/config/pricing/promotion", handleUpdatePromotionEntry)
+	adminGroup.Post("/config/pricing/import", handleImportPricing)
+	adminGroup.Get("/config/pricing/items", handleListPricingItems)
+	adminGroup.Post("/config/pricing/items", handleCreatePricingItem)
+	adminGroup.Get("/config/pricing/items/:key", handleGetPricingItem)
+	adminGroup.Put("/config/pricing/items/:key", handleReplacePricingItem)
+	adminGroup.Delete("/config/pricing/items/:key", handleDeletePricingItem)
+	adminGroup.Put("/config/pricing/services/:key", handlePutPricingService)
+	adminGroup.Delete("/config/pricing/services/:key", handleDeletePricingService)
+	adminGroup.Post("/customers/import", handleImportCustomers)
+	adminGroup.Get("/customers/imported", handleGetImportedCustomers)
+	adminGroup.Get("/members/lookup", handleLookupMember)
+	adminGroup.Get("/config/run-params", handleGetRunParams)
+	adminGroup.Put("/config/run-params", handleReplaceRunParams)
+	adminGroup.Get("/config/experiment", handleGetInstructionExperiment)
+	adminGroup.Put("/config/experiment", handleReplaceInstructionExperiment)
+	adminGroup.Get("/channels", handleGetChannels)
+	adminGroup.Get("/config/greeting", handleGetGreeting)
+	adminGroup.Put("/config/greeting", handleReplaceGreeting)
+	adminGroup.Get("/config/sticker-replies", handleGetStickerConfig)
+	adminGroup.Put("/config/sticker-replies", handleReplaceStickerConfig)
+	adminGroup.Get("/config/conversation-modes", handleGetConversationModes)
+	adminGroup.Put("/config/conversation-modes", handleReplaceConversationModes)
+	adminGroup.Get("/config/service-comparison", handleGetServiceComparison)
+	adminGroup.Put("/config/service-comparison", handleReplaceServiceComparison)
+	adminGroup.Get("/config/keyword-triggers", handleGetKeywordTriggers)
+	adminGroup.Put("/config/keyword-triggers", handleReplaceKeywordTriggers)
+	adminGroup.Get("/config/faq", handleGetFAQ)
+	adminGroup.Put("/config/faq", handleReplaceFAQ)
+	adminGroup.Post("/config/faq/match", handleMatchFAQ)
 
 	adminGroup.Get("/conversations", handleGetConversations)
+	adminGroup.Get("/conversations/live", handleGetLiveConversations)
 	adminGroup.Get("/conversations/:userId", handleGetConversationMessages)
+	adminGroup.Get("/conversations/:userId/pending", handleGetPendingMessages)
+	adminGroup.Get("/conversations/:userId/log", handleGetConversationLog)
 	adminGroup.Post("/conversations/:userId/takeover", handleTakeoverConversation)
 	adminGroup.Post("/conversations/:userId/release", handleReleaseConversation)
 	adminGroup.Post("/conversations/:userId/reply", handleAdminReply)
 	adminGroup.Post("/conversations/:userId/nickname", handleSetNickname)
+	adminGroup.Post("/conversations/:userId/profile", handleUpdateProfile)
+	adminGroup.Get("/archive/conversations", handleGetArchivedConversations)
+	adminGroup.Post("/archive/conversations/:userId/restore", handleRestoreArchivedConversation)
+	adminGroup.Post("/archive/run", handleRunArchival)
+	adminGroup.Post("/context-reset/run", handleRunContextReset)
+	adminGroup.Get("/config/retention", handleGetRetentionPolicy)
+	adminGroup.Put("/config/retention", handleReplaceRetentionPolicy)
+	adminGroup.Post("/retention/run", handleRunRetention)
+	adminGroup.Get("/retention/report", handleGetRetentionReport)
+
+	adminGroup.Get("/segments", handleGetSegments)
+	adminGroup.Get("/segments/:id", handleGetSegmentMembers)
+	adminGroup.Post("/broadcasts", handleSendBroadcast)
+
+	adminGroup.Get("/campaign-codes", handleGetCampaignCodes)
+	adminGroup.Put("/campaign-codes", handleReplaceCampaignCodes)
+	adminGroup.Get("/analytics/sources", handleGetSourceAnalytics)
+	adminGroup.Get("/analytics/nps", handleGetNPSAnalytics)
+	adminGroup.Get("/analytics/experiment", handleGetExperimentReport)
+
+	adminGroup.Get("/metrics", handleGetMetrics)
+	adminGroup.Get("/usage", handleGetUsage)
+	adminGroup.Get("/debug/logging", handleGetLogControls)
+	adminGroup.Put("/debug/logging", handleReplaceLogControls)
+	adminGroup.Get("/debug/payloads", handleGetCapturedPayloads)
+	adminGroup.Get("/line-quota", handleGetLineQuota)
+
+	adminGroup.Get("/callbacks", handleGetCallbackTasks)
+	adminGroup.Post("/callbacks/:id/done", handleCompleteCallbackTask)
+
+	adminGroup.Get("/bookings", handleGetBookings)
+	adminGroup.Post("/bookings", handleCreateBooking)
+	adminGroup.Put("/bookings/:id", handleUpdateBooking)
+
+	adminGroup.Get("/payments", handleGetPayments)
+	adminGroup.Get("/quotes", handleGetQuotations)
+	adminGroup.Post("/payments/:id/paid", handleMarkPaymentPaid)
+	adminGroup.Get("/payment-slips", handleGetPaymentSlips)
+	adminGroup.Get("/gift-vouchers", handleGetGiftVouchers)
+
+	adminGroup.Get("/contracts", handleGetContracts)
+	adminGroup.Post("/contracts", handleCreateContract)
+	adminGroup.Post("/contracts/:id/renew", handleRenewContract)
+
+	adminGroup.Get("/outbound-webhooks", handleGetOutboundWebhooks)
+	adminGroup.Put("/outbound-webhooks", handleReplaceOutboundWebhooks)
+	adminGroup.Get("/outbound-deliveries", handleGetOutboundDeliveries)
+	adminGroup.Post("/outbound-deliveries/:id/retry", handleRetryOutboundDelivery)
+
+	adminGroup.Post("/simulate", handleSimulate)
+
+	adminGroup.Get("/reconciliation", handleGetReconciliation)
+	adminGroup.Post("/reconciliation/run", handleRunReconciliation)
+
+	adminGroup.Get("/config/slot-template", handleGetSlotTemplate)
+	adminGroup.Put("/config/slot-template", handleReplaceSlotTemplate)
+	adminGroup.Post("/slots/seed", handleSeedSlotMonth)
+
+	adminGroup.Get("/branches", handleGetBranches)
+	adminGroup.Put("/branches", handleReplaceBranches)
+
+	adminGroup.Get("/service-areas", handleGetServiceAreas)
+	adminGroup.Put("/service-areas", handleReplaceServiceAreas)
+	adminGroup.Get("/service-areas/check", handleCheckServiceArea)
+	adminGroup.Put("/service-areas/:id", handleUpsertServiceArea)
+	adminGroup.Delete("/service-areas/:id", handleDeleteServiceArea)
+
+	app.Post("/openai/webhook", handleOpenAIWebhook)
 
 	app.Post("/webhook", func(c *fiber.Ctx) error {
+		requestID := newLogID()
+		reqLog := slog.With("request_id", requestID)
+		_, webhookSpan := startSpan(context.Background(), "line.webhook", spanServer, "request_id", requestID)
+		defer webhookSpan.End(nil)
 		var event LineEvent
 		if err := json.Unmarshal(c.Body(), &event); err != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
+		// the destination only picks the secret; nothing is trusted before the check
+		secret, known := lineChannelSecret(event.Destination)
+		if !known {
+			reqLog.Warn("Rejected /webhook request for a destination not in channels.json", "destination", event.Destination, "ip", c.IP())
+			appMetrics.inc("webhook_destination_rejected")
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		if !webhookSignatureOK(secret, c.Get("X-Line-Signature"), c.Body()) {
+			reqLog.Warn("Rejected /webhook request with missing or invalid signature", "ip", c.IP())
+			appMetrics.inc("webhook_signature_rejected")
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		// only signed bodies are kept, so forged requests can't fill the capture
+		captureWebhookPayload(c.Body())
+		channel, hasChannel := channelForDestination(event.Destination)
 		for _, e := range event.Events {
+			if e.DeliveryContext.IsRedelivery {
+				appMetrics.inc("webhook_redeliveries")
+			}
+			if !markWebhookEvent(e.WebhookEventID) {
+				reqLog.Info("Skipping webhook event already processed", "event_id", e.WebhookEventID, "redelivery", e.DeliveryContext.IsRedelivery)
+				appMetrics.inc("webhook_duplicates_skipped")
+				continue
+			}
+			if hasChannel {
+				setUserChannel(e.Source.conversationID(), channel.ID)
+			}
+			if e.Type == "postback" {
+				handlePostback(e.Source.conversationID(), e.ReplyToken, e.Postback.Data)
+				continue
+			}
 			if e.Type == "message" {
-				userId := e.Source.UserID
+				// groups and rooms are one conversation each, keyed by their ID
+				userId := e.Source.conversationID()
+				if isGroupChat(userId) {
+					if e.Message.Type != "text" {
+						continue
+					}
+					text, addressed := addressedGroupText(e.Message.Text, e.Message.Mention.Mentionees)
+					if !addressed {
+						continue // group chatter not meant for the bot
+					}
+					e.Message.Text = text
+					appMetrics.inc("group_messages_answered")
+				}
 				var messageContent string
+				var imageURL string
+				var sentiment string // stickers and emoji-only text, see sticker_messages.go
 
 				if e.Message.Type == "text" {
 					messageContent = e.Message.Text
+					sentiment = emojiSentiment(messageContent)
 				} else if e.Message.Type == "image" {
 					// Handle image message
 					log.Printf("Processing image message with ID: %s", e.Message.ID)
-					imageURL, err := getLineImageURL(e.Message.ID)
+					var err error
+					imageURL, err = getLineImageURL(userId, e.Message.ID)
 					if err != nil {
 						log.Printf("Error getting image URL for message ID %s: %v", e.Message.ID, err)
 						messageContent = "ได้รับรูปภาพจากลูกค้า (ไม่สามารถแสดงได้)"
 					} else {
-						log.Printf("Successfully converted image to data URL. Length: %d", len(imageURL))
+						debugf(userId, "Successfully converted image to data URL. Length: %d", len(imageURL))
 						messageContent = "ลูกค้าส่งรูปภาพ: " + imageURL
+						userThreadLock.Lock()
+						rememberCustomerImage(userId, imageURL)
+						userThreadLock.Unlock()
 						log.Printf("Image message content prepared: ลูกค้าส่งรูปภาพ: [DATA_URL]")
 					}
+				} else if e.Message.Type == "file" || e.Message.Type == "audio" {
+					// Documents (condo permits, floor plans) are summarized and voice notes
+					// transcribed after the webhook has answered; see routeMediaMessage
+					log.Printf("Processing %s message %s", e.Message.Type, e.Message.ID)
+					msg := inboundMessage{
+						UserID: userId, ReplyToken: e.ReplyToken, Type: e.Message.Type,
+						RequestID: requestID, TraceParent: webhookSpan.TraceParent(),
+					}
+					go routeMediaMessage(reqLog, msg, e.Message.ID, e.Message.FileName, e.Message.Duration)
+					continue
+				} else if e.Message.Type == "sticker" {
+					sentiment = stickerSentiment(e.Message.PackageID, e.Message.StickerID, e.Message.Keywords)
+					messageContent = stickerMessageText(sentiment, e.Message.Text, e.Message.Keywords)
+				} else if e.Message.Type == "location" {
+					messageContent = locationMessageText(e.Message.Title, e.Message.Address, e.Message.Latitude, e.Message.Longitude)
 				} else {
 					// Skip other message types
 					continue
 				}
 
-				userThreadLock.Lock()
-				userMsgBuffer[userId] = append(userMsgBuffer[userId], messageContent)
-
-				// Record customer message in conversation history
-				isNewUser := false
-				if _, ok := userConversations[userId]; !ok {
-					userConversations[userId] = &UserConversation{UserID: userId}
-					isNewUser = true
-				}
-				{
-					conv := userConversations[userId]
-					conv.LastSeen = getBangkokTime()
-					if detectHumanRequest(messageContent) || detectAdminAlert(messageContent) {
-						conv.WantsHuman = true
-						conv.Takeover = true              // Stop AI immediately
-						conv.LastAdminAction = time.Now() // Start 30-min inactivity clock
-					}
-					displayMsg := messageContent
-					if strings.Contains(messageContent, "data:image") {
-						displayMsg = "[รูปภาพ]"
-					}
-					conv.appendMessage("customer", displayMsg)
-				}
-
-				if isNewUser {
-					go fetchAndStoreLineDisplayName(userId)
-				}
-				go saveConversations()
-				// Stop existing timer if any
-				if timer, ok := userMsgTimer[userId]; ok {
-					timer.Stop()
+				msg := inboundMessage{
+					UserID: userId, ReplyToken: e.ReplyToken, Type: e.Message.Type,
+					Content: messageContent, ImageURL: imageURL, Sentiment: sentiment,
+					Address: e.Message.Address, Latitude: e.Message.Latitude, Longitude: e.Message.Longitude,
+					RequestID: requestID, TraceParent: webhookSpan.TraceParent(),
 				}
+				routeInboundMessage(reqLog, msg)
+			}
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
 
-				// Capture replyToken to avoid closure issues
-				replyToken := e.ReplyToken
+	log.Fatal(app.Listen(":" + appConfig.Port))
+}
 
-				// Set new timer for 15 seconds
-				t := time.AfterFunc(15*time.Second, func() {
-					userThreadLock.Lock()
-					msgs := userMsgBuffer[userId]
-					userMsgBuffer[userId] = nil
-					delete(userMsgTimer, userId) // Clean up timer reference
-					userThreadLock.Unlock()
+// inboundMessage is a customer message from the webhook, turned into conversation text.
+type inboundMessage struct {
+	UserID      string
+	ReplyToken  string
+	Type        string // LINE message type
+	Content     string // text that goes into the conversation
+	ImageURL    string // data URL of an image message
+	Sentiment   string // stickers and emoji-only text, see sticker_messages.go
+	Address     string // location messages
+	Latitude    float64
+	Longitude   float64
+	RequestID   string
+	TraceParent string // span of the /webhook request
+}
 
-					if len(msgs) == 0 {
-						log.Printf("No messages to process for user %s", userId)
-						return
-					}
+// routeInboundMessage records the message, answers it directly when a fast path applies
+// and otherwise buffers it for the next assistant turn.
+func routeInboundMessage(reqLog *slog.Logger, m inboundMessage) {
+	userId, replyToken, messageContent, imageURL := m.UserID, m.ReplyToken, m.Content, m.ImageURL
+	isNewUser := recordInboundMessage(userId, messageContent)
+	if imageURL != "" && !isNewUser && takePossibleSlip(userId, messageContent) {
+		go checkPaymentSlip(userId, replyToken, imageURL, messageContent)
+		return // a transfer slip is checked before the assistant sees the photo
+	}
+	if m.Type == "text" && answerQueueCancel(userId, replyToken, messageContent) {
+		return // left the run queue; nothing for the assistant
+	}
+	if m.Type == "location" {
+		rememberSharedLocation(userId, m.Address, m.Latitude, m.Longitude)
+	}
+	if m.Type == "location" && answerLocation(userId, replyToken, messageContent, m.Address, m.Latitude, m.Longitude) {
+		return // service-area check answered; the location stays in the history
+	}
+	if m.Sentiment != "" && answerSentiment(userId, replyToken, m.Sentiment, messageContent, isNewUser) {
+		return // greeting or thanks answered with a canned reply
+	}
+	if m.Type == "text" && answerKeywordTrigger(userId, replyToken, messageContent) {
+		return // answered instantly; nothing left for the assistant
+	}
+	if m.Type == "text" && !isNewUser && answerPricingClarification(userId, replyToken, messageContent) {
+		return // asked for the missing size or service
+	}
+	if isNewUser && sendFirstTimeGreeting(userId, replyToken) {
+		replyToken = "" // used by the greeting; the answer will be pushed
+	}
 
-					var summary string
-					if len(msgs) == 1 {
-						summary = msgs[0]
-						log.Printf("Single message from user %s: %s", userId, summary)
-					} else {
-						summary = fmt.Sprintf("สรุปคำถาม %d ข้อความจากลูกค้า: %v", len(msgs), msgs)
-						log.Printf("Multiple messages (%d) from user %s: %v", len(msgs), userId, msgs)
-					}
+	userThreadLock.Lock()
+	userRequestIDs[userId] = m.RequestID
+	userTraceParents[userId] = m.TraceParent
+	// Stop existing timer if any
+	if timer, ok := userMsgTimer[userId]; ok {
+		timer.Stop()
+	}
+	if bufferAtLimit(userMsgBuffer[userId]) {
+		// don't let a burst of messages grow into one huge turn
+		delete(userMsgTimer, userId)
+		userThreadLock.Unlock()
+		reqLog.Info("Buffer cap reached; flushing early", "user_id", userId)
+		appMetrics.inc("buffer_cap_flushes")
+		if replyToken != "" {
+			deliverReply(userId, replyToken, bufferLimitAck, nil)
+		}
+		go flushUserBuffer(userId, "")
+		return
+	}
 
-					// Check if human takeover is active - skip AI if so
-					userThreadLock.Lock()
-					takeoverActive := userConversations[userId] != nil && userConversations[userId].Takeover
-					userThreadLock.Unlock()
-					if takeoverActive {
-						log.Printf("Human takeover active for user %s, skipping AI response", userId)
-						return
-					}
+	delay := nextBufferDelayLocked(userId, messageContent)
+	if delay == 0 {
+		// a complete question; no point waiting for more
+		delete(userMsgTimer, userId)
+		userThreadLock.Unlock()
+		reqLog.Info("Message looks complete; answering now", "user_id", userId)
+		go flushUserBuffer(userId, replyToken)
+		return
+	}
+	t := time.AfterFunc(delay, func() {
+		flushUserBuffer(userId, replyToken)
+	})
 
-					responseText := getAssistantResponse(userId, summary)
-					replyToLine(replyToken, responseText)
+	userMsgTimer[userId] = t
+	buffered := len(userMsgBuffer[userId])
+	userThreadLock.Unlock()
 
-					// Record AI response in conversation history
-					if responseText != "" {
-						userThreadLock.Lock()
-						if conv, ok := userConversations[userId]; ok {
-							conv.appendMessage("ai", responseText)
-						}
-						userThreadLock.Unlock()
-						go saveConversations()
-					}
-				})
+	reqLog.Info("Message buffered", "user_id", userId, "buffered", buffered, "delay", delay.String())
+}
 
-				userMsgTimer[userId] = t
-				userThreadLock.Unlock()
+// recordInboundMessage buffers a customer message for the next assistant turn and applies
+// the per-message routing (urgency, campaign codes, human requests) to the conversation.
+// It reports whether this was the customer's first message.
+func recordInboundMessage(userId, messageContent string) bool {
+	debugf(userId, "Inbound message from %s: %s", userId, logContent(truncateRunes(messageContent, 300)))
+	userThreadLock.Lock()
+	userMsgBuffer[userId] = append(userMsgBuffer[userId], messageContent)
 
-				log.Printf("Message buffered for user %s (total: %d messages). Timer set for 15 seconds.", userId, len(userMsgBuffer[userId]))
+	// Record customer message in conversation history
+	isNewUser := false
+	if _, ok := userConversations[userId]; !ok {
+		userConversations[userId] = &UserConversation{UserID: userId}
+		isNewUser = true
+	}
+	// imported customers already have a profile but no LINE name yet
+	needsName := isNewUser || (len(userConversations[userId].Messages) == 0 && userConversations[userId].DisplayName == "")
+	{
+		conv := userConversations[userId]
+		conv.LastSeen = getBangkokTime()
+		noteChannelLocked(conv)
+		normalized := normalizeInboundText(messageContent)
+		if !strings.Contains(messageContent, "data:image") {
+			routeUrgency(conv, classifyUrgency(normalized), messageContent)
+			if code, cc, ok := matchCampaignCode(normalized); ok {
+				attributeConversation(conv, cc.Source, cc.Campaign, code)
 			}
+			if b, ok := branchForText(normalized); ok {
+				assignBranchLocked(conv, b)
+			}
+			routeConversationMode(conv, normalized)
 		}
-		return c.SendStatus(fiber.StatusOK)
-	})
+		if detectHumanRequest(normalized) || detectAdminAlert(normalized) {
+			if !conv.WantsHuman {
+				go startHandoffSummary(userId, "ลูกค้าขอคุยกับเจ้าหน้าที่")
+			}
+			conv.WantsHuman = true
+			conv.Takeover = true              // Stop AI immediately
+			conv.LastAdminAction = time.Now() // Start 30-min inactivity clock
+		}
+		displayMsg := messageContent
+		if strings.Contains(messageContent, "data:image") {
+			displayMsg = "[รูปภาพ]"
+		}
+		conv.appendMessage("customer", displayMsg)
+	}
+	userThreadLock.Unlock()
+
+	if isNewUser && restoreOnReturn(userId) {
+		isNewUser = false // returning customer whose history was archived
+		needsName = false
+	}
+	if needsName && !isSimulatedUser(userId) {
+		go fetchAndStoreLineDisplayName(userId)
+	}
+	go saveConversations()
+	return isNewUser
+}
+
+// flushUserBuffer takes the user's buffered messages and enqueues them as one assistant turn
+// (see turn_queue.go). Simulated conversations run the turn directly so their captures are
+// ready when this returns.
+func flushUserBuffer(userId, replyToken string) {
+	userThreadLock.Lock()
+	msgs, rest := splitBufferAtLimit(userMsgBuffer[userId])
+	userMsgBuffer[userId] = rest
+	delete(userMsgTimer, userId) // Clean up timer reference
+	waited, timed := takeBufferWaitLocked(userId)
+	requestID := userRequestIDs[userId]
+	delete(userRequestIDs, userId)
+	traceParent := userTraceParents[userId]
+	delete(userTraceParents, userId)
+	held := userHeldTurnJobs[userId] // their messages are at the front of msgs
+	delete(userHeldTurnJobs, userId)
+	userThreadLock.Unlock()
+	if timed {
+		appMetrics.observe("buffer_wait", waited)
+	}
+
+	if len(msgs) == 0 {
+		slog.Info("No messages to process", "user_id", userId, "request_id", requestID)
+		completeTurn(held...)
+		return
+	}
+	_, flushSpan := startSpan(withTraceParent(context.Background(), traceParent), "buffer.flush", spanInternal,
+		"user_id", userId, "request_id", requestID, "messages", len(msgs), "held_back", len(rest))
+	flushSpan.End(nil)
+	job := &TurnJob{
+		ID:          newLogID(),
+		UserID:      userId,
+		ReplyToken:  replyToken,
+		Messages:    msgs,
+		RequestID:   requestID,
+		TraceParent: flushSpan.TraceParent(),
+		EnqueuedAt:  time.Now(),
+		HeldBack:    len(rest),
+		replaces:    held,
+	}
+	if isSimulatedUser(userId) {
+		runTurn(job)
+		return
+	}
+	enqueueTurn(job)
+}
+
+// runTurn sends a job's messages to the assistant as one turn and replies.
+func runTurn(job *TurnJob) {
+	userId, replyToken, msgs := job.UserID, job.ReplyToken, job.Messages
+	logger := slog.With("user_id", userId, "request_id", job.RequestID, "run_id", job.ID)
+	if job.HeldBack > 0 {
+		// over the buffer caps: the rest gets its own turn once this one has replied
+		logger.Info("Buffer over its caps; messages held for the next turn", "held", job.HeldBack)
+		appMetrics.add("buffer_messages_deferred", int64(job.HeldBack))
+		defer func() { go flushUserBuffer(userId, "") }()
+	}
+	started := time.Now()
+
+	// Check if human takeover is active - skip AI if so
+	userThreadLock.Lock()
+	takeoverActive := userConversations[userId] != nil && userConversations[userId].Takeover
+	userThreadLock.Unlock()
+	if takeoverActive {
+		logger.Info("Human takeover active, skipping AI response")
+		return
+	}
+
+	// A run for an earlier flush may still be in flight: queue behind it or cancel and merge
+	ctx, run, msgs, queued := beginInflightRun(job, msgs)
+	if queued {
+		return
+	}
+	defer finishInflightRun(userId, run)
+	ctx = withRunID(withLogger(ctx, logger), job.ID)
+	ctx, turnSpan := startSpan(withTraceParent(ctx, job.TraceParent), "assistant.turn", spanInternal,
+		"user_id", userId, "request_id", job.RequestID, "run_id", job.ID, "conversation.id", userId, "messages", len(msgs))
+	defer turnSpan.End(nil)
+
+	var summary string
+	if len(msgs) == 1 {
+		summary = msgs[0]
+		logger.Info("Single message", "text", logContent(truncateRunes(summary, 300)))
+	} else {
+		// One message per line keeps photos and text in the order they were sent
+		summary = fmt.Sprintf("สรุปคำถาม %d ข้อความจากลูกค้า:\n%s", len(msgs), strings.Join(msgs, "\n"))
+		logger.Info("Multiple messages", "count", len(msgs))
+	}
 
-	log.Fatal(app.Listen(":8080"))
+	// After repeated failures the AI stays paused until staff resolve the callback
+	userThreadLock.Lock()
+	escalated := userConversations[userId] != nil && userConversations[userId].FailureEscalated
+	userThreadLock.Unlock()
+	var responseText string
+	var stats *TurnStats
+	if escalated {
+		responseText = handleEscalatedTurn(userId, summary)
+	} else if reply, blocked := moderateTurn(ctx, userId, msgs); blocked {
+		responseText = reply // no model call for abuse or spam
+	} else {
+		var err error
+		release, ok := acquireRunSlot(ctx, userId)
+		if !ok {
+			logger.Info("Assistant run dropped while waiting for a run slot")
+			return
+		}
+		defer release()
+		stats = &TurnStats{Path: "assistant"}
+		responseText, replyToken, err = getAssistantResponseWithinBudget(withTurnStats(ctx, stats), run, userId, replyToken, summary)
+		if ctx.Err() != nil {
+			logger.Info("Assistant run was cancelled by newer input; dropping its reply")
+			takeReplyAttachments(userId)
+			recordConversationEvent(ConversationEvent{UserID: userId, RunID: job.ID, Kind: "run", Status: "cancelled"})
+			return
+		}
+		if err != nil {
+			logger.Log(ctx, errorLogLevel(err), "Assistant turn failed", "kind", errorKind(err), "error", err)
+			appMetrics.inc("assistant_errors_" + errorKind(err))
+			responseText = assistantErrorMessage(err, customerLanguage(userId, ""))
+			stats.Error = errorKind(err)
+			turnSpan.SetError(err)
+		}
+		if recordAssistantOutcome(userId, err != nil) {
+			responseText = failureApologyMessage
+		}
+	}
+	var quickReplies []QuickReplyOption
+	if !escalated && stats != nil && stats.Error == "" {
+		quickReplies = assistantQuickReplies(userId, summary, responseText)
+	}
+	method := "push"
+	if replyToken != "" {
+		method = "reply"
+	}
+	_, replySpan := startSpan(ctx, "line.reply", spanClient, "line.method", method)
+	deliverReply(userId, replyToken, responseText, quickReplies, takeReplyAttachments(userId)...)
+	replySpan.End(nil)
+	if stats != nil {
+		go maybeSummarizeContext(userId)
+		finishTurnStats(stats, started)
+		status := "ok"
+		if stats.Error != "" {
+			status = stats.Error
+		}
+		details, _ := json.Marshal(stats)
+		recordConversationEvent(ConversationEvent{UserID: userId, RunID: job.ID, Kind: "run", Status: status, Content: string(details)})
+	}
+
+	// Record AI response in conversation history
+	if responseText != "" {
+		userThreadLock.Lock()
+		if conv, ok := userConversations[userId]; ok {
+			conv.appendTurn(responseText, stats)
+		}
+		userThreadLock.Unlock()
+		go saveConversations()
+	}
 }
 
 // getLineImageURL gets the image URL from LINE and converts it to a base64 data URL for GPT vision
-func getLineImageURL(messageID string) (string, error) {
+func getLineImageURL(userId, messageID string) (string, error) {
 	log.Printf("Starting image download for message ID: %s", messageID)
 
-	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	channelToken := lineAccessToken(userId)
 	if channelToken == "" {
 		log.Printf("ERROR: LINE_CHANNEL_ACCESS_TOKEN not set")
 		return "", fmt.Errorf("LINE channel access token not set")
@@ -851,7 +1273,7 @@ func getLineImageURL(messageID string) (string, error) {
 	}
 	req.Header.Set("Authorization", "Bearer "+channelToken)
 
-	client := &http.Client{}
+	client := &http.Client{Transport: lineTransport}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("ERROR: Failed to download image: %v", err)
@@ -903,21 +1325,52 @@ func getLineImageURL(messageID string) (string, error) {
 		return "", fmt.Errorf("รูปภาพมีขนาดใหญ่เกินไป กรุณาลดขนาดรูปภาพแล้วลองใหม่อีกครั้ง")
 	}
 
-	log.Printf("✅ Successfully created data URL. Length: %d characters", len(dataURL))
+	debugf("", "✅ Successfully created data URL. Length: %d characters", len(dataURL))
 
 	return dataURL, nil
 }
 
-// extractFirstDataURL finds the first valid image data URL in a string and returns it exactly
-func extractFirstDataURL(s string) (string, error) {
-	// Match data:image/<type>;base64,<payload>
-	// base64 payload restricted to valid chars only to avoid trailing list/bracket artifacts
-	re := regexp.MustCompile(`data:image/[a-zA-Z0-9.+-]+;base64,[A-Za-z0-9+/=]+`)
-	loc := re.FindStringIndex(s)
-	if loc == nil {
-		return "", fmt.Errorf("no image data URL found")
+// imageDataURLPattern matches data:image/<type>;base64,<payload>. The payload is restricted
+// to valid base64 chars so trailing list/bracket artifacts are not included.
+var imageDataURLPattern = regexp.MustCompile(`data:image/[a-zA-Z0-9.+-]+;base64,[A-Za-z0-9+/=]+`)
+
+// turnContent builds the current user turn. When a flush holds photos, the photos and any
+// text sent with them become one multimodal message with the parts in the order received,
+// so a question like "อันนี้ซักได้ไหม ราคาเท่าไหร่" is answered about the photo it refers to.
+func turnContent(timeStr, message string) interface{} {
+	locs := imageDataURLPattern.FindAllStringIndex(message, -1)
+	if len(locs) == 0 {
+		if strings.Contains(message, "data:image") {
+			return fmt.Sprintf("ขณะนี้เวลา %s: ลูกค้าส่งรูปภาพมา (ไม่สามารถแสดงได้)", timeStr)
+		}
+		return fmt.Sprintf("ขณะนี้เวลา %s: %s", timeStr, message)
+	}
+
+	var parts []interface{}
+	hasText := false
+	addText := func(text string) {
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "ลูกค้าส่งรูปภาพ:"))
+		if text == "" {
+			return
+		}
+		if !strings.HasPrefix(text, "สรุปคำถาม") {
+			hasText = true
+		}
+		parts = append(parts, map[string]interface{}{"type": "input_text", "text": text})
+	}
+	prev := 0
+	for _, loc := range locs {
+		addText(message[prev:loc[0]])
+		parts = append(parts, map[string]interface{}{"type": "input_image", "image_url": message[loc[0]:loc[1]]})
+		prev = loc[1]
+	}
+	addText(message[prev:])
+
+	header := fmt.Sprintf("ขณะนี้เวลา %s: ลูกค้าส่งรูปภาพมา กรุณาวิเคราะห์รูปภาพและให้คำแนะนำเกี่ยวกับบริการทำความสะอาดที่เหมาะสม", timeStr)
+	if hasText {
+		header = fmt.Sprintf("ขณะนี้เวลา %s: ลูกค้าส่งรูปภาพพร้อมข้อความตามลำดับด้านล่าง กรุณาวิเคราะห์รูปภาพและตอบคำถามในข้อความโดยอ้างอิงรูปภาพที่เกี่ยวข้อง", timeStr)
 	}
-	return s[loc[0]:loc[1]], nil
+	return append([]interface{}{map[string]interface{}{"type": "input_text", "text": header}}, parts...)
 }
 
 // loadSystemInstructions reads gpt_instructions.md into the systemInstructions global.
@@ -951,7 +1404,15 @@ func loadToolDefinitions() error {
 		return fmt.Errorf("failed to parse gpt_functions.json: %v", err)
 	}
 	toolDefinitions = make([]ToolDefinition, 0, len(src))
+	toolSchemas = make(map[string]toolSchema, len(src))
 	for _, item := range src {
+		var schema toolSchema
+		if len(item.Function.Parameters) > 0 {
+			if err := json.Unmarshal(item.Function.Parameters, &schema); err != nil {
+				return fmt.Errorf("failed to parse parameters of %s: %v", item.Function.Name, err)
+			}
+		}
+		toolSchemas[item.Function.Name] = schema
 		toolDefinitions = append(toolDefinitions, ToolDefinition{
 			Type:        "function",
 			Name:        item.Function.Name,
@@ -967,6 +1428,9 @@ func loadToolDefinitions() error {
 func flagSchedulingFallback(userId string) string {
 	userThreadLock.Lock()
 	if conv, ok := userConversations[userId]; ok {
+		if !conv.WantsHuman {
+			go startHandoffSummary(userId, "ระบบตารางนัดหมายขัดข้อง")
+		}
 		conv.WantsHuman = true
 	}
 	userThreadLock.Unlock()
@@ -975,10 +1439,34 @@ func flagSchedulingFallback(userId string) string {
 }
 
 // dispatchFunctionCall executes the named function with the given JSON arguments.
-func dispatchFunctionCall(name string, arguments json.RawMessage, userId string) string {
-	log.Printf("Dispatching function call: %s args: %s", name, string(arguments))
+// result is always sent back to the model; err classifies failures as *ToolError or *UpstreamError.
+func dispatchFunctionCall(name string, arguments json.RawMessage, userId string) (result string, err error) {
+	debugf(userId, "Dispatching function call: %s args: %s", name, logContent(string(arguments)))
+
+	repaired, argErr := prepareToolArguments(name, arguments, userId)
+	if argErr != nil {
+		return "Invalid arguments: " + argErr.Error() + ". Fix the arguments or ask the customer for the missing details, then call the function again.", &ToolError{Tool: name, Err: argErr}
+	}
+	arguments = repaired
+
+	// State-changing tools run only on the second, token-confirmed call
+	if confirmationRequiredTools[name] {
+		proceed, token, message := gateToolConfirmation(userId, name, arguments)
+		if !proceed {
+			return message, nil
+		}
+		defer func() { completeToolConfirmation(token, result) }()
+	}
+	if isSimulatedUser(userId) && simulatedNoOpTools[name] {
+		log.Printf("Skipping %s for simulated user %s", name, userId)
+		return simulatedToolResult(name), nil
+	}
 
 	// unmarshalArgs tries direct then double-unmarshal (some models wrap args as a JSON string)
+	toolErr := func(prefix string, cause error) (string, error) {
+		return prefix + cause.Error(), &ToolError{Tool: name, Err: cause}
+	}
+
 	unmarshalArgs := func(dest interface{}) error {
 		if err := json.Unmarshal(arguments, dest); err == nil {
 			return nil
@@ -994,25 +1482,24 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 	case "get_available_slots_with_months":
 		var args struct {
 			ThaiMonthYear string `json:"thai_month_year"`
+			Language      string `json:"language,omitempty"`
 		}
 		if err := unmarshalArgs(&args); err != nil || args.ThaiMonthYear == "" {
-			return "ไม่พบเดือนที่ระบุ"
+			return "ไม่พบเดือนที่ระบุ", &ToolError{Tool: name, Err: errors.New("thai_month_year is required")}
 		}
-		gsUrl := "https://script.google.com/macros/s/AKfycbwfSkwsgO56UdPHqa-KCxO7N-UDzkiMIBVjBTd0k8sowLtm7wORC-lN32IjAwtOVqMxQw/exec?sheet=" + url.QueryEscape(args.ThaiMonthYear)
-		resp, err := http.Get(gsUrl)
-		if err != nil {
-			log.Printf("Error calling scheduling API: %v", err)
-			return flagSchedulingFallback(userId)
+		args.ThaiMonthYear = slotMonth(args.ThaiMonthYear)
+		availability, err := slotProviderFor(userId).MonthSlots(context.Background(), args.ThaiMonthYear)
+		var formatErr *SlotFormatError
+		if errors.As(err, &formatErr) {
+			// unknown shape: let the model read the raw data rather than lose it
+			log.Printf("Slot data for %s not formatted: %v", args.ThaiMonthYear, err)
+			appMetrics.inc("slot_format_fallbacks")
+			return formatErr.Body, nil
 		}
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		bodyStr := strings.TrimSpace(string(body))
-		// If response is empty or clearly indicates no data, flag for admin
-		if bodyStr == "" || bodyStr == "[]" || bodyStr == "{}" || len(bodyStr) < 20 {
-			log.Printf("Slot API returned no data for %s, flagging for admin", args.ThaiMonthYear)
-			return flagSchedulingFallback(userId)
+		if err != nil {
+			return flagSchedulingFallback(userId), err
 		}
-		return bodyStr
+		return formatSlotsResult(userId, availability, args.Language), nil
 
 	case "get_ncs_pricing":
 		var args struct {
@@ -1022,20 +1509,56 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 			CustomerType string `json:"customer_type,omitempty"`
 			PackageType  string `json:"package_type,omitempty"`
 			Quantity     int    `json:"quantity,omitempty"`
+			VoucherCode  string `json:"voucher_code,omitempty"`
+			PromoCode    string `json:"promo_code,omitempty"`
 		}
 		if err := unmarshalArgs(&args); err != nil {
-			return "Error parsing pricing arguments: " + err.Error()
+			return toolErr("Error parsing pricing arguments: ", err)
 		}
 		if args.CustomerType == "" {
 			args.CustomerType = "new"
 		}
+		// Members always get member pricing, whatever the model inferred
+		var memberNote string
+		args.CustomerType, memberNote = verifiedCustomerType(userId, args.CustomerType)
 		if args.PackageType == "" {
 			args.PackageType = "regular"
 		}
 		if args.Quantity == 0 {
 			args.Quantity = 1
 		}
-		return getNCSPricing(args.ServiceType, args.ItemType, args.Size, args.CustomerType, args.PackageType, args.Quantity)
+		engine := pricingEngineFor(userId)
+		quote, err := getNCSPricing(engine, args.ServiceType, args.ItemType, args.Size, args.CustomerType, args.PackageType, args.Quantity, args.PromoCode)
+		if err != nil {
+			// the apology tells the model what to ask for or to refer to staff
+			return quote, &ToolError{Tool: name, Err: err}
+		}
+		recordQuoteIssued(userId)
+		rememberPricingContext(userId, engine, args.ServiceType, args.ItemType, args.Size)
+		// cardNotes are the lines the customer may see on the quote card; the contract and
+		// membership notes are instructions for the model and stay out of it
+		var cardNotes strings.Builder
+		if b, ok := customerBranch(userId); ok && b.PriceAdjustPercent != 0 {
+			cardNotes.WriteString("\n📍 ราคาสำหรับพื้นที่สาขา" + b.Name)
+		}
+		if isUrgentConversation(userId) {
+			cardNotes.WriteString(urgentSurchargeLine())
+		}
+		cardNotes.WriteString(travelSurchargeLine(userId))
+		if args.VoucherCode != "" {
+			cardNotes.WriteString(giftVoucherQuoteLine(args.VoucherCode))
+		}
+		quote += cardNotes.String()
+		quote += contractQuoteNote(userId, args.ServiceType)
+		quote += memberNote
+		if engine.Config != nil {
+			if item, ok := engine.QuoteItem(pricing.QuoteRequest{ServiceType: args.ServiceType, ItemType: args.ItemType, Size: args.Size, CustomerType: args.CustomerType, PackageType: args.PackageType, PromoCode: args.PromoCode, Today: bangkokNow().Format("2006-01-02")}); ok {
+				queueReplyAttachment(userId, quoteFlex(item, cardNotes.String()))
+				addPartialAnswer(userId, quotePartial(item, cardNotes.String()))
+				quote += quoteCardNote
+			}
+		}
+		return quote, nil
 
 	case "get_action_step_summary":
 		var args struct {
@@ -1045,9 +1568,9 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 			RecommendedService string `json:"recommended_service,omitempty"`
 		}
 		if err := unmarshalArgs(&args); err != nil {
-			return "Error parsing step summary arguments: " + err.Error()
+			return toolErr("Error parsing step summary arguments: ", err)
 		}
-		return getActionStepSummary(args.AnalysisType, args.ItemIdentified, args.ConditionAssessed, args.RecommendedService)
+		return getActionStepSummary(args.AnalysisType, args.ItemIdentified, args.ConditionAssessed, args.RecommendedService), nil
 
 	case "get_image_analysis_guidance":
 		var args struct {
@@ -1055,7 +1578,7 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 			AnalysisRequest string `json:"analysis_request,omitempty"`
 		}
 		_ = unmarshalArgs(&args)
-		return getImageAnalysisGuidance(args.ImageType, args.AnalysisRequest)
+		return getImageAnalysisGuidance(args.ImageType, args.AnalysisRequest), nil
 
 	case "get_workflow_step_instruction":
 		var args struct {
@@ -1065,9 +1588,9 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 			PreviousContext string `json:"previous_context,omitempty"`
 		}
 		if err := unmarshalArgs(&args); err != nil {
-			return "Error parsing workflow step arguments: " + err.Error()
+			return toolErr("Error parsing workflow step arguments: ", err)
 		}
-		return getWorkflowStepInstruction(args.CurrentStep, args.UserMessage, args.ImageAnalysis, args.PreviousContext)
+		return getWorkflowStepInstruction(args.CurrentStep, args.UserMessage, args.ImageAnalysis, args.PreviousContext), nil
 
 	case "get_current_workflow_step":
 		var args struct {
@@ -1076,74 +1599,289 @@ func dispatchFunctionCall(name string, arguments json.RawMessage, userId string)
 			PreviousContext string `json:"previous_context,omitempty"`
 		}
 		if err := unmarshalArgs(&args); err != nil {
-			return "Error parsing current step arguments: " + err.Error()
+			return toolErr("Error parsing current step arguments: ", err)
 		}
 		step := getCurrentWorkflowStep(args.UserMessage, args.ImageAnalysis, args.PreviousContext)
-		return fmt.Sprintf("Current workflow step: %d", step)
-	}
+		return fmt.Sprintf("Current workflow step: %d", step), nil
 
-	return "Unknown function: " + name
-}
+	case "annotate_customer_image":
+		var args struct {
+			ItemLabel    string        `json:"item_label"`
+			ServiceLabel string        `json:"service_label,omitempty"`
+			Box          annotationBox `json:"box"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing annotation arguments: ", err)
+		}
+		return annotateCustomerImage(userId, args.Box, args.ItemLabel, args.ServiceLabel)
 
-// isErrorResponse checks if a response is an error message that shouldn't be cached
-func isErrorResponse(response string) bool {
-	errorKeywords := []string{
-		"Error ",
-		"Failed to ",
-		"not configured",
-		"not set",
-		"Error creating",
-		"Error running",
-		"Error sending",
-		"Error getting",
-		"Error calling",
-		"ขออภัย ระบบมีปัญหา", // Our user-friendly error messages
-		"เกิดข้อผิดพลาด",
-		"ไม่สามารถ",    // Unable to
-		"พบข้อผิดพลาด", // Found error
-	}
+	case "get_my_booking":
+		return describeMyBookings(userId), nil
 
-	for _, keyword := range errorKeywords {
-		if strings.Contains(response, keyword) {
-			return true
+	case "get_membership_info":
+		return getMembershipInfo(userId), nil
+
+	case "check_membership":
+		var args struct {
+			Phone string `json:"phone,omitempty"`
 		}
-	}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing membership check arguments: ", err)
+		}
+		return checkMembership(userId, args.Phone)
+
+	case "purchase_membership":
+		var args struct {
+			FullName string `json:"full_name"`
+			Phone    string `json:"phone"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing membership arguments: ", err)
+		}
+		return purchaseMembership(userId, args.FullName, args.Phone)
+
+	case "purchase_gift_voucher":
+		var args struct {
+			Kind          string `json:"kind"`
+			Amount        int    `json:"amount,omitempty"`
+			PackageType   string `json:"package_type,omitempty"`
+			ServiceType   string `json:"service_type,omitempty"`
+			Quantity      int    `json:"quantity,omitempty"`
+			RecipientName string `json:"recipient_name,omitempty"`
+			Message       string `json:"message,omitempty"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing gift voucher arguments: ", err)
+		}
+		return purchaseGiftVoucher(userId, args.Kind, args.Amount, args.PackageType, args.ServiceType, args.Quantity, args.RecipientName, args.Message)
+
+	case "redeem_gift_voucher":
+		var args struct {
+			Code      string `json:"code"`
+			BookingID string `json:"booking_id"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing voucher redemption arguments: ", err)
+		}
+		return redeemGiftVoucher(userId, args.Code, args.BookingID)
+
+	case "get_my_contracts":
+		return describeMyContracts(userId), nil
+
+	case "export_my_chat_history":
+		return exportMyChatHistory(userId)
+
+	case "update_booking_details":
+		var args struct {
+			BookingID    string `json:"booking_id,omitempty"`
+			Address      string `json:"address,omitempty"`
+			ContactPhone string `json:"contact_phone,omitempty"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing booking change arguments: ", err)
+		}
+		return updateBookingDetails(userId, args.BookingID, args.Address, args.ContactPhone)
+
+	case "cancel_booking":
+		var args struct {
+			BookingID string `json:"booking_id,omitempty"`
+			Reason    string `json:"reason,omitempty"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing booking cancellation arguments: ", err)
+		}
+		return cancelBooking(userId, args.BookingID, args.Reason)
+
+	case "reschedule_booking":
+		var args struct {
+			BookingID string `json:"booking_id,omitempty"`
+			Date      string `json:"date"`
+			TimeSlot  string `json:"time_slot"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing reschedule arguments: ", err)
+		}
+		return rescheduleBooking(userId, args.BookingID, bookingDate(args.Date), args.TimeSlot)
+
+	case "send_quotation":
+		var args struct {
+			Items         []ChatBookingItem `json:"items"`
+			DepositAmount int               `json:"deposit_amount,omitempty"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing quotation arguments: ", err)
+		}
+		return sendQuotation(userId, args.Items, args.DepositAmount)
+
+	case "compare_services":
+		var args struct {
+			ItemType      string   `json:"item_type"`
+			Size          string   `json:"size,omitempty"`
+			ConditionTags []string `json:"condition_tags,omitempty"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing compare_services arguments: ", err)
+		}
+		return compareServices(userId, args.ItemType, args.Size, args.ConditionTags)
+
+	case "create_booking":
+		var args struct {
+			Date          string            `json:"date"`
+			TimeSlot      string            `json:"time_slot"`
+			Address       string            `json:"address,omitempty"`
+			Items         []ChatBookingItem `json:"items"`
+			DepositAmount int               `json:"deposit_amount"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing booking arguments: ", err)
+		}
+		return createBooking(userId, bookingDate(args.Date), args.TimeSlot, args.Address, args.Items, args.DepositAmount)
 
-	// Also check if response is empty or too short to be useful
-	if len(strings.TrimSpace(response)) < 10 {
-		return true
+	case "book_with_contract":
+		var args struct {
+			ContractID string                `json:"contract_id,omitempty"`
+			Date       string                `json:"date"`
+			TimeSlot   string                `json:"time_slot"`
+			Address    string                `json:"address,omitempty"`
+			Items      []ContractBookingItem `json:"items"`
+		}
+		if err := unmarshalArgs(&args); err != nil {
+			return toolErr("Error parsing contract booking arguments: ", err)
+		}
+		return bookWithContract(userId, args.ContractID, bookingDate(args.Date), args.TimeSlot, args.Address, args.Items)
 	}
 
-	return false
-} // getAssistantResponse calls the OpenAI Responses API (stateless) with the full conversation history.
+	return "Unknown function: " + name, &ToolError{Tool: name, Err: errors.New("unknown function")}
+}
+
+// getAssistantResponse calls the model (see LLMProvider) with the full conversation history.
 // It handles tool/function calls in a synchronous loop and returns the final assistant text.
-func getAssistantResponse(userId, message string) string {
-	log.Printf("getAssistantResponse called for user %s, message length: %d", userId, len(message))
+// Failures are returned as *UpstreamError or *TimeoutError; the caller picks the customer-facing text.
+// maxToolIterations caps the model calls of one turn. The last call gets no tools, so a long
+// tool chain still ends in a text reply.
+const maxToolIterations = 12
+
+func getAssistantResponse(ctx context.Context, userId, message string) (string, error) {
+	logger := loggerFrom(ctx)
+	logger.Info("getAssistantResponse called", "message_length", len(message))
+	stats := turnStatsFrom(ctx)
+
+	// Return the cached answer for a repeated question to save costs (see answer_cache.go)
+	if answer, ok := cachedAnswerFor(userId, message); ok {
+		logger.Info("Returning cached answer")
+		stats.Path = "cache"
+		return answer, nil
+	}
+	if answer, ok := cachedVisionAnswer(message); ok {
+		logger.Info("Returning cached answer about the same photo")
+		appMetrics.inc("vision_cache_hits")
+		stats.Path = "cache"
+		return answer, nil
+	}
+	similar, questionVector, ok := lookupSimilarAnswer(ctx, userId, message)
+	if ok {
+		logger.Info("Returning answer to a similar question", "source", similar.Path, "match", similar.Match, "score", similar.Score)
+		stats.Path = similar.Path
+		return similar.Answer, nil
+	}
 
-	// Return cached answer for duplicate questions to save costs
-	userThreadLock.Lock()
-	lastQA, hasLast := userLastQAMap[userId]
-	userThreadLock.Unlock()
-	if hasLast && lastQA.Question == message && lastQA.Answer != "" {
-		if !isErrorResponse(lastQA.Answer) {
-			log.Printf("Returning cached answer for user %s", userId)
-			return lastQA.Answer
-		}
+	provider, err := newLLMProvider(120 * time.Second)
+	if err != nil {
+		return "", err
 	}
+	mode, modeSettings := conversationModeFor(userId)
+	inputItems := assistantInput(userId, message, modeSettings)
+
+	step := runStepFor(message)
+	params := runParamsFor(step)
+	if ch, ok := channelFor(userId); ok && ch.Model != "" {
+		params.Model = ch.Model
+	}
+	logger.Info("Run parameters chosen", "step", step, "mode", mode, "model", params.Model, "backend", provider.Name())
+	stats.Model = params.Model
+	instructions, variant := instructionsFor(userId)
+	stats.Variant = variant
+	var toolErrors int
+
+	// Loop to handle function/tool calls; the turn's context bounds it in time
+	for iteration := 0; iteration < maxToolIterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		logger.Info("Model request", "iteration", iteration)
+		tools := assistantTools(modeSettings.Tools)
+		if iteration == maxToolIterations-1 {
+			tools = nil // last round: answer with what the tools returned so far
+		}
+		llmCtx, llmSpan := startSpan(ctx, "llm.respond", spanClient, "llm.backend", provider.Name(), "llm.model", params.Model, "iteration", iteration)
+		resp, err := provider.Respond(llmCtx, &LLMRequest{
+			Instructions: instructions,
+			Input:        inputItems,
+			Tools:        tools,
+			Params:       params,
+		})
+		if err != nil {
+			llmSpan.End(err)
+			return "", err
+		}
+		llmSpan.SetAttrs("llm.input_tokens", resp.InputTokens, "llm.output_tokens", resp.OutputTokens, "llm.tool_calls", len(resp.Calls))
+		llmSpan.End(nil)
+		if debugEnabled(userId) {
+			logger.Debug("Model response", "body", logContent(string(resp.Body)))
+		}
+		stats.ModelCalls++
+		stats.InputTokens += resp.InputTokens
+		stats.OutputTokens += resp.OutputTokens
+		recordUsage(userId, params.Model, resp.InputTokens, resp.OutputTokens)
+
+		if len(resp.Calls) > 0 {
+			logger.Info("Processing function calls", "count", len(resp.Calls), "iteration", iteration)
+			inputItems = append(inputItems, resp.Echo...)
+			outputs, failed, err := runToolCalls(ctx, userId, provider, resp.Calls)
+			if err != nil {
+				return "", err
+			}
+			toolErrors += failed
+			inputItems = append(inputItems, outputs...)
+			continue
+		}
 
-	apiKey := os.Getenv("CHATGPT_API_KEY")
-	if apiKey == "" {
-		return "ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้งหรือติดต่อเจ้าหน้าที่"
+		if reply := resp.Text; reply != "" {
+			if debugEnabled(userId) {
+				logger.Debug("Assistant reply", "text", logContent(reply))
+			}
+			// A reply built on a failed tool call may be a workaround; don't replay it
+			if toolErrors == 0 {
+				storeCachedAnswer(userId, message, reply, stats.Tools, questionVector)
+				storeVisionAnswer(message, reply)
+			}
+			return reply, nil
+		}
+
+		logger.Warn("No text reply found in output", "iteration", iteration)
+		break
 	}
 
-	// Build input items from stored conversation history (all messages except the current one)
+	return "", &UpstreamError{Service: "openai", Err: errNoAssistantReply}
+}
+
+// assistantInput builds the input items for a turn: the stored history (all messages except
+// the current one), the current message and the developer notes that apply to this turn.
+func assistantInput(userId, message string, modeSettings ConversationMode) []interface{} {
 	var inputItems []interface{}
 	userThreadLock.Lock()
 	conv := userConversations[userId]
 	var historyMsgs []ConversationMessage
-	if conv != nil && len(conv.Messages) > 1 {
-		historyMsgs = make([]ConversationMessage, len(conv.Messages)-1)
-		copy(historyMsgs, conv.Messages[:len(conv.Messages)-1])
+	greeted := false
+	if conv != nil {
+		if msgs := contextMessages(conv); len(msgs) > 1 {
+			historyMsgs = make([]ConversationMessage, len(msgs)-1)
+			copy(historyMsgs, msgs[:len(msgs)-1])
+		}
+		greeted = !conv.GreetedAt.IsZero()
+		if conv.ContextSummary != "" {
+			inputItems = append(inputItems, openai.Message{Role: "developer", Content: contextSummaryNote(conv.ContextSummary)})
+		}
 	}
 	userThreadLock.Unlock()
 
@@ -1154,172 +1892,72 @@ func getAssistantResponse(userId, message string) string {
 	for _, msg := range historyMsgs {
 		switch msg.Role {
 		case "customer":
-			inputItems = append(inputItems, map[string]interface{}{
-				"role":    "user",
-				"content": msg.Text,
-			})
+			inputItems = append(inputItems, openai.Message{Role: "user", Content: msg.original()})
 		case "ai":
-			inputItems = append(inputItems, map[string]interface{}{
-				"role":    "assistant",
-				"content": msg.Text,
-			})
+			greeted = false // the assistant has answered since; the greeting is history
+			inputItems = append(inputItems, openai.Message{Role: "assistant", Content: msg.original()})
 			// "admin" messages are skipped — they are not part of the AI conversation
 		}
 	}
 
-	// Add current user message, with inline image if present
-	timeStr := getBangkokTime()
-	if strings.Contains(message, "ลูกค้าส่งรูปภาพ:") && strings.Contains(message, "data:image") {
-		imageURL, err := extractFirstDataURL(message)
-		if err == nil {
-			inputItems = append(inputItems, map[string]interface{}{
-				"role": "user",
-				"content": []interface{}{
-					map[string]interface{}{
-						"type": "input_text",
-						"text": fmt.Sprintf("ขณะนี้เวลา %s: ลูกค้าส่งรูปภาพมา กรุณาวิเคราะห์รูปภาพและให้คำแนะนำเกี่ยวกับบริการทำความสะอาดที่เหมาะสม", timeStr),
-					},
-					map[string]interface{}{
-						"type":      "input_image",
-						"image_url": imageURL,
-					},
-				},
-			})
-		} else {
-			log.Printf("Failed to extract image URL: %v", err)
-			inputItems = append(inputItems, map[string]interface{}{
-				"role":    "user",
-				"content": fmt.Sprintf("ขณะนี้เวลา %s: ลูกค้าส่งรูปภาพมา (ไม่สามารถแสดงได้)", timeStr),
-			})
-		}
-	} else {
-		inputItems = append(inputItems, map[string]interface{}{
-			"role":    "user",
-			"content": fmt.Sprintf("ขณะนี้เวลา %s: %s", timeStr, message),
-		})
+	// Add current user message; photos and text from the same flush are combined in order
+	inputItems = append(inputItems, openai.Message{Role: "user", Content: turnContent(getBangkokTime(), message)})
+	var notes []string
+	if modeSettings.Prompt != "" {
+		notes = append(notes, modeSettings.Prompt)
 	}
-
-	client := &http.Client{Timeout: 120 * time.Second}
-
-	// Loop to handle function/tool calls (Responses API is synchronous — no polling needed)
-	for iteration := 0; iteration < 10; iteration++ {
-		payload := map[string]interface{}{
-			"model":        "gpt-4.1",
-			"instructions": systemInstructions,
-			"input":        inputItems,
-			"tools":        toolDefinitions,
-			"store":        false,
+	if isUrgentConversation(userId) {
+		notes = append(notes, urgentTurnNote())
+	}
+	if greeted {
+		notes = append(notes, greetingSentNote())
+	}
+	if !strings.Contains(message, "data:image") {
+		if note, ok := photoContextNote(userId); ok {
+			notes = append(notes, note)
 		}
-		payloadBytes, _ := json.Marshal(payload)
-		log.Printf("Responses API request (iteration %d), payload size: %d bytes", iteration, len(payloadBytes))
+	}
+	for _, note := range notes {
+		inputItems = append(inputItems, openai.Message{Role: "developer", Content: note})
+	}
+	return inputItems
+}
 
-		req, err := http.NewRequest("POST", "https://api.openai.com/v1/responses", bytes.NewReader(payloadBytes))
-		if err != nil {
-			log.Printf("Failed to create request: %v", err)
-			return "ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้ง"
+// runToolCalls executes the model's function calls in order and returns their outputs and
+// the number of calls that failed. A failed call is reported to the model, not returned;
+// the error is only set when the turn was cancelled.
+func runToolCalls(ctx context.Context, userId string, provider LLMProvider, calls []LLMToolCall) ([]interface{}, int, error) {
+	logger := loggerFrom(ctx)
+	stats := turnStatsFrom(ctx)
+	var outputs []interface{}
+	failed := 0
+	for _, call := range calls {
+		if err := ctx.Err(); err != nil {
+			return nil, failed, err
 		}
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
+		_, toolSpan := startSpan(ctx, "tool "+call.Name, spanInternal, "tool.name", call.Name)
+		result, err := dispatchFunctionCall(call.Name, call.Arguments, userId)
+		toolSpan.End(err)
+		stats.Tools = append(stats.Tools, call.Name)
+		toolStatus := "ok"
 		if err != nil {
-			log.Printf("Responses API request failed: %v", err)
-			return "ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้ง"
+			toolStatus = errorKind(err)
 		}
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			log.Printf("Responses API error %d: %s", resp.StatusCode, string(body))
-			return "ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้ง"
+		recordConversationEvent(ConversationEvent{UserID: userId, RunID: runIDFrom(ctx), Kind: "tool_call",
+			Tool: call.Name, ToolArgs: string(call.Arguments), Content: result, Status: toolStatus})
+		if debugEnabled(userId) {
+			logger.Debug("Function result", "tool", call.Name, "result", logContent(result))
 		}
-		log.Printf("Responses API response: %s", string(body))
-
-		// Parse output items
-		var respObj struct {
-			Output []json.RawMessage `json:"output"`
-		}
-		if err := json.Unmarshal(body, &respObj); err != nil {
-			log.Printf("Failed to parse Responses API response: %v", err)
-			return "ขออภัย ระบบมีปัญหาชั่วคราว กรุณาลองใหม่อีกครั้ง"
-		}
-
-		type outputItem struct {
-			Type    string `json:"type"`
-			Role    string `json:"role"`
-			Content []struct {
-				Type string `json:"type"`
-				Text string `json:"text"`
-			} `json:"content"`
-			ID        string          `json:"id"`
-			CallID    string          `json:"call_id"`
-			Name      string          `json:"name"`
-			Arguments json.RawMessage `json:"arguments"`
-		}
-
-		var parsedOutput []outputItem
-		for _, raw := range respObj.Output {
-			var item outputItem
-			json.Unmarshal(raw, &item)
-			parsedOutput = append(parsedOutput, item)
-		}
-
-		// Collect function calls
-		var toolCalls []outputItem
-		for _, item := range parsedOutput {
-			if item.Type == "function_call" {
-				toolCalls = append(toolCalls, item)
-			}
-		}
-
-		if len(toolCalls) > 0 {
-			log.Printf("Processing %d function call(s) at iteration %d", len(toolCalls), iteration)
-			// Echo all output items back into input (Responses API requirement)
-			for _, raw := range respObj.Output {
-				var rawItem interface{}
-				json.Unmarshal(raw, &rawItem)
-				inputItems = append(inputItems, rawItem)
-			}
-			// Execute each function call and append its result
-			for _, call := range toolCalls {
-				result := dispatchFunctionCall(call.Name, call.Arguments, userId)
-				log.Printf("Function %s → %s", call.Name, result)
-				inputItems = append(inputItems, map[string]interface{}{
-					"type":    "function_call_output",
-					"call_id": call.CallID,
-					"output":  result,
-				})
-			}
-			continue
-		}
-
-		// Look for the assistant's text reply
-		for _, item := range parsedOutput {
-			if item.Type == "message" && item.Role == "assistant" {
-				for _, content := range item.Content {
-					if content.Type == "output_text" && content.Text != "" {
-						reply := content.Text
-						log.Printf("Assistant reply: %s", reply)
-						if !isErrorResponse(reply) {
-							userThreadLock.Lock()
-							userLastQAMap[userId] = struct {
-								Question string
-								Answer   string
-							}{Question: message, Answer: reply}
-							userThreadLock.Unlock()
-						}
-						return reply
-					}
-				}
-			}
+		if err != nil {
+			failed++
+			logger.Log(ctx, errorLogLevel(err), "Tool call failed", "tool", call.Name, "kind", errorKind(err), "error", err)
+			appMetrics.inc("tool_errors_" + errorKind(err))
+		} else {
+			recordPartialAnswer(userId, call.Name, result)
 		}
-
-		log.Printf("No text reply found in output at iteration %d", iteration)
-		break
+		outputs = append(outputs, provider.ToolOutput(call.ID, result))
 	}
-
-	log.Printf("getAssistantResponse: no reply generated for user %s", userId)
-	return ""
+	return outputs, failed, nil
 }
 
 // getWorkflowStepInstruction manages GPT workflow and provides step-by-step instructions
@@ -1606,276 +2244,34 @@ func getImageAnalysisGuidance(imageType, analysisRequest string) string {
 	return guidance.String()
 }
 
-// Helper functions for JSON-based pricing
-func normalizeAlias(input string, aliases []string) bool {
-	input = strings.ToLower(strings.TrimSpace(input))
-	for _, alias := range aliases {
-		if strings.ToLower(alias) == input {
-			return true
-		}
-	}
-	return false
-}
-
-func findServiceKey(input string) string {
-	for key, service := range pricingConfig.Services {
-		if normalizeAlias(input, service.Aliases) {
-			return key
-		}
-	}
-	return ""
-}
-
-func findItemKey(input string) string {
-	for key, item := range pricingConfig.Items {
-		if normalizeAlias(input, item.Aliases) {
-			return key
-		}
-	}
-	return ""
-}
-
-func findPackageKey(input string) string {
-	for key, pkg := range pricingConfig.Packages {
-		if normalizeAlias(input, pkg.Aliases) {
-			return key
-		}
-	}
-	return ""
-}
-
-func findCustomerKey(input string) string {
-	for key, customer := range pricingConfig.CustomerTypes {
-		if normalizeAlias(input, customer.Aliases) {
-			return key
-		}
-	}
-	return ""
-}
-
-func findSizeKey(input string, sizes map[string]SizeConfig) string {
-	for key, size := range sizes {
-		if normalizeAlias(input, size.Aliases) {
-			return key
-		}
-	}
-	return ""
-}
-
-func formatPrice(price PriceConfig, serviceName, itemName, sizeName, customerName string) string {
-	var result strings.Builder
-
-	result.WriteString(fmt.Sprintf("%s %s บริการ%s", itemName, sizeName, serviceName))
-
-	if customerName != "" {
-		result.WriteString(fmt.Sprintf(" สำหรับ%s", customerName))
-	}
-	result.WriteString(": ")
-
-	parts := []string{}
-	if price.FullPrice > 0 {
-		parts = append(parts, fmt.Sprintf("ราคาเต็ม %s บาท", formatNumber(price.FullPrice)))
-	}
-	if price.Discount35 > 0 {
-		parts = append(parts, fmt.Sprintf("ลด 35%% = %s บาท", formatNumber(price.Discount35)))
-	}
-	if price.Discount50 > 0 {
-		parts = append(parts, fmt.Sprintf("ลด 50%% = %s บาท", formatNumber(price.Discount50)))
-	}
-
-	result.WriteString(strings.Join(parts, ", "))
-	return result.String()
-}
-
-func formatPackagePrice(pkg PackagePrice, serviceName, packageName string, quantity int) string {
-	depositInfo := ""
-	if pkg.DepositMin > 0 {
-		depositInfo = fmt.Sprintf(" มัดจำขั้นต่ำ %s บาท", formatNumber(pkg.DepositMin))
-	}
-
-	return fmt.Sprintf("%s %d ใบ บริการ%s: ราคาเต็ม %s บาท, ส่วนลด %s บาท, ราคาขาย %s บาท (เฉลี่ย %s บาท/ใบ)%s",
-		packageName, quantity, serviceName,
-		formatNumber(pkg.FullPrice),
-		formatNumber(pkg.Discount),
-		formatNumber(pkg.SalePrice),
-		formatNumber(pkg.PerItem),
-		depositInfo)
-}
-
-func formatNumber(n int) string {
-	str := fmt.Sprintf("%d", n)
-	if len(str) <= 3 {
-		return str
-	}
-
-	var result strings.Builder
-	for i, r := range str {
-		if i > 0 && (len(str)-i)%3 == 0 {
-			result.WriteString(",")
-		}
-		result.WriteRune(r)
-	}
-	return result.String()
-}
-
-// getNCSPricingJSON returns pricing information using JSON configuration
-func getNCSPricingJSON(serviceType, itemType, size, customerType, packageType string, quantity int) string {
-	if pricingConfig == nil {
-		return "ระบบราคายังไม่พร้อมใช้งาน กรุณาลองใหม่อีกครั้ง"
-	}
-
-	log.Printf("getNCSPricingJSON called with: serviceType='%s', itemType='%s', size='%s', customerType='%s', packageType='%s', quantity=%d",
-		serviceType, itemType, size, customerType, packageType, quantity)
-
-	// Normalize inputs
-	serviceKey := findServiceKey(serviceType)
-	itemKey := findItemKey(itemType)
-	customerKey := findCustomerKey(customerType)
-	packageKey := findPackageKey(packageType)
-
-	// Set defaults
-	if customerKey == "" {
-		customerKey = "new" // default customer type
-	}
-	if packageKey == "" {
-		packageKey = "regular" // default package type
-	}
-
-	log.Printf("Normalized keys: serviceKey='%s', itemKey='%s', customerKey='%s', packageKey='%s'",
-		serviceKey, itemKey, customerKey, packageKey)
-
-	// Handle package pricing
-	if packageKey != "regular" {
-		return handlePackagePricing(serviceKey, packageKey, quantity)
-	}
-
-	// Handle regular item pricing
-	if serviceKey == "" || itemKey == "" {
-		return generateFallbackResponse(serviceType, itemType, size)
-	}
-
-	return handleItemPricing(serviceKey, itemKey, size, customerKey)
-}
-
-func handlePackagePricing(serviceKey, packageKey string, quantity int) string {
-	pkg, exists := pricingConfig.Packages[packageKey]
-	if !exists {
-		return "ไม่พบข้อมูลแพคเพจที่ระบุ"
-	}
-
-	serviceName := ""
-	if serviceKey != "" {
-		if svc, exists := pricingConfig.Services[serviceKey]; exists {
-			serviceName = svc.Name
-		}
-	} else {
-		serviceName = "ทำความสะอาด"
-	}
-
-	quantityStr := fmt.Sprintf("%d", quantity)
-
-	if serviceKey == "disinfection" && pkg.Disinfection != nil {
-		if price, exists := pkg.Disinfection[quantityStr]; exists {
-			return formatPackagePrice(price, serviceName, pkg.Name, quantity)
-		}
-	} else if serviceKey == "washing" && pkg.Washing != nil {
-		if price, exists := pkg.Washing[quantityStr]; exists {
-			return formatPackagePrice(price, serviceName, pkg.Name, quantity)
-		}
-	}
-
-	return fmt.Sprintf("ไม่พบข้อมูลราคา%s %d ใบ สำหรับบริการ%s", pkg.Name, quantity, serviceName)
-}
-
-func handleItemPricing(serviceKey, itemKey, size, customerKey string) string {
-	item, exists := pricingConfig.Items[itemKey]
-	if !exists {
-		return "ไม่พบข้อมูลสินค้าที่ระบุ"
-	}
-
-	service := pricingConfig.Services[serviceKey]
-	customer := pricingConfig.CustomerTypes[customerKey]
-
-	// Handle case where no size is specified
-	if size == "" {
-		return generateItemSizeList(serviceKey, itemKey, customerKey)
-	}
-
-	// Find size
-	sizeKey := findSizeKey(size, item.Sizes)
-	if sizeKey == "" {
-		return generateItemSizeList(serviceKey, itemKey, customerKey)
-	}
-
-	sizeConfig := item.Sizes[sizeKey]
-
-	// Get pricing
-	if servicePricing, exists := sizeConfig.Pricing[serviceKey]; exists {
-		if customerPricing, exists := servicePricing[customerKey]; exists {
-			if regularPricing, exists := customerPricing["regular"]; exists {
-				return formatPrice(regularPricing, service.Name, item.Name, sizeConfig.Name, customer.Name)
-			}
-		}
-	}
-
-	return fmt.Sprintf("ไม่พบข้อมูลราคา%s %s %s สำหรับ%s", item.Name, sizeConfig.Name, service.Name, customer.Name)
-}
-
-func generateItemSizeList(serviceKey, itemKey, customerKey string) string {
-	item := pricingConfig.Items[itemKey]
-	service := pricingConfig.Services[serviceKey]
-	customer := pricingConfig.CustomerTypes[customerKey]
-
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("บริการทำความสะอาด%s %s", item.Name, service.Name))
-	if customerKey != "new" {
-		result.WriteString(fmt.Sprintf(" สำหรับ%s", customer.Name))
-	}
-	result.WriteString(":\n")
-
-	count := 0
-	for _, sizeConfig := range item.Sizes {
-		if servicePricing, exists := sizeConfig.Pricing[serviceKey]; exists {
-			if customerPricing, exists := servicePricing[customerKey]; exists {
-				if pricing, exists := customerPricing["regular"]; exists {
-					count++
-					result.WriteString(fmt.Sprintf("• %s %s: ", item.Name, sizeConfig.Name))
-
-					parts := []string{}
-					if pricing.FullPrice > 0 {
-						parts = append(parts, fmt.Sprintf("%s บาท", formatNumber(pricing.FullPrice)))
-					}
-					if pricing.Discount35 > 0 {
-						parts = append(parts, fmt.Sprintf("ลด 35%% = %s บาท", formatNumber(pricing.Discount35)))
-					}
-					if pricing.Discount50 > 0 {
-						parts = append(parts, fmt.Sprintf("ลด 50%% = %s บาท", formatNumber(pricing.Discount50)))
-					}
-					result.WriteString(strings.Join(parts, ", "))
-					result.WriteString("\n")
-				}
-			}
-		}
-	}
-
-	if count == 0 {
-		return fmt.Sprintf("ไม่พบข้อมูลราคา%s สำหรับบริการ%s", item.Name, service.Name)
-	}
-
-	result.WriteString(fmt.Sprintf("\nกรุณาระบุขนาด%sเพื่อข้อมูลราคาที่แม่นยำ", item.Name))
-	return result.String()
-}
-
-func generateFallbackResponse(serviceType, itemType, size string) string {
-	return fmt.Sprintf("ขออภัย ไม่พบข้อมูลราคาสำหรับ บริการ: '%s' สินค้า: '%s' ขนาด: '%s'\n\nกรุณาติดต่อเจ้าหน้าที่เพื่อสอบถามราคาเพิ่มเติม หรือระบุรายละเอียดให้ชัดเจนมากขึ้น เช่น:\n• ประเภทบริการ (กำจัดเชื้อโรค หรือ ซักขจัดคราบ)\n• ประเภทสินค้า (ที่นอน/โซฟา/ม่าน/พรม)\n• ขนาด (3ฟุต, 6ฟุต, 2ที่นั่ง, ฯลฯ)\n• ประเภทลูกค้า (ลูกค้าใหม่ หรือ สมาชิก)",
-		serviceType, itemType, size)
+// pricingEngine wraps the active config; aliases are matched after inbound normalization.
+func pricingEngine() *pricing.Engine {
+	return &pricing.Engine{Config: pricingConfig, Normalize: normalizeInboundText}
+}
+
+// getNCSPricingJSON returns pricing information using JSON configuration, with the
+// promotions running today in Bangkok applied
+func getNCSPricingJSON(engine *pricing.Engine, serviceType, itemType, size, customerType, packageType string, quantity int, promoCode string) (string, error) {
+	log.Printf("getNCSPricingJSON called with: serviceType='%s', itemType='%s', size='%s', customerType='%s', packageType='%s', quantity=%d, promoCode='%s'",
+		serviceType, itemType, size, customerType, packageType, quantity, promoCode)
+	return engine.Quote(pricing.QuoteRequest{
+		ServiceType:  serviceType,
+		ItemType:     itemType,
+		Size:         size,
+		CustomerType: customerType,
+		PackageType:  packageType,
+		Quantity:     quantity,
+		PromoCode:    promoCode,
+		Today:        bangkokNow().Format("2006-01-02"),
+	})
 }
 
-// getNCSPricing returns pricing information for NCS cleaning services (Legacy version for backward compatibility)
-func getNCSPricing(serviceType, itemType, size, customerType, packageType string, quantity int) string {
+// getNCSPricing returns pricing information for NCS cleaning services (Legacy version for backward compatibility).
+// With pricing.ErrNotFound the text is the apology to pass on.
+func getNCSPricing(engine *pricing.Engine, serviceType, itemType, size, customerType, packageType string, quantity int, promoCode string) (string, error) {
 	// Use JSON-based pricing if configuration is loaded
 	if pricingConfig != nil {
-		return getNCSPricingJSON(serviceType, itemType, size, customerType, packageType, quantity)
+		return getNCSPricingJSON(engine, serviceType, itemType, size, customerType, packageType, quantity, promoCode)
 	}
 
 	// Fallback to hardcoded pricing if JSON config is not available
@@ -1884,7 +2280,7 @@ func getNCSPricing(serviceType, itemType, size, customerType, packageType string
 }
 
 // getNCSPricingHardcoded returns pricing information for NCS cleaning services (Legacy hardcoded version)
-func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageType string, quantity int) string {
+func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageType string, quantity int) (string, error) {
 	log.Printf("getNCSPricing called with: serviceType='%s', itemType='%s', size='%s', customerType='%s', packageType='%s', quantity=%d",
 		serviceType, itemType, size, customerType, packageType, quantity)
 
@@ -1915,68 +2311,68 @@ func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageTy
 			case "mattress", "ที่นอน":
 				// Handle case where size is not specified - return both mattress sizes
 				if size == "" {
-					return "บริการทำความสะอาดที่นอน กำจัดเชื้อโรค-ไรฝุ่น:\n• ที่นอน 3-3.5ฟุต: 1,990 บาท (ลด 35% = 1,290 บาท, ลด 50% = 995 บาท)\n• ที่นอน 5-6ฟุต: 2,390 บาท (ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท)\n\nกรุณาระบุขนาดที่นอนเพื่อข้อมูลราคาที่แม่นยำ"
+					return "บริการทำความสะอาดที่นอน กำจัดเชื้อโรค-ไรฝุ่น:\n• ที่นอน 3-3.5ฟุต: 1,990 บาท (ลด 35% = 1,290 บาท, ลด 50% = 995 บาท)\n• ที่นอน 5-6ฟุต: 2,390 บาท (ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท)\n\nกรุณาระบุขนาดที่นอนเพื่อข้อมูลราคาที่แม่นยำ", nil
 				}
 				if size == "3-3.5ft" || size == "3ฟุต" || size == "3.5ฟุต" {
-					return "ที่นอน 3-3.5ฟุต บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,990 บาท, ลด 35% = 1,290 บาท, ลด 50% = 995 บาท"
+					return "ที่นอน 3-3.5ฟุต บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,990 บาท, ลด 35% = 1,290 บาท, ลด 50% = 995 บาท", nil
 				} else if size == "5-6ft" || size == "5ฟุต" || size == "6ฟุต" {
-					return "ที่นอน 5-6ฟุต บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท"
+					return "ที่นอน 5-6ฟุต บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท", nil
 				}
 			case "sofa", "โซฟา":
 				// Handle case where size is not specified - return general sofa pricing
 				if size == "" {
-					return "บริการทำความสะอาดโซฟา กำจัดเชื้อโรค-ไรฝุ่น:\n• เก้าอี้: 450 บาท (ลด 35% = 295 บาท, ลด 50% = 225 บาท)\n• โซฟา 1ที่นั่ง: 990 บาท (ลด 35% = 650 บาท, ลด 50% = 495 บาท)\n• โซฟา 2ที่นั่ง: 1,690 บาท (ลด 35% = 1,100 บาท, ลด 50% = 845 บาท)\n• โซฟา 3ที่นั่ง: 2,390 บาท (ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท)\n\nกรุณาระบุขนาดโซฟาเพื่อข้อมูลราคาที่แม่นยำ"
+					return "บริการทำความสะอาดโซฟา กำจัดเชื้อโรค-ไรฝุ่น:\n• เก้าอี้: 450 บาท (ลด 35% = 295 บาท, ลด 50% = 225 บาท)\n• โซฟา 1ที่นั่ง: 990 บาท (ลด 35% = 650 บาท, ลด 50% = 495 บาท)\n• โซฟา 2ที่นั่ง: 1,690 บาท (ลด 35% = 1,100 บาท, ลด 50% = 845 บาท)\n• โซฟา 3ที่นั่ง: 2,390 บาท (ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท)\n\nกรุณาระบุขนาดโซฟาเพื่อข้อมูลราคาที่แม่นยำ", nil
 				}
 				switch size {
 				case "chair", "เก้าอี้":
-					return "เก้าอี้ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 450 บาท, ลด 35% = 295 บาท, ลด 50% = 225 บาท"
+					return "เก้าอี้ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 450 บาท, ลด 35% = 295 บาท, ลด 50% = 225 บาท", nil
 				case "1seat", "1ที่นั่ง":
-					return "โซฟา 1ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 990 บาท, ลด 35% = 650 บาท, ลด 50% = 495 บาท"
+					return "โซฟา 1ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 990 บาท, ลด 35% = 650 บาท, ลด 50% = 495 บาท", nil
 				case "2seat", "2ที่นั่ง":
-					return "โซฟา 2ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,690 บาท, ลด 35% = 1,100 บาท, ลด 50% = 845 บาท"
+					return "โซฟา 2ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,690 บาท, ลด 35% = 1,100 บาท, ลด 50% = 845 บาท", nil
 				case "3seat", "3ที่นั่ง":
-					return "โซฟา 3ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท"
+					return "โซฟา 3ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท", nil
 				case "4seat", "4ที่นั่ง":
-					return "โซฟา 4ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,090 บาท, ลด 35% = 1,990 บาท, ลด 50% = 1,545 บาท"
+					return "โซฟา 4ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,090 บาท, ลด 35% = 1,990 บาท, ลด 50% = 1,545 บาท", nil
 				case "5seat", "5ที่นั่ง":
-					return "โซฟา 5ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,790 บาท, ลด 35% = 2,490 บาท, ลด 50% = 1,895 บาท"
+					return "โซฟา 5ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,790 บาท, ลด 35% = 2,490 บาท, ลด 50% = 1,895 บาท", nil
 				case "6seat", "6ที่นั่ง":
-					return "โซฟา 6ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,490 บาท, ลด 35% = 2,900 บาท, ลด 50% = 2,245 บาท"
+					return "โซฟา 6ที่นั่ง บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,490 บาท, ลด 35% = 2,900 บาท, ลด 50% = 2,245 บาท", nil
 				}
 			case "curtain", "ม่าน", "carpet", "พรม", "ม่าน/พรม":
 				// Default to per square meter pricing if no size specified
 				if size == "" || size == "sqm" || size == "ตรม" || size == "ตร.ม." || size == "ตารางเมตร" || size == "ตารางเมตร(ตรม.)" || size == "ต่อ 1 ตรม" || size == "ต่อ1ตรม" || size == "per_sqm" || size == "per_sqm_disinfection" || size == "1sqm" {
-					return "ม่าน/พรม ต่อ 1 ตร.ม. บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 150 บาท, ลด 35% = 95 บาท, ลด 50% = 75 บาท"
+					return "ม่าน/พรม ต่อ 1 ตร.ม. บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 150 บาท, ลด 35% = 95 บาท, ลด 50% = 75 บาท", nil
 				}
 			}
 		} else if serviceType == "washing" || serviceType == "ซักขจัดคราบ" {
 			switch itemType {
 			case "mattress", "ที่นอน":
 				if size == "3-3.5ft" || size == "3ฟุต" || size == "3.5ฟุต" {
-					return "ที่นอน 3-3.5ฟุต บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,500 บาท, ลด 35% = 1,590 บาท, ลด 50% = 1,250 บาท"
+					return "ที่นอน 3-3.5ฟุต บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,500 บาท, ลด 35% = 1,590 บาท, ลด 50% = 1,250 บาท", nil
 				} else if size == "5-6ft" || size == "5ฟุต" || size == "6ฟุต" {
-					return "ที่นอน 5-6ฟุต บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,790 บาท, ลด 35% = 1,790 บาท, ลด 50% = 1,395 บาท"
+					return "ที่นอน 5-6ฟุต บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,790 บาท, ลด 35% = 1,790 บาท, ลด 50% = 1,395 บาท", nil
 				}
 			case "sofa", "โซฟา":
 				switch size {
 				case "chair", "เก้าอี้":
-					return "เก้าอี้ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 990 บาท, ลด 35% = 650 บาท, ลด 50% = 495 บาท"
+					return "เก้าอี้ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 990 บาท, ลด 35% = 650 บาท, ลด 50% = 495 บาท", nil
 				case "1seat", "1ที่นั่ง":
-					return "โซฟา 1ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 1,690 บาท, ลด 35% = 1,100 บาท, ลด 50% = 845 บาท"
+					return "โซฟา 1ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 1,690 บาท, ลด 35% = 1,100 บาท, ลด 50% = 845 บาท", nil
 				case "2seat", "2ที่นั่ง":
-					return "โซฟา 2ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท"
+					return "โซฟา 2ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,390 บาท, ลด 35% = 1,490 บาท, ลด 50% = 1,195 บาท", nil
 				case "3seat", "3ที่นั่ง":
-					return "โซฟา 3ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,090 บาท, ลด 35% = 1,990 บาท, ลด 50% = 1,545 บาท"
+					return "โซฟา 3ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,090 บาท, ลด 35% = 1,990 บาท, ลด 50% = 1,545 บาท", nil
 				case "4seat", "4ที่นั่ง":
-					return "โซฟา 4ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,790 บาท, ลด 35% = 2,490 บาท, ลด 50% = 1,895 บาท"
+					return "โซฟา 4ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,790 บาท, ลด 35% = 2,490 บาท, ลด 50% = 1,895 บาท", nil
 				case "5seat", "5ที่นั่ง":
-					return "โซฟา 5ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 4,490 บาท, ลด 35% = 2,900 บาท, ลด 50% = 2,245 บาท"
+					return "โซฟา 5ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 4,490 บาท, ลด 35% = 2,900 บาท, ลด 50% = 2,245 บาท", nil
 				case "6seat", "6ที่นั่ง":
-					return "โซฟา 6ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 5,190 บาท, ลด 35% = 3,350 บาท, ลด 50% = 2,595 บาท"
+					return "โซฟา 6ที่นั่ง บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 5,190 บาท, ลด 35% = 3,350 บาท, ลด 50% = 2,595 บาท", nil
 				}
 			case "curtain", "ม่าน", "carpet", "พรม", "ม่าน/พรม":
 				if size == "sqm" || size == "ตรม" || size == "ตร.ม." || size == "ตารางเมตร" || size == "ตารางเมตร(ตรม.)" || size == "ต่อ 1 ตรม" || size == "ต่อ1ตรม" || size == "per_sqm" || size == "1sqm" {
-					return "ม่าน/พรม ต่อ 1 ตร.ม. บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 700 บาท, ลด 35% = 450 บาท, ลด 50% = 350 บาท"
+					return "ม่าน/พรม ต่อ 1 ตร.ม. บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 700 บาท, ลด 35% = 450 บาท, ลด 50% = 350 บาท", nil
 				}
 			}
 		}
@@ -1987,18 +2383,18 @@ func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageTy
 		if serviceType == "disinfection" || serviceType == "กำจัดเชื้อโรค" {
 			switch quantity {
 			case 5:
-				return "แพคเพจคูปอง 5 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 12,950 บาท, ส่วนลด 7,460 บาท, ราคาขาย 5,490 บาท (เฉลี่ย 1,098 บาท/ใบ)"
+				return "แพคเพจคูปอง 5 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 12,950 บาท, ส่วนลด 7,460 บาท, ราคาขาย 5,490 บาท (เฉลี่ย 1,098 บาท/ใบ)", nil
 			case 10:
-				return "แพคเพจคูปอง 10 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 25,900 บาท, ส่วนลด 16,000 บาท, ราคาขาย 9,900 บาท (เฉลี่ย 990 บาท/ใบ)"
+				return "แพคเพจคูปอง 10 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 25,900 บาท, ส่วนลด 16,000 บาท, ราคาขาย 9,900 บาท (เฉลี่ย 990 บาท/ใบ)", nil
 			case 20:
-				return "แพคเพจคูปอง 20 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 51,800 บาท, ส่วนลด 32,800 บาท, ราคาขาย 19,000 บาท (เฉลี่ย 950 บาท/ใบ)"
+				return "แพคเพจคูปอง 20 ใบ บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 51,800 บาท, ส่วนลด 32,800 บาท, ราคาขาย 19,000 บาท (เฉลี่ย 950 บาท/ใบ)", nil
 			}
 		} else if serviceType == "washing" || serviceType == "ซักขจัดคราบ" {
 			switch quantity {
 			case 5:
-				return "แพคเพจคูปอง 5 ใบ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 13,500 บาท, ส่วนลด 6,550 บาท, ราคาขาย 6,950 บาท (เฉลี่ย 1,390 บาท/ใบ)"
+				return "แพคเพจคูปอง 5 ใบ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 13,500 บาท, ส่วนลด 6,550 บาท, ราคาขาย 6,950 บาท (เฉลี่ย 1,390 บาท/ใบ)", nil
 			case 10:
-				return "แพคเพจคูปอง 10 ใบ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 27,000 บาท, ส่วนลด 14,100 บาท, ราคาขาย 12,900 บาท (เฉลี่ย 1,290 บาท/ใบ)"
+				return "แพคเพจคูปอง 10 ใบ บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 27,000 บาท, ส่วนลด 14,100 บาท, ราคาขาย 12,900 บาท (เฉลี่ย 1,290 บาท/ใบ)", nil
 			}
 		}
 	}
@@ -2008,13 +2404,13 @@ func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageTy
 		if serviceType == "disinfection" || serviceType == "กำจัดเชื้อโรค" {
 			switch quantity {
 			case 2:
-				return "สัญญา 2 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,780 บาท, ส่วนลด 2,090 บาท, ราคาขาย 2,690 บาท (เฉลี่ย 1,345 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท"
+				return "สัญญา 2 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,780 บาท, ส่วนลด 2,090 บาท, ราคาขาย 2,690 บาท (เฉลี่ย 1,345 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท", nil
 			case 3:
-				return "สัญญา 3 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 7,170 บาท, ส่วนลด 3,520 บาท, ราคาขาย 3,850 บาท (เฉลี่ย 1,283 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท"
+				return "สัญญา 3 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 7,170 บาท, ส่วนลด 3,520 บาท, ราคาขาย 3,850 บาท (เฉลี่ย 1,283 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท", nil
 			case 4:
-				return "สัญญา 4 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 9,560 บาท, ส่วนลด 4,870 บาท, ราคาขาย 4,690 บาท (เฉลี่ย 1,173 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท"
+				return "สัญญา 4 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 9,560 บาท, ส่วนลด 4,870 บาท, ราคาขาย 4,690 บาท (เฉลี่ย 1,173 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท", nil
 			case 5:
-				return "สัญญา 5 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 11,950 บาท, ส่วนลด 6,860 บาท, ราคาขาย 5,450 บาท (เฉลี่ย 1,090 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท"
+				return "สัญญา 5 ชิ้น บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 11,950 บาท, ส่วนลด 6,860 บาท, ราคาขาย 5,450 บาท (เฉลี่ย 1,090 บาท/ชิ้น) มัดจำขั้นต่ำ 1,000 บาท", nil
 			}
 		}
 	}
@@ -2025,101 +2421,191 @@ func getNCSPricingHardcoded(serviceType, itemType, size, customerType, packageTy
 			switch itemType {
 			case "mattress", "ที่นอน":
 				if size == "3-3.5ft" || size == "3ฟุต" || size == "3.5ฟุต" {
-					return "ที่นอน 3-3.5ฟุต สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,990 บาท, ราคาลด 50% = 995 บาท"
+					return "ที่นอน 3-3.5ฟุต สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,990 บาท, ราคาลด 50% = 995 บาท", nil
 				} else if size == "5-6ft" || size == "5ฟุต" || size == "6ฟุต" {
-					return "ที่นอน 5-6ฟุต สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท"
+					return "ที่นอน 5-6ฟุต สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท", nil
 				}
 			case "sofa", "โซฟา":
 				switch size {
 				case "chair", "เก้าอี้":
-					return "เก้าอี้ สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 450 บาท, ราคาลด 50% = 225 บาท"
+					return "เก้าอี้ สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 450 บาท, ราคาลด 50% = 225 บาท", nil
 				case "1seat", "1ที่นั่ง":
-					return "โซฟา 1ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 990 บาท, ราคาลด 50% = 495 บาท"
+					return "โซฟา 1ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 990 บาท, ราคาลด 50% = 495 บาท", nil
 				case "2seat", "2ที่นั่ง":
-					return "โซฟา 2ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,690 บาท, ราคาลด 50% = 845 บาท"
+					return "โซฟา 2ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 1,690 บาท, ราคาลด 50% = 845 บาท", nil
 				case "3seat", "3ที่นั่ง":
-					return "โซฟา 3ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท"
+					return "โซฟา 3ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท", nil
 				case "4seat", "4ที่นั่ง":
-					return "โซฟา 4ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,090 บาท, ราคาลด 50% = 1,545 บาท"
+					return "โซฟา 4ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,090 บาท, ราคาลด 50% = 1,545 บาท", nil
 				case "5seat", "5ที่นั่ง":
-					return "โซฟา 5ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,790 บาท, ราคาลด 50% = 1,895 บาท"
+					return "โซฟา 5ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 3,790 บาท, ราคาลด 50% = 1,895 บาท", nil
 				case "6seat", "6ที่นั่ง":
-					return "โซฟา 6ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,490 บาท, ราคาลด 50% = 2,245 บาท"
+					return "โซฟา 6ที่นั่ง สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 4,490 บาท, ราคาลด 50% = 2,245 บาท", nil
 				}
 			case "curtain", "ม่าน", "carpet", "พรม", "ม่าน/พรม":
 				if size == "sqm" || size == "ตรม" || size == "ตร.ม." || size == "ตารางเมตร" || size == "per_sqm" || size == "1sqm" {
-					return "ม่าน/พรม ต่อ 1 ตร.ม. สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 150 บาท, ราคาลด 50% = 75 บาท"
+					return "ม่าน/พรม ต่อ 1 ตร.ม. สำหรับสมาชิก NCS Family Member บริการกำจัดเชื้อโรค-ไรฝุ่น: ราคาเต็ม 150 บาท, ราคาลด 50% = 75 บาท", nil
 				}
 			}
 		} else if serviceType == "washing" || serviceType == "ซักขจัดคราบ" {
 			switch itemType {
 			case "mattress", "ที่นอน":
 				if size == "3-3.5ft" || size == "3ฟุต" || size == "3.5ฟุต" {
-					return "ที่นอน 3-3.5ฟุต สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,500 บาท, ราคาลด 50% = 1,250 บาท"
+					return "ที่นอน 3-3.5ฟุต สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,500 บาท, ราคาลด 50% = 1,250 บาท", nil
 				} else if size == "5-6ft" || size == "5ฟุต" || size == "6ฟุต" {
-					return "ที่นอน 5-6ฟุต สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,790 บาท, ราคาลด 50% = 1,395 บาท"
+					return "ที่นอน 5-6ฟุต สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,790 บาท, ราคาลด 50% = 1,395 บาท", nil
 				}
 			case "sofa", "โซฟา":
 				switch size {
 				case "chair", "เก้าอี้":
-					return "เก้าอี้ สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 990 บาท, ราคาลด 50% = 495 บาท"
+					return "เก้าอี้ สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 990 บาท, ราคาลด 50% = 495 บาท", nil
 				case "1seat", "1ที่นั่ง":
-					return "โซฟา 1ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 1,690 บาท, ราคาลด 50% = 845 บาท"
+					return "โซฟา 1ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 1,690 บาท, ราคาลด 50% = 845 บาท", nil
 				case "2seat", "2ที่นั่ง":
-					return "โซฟา 2ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท"
+					return "โซฟา 2ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 2,390 บาท, ราคาลด 50% = 1,195 บาท", nil
 				case "3seat", "3ที่นั่ง":
-					return "โซฟา 3ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,090 บาท, ราคาลด 50% = 1,545 บาท"
+					return "โซฟา 3ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,090 บาท, ราคาลด 50% = 1,545 บาท", nil
 				case "4seat", "4ที่นั่ง":
-					return "โซฟา 4ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,790 บาท, ราคาลด 50% = 1,895 บาท"
+					return "โซฟา 4ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 3,790 บาท, ราคาลด 50% = 1,895 บาท", nil
 				case "5seat", "5ที่นั่ง":
-					return "โซฟา 5ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 4,490 บาท, ราคาลด 50% = 2,245 บาท"
+					return "โซฟา 5ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 4,490 บาท, ราคาลด 50% = 2,245 บาท", nil
 				case "6seat", "6ที่นั่ง":
-					return "โซฟา 6ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 5,190 บาท, ราคาลด 50% = 2,595 บาท"
+					return "โซฟา 6ที่นั่ง สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 5,190 บาท, ราคาลด 50% = 2,595 บาท", nil
 				}
 			case "curtain", "ม่าน", "carpet", "พรม", "ม่าน/พรม":
 				if size == "sqm" || size == "ตรม" || size == "ตร.ม." || size == "ตารางเมตร" || size == "per_sqm" || size == "1sqm" {
-					return "ม่าน/พรม ต่อ 1 ตร.ม. สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 700 บาท, ราคาลด 50% = 350 บาท"
+					return "ม่าน/พรม ต่อ 1 ตร.ม. สำหรับสมาชิก NCS Family Member บริการซักขจัดคราบ-กลิ่น: ราคาเต็ม 700 บาท, ราคาลด 50% = 350 บาท", nil
 				}
 			}
 		}
 	}
 
-	return "ขออภัย ไม่พบข้อมูลราคาสำหรับบริการที่ระบุ กรุณาติดต่อเจ้าหน้าที่เพื่อสอบถามราคาเพิ่มเติม หรือระบุรายละเอียดให้ชัดเจนมากขึ้น เช่น ประเภทบริการ (กำจัดเชื้อโรค หรือ ซักขจัดคราบ), ประเภทสินค้า (ที่นอน/โซฟา), ขนาด, และประเภทลูกค้า"
+	return "ขออภัย ไม่พบข้อมูลราคาสำหรับบริการที่ระบุ กรุณาติดต่อเจ้าหน้าที่เพื่อสอบถามราคาเพิ่มเติม หรือระบุรายละเอียดให้ชัดเจนมากขึ้น เช่น ประเภทบริการ (กำจัดเชื้อโรค หรือ ซักขจัดคราบ), ประเภทสินค้า (ที่นอน/โซฟา), ขนาด, และประเภทลูกค้า", pricing.ErrNotFound
 }
 
-func replyToLine(replyToken, message string) {
+// replyToLine replies with a text message followed by any extra message objects
+// (images, flex). Quick replies go on the last message, the only one LINE shows them on.
+func replyToLine(userId, replyToken, message string, quickReplies []QuickReplyOption, extra ...map[string]interface{}) {
 	if message == "" {
 		log.Println("No message to reply.")
 		return
 	}
+	replyOrPush(userId, replyToken, replyMessages(message, quickReplies, extra))
+}
+
+// replyMessages builds a reply's message objects. LINE accepts at most 5 messages per reply;
+// extras beyond that are dropped.
+func replyMessages(message string, quickReplies []QuickReplyOption, extra []map[string]interface{}) []map[string]interface{} {
+	messages := []map[string]interface{}{{
+		"type": "text",
+		"text": message,
+	}}
+	messages = append(messages, extra...)
+	if len(messages) > 5 {
+		log.Printf("Dropping %d reply message(s) over the LINE limit of 5", len(messages)-5)
+		messages = messages[:5]
+	}
+	attachQuickReplies(messages[len(messages)-1], quickReplies)
+	return messages
+}
+
+var (
+	// errInvalidReplyToken is LINE's answer to an expired or already used reply token.
+	errInvalidReplyToken = errors.New("invalid reply token")
+	errLineTokenMissing  = errors.New("LINE channel access token not set")
+)
+
+// replyOrPush replies with the token and falls back to a push when LINE rejects the token,
+// which happens when buffering and a slow assistant run outlast its validity.
+func replyOrPush(userId, replyToken string, messages []map[string]interface{}) {
+	if replyToken != "" {
+		err := sendWithLineRateLimit("reply", pushTransactional, func() error { return sendLineReply(userId, replyToken, messages) })
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, errInvalidReplyToken) {
+			log.Printf("Failed to reply to %s: %v", userId, err)
+			return
+		}
+		log.Printf("Reply token for %s expired; pushing the reply instead", userId)
+		appMetrics.inc("reply_token_push_fallbacks")
+	}
+	if err := pushLineMessages(userId, messages); err != nil {
+		log.Printf("Failed to push reply to %s: %v", userId, err)
+	}
+}
+
+// sendLineReply sends prepared message objects with a reply token of the user's channel.
+func sendLineReply(userId, replyToken string, messages []map[string]interface{}) error {
+	if captureLineMessage(replyToken, "reply", messages) {
+		return nil
+	}
 	lineReplyURL := "https://api.line.me/v2/bot/message/reply"
-	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	channelToken := lineAccessToken(userId)
 	if channelToken == "" {
-		log.Println("LINE channel access token not set.")
-		return
+		return &UpstreamError{Service: "line", Err: errLineTokenMissing}
 	}
 	payload := map[string]interface{}{
 		"replyToken": replyToken,
-		"messages": []map[string]string{{
-			"type": "text",
-			"text": message,
-		}},
+		"messages":   messages,
 	}
 	jsonPayload, _ := json.Marshal(payload)
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", lineReplyURL, io.NopCloser(bytes.NewReader(jsonPayload)))
+	client := &http.Client{Transport: lineTransport}
+	req, _ := http.NewRequest("POST", lineReplyURL, bytes.NewReader(jsonPayload))
 	req.Header.Set("Authorization", "Bearer "+channelToken)
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error replying to LINE:", err)
-		return
+		return classifyRequestError("line", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		log.Println("LINE reply error:", string(body))
+		if err := lineRateLimitFromResponse(resp); err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "Invalid reply token") {
+			return &UpstreamError{Service: "line", StatusCode: resp.StatusCode, Err: errInvalidReplyToken}
+		}
+		return &UpstreamError{Service: "line", StatusCode: resp.StatusCode, Err: errors.New(truncateRunes(string(body), 300))}
 	}
+	return nil
+}
+
+// deliverReply answers a turn with the reply token, or by push when the token was
+// already used (e.g. by the first-time greeting).
+func deliverReply(userId, replyToken, message string, quickReplies []QuickReplyOption, extra ...map[string]interface{}) {
+	debugf(userId, "Reply to %s (%d extra message(s), %d quick replies): %s", userId, len(extra), len(quickReplies), logContent(message))
+	if replyToken != "" {
+		replyToLine(userId, replyToken, message, quickReplies, extra...)
+		return
+	}
+	if message == "" {
+		return
+	}
+	if err := pushLineMessages(userId, replyMessages(message, quickReplies, extra)); err != nil {
+		log.Printf("Failed to push reply to %s: %v", userId, err)
+	}
+}
+
+// userReplyAttachments holds non-text messages produced by tools during a turn,
+// sent together with the assistant's reply. Guarded by userThreadLock.
+var userReplyAttachments = make(map[string][]map[string]interface{})
+
+// queueReplyAttachment adds a LINE message object to the user's next reply.
+func queueReplyAttachment(userId string, msg map[string]interface{}) {
+	userThreadLock.Lock()
+	userReplyAttachments[userId] = append(userReplyAttachments[userId], msg)
+	userThreadLock.Unlock()
+}
+
+// takeReplyAttachments returns and clears the queued attachments for a user.
+func takeReplyAttachments(userId string) []map[string]interface{} {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	msgs := userReplyAttachments[userId]
+	delete(userReplyAttachments, userId)
+	return msgs
 }
 
 // detectHumanRequest returns true when the message signals a request for a human agent
@@ -2166,37 +2652,59 @@ func detectAdminAlert(msg string) bool {
 
 // pushLineMessage sends a push message to a LINE user via the Push API
 func pushLineMessage(userId, message string) error {
-	channelToken := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	return pushLineMessages(userId, []map[string]interface{}{{"type": "text", "text": message}})
+}
+
+// pushLineMessages pushes prepared message objects (text, images, flex) in one request.
+func pushLineMessages(userId string, messages []map[string]interface{}) error {
+	return pushLineMessagesAs(userId, messages, pushTransactional)
+}
+
+// pushLineMessagesAs pushes with the given priority, which decides the order of sends while
+// LINE rate limits the channel.
+func pushLineMessagesAs(userId string, messages []map[string]interface{}, priority pushPriority) error {
+	if captureLineMessage(userId, "push", messages) {
+		return nil
+	}
+	return sendWithLineRateLimit("push", priority, func() error { return postLinePush(userId, messages) })
+}
+
+// postLinePush makes one call to the LINE push API.
+func postLinePush(userId string, messages []map[string]interface{}) error {
+	channelToken := lineAccessToken(userId)
 	if channelToken == "" {
-		return fmt.Errorf("LINE channel access token not set")
+		return &UpstreamError{Service: "line", Err: errLineTokenMissing}
 	}
 	payload := map[string]interface{}{
-		"to": userId,
-		"messages": []map[string]string{{
-			"type": "text",
-			"text": message,
-		}},
+		"to":       userId,
+		"messages": messages,
 	}
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal push payload: %w", err)
 	}
-	client := &http.Client{}
+	client := &http.Client{Transport: lineTransport}
 	req, err := http.NewRequest("POST", "https://api.line.me/v2/bot/message/push", bytes.NewReader(jsonPayload))
 	if err != nil {
 		return fmt.Errorf("failed to create push request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+channelToken)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Line-Retry-Key", newRetryKey())
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send push message: %w", err)
+		return classifyRequestError("line", err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
+	// 409: a retry of a push LINE had already accepted
+	if resp.StatusCode != 200 && resp.StatusCode != http.StatusConflict {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("LINE push error (%d): %s", resp.StatusCode, string(body))
+		if err := lineRateLimitFromResponse(resp); err != nil {
+			return err
+		}
+		return &UpstreamError{Service: "line", StatusCode: resp.StatusCode, Err: errors.New(truncateRunes(string(body), 300))}
 	}
+	recordLinePush(userId)
 	return nil
 }
 
@@ -2204,14 +2712,19 @@ func pushLineMessage(userId, message string) error {
 
 // ConversationSummary is a lightweight view of a conversation for the list page
 type ConversationSummary struct {
-	UserID       string `json:"user_id"`
-	DisplayName  string `json:"display_name"`
-	Nickname     string `json:"nickname"`
-	LastMessage  string `json:"last_message"`
-	LastSeen     string `json:"last_seen"`
-	Takeover     bool   `json:"takeover"`
-	WantsHuman   bool   `json:"wants_human"`
-	MessageCount int    `json:"message_count"`
+	UserID           string `json:"user_id"`
+	DisplayName      string `json:"display_name"`
+	Nickname         string `json:"nickname"`
+	LastMessage      string `json:"last_message"`
+	LastSeen         string `json:"last_seen"`
+	Takeover         bool   `json:"takeover"`
+	WantsHuman       bool   `json:"wants_human"`
+	MessageCount     int    `json:"message_count"`
+	FailureEscalated bool   `json:"failure_escalated"`
+	HandoffSummary   string `json:"handoff_summary,omitempty"`
+	Urgent           bool   `json:"urgent"`
+
+	TurnTotals TurnTotals `json:"turn_totals"`
 }
 
 func handleGetConversations(c *fiber.Ctx) error {
@@ -2227,15 +2740,23 @@ func handleGetConversations(c *fiber.Ctx) error {
 				lastMsg = lastMsg[:80] + "…"
 			}
 		}
+		handoff := ""
+		if conv.WantsHuman && conv.Handoff != nil {
+			handoff = conv.Handoff.Text
+		}
 		summaries = append(summaries, ConversationSummary{
-			UserID:       conv.UserID,
-			DisplayName:  conv.DisplayName,
-			Nickname:     conv.Nickname,
-			LastMessage:  lastMsg,
-			LastSeen:     conv.LastSeen,
-			Takeover:     conv.Takeover,
-			WantsHuman:   conv.WantsHuman,
-			MessageCount: len(conv.Messages),
+			UserID:           conv.UserID,
+			DisplayName:      conv.DisplayName,
+			Nickname:         conv.Nickname,
+			LastMessage:      lastMsg,
+			LastSeen:         conv.LastSeen,
+			Takeover:         conv.Takeover,
+			WantsHuman:       conv.WantsHuman,
+			MessageCount:     len(conv.Messages),
+			FailureEscalated: conv.FailureEscalated,
+			HandoffSummary:   handoff,
+			Urgent:           isUrgentLocked(conv),
+			TurnTotals:       conversationTurnTotals(conv),
 		})
 	}
 	return c.JSON(summaries)
@@ -2247,11 +2768,16 @@ func handleGetConversationMessages(c *fiber.Ctx) error {
 		return respondError(c, fiber.StatusBadRequest, "userId is required")
 	}
 
+	limit := c.QueryInt("limit", 0) // latest messages only; 0 returns the whole history
+
 	userThreadLock.Lock()
 	conv, ok := userConversations[userId]
 	var result UserConversation
 	if ok {
 		result = *conv // shallow copy is fine for read
+		if limit > 0 && len(result.Messages) > limit {
+			result.Messages = result.Messages[len(result.Messages)-limit:]
+		}
 	}
 	userThreadLock.Unlock()
 
@@ -2290,6 +2816,9 @@ func handleReleaseConversation(c *fiber.Ctx) error {
 	if conv, ok := userConversations[userId]; ok {
 		conv.Takeover = false
 		conv.WantsHuman = false
+		conv.UrgentSince = time.Time{}
+		resetFailureEscalation(conv)
+		resetModeration(conv)
 	}
 	userThreadLock.Unlock()
 
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MediaStore hosts generated files (annotated photos, quote images) at a public HTTPS URL
+// so they can be sent as LINE image messages, which only accept URLs.
+type MediaStore interface {
+	Put(name, contentType string, data []byte) (string, error)
+}
+
+// localMediaStore writes files under DATA_DIR/media and serves them from /media/:name.
+type localMediaStore struct{}
+
+var mediaDir = "media"
+
+var mediaStore MediaStore = &localMediaStore{}
+
+var mediaNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+\.(jpg|jpeg|png|pdf|txt)$`)
+
+func (s *localMediaStore) Put(name, contentType string, data []byte) (string, error) {
+	baseURL := appConfig.PublicBaseURL
+	if baseURL == "" {
+		return "", errors.New("PUBLIC_BASE_URL is not configured")
+	}
+	if !mediaNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid media name %q", name)
+	}
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create media dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(mediaDir, name), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write media file: %w", err)
+	}
+	return baseURL + "/media/" + name, nil
+}
+
+// newMediaName returns a random, unguessable file name with the given extension.
+func newMediaName(prefix, ext string) string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return prefix + "_" + strings.ReplaceAll(getBangkokTime(), ":", "") + "." + ext
+	}
+	return prefix + "_" + hex.EncodeToString(buf) + "." + ext
+}
+
+// Files holding personal data (chat exports) are private: their links carry an expiry and
+// an HMAC signature, they are served with no-store, and they are deleted when they expire.
+// The signing key is made at startup, so a restart also ends the links.
+
+// privateMediaPrefix marks the media names that need a signed link.
+const privateMediaPrefix = "chat_"
+
+var mediaSigningKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return key
+}()
+
+func isPrivateMedia(name string) bool {
+	return strings.HasPrefix(name, privateMediaPrefix)
+}
+
+func mediaSignature(name string, expires int64) string {
+	mac := hmac.New(sha256.New, mediaSigningKey)
+	fmt.Fprintf(mac, "%s|%d", name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signMediaURL makes the URL of a private media file valid until expires.
+func signMediaURL(url, name string, expires time.Time) string {
+	return fmt.Sprintf("%s?expires=%d&sig=%s", url, expires.Unix(), mediaSignature(name, expires.Unix()))
+}
+
+// validMediaSignature checks the expiry and signature of a link to a private file.
+func validMediaSignature(name, expires, sig string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(mediaSignature(name, exp)))
+}
+
+func handleGetMedia(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !mediaNamePattern.MatchString(name) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	private := isPrivateMedia(name)
+	if private && !validMediaSignature(name, c.Query("expires"), c.Query("sig")) {
+		appMetrics.inc("media_link_rejected")
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	data, err := os.ReadFile(filepath.Join(mediaDir, name))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		c.Set("Content-Type", "image/png")
+	case ".pdf":
+		c.Set("Content-Type", "application/pdf")
+	case ".txt":
+		c.Set("Content-Type", "text/plain; charset=utf-8")
+	default:
+		c.Set("Content-Type", "image/jpeg")
+	}
+	if private {
+		c.Set("Cache-Control", "private, no-store")
+	} else {
+		c.Set("Cache-Control", "public, max-age=86400")
+	}
+	return c.Send(data)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NCS Family Members signed up in the shop are kept in a members table outside the bot. With
+// MEMBERS_DATABASE_URL (Postgres, server built with -tags postgres) or MEMBERS_SHEET_URL (the
+// CSV export link of a Google Sheet) set, the check_membership tool looks customers up there
+// by LINE user ID or phone, and a match is copied to the customer profile so get_ncs_pricing
+// gives member prices from then on. While a directory is configured, a customer_type of
+// "member" from the model is only honoured for customers the directory knows.
+
+// MemberRecord is one row of the members table.
+type MemberRecord struct {
+	UserID        string `json:"user_id,omitempty"`
+	Phone         string `json:"phone,omitempty"`
+	FullName      string `json:"full_name,omitempty"`
+	Tier          string `json:"tier"`
+	CouponBalance int    `json:"coupon_balance"`       // coupons left on the member's package
+	ExpiresAt     string `json:"expires_at,omitempty"` // YYYY-MM-DD, "" for no expiry
+}
+
+// active reports whether the membership is valid today.
+func (m MemberRecord) active() bool {
+	return !noMembershipValues[strings.ToLower(m.Tier)] && (m.ExpiresAt == "" || m.ExpiresAt >= bangkokNow().Format("2006-01-02"))
+}
+
+// MemberDirectory looks up members by LINE user ID or phone; either may be empty.
+type MemberDirectory interface {
+	Lookup(ctx context.Context, userId, phone string) (MemberRecord, bool, error)
+}
+
+// memberDirectory is nil when no members table is configured.
+var memberDirectory MemberDirectory
+
+const membersSheetTTL = 5 * time.Minute
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// startMemberDirectory opens the members table from MEMBERS_DATABASE_URL or MEMBERS_SHEET_URL.
+func startMemberDirectory() {
+	if dsn := appConfig.MembersDatabaseURL; dsn != "" {
+		table := appConfig.MembersTable
+		switch {
+		case !slices.Contains(sql.Drivers(), "pgx"):
+			log.Printf("MEMBERS_DATABASE_URL is set but the server was built without -tags postgres; check_membership is disabled")
+		case !sqlIdentifier.MatchString(table):
+			log.Printf("Invalid MEMBERS_TABLE %q; check_membership is disabled", table)
+		default:
+			db, err := sql.Open("pgx", dsn)
+			if err != nil {
+				log.Printf("Failed to open the members database: %v", err)
+				return
+			}
+			memberDirectory = &sqlMemberDirectory{db: db, table: table}
+			log.Printf("Looking up members in Postgres table %s", table)
+		}
+		return
+	}
+	if url := appConfig.MembersSheetURL; url != "" {
+		memberDirectory = &sheetMemberDirectory{url: url}
+		log.Printf("Looking up members in the members sheet")
+	}
+}
+
+// sqlMemberDirectory reads a Postgres table with the columns line_user_id, phone, full_name,
+// tier, coupon_balance and expires_at (DATE, NULL for no expiry).
+type sqlMemberDirectory struct {
+	db    *sql.DB
+	table string
+}
+
+func (d *sqlMemberDirectory) Lookup(ctx context.Context, userId, phone string) (MemberRecord, bool, error) {
+	if userId == "" && phone == "" {
+		return MemberRecord{}, false, nil
+	}
+	// phones are compared by digits, so "081-234-5678" and "+66812345678" both match
+	query := `SELECT COALESCE(line_user_id, ''), COALESCE(phone, ''), COALESCE(full_name, ''), COALESCE(tier, ''),
+		COALESCE(coupon_balance, 0), COALESCE(to_char(expires_at, 'YYYY-MM-DD'), '')
+		FROM ` + d.table + `
+		WHERE ($1 <> '' AND line_user_id = $1)
+		   OR ($2 <> '' AND regexp_replace(phone, '[^0-9]', '', 'g') IN ($2, '66' || substr($2, 2)))
+		ORDER BY (line_user_id = $1) DESC, expires_at DESC NULLS FIRST
+		LIMIT 1`
+	var m MemberRecord
+	err := d.db.QueryRowContext(ctx, query, userId, phone).Scan(&m.UserID, &m.Phone, &m.FullName, &m.Tier, &m.CouponBalance, &m.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MemberRecord{}, false, nil
+	}
+	if err != nil {
+		return MemberRecord{}, false, &UpstreamError{Service: "members", Err: err}
+	}
+	m.Phone = extractThaiPhone(m.Phone)
+	return m, true, nil
+}
+
+// sheetMemberDirectory reads the CSV export of a Google Sheet, cached for membersSheetTTL.
+// Headers follow the customer import (line_user_id, phone, full_name, tier) plus
+// coupon_balance and expires_at, in English or Thai.
+type sheetMemberDirectory struct {
+	url string
+
+	mu        sync.Mutex
+	records   []MemberRecord
+	fetchedAt time.Time
+}
+
+// membersSheetColumns maps accepted header spellings (lower-cased) to canonical column names.
+var membersSheetColumns = map[string]string{
+	"coupon_balance": "coupon_balance",
+	"coupons":        "coupon_balance",
+	"คูปองคงเหลือ":   "coupon_balance",
+	"expires_at":     "expires_at",
+	"expiry":         "expires_at",
+	"วันหมดอายุ":     "expires_at",
+}
+
+func membersSheetColumn(header string) string {
+	h := strings.ToLower(strings.TrimSpace(header))
+	if col, ok := membersSheetColumns[h]; ok {
+		return col
+	}
+	return customerImportColumns[h]
+}
+
+func (d *sheetMemberDirectory) Lookup(ctx context.Context, userId, phone string) (MemberRecord, bool, error) {
+	if userId == "" && phone == "" {
+		return MemberRecord{}, false, nil
+	}
+	records, err := d.load(ctx)
+	if err != nil {
+		return MemberRecord{}, false, err
+	}
+	var byPhone *MemberRecord
+	for i, m := range records {
+		if userId != "" && m.UserID == userId {
+			return m, true, nil
+		}
+		if phone != "" && m.Phone == phone && byPhone == nil {
+			byPhone = &records[i]
+		}
+	}
+	if byPhone != nil {
+		return *byPhone, true, nil
+	}
+	return MemberRecord{}, false, nil
+}
+
+func (d *sheetMemberDirectory) load(ctx context.Context) ([]MemberRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.records != nil && time.Since(d.fetchedAt) < membersSheetTTL {
+		return d.records, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, classifyRequestError("members", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &UpstreamError{Service: "members", StatusCode: resp.StatusCode, Err: errors.New(string(body))}
+	}
+	records, err := parseMembersSheet(body)
+	if err != nil {
+		return nil, &UpstreamError{Service: "members", Err: err}
+	}
+	d.records, d.fetchedAt = records, time.Now()
+	appMetrics.setGauge("members_sheet_rows", float64(len(records)))
+	return records, nil
+}
+
+// parseMembersSheet reads the members CSV, skipping rows it can't use.
+func parseMembersSheet(data []byte) ([]MemberRecord, error) {
+	rows, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid members CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("members sheet is empty")
+	}
+	index := make(map[string]int)
+	for i, h := range rows[0] {
+		if col := membersSheetColumn(h); col != "" {
+			if _, dup := index[col]; !dup {
+				index[col] = i
+			}
+		}
+	}
+	if _, ok := index["membership_tier"]; !ok {
+		return nil, fmt.Errorf("members sheet needs a tier column")
+	}
+	records := make([]MemberRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		cell := func(col string) string {
+			if i, ok := index[col]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		m := MemberRecord{
+			UserID:   cell("user_id"),
+			Phone:    extractThaiPhone(cell("phone")),
+			FullName: cell("full_name"),
+			Tier:     cell("membership_tier"),
+		}
+		if m.UserID == "" && m.Phone == "" {
+			continue
+		}
+		if v := cell("coupon_balance"); v != "" {
+			m.CouponBalance, _ = strconv.Atoi(convertThaiDigits(v))
+		}
+		if v := cell("expires_at"); v != "" {
+			expires, err := parseImportDate(v)
+			if err != nil {
+				log.Printf("Members sheet row %d: %v", n+2, err)
+				continue
+			}
+			m.ExpiresAt = expires
+		}
+		records = append(records, m)
+	}
+	return records, nil
+}
+
+// lookupMember asks the directory about the user, by their LINE ID and the given phone or,
+// without one, the phone on their profile.
+func lookupMember(userId, phone string) (MemberRecord, bool, error) {
+	if phone == "" {
+		userThreadLock.Lock()
+		if conv, ok := userConversations[userId]; ok {
+			phone = conv.Profile.Phone
+		}
+		userThreadLock.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	m, found, err := memberDirectory.Lookup(ctx, userId, phone)
+	if err != nil {
+		appMetrics.inc("member_lookup_failures")
+		return m, false, err
+	}
+	appMetrics.inc("member_lookups")
+	if found && m.active() {
+		applyMemberRecord(userId, m)
+	}
+	return m, found, nil
+}
+
+// applyMemberRecord copies an active member's tier, and the phone and name if missing, to
+// the customer profile.
+func applyMemberRecord(userId string, m MemberRecord) {
+	tier := strings.ToLower(m.Tier)
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		conv = &UserConversation{UserID: userId}
+		userConversations[userId] = conv
+	}
+	changed := conv.Profile.MembershipTier != tier
+	conv.Profile.MembershipTier = tier
+	if conv.Profile.MemberSince.IsZero() {
+		conv.Profile.MemberSince = time.Now()
+	}
+	if conv.Profile.Phone == "" {
+		conv.Profile.Phone = m.Phone
+	}
+	if conv.Profile.FullName == "" {
+		conv.Profile.FullName = m.FullName
+	}
+	if changed {
+		delete(userAnswerCache, userId) // cached answers may quote non-member prices
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	if changed {
+		log.Printf("Member record of user %s found in the members directory (tier %s)", userId, tier)
+		appMetrics.inc("members_verified")
+	}
+}
+
+// checkMembership handles the check_membership tool.
+func checkMembership(userId, phone string) (string, error) {
+	if memberDirectory == nil {
+		return getMembershipInfo(userId), nil
+	}
+	normalized := ""
+	if strings.TrimSpace(phone) != "" {
+		if normalized = extractThaiPhone(phone); normalized == "" {
+			return "เบอร์โทรไม่ถูกต้อง ขอเบอร์ 9-10 หลักที่ใช้สมัครสมาชิกจากลูกค้าอีกครั้ง", &ToolError{Tool: "check_membership", Err: fmt.Errorf("invalid phone")}
+		}
+	}
+	m, found, err := lookupMember(userId, normalized)
+	if err != nil {
+		return "ตรวจสอบข้อมูลสมาชิกไม่ได้ในขณะนี้ ใช้ราคาปกติไปก่อน และแจ้งลูกค้าว่าเจ้าหน้าที่จะตรวจสอบสิทธิ์สมาชิกให้", err
+	}
+	if !found {
+		if normalized == "" {
+			return "ไม่พบข้อมูลสมาชิกจากบัญชี LINE นี้ ขอเบอร์โทรที่ใช้สมัครสมาชิกแล้วเรียก check_membership อีกครั้ง ระหว่างนี้ใช้ราคาปกติ", nil
+		}
+		return "ไม่พบข้อมูลสมาชิกจากบัญชี LINE และเบอร์นี้ ใช้ราคาปกติ หากลูกค้ายืนยันว่าเป็นสมาชิก แจ้งว่าเจ้าหน้าที่จะตรวจสอบให้ หรือแนะนำสมัครสมาชิกด้วย get_membership_info", nil
+	}
+	if !m.active() {
+		return fmt.Sprintf("ลูกค้าเคยเป็นสมาชิก แต่สมาชิกหมดอายุแล้วเมื่อ %s ใช้ราคาปกติ และแนะนำต่ออายุสมาชิกด้วย get_membership_info", formatThaiDate(m.ExpiresAt)), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ลูกค้าเป็นสมาชิก NCS Family Member ระดับ %s", m.Tier)
+	if m.ExpiresAt != "" {
+		fmt.Fprintf(&b, " หมดอายุ %s", formatThaiDate(m.ExpiresAt))
+	}
+	fmt.Fprintf(&b, "\nคูปองคงเหลือ %d ใบ", m.CouponBalance)
+	b.WriteString("\nget_ncs_pricing จะให้ราคาสมาชิกกับลูกค้าคนนี้โดยอัตโนมัติแล้ว")
+	return b.String(), nil
+}
+
+// verifiedCustomerType returns the customer type to price with: members get member prices,
+// and while a members directory is configured a claimed "member" is only honoured for
+// customers the directory knows. The note tells the model when a claim was not honoured.
+func verifiedCustomerType(userId, requested string) (string, string) {
+	if isMember(userId) {
+		return "member", ""
+	}
+	claimed := strings.Contains(strings.ToLower(requested), "member") || requested == "สมาชิก" || requested == "เมมเบอร์"
+	if !claimed || memberDirectory == nil {
+		return requested, ""
+	}
+	m, found, err := lookupMember(userId, "")
+	switch {
+	case err != nil:
+		log.Printf("Member lookup for %s failed (%s): %v", userId, errorKind(err), err)
+		return "new", "\n⚠️ ตรวจสอบสิทธิ์สมาชิกไม่ได้ในขณะนี้ ราคานี้เป็นราคาปกติ แจ้งลูกค้าว่าเจ้าหน้าที่จะตรวจสอบสิทธิ์ให้"
+	case found && m.active():
+		return "member", ""
+	}
+	return "new", "\n⚠️ ยังไม่พบข้อมูลสมาชิกของลูกค้า ราคานี้เป็นราคาปกติ ถ้าลูกค้าแจ้งว่าเป็นสมาชิก ให้ขอเบอร์ที่ใช้สมัครแล้วเรียก check_membership"
+}
+
+// handleLookupMember lets staff check what the directory holds: ?user_id= and/or ?phone=.
+func handleLookupMember(c *fiber.Ctx) error {
+	if memberDirectory == nil {
+		return respondError(c, fiber.StatusNotFound, "no members directory configured (MEMBERS_DATABASE_URL or MEMBERS_SHEET_URL)")
+	}
+	userId, phone := c.Query("user_id"), extractThaiPhone(c.Query("phone"))
+	if userId == "" && phone == "" {
+		return respondError(c, fiber.StatusBadRequest, "user_id or phone is required")
+	}
+	m, found, err := memberDirectory.Lookup(c.Context(), userId, phone)
+	if err != nil {
+		return respondError(c, fiber.StatusBadGateway, err.Error())
+	}
+	if !found {
+		return respondError(c, fiber.StatusNotFound, "member not found")
+	}
+	return c.JSON(fiber.Map{"member": m, "active": m.active()})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+const memberTier = "family"
+
+var membershipBenefits = []string{
+	"ส่วนลดสูงสุด 50% ทุกบริการทำความสะอาด (ราคาสมาชิก)",
+	"ใช้สิทธิ์ได้ทันทีตั้งแต่การจองครั้งถัดไป",
+	"รับข่าวสารโปรโมชั่นพิเศษสำหรับสมาชิกก่อนใคร",
+}
+
+// membershipFee is the NCS Family Member price in baht (MEMBERSHIP_FEE). 0 means not sold in chat.
+func membershipFee() int {
+	return appConfig.MembershipFee
+}
+
+func isMember(userId string) bool {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	conv, ok := userConversations[userId]
+	return ok && conv.Profile.MembershipTier != ""
+}
+
+// getMembershipInfo handles the get_membership_info tool.
+func getMembershipInfo(userId string) string {
+	if isMember(userId) {
+		return "ลูกค้าเป็นสมาชิก NCS Family Member อยู่แล้ว ใช้ราคาสมาชิกได้ทันที ไม่ต้องสมัครใหม่"
+	}
+	if p, ok := pendingPayment(userId, "membership"); ok {
+		return "ลูกค้าสมัครสมาชิกไว้แล้วและรอชำระเงิน แจ้งวิธีชำระอีกครั้ง:\n" + paymentInstructions(p)
+	}
+	fee := membershipFee()
+	if fee == 0 {
+		return "ยังไม่เปิดสมัครสมาชิกผ่านแชท แจ้งลูกค้าว่าเจ้าหน้าที่จะติดต่อกลับเพื่อแนะนำการสมัครสมาชิก"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "NCS Family Member ค่าสมัคร %s บาท\nสิทธิประโยชน์:", pricing.FormatNumber(fee))
+	for _, benefit := range membershipBenefits {
+		fmt.Fprintf(&b, "\n• %s", benefit)
+	}
+	b.WriteString("\nหากลูกค้าสนใจ ขอชื่อ-นามสกุลและเบอร์โทร แล้วใช้ purchase_membership")
+	return b.String()
+}
+
+func membershipConfirmationSummary(args map[string]interface{}) string {
+	name, _ := args["full_name"].(string)
+	phone, _ := args["phone"].(string)
+	return fmt.Sprintf("สมัครสมาชิก NCS Family Member ในชื่อ %s เบอร์ %s ค่าสมัคร %s บาท",
+		name, phone, pricing.FormatNumber(membershipFee()))
+}
+
+// purchaseMembership handles the confirmed purchase_membership tool: it stores the member's
+// details and opens a payment. Membership starts when the payment is marked paid.
+func purchaseMembership(userId, fullName, phone string) (string, error) {
+	if isMember(userId) {
+		return "ลูกค้าเป็นสมาชิกอยู่แล้ว ไม่ต้องชำระเงิน", nil
+	}
+	fee := membershipFee()
+	if fee == 0 {
+		return "ยังไม่เปิดสมัครสมาชิกผ่านแชท", &ToolError{Tool: "purchase_membership", Err: fmt.Errorf("MEMBERSHIP_FEE not set")}
+	}
+	fullName = strings.TrimSpace(fullName)
+	normalizedPhone := extractThaiPhone(phone)
+	if fullName == "" || normalizedPhone == "" {
+		return "ต้องมีชื่อ-นามสกุลและเบอร์โทร 10 หลักที่ถูกต้องก่อนสมัคร", &ToolError{Tool: "purchase_membership", Err: fmt.Errorf("invalid name or phone")}
+	}
+	if p, ok := pendingPayment(userId, "membership"); ok {
+		return "มีรายการสมัครที่รอชำระอยู่แล้ว:\n" + paymentInstructions(p), nil
+	}
+
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.Profile.FullName = fullName
+		conv.Profile.Phone = normalizedPhone
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	if claimImportedCustomer(userId, normalizedPhone) && isMember(userId) {
+		return "พบข้อมูลสมาชิกเดิมของลูกค้าจากเบอร์โทรนี้ ลูกค้าเป็นสมาชิกอยู่แล้ว ไม่ต้องชำระเงิน ใช้ราคาสมาชิกได้ทันที", nil
+	}
+
+	p := createPayment(userId, "membership", memberTier, fee)
+	return "สร้างรายการสมัครสมาชิกแล้ว แจ้งลูกค้าให้ชำระเงินตามนี้ สมาชิกจะเริ่มใช้ได้ทันทีที่ยืนยันการชำระ:\n" + paymentInstructions(p), nil
+}
+
+// activateMembership is the paid handler for membership payments.
+func activateMembership(p Payment) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[p.UserID]
+	if !ok {
+		conv = &UserConversation{UserID: p.UserID}
+		userConversations[p.UserID] = conv
+	}
+	conv.Profile.MembershipTier = memberTier
+	conv.Profile.MemberSince = time.Now()
+	delete(userAnswerCache, p.UserID) // cached answers may quote non-member prices
+	userThreadLock.Unlock()
+	go saveConversations()
+	log.Printf("Activated membership for user %s (payment %s)", p.UserID, p.ID)
+	appMetrics.inc("memberships_activated")
+
+	msg := "🎉 ยินดีต้อนรับสู่ NCS Family Member ค่ะ! ได้รับชำระเงินเรียบร้อยแล้ว ตั้งแต่นี้ราคาที่แจ้งในแชทจะเป็นราคาสมาชิกทันทีนะคะ"
+	if err := pushLineMessageWithPriority(p.UserID, msg, pushTransactional, "membership"); err != nil {
+		log.Printf("Failed to send membership welcome to %s: %v", p.UserID, err)
+	}
+}
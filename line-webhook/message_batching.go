@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A customer's messages are buffered and answered in one assistant turn. How long the buffer
+// waits adapts to the message: a complete question or a booking request is answered at once,
+// anything else after BUFFER_WINDOW_SECONDS. While the customer still seems to be typing
+// (messages in quick succession, or a fragment such as "แล้วก็") the window is extended by
+// BUFFER_TYPING_EXTRA_SECONDS. No message waits longer than BUFFER_MAX_WAIT_SECONDS after the
+// first one in the buffer.
+
+var (
+	userBufferStarted = make(map[string]time.Time) // first buffered message; guarded by userThreadLock
+	userBufferLastAt  = make(map[string]time.Time) // latest buffered message; guarded by userThreadLock
+)
+
+func bufferWindow() time.Duration      { return appConfig.BufferWindow }
+func bufferTypingExtra() time.Duration { return appConfig.BufferTypingExtra }
+func bufferMaxWait() time.Duration     { return appConfig.BufferMaxWait }
+
+// bufferTypingGap is how soon after the previous message a new one counts as a burst.
+const bufferTypingGap = 6 * time.Second
+
+// politeEndings are stripped before looking for a question word at the end of a message.
+var politeEndings = []string{"คะ", "ค่ะ", "คับ", "ครับ", "ค่า", "จ้า", "จ้ะ", "นะ", "ป่ะ"}
+
+var questionEndings = []string{
+	"ไหม", "มั้ย", "มั๊ย", "หรือเปล่า", "รึเปล่า", "หรือยัง", "รึยัง",
+	"เท่าไหร่", "เท่าไร", "กี่บาท", "ยังไง", "อย่างไร", "ที่ไหน", "เมื่อไหร่", "เมื่อไร", "วันไหน",
+}
+
+var bookingKeywords = []string{"จอง", "นัดวัน", "นัดคิว", "ว่างวันไหน", "คิวว่าง", "book"}
+
+// unfinishedEndings mark a message the customer is likely to continue in the next one.
+var unfinishedEndings = []string{",", "...", "แล้วก็", "และ", "กับ", "แต่", "ส่วน", "คือ"}
+
+// looksLikeCompleteQuestion reports whether a message can be answered without waiting for
+// more: it ends with a question mark or question word, or asks to book.
+func looksLikeCompleteQuestion(msg string) bool {
+	if strings.Contains(msg, "data:image") {
+		return false // photos usually come before the question about them
+	}
+	text := strings.ToLower(strings.TrimSpace(normalizeInboundText(msg)))
+	if text == "" {
+		return false
+	}
+	for _, kw := range bookingKeywords {
+		if strings.Contains(text, kw) {
+			return true
+		}
+	}
+	if strings.HasSuffix(text, "?") || strings.HasSuffix(text, "？") {
+		return true
+	}
+	for trimmed := true; trimmed; {
+		trimmed = false
+		text = strings.TrimRight(text, " !.~")
+		for _, p := range politeEndings {
+			if strings.HasSuffix(text, p) {
+				text, trimmed = strings.TrimSuffix(text, p), true
+			}
+		}
+	}
+	for _, q := range questionEndings {
+		if strings.HasSuffix(text, q) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksUnfinished reports whether a message reads like the start of a longer one.
+func looksUnfinished(msg string) bool {
+	text := strings.TrimSpace(normalizeInboundText(msg))
+	if strings.Contains(msg, "data:image") || utf8.RuneCountInString(text) <= 3 {
+		return true
+	}
+	for _, e := range unfinishedEndings {
+		if strings.HasSuffix(text, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextBufferDelayLocked returns how long to wait before answering the user's buffer now that
+// msg has been added to it; 0 means answer at once. Caller holds userThreadLock.
+func nextBufferDelayLocked(userId, msg string) time.Duration {
+	now := time.Now()
+	started, ok := userBufferStarted[userId]
+	if !ok || len(userMsgBuffer[userId]) <= 1 {
+		// a fresh buffer; fast paths may have emptied it without a flush
+		started = now
+		userBufferStarted[userId] = now
+	}
+	last, typing := userBufferLastAt[userId]
+	typing = typing && now.Sub(last) < bufferTypingGap
+	userBufferLastAt[userId] = now
+
+	if looksLikeCompleteQuestion(msg) {
+		appMetrics.inc("buffer_complete_question_flushes")
+		return 0
+	}
+	delay := bufferWindow()
+	if typing || looksUnfinished(msg) {
+		delay += bufferTypingExtra()
+		appMetrics.inc("buffer_window_extended")
+	}
+	if remaining := started.Add(bufferMaxWait()).Sub(now); delay > remaining {
+		delay = remaining
+		if delay < 0 {
+			delay = 0
+		}
+	}
+	return delay
+}
+
+// takeBufferWaitLocked clears the user's buffer timing and returns how long the oldest
+// message waited. Caller holds userThreadLock.
+func takeBufferWaitLocked(userId string) (time.Duration, bool) {
+	started, ok := userBufferStarted[userId]
+	delete(userBufferStarted, userId)
+	delete(userBufferLastAt, userId)
+	if !ok {
+		return 0, false
+	}
+	return time.Since(started), true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metricsRegistry keeps simple in-process counters, gauges and timing summaries
+// that are exposed to operators via /admin/metrics.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	timings  map[string]*timingSummary
+	started  time.Time
+}
+
+type timingSummary struct {
+	Count   int64   `json:"count"`
+	TotalMs float64 `json:"total_ms"`
+	MaxMs   float64 `json:"max_ms"`
+}
+
+var appMetrics = &metricsRegistry{
+	counters: make(map[string]int64),
+	gauges:   make(map[string]float64),
+	timings:  make(map[string]*timingSummary),
+	started:  time.Now(),
+}
+
+func (m *metricsRegistry) inc(name string) {
+	m.add(name, 1)
+}
+
+func (m *metricsRegistry) add(name string, delta int64) {
+	m.mu.Lock()
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) setGauge(name string, value float64) {
+	m.mu.Lock()
+	m.gauges[name] = value
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) observe(name string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	m.mu.Lock()
+	t, ok := m.timings[name]
+	if !ok {
+		t = &timingSummary{}
+		m.timings[name] = t
+	}
+	t.Count++
+	t.TotalMs += ms
+	if ms > t.MaxMs {
+		t.MaxMs = ms
+	}
+	m.mu.Unlock()
+}
+
+// snapshot returns a copy of all metrics suitable for JSON encoding.
+func (m *metricsRegistry) snapshot() fiber.Map {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters := make(map[string]int64, len(m.counters))
+	for k, v := range m.counters {
+		counters[k] = v
+	}
+	gauges := make(map[string]float64, len(m.gauges))
+	for k, v := range m.gauges {
+		gauges[k] = v
+	}
+	timings := make(map[string]fiber.Map, len(m.timings))
+	for k, t := range m.timings {
+		avg := 0.0
+		if t.Count > 0 {
+			avg = t.TotalMs / float64(t.Count)
+		}
+		timings[k] = fiber.Map{"count": t.Count, "avg_ms": avg, "max_ms": t.MaxMs}
+	}
+	names := make([]string, 0, len(counters)+len(gauges)+len(timings))
+	for k := range counters {
+		names = append(names, k)
+	}
+	for k := range gauges {
+		names = append(names, k)
+	}
+	for k := range timings {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return fiber.Map{
+		"uptime_seconds": int64(time.Since(m.started).Seconds()),
+		"counters":       counters,
+		"gauges":         gauges,
+		"timings":        timings,
+		"names":          names,
+	}
+}
+
+func handleGetMetrics(c *fiber.Ctx) error {
+	return c.JSON(appMetrics.snapshot())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Model calls that must answer with data ask for structured output (a JSON schema in the
+// request), but gateways and older models may ignore it and wrap the JSON in prose or code
+// fences, send several objects, or stop mid-object at the token limit. decodeModelJSON reads
+// all of those instead of failing on anything that isn't one bare object.
+
+var errNoModelJSON = errors.New("no JSON object in model output")
+
+// requestOpenAIJSON is requestOpenAIText with structured output: the reply must follow schema
+// and is decoded into dest.
+func requestOpenAIJSON(ctx context.Context, model, instructions string, input interface{}, schema *openai.JSONSchema, dest interface{}) error {
+	text, err := requestOpenAI(ctx, model, instructions, input, schema)
+	if err != nil {
+		return err
+	}
+	if err := decodeModelJSON(text, dest); err != nil {
+		appMetrics.inc("model_json_invalid")
+		return fmt.Errorf("unexpected %s output %q: %w", schema.Name, text, err)
+	}
+	return nil
+}
+
+// decodeModelJSON decodes the first JSON object in text that fits dest (a pointer). Complete
+// objects are tried first, then a truncated trailing object cut back to its last complete
+// value. dest is only changed on success.
+func decodeModelJSON(text string, dest interface{}) error {
+	complete, partial := scanJSONObjects(text)
+	lastErr := errNoModelJSON
+	for _, candidate := range append(complete, partial...) {
+		v := reflect.New(reflect.TypeOf(dest).Elem())
+		if err := json.Unmarshal([]byte(candidate), v.Interface()); err != nil {
+			lastErr = err
+			continue
+		}
+		reflect.ValueOf(dest).Elem().Set(v.Elem())
+		return nil
+	}
+	return lastErr
+}
+
+// jsonCut is a place a truncated object can be cut and still be closed into valid JSON: after
+// an opening bracket or before a comma, with the brackets still open there.
+type jsonCut struct {
+	end     int
+	closers string
+}
+
+// scanJSONObjects returns the balanced {...} blocks of text in order, and the repaired form of
+// an object left unterminated at the end. Braces inside JSON strings are skipped.
+func scanJSONObjects(text string) (complete, partial []string) {
+	start := -1
+	var stack []byte
+	var cuts []jsonCut
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if start < 0 {
+			if ch == '{' {
+				start, stack, cuts = i, []byte{'}'}, nil
+			}
+			continue
+		}
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{', '[':
+			if ch == '{' {
+				stack = append(stack, '}')
+			} else {
+				stack = append(stack, ']')
+			}
+			cuts = append(cuts, jsonCut{end: i + 1, closers: closersFor(stack)})
+		case '}', ']':
+			if ch != stack[len(stack)-1] {
+				// not JSON after all (e.g. a stray brace in prose): look again after it
+				i, start = start, -1
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				complete = append(complete, text[start:i+1])
+				start = -1
+			}
+		case ',':
+			cuts = append(cuts, jsonCut{end: i, closers: closersFor(stack)})
+		}
+	}
+	if start < 0 {
+		return complete, partial
+	}
+
+	// the last object never closed: it may still contain complete objects, or be a truncated one
+	innerComplete, innerPartial := scanJSONObjects(text[start+1:])
+	complete = append(complete, innerComplete...)
+	tail := strings.TrimRight(text[start:], " \t\r\n")
+	if inString {
+		tail += `"`
+	}
+	if repaired := tail + closersFor(stack); json.Valid([]byte(repaired)) {
+		partial = append(partial, repaired)
+	} else {
+		for j := len(cuts) - 1; j >= 0; j-- {
+			if repaired := text[start:cuts[j].end] + cuts[j].closers; json.Valid([]byte(repaired)) {
+				partial = append(partial, repaired)
+				break
+			}
+		}
+	}
+	return complete, append(partial, innerPartial...)
+}
+
+// closersFor returns the brackets that close the open ones, innermost first.
+func closersFor(stack []byte) string {
+	b := make([]byte, len(stack))
+	for i, c := range stack {
+		b[len(stack)-1-i] = c
+	}
+	return string(b)
+}
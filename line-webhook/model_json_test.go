@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type testIntent struct {
+	Intent     string   `json:"intent"`
+	Confidence float64  `json:"confidence"`
+	Services   []string `json:"services"`
+}
+
+func TestDecodeModelJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want testIntent
+	}{
+		{"bare object", `{"intent":"booking","confidence":0.9}`,
+			testIntent{Intent: "booking", Confidence: 0.9}},
+		{"fenced", "```json\n{\"intent\":\"pricing\",\"confidence\":0.7}\n```",
+			testIntent{Intent: "pricing", Confidence: 0.7}},
+		{"fenced without language", "```\n{\"intent\":\"pricing\"}\n```",
+			testIntent{Intent: "pricing"}},
+		{"prose around", `Sure! Here is the result: {"intent":"complaint","confidence":1} Let me know if you need more.`,
+			testIntent{Intent: "complaint", Confidence: 1}},
+		{"braces inside strings", `{"intent":"other {not json}","services":["sofa}"]}`,
+			testIntent{Intent: "other {not json}", Services: []string{"sofa}"}}},
+		{"stray brace in prose", `I think } the answer is {"intent":"booking"}`,
+			testIntent{Intent: "booking"}},
+		{"several objects, first fits", `{"intent":"booking"} {"intent":"pricing"}`,
+			testIntent{Intent: "booking"}},
+		{"first object does not fit the schema", `{"intent":["a","b"]} {"intent":"pricing"}`,
+			testIntent{Intent: "pricing"}},
+		{"truncated mid value", `{"intent":"booking","services":["sofa","mattr`,
+			testIntent{Intent: "booking", Services: []string{"sofa", "mattr"}}},
+		{"truncated after comma", `{"intent":"booking","confidence":0.8,`,
+			testIntent{Intent: "booking", Confidence: 0.8}},
+		{"truncated mid key", `{"intent":"booking","confi`,
+			testIntent{Intent: "booking"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got testIntent
+			if err := decodeModelJSON(tt.text, &got); err != nil {
+				t.Fatalf("decodeModelJSON(%q) error: %v", tt.text, err)
+			}
+			if got.Intent != tt.want.Intent || got.Confidence != tt.want.Confidence || len(got.Services) != len(tt.want.Services) {
+				t.Fatalf("decodeModelJSON(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+			for i := range got.Services {
+				if got.Services[i] != tt.want.Services[i] {
+					t.Errorf("decodeModelJSON(%q) = %+v, want %+v", tt.text, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeModelJSONErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		noJSON bool
+	}{
+		{"empty", "", true},
+		{"prose only", "ขออภัยค่ะ ไม่สามารถตอบได้", true},
+		{"array only", `["booking"]`, true},
+		{"invalid JSON", `{"intent": booking}`, false},
+		{"schema mismatch", `{"intent":"booking","confidence":"high"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := testIntent{Intent: "unchanged"}
+			err := decodeModelJSON(tt.text, &dest)
+			if err == nil {
+				t.Fatalf("decodeModelJSON(%q) = %+v, want an error", tt.text, dest)
+			}
+			if errors.Is(err, errNoModelJSON) != tt.noJSON {
+				t.Errorf("decodeModelJSON(%q) error = %v, want errNoModelJSON: %v", tt.text, err, tt.noJSON)
+			}
+			if dest.Intent != "unchanged" {
+				t.Errorf("decodeModelJSON(%q) changed dest to %+v on failure", tt.text, dest)
+			}
+		})
+	}
+}
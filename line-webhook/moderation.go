@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// Customer text is checked before an assistant run is started for it. Messages containing a
+// phrase from MODERATION_BLOCKLIST (spam, scams, abuse the team has seen) and, with
+// MODERATION_OPENAI=true, messages the OpenAI moderation endpoint flags get a polite canned
+// reply instead of a model call. Customers flagged MODERATION_STRIKE_LIMIT times are marked
+// for staff, who clear the mark by releasing the conversation. A failing moderation call
+// lets the message through.
+
+const moderationReply = "ขออภัยค่ะ ข้อความนี้อยู่นอกขอบเขตที่แอดมินอัตโนมัติตอบได้ 🙏 หากต้องการสอบถามเรื่องบริการทำความสะอาดที่นอน โซฟา ม่าน หรือพรม พิมพ์คำถามได้เลยค่ะ หรือพิมพ์ \"ขอคุยกับเจ้าหน้าที่\" เพื่อคุยกับทีมงาน"
+
+// moderationStrikeLimit is MODERATION_STRIKE_LIMIT (default 3; 0 never flags).
+func moderationStrikeLimit() int {
+	return appConfig.ModerationStrikeLimit
+}
+
+// moderationBlocklist is MODERATION_BLOCKLIST: comma-separated phrases, matched against
+// normalized, lower-cased text.
+func moderationBlocklist() []string {
+	var phrases []string
+	for _, p := range appConfig.ModerationBlocklist {
+		if p = strings.ToLower(normalizeInboundText(p)); p != "" {
+			phrases = append(phrases, p)
+		}
+	}
+	return phrases
+}
+
+func moderationUsesOpenAI() bool {
+	return appConfig.ModerationOpenAI
+}
+
+// moderationTexts returns the typed or transcribed messages of a turn; photos are skipped.
+func moderationTexts(msgs []string) []string {
+	var texts []string
+	for _, m := range msgs {
+		if strings.TrimSpace(m) != "" && !strings.Contains(m, "data:image") {
+			texts = append(texts, m)
+		}
+	}
+	return texts
+}
+
+// moderateMessages returns why the messages should not reach the assistant, or "".
+func moderateMessages(ctx context.Context, msgs []string) string {
+	texts := moderationTexts(msgs)
+	if len(texts) == 0 {
+		return ""
+	}
+	if phrases := moderationBlocklist(); len(phrases) > 0 {
+		for _, text := range texts {
+			lower := strings.ToLower(normalizeInboundText(text))
+			for _, p := range phrases {
+				if strings.Contains(lower, p) {
+					return "blocklist: " + p
+				}
+			}
+		}
+	}
+	if !moderationUsesOpenAI() {
+		return ""
+	}
+	client, err := newOpenAIClient(10 * time.Second)
+	if err != nil {
+		return ""
+	}
+	results, err := client.Moderate(ctx, &openai.ModerationRequest{Input: texts})
+	if err != nil {
+		log.Printf("Moderation check failed; letting the message through: %v", openAIError(err))
+		appMetrics.inc("moderation_errors")
+		return ""
+	}
+	for _, r := range results {
+		if !r.Flagged {
+			continue
+		}
+		var categories []string
+		for name, hit := range r.Categories {
+			if hit {
+				categories = append(categories, name)
+			}
+		}
+		sort.Strings(categories)
+		return "openai: " + strings.Join(categories, ", ")
+	}
+	return ""
+}
+
+// moderateTurn checks a turn's messages and, when they are blocked, records a strike and
+// returns the canned reply to send instead of running the assistant.
+func moderateTurn(ctx context.Context, userId string, msgs []string) (string, bool) {
+	reason := moderateMessages(ctx, msgs)
+	if reason == "" {
+		return "", false
+	}
+	appMetrics.inc("moderation_blocked")
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	if conv == nil {
+		userThreadLock.Unlock()
+		return moderationReply, true
+	}
+	conv.ModerationStrikes++
+	strikes, name := conv.ModerationStrikes, conv.DisplayName
+	newlyFlagged := false
+	if limit := moderationStrikeLimit(); limit > 0 && strikes >= limit && !conv.ModerationFlagged {
+		conv.ModerationFlagged = true
+		newlyFlagged = true
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+
+	log.Printf("Blocked a turn from %s before the assistant (%s, strike %d)", userId, reason, strikes)
+	if newlyFlagged {
+		appMetrics.inc("moderation_flagged_users")
+		if name == "" {
+			name = "…" + userId[max(0, len(userId)-8):]
+		}
+		go alertStaff(userId, fmt.Sprintf("🚫 ลูกค้า %s ส่งข้อความที่ถูกกรองแล้ว %d ครั้ง (ล่าสุด: %s)\nบอทจะตอบข้อความแบบนี้ด้วยข้อความสำเร็จรูปต่อไป ปลดสถานะได้ด้วย POST /admin/conversations/%s/release",
+			name, strikes, reason, userId), "moderation")
+	}
+	return moderationReply, true
+}
+
+// resetModeration clears a customer's strikes and flag. Caller must hold userThreadLock.
+func resetModeration(conv *UserConversation) {
+	conv.ModerationStrikes = 0
+	conv.ModerationFlagged = false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// textNormalizer is one stage of the inbound normalization pipeline.
+type textNormalizer struct {
+	name string
+	fn   func(string) string
+}
+
+// inboundNormalizers run in order before intent detection and alias matching.
+// The original text is still what gets stored in the transcript and sent to the model.
+var inboundNormalizers = []textNormalizer{
+	{"unicode_nfc", norm.NFC.String},
+	{"strip_zero_width", stripZeroWidth},
+	{"thai_digits", convertThaiDigits},
+	{"thai_sara_am", fixThaiSaraAm},
+	{"misspellings", correctCommonMisspellings},
+	{"strip_emoji", stripEmoji},
+	{"collapse_space", collapseWhitespace},
+}
+
+// normalizeInboundText applies every normalization stage.
+func normalizeInboundText(s string) string {
+	for _, n := range inboundNormalizers {
+		s = n.fn(s)
+	}
+	return s
+}
+
+var zeroWidthReplacer = strings.NewReplacer(
+	"\u200b", "", // zero width space
+	"\u200c", "", // zero width non-joiner
+	"\u200d", "", // zero width joiner
+	"\u2060", "", // word joiner
+	"\ufeff", "", // byte order mark
+	"\u00ad", "", // soft hyphen
+)
+
+func stripZeroWidth(s string) string {
+	return zeroWidthReplacer.Replace(s)
+}
+
+// convertThaiDigits maps ๐-๙ to ASCII 0-9.
+func convertThaiDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '๐' && r <= '๙' {
+			return '0' + (r - '๐')
+		}
+		return r
+	}, s)
+}
+
+// fixThaiSaraAm composes NIKHAHIT + SARA AA (typed as two keys on some keyboards) into SARA AM,
+// which Unicode normalization does not do.
+func fixThaiSaraAm(s string) string {
+	return strings.ReplaceAll(s, "\u0e4d\u0e32", "\u0e33")
+}
+
+// commonMisspellings maps frequent customer typos to the spelling used in pricing aliases
+// and keyword lists.
+var commonMisspellings = strings.NewReplacer(
+	"ทีนอน", "ที่นอน",
+	"ที่นอล", "ที่นอน",
+	"ทีนอล", "ที่นอน",
+	"โซฟ่า", "โซฟา",
+	"โซฟร", "โซฟา",
+	"ม้าน", "ม่าน",
+	"เชือโรค", "เชื้อโรค",
+	"เชื่อโรค", "เชื้อโรค",
+	"ไรฝุน", "ไรฝุ่น",
+	"ขจัดครบ", "ขจัดคราบ",
+	"ตารางเมตรร", "ตารางเมตร",
+	"ฟุด", "ฟุต",
+)
+
+func correctCommonMisspellings(s string) string {
+	return commonMisspellings.Replace(s)
+}
+
+// isEmojiRune reports whether r is a pictographic emoji or an emoji modifier.
+func isEmojiRune(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // emoticons, pictographs, transport, flags, supplemental symbols
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // misc symbols and dingbats
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF: // arrows and stars (⭐)
+		return true
+	case r == 0xFE0F || r == 0xFE0E: // variation selectors
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // tag characters (subdivision flags)
+		return true
+	}
+	return false
+}
+
+func stripEmoji(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isEmojiRune(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func collapseWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
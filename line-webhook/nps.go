@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NPSSurvey is the "would you recommend us" question sent after a completed booking.
+type NPSSurvey struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	BookingID  string    `json:"booking_id"`
+	SentAt     time.Time `json:"sent_at"`
+	Score      *int      `json:"score,omitempty"` // 0-10, nil until answered
+	AnsweredAt time.Time `json:"answered_at,omitempty"`
+}
+
+// NPSSummary is the rolling Net Promoter Score over answered surveys.
+type NPSSummary struct {
+	WindowDays int     `json:"window_days"`
+	Responses  int     `json:"responses"`
+	Promoters  int     `json:"promoters"`  // 9-10
+	Passives   int     `json:"passives"`   // 7-8
+	Detractors int     `json:"detractors"` // 0-6
+	Score      float64 `json:"score"`      // % promoters - % detractors, -100..100
+	Sent       int     `json:"sent"`
+	AnswerRate float64 `json:"answer_rate"`
+
+	AlertThreshold float64 `json:"alert_threshold"`
+	BelowAlert     bool    `json:"below_alert"` // enough responses and Score < AlertThreshold
+}
+
+type npsState struct {
+	Surveys     []*NPSSurvey `json:"surveys"`
+	LastAlertAt time.Time    `json:"last_alert_at,omitempty"`
+}
+
+var npsFile = "nps.json"
+
+var (
+	npsLock sync.Mutex
+	nps     npsState
+)
+
+const npsQuestion = "ขอบคุณที่ใช้บริการ NCS ค่ะ 🙏 จากประสบการณ์ครั้งนี้ คุณลูกค้ามีแนวโน้มจะแนะนำ NCS ให้เพื่อนหรือคนรู้จักมากน้อยแค่ไหนคะ (0 = ไม่แนะนำเลย, 10 = แนะนำแน่นอน)"
+
+// npsSurveyDelayDays is how long after the service date the survey is sent.
+func npsSurveyDelayDays() int { return appConfig.NPSSurveyDelayDays }
+
+func npsWindowDays() int { return appConfig.NPSWindowDays }
+
+func npsAlertThreshold() float64 { return float64(appConfig.NPSAlertThreshold) }
+
+// npsAlertRecipients are the managers told when the rolling score drops, else the staff list.
+func npsAlertRecipients() []string {
+	if len(appConfig.NPSAlertUserIDs) == 0 {
+		return staffAlertRecipients()
+	}
+	return appConfig.NPSAlertUserIDs
+}
+
+func loadNPS() {
+	data, err := os.ReadFile(npsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read NPS file: %v", err)
+		}
+		return
+	}
+	npsLock.Lock()
+	defer npsLock.Unlock()
+	if err := json.Unmarshal(data, &nps); err != nil {
+		log.Printf("Failed to parse NPS file: %v", err)
+	}
+}
+
+// saveNPS persists surveys. Caller must hold npsLock.
+func saveNPS() {
+	data, err := json.MarshalIndent(nps, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal NPS surveys: %v", err)
+		return
+	}
+	if err := os.WriteFile(npsFile, data, 0644); err != nil {
+		log.Printf("Failed to save NPS surveys: %v", err)
+	}
+}
+
+// npsQuestionMessage asks for a 0-10 score with one postback quick reply per score.
+func npsQuestionMessage(surveyID string) map[string]interface{} {
+	items := make([]map[string]interface{}, 0, 11)
+	for score := 0; score <= 10; score++ {
+		label := strconv.Itoa(score)
+		items = append(items, map[string]interface{}{
+			"type": "action",
+			"action": map[string]interface{}{
+				"type":        "postback",
+				"label":       label,
+				"data":        fmt.Sprintf("action=nps&survey=%s&score=%d", surveyID, score),
+				"displayText": label,
+			},
+		})
+	}
+	return map[string]interface{}{
+		"type":       "text",
+		"text":       npsQuestion,
+		"quickReply": map[string]interface{}{"items": items},
+	}
+}
+
+// sendDueNPSSurveys asks customers whose booking was completed NPS_SURVEY_DELAY_DAYS ago.
+// Each booking is surveyed once, and a customer at most once per rolling window.
+func sendDueNPSSurveys() {
+	dueDate := bangkokNow().AddDate(0, 0, -npsSurveyDelayDays())
+	due := dueDate.Format("2006-01-02")
+	// a week of slack covers missed runs; older completions predate the survey and are skipped
+	oldest := dueDate.AddDate(0, 0, -7).Format("2006-01-02")
+	windowStart := time.Now().AddDate(0, 0, -npsWindowDays())
+
+	npsLock.Lock()
+	surveyedBooking := map[string]bool{}
+	recentlyAsked := map[string]bool{}
+	for _, s := range nps.Surveys {
+		surveyedBooking[s.BookingID] = true
+		if s.SentAt.After(windowStart) {
+			recentlyAsked[s.UserID] = true
+		}
+	}
+	npsLock.Unlock()
+
+	var pending []*Booking
+	bookingLock.Lock()
+	for _, b := range bookings {
+		if b.Status == "completed" && b.Date <= due && b.Date > oldest && !surveyedBooking[b.ID] && !recentlyAsked[b.UserID] && !isSimulatedUser(b.UserID) {
+			pending = append(pending, b)
+			recentlyAsked[b.UserID] = true
+		}
+	}
+	bookingLock.Unlock()
+
+	postponed := 0
+	for _, b := range pending {
+		// each channel has its own quota, so a full one only holds back its own customers
+		if !nonEssentialPushAllowed(b.UserID) {
+			postponed++
+			continue
+		}
+		survey := &NPSSurvey{ID: "nps_" + newConfirmationToken(), UserID: b.UserID, BookingID: b.ID, SentAt: time.Now()}
+		if err := pushLineMessagesAs(b.UserID, []map[string]interface{}{npsQuestionMessage(survey.ID)}, pushNonEssential); err != nil {
+			log.Printf("Failed to send NPS survey to %s: %v", b.UserID, err)
+			continue
+		}
+		npsLock.Lock()
+		nps.Surveys = append(nps.Surveys, survey)
+		saveNPS()
+		npsLock.Unlock()
+		appMetrics.inc("nps_surveys_sent")
+	}
+	if postponed > 0 {
+		log.Printf("LINE quota near limit; postponing %d NPS survey(s)", postponed)
+	}
+}
+
+// handleNPSPostback records a quick reply score.
+func handleNPSPostback(userId, replyToken string, values url.Values) bool {
+	score, err := strconv.Atoi(values.Get("score"))
+	if err != nil || score < 0 || score > 10 {
+		return true
+	}
+	npsLock.Lock()
+	var survey *NPSSurvey
+	for _, s := range nps.Surveys {
+		if s.ID == values.Get("survey") && s.UserID == userId {
+			survey = s
+			break
+		}
+	}
+	if survey == nil {
+		npsLock.Unlock()
+		log.Printf("NPS answer from %s for unknown survey %q", userId, values.Get("survey"))
+		return true
+	}
+	first := survey.Score == nil
+	survey.Score = &score
+	survey.AnsweredAt = time.Now()
+	saveNPS()
+	npsLock.Unlock()
+
+	log.Printf("NPS score %d from %s (booking %s)", score, userId, survey.BookingID)
+	if first {
+		appMetrics.inc("nps_answers")
+		replyOrPush(userId, replyToken, []map[string]interface{}{{"type": "text", "text": "ขอบคุณสำหรับคะแนนและความคิดเห็นค่ะ 🙏 NCS จะนำไปปรับปรุงบริการให้ดียิ่งขึ้นค่ะ"}})
+	}
+	checkNPSAlert()
+	return true
+}
+
+// rollingNPS computes the score over surveys answered in the window.
+func rollingNPS() NPSSummary {
+	window := npsWindowDays()
+	since := time.Now().AddDate(0, 0, -window)
+	summary := NPSSummary{WindowDays: window, AlertThreshold: npsAlertThreshold()}
+	npsLock.Lock()
+	for _, s := range nps.Surveys {
+		if s.SentAt.After(since) {
+			summary.Sent++
+		}
+		if s.Score == nil || s.AnsweredAt.Before(since) {
+			continue
+		}
+		summary.Responses++
+		switch {
+		case *s.Score >= 9:
+			summary.Promoters++
+		case *s.Score >= 7:
+			summary.Passives++
+		default:
+			summary.Detractors++
+		}
+	}
+	npsLock.Unlock()
+	if summary.Responses > 0 {
+		summary.Score = float64(summary.Promoters-summary.Detractors) * 100 / float64(summary.Responses)
+		summary.BelowAlert = summary.Responses >= appConfig.NPSAlertMinResponses && summary.Score < summary.AlertThreshold
+	}
+	if summary.Sent > 0 {
+		summary.AnswerRate = float64(summary.Responses) / float64(summary.Sent)
+	}
+	return summary
+}
+
+// checkNPSAlert tells management when the rolling score falls below NPS_ALERT_THRESHOLD,
+// at most once a week.
+func checkNPSAlert() {
+	summary := rollingNPS()
+	appMetrics.setGauge("nps_rolling", summary.Score)
+	if !summary.BelowAlert {
+		return
+	}
+	npsLock.Lock()
+	if time.Since(nps.LastAlertAt) < 7*24*time.Hour {
+		npsLock.Unlock()
+		return
+	}
+	nps.LastAlertAt = time.Now()
+	saveNPS()
+	npsLock.Unlock()
+
+	alert := fmt.Sprintf("📉 NPS %d วันล่าสุดอยู่ที่ %.0f (ต่ำกว่าเกณฑ์ %.0f)\nผู้ตอบ %d ราย: แนะนำ %d, เฉยๆ %d, ไม่แนะนำ %d",
+		summary.WindowDays, summary.Score, summary.AlertThreshold, summary.Responses, summary.Promoters, summary.Passives, summary.Detractors)
+	for _, id := range npsAlertRecipients() {
+		if err := pushLineMessageWithPriority(id, alert, pushTransactional, "nps_alert"); err != nil {
+			log.Printf("Failed to send NPS alert to %s: %v", id, err)
+		}
+	}
+	appMetrics.inc("nps_alerts")
+}
+
+// startNPSJob sends due surveys daily at 11:00 Bangkok time.
+func startNPSJob() {
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 11, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+			sendDueNPSSurveys()
+			checkNPSAlert()
+		}
+	}()
+}
+
+func handleGetNPSAnalytics(c *fiber.Ctx) error {
+	npsLock.Lock()
+	recent := make([]NPSSurvey, 0)
+	for i := len(nps.Surveys) - 1; i >= 0 && len(recent) < 50; i-- {
+		if nps.Surveys[i].Score != nil {
+			recent = append(recent, *nps.Surveys[i])
+		}
+	}
+	npsLock.Unlock()
+	return c.JSON(fiber.Map{"summary": rollingNPS(), "recent_answers": recent})
+}
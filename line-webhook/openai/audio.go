@@ -0,0 +1,48 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+)
+
+// TranscriptionRequest is the form of POST /audio/transcriptions.
+type TranscriptionRequest struct {
+	Model    string // whisper-1, gpt-4o-transcribe or gpt-4o-mini-transcribe
+	Filename string // the extension tells the API the audio format
+	Audio    []byte
+	Language string // ISO-639-1, e.g. "th"; detected when empty
+	Prompt   string // words and spelling to expect
+}
+
+// Transcribe returns the text spoken in the audio.
+func (c *Client) Transcribe(ctx context.Context, req *TranscriptionRequest) (string, error) {
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	w.WriteField("model", req.Model)
+	w.WriteField("response_format", "json")
+	if req.Language != "" {
+		w.WriteField("language", req.Language)
+	}
+	if req.Prompt != "" {
+		w.WriteField("prompt", req.Prompt)
+	}
+	part, err := w.CreateFormFile("file", req.Filename)
+	if err != nil {
+		return "", err
+	}
+	part.Write(req.Audio)
+	w.Close()
+
+	body, err := c.Do(ctx, "POST", "/audio/transcriptions", &form, w.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Text string `json:"text"`
+	}
+	if err := decode(body, &out); err != nil {
+		return "", err
+	}
+	return out.Text, nil
+}
@@ -0,0 +1,95 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ChatCompletionRequest is the body of POST /chat/completions.
+type ChatCompletionRequest struct {
+	Model               string        `json:"model"`
+	Messages            []ChatMessage `json:"messages"`
+	Tools               []ChatTool    `json:"tools,omitempty"`
+	Temperature         *float64      `json:"temperature,omitempty"`
+	MaxCompletionTokens int           `json:"max_completion_tokens,omitempty"`
+	ResponseFormat      *ChatFormat   `json:"response_format,omitempty"`
+}
+
+// JSONSchema describes the structured output a call must produce. With Strict, every
+// property must be listed as required and objects must not allow additional properties.
+type JSONSchema struct {
+	Name   string      `json:"name"`
+	Schema interface{} `json:"schema"`
+	Strict bool        `json:"strict,omitempty"`
+}
+
+// ChatFormat is the response_format of a chat completion.
+type ChatFormat struct {
+	Type       string      `json:"type"` // "text", "json_object" or "json_schema"
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// ChatMessage is one message of a chat. Content is a string or a list of content parts
+// such as {"type": "text"} and {"type": "image_url"}; assistant messages that call tools
+// may have no content.
+type ChatMessage struct {
+	Role       string         `json:"role"` // system, developer, user, assistant or tool
+	Content    interface{}    `json:"content"`
+	ToolCalls  []ChatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type ChatTool struct {
+	Type     string       `json:"type"` // always "function"
+	Function ChatFunction `json:"function"`
+}
+
+type ChatFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type ChatToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"` // always "function"
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON text
+	} `json:"function"`
+}
+
+// ChatCompletion is the answer of POST /chat/completions.
+type ChatCompletion struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+
+	Body []byte `json:"-"` // the answer as received, for debug logging
+}
+
+// ChatCompletions is the chat part of the API. *Client implements it.
+type ChatCompletions interface {
+	CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletion, error)
+}
+
+var _ ChatCompletions = (*Client)(nil)
+
+func (c *Client) CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletion, error) {
+	var raw json.RawMessage
+	if err := c.doJSON(ctx, "POST", "/chat/completions", req, &raw); err != nil {
+		return nil, err
+	}
+	var completion ChatCompletion
+	if err := decode(raw, &completion); err != nil {
+		return nil, err
+	}
+	completion.Body = raw
+	return &completion, nil
+}
@@ -0,0 +1,112 @@
+// Package openai is a small client for the parts of the OpenAI API the chatbot uses: the
+// Responses API for assistant turns and the file and vector store endpoints behind
+// knowledge sync. It only handles transport and the wire format; retries, background
+// waiting and error classification stay with the caller.
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// Client calls the API with one key. The zero values of BaseURL and HTTPClient use
+// DefaultBaseURL and http.DefaultClient. The key is sent as a bearer token unless AuthHeader
+// names another header, e.g. "api-key" for Azure OpenAI.
+type Client struct {
+	APIKey     string
+	BaseURL    string
+	HTTPClient *http.Client
+	AuthHeader string
+}
+
+func NewClient(apiKey, baseURL string, httpClient *http.Client) *Client {
+	return &Client{APIKey: apiKey, BaseURL: baseURL, HTTPClient: httpClient}
+}
+
+// APIError is a non-200 answer from the API.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("openai: HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// DecodeError is a 200 answer whose body isn't the expected JSON.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string { return "openai: invalid response body: " + e.Err.Error() }
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Do performs a request against BaseURL+path and returns the body of a 200 answer. Transport
+// errors are returned as they come from the http.Client.
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader, contentType string) ([]byte, error) {
+	base := strings.TrimRight(c.BaseURL, "/")
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.AuthHeader != "" {
+		req.Header.Set(c.AuthHeader, c.APIKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	return respBody, nil
+}
+
+// doJSON sends v as JSON (no body when v is nil) and decodes the answer into out.
+func (c *Client) doJSON(ctx context.Context, method, path string, v, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if v != nil {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body, contentType = bytes.NewReader(payload), "application/json"
+	}
+	respBody, err := c.Do(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return decode(respBody, out)
+}
+
+func decode(body []byte, out interface{}) error {
+	if err := json.Unmarshal(body, out); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package openai
+
+import "context"
+
+// EmbeddingRequest is the body of POST /embeddings. Input is a string or a list of strings.
+type EmbeddingRequest struct {
+	Model string      `json:"model"` // e.g. text-embedding-3-small
+	Input interface{} `json:"input"`
+}
+
+// Embeddings is the answer to an embedding request: one vector per input, in input order.
+type Embeddings struct {
+	Vectors     [][]float32
+	InputTokens int
+}
+
+// CreateEmbeddings embeds the input.
+func (c *Client) CreateEmbeddings(ctx context.Context, req *EmbeddingRequest) (*Embeddings, error) {
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := c.doJSON(ctx, "POST", "/embeddings", req, &out); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(out.Data))
+	for i, d := range out.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			i = d.Index
+		}
+		vectors[i] = d.Embedding
+	}
+	return &Embeddings{Vectors: vectors, InputTokens: out.Usage.PromptTokens}, nil
+}
@@ -0,0 +1,63 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+)
+
+// File is an uploaded file or vector store.
+type File struct {
+	ID string `json:"id"`
+}
+
+// CreateVectorStore creates an empty vector store and returns its ID.
+func (c *Client) CreateVectorStore(ctx context.Context, name string) (string, error) {
+	var store File
+	if err := c.doJSON(ctx, "POST", "/vector_stores", map[string]string{"name": name}, &store); err != nil {
+		return "", err
+	}
+	if store.ID == "" {
+		return "", &DecodeError{Err: errors.New("vector store has no id")}
+	}
+	return store.ID, nil
+}
+
+// UploadFile uploads data as filename for the given purpose and returns the file ID.
+func (c *Client) UploadFile(ctx context.Context, filename string, data []byte, purpose string) (string, error) {
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	w.WriteField("purpose", purpose)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	w.Close()
+
+	body, err := c.Do(ctx, "POST", "/files", &form, w.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	var file File
+	if err := decode(body, &file); err != nil {
+		return "", err
+	}
+	if file.ID == "" {
+		return "", &DecodeError{Err: errors.New("file has no id")}
+	}
+	return file.ID, nil
+}
+
+func (c *Client) AddVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) error {
+	return c.doJSON(ctx, "POST", "/vector_stores/"+vectorStoreID+"/files", map[string]string{"file_id": fileID}, nil)
+}
+
+func (c *Client) RemoveVectorStoreFile(ctx context.Context, vectorStoreID, fileID string) error {
+	return c.doJSON(ctx, "DELETE", "/vector_stores/"+vectorStoreID+"/files/"+fileID, nil, nil)
+}
+
+func (c *Client) DeleteFile(ctx context.Context, fileID string) error {
+	return c.doJSON(ctx, "DELETE", "/files/"+fileID, nil, nil)
+}
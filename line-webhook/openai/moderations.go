@@ -0,0 +1,26 @@
+package openai
+
+import "context"
+
+// ModerationRequest is the body of POST /moderations. Input is a string or a list of strings.
+type ModerationRequest struct {
+	Model string      `json:"model,omitempty"` // default omni-moderation-latest
+	Input interface{} `json:"input"`
+}
+
+// ModerationResult is the verdict on one input.
+type ModerationResult struct {
+	Flagged    bool            `json:"flagged"`
+	Categories map[string]bool `json:"categories"` // e.g. "harassment", "hate", "sexual"
+}
+
+// Moderate classifies the input, one result per input string.
+func (c *Client) Moderate(ctx context.Context, req *ModerationRequest) ([]ModerationResult, error) {
+	var out struct {
+		Results []ModerationResult `json:"results"`
+	}
+	if err := c.doJSON(ctx, "POST", "/moderations", req, &out); err != nil {
+		return nil, err
+	}
+	return out.Results, nil
+}
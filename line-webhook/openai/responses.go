@@ -0,0 +1,173 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Responses is the part of the API used to run assistant turns. *Client implements it; tests
+// and simulations can substitute their own.
+type Responses interface {
+	CreateResponse(ctx context.Context, req *ResponseRequest) (*Response, error)
+	GetResponse(ctx context.Context, id string) (*Response, error)
+	CancelResponse(ctx context.Context, id string) error
+}
+
+var _ Responses = (*Client)(nil)
+
+// ResponseRequest is the body of POST /responses. Input is a plain string or a list of input
+// items: Message, FunctionCallOutput, or output items echoed back from an earlier response.
+type ResponseRequest struct {
+	Model           string        `json:"model"`
+	Instructions    string        `json:"instructions,omitempty"`
+	Input           interface{}   `json:"input"`
+	Tools           []interface{} `json:"tools,omitempty"`
+	Store           bool          `json:"store"`
+	Background      bool          `json:"background,omitempty"`
+	Temperature     *float64      `json:"temperature,omitempty"`
+	MaxOutputTokens int           `json:"max_output_tokens,omitempty"`
+	Truncation      string        `json:"truncation,omitempty"`
+	Text            *ResponseText `json:"text,omitempty"`
+}
+
+// ResponseText configures the text output, e.g. structured outputs following a JSON schema.
+type ResponseText struct {
+	Format ResponseFormat `json:"format"`
+}
+
+// ResponseFormat is {"type": "text"} or a json_schema format; the schema fields are inlined.
+type ResponseFormat struct {
+	Type   string      `json:"type"` // "text", "json_object" or "json_schema"
+	Name   string      `json:"name,omitempty"`
+	Schema interface{} `json:"schema,omitempty"`
+	Strict bool        `json:"strict,omitempty"`
+}
+
+// JSONSchemaText asks for output matching the schema (Responses API form).
+func JSONSchemaText(s *JSONSchema) *ResponseText {
+	return &ResponseText{Format: ResponseFormat{Type: "json_schema", Name: s.Name, Schema: s.Schema, Strict: s.Strict}}
+}
+
+// Message is a user, assistant or developer input item. Content is a string or a list of
+// content parts such as input_text and input_image.
+type Message struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// FunctionCallOutput answers a function_call output item.
+type FunctionCallOutput struct {
+	Type   string `json:"type"` // always "function_call_output"
+	CallID string `json:"call_id"`
+	Output string `json:"output"`
+}
+
+func NewFunctionCallOutput(callID, output string) FunctionCallOutput {
+	return FunctionCallOutput{Type: "function_call_output", CallID: callID, Output: output}
+}
+
+// Response is a created or retrieved response.
+type Response struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"` // queued, in_progress, completed, failed, cancelled or incomplete
+	Output []OutputItem `json:"output"`
+	Usage  Usage        `json:"usage"`
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+
+	Body []byte `json:"-"` // the answer as received, for debug logging
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// OutputItem is one output item. Only the fields of message and function_call items are
+// decoded; Raw keeps the item as received so it can be sent back as input.
+type OutputItem struct {
+	Type    string `json:"type"`
+	Role    string `json:"role"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	ID        string          `json:"id"`
+	CallID    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (o *OutputItem) UnmarshalJSON(data []byte) error {
+	type plain OutputItem
+	if err := json.Unmarshal(data, (*plain)(o)); err != nil {
+		return err
+	}
+	o.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// Finished reports whether the response has stopped running.
+func (r *Response) Finished() bool {
+	return r.Status != "queued" && r.Status != "in_progress"
+}
+
+// FunctionCalls returns the function_call output items in order.
+func (r *Response) FunctionCalls() []OutputItem {
+	var calls []OutputItem
+	for _, item := range r.Output {
+		if item.Type == "function_call" {
+			calls = append(calls, item)
+		}
+	}
+	return calls
+}
+
+// OutputText returns the first non-blank output_text of an assistant message, or "".
+func (r *Response) OutputText() string {
+	for _, item := range r.Output {
+		if item.Type != "message" || (item.Role != "" && item.Role != "assistant") {
+			continue
+		}
+		for _, content := range item.Content {
+			if content.Type == "output_text" && strings.TrimSpace(content.Text) != "" {
+				return content.Text
+			}
+		}
+	}
+	return ""
+}
+
+func (c *Client) CreateResponse(ctx context.Context, req *ResponseRequest) (*Response, error) {
+	return c.response(ctx, "POST", "/responses", req)
+}
+
+func (c *Client) GetResponse(ctx context.Context, id string) (*Response, error) {
+	return c.response(ctx, "GET", "/responses/"+id, nil)
+}
+
+func (c *Client) CancelResponse(ctx context.Context, id string) error {
+	return c.doJSON(ctx, "POST", "/responses/"+id+"/cancel", nil, nil)
+}
+
+func (c *Client) response(ctx context.Context, method, path string, req *ResponseRequest) (*Response, error) {
+	var body interface{}
+	if req != nil {
+		body = req
+	}
+	var raw json.RawMessage
+	if err := c.doJSON(ctx, method, path, body, &raw); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := decode(raw, &resp); err != nil {
+		return nil, err
+	}
+	resp.Body = raw
+	return &resp, nil
+}
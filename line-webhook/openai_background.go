@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// In background mode (OPENAI_RESPONSES_MODE=background) assistant requests return at once and
+// finish on OpenAI's side; completion arrives as a signed response.* webhook at /openai/webhook.
+// Polling takes over whenever that channel is unavailable: no OPENAI_WEBHOOK_SECRET, or
+// webhooks stopped arriving for responses that polling found finished.
+
+const (
+	responsePollFast     = 1 * time.Second  // no webhook channel
+	responsePollSafety   = 10 * time.Second // webhook channel healthy; catches lost events
+	responseWaitLimit    = 3 * time.Minute
+	webhookMissThreshold = 3 // consecutive responses finished without a webhook
+	webhookTolerance     = 5 * time.Minute
+)
+
+var (
+	responseWaitLock sync.Mutex
+	responseWaiters  = make(map[string]chan struct{}) // response ID -> signalled on webhook
+	responseDone     = make(map[string]time.Time)     // webhooks that arrived before their waiter
+	webhookMisses    int
+)
+
+func backgroundResponsesEnabled() bool {
+	return appConfig.ResponsesMode == "background"
+}
+
+// webhookChannelAvailable reports whether run completion is expected by webhook.
+func webhookChannelAvailable() bool {
+	if appConfig.OpenAIWebhookSecret == "" {
+		return false
+	}
+	responseWaitLock.Lock()
+	defer responseWaitLock.Unlock()
+	return webhookMisses < webhookMissThreshold
+}
+
+// postResponse creates one response and returns it once it has finished.
+func postResponse(ctx context.Context, api openai.Responses, req *openai.ResponseRequest) (*openai.Response, error) {
+	background := backgroundResponsesEnabled()
+	if background {
+		req.Background = true
+		req.Store = true // background responses must be stored to be retrieved
+	}
+	payloadBytes, _ := json.Marshal(req)
+	log.Printf("Responses API request (background=%v), payload size: %d bytes", background, len(payloadBytes))
+
+	resp, err := api.CreateResponse(ctx, req)
+	if err != nil {
+		return nil, openAIError(err)
+	}
+	if !background {
+		return resp, nil
+	}
+	if resp.ID == "" {
+		return nil, &UpstreamError{Service: "openai", Err: fmt.Errorf("invalid background response: %s", resp.Body)}
+	}
+	if resp.Finished() {
+		return resp, nil
+	}
+	return waitForResponse(ctx, api, resp.ID)
+}
+
+// waitForResponse blocks until the background response finishes, woken by its webhook or,
+// failing that, by polling.
+func waitForResponse(ctx context.Context, api openai.Responses, id string) (*openai.Response, error) {
+	signal := make(chan struct{}, 1)
+	responseWaitLock.Lock()
+	if _, ok := responseDone[id]; ok {
+		delete(responseDone, id)
+		signal <- struct{}{}
+	}
+	responseWaiters[id] = signal
+	responseWaitLock.Unlock()
+	defer func() {
+		responseWaitLock.Lock()
+		delete(responseWaiters, id)
+		responseWaitLock.Unlock()
+	}()
+
+	deadline := time.NewTimer(responseWaitLimit)
+	defer deadline.Stop()
+	viaWebhook := false
+	for {
+		interval := responsePollFast
+		if webhookChannelAvailable() {
+			interval = responsePollSafety
+		}
+		poll := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			poll.Stop()
+			go cancelBackgroundResponse(api, id)
+			return nil, ctx.Err()
+		case <-deadline.C:
+			poll.Stop()
+			go cancelBackgroundResponse(api, id)
+			return nil, &TimeoutError{Op: "openai", Err: fmt.Errorf("background response %s still running after %s", id, responseWaitLimit)}
+		case <-signal:
+			viaWebhook = true
+		case <-poll.C:
+		}
+		poll.Stop()
+
+		resp, err := api.GetResponse(ctx, id)
+		if err != nil {
+			return nil, openAIError(err)
+		}
+		if !resp.Finished() {
+			continue
+		}
+		recordResponseCompletion(viaWebhook)
+		if resp.Status == "failed" && resp.Error != nil {
+			return nil, &UpstreamError{Service: "openai", Err: errors.New(resp.Error.Message)}
+		}
+		return resp, nil
+	}
+}
+
+// recordResponseCompletion tracks whether finished responses were announced by webhook and
+// switches to fast polling after several in a row were not.
+func recordResponseCompletion(viaWebhook bool) {
+	if appConfig.OpenAIWebhookSecret == "" {
+		appMetrics.inc("openai_responses_polled")
+		return
+	}
+	responseWaitLock.Lock()
+	defer responseWaitLock.Unlock()
+	if viaWebhook {
+		webhookMisses = 0
+		appMetrics.inc("openai_responses_via_webhook")
+		return
+	}
+	webhookMisses++
+	appMetrics.inc("openai_responses_polled")
+	if webhookMisses == webhookMissThreshold {
+		log.Printf("No OpenAI webhook for %d finished responses; falling back to polling", webhookMisses)
+	}
+}
+
+func cancelBackgroundResponse(api openai.Responses, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := api.CancelResponse(ctx, id); err != nil {
+		log.Printf("Failed to cancel background response %s: %v", id, err)
+	}
+}
+
+// verifyOpenAIWebhook checks the Standard Webhooks signature OpenAI sends with each event.
+func verifyOpenAIWebhook(secret, id, timestamp, signatures string, body []byte) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid webhook-timestamp")
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return errors.New("webhook timestamp outside tolerance")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return fmt.Errorf("invalid OPENAI_WEBHOOK_SECRET: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	for _, sig := range strings.Fields(signatures) {
+		if v, ok := strings.CutPrefix(sig, "v1,"); ok && hmac.Equal([]byte(v), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// handleOpenAIWebhook wakes the turn waiting on a background response when it finishes.
+func handleOpenAIWebhook(c *fiber.Ctx) error {
+	secret := appConfig.OpenAIWebhookSecret
+	if secret == "" {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err := verifyOpenAIWebhook(secret, c.Get("webhook-id"), c.Get("webhook-timestamp"), c.Get("webhook-signature"), c.Body()); err != nil {
+		log.Printf("Rejected OpenAI webhook: %v", err)
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(c.Body(), &event); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if !strings.HasPrefix(event.Type, "response.") || event.Data.ID == "" {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	responseWaitLock.Lock()
+	if signal, ok := responseWaiters[event.Data.ID]; ok {
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	} else {
+		// the request that created the response may not be waiting yet
+		for id, at := range responseDone {
+			if time.Since(at) > responseWaitLimit {
+				delete(responseDone, id)
+			}
+		}
+		responseDone[event.Data.ID] = time.Now()
+	}
+	responseWaitLock.Unlock()
+	return c.SendStatus(fiber.StatusOK)
+}
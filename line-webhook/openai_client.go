@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// newOpenAIClient returns a client for CHATGPT_API_KEY. OPENAI_BASE_URL points it at a proxy
+// or a compatible server instead of the public API. 429 and 5xx answers are retried, see
+// retryTransport.
+func newOpenAIClient(timeout time.Duration) (*openai.Client, error) {
+	apiKey := appConfig.OpenAIAPIKey
+	if apiKey == "" {
+		return nil, &UpstreamError{Service: "openai", Err: errors.New("CHATGPT_API_KEY not set")}
+	}
+	return openai.NewClient(apiKey, appConfig.OpenAIBaseURL, &http.Client{Timeout: timeout, Transport: openAITransport}), nil
+}
+
+// newFallbackOpenAIClient returns a client for the failover endpoint, LLM_FALLBACK_BASE_URL.
+// Azure OpenAI endpoints take the key in their api-key header.
+func newFallbackOpenAIClient(timeout time.Duration) *openai.Client {
+	client := openai.NewClient(appConfig.LLMFallbackAPIKey, appConfig.LLMFallbackBaseURL, &http.Client{Timeout: timeout, Transport: openAITransport})
+	if u, err := url.Parse(appConfig.LLMFallbackBaseURL); err == nil {
+		host := u.Hostname()
+		if strings.HasSuffix(host, ".openai.azure.com") || strings.HasSuffix(host, ".cognitiveservices.azure.com") {
+			client.AuthHeader = "api-key"
+		}
+	}
+	return client
+}
+
+// openAIError maps client errors to the kinds used for customer replies and metrics.
+func openAIError(err error) error {
+	var apiErr *openai.APIError
+	var decodeErr *openai.DecodeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &apiErr):
+		return &UpstreamError{Service: "openai", StatusCode: apiErr.StatusCode, Err: errors.New(apiErr.Body)}
+	case errors.As(err, &decodeErr):
+		return &UpstreamError{Service: "openai", Err: err}
+	}
+	return classifyRequestError("openai", err)
+}
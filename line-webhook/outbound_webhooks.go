@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OutboundEndpoint is an external system (CRM, payment callback, event bus sink)
+// that receives signed event deliveries.
+type OutboundEndpoint struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`           // HMAC-SHA256 signing key
+	Events []string `json:"events,omitempty"` // event types to receive; empty means all
+}
+
+func (e OutboundEndpoint) wants(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, ev := range e.Events {
+		if ev == event || ev == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// OutboundDelivery is one event sent to one endpoint, retried until it succeeds or gives up.
+type OutboundDelivery struct {
+	ID            string          `json:"id"`
+	Endpoint      string          `json:"endpoint"`
+	Event         string          `json:"event"`
+	Body          json.RawMessage `json:"body"`
+	Status        string          `json:"status"` // "pending", "delivered" or "failed"
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	LastHTTPCode  int             `json:"last_http_code,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   time.Time       `json:"delivered_at,omitempty"`
+}
+
+const (
+	outboundMaxAttempts     = 8
+	outboundRetryBase       = 30 * time.Second
+	outboundMaxKept         = 500 // finished deliveries kept for the admin view
+	outboundSignatureHeader = "X-NCS-Signature"
+)
+
+var (
+	outboundEndpointsFile  = "outbound_webhooks.json"
+	outboundDeliveriesFile = "outbound_deliveries.json"
+)
+
+var (
+	outboundLock       sync.Mutex
+	outboundEndpoints  []OutboundEndpoint
+	outboundDeliveries []*OutboundDelivery
+	outboundWake       = make(chan struct{}, 1)
+)
+
+// signOutboundBody returns the signature header value "t=<unix>,v1=<hex hmac>" computed over "<unix>.<body>".
+// Receivers recompute it with the shared secret and reject stale timestamps.
+func signOutboundBody(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// outboundRetryDelay doubles the wait after every failed attempt (30s, 1m, 2m, ... ~1h).
+func outboundRetryDelay(attempts int) time.Duration {
+	return outboundRetryBase << uint(attempts-1)
+}
+
+// emitOutboundEvent queues an event about userId for every endpoint subscribed to it. It never
+// blocks on the network. Events about simulated users are dropped.
+func emitOutboundEvent(event, userId string, data interface{}) {
+	if isSimulatedUser(userId) {
+		return
+	}
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	if len(outboundEndpoints) == 0 {
+		return
+	}
+	now := time.Now()
+	body, err := json.Marshal(map[string]interface{}{
+		"event":       event,
+		"occurred_at": now.Format(time.RFC3339),
+		"data":        data,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal outbound event %s: %v", event, err)
+		return
+	}
+	queued := 0
+	for _, ep := range outboundEndpoints {
+		if !ep.wants(event) {
+			continue
+		}
+		outboundDeliveries = append(outboundDeliveries, &OutboundDelivery{
+			ID:            fmt.Sprintf("dlv_%d_%d", now.UnixNano(), queued),
+			Endpoint:      ep.Name,
+			Event:         event,
+			Body:          body,
+			Status:        "pending",
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		queued++
+	}
+	if queued > 0 {
+		select {
+		case outboundWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// attemptOutboundDelivery POSTs one delivery and returns the HTTP status and error.
+func attemptOutboundDelivery(ep OutboundEndpoint, d OutboundDelivery) (int, error) {
+	req, err := http.NewRequest("POST", ep.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-NCS-Event", d.Event)
+	req.Header.Set("X-NCS-Delivery", d.ID)
+	req.Header.Set(outboundSignatureHeader, signOutboundBody(ep.Secret, time.Now().Unix(), d.Body))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// processOutboundDeliveries sends every due delivery once.
+func processOutboundDeliveries() {
+	now := time.Now()
+	type job struct {
+		d  *OutboundDelivery
+		ep OutboundEndpoint
+	}
+	var due []job
+	outboundLock.Lock()
+	endpoints := make(map[string]OutboundEndpoint, len(outboundEndpoints))
+	for _, ep := range outboundEndpoints {
+		endpoints[ep.Name] = ep
+	}
+	for _, d := range outboundDeliveries {
+		if d.Status != "pending" || d.NextAttemptAt.After(now) {
+			continue
+		}
+		ep, ok := endpoints[d.Endpoint]
+		if !ok {
+			d.Status = "failed"
+			d.LastError = "endpoint no longer configured"
+			continue
+		}
+		due = append(due, job{d, ep})
+	}
+	outboundLock.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	for _, j := range due {
+		outboundLock.Lock()
+		snapshot := *j.d
+		outboundLock.Unlock()
+		code, err := attemptOutboundDelivery(j.ep, snapshot)
+
+		outboundLock.Lock()
+		j.d.Attempts++
+		j.d.LastHTTPCode = code
+		switch {
+		case err == nil:
+			j.d.Status = "delivered"
+			j.d.DeliveredAt = time.Now()
+			j.d.LastError = ""
+			appMetrics.inc("outbound_delivered")
+		case j.d.Attempts >= outboundMaxAttempts:
+			j.d.Status = "failed"
+			j.d.LastError = err.Error()
+			appMetrics.inc("outbound_failed")
+			log.Printf("Outbound delivery %s (%s -> %s) failed permanently: %v", j.d.ID, j.d.Event, j.d.Endpoint, err)
+		default:
+			j.d.LastError = err.Error()
+			j.d.NextAttemptAt = time.Now().Add(outboundRetryDelay(j.d.Attempts))
+			appMetrics.inc("outbound_retried")
+			log.Printf("Outbound delivery %s to %s failed (attempt %d), retrying at %s: %v", j.d.ID, j.d.Endpoint, j.d.Attempts, j.d.NextAttemptAt.Format(time.RFC3339), err)
+		}
+		outboundLock.Unlock()
+	}
+	pruneOutboundDeliveries()
+	saveOutboundDeliveries()
+}
+
+// pruneOutboundDeliveries drops the oldest finished deliveries beyond outboundMaxKept.
+func pruneOutboundDeliveries() {
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	finished := 0
+	for _, d := range outboundDeliveries {
+		if d.Status != "pending" {
+			finished++
+		}
+	}
+	if finished <= outboundMaxKept {
+		return
+	}
+	drop := finished - outboundMaxKept
+	kept := outboundDeliveries[:0]
+	for _, d := range outboundDeliveries {
+		if drop > 0 && d.Status != "pending" {
+			drop--
+			continue
+		}
+		kept = append(kept, d)
+	}
+	outboundDeliveries = kept
+}
+
+// startOutboundWorker delivers queued events as they arrive and retries failures when due.
+func startOutboundWorker() {
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-outboundWake:
+			}
+			processOutboundDeliveries()
+		}
+	}()
+}
+
+func saveOutboundDeliveries() {
+	outboundLock.Lock()
+	data, err := json.Marshal(outboundDeliveries)
+	outboundLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal outbound deliveries: %v", err)
+		return
+	}
+	if err := os.WriteFile(outboundDeliveriesFile, data, 0644); err != nil {
+		log.Printf("Failed to save outbound deliveries: %v", err)
+	}
+}
+
+func loadOutboundWebhooks() {
+	if data, err := os.ReadFile(outboundEndpointsFile); err == nil {
+		var endpoints []OutboundEndpoint
+		if err := json.Unmarshal(data, &endpoints); err != nil {
+			log.Printf("Failed to parse outbound webhooks file: %v", err)
+		} else {
+			outboundLock.Lock()
+			outboundEndpoints = endpoints
+			outboundLock.Unlock()
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to read outbound webhooks file: %v", err)
+	}
+
+	if data, err := os.ReadFile(outboundDeliveriesFile); err == nil {
+		outboundLock.Lock()
+		if err := json.Unmarshal(data, &outboundDeliveries); err != nil {
+			log.Printf("Failed to parse outbound deliveries file: %v", err)
+		}
+		outboundLock.Unlock()
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to read outbound deliveries file: %v", err)
+	}
+}
+
+// maskedOutboundSecret stands in for a stored secret in GET responses. A PUT that sends it
+// back (or leaves the secret empty) keeps the endpoint's stored secret.
+const maskedOutboundSecret = "••••"
+
+// handleGetOutboundWebhooks lists configured endpoints with secrets masked.
+func handleGetOutboundWebhooks(c *fiber.Ctx) error {
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	result := make([]OutboundEndpoint, 0, len(outboundEndpoints))
+	for _, ep := range outboundEndpoints {
+		if ep.Secret != "" {
+			ep.Secret = maskedOutboundSecret
+		}
+		result = append(result, ep)
+	}
+	return c.JSON(result)
+}
+
+func handleReplaceOutboundWebhooks(c *fiber.Ctx) error {
+	var incoming []OutboundEndpoint
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	// held through the save so a concurrent PUT can't swap the secrets being kept
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	stored := map[string]string{}
+	for _, ep := range outboundEndpoints {
+		stored[ep.Name] = ep.Secret
+	}
+	seen := map[string]bool{}
+	for i := range incoming {
+		ep := &incoming[i]
+		if ep.Name == "" || seen[ep.Name] {
+			return respondError(c, fiber.StatusBadRequest, "each endpoint needs a unique name")
+		}
+		seen[ep.Name] = true
+		if !strings.HasPrefix(ep.URL, "https://") && !strings.HasPrefix(ep.URL, "http://") {
+			return respondError(c, fiber.StatusBadRequest, fmt.Sprintf("endpoint '%s' needs an http(s) url", ep.Name))
+		}
+		if ep.Secret == "" || ep.Secret == maskedOutboundSecret {
+			ep.Secret = stored[ep.Name]
+		}
+		if ep.Secret == "" {
+			return respondError(c, fiber.StatusBadRequest, fmt.Sprintf("endpoint '%s' needs a signing secret", ep.Name))
+		}
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save outbound webhooks")
+	}
+	if err := os.WriteFile(outboundEndpointsFile, data, 0600); err != nil {
+		log.Printf("Failed to save outbound webhooks: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save outbound webhooks")
+	}
+	outboundEndpoints = incoming
+	return c.JSON(fiber.Map{"status": "ok", "endpoints": len(incoming)})
+}
+
+// handleGetOutboundDeliveries is the delivery-status view; filter with ?status= and ?endpoint=.
+func handleGetOutboundDeliveries(c *fiber.Ctx) error {
+	status := c.Query("status")
+	endpoint := c.Query("endpoint")
+	outboundLock.Lock()
+	defer outboundLock.Unlock()
+	counts := map[string]int{}
+	result := make([]OutboundDelivery, 0)
+	for i := len(outboundDeliveries) - 1; i >= 0; i-- {
+		d := outboundDeliveries[i]
+		counts[d.Status]++
+		if (status == "" || d.Status == status) && (endpoint == "" || d.Endpoint == endpoint) {
+			result = append(result, *d)
+		}
+	}
+	return c.JSON(fiber.Map{"counts": counts, "deliveries": result})
+}
+
+// handleRetryOutboundDelivery requeues a failed delivery for immediate sending.
+func handleRetryOutboundDelivery(c *fiber.Ctx) error {
+	id := c.Params("id")
+	outboundLock.Lock()
+	var found *OutboundDelivery
+	for _, d := range outboundDeliveries {
+		if d.ID == id {
+			found = d
+		}
+	}
+	if found == nil {
+		outboundLock.Unlock()
+		return respondError(c, fiber.StatusNotFound, "delivery not found")
+	}
+	if found.Status == "delivered" {
+		outboundLock.Unlock()
+		return respondError(c, fiber.StatusBadRequest, "delivery already succeeded")
+	}
+	found.Status = "pending"
+	found.Attempts = 0
+	found.NextAttemptAt = time.Now()
+	result := *found
+	outboundLock.Unlock()
+
+	select {
+	case outboundWake <- struct{}{}:
+	default:
+	}
+	return c.JSON(result)
+}
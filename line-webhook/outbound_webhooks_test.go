@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReplaceOutboundWebhooksKeepsMaskedSecret(t *testing.T) {
+	savedFile := outboundEndpointsFile
+	defer func() { outboundEndpointsFile = savedFile }()
+	outboundEndpointsFile = filepath.Join(t.TempDir(), "outbound_webhooks.json")
+	outboundLock.Lock()
+	savedEndpoints := outboundEndpoints
+	outboundEndpoints = []OutboundEndpoint{{Name: "crm", URL: "https://crm.example/hook", Secret: "s3cret"}}
+	outboundLock.Unlock()
+	defer func() {
+		outboundLock.Lock()
+		outboundEndpoints = savedEndpoints
+		outboundLock.Unlock()
+	}()
+
+	app := fiber.New()
+	app.Put("/admin/outbound-webhooks", handleReplaceOutboundWebhooks)
+	put := func(body string) int {
+		req := httptest.NewRequest("PUT", "/admin/outbound-webhooks", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for _, secret := range []string{maskedOutboundSecret, ""} {
+		if code := put(`[{"name": "crm", "url": "https://crm.example/v2", "secret": "` + secret + `"}]`); code != fiber.StatusOK {
+			t.Fatalf("PUT with secret %q = %d, want 200", secret, code)
+		}
+		outboundLock.Lock()
+		got := outboundEndpoints[0].Secret
+		outboundLock.Unlock()
+		if got != "s3cret" {
+			t.Errorf("PUT with secret %q stored %q, want the old secret kept", secret, got)
+		}
+	}
+	if code := put(`[{"name": "erp", "url": "https://erp.example/hook", "secret": "` + maskedOutboundSecret + `"}]`); code != fiber.StatusBadRequest {
+		t.Errorf("PUT of a new endpoint with a masked secret = %d, want 400", code)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/openai"
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// A photo sent while the customer has a pending payment (a booking deposit, membership or
+// gift voucher) is read as a transfer slip before it reaches the assistant. The slip's
+// amount, date and transaction reference are read by the vision model and, with
+// SLIP_VERIFY_URL set, confirmed with a bank slip-verification service. A slip that matches
+// the payment settles it; anything doubtful is flagged for staff, who settle it with
+// POST /admin/payments/:id/paid. Photos that aren't slips go to the assistant as usual.
+
+// PaymentSlip is one slip a customer sent and what became of it.
+type PaymentSlip struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	PaymentID  string    `json:"payment_id"`
+	Amount     int       `json:"amount"`                // as read from the slip, baht
+	TransferAt string    `json:"transfer_at,omitempty"` // as read from the slip
+	Reference  string    `json:"reference,omitempty"`   // bank transaction reference
+	Receiver   string    `json:"receiver,omitempty"`
+	Verified   bool      `json:"verified"` // confirmed by the slip-verification service
+	Status     string    `json:"status"`   // "approved" or "review"
+	Reason     string    `json:"reason,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// slipReading is what the vision model reads from a photo.
+type slipReading struct {
+	IsSlip     bool    `json:"is_slip"`
+	Amount     float64 `json:"amount"`
+	TransferAt string  `json:"transfer_at"` // YYYY-MM-DD HH:MM
+	Reference  string  `json:"reference"`
+	Receiver   string  `json:"receiver"`
+}
+
+const slipReadingInstructions = `You read Thai bank transfer slips and PromptPay receipts. Reply with JSON only:
+{"is_slip": bool, "amount": number, "transfer_at": "YYYY-MM-DD HH:MM", "reference": string, "receiver": string}
+Use the Gregorian year (subtract 543 from Buddhist-era years). reference is the transaction reference number.
+If the image is not a completed transfer slip, reply {"is_slip": false} with the other fields empty or 0.`
+
+// slipReadingSchema is the structured output of a slip reading.
+var slipReadingSchema = &openai.JSONSchema{
+	Name: "slip_reading",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"is_slip":     map[string]interface{}{"type": "boolean"},
+			"amount":      map[string]interface{}{"type": "number"},
+			"transfer_at": map[string]interface{}{"type": "string", "description": "YYYY-MM-DD HH:MM, Gregorian year"},
+			"reference":   map[string]interface{}{"type": "string"},
+			"receiver":    map[string]interface{}{"type": "string"},
+		},
+		"required":             []string{"is_slip", "amount", "transfer_at", "reference", "receiver"},
+		"additionalProperties": false,
+	},
+	Strict: true,
+}
+
+// slipMaxAge is how old a slip may be and still settle a payment automatically.
+const slipMaxAge = 72 * time.Hour
+
+var paymentSlipsFile = "payment_slips.json"
+
+var (
+	paymentSlipLock sync.Mutex
+	paymentSlips    []*PaymentSlip
+)
+
+// awaitingPayment returns the user's most recent pending payment.
+func awaitingPayment(userId string) (Payment, bool) {
+	paymentLock.Lock()
+	defer paymentLock.Unlock()
+	for i := len(payments) - 1; i >= 0; i-- {
+		if p := payments[i]; p.UserID == userId && p.Status == "pending" {
+			return *p, true
+		}
+	}
+	return Payment{}, false
+}
+
+// takePossibleSlip removes a photo from the buffer when the customer has a pending payment,
+// so it can be checked as a slip first. It reports whether the photo was taken.
+func takePossibleSlip(userId, messageContent string) bool {
+	if _, ok := awaitingPayment(userId); !ok || staffHandling(userId) {
+		return false
+	}
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	buf := userMsgBuffer[userId]
+	n := len(buf)
+	if n == 0 || buf[n-1] != messageContent {
+		return false
+	}
+	userMsgBuffer[userId] = buf[:n-1]
+	return true
+}
+
+// checkPaymentSlip reads a photo as a slip for the user's pending payment. Photos that aren't
+// slips are handed to the assistant.
+func checkPaymentSlip(userId, replyToken, imageURL, messageContent string) {
+	started := time.Now()
+	payment, ok := awaitingPayment(userId)
+	if !ok {
+		askAssistant(userId, replyToken, messageContent)
+		return
+	}
+	ctx, cancel := context.WithTimeout(withUsageUser(context.Background(), userId), 60*time.Second)
+	defer cancel()
+	reading, err := readPaymentSlip(ctx, imageURL)
+	if err != nil {
+		log.Printf("Could not read photo from %s as a slip: %v", userId, err)
+		askAssistant(userId, replyToken, messageContent)
+		return
+	}
+	if !reading.IsSlip {
+		appMetrics.inc("payment_slips_not_slip")
+		askAssistant(userId, replyToken, messageContent)
+		return
+	}
+
+	slip := &PaymentSlip{
+		ID:         fmt.Sprintf("slip_%d", time.Now().UnixNano()),
+		UserID:     userId,
+		PaymentID:  payment.ID,
+		Amount:     int(reading.Amount + 0.5),
+		TransferAt: reading.TransferAt,
+		Reference:  strings.TrimSpace(reading.Reference),
+		Receiver:   reading.Receiver,
+		ReceivedAt: time.Now(),
+	}
+	slip.Reason = slipProblem(slip, payment)
+	if slip.Reason == "" && slipVerifyURL() != "" {
+		verified, err := verifySlipWithBank(ctx, imageURL, slip)
+		switch {
+		case err != nil:
+			slip.Reason = "ตรวจสอบกับธนาคารไม่ได้: " + err.Error()
+		case !verified:
+			slip.Reason = "ธนาคารยืนยันสลิปนี้ไม่ได้"
+		default:
+			slip.Verified = true
+		}
+	}
+	if slip.Reason == "" && !slip.Verified && !appConfig.SlipOCRAutoApprove {
+		slip.Reason = "ยอดตรงกัน แต่ยังไม่ได้ยืนยันกับธนาคาร"
+	}
+	slip.Status = "approved"
+	if slip.Reason != "" {
+		slip.Status = "review"
+	}
+	paymentSlipLock.Lock()
+	paymentSlips = append(paymentSlips, slip)
+	paymentSlipLock.Unlock()
+	go savePaymentSlips()
+	appMetrics.inc("payment_slips_" + slip.Status)
+	log.Printf("Slip %s from %s for payment %s: %s %s", slip.ID, userId, payment.ID, slip.Status, slip.Reason)
+
+	var reply string
+	if slip.Status == "approved" {
+		if _, err := markPaymentPaid(payment.ID); err != nil {
+			log.Printf("Failed to settle payment %s from slip %s: %v", payment.ID, slip.ID, err)
+		}
+		reply = fmt.Sprintf("ได้รับสลิปและตรวจสอบยอด %s บาทเรียบร้อยแล้วค่ะ ขอบคุณค่ะ 🙏", pricing.FormatNumber(slip.Amount))
+	} else {
+		reply = "ได้รับสลิปแล้วค่ะ 🙏 เจ้าหน้าที่จะตรวจสอบการชำระเงินและแจ้งกลับโดยเร็วนะคะ"
+		go alertStaff(userId, fmt.Sprintf("🧾 สลิปรอตรวจสอบ (%s)\nรายการ %s ยอด %s บาท\nสลิป %s บาท เวลา %s อ้างอิง %s\nเหตุผล: %s\nยืนยันด้วย POST /admin/payments/%s/paid",
+			slip.ID, payment.Reference, pricing.FormatNumber(payment.Amount), pricing.FormatNumber(slip.Amount), slip.TransferAt, slip.Reference, slip.Reason, payment.ID), "payment_slip")
+	}
+	deliverReply(userId, replyToken, reply, nil)
+
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.appendTurn(reply, stats)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+}
+
+// slipProblem returns why a slip can't settle the payment by itself, or "".
+func slipProblem(slip *PaymentSlip, payment Payment) string {
+	if slip.Amount != payment.Amount {
+		return fmt.Sprintf("ยอดในสลิป %s บาท ไม่ตรงกับยอด %s บาท", pricing.FormatNumber(slip.Amount), pricing.FormatNumber(payment.Amount))
+	}
+	if slip.Reference == "" {
+		return "อ่านเลขอ้างอิงในสลิปไม่ได้"
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", slip.TransferAt, bangkokNow().Location())
+	if err != nil {
+		return "อ่านวันเวลาในสลิปไม่ได้"
+	}
+	if time.Since(at) > slipMaxAge || at.Before(payment.CreatedAt.Add(-time.Hour)) {
+		return "วันที่โอนในสลิปเก่ากว่ารายการที่ต้องชำระ"
+	}
+	paymentSlipLock.Lock()
+	defer paymentSlipLock.Unlock()
+	for _, s := range paymentSlips {
+		if s.Reference == slip.Reference {
+			return "สลิปนี้เคยส่งมาแล้ว (" + s.ID + ")"
+		}
+	}
+	return ""
+}
+
+// readPaymentSlip asks the vision model to read a photo as a transfer slip.
+func readPaymentSlip(ctx context.Context, imageURL string) (slipReading, error) {
+	input := []interface{}{map[string]interface{}{
+		"role":    "user",
+		"content": []interface{}{map[string]interface{}{"type": "input_image", "image_url": imageURL}},
+	}}
+	var reading slipReading
+	if err := requestOpenAIJSON(ctx, "gpt-4.1-mini", slipReadingInstructions, input, slipReadingSchema, &reading); err != nil {
+		return slipReading{}, err
+	}
+	return reading, nil
+}
+
+func slipVerifyURL() string {
+	return appConfig.SlipVerifyURL
+}
+
+// verifySlipWithBank asks the slip-verification service whether the slip is genuine. It
+// posts {"image", "reference", "amount"} and expects {"valid": bool, "amount": number}.
+func verifySlipWithBank(ctx context.Context, imageURL string, slip *PaymentSlip) (bool, error) {
+	payload, _ := json.Marshal(map[string]interface{}{"image": imageURL, "reference": slip.Reference, "amount": slip.Amount})
+	req, err := http.NewRequestWithContext(ctx, "POST", slipVerifyURL(), bytes.NewReader(payload))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := appConfig.SlipVerifyAPIKey; key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, classifyRequestError("slip_verify", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, &UpstreamError{Service: "slip_verify", StatusCode: resp.StatusCode, Err: errors.New(string(body))}
+	}
+	var result struct {
+		Valid  bool    `json:"valid"`
+		Amount float64 `json:"amount"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, &UpstreamError{Service: "slip_verify", StatusCode: resp.StatusCode, Err: fmt.Errorf("invalid response body: %w", err)}
+	}
+	return result.Valid && int(result.Amount+0.5) == slip.Amount, nil
+}
+
+// markBookingDepositPaid records a paid deposit payment on its booking.
+func markBookingDepositPaid(p Payment) {
+	bookingLock.Lock()
+	var booking *Booking
+	for _, b := range bookings {
+		if b.ID == p.Reference {
+			booking = b
+		}
+	}
+	if booking == nil {
+		bookingLock.Unlock()
+		log.Printf("Deposit payment %s refers to unknown booking %s", p.ID, p.Reference)
+		return
+	}
+	booking.DepositStatus = "paid"
+	booking.UpdatedAt = time.Now()
+	result := *booking
+	bookingLock.Unlock()
+	go saveBookings()
+	emitOutboundEvent("booking.updated", result.UserID, result)
+	log.Printf("Deposit for booking %s paid (payment %s)", result.ID, p.ID)
+	// the sheet's tentative hold becomes a firm booking
+	branch, _ := customerBranch(result.UserID)
+	if w := branchSlotWriter(branch); w != nil && result.Status == "confirmed" {
+		go func() {
+			if err := w.HoldSlot(context.Background(), result.Date, result.TimeSlot, result.ID, false); err != nil {
+				log.Printf("Failed to mark booking %s as paid in the scheduling sheet: %v", result.ID, err)
+			}
+		}()
+	}
+}
+
+func savePaymentSlips() {
+	paymentSlipLock.Lock()
+	data, err := json.Marshal(paymentSlips)
+	paymentSlipLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal payment slips: %v", err)
+		return
+	}
+	if err := os.WriteFile(paymentSlipsFile, data, 0644); err != nil {
+		log.Printf("Failed to save payment slips: %v", err)
+	}
+}
+
+func loadPaymentSlips() {
+	data, err := os.ReadFile(paymentSlipsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read payment slips file: %v", err)
+		}
+		return
+	}
+	paymentSlipLock.Lock()
+	defer paymentSlipLock.Unlock()
+	if err := json.Unmarshal(data, &paymentSlips); err != nil {
+		log.Printf("Failed to parse payment slips file: %v", err)
+	}
+}
+
+func handleGetPaymentSlips(c *fiber.Ctx) error {
+	status := c.Query("status")
+	paymentSlipLock.Lock()
+	defer paymentSlipLock.Unlock()
+	result := make([]PaymentSlip, 0, len(paymentSlips))
+	for i := len(paymentSlips) - 1; i >= 0; i-- {
+		if s := paymentSlips[i]; status == "" || s.Status == status {
+			result = append(result, *s)
+		}
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Payment is a request for the customer to pay NCS, settled by bank transfer or PromptPay.
+type Payment struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Purpose   string    `json:"purpose"`             // "membership", "deposit", ...
+	Reference string    `json:"reference,omitempty"` // what the payment is for (booking ID, voucher code...)
+	Amount    int       `json:"amount"`              // baht
+	Status    string    `json:"status"`              // "pending", "paid" or "cancelled"
+	CreatedAt time.Time `json:"created_at"`
+	PaidAt    time.Time `json:"paid_at,omitempty"`
+}
+
+var paymentsFile = "payments.json"
+
+var (
+	paymentLock sync.Mutex
+	payments    []*Payment
+)
+
+// paymentPaidHandlers run when a payment of the given purpose is marked paid.
+var paymentPaidHandlers = map[string]func(p Payment){
+	"membership": activateMembership,
+	"voucher":    activateGiftVoucher,
+	"deposit":    markBookingDepositPaid,
+}
+
+func createPayment(userId, purpose, reference string, amount int) Payment {
+	p := &Payment{
+		ID:        fmt.Sprintf("pay_%d", time.Now().UnixNano()),
+		UserID:    userId,
+		Purpose:   purpose,
+		Reference: reference,
+		Amount:    amount,
+		Status:    "pending",
+		CreatedAt: time.Now(),
+	}
+	paymentLock.Lock()
+	payments = append(payments, p)
+	paymentLock.Unlock()
+	go savePayments()
+	log.Printf("Created %s payment %s for user %s: %d baht", purpose, p.ID, userId, amount)
+	return *p
+}
+
+// pendingPayment returns the user's open payment for a purpose, if any.
+func pendingPayment(userId, purpose string) (Payment, bool) {
+	paymentLock.Lock()
+	defer paymentLock.Unlock()
+	for _, p := range payments {
+		if p.UserID == userId && p.Purpose == purpose && p.Status == "pending" {
+			return *p, true
+		}
+	}
+	return Payment{}, false
+}
+
+// cancelPendingPayment cancels the open payment for a purpose and reference, e.g. the deposit
+// of a cancelled booking, and reports whether there was one.
+func cancelPendingPayment(purpose, reference string) bool {
+	paymentLock.Lock()
+	defer paymentLock.Unlock()
+	for _, p := range payments {
+		if p.Purpose == purpose && p.Reference == reference && p.Status == "pending" {
+			p.Status = "cancelled"
+			go savePayments()
+			return true
+		}
+	}
+	return false
+}
+
+// paymentInstructions tells the customer how to pay (PROMPTPAY_ID and/or PAYMENT_BANK_ACCOUNT).
+func paymentInstructions(p Payment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ยอดชำระ %s บาท (รหัสอ้างอิง %s)", pricing.FormatNumber(p.Amount), p.ID)
+	if id := appConfig.PromptPayID; id != "" {
+		fmt.Fprintf(&b, "\n• พร้อมเพย์: %s", id)
+	}
+	if acct := appConfig.PaymentBankAccount; acct != "" {
+		fmt.Fprintf(&b, "\n• โอนเข้าบัญชี: %s", acct)
+	}
+	b.WriteString("\nชำระแล้วรบกวนส่งสลิปในแชทนี้ได้เลย")
+	return b.String()
+}
+
+// markPaymentPaid settles a pending payment and runs its purpose handler.
+func markPaymentPaid(id string) (Payment, error) {
+	paymentLock.Lock()
+	var found *Payment
+	for _, p := range payments {
+		if p.ID == id {
+			found = p
+		}
+	}
+	if found == nil {
+		paymentLock.Unlock()
+		return Payment{}, fmt.Errorf("payment not found")
+	}
+	if found.Status != "pending" {
+		paymentLock.Unlock()
+		return *found, fmt.Errorf("payment is %s", found.Status)
+	}
+	found.Status = "paid"
+	found.PaidAt = time.Now()
+	result := *found
+	paymentLock.Unlock()
+
+	go savePayments()
+	appMetrics.inc("payments_paid_" + result.Purpose)
+	emitOutboundEvent("payment.paid", result.UserID, result)
+	if handler, ok := paymentPaidHandlers[result.Purpose]; ok {
+		handler(result)
+	}
+	return result, nil
+}
+
+func savePayments() {
+	paymentLock.Lock()
+	data, err := json.Marshal(payments)
+	paymentLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal payments: %v", err)
+		return
+	}
+	if err := os.WriteFile(paymentsFile, data, 0644); err != nil {
+		log.Printf("Failed to save payments: %v", err)
+	}
+}
+
+func loadPayments() {
+	data, err := os.ReadFile(paymentsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read payments file: %v", err)
+		}
+		return
+	}
+	paymentLock.Lock()
+	defer paymentLock.Unlock()
+	if err := json.Unmarshal(data, &payments); err != nil {
+		log.Printf("Failed to parse payments file: %v", err)
+	}
+}
+
+func handleGetPayments(c *fiber.Ctx) error {
+	status := c.Query("status")
+	userId := c.Query("user_id")
+	paymentLock.Lock()
+	defer paymentLock.Unlock()
+	result := make([]Payment, 0, len(payments))
+	for _, p := range payments {
+		if (status == "" || p.Status == status) && (userId == "" || p.UserID == userId) {
+			result = append(result, *p)
+		}
+	}
+	return c.JSON(result)
+}
+
+// handleMarkPaymentPaid is used by staff after checking the transfer slip.
+func handleMarkPaymentPaid(c *fiber.Ctx) error {
+	p, err := markPaymentPaid(c.Params("id"))
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	return c.JSON(p)
+}
+
+// depositReconcileDays is how far back the deposit_payment check looks at bookings.
+const depositReconcileDays = 30
+
+// checkDepositPayments compares booking deposits with the deposit payment records: a deposit
+// marked paid needs a paid payment of the same amount, and a paid deposit payment needs its
+// booking marked paid.
+func checkDepositPayments(ctx context.Context) ([]ReconciliationIssue, error) {
+	since := bangkokNow().AddDate(0, 0, -depositReconcileDays).Format("2006-01-02")
+	paymentLock.Lock()
+	var paid []Payment
+	for _, p := range payments {
+		if p.Purpose == "deposit" && p.Status == "paid" {
+			paid = append(paid, *p)
+		}
+	}
+	paymentLock.Unlock()
+
+	bookingLock.Lock()
+	defer bookingLock.Unlock()
+	var issues []ReconciliationIssue
+	known := map[string]bool{}
+	for _, b := range bookings {
+		known[b.ID] = true
+		if b.Date < since {
+			continue
+		}
+		var payment *Payment
+		for i := range paid {
+			if paid[i].Reference == b.ID {
+				payment = &paid[i]
+			}
+		}
+		switch {
+		case b.DepositStatus == "paid" && payment == nil:
+			issues = append(issues, ReconciliationIssue{Ref: b.ID, Message: "มัดจำเป็น paid แต่ไม่มีรายการชำระเงินมัดจำ"})
+		case payment != nil && b.DepositStatus != "paid":
+			issues = append(issues, ReconciliationIssue{Ref: b.ID,
+				Message: fmt.Sprintf("ชำระมัดจำแล้ว (%s) แต่สถานะมัดจำของคิวยังเป็น %s", payment.ID, b.DepositStatus)})
+		case payment != nil && payment.Amount != b.DepositAmount:
+			issues = append(issues, ReconciliationIssue{Ref: b.ID,
+				Message: fmt.Sprintf("ชำระมัดจำ %s บาท (%s) ไม่ตรงกับมัดจำของคิว %s บาท",
+					pricing.FormatNumber(payment.Amount), payment.ID, pricing.FormatNumber(b.DepositAmount))})
+		}
+	}
+	for _, p := range paid {
+		if !known[p.Reference] {
+			issues = append(issues, ReconciliationIssue{Ref: p.ID, Message: fmt.Sprintf("ชำระมัดจำสำหรับคิว %s ที่ไม่มีในระบบ", p.Reference)})
+		}
+	}
+	return issues, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Personal data in customer messages — phone numbers, e-mail addresses, street addresses,
+// bank accounts and payment references — is masked in two places:
+//   - log lines, unless LOG_MASK_PII=false: the slog handler masks the message and every
+//     string attribute, which covers log.Printf as well;
+//   - stored transcripts, always: conversations.json, archives and the conversation log keep
+//     the masked text. With PII_ENCRYPTION_KEY the original is stored encrypted with the
+//     message; without it the original is only kept in memory, until the server restarts.
+//     The assistant's history and the customer's own export use the original, so addresses
+//     and phones given earlier in the chat still reach the booking.
+//
+// Masking is pattern-based: addresses are recognized by a house number followed by an
+// address word (หมู่, ซอย, ถนน...), so free-form addresses without one may slip through.
+
+var (
+	piiEmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// 123-4-56789-0 bank accounts and 1-2345-67890-12-3 national IDs
+	piiAccountPattern = regexp.MustCompile(`\d{3}-\d-\d{5}-\d|\d-\d{4}-\d{5}-\d{2}-\d`)
+	// long digit runs and upper-case references; lower-case hex (LINE user IDs, log IDs) and
+	// internal IDs with a separator (pay_..., qa-...) are left alone by piiReferenceToken
+	piiTokenPattern   = regexp.MustCompile(`[0-9A-Za-z_-]{11,}`)
+	piiAddressPattern = regexp.MustCompile(`(?:บ้านเลขที่|เลขที่)?\s*\d{1,5}(?:/\d{1,5})?\s*(?:หมู่บ้าน|หมู่ที่|หมู่|ซอย|ซ\.|ถนน|ถ\.|แขวง|ตำบล|ต\.|คอนโด|อาคาร)[^\n]*`)
+)
+
+// maskPII replaces personal data in s with Thai placeholders. Image data URLs are kept as they are.
+func maskPII(s string) string {
+	if !strings.Contains(s, "data:image") {
+		return maskPIIText(s)
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range imageDataURLPattern.FindAllStringIndex(s, -1) {
+		b.WriteString(maskPIIText(s[last:loc[0]]))
+		b.WriteString(s[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(maskPIIText(s[last:]))
+	return b.String()
+}
+
+func maskPIIText(s string) string {
+	if s == "" {
+		return s
+	}
+	s = piiEmailPattern.ReplaceAllString(s, "[อีเมล]")
+	s = piiAddressPattern.ReplaceAllString(s, " [ที่อยู่]")
+	s = piiAccountPattern.ReplaceAllString(s, "[เลขบัญชี]")
+	s = replaceStandalone(thaiPhonePattern, s, "[เบอร์โทร]")
+	return piiTokenPattern.ReplaceAllStringFunc(s, func(tok string) string {
+		if piiReferenceToken(tok) {
+			return "[เลขอ้างอิง]"
+		}
+		return tok
+	})
+}
+
+// replaceStandalone replaces the matches of re that aren't part of a longer word or number,
+// so a phone pattern doesn't bite into IDs.
+func replaceStandalone(re *regexp.Regexp, s, repl string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		if (loc[0] > 0 && isWordByte(s[loc[0]-1])) || (loc[1] < len(s) && isWordByte(s[loc[1]])) {
+			continue
+		}
+		b.WriteString(s[last:loc[0]])
+		b.WriteString(repl)
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// piiReferenceToken reports whether a long token looks like a transfer reference, account
+// or ID card number: all digits, or upper-case letters with at least 8 digits.
+func piiReferenceToken(tok string) bool {
+	digits := 0
+	for _, r := range tok {
+		switch {
+		case r == '_' || r == '-' || unicode.IsLower(r):
+			return false
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+	return digits == len(tok) || digits >= 8
+}
+
+// logMaskPII reports whether log lines are masked (LOG_MASK_PII, default true).
+func logMaskPII() bool {
+	return appConfig.LogMaskPII
+}
+
+// piiMaskingHandler masks personal data in records before passing them on.
+type piiMaskingHandler struct {
+	slog.Handler
+}
+
+func (h piiMaskingHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, maskPII(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(maskPIIAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, masked)
+}
+
+func (h piiMaskingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = maskPIIAttr(a)
+	}
+	return piiMaskingHandler{h.Handler.WithAttrs(masked)}
+}
+
+func (h piiMaskingHandler) WithGroup(name string) slog.Handler {
+	return piiMaskingHandler{h.Handler.WithGroup(name)}
+}
+
+func maskPIIAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, maskPII(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		masked := make([]any, len(group))
+		for i, g := range group {
+			masked[i] = maskPIIAttr(g)
+		}
+		return slog.Group(a.Key, masked...)
+	}
+	return a
+}
+
+var (
+	piiKeyOnce sync.Once
+	piiAEAD    cipher.AEAD
+)
+
+// piiCipher returns the AES-GCM cipher for PII_ENCRYPTION_KEY (32 bytes, base64), or nil when
+// the key is unset and originals of masked messages are not kept. An invalid key stops the
+// server at startup (config.Validate).
+func piiCipher() cipher.AEAD {
+	piiKeyOnce.Do(func() {
+		if appConfig.PIIEncryptionKey == "" {
+			slog.Warn("PII_ENCRYPTION_KEY is not set; originals of masked messages are kept in memory only and lost on restart")
+			return
+		}
+		key, err := base64.StdEncoding.DecodeString(appConfig.PIIEncryptionKey)
+		if err != nil || len(key) != 32 {
+			slog.Error("PII_ENCRYPTION_KEY must be 32 bytes in base64; originals of masked messages are not kept")
+			return
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			slog.Error("Failed to set up PII encryption", "error", err)
+			return
+		}
+		piiAEAD, _ = cipher.NewGCM(block)
+	})
+	return piiAEAD
+}
+
+// sealPII encrypts text for storage next to its masked form.
+func sealPII(text string) (string, error) {
+	aead := piiCipher()
+	if aead == nil {
+		return "", errors.New("PII_ENCRYPTION_KEY is not configured")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(text), nil)), nil
+}
+
+// openPII decrypts a value from sealPII.
+func openPII(sealed string) (string, error) {
+	aead := piiCipher()
+	if aead == nil {
+		return "", errors.New("PII_ENCRYPTION_KEY is not configured")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("invalid sealed value")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// maskForStorage returns the text to store in a transcript, always masked, and, when personal
+// data was masked, the encrypted original. Without PII_ENCRYPTION_KEY sealed is empty and the
+// caller keeps the original in memory.
+func maskForStorage(text string) (masked, sealed string) {
+	masked = maskPII(text)
+	if masked == text || piiCipher() == nil {
+		return masked, ""
+	}
+	sealed, err := sealPII(text)
+	if err != nil {
+		slog.Error("Failed to encrypt message; storing it masked only", "error", err)
+		return masked, ""
+	}
+	return masked, sealed
+}
+
+// original returns the message as written, decrypting it if it was stored masked.
+func (m ConversationMessage) original() string {
+	if m.plain != "" {
+		return m.plain
+	}
+	if m.Sealed == "" {
+		return m.Text
+	}
+	plain, err := openPII(m.Sealed)
+	if err != nil {
+		slog.Error("Failed to decrypt stored message", "error", err)
+		return m.Text
+	}
+	return plain
+}
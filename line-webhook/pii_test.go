@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"testing"
+)
+
+func TestMaskPII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"โทร 081-234-5678 นะคะ", "โทร [เบอร์โทร] นะคะ"},
+		{"เบอร์ 0812345678", "เบอร์ [เบอร์โทร]"},
+		{"+66812345678", "[เบอร์โทร]"},
+		{"อีเมล test.user@example.com ค่ะ", "อีเมล [อีเมล] ค่ะ"},
+		{"ที่อยู่ 99 ซอยลาดพร้าว 10 แขวงจอมพล", "ที่อยู่ [ที่อยู่]"},
+		{"บ้านเลขที่ 123/45 หมู่ 6 ถนนสุขุมวิท", " [ที่อยู่]"},
+		{"โอนเข้า 123-4-56789-0", "โอนเข้า [เลขบัญชี]"},
+		{"บัตร 1-2345-67890-12-3", "บัตร [เลขบัญชี]"},
+		{"ref 20261015ABCD1234", "ref [เลขอ้างอิง]"},
+		{"ref 123456789012", "ref [เลขอ้างอิง]"},
+
+		// left alone: internal IDs, prices and sizes
+		{"U4af4980629abcdef0123456789abcdef", "U4af4980629abcdef0123456789abcdef"},
+		{"pay_1760000000000000", "pay_1760000000000000"},
+		{"qa-17600000000000", "qa-17600000000000"},
+		{"ซักโซฟา 3 ที่นั่ง 1,290 บาท", "ซักโซฟา 3 ที่นั่ง 1,290 บาท"},
+		{"ที่นอน 6 ฟุต 2 ชิ้น", "ที่นอน 6 ฟุต 2 ชิ้น"},
+		{"", ""},
+
+		// image data is kept byte for byte
+		{"data:image/png;base64,AAAA0812345678 โทร 0812345678", "data:image/png;base64,AAAA0812345678 โทร [เบอร์โทร]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := maskPII(tt.in); got != tt.want {
+				t.Errorf("maskPII(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// withPIIKey runs the test with PII_ENCRYPTION_KEY set to key ("" for none).
+func withPIIKey(t *testing.T, key string) {
+	t.Helper()
+	saved := appConfig
+	cfg := *appConfig
+	cfg.PIIEncryptionKey = key
+	appConfig = &cfg
+	piiKeyOnce, piiAEAD = sync.Once{}, nil
+	t.Cleanup(func() {
+		appConfig = saved
+		piiKeyOnce, piiAEAD = sync.Once{}, nil
+	})
+}
+
+func TestSealOpenPII(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	withPIIKey(t, base64.StdEncoding.EncodeToString(key))
+
+	text := "ส่งที่ 99 ซอยลาดพร้าว 10 โทร 0812345678"
+	sealed, err := sealPII(text)
+	if err != nil {
+		t.Fatalf("sealPII() error: %v", err)
+	}
+	if sealed == text {
+		t.Fatal("sealPII() returned the text unencrypted")
+	}
+	if again, _ := sealPII(text); again == sealed {
+		t.Error("sealPII() reused a nonce")
+	}
+	opened, err := openPII(sealed)
+	if err != nil || opened != text {
+		t.Fatalf("openPII(sealPII(%q)) = %q, %v", text, opened, err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := openPII(string(tampered)); err == nil {
+		t.Error("openPII() accepted a tampered value")
+	}
+	if _, err := openPII("not base64!"); err == nil {
+		t.Error("openPII() accepted an invalid value")
+	}
+
+	conv := &UserConversation{UserID: "test-user"}
+	conv.appendMessage("customer", text)
+	msg := conv.Messages[0]
+	if msg.Text != maskPII(text) || msg.Sealed == "" {
+		t.Errorf("stored message = %+v, want the masked text and a sealed original", msg)
+	}
+	msg.plain = ""
+	if got := msg.original(); got != text {
+		t.Errorf("original() = %q, want %q", got, text)
+	}
+}
+
+func TestOriginalWithoutPIIKey(t *testing.T) {
+	withPIIKey(t, "")
+	if _, err := sealPII("0812345678"); err == nil {
+		t.Error("sealPII() without a key succeeded")
+	}
+
+	text := "โทร 0812345678 นะคะ"
+	conv := &UserConversation{UserID: "test-user"}
+	conv.appendMessage("customer", text)
+	conv.appendMessage("customer", "สวัสดีค่ะ")
+	msg := conv.Messages[0]
+	if msg.Text != "โทร [เบอร์โทร] นะคะ" || msg.Sealed != "" {
+		t.Errorf("stored message = %+v, want the masked text only", msg)
+	}
+	if got := msg.original(); got != text {
+		t.Errorf("original() = %q, want the unmasked text %q for the model", got, text)
+	}
+	if got := conv.Messages[1].original(); got != "สวัสดีค่ะ" {
+		t.Errorf("original() = %q, want %q", got, "สวัสดีค่ะ")
+	}
+}
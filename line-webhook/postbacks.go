@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Buttons and quick replies that send a postback with "action=..." data are answered by a
+// postbackHandlers entry without an assistant turn. Each handler reports whether it answered;
+// postbacks nobody answers are only used for attribution.
+//
+//	action=slots                    free days this month (month=ตุลาคม 2569 for another month)
+//	action=slots&when=today         today's free slots, or the next free days when today is full
+//	action=price                    the price list carousel
+//	action=price&item=..&size=..    a quote card (service=.. optional, default disinfection)
+//	action=contact_staff            hand the chat to staff
+//	action=mode&mode=aftercare      switch the conversation mode (sales, aftercare, complaint)
+//	action=nps&survey=..&score=..   NPS answer
+var postbackHandlers = map[string]func(userId, replyToken string, values url.Values) bool{
+	"nps":           handleNPSPostback,
+	"slots":         handleSlotsPostback,
+	"price":         handlePricePostback,
+	"contact_staff": handleContactStaffPostback,
+	"mode":          handleModePostback,
+}
+
+// postbackLabels is what a button press is recorded as in the conversation, so the assistant
+// and staff see what the customer chose.
+var postbackLabels = map[string]string{
+	"slots":         "[กดปุ่ม: ดูวันว่าง]",
+	"price":         "[กดปุ่ม: ดูราคา]",
+	"contact_staff": "[กดปุ่ม: ติดต่อเจ้าหน้าที่]",
+}
+
+// handlePostback routes a LINE postback event.
+func handlePostback(userId, replyToken, data string) {
+	values, err := url.ParseQuery(data)
+	if err != nil || userId == "" {
+		return
+	}
+	action := values.Get("action")
+	if handler, ok := postbackHandlers[action]; ok {
+		if label, ok := postbackLabels[action]; ok {
+			if values.Get("when") == "today" {
+				label = "[กดปุ่ม: จองวันนี้]"
+			}
+			recordPostbackAction(userId, label)
+		}
+		if handler(userId, replyToken, values) {
+			log.Printf("Postback %s from %s answered", action, userId)
+			appMetrics.inc("postback_" + action)
+			return
+		}
+	}
+	recordPostbackAttribution(userId, data)
+}
+
+// recordPostbackAction adds the button press to the conversation as a customer message.
+func recordPostbackAction(userId, label string) {
+	userThreadLock.Lock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		conv = &UserConversation{UserID: userId}
+		userConversations[userId] = conv
+	}
+	conv.LastSeen = getBangkokTime()
+	noteChannelLocked(conv)
+	conv.appendMessage("customer", label)
+	userThreadLock.Unlock()
+}
+
+// answerPostback replies to a postback and records the answer as an automatic turn.
+func answerPostback(userId, replyToken, note string, started time.Time, messages ...map[string]interface{}) {
+	replyOrPush(userId, replyToken, messages)
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		conv.appendTurn(note, stats)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+}
+
+// askAssistant hands a button press to the assistant as if the customer had typed text.
+func askAssistant(userId, replyToken, text string) {
+	userThreadLock.Lock()
+	userMsgBuffer[userId] = append(userMsgBuffer[userId], text)
+	userThreadLock.Unlock()
+	go flushUserBuffer(userId, replyToken)
+}
+
+// staffHandling reports whether staff have taken over; buttons then only record the press.
+func staffHandling(userId string) bool {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	conv, ok := userConversations[userId]
+	return ok && conv.Takeover
+}
+
+func handleSlotsPostback(userId, replyToken string, values url.Values) bool {
+	if staffHandling(userId) {
+		return true
+	}
+	started := time.Now()
+	now := bangkokNow()
+	month := strings.TrimSpace(values.Get("month"))
+	if month == "" {
+		month = thaiMonthYear(now)
+	}
+	availability, err := slotProviderFor(userId).MonthSlots(context.Background(), month)
+	var formatErr *SlotFormatError
+	if errors.As(err, &formatErr) {
+		log.Printf("Slot data for %s not formatted: %v", month, err)
+		appMetrics.inc("slot_format_fallbacks")
+		askAssistant(userId, replyToken, "ขอดูวันว่างเดือน"+month) // the assistant can read unformatted data
+		return true
+	}
+	if err != nil {
+		flagSchedulingFallback(userId)
+		const reply = "ขออภัยค่ะ ระบบตารางนัดหมายขัดข้องชั่วคราว เจ้าหน้าที่จะติดต่อกลับเพื่อนัดหมายให้นะคะ 🙏"
+		answerPostback(userId, replyToken, reply, started, map[string]interface{}{"type": "text", "text": reply})
+		return true
+	}
+
+	var text strings.Builder
+	if values.Get("when") == "today" {
+		today := now.Format("2006-01-02")
+		var todaySlots []string
+		for _, d := range availability.Days {
+			if d.Date.Format("2006-01-02") == today {
+				todaySlots = d.Slots
+			}
+		}
+		if len(todaySlots) > 0 {
+			fmt.Fprintf(&text, "วันนี้ (%s) ยังมีคิวว่างค่ะ: %s\n", formatThaiDate(today), strings.Join(todaySlots, ", "))
+			text.WriteString("สะดวกช่วงเวลาไหน แจ้งพร้อมรายการที่ต้องการทำความสะอาดและที่อยู่ได้เลยค่ะ 😊")
+			answerPostback(userId, replyToken, text.String(), started, map[string]interface{}{"type": "text", "text": text.String()})
+			return true
+		}
+		text.WriteString("วันนี้คิวเต็มแล้วค่ะ 🙏 วันว่างที่ใกล้ที่สุด:\n")
+	}
+	text.WriteString(formatSlotSchedule(availability, customerLanguage(userId, "")))
+	text.WriteString("\n\nสะดวกวันและช่วงเวลาไหน แจ้งได้เลยค่ะ 😊")
+	answerPostback(userId, replyToken, text.String(), started, map[string]interface{}{"type": "text", "text": text.String()})
+	return true
+}
+
+func handlePricePostback(userId, replyToken string, values url.Values) bool {
+	if staffHandling(userId) {
+		return true
+	}
+	started := time.Now()
+	engine := pricingEngineFor(userId)
+	if engine.Config == nil {
+		return false
+	}
+	if item := values.Get("item"); item != "" {
+		service := values.Get("service")
+		if service == "" {
+			service = "disinfection"
+		}
+		customer := "new"
+		if isMember(userId) {
+			customer = "member"
+		}
+		if q, ok := engine.QuoteItem(pricing.QuoteRequest{ServiceType: service, ItemType: item, Size: values.Get("size"), CustomerType: customer, Today: bangkokNow().Format("2006-01-02")}); ok {
+			recordQuoteIssued(userId)
+			rememberPricingContext(userId, engine, service, item, values.Get("size"))
+			answerPostback(userId, replyToken, "[ส่งการ์ดราคา: "+q.Service+" "+q.Item+" "+q.Size+"]", started, quoteFlex(q, ""))
+			return true
+		}
+	}
+	flex, ok := priceListFlex(engine.Config)
+	if !ok {
+		return false
+	}
+	answerPostback(userId, replyToken, "[ส่งข้อมูลอัตโนมัติ: ตารางราคา]", started, flex)
+	return true
+}
+
+func handleContactStaffPostback(userId, replyToken string, values url.Values) bool {
+	started := time.Now()
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	first := conv != nil && !conv.WantsHuman && !conv.Takeover
+	if conv != nil {
+		conv.WantsHuman = true
+	}
+	userThreadLock.Unlock()
+	if first {
+		go startHandoffSummary(userId, "ลูกค้ากดปุ่มติดต่อเจ้าหน้าที่")
+	}
+	const reply = "รับทราบค่ะ ได้แจ้งเจ้าหน้าที่แล้ว จะติดต่อกลับโดยเร็วที่สุดนะคะ 🙏"
+	answerPostback(userId, replyToken, reply, started, map[string]interface{}{"type": "text", "text": reply})
+	return true
+}
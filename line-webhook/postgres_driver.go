@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// Building with -tags postgres compiles in the pgx driver so DATABASE_URL and
+// MEMBERS_DATABASE_URL can be used. pgx is already required in go.mod.
+import _ "github.com/jackc/pgx/v5/stdlib"
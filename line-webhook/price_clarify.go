@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Price questions that name an item but not its size or the service are answered at once
+// with a clarification question and quick replies built from the price list, instead of an
+// assistant turn. Each quick reply repeats the question with the missing part filled in; the
+// answer to it goes to the assistant.
+
+// priceAskWords mark a message as a price question.
+var priceAskWords = []string{"ราคา", "เท่าไหร่", "เท่าไร", "กี่บาท"}
+
+// maxClarifyRunes skips longer messages; they usually carry details the assistant should read.
+const maxClarifyRunes = 60
+
+// clarifyCooldown lets the assistant take over once a question has been asked, so a
+// customer is never asked twice in a row. Guarded by userThreadLock.
+const clarifyCooldown = 5 * time.Minute
+
+var lastPriceClarification = map[string]time.Time{}
+
+// pricingClarification is the question to ask for a price message, if any part is missing.
+type pricingClarification struct {
+	Text    string
+	Options []QuickReplyOption
+}
+
+// clarifyPricingAsk works out what a price question is missing. It reports false when the
+// message is not a price question, names no item, or is already complete.
+func clarifyPricingAsk(engine *pricing.Engine, customerKey, text string) (pricingClarification, bool) {
+	if engine.Config == nil || utf8.RuneCountInString(text) > maxClarifyRunes {
+		return pricingClarification{}, false
+	}
+	isAsk := false
+	for _, w := range priceAskWords {
+		if strings.Contains(text, w) {
+			isAsk = true
+			break
+		}
+	}
+	if !isAsk {
+		return pricingClarification{}, false
+	}
+	ex := engine.ExtractSize(text)
+	if ex.ItemKey == "" {
+		return pricingClarification{}, false
+	}
+	item := engine.Config.Items[ex.ItemKey]
+	serviceKey := engine.ServiceIn(text)
+	serviceName := ""
+	if serviceKey != "" {
+		serviceName = engine.Config.Services[serviceKey].Name
+	}
+
+	if ex.SizeKey == "" && len(item.Sizes) > 1 {
+		sizeKeys := make([]string, 0, len(item.Sizes))
+		for key := range item.Sizes {
+			if serviceKey != "" {
+				if p, ok := engine.Config.ItemPrice(serviceKey, ex.ItemKey, key, customerKey, "regular"); !ok || !p.HasValue() {
+					continue
+				}
+			}
+			sizeKeys = append(sizeKeys, key)
+		}
+		if len(sizeKeys) < 2 {
+			return pricingClarification{}, false
+		}
+		sort.Strings(sizeKeys)
+		c := pricingClarification{Text: fmt.Sprintf("รบกวนขอทราบขนาด%sด้วยนะคะ จะได้แจ้งราคาได้ถูกต้องค่ะ 😊", item.Name)}
+		for _, key := range sizeKeys {
+			size := item.Sizes[key].Name
+			c.Options = append(c.Options, QuickReplyOption{
+				Label: truncateRunes(size, 20),
+				Text:  "ราคา" + strings.TrimSpace(serviceName+" "+item.Name) + " " + size,
+			})
+		}
+		return c, true
+	}
+
+	if serviceKey == "" && len(engine.Config.Services) > 1 {
+		size := ""
+		if ex.SizeKey != "" {
+			size = item.Sizes[ex.SizeKey].Name
+		}
+		serviceKeys := make([]string, 0, len(engine.Config.Services))
+		for key := range engine.Config.Services {
+			serviceKeys = append(serviceKeys, key)
+		}
+		sort.Strings(serviceKeys)
+		c := pricingClarification{Text: fmt.Sprintf("สำหรับ%s ต้องการบริการแบบไหนคะ", strings.TrimSpace(item.Name+" "+size))}
+		for _, key := range serviceKeys {
+			name := engine.Config.Services[key].Name
+			c.Options = append(c.Options, QuickReplyOption{
+				Label: truncateRunes(name, 20),
+				Text:  strings.TrimSpace(fmt.Sprintf("ราคา%s %s %s", name, item.Name, size)),
+			})
+		}
+		c.Text += "\nถ้ายังไม่แน่ใจ บอกอาการมาได้เลย เช่น มีคราบ มีกลิ่น หรือเป็นภูมิแพ้ไรฝุ่น"
+		return c, true
+	}
+	return pricingClarification{}, false
+}
+
+// answerPricingClarification asks for the missing size or service of a price question
+// without an assistant turn. Only a message that is alone in the buffer is answered, so
+// nothing the customer wrote just before is left waiting.
+func answerPricingClarification(userId, replyToken, messageContent string) bool {
+	started := time.Now()
+	customerKey := "new"
+	if isMember(userId) {
+		customerKey = "member"
+	}
+	c, ok := clarifyPricingAsk(pricingEngineFor(userId), customerKey, normalizeInboundText(messageContent))
+	if !ok {
+		return false
+	}
+
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	buf := userMsgBuffer[userId]
+	if conv == nil || conv.Takeover || conv.WantsHuman || len(buf) != 1 || buf[0] != messageContent ||
+		time.Since(lastPriceClarification[userId]) < clarifyCooldown {
+		userThreadLock.Unlock()
+		return false
+	}
+	for id, at := range lastPriceClarification {
+		if time.Since(at) >= clarifyCooldown {
+			delete(lastPriceClarification, id)
+		}
+	}
+	lastPriceClarification[userId] = time.Now()
+	userMsgBuffer[userId] = nil
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+	conv.appendTurn(c.Text, stats)
+	userThreadLock.Unlock()
+
+	msg := CannedGreeting{Text: c.Text, QuickReplies: c.Options}.lineMessage()
+	replyOrPush(userId, replyToken, []map[string]interface{}{msg})
+	go saveConversations()
+	log.Printf("Asked user %s to clarify a price question (%d options)", userId, len(c.Options))
+	appMetrics.inc("price_clarifications")
+	return true
+}
@@ -0,0 +1,366 @@
+// Package pricing is the NCS pricing engine: the pricing_config.json schema, alias
+// matching and quote formatting. It has no LINE or OpenAI dependencies so other
+// services can embed the same engine the chatbot uses.
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Config represents the JSON pricing configuration structure
+type Config struct {
+	Services      map[string]Service      `json:"services"`
+	Items         map[string]Item         `json:"items"`
+	Packages      map[string]Package      `json:"packages"`
+	CustomerTypes map[string]CustomerType `json:"customer_types"`
+	Promotions    map[string]Promotion    `json:"promotions,omitempty"`
+}
+
+type Service struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+type Item struct {
+	Name    string          `json:"name"`
+	Aliases []string        `json:"aliases"`
+	Sizes   map[string]Size `json:"sizes"`
+}
+
+type Size struct {
+	Name    string                                 `json:"name"`
+	Aliases []string                               `json:"aliases"`
+	Pricing map[string]map[string]map[string]Price `json:"pricing"` // [service][customer][package]
+}
+
+type Price struct {
+	FullPrice  int `json:"full_price,omitempty"`
+	Discount35 int `json:"discount_35,omitempty"`
+	Discount50 int `json:"discount_50,omitempty"`
+}
+
+type Package struct {
+	Name         string                  `json:"name"`
+	Aliases      []string                `json:"aliases"`
+	Disinfection map[string]PackagePrice `json:"disinfection,omitempty"`
+	Washing      map[string]PackagePrice `json:"washing,omitempty"`
+}
+
+type PackagePrice struct {
+	FullPrice  int `json:"full_price"`
+	Discount   int `json:"discount"`
+	SalePrice  int `json:"sale_price"`
+	PerItem    int `json:"per_item"`
+	DepositMin int `json:"deposit_min,omitempty"`
+}
+
+type CustomerType struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// HasValue reports whether any price tier is set.
+func (p Price) HasValue() bool {
+	return p.FullPrice > 0 || p.Discount35 > 0 || p.Discount50 > 0
+}
+
+// HasValue reports whether any package amount is set.
+func (p PackagePrice) HasValue() bool {
+	return p.FullPrice > 0 || p.Discount > 0 || p.SalePrice > 0 || p.PerItem > 0
+}
+
+// Parse decodes a pricing_config.json document.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	cfg.Sanitize()
+	return cfg, nil
+}
+
+// Sanitize fills in nil maps so callers can index and assign without checks.
+func (cfg *Config) Sanitize() {
+	if cfg == nil {
+		return
+	}
+	if cfg.Services == nil {
+		cfg.Services = make(map[string]Service)
+	}
+	if cfg.Items == nil {
+		cfg.Items = make(map[string]Item)
+	}
+	if cfg.Packages == nil {
+		cfg.Packages = make(map[string]Package)
+	}
+	if cfg.CustomerTypes == nil {
+		cfg.CustomerTypes = make(map[string]CustomerType)
+	}
+	for itemKey, item := range cfg.Items {
+		if item.Sizes == nil {
+			item.Sizes = make(map[string]Size)
+		}
+		for sizeKey, sizeCfg := range item.Sizes {
+			if sizeCfg.Pricing == nil {
+				sizeCfg.Pricing = make(map[string]map[string]map[string]Price)
+			}
+			for serviceKey, customerMap := range sizeCfg.Pricing {
+				if customerMap == nil {
+					sizeCfg.Pricing[serviceKey] = make(map[string]map[string]Price)
+					continue
+				}
+				for customerKey, packageMap := range customerMap {
+					if packageMap == nil {
+						customerMap[customerKey] = make(map[string]Price)
+					}
+				}
+			}
+			item.Sizes[sizeKey] = sizeCfg
+		}
+		cfg.Items[itemKey] = item
+	}
+	for pkgKey, pkg := range cfg.Packages {
+		if pkg.Disinfection == nil {
+			pkg.Disinfection = make(map[string]PackagePrice)
+		}
+		if pkg.Washing == nil {
+			pkg.Washing = make(map[string]PackagePrice)
+		}
+		cfg.Packages[pkgKey] = pkg
+	}
+}
+
+// Clone returns a deep copy of the config.
+func (cfg *Config) Clone() (*Config, error) {
+	if cfg == nil {
+		return nil, errors.New("pricing config is nil")
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy pricing config: %w", err)
+	}
+	clone, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild clone: %w", err)
+	}
+	return clone, nil
+}
+
+// Scaled returns a copy with every price adjusted by percent (e.g. 10 for +10%, -5 for -5%),
+// rounded to the nearest 10 baht. Used for branches priced differently from the base config.
+func (cfg *Config) Scaled(percent float64) (*Config, error) {
+	clone, err := cfg.Clone()
+	if err != nil {
+		return nil, err
+	}
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return int(math.Round(float64(n)*(100+percent)/1000)) * 10
+	}
+	for _, item := range clone.Items {
+		for _, size := range item.Sizes {
+			for _, customerMap := range size.Pricing {
+				for _, packageMap := range customerMap {
+					for pkgKey, p := range packageMap {
+						packageMap[pkgKey] = Price{FullPrice: scale(p.FullPrice), Discount35: scale(p.Discount35), Discount50: scale(p.Discount50)}
+					}
+				}
+			}
+		}
+	}
+	for _, pkg := range clone.Packages {
+		for _, prices := range []map[string]PackagePrice{pkg.Disinfection, pkg.Washing} {
+			for qty, p := range prices {
+				p.FullPrice = scale(p.FullPrice)
+				p.SalePrice = scale(p.SalePrice)
+				p.Discount = p.FullPrice - p.SalePrice
+				p.PerItem = scale(p.PerItem)
+				prices[qty] = p
+			}
+		}
+	}
+	return clone, nil
+}
+
+// SetItemPrice stores the price for one service/item/size/customer/package combination.
+// The service, item and size must already exist.
+func (cfg *Config) SetItemPrice(serviceKey, itemKey, sizeKey, customerKey, packageKey string, price Price) error {
+	if _, ok := cfg.Services[serviceKey]; !ok {
+		return fmt.Errorf("unknown service_key '%s'", serviceKey)
+	}
+	item, ok := cfg.Items[itemKey]
+	if !ok {
+		return fmt.Errorf("unknown item_key '%s'", itemKey)
+	}
+	sizeCfg, ok := item.Sizes[sizeKey]
+	if !ok {
+		return fmt.Errorf("unknown size_key '%s'", sizeKey)
+	}
+	if sizeCfg.Pricing == nil {
+		sizeCfg.Pricing = make(map[string]map[string]map[string]Price)
+	}
+	if _, ok := sizeCfg.Pricing[serviceKey]; !ok {
+		sizeCfg.Pricing[serviceKey] = make(map[string]map[string]Price)
+	}
+	customerPricing := sizeCfg.Pricing[serviceKey]
+	if _, ok := customerPricing[customerKey]; !ok {
+		customerPricing[customerKey] = make(map[string]Price)
+	}
+	customerPricing[customerKey][packageKey] = price
+	item.Sizes[sizeKey] = sizeCfg
+	cfg.Items[itemKey] = item
+	return nil
+}
+
+// SetPackagePrice stores a promotion package price for a quantity. Only the
+// disinfection and washing services carry package pricing.
+func (cfg *Config) SetPackagePrice(packageKey, serviceKey string, quantity int, price PackagePrice) error {
+	pkg, ok := cfg.Packages[packageKey]
+	if !ok {
+		return fmt.Errorf("unknown package_key '%s'", packageKey)
+	}
+	quantityKey := strconv.Itoa(quantity)
+	switch serviceKey {
+	case "disinfection":
+		if pkg.Disinfection == nil {
+			pkg.Disinfection = make(map[string]PackagePrice)
+		}
+		pkg.Disinfection[quantityKey] = price
+	case "washing":
+		if pkg.Washing == nil {
+			pkg.Washing = make(map[string]PackagePrice)
+		}
+		pkg.Washing[quantityKey] = price
+	default:
+		return fmt.Errorf("service_key '%s' is not supported for promotions", serviceKey)
+	}
+	cfg.Packages[packageKey] = pkg
+	return nil
+}
+
+// ItemPrice looks up the price for a resolved combination.
+func (cfg *Config) ItemPrice(serviceKey, itemKey, sizeKey, customerKey, packageKey string) (Price, bool) {
+	item, ok := cfg.Items[itemKey]
+	if !ok {
+		return Price{}, false
+	}
+	price, ok := item.Sizes[sizeKey].Pricing[serviceKey][customerKey][packageKey]
+	return price, ok
+}
+
+// PackagePriceFor looks up a promotion package price for a service and quantity.
+func (cfg *Config) PackagePriceFor(packageKey, serviceKey string, quantity int) (PackagePrice, bool) {
+	pkg, ok := cfg.Packages[packageKey]
+	if !ok {
+		return PackagePrice{}, false
+	}
+	var prices map[string]PackagePrice
+	switch serviceKey {
+	case "disinfection":
+		prices = pkg.Disinfection
+	case "washing":
+		prices = pkg.Washing
+	}
+	price, ok := prices[strconv.Itoa(quantity)]
+	return price, ok
+}
+
+// Validate checks that the config is usable before it goes live: every entry has a name,
+// prices and promotions refer to existing services, items, customer types and packages, no
+// price is negative or a discount above its full price, and promotion codes are unique.
+func (cfg *Config) Validate() error {
+	if cfg == nil {
+		return errors.New("pricing config is nil")
+	}
+	if len(cfg.Services) == 0 || len(cfg.Items) == 0 || len(cfg.CustomerTypes) == 0 {
+		return errors.New("services, items and customer_types must not be empty")
+	}
+	for key, s := range cfg.Services {
+		if s.Name == "" {
+			return fmt.Errorf("service '%s' needs a name", key)
+		}
+	}
+	for key, c := range cfg.CustomerTypes {
+		if c.Name == "" {
+			return fmt.Errorf("customer type '%s' needs a name", key)
+		}
+	}
+	for key, p := range cfg.Packages {
+		if p.Name == "" {
+			return fmt.Errorf("package '%s' needs a name", key)
+		}
+		for _, prices := range []map[string]PackagePrice{p.Disinfection, p.Washing} {
+			for qty, price := range prices {
+				if n, err := strconv.Atoi(qty); err != nil || n <= 0 {
+					return fmt.Errorf("package '%s': quantity '%s' must be a positive number", key, qty)
+				}
+				if price.FullPrice < 0 || price.Discount < 0 || price.SalePrice < 0 || price.PerItem < 0 || price.DepositMin < 0 {
+					return fmt.Errorf("package '%s' quantity %s: prices must not be negative", key, qty)
+				}
+			}
+		}
+	}
+	codes := make(map[string]string)
+	for key, p := range cfg.Promotions {
+		if err := p.validate(cfg); err != nil {
+			return fmt.Errorf("promotion '%s': %w", key, err)
+		}
+		if p.Code == "" {
+			continue
+		}
+		code := strings.ToLower(p.Code)
+		if other, dup := codes[code]; dup {
+			return fmt.Errorf("promotions '%s' and '%s' share the code '%s'", other, key, p.Code)
+		}
+		codes[code] = key
+	}
+	for itemKey, item := range cfg.Items {
+		if item.Name == "" {
+			return fmt.Errorf("item '%s' needs a name", itemKey)
+		}
+		if len(item.Sizes) == 0 {
+			return fmt.Errorf("item '%s' needs at least one size", itemKey)
+		}
+		for sizeKey, size := range item.Sizes {
+			if size.Name == "" {
+				return fmt.Errorf("item '%s' size '%s' needs a name", itemKey, sizeKey)
+			}
+			for serviceKey, customerMap := range size.Pricing {
+				if _, ok := cfg.Services[serviceKey]; !ok {
+					return fmt.Errorf("item '%s' size '%s': unknown service '%s'", itemKey, sizeKey, serviceKey)
+				}
+				for customerKey, packageMap := range customerMap {
+					if _, ok := cfg.CustomerTypes[customerKey]; !ok {
+						return fmt.Errorf("item '%s' size '%s': unknown customer type '%s'", itemKey, sizeKey, customerKey)
+					}
+					for packageKey, p := range packageMap {
+						if _, ok := cfg.Packages[packageKey]; !ok && packageKey != "regular" {
+							return fmt.Errorf("item '%s' size '%s': unknown package '%s'", itemKey, sizeKey, packageKey)
+						}
+						if err := p.validate(); err != nil {
+							return fmt.Errorf("item '%s' size '%s' %s/%s/%s: %w", itemKey, sizeKey, serviceKey, customerKey, packageKey, err)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p Price) validate() error {
+	if p.FullPrice < 0 || p.Discount35 < 0 || p.Discount50 < 0 {
+		return errors.New("prices must not be negative")
+	}
+	if p.FullPrice > 0 && (p.Discount35 > p.FullPrice || p.Discount50 > p.FullPrice) {
+		return errors.New("a discounted price is above the full price")
+	}
+	return nil
+}
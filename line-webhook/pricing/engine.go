@@ -0,0 +1,338 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Engine answers price questions against a Config.
+type Engine struct {
+	Config *Config
+	// Normalize is applied to both the input and each alias before comparing.
+	// When nil, only case and surrounding whitespace are ignored.
+	Normalize func(string) string
+}
+
+// QuoteRequest is a free-text price question; fields may be keys, aliases or display names.
+type QuoteRequest struct {
+	ServiceType  string
+	ItemType     string
+	Size         string
+	CustomerType string // defaults to "new"
+	PackageType  string // defaults to "regular"
+	Quantity     int    // package quantity
+	PromoCode    string // code of a promotion the customer gave
+	Today        string // YYYY-MM-DD for promotion dates; defaults to the local date
+}
+
+func (req QuoteRequest) today() string {
+	if req.Today != "" {
+		return req.Today
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+func (e *Engine) normalize(s string) string {
+	if e.Normalize != nil {
+		s = e.Normalize(s)
+	}
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// MatchAlias reports whether input equals one of the aliases after normalization.
+func (e *Engine) MatchAlias(input string, aliases []string) bool {
+	input = e.normalize(input)
+	for _, alias := range aliases {
+		if e.normalize(alias) == input {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) ServiceKey(input string) string {
+	for key, service := range e.Config.Services {
+		if e.MatchAlias(input, service.Aliases) {
+			return key
+		}
+	}
+	return ""
+}
+
+func (e *Engine) ItemKey(input string) string {
+	for key, item := range e.Config.Items {
+		if e.MatchAlias(input, item.Aliases) {
+			return key
+		}
+	}
+	return ""
+}
+
+func (e *Engine) PackageKey(input string) string {
+	for key, pkg := range e.Config.Packages {
+		if e.MatchAlias(input, pkg.Aliases) {
+			return key
+		}
+	}
+	return ""
+}
+
+func (e *Engine) CustomerKey(input string) string {
+	for key, customer := range e.Config.CustomerTypes {
+		if e.MatchAlias(input, customer.Aliases) {
+			return key
+		}
+	}
+	return ""
+}
+
+func (e *Engine) SizeKey(input string, sizes map[string]Size) string {
+	for key, size := range sizes {
+		if e.MatchAlias(input, size.Aliases) {
+			return key
+		}
+	}
+	return ""
+}
+
+// ItemQuote is the structured price of one sized item, for rendering as a card.
+type ItemQuote struct {
+	Service    string // display names
+	Item       string
+	Size       string
+	Customer   string
+	Price      Price
+	Promotion  *Promotion // running promotion applied to the best price, if any
+	PromoPrice int        // best price after the promotion
+}
+
+// promote applies the best running promotion to the price's best tier.
+func (e *Engine) promote(req QuoteRequest, serviceKey, itemKey, customerKey string, price Price) (*Promotion, int) {
+	best := price.BestPrice()
+	if p, ok := e.Config.BestPromotion(serviceKey, itemKey, customerKey, req.PromoCode, req.today(), best); ok {
+		return &p, p.Apply(best)
+	}
+	return nil, 0
+}
+
+// QuoteItem resolves the request to a single priced item and size. It reports false for
+// package quotes and whenever Quote would answer with a size list or a fallback.
+func (e *Engine) QuoteItem(req QuoteRequest) (ItemQuote, bool) {
+	if e.Config == nil {
+		return ItemQuote{}, false
+	}
+	serviceKey, itemKey, size, customerKey, packageKey := e.resolve(req)
+	if packageKey != "regular" || serviceKey == "" || itemKey == "" || size == "" {
+		return ItemQuote{}, false
+	}
+	item := e.Config.Items[itemKey]
+	sizeKey := e.SizeKey(size, item.Sizes)
+	if sizeKey == "" {
+		sizeKey = e.extract(size, itemKey).SizeKey
+	}
+	if sizeKey == "" {
+		return ItemQuote{}, false
+	}
+	price, ok := e.Config.ItemPrice(serviceKey, itemKey, sizeKey, customerKey, "regular")
+	if !ok || !price.HasValue() {
+		return ItemQuote{}, false
+	}
+	promo, promoPrice := e.promote(req, serviceKey, itemKey, customerKey, price)
+	return ItemQuote{
+		Service:    e.Config.Services[serviceKey].Name,
+		Item:       item.Name,
+		Size:       item.Sizes[sizeKey].Name,
+		Customer:   e.Config.CustomerTypes[customerKey].Name,
+		Price:      price,
+		Promotion:  promo,
+		PromoPrice: promoPrice,
+	}, true
+}
+
+var (
+	// ErrNotFound means nothing in the price list matches the request.
+	ErrNotFound = errors.New("pricing: no price for the request")
+	// ErrNotLoaded means the engine has no price list.
+	ErrNotLoaded = errors.New("pricing: price list not loaded")
+)
+
+// Quote returns a Thai-language price answer for the request. With ErrNotFound or
+// ErrNotLoaded the answer is the apology to pass on instead.
+func (e *Engine) Quote(req QuoteRequest) (string, error) {
+	if e.Config == nil {
+		return "ระบบราคายังไม่พร้อมใช้งาน กรุณาลองใหม่อีกครั้ง", ErrNotLoaded
+	}
+
+	serviceKey, itemKey, size, customerKey, packageKey := e.resolve(req)
+	if packageKey != "regular" {
+		quote, err := e.packageQuote(serviceKey, packageKey, req.Quantity)
+		return quote + e.promoCodeNote(req, nil), err
+	}
+	if serviceKey == "" || itemKey == "" {
+		return FallbackResponse(req.ServiceType, req.ItemType, req.Size), ErrNotFound
+	}
+	quote, used, err := e.itemQuote(req, serviceKey, itemKey, size, customerKey)
+	return quote + e.promoCodeNote(req, used), err
+}
+
+// promoCodeNote explains why the customer's promo code was not applied; used lists the
+// promotions the quote applied.
+func (e *Engine) promoCodeNote(req QuoteRequest, used []Promotion) string {
+	code := strings.TrimSpace(req.PromoCode)
+	if code == "" {
+		return ""
+	}
+	for _, p := range used {
+		if strings.EqualFold(p.Code, code) {
+			return ""
+		}
+	}
+	p, ok := e.Config.PromotionByCode(code)
+	switch {
+	case !ok:
+		return fmt.Sprintf("\n⚠️ ไม่พบรหัสโปรโมชั่น %s", code)
+	case !p.ActiveOn(req.today()):
+		return fmt.Sprintf("\n⚠️ รหัสโปรโมชั่น %s (%s) ไม่อยู่ในช่วงเวลาโปรโมชั่น", code, p.Name)
+	case len(used) > 0:
+		return fmt.Sprintf("\n⚠️ รหัสโปรโมชั่น %s ลดได้น้อยกว่าโปรโมชั่นที่ใช้อยู่ จึงไม่ได้ใช้", code)
+	}
+	return fmt.Sprintf("\n⚠️ รหัสโปรโมชั่น %s (%s) ใช้กับรายการนี้ไม่ได้", code, p.Name)
+}
+
+// resolve maps the free-text request to config keys, defaulting customer and package.
+func (e *Engine) resolve(req QuoteRequest) (serviceKey, itemKey, size, customerKey, packageKey string) {
+	serviceKey = e.ServiceKey(req.ServiceType)
+	itemKey = e.ItemKey(req.ItemType)
+	customerKey = e.CustomerKey(req.CustomerType)
+	packageKey = e.PackageKey(req.PackageType)
+	size = req.Size
+	if itemKey == "" {
+		// the size is often written into the item itself, e.g. "ที่นอนหกฟุต"
+		text := strings.TrimSpace(req.ItemType + " " + req.Size)
+		if ex := e.ExtractSize(text); ex.ItemKey != "" {
+			itemKey, size = ex.ItemKey, text
+		}
+	}
+	if customerKey == "" {
+		customerKey = "new"
+	}
+	if packageKey == "" {
+		packageKey = "regular"
+	}
+	return
+}
+
+func (e *Engine) packageQuote(serviceKey, packageKey string, quantity int) (string, error) {
+	pkg, exists := e.Config.Packages[packageKey]
+	if !exists {
+		return "ไม่พบข้อมูลแพคเพจที่ระบุ", ErrNotFound
+	}
+
+	serviceName := "ทำความสะอาด"
+	if serviceKey != "" {
+		serviceName = e.Config.Services[serviceKey].Name
+	}
+
+	if price, ok := e.Config.PackagePriceFor(packageKey, serviceKey, quantity); ok {
+		return FormatPackagePrice(price, serviceName, pkg.Name, quantity), nil
+	}
+	return fmt.Sprintf("ไม่พบข้อมูลราคา%s %d ใบ สำหรับบริการ%s", pkg.Name, quantity, serviceName), ErrNotFound
+}
+
+// itemQuote prices one item and returns the promotions it applied.
+func (e *Engine) itemQuote(req QuoteRequest, serviceKey, itemKey, size, customerKey string) (string, []Promotion, error) {
+	item, exists := e.Config.Items[itemKey]
+	if !exists {
+		return "ไม่พบข้อมูลสินค้าที่ระบุ", nil, ErrNotFound
+	}
+
+	service := e.Config.Services[serviceKey]
+	customer := e.Config.CustomerTypes[customerKey]
+
+	if size == "" {
+		return e.sizeList(req, serviceKey, itemKey, customerKey)
+	}
+	sizeKey := e.SizeKey(size, item.Sizes)
+	if sizeKey == "" {
+		sizeKey = e.extract(size, itemKey).SizeKey
+	}
+	if sizeKey == "" {
+		return e.sizeList(req, serviceKey, itemKey, customerKey)
+	}
+
+	sizeConfig := item.Sizes[sizeKey]
+	if price, ok := e.Config.ItemPrice(serviceKey, itemKey, sizeKey, customerKey, "regular"); ok {
+		quote := FormatPrice(price, service.Name, item.Name, sizeConfig.Name, customer.Name)
+		if promo, promoPrice := e.promote(req, serviceKey, itemKey, customerKey, price); promo != nil {
+			return quote + FormatPromotion(*promo, price.BestPrice(), promoPrice), []Promotion{*promo}, nil
+		}
+		return quote, nil, nil
+	}
+	return fmt.Sprintf("ไม่พบข้อมูลราคา%s %s %s สำหรับ%s", item.Name, sizeConfig.Name, service.Name, customer.Name), nil, ErrNotFound
+}
+
+// SizeList lists the regular price of every size of an item, used when the size is missing or unknown.
+func (e *Engine) SizeList(serviceKey, itemKey, customerKey string) string {
+	list, _, _ := e.sizeList(QuoteRequest{}, serviceKey, itemKey, customerKey)
+	return list
+}
+
+// sizeList is SizeList with running promotions applied, returning the promotions used.
+func (e *Engine) sizeList(req QuoteRequest, serviceKey, itemKey, customerKey string) (string, []Promotion, error) {
+	item := e.Config.Items[itemKey]
+	service := e.Config.Services[serviceKey]
+	customer := e.Config.CustomerTypes[customerKey]
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("บริการทำความสะอาด%s %s", item.Name, service.Name))
+	if customerKey != "new" {
+		result.WriteString(fmt.Sprintf(" สำหรับ%s", customer.Name))
+	}
+	result.WriteString(":\n")
+
+	count := 0
+	var used []Promotion
+	for sizeKey, sizeConfig := range item.Sizes {
+		pricing, ok := e.Config.ItemPrice(serviceKey, itemKey, sizeKey, customerKey, "regular")
+		if !ok {
+			continue
+		}
+		count++
+		result.WriteString(fmt.Sprintf("• %s %s: ", item.Name, sizeConfig.Name))
+
+		parts := []string{}
+		if pricing.FullPrice > 0 {
+			parts = append(parts, fmt.Sprintf("%s บาท", FormatNumber(pricing.FullPrice)))
+		}
+		if pricing.Discount35 > 0 {
+			parts = append(parts, fmt.Sprintf("ลด 35%% = %s บาท", FormatNumber(pricing.Discount35)))
+		}
+		if pricing.Discount50 > 0 {
+			parts = append(parts, fmt.Sprintf("ลด 50%% = %s บาท", FormatNumber(pricing.Discount50)))
+		}
+		if promo, promoPrice := e.promote(req, serviceKey, itemKey, customerKey, pricing); promo != nil {
+			parts = append(parts, fmt.Sprintf("โปรโมชั่น %s = %s บาท", promo.Name, FormatNumber(promoPrice)))
+			if !slices.ContainsFunc(used, func(p Promotion) bool { return p.Name == promo.Name }) {
+				used = append(used, *promo)
+			}
+		}
+		result.WriteString(strings.Join(parts, ", "))
+		result.WriteString("\n")
+	}
+
+	if count == 0 {
+		return fmt.Sprintf("ไม่พบข้อมูลราคา%s สำหรับบริการ%s", item.Name, service.Name), nil, ErrNotFound
+	}
+	for _, p := range used {
+		result.WriteString("\n" + p.Describe())
+	}
+	if len(used) > 0 {
+		result.WriteString("\n")
+	}
+
+	result.WriteString(fmt.Sprintf("\nกรุณาระบุขนาด%sเพื่อข้อมูลราคาที่แม่นยำ", item.Name))
+	return result.String(), used, nil
+}
@@ -0,0 +1,245 @@
+package pricing
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Extraction is what ExtractSize found in free text such as "ที่นอนหกฟุต 2 หลัง",
+// "โซฟา ๓ ที่นั่ง" or "พรม 12 ตารางเมตร". Empty fields were not found.
+type Extraction struct {
+	ItemKey  string  `json:"item_key,omitempty"`
+	SizeKey  string  `json:"size_key,omitempty"`
+	Number   float64 `json:"number,omitempty"`   // the measurement as written: 6 (ft), 3 (seats), 12 (sqm)
+	Unit     string  `json:"unit,omitempty"`     // "ft", "seat" or "sqm"
+	Quantity int     `json:"quantity,omitempty"` // pieces ("2 หลัง"), or the area rounded up for per-sqm items
+}
+
+// thaiNumberWords matches spelled-out Thai numbers ("หก", "สิบสอง", "ยี่สิบ", "สองร้อย").
+const thaiNumberWords = `(?:ศูนย์|หนึ่ง|เอ็ด|สอง|ยี่|สาม|สี่|ห้า|หก|เจ็ด|แปด|เก้า|สิบ|ร้อย|พัน|หมื่น|แสน|ล้าน)+`
+
+var (
+	measurementPattern = regexp.MustCompile(`(\d+(?:\.\d+)?|` + thaiNumberWords + `)\s*(ฟุต|feet|foot|ft\.?|ที่นั่ง|seater|seats?|ตารางเมตร|ตร\.?\s?ม\.?|sq\.?\s?m|m2)(\s*ครึ่ง)?`)
+	quantityPattern    = regexp.MustCompile(`(\d+|` + thaiNumberWords + `)\s*(หลัง|ตัว|ชิ้น|ผืน|ชุด|pcs|pieces?)`)
+	sizeKeyRange       = regexp.MustCompile(`^(\d+(?:\.\d+)?)(?:-(\d+(?:\.\d+)?))?(ft|seat)$`)
+)
+
+var thaiDigitWords = []struct {
+	word  string
+	value int
+}{
+	{"ศูนย์", 0}, {"หนึ่ง", 1}, {"เอ็ด", 1}, {"สอง", 2}, {"ยี่", 2}, {"สาม", 3},
+	{"สี่", 4}, {"ห้า", 5}, {"หก", 6}, {"เจ็ด", 7}, {"แปด", 8}, {"เก้า", 9},
+}
+
+// thaiMultiplierWords are the place values of spelled-out numbers; ล้าน multiplies all
+// that comes before it.
+var thaiMultiplierWords = []struct {
+	word  string
+	value int
+}{
+	{"สิบ", 10}, {"ร้อย", 100}, {"พัน", 1000}, {"หมื่น", 10000}, {"แสน", 100000}, {"ล้าน", 1000000},
+}
+
+// ParseThaiNumber converts ASCII or Thai digits ("12", "๑๒") or Thai words ("สิบสอง",
+// "สองร้อยห้าสิบ") to a number. Two digit words in a row ("สองสาม") are not a number.
+func ParseThaiNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(thaiDigitsToASCII(s))
+	if s == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, true
+	}
+	millions, total, digit := 0, 0, -1
+next:
+	for s != "" {
+		for _, w := range thaiMultiplierWords {
+			if !strings.HasPrefix(s, w.word) {
+				continue
+			}
+			switch {
+			case w.value == 1000000:
+				group := total + max(digit, 0)
+				if group == 0 {
+					group = 1 // "ล้าน" on its own
+				}
+				millions += group * w.value
+				total = 0
+			case digit < 0:
+				total += w.value // "สิบ", "ร้อย" on their own
+			default:
+				total += digit * w.value
+			}
+			digit = -1
+			s = strings.TrimPrefix(s, w.word)
+			continue next
+		}
+		for _, w := range thaiDigitWords {
+			if strings.HasPrefix(s, w.word) {
+				if digit >= 0 {
+					return 0, false
+				}
+				digit = w.value
+				s = strings.TrimPrefix(s, w.word)
+				continue next
+			}
+		}
+		return 0, false
+	}
+	if digit >= 0 {
+		total += digit
+	}
+	return float64(millions + total), true
+}
+
+func thaiDigitsToASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '๐' && r <= '๙' {
+			return '0' + (r - '๐')
+		}
+		return r
+	}, s)
+}
+
+func canonicalUnit(unit string) string {
+	switch {
+	case strings.Contains(unit, "ฟุต"), strings.HasPrefix(unit, "f"):
+		return "ft"
+	case strings.Contains(unit, "ที่นั่ง"), strings.HasPrefix(unit, "seat"):
+		return "seat"
+	default:
+		return "sqm"
+	}
+}
+
+// thaiUnitName is the unit as written in size aliases ("6ฟุต", "3ที่นั่ง").
+var thaiUnitName = map[string]string{"ft": "ฟุต", "seat": "ที่นั่ง"}
+
+// ExtractSize finds the item, size and quantity mentioned in free text and maps them to
+// config keys. The item is taken from an alias in the text, or inferred from the unit.
+func (e *Engine) ExtractSize(text string) Extraction {
+	return e.extract(text, "")
+}
+
+// extract is ExtractSize with the item already known when itemKey is set.
+func (e *Engine) extract(text, itemKey string) Extraction {
+	var ex Extraction
+	if e.Config == nil {
+		return ex
+	}
+	text = strings.ToLower(thaiDigitsToASCII(text))
+	ex.ItemKey = itemKey
+	if ex.ItemKey == "" {
+		ex.ItemKey = e.findItemIn(text)
+	}
+
+	if m := measurementPattern.FindStringSubmatch(text); m != nil {
+		if n, ok := ParseThaiNumber(m[1]); ok {
+			if m[3] != "" {
+				n += 0.5 // "สามฟุตครึ่ง"
+			}
+			ex.Number = n
+			ex.Unit = canonicalUnit(m[2])
+		}
+	}
+	if m := quantityPattern.FindStringSubmatch(text); m != nil {
+		if n, ok := ParseThaiNumber(m[1]); ok && n > 0 {
+			ex.Quantity = int(n)
+		}
+	}
+	if ex.Unit == "" {
+		return ex
+	}
+
+	candidates := []string{ex.ItemKey}
+	if ex.ItemKey == "" {
+		candidates = candidates[:0]
+		for key := range e.Config.Items {
+			candidates = append(candidates, key)
+		}
+	}
+	for _, itemKey := range candidates {
+		if sizeKey := e.sizeForMeasurement(e.Config.Items[itemKey].Sizes, ex.Number, ex.Unit); sizeKey != "" {
+			ex.ItemKey = itemKey
+			ex.SizeKey = sizeKey
+			break
+		}
+	}
+	if ex.Unit == "sqm" && ex.SizeKey != "" && ex.Quantity == 0 {
+		ex.Quantity = int(math.Ceil(ex.Number))
+	}
+	return ex
+}
+
+// findItemIn returns the item whose longest alias appears in the text.
+func (e *Engine) findItemIn(text string) string {
+	aliases := make(map[string][]string, len(e.Config.Items))
+	for key, item := range e.Config.Items {
+		aliases[key] = item.Aliases
+	}
+	return e.longestAliasIn(text, aliases)
+}
+
+// ServiceIn returns the service whose longest alias appears in free text, or "".
+func (e *Engine) ServiceIn(text string) string {
+	if e.Config == nil {
+		return ""
+	}
+	aliases := make(map[string][]string, len(e.Config.Services))
+	for key, service := range e.Config.Services {
+		aliases[key] = service.Aliases
+	}
+	return e.longestAliasIn(text, aliases)
+}
+
+func (e *Engine) longestAliasIn(text string, aliases map[string][]string) string {
+	normalized := e.normalize(text)
+	best, bestLen := "", 0
+	for key, list := range aliases {
+		for _, alias := range list {
+			a := e.normalize(alias)
+			if len([]rune(a)) < 2 || len(a) <= bestLen {
+				continue
+			}
+			if strings.Contains(normalized, a) {
+				best, bestLen = key, len(a)
+			}
+		}
+	}
+	return best
+}
+
+// sizeForMeasurement matches a measurement against size aliases ("6ฟุต"), then against
+// numeric ranges encoded in size keys ("5-6ft", "3seat"), then per-area sizes.
+func (e *Engine) sizeForMeasurement(sizes map[string]Size, n float64, unit string) string {
+	if name, ok := thaiUnitName[unit]; ok {
+		if key := e.SizeKey(strconv.FormatFloat(n, 'f', -1, 64)+name, sizes); key != "" {
+			return key
+		}
+	}
+	for key := range sizes {
+		m := sizeKeyRange.FindStringSubmatch(key)
+		if m == nil || m[3] != unit {
+			continue
+		}
+		lo, _ := strconv.ParseFloat(m[1], 64)
+		hi := lo
+		if m[2] != "" {
+			hi, _ = strconv.ParseFloat(m[2], 64)
+		}
+		if n >= lo && n <= hi {
+			return key
+		}
+	}
+	if unit == "sqm" {
+		for key, size := range sizes {
+			if strings.Contains(key, "sqm") || e.MatchAlias("ตรม", size.Aliases) {
+				return key
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package pricing
+
+import "testing"
+
+func TestParseThaiNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		// digits
+		{"12", 12, true},
+		{"3.5", 3.5, true},
+		{"๑๒", 12, true},
+		{"๓", 3, true},
+		{" 6 ", 6, true},
+
+		// words
+		{"ศูนย์", 0, true},
+		{"หก", 6, true},
+		{"สิบ", 10, true},
+		{"สิบเอ็ด", 11, true},
+		{"สิบสอง", 12, true},
+		{"ยี่สิบ", 20, true},
+		{"ยี่สิบเอ็ด", 21, true},
+		{"สามสิบห้า", 35, true},
+		{"เก้าสิบเก้า", 99, true},
+
+		// compound
+		{"ร้อย", 100, true},
+		{"สองร้อย", 200, true},
+		{"หนึ่งร้อยยี่สิบ", 120, true},
+		{"ร้อยห้าสิบ", 150, true},
+		{"สองร้อยเอ็ด", 201, true},
+		{"พันห้าร้อย", 1500, true},
+		{"สองพันห้าร้อย", 2500, true},
+		{"หนึ่งหมื่นสองพัน", 12000, true},
+		{"สามแสน", 300000, true},
+		{"ล้าน", 1000000, true},
+		{"สองล้านห้าแสน", 2500000, true},
+		{"ร้อยล้าน", 100000000, true},
+
+		// not numbers
+		{"", 0, false},
+		{"สองสาม", 0, false},
+		{"หกฟุต", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := ParseThaiNumber(tt.in)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParseThaiNumber(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func testEngine() *Engine {
+	cfg := &Config{Items: map[string]Item{
+		"mattress": {Name: "ที่นอน", Aliases: []string{"mattress", "ที่นอน"},
+			Sizes: map[string]Size{"3-3.5ft": {Name: "3-3.5 ฟุต"}, "5-6ft": {Name: "5-6 ฟุต", Aliases: []string{"6ฟุต"}}}},
+		"sofa": {Name: "โซฟา", Aliases: []string{"sofa", "โซฟา"},
+			Sizes: map[string]Size{"1seat": {Name: "1 ที่นั่ง"}, "2seat": {Name: "2 ที่นั่ง"}, "3seat": {Name: "3 ที่นั่ง"}}},
+		"carpet": {Name: "พรม", Aliases: []string{"carpet", "พรม"},
+			Sizes: map[string]Size{"per_sqm": {Name: "ต่อตารางเมตร"}}},
+	}}
+	cfg.Sanitize()
+	return &Engine{Config: cfg}
+}
+
+func TestExtractSize(t *testing.T) {
+	e := testEngine()
+	tests := []struct {
+		in   string
+		want Extraction
+	}{
+		// Thai words
+		{"ที่นอนหกฟุต", Extraction{ItemKey: "mattress", SizeKey: "5-6ft", Number: 6, Unit: "ft"}},
+		{"ที่นอนสามฟุตครึ่ง", Extraction{ItemKey: "mattress", SizeKey: "3-3.5ft", Number: 3.5, Unit: "ft"}},
+		{"ที่นอนหกฟุต สองหลัง", Extraction{ItemKey: "mattress", SizeKey: "5-6ft", Number: 6, Unit: "ft", Quantity: 2}},
+		{"โซฟาสามที่นั่ง", Extraction{ItemKey: "sofa", SizeKey: "3seat", Number: 3, Unit: "seat"}},
+
+		// Thai digits
+		{"โซฟา ๓ ที่นั่ง", Extraction{ItemKey: "sofa", SizeKey: "3seat", Number: 3, Unit: "seat"}},
+		{"ที่นอน ๕ ฟุต ๒ หลัง", Extraction{ItemKey: "mattress", SizeKey: "5-6ft", Number: 5, Unit: "ft", Quantity: 2}},
+
+		// ASCII digits and English units
+		{"ที่นอน 6 ฟุต 2 หลัง", Extraction{ItemKey: "mattress", SizeKey: "5-6ft", Number: 6, Unit: "ft", Quantity: 2}},
+		{"2 seater sofa", Extraction{ItemKey: "sofa", SizeKey: "2seat", Number: 2, Unit: "seat"}},
+		{"พรม 12 ตารางเมตร", Extraction{ItemKey: "carpet", SizeKey: "per_sqm", Number: 12, Unit: "sqm", Quantity: 12}},
+		{"พรม 7.5 ตร.ม.", Extraction{ItemKey: "carpet", SizeKey: "per_sqm", Number: 7.5, Unit: "sqm", Quantity: 8}},
+
+		// compound numbers
+		{"ที่นอนสิบสองฟุต", Extraction{ItemKey: "mattress", Number: 12, Unit: "ft"}},
+		{"พรมสองร้อยตารางเมตร", Extraction{ItemKey: "carpet", SizeKey: "per_sqm", Number: 200, Unit: "sqm", Quantity: 200}},
+		{"พรมยี่สิบเอ็ดตารางเมตร", Extraction{ItemKey: "carpet", SizeKey: "per_sqm", Number: 21, Unit: "sqm", Quantity: 21}},
+		{"โซฟาสองที่นั่ง สิบสองตัว", Extraction{ItemKey: "sofa", SizeKey: "2seat", Number: 2, Unit: "seat", Quantity: 12}},
+
+		// item from the unit, or no size at all
+		{"หกฟุต", Extraction{ItemKey: "mattress", SizeKey: "5-6ft", Number: 6, Unit: "ft"}},
+		{"ซักโซฟาราคาเท่าไร", Extraction{ItemKey: "sofa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := e.ExtractSize(tt.in); got != tt.want {
+				t.Errorf("ExtractSize(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
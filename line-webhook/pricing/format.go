@@ -0,0 +1,74 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+func FormatPrice(price Price, serviceName, itemName, sizeName, customerName string) string {
+	var result strings.Builder
+
+	result.WriteString(fmt.Sprintf("%s %s บริการ%s", itemName, sizeName, serviceName))
+
+	if customerName != "" {
+		result.WriteString(fmt.Sprintf(" สำหรับ%s", customerName))
+	}
+	result.WriteString(": ")
+
+	parts := []string{}
+	if price.FullPrice > 0 {
+		parts = append(parts, fmt.Sprintf("ราคาเต็ม %s บาท", FormatNumber(price.FullPrice)))
+	}
+	if price.Discount35 > 0 {
+		parts = append(parts, fmt.Sprintf("ลด 35%% = %s บาท", FormatNumber(price.Discount35)))
+	}
+	if price.Discount50 > 0 {
+		parts = append(parts, fmt.Sprintf("ลด 50%% = %s บาท", FormatNumber(price.Discount50)))
+	}
+
+	result.WriteString(strings.Join(parts, ", "))
+	return result.String()
+}
+
+func FormatPackagePrice(pkg PackagePrice, serviceName, packageName string, quantity int) string {
+	depositInfo := ""
+	if pkg.DepositMin > 0 {
+		depositInfo = fmt.Sprintf(" มัดจำขั้นต่ำ %s บาท", FormatNumber(pkg.DepositMin))
+	}
+
+	return fmt.Sprintf("%s %d ใบ บริการ%s: ราคาเต็ม %s บาท, ส่วนลด %s บาท, ราคาขาย %s บาท (เฉลี่ย %s บาท/ใบ)%s",
+		packageName, quantity, serviceName,
+		FormatNumber(pkg.FullPrice),
+		FormatNumber(pkg.Discount),
+		FormatNumber(pkg.SalePrice),
+		FormatNumber(pkg.PerItem),
+		depositInfo)
+}
+
+// FormatPromotion is the line added to a quote when a promotion lowers the best price.
+func FormatPromotion(promo Promotion, before, after int) string {
+	return fmt.Sprintf("\n%s: จาก %s บาท เหลือ %s บาท", promo.Describe(), FormatNumber(before), FormatNumber(after))
+}
+
+// FormatNumber renders n with thousands separators (1990 -> "1,990").
+func FormatNumber(n int) string {
+	str := fmt.Sprintf("%d", n)
+	if len(str) <= 3 {
+		return str
+	}
+
+	var result strings.Builder
+	for i, r := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			result.WriteString(",")
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// FallbackResponse is returned when the service or item cannot be resolved.
+func FallbackResponse(serviceType, itemType, size string) string {
+	return fmt.Sprintf("ขออภัย ไม่พบข้อมูลราคาสำหรับ บริการ: '%s' สินค้า: '%s' ขนาด: '%s'\n\nกรุณาติดต่อเจ้าหน้าที่เพื่อสอบถามราคาเพิ่มเติม หรือระบุรายละเอียดให้ชัดเจนมากขึ้น เช่น:\n• ประเภทบริการ (กำจัดเชื้อโรค หรือ ซักขจัดคราบ)\n• ประเภทสินค้า (ที่นอน/โซฟา/ม่าน/พรม)\n• ขนาด (3ฟุต, 6ฟุต, 2ที่นั่ง, ฯลฯ)\n• ประเภทลูกค้า (ลูกค้าใหม่ หรือ สมาชิก)",
+		serviceType, itemType, size)
+}
@@ -0,0 +1,157 @@
+package pricing
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Promotion is a time-bound campaign on item prices. It takes a percentage or a flat amount
+// off the best price of the items it covers (the lowest tier set), between Starts and Ends
+// (inclusive). With a Code it applies only when the customer gives that code; without one it
+// applies to every matching quote. Package prices are promotions already and are not
+// discounted again. When several promotions match, the one leaving the lowest price is used.
+type Promotion struct {
+	Name          string   `json:"name"`
+	Code          string   `json:"code,omitempty"`
+	Percent       float64  `json:"percent,omitempty"`        // e.g. 10 for 10% off
+	Amount        int      `json:"amount,omitempty"`         // baht off each item
+	Services      []string `json:"services,omitempty"`       // service keys; empty for all
+	Items         []string `json:"items,omitempty"`          // item keys; empty for all
+	CustomerTypes []string `json:"customer_types,omitempty"` // customer type keys; empty for all
+	Starts        string   `json:"starts,omitempty"`         // YYYY-MM-DD, "" for already running
+	Ends          string   `json:"ends,omitempty"`           // YYYY-MM-DD, "" for open-ended
+}
+
+// ActiveOn reports whether the promotion runs on day (YYYY-MM-DD).
+func (p Promotion) ActiveOn(day string) bool {
+	return (p.Starts == "" || p.Starts <= day) && (p.Ends == "" || day <= p.Ends)
+}
+
+// covers reports whether the promotion applies to the combination.
+func (p Promotion) covers(serviceKey, itemKey, customerKey string) bool {
+	return (len(p.Services) == 0 || slices.Contains(p.Services, serviceKey)) &&
+		(len(p.Items) == 0 || slices.Contains(p.Items, itemKey)) &&
+		(len(p.CustomerTypes) == 0 || slices.Contains(p.CustomerTypes, customerKey))
+}
+
+// Apply returns amount with the promotion taken off.
+func (p Promotion) Apply(amount int) int {
+	if amount <= 0 {
+		return 0
+	}
+	off := p.Amount
+	if p.Percent > 0 {
+		off = int(math.Round(float64(amount) * p.Percent / 100))
+	}
+	if off > amount {
+		off = amount
+	}
+	return amount - off
+}
+
+// Describe is the Thai line naming the promotion and its discount.
+func (p Promotion) Describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎉 โปรโมชั่น %s", p.Name)
+	if p.Percent > 0 {
+		fmt.Fprintf(&b, " ลดเพิ่ม %s%%", strings.TrimSuffix(fmt.Sprintf("%.1f", p.Percent), ".0"))
+	} else {
+		fmt.Fprintf(&b, " ลดเพิ่ม %s บาท", FormatNumber(p.Amount))
+	}
+	if p.Ends != "" {
+		fmt.Fprintf(&b, " ถึง %s", p.Ends)
+	}
+	return b.String()
+}
+
+func (p Promotion) validate(cfg *Config) error {
+	if p.Name == "" {
+		return fmt.Errorf("needs a name")
+	}
+	if (p.Percent > 0) == (p.Amount > 0) {
+		return fmt.Errorf("needs either percent or amount")
+	}
+	if p.Percent < 0 || p.Percent >= 100 || p.Amount < 0 {
+		return fmt.Errorf("percent must be between 0 and 100 and amount positive")
+	}
+	for _, d := range []string{p.Starts, p.Ends} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			return fmt.Errorf("invalid date '%s' (use YYYY-MM-DD)", d)
+		}
+	}
+	if p.Starts != "" && p.Ends != "" && p.Ends < p.Starts {
+		return fmt.Errorf("ends before it starts")
+	}
+	for _, key := range p.Services {
+		if _, ok := cfg.Services[key]; !ok {
+			return fmt.Errorf("unknown service '%s'", key)
+		}
+	}
+	for _, key := range p.Items {
+		if _, ok := cfg.Items[key]; !ok {
+			return fmt.Errorf("unknown item '%s'", key)
+		}
+	}
+	for _, key := range p.CustomerTypes {
+		if _, ok := cfg.CustomerTypes[key]; !ok {
+			return fmt.Errorf("unknown customer type '%s'", key)
+		}
+	}
+	return nil
+}
+
+// BestPromotion picks the promotion that leaves the lowest amount for the combination, among
+// those running on day that need no code or match code. It reports false when none applies.
+func (cfg *Config) BestPromotion(serviceKey, itemKey, customerKey, code, day string, amount int) (Promotion, bool) {
+	var best Promotion
+	bestPrice, found := 0, false
+	if amount <= 0 {
+		return best, false
+	}
+	keys := make([]string, 0, len(cfg.Promotions))
+	for key := range cfg.Promotions {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys) // ties go to the first key, not to map order
+	for _, key := range keys {
+		p := cfg.Promotions[key]
+		if !p.ActiveOn(day) || !p.covers(serviceKey, itemKey, customerKey) {
+			continue
+		}
+		if p.Code != "" && !strings.EqualFold(p.Code, code) {
+			continue
+		}
+		if discounted := p.Apply(amount); !found || discounted < bestPrice {
+			best, bestPrice, found = p, discounted, true
+		}
+	}
+	return best, found
+}
+
+// PromotionByCode finds a promotion by its code, whether or not it is running.
+func (cfg *Config) PromotionByCode(code string) (Promotion, bool) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return Promotion{}, false
+	}
+	for _, p := range cfg.Promotions {
+		if strings.EqualFold(p.Code, code) {
+			return p, true
+		}
+	}
+	return Promotion{}, false
+}
+
+// BestPrice is what the customer pays at best: the lowest tier that is set.
+func (p Price) BestPrice() int {
+	lowest := 0
+	for _, n := range []int{p.FullPrice, p.Discount35, p.Discount50} {
+		if n > 0 && (lowest == 0 || n < lowest) {
+			lowest = n
+		}
+	}
+	return lowest
+}
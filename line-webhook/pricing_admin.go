@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// pricingWriteLock serialises admin changes to the price list so two edits made at the same
+// time can't overwrite each other.
+var pricingWriteLock sync.Mutex
+
+// errPricingNotSaved marks update failures caused by storage rather than by the change itself.
+var errPricingNotSaved = errors.New("unable to persist pricing config")
+
+// updatePricingConfig applies change to a copy of the live config, validates and saves the
+// copy, then makes it live. The live config is untouched when any step fails.
+func updatePricingConfig(reason string, change func(cfg *pricing.Config) error) (*pricing.Config, error) {
+	pricingWriteLock.Lock()
+	defer pricingWriteLock.Unlock()
+	if pricingConfig == nil {
+		return nil, errors.New("pricing config not loaded")
+	}
+	workingCopy, err := pricingConfig.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPricingNotSaved, err)
+	}
+	if err := change(workingCopy); err != nil {
+		return nil, err
+	}
+	workingCopy.Sanitize()
+	if err := workingCopy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := savePricingConfigToFile(workingCopy); err != nil {
+		log.Printf("Failed to save pricing config: %v", err)
+		return nil, fmt.Errorf("%w: %v", errPricingNotSaved, err)
+	}
+	activatePricingConfig(workingCopy, reason)
+	log.Printf("Pricing config updated: %s", reason)
+	return workingCopy, nil
+}
+
+// respondPricingError maps an updatePricingConfig error to a status code.
+func respondPricingError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errPricingNotSaved):
+		return respondError(c, fiber.StatusInternalServerError, errPricingNotSaved.Error())
+	case pricingConfig == nil:
+		return respondError(c, fiber.StatusServiceUnavailable, err.Error())
+	}
+	return respondError(c, fiber.StatusBadRequest, err.Error())
+}
+
+func handleListPricingItems(c *fiber.Ctx) error {
+	cfg := pricingConfig
+	if cfg == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "pricing config not loaded")
+	}
+	return c.JSON(cfg.Items)
+}
+
+func handleGetPricingItem(c *fiber.Ctx) error {
+	cfg := pricingConfig
+	if cfg == nil {
+		return respondError(c, fiber.StatusServiceUnavailable, "pricing config not loaded")
+	}
+	item, ok := cfg.Items[c.Params("key")]
+	if !ok {
+		return respondError(c, fiber.StatusNotFound, "item not found")
+	}
+	return c.JSON(item)
+}
+
+// handleCreatePricingItem adds a new item, with its sizes and prices, under "key".
+func handleCreatePricingItem(c *fiber.Ctx) error {
+	var req struct {
+		Key string `json:"key"`
+		pricing.Item
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	key := strings.TrimSpace(req.Key)
+	if key == "" {
+		return respondError(c, fiber.StatusBadRequest, "key is required")
+	}
+	_, err := updatePricingConfig("item "+key+" added", func(cfg *pricing.Config) error {
+		if _, exists := cfg.Items[key]; exists {
+			return fmt.Errorf("item '%s' already exists; use PUT to replace it", key)
+		}
+		cfg.Items[key] = req.Item
+		return nil
+	})
+	if err != nil {
+		return respondPricingError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "ok", "key": key, "item": req.Item})
+}
+
+// handleReplacePricingItem replaces an existing item, including all of its sizes and prices.
+func handleReplacePricingItem(c *fiber.Ctx) error {
+	key := c.Params("key")
+	var item pricing.Item
+	if err := c.BodyParser(&item); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	_, err := updatePricingConfig("item "+key+" replaced", func(cfg *pricing.Config) error {
+		if _, exists := cfg.Items[key]; !exists {
+			return fmt.Errorf("unknown item_key '%s'", key)
+		}
+		cfg.Items[key] = item
+		return nil
+	})
+	if err != nil {
+		return respondPricingError(c, err)
+	}
+	return c.JSON(fiber.Map{"status": "ok", "key": key, "item": item})
+}
+
+func handleDeletePricingItem(c *fiber.Ctx) error {
+	key := c.Params("key")
+	_, err := updatePricingConfig("item "+key+" removed", func(cfg *pricing.Config) error {
+		if _, exists := cfg.Items[key]; !exists {
+			return fmt.Errorf("unknown item_key '%s'", key)
+		}
+		delete(cfg.Items, key)
+		return nil
+	})
+	if err != nil {
+		return respondPricingError(c, err)
+	}
+	return c.JSON(fiber.Map{"status": "ok", "key": key})
+}
+
+// handlePutPricingService adds or renames a service. Prices are set per item afterwards.
+func handlePutPricingService(c *fiber.Ctx) error {
+	key := c.Params("key")
+	var service pricing.Service
+	if err := c.BodyParser(&service); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	_, err := updatePricingConfig("service "+key+" saved", func(cfg *pricing.Config) error {
+		cfg.Services[key] = service
+		return nil
+	})
+	if err != nil {
+		return respondPricingError(c, err)
+	}
+	return c.JSON(fiber.Map{"status": "ok", "key": key, "service": service})
+}
+
+// handleDeletePricingService removes a service; it fails while any item is still priced for it.
+func handleDeletePricingService(c *fiber.Ctx) error {
+	key := c.Params("key")
+	_, err := updatePricingConfig("service "+key+" removed", func(cfg *pricing.Config) error {
+		if _, exists := cfg.Services[key]; !exists {
+			return fmt.Errorf("unknown service_key '%s'", key)
+		}
+		delete(cfg.Services, key)
+		return nil
+	})
+	if err != nil {
+		return respondPricingError(c, err)
+	}
+	return c.JSON(fiber.Map{"status": "ok", "key": key})
+}
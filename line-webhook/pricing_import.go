@@ -0,0 +1,433 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// PricingImportRowError describes a spreadsheet row that could not be applied.
+type PricingImportRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// PricingImportReport summarises a bulk price import so operators can review it before saving.
+type PricingImportReport struct {
+	RowsRead    int                     `json:"rows_read"`
+	RowsApplied int                     `json:"rows_applied"`
+	Created     []string                `json:"created,omitempty"` // items/sizes that did not exist before
+	Errors      []PricingImportRowError `json:"errors,omitempty"`
+	Warnings    []PricingImportRowError `json:"warnings,omitempty"`
+	DryRun      bool                    `json:"dry_run"`
+	Saved       bool                    `json:"saved"`
+}
+
+// pricingImportColumns maps accepted header spellings (lower-cased) to canonical column names.
+var pricingImportColumns = map[string]string{
+	"service":       "service",
+	"service_key":   "service",
+	"บริการ":        "service",
+	"item":          "item",
+	"item_key":      "item",
+	"สินค้า":        "item",
+	"item_name":     "item_name",
+	"size":          "size",
+	"size_key":      "size",
+	"ขนาด":          "size",
+	"size_name":     "size_name",
+	"customer":      "customer",
+	"customer_key":  "customer",
+	"customer_type": "customer",
+	"ประเภทลูกค้า":  "customer",
+	"package":       "package",
+	"package_key":   "package",
+	"full_price":    "full_price",
+	"ราคาเต็ม":      "full_price",
+	"discount_35":   "discount_35",
+	"ลด35%":         "discount_35",
+	"discount_50":   "discount_50",
+	"ลด50%":         "discount_50",
+}
+
+// readPricingSheet returns the rows of a CSV or XLSX price list.
+func readPricingSheet(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx":
+		return readXLSXRows(data)
+	default:
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		return r.ReadAll()
+	}
+}
+
+// importPricingRows applies spreadsheet rows onto cfg and returns a validation report.
+// cfg should be a working copy; the caller decides whether to persist it.
+func importPricingRows(cfg *pricing.Config, rows [][]string) PricingImportReport {
+	report := PricingImportReport{}
+	if len(rows) == 0 {
+		report.Errors = append(report.Errors, PricingImportRowError{Row: 0, Message: "spreadsheet is empty"})
+		return report
+	}
+
+	columns := make(map[string]int)
+	for i, h := range rows[0] {
+		if name, ok := pricingImportColumns[strings.ToLower(strings.TrimSpace(h))]; ok {
+			columns[name] = i
+		}
+	}
+	for _, required := range []string{"service", "item", "size", "customer"} {
+		if _, ok := columns[required]; !ok {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: 1, Message: "missing required column: " + required})
+		}
+	}
+	if len(report.Errors) > 0 {
+		return report
+	}
+
+	cell := func(row []string, name string) string {
+		idx, ok := columns[name]
+		if !ok || idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	for i, row := range rows[1:] {
+		rowNum := i + 2 // 1-based, header is row 1
+		if isBlankRow(row) {
+			continue
+		}
+		report.RowsRead++
+
+		fullPrice, err1 := parseImportPrice(cell(row, "full_price"))
+		d35, err2 := parseImportPrice(cell(row, "discount_35"))
+		d50, err3 := parseImportPrice(cell(row, "discount_50"))
+		if err := errors.Join(err1, err2, err3); err != nil {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: rowNum, Message: err.Error()})
+			continue
+		}
+
+		req := UpdatePriceRequest{
+			ServiceKey:  resolveServiceKey(cfg, cell(row, "service")),
+			ItemKey:     resolveItemKey(cfg, cell(row, "item")),
+			CustomerKey: resolveCustomerKey(cfg, cell(row, "customer")),
+			PackageKey:  cell(row, "package"),
+			Price:       pricing.Price{FullPrice: fullPrice, Discount35: d35, Discount50: d50},
+		}
+		if req.ItemKey == "" {
+			req.ItemKey = importKey(cell(row, "item"))
+		}
+		if item, ok := cfg.Items[req.ItemKey]; ok {
+			req.SizeKey = pricingEngine().SizeKey(cell(row, "size"), item.Sizes)
+			if req.SizeKey == "" {
+				if _, exists := item.Sizes[cell(row, "size")]; exists {
+					req.SizeKey = cell(row, "size")
+				}
+			}
+		}
+		if req.SizeKey == "" {
+			req.SizeKey = importKey(cell(row, "size"))
+		}
+		req.normalize()
+		// a name that matches nothing resolves to "", which validate would report as missing
+		if req.ServiceKey == "" && cell(row, "service") != "" {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: rowNum, Message: fmt.Sprintf("unknown service '%s'", cell(row, "service"))})
+			continue
+		}
+		if req.CustomerKey == "" && cell(row, "customer") != "" {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: rowNum, Message: fmt.Sprintf("unknown customer type '%s'", cell(row, "customer"))})
+			continue
+		}
+		if err := req.validate(); err != nil {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: rowNum, Message: err.Error()})
+			continue
+		}
+
+		item, ok := cfg.Items[req.ItemKey]
+		if !ok {
+			name := cell(row, "item_name")
+			if name == "" {
+				name = cell(row, "item")
+			}
+			item = pricing.Item{Name: name, Aliases: []string{cell(row, "item")}, Sizes: make(map[string]pricing.Size)}
+			report.Created = append(report.Created, "item:"+req.ItemKey)
+			report.Warnings = append(report.Warnings, PricingImportRowError{Row: rowNum, Message: fmt.Sprintf("created new item '%s'", req.ItemKey)})
+		}
+		if item.Sizes == nil {
+			item.Sizes = make(map[string]pricing.Size)
+		}
+		if _, ok := item.Sizes[req.SizeKey]; !ok {
+			name := cell(row, "size_name")
+			if name == "" {
+				name = cell(row, "size")
+			}
+			item.Sizes[req.SizeKey] = pricing.Size{Name: name, Aliases: []string{cell(row, "size")}, Pricing: make(map[string]map[string]map[string]pricing.Price)}
+			report.Created = append(report.Created, "size:"+req.ItemKey+"/"+req.SizeKey)
+			report.Warnings = append(report.Warnings, PricingImportRowError{Row: rowNum, Message: fmt.Sprintf("created new size '%s' for item '%s'", req.SizeKey, req.ItemKey)})
+		}
+		cfg.Items[req.ItemKey] = item
+
+		if err := applyPriceUpdate(cfg, req); err != nil {
+			report.Errors = append(report.Errors, PricingImportRowError{Row: rowNum, Message: err.Error()})
+			continue
+		}
+		report.RowsApplied++
+	}
+	return report
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseImportPrice accepts "1,990", "1990 บาท" or an empty cell (0).
+func parseImportPrice(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimSuffix(v, "บาท")
+	v = strings.NewReplacer(",", "", " ", "", "฿", "").Replace(v)
+	if v == "" || v == "-" {
+		return 0, nil
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return int(f + 0.5), nil
+	}
+	return 0, fmt.Errorf("invalid price '%s'", v)
+}
+
+func importKey(v string) string {
+	return strings.ToLower(strings.Join(strings.Fields(v), "_"))
+}
+
+func resolveServiceKey(cfg *pricing.Config, input string) string {
+	if _, ok := cfg.Services[input]; ok {
+		return input
+	}
+	for key, svc := range cfg.Services {
+		if pricingEngine().MatchAlias(input, svc.Aliases) || pricingEngine().MatchAlias(input, []string{svc.Name}) {
+			return key
+		}
+	}
+	return ""
+}
+
+func resolveItemKey(cfg *pricing.Config, input string) string {
+	if _, ok := cfg.Items[input]; ok {
+		return input
+	}
+	for key, item := range cfg.Items {
+		if pricingEngine().MatchAlias(input, item.Aliases) || pricingEngine().MatchAlias(input, []string{item.Name}) {
+			return key
+		}
+	}
+	return ""
+}
+
+func resolveCustomerKey(cfg *pricing.Config, input string) string {
+	if _, ok := cfg.CustomerTypes[input]; ok {
+		return input
+	}
+	for key, ct := range cfg.CustomerTypes {
+		if pricingEngine().MatchAlias(input, ct.Aliases) || pricingEngine().MatchAlias(input, []string{ct.Name}) {
+			return key
+		}
+	}
+	return ""
+}
+
+// readXLSXRows reads the first worksheet of an .xlsx workbook using only the standard library.
+func readXLSXRows(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	readXML := func(name string, dest interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("xlsx is missing %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		raw, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		return xml.Unmarshal(raw, dest)
+	}
+
+	var shared struct {
+		Items []struct {
+			T    string `xml:"t"`
+			Runs []struct {
+				T string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	var sharedStrings []string
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readXML("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, fmt.Errorf("failed to read shared strings: %w", err)
+		}
+		for _, si := range shared.Items {
+			text := si.T
+			for _, r := range si.Runs {
+				text += r.T
+			}
+			sharedStrings = append(sharedStrings, text)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					T string `xml:"t"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := readXML("xl/worksheets/sheet1.xml", &sheet); err != nil {
+		return nil, fmt.Errorf("failed to read worksheet: %w", err)
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		var row []string
+		for i, c := range r.Cells {
+			col := xlsxColumnIndex(c.Ref)
+			if col < 0 {
+				col = i
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err == nil && idx >= 0 && idx < len(sharedStrings) {
+					row[col] = sharedStrings[idx]
+				}
+			case "inlineStr":
+				row[col] = c.Inline.T
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// xlsxColumnIndex converts a cell reference like "C12" to a zero-based column index.
+func xlsxColumnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+// runPricingImport loads a spreadsheet onto a copy of the active config and optionally saves it.
+func runPricingImport(filename string, data []byte, dryRun bool) (PricingImportReport, *pricing.Config, error) {
+	if pricingConfig == nil {
+		return PricingImportReport{}, nil, errors.New("pricing config not loaded")
+	}
+	rows, err := readPricingSheet(filename, data)
+	if err != nil {
+		return PricingImportReport{}, nil, fmt.Errorf("failed to read spreadsheet: %w", err)
+	}
+	pricingWriteLock.Lock()
+	defer pricingWriteLock.Unlock()
+	workingCopy, err := pricingConfig.Clone()
+	if err != nil {
+		return PricingImportReport{}, nil, err
+	}
+	report := importPricingRows(workingCopy, rows)
+	report.DryRun = dryRun
+	if len(report.Errors) == 0 && report.RowsApplied > 0 {
+		if err := workingCopy.Validate(); err != nil {
+			report.Errors = append(report.Errors, PricingImportRowError{Message: err.Error()})
+		}
+	}
+	if dryRun || len(report.Errors) > 0 || report.RowsApplied == 0 {
+		return report, workingCopy, nil
+	}
+	if err := savePricingConfigToFile(workingCopy); err != nil {
+		return report, workingCopy, err
+	}
+	activatePricingConfig(workingCopy, "spreadsheet import")
+	report.Saved = true
+	log.Printf("Imported %d price rows from %s", report.RowsApplied, filename)
+	return report, workingCopy, nil
+}
+
+// handleImportPricing accepts a multipart "file" upload (CSV or XLSX) or a raw CSV body.
+// Pass ?dry_run=true to get the validation report without saving. Rows with errors block the save.
+func handleImportPricing(c *fiber.Ctx) error {
+	filename := "upload.csv"
+	var data []byte
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return respondError(c, fiber.StatusBadRequest, "unable to read uploaded file")
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return respondError(c, fiber.StatusBadRequest, "unable to read uploaded file")
+		}
+		filename = fh.Filename
+	} else {
+		data = c.Body()
+	}
+	if len(data) == 0 {
+		return respondError(c, fiber.StatusBadRequest, "spreadsheet file is required")
+	}
+
+	report, _, err := runPricingImport(filename, data, c.QueryBool("dry_run"))
+	if err != nil {
+		log.Printf("Pricing import failed: %v", err)
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	status := fiber.StatusOK
+	if len(report.Errors) > 0 {
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).JSON(report)
+}
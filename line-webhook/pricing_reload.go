@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+	"os"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// pricingFileSum is the checksum of pricing_config.json as last loaded or saved, so the
+// watcher only reacts to edits made outside the bot. Guarded by pricingWriteLock once the
+// server is running.
+var pricingFileSum [32]byte
+
+// pricingReloadInterval is how often pricing_config.json is checked for changes.
+// PRICING_RELOAD_SECONDS=0 turns the watcher off.
+func pricingReloadInterval() time.Duration {
+	return appConfig.PricingReloadInterval
+}
+
+// reloadPricingConfigIfChanged makes an edited pricing_config.json live. A file that doesn't
+// parse or validate is ignored and the current prices stay live until the file changes again.
+func reloadPricingConfigIfChanged() {
+	data, err := os.ReadFile(pricingConfigFile)
+	if err != nil {
+		log.Printf("Pricing reload: failed to read %s: %v", pricingConfigFile, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+
+	pricingWriteLock.Lock()
+	defer pricingWriteLock.Unlock()
+	if sum == pricingFileSum {
+		return
+	}
+	pricingFileSum = sum // a bad file is reported once, not on every check
+	cfg, err := pricing.Parse(data)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Printf("Pricing reload: %s changed but was rejected, keeping the current prices: %v", pricingConfigFile, err)
+		appMetrics.inc("pricing_reload_failed")
+		return
+	}
+	activatePricingConfig(cfg, "file changed")
+	log.Printf("Pricing reload: %s changed on disk; new prices are live", pricingConfigFile)
+	appMetrics.inc("pricing_reloads")
+}
+
+func startPricingReloadWatcher() {
+	interval := pricingReloadInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reloadPricingConfigIfChanged()
+		}
+	}()
+}
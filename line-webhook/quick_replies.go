@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// Assistant replies get quick reply buttons for the step the reply leaves the customer at,
+// so the next answer can be tapped instead of typed. The step is read from the reply itself:
+// a question about size, a question about dates, a booking summary to confirm, or a quote.
+
+// replyStepWords mark the step of an assistant reply; earlier steps in the list win.
+var replyStepWords = []struct {
+	step  string
+	words []string
+}{
+	{"confirm", []string{"ยืนยันการจอง", "ยืนยันข้อมูล", "ยืนยันนัด"}},
+	{"size", []string{"ขนาดเท่าไหร่", "ขนาดไหน", "ขนาดอะไร", "กี่ฟุต", "กี่ที่นั่ง", "ขอทราบขนาด"}},
+	{"date", []string{"สะดวกวันไหน", "สะดวกวันที่", "วันไหนดี", "เลือกวัน", "วันว่าง", "คิวว่าง"}},
+	{"quoted", []string{"บาท"}},
+}
+
+// replyStep names the step an assistant reply leaves the customer at, or "" for none.
+func replyStep(reply string) string {
+	for _, s := range replyStepWords {
+		for _, w := range s.words {
+			if strings.Contains(reply, w) {
+				return s.step
+			}
+		}
+	}
+	return ""
+}
+
+// assistantQuickReplies picks the quick replies for an assistant reply to the customer's message.
+func assistantQuickReplies(userId, customerText, reply string) []QuickReplyOption {
+	step := replyStep(reply)
+	var options []QuickReplyOption
+	switch step {
+	case "confirm":
+		options = []QuickReplyOption{
+			{Label: "ยืนยันการจอง", Text: "ยืนยันการจอง"},
+			{Label: "ขอแก้ไขข้อมูล", Text: "ขอแก้ไขข้อมูลการจอง"},
+		}
+	case "size":
+		options = sizeQuickReplies(userId, customerText, reply)
+	case "date":
+		options = monthQuickReplies()
+	case "quoted":
+		options = []QuickReplyOption{
+			{Label: "ดูวันว่าง", Text: "ขอดูวันว่าง", Data: "action=slots"},
+			{Label: "จองคิว", Text: "จองคิว"},
+			{Label: "คุยกับเจ้าหน้าที่", Text: "ขอคุยกับเจ้าหน้าที่", Data: "action=contact_staff"},
+		}
+	}
+	if len(options) > 0 {
+		appMetrics.inc("quick_replies_" + step)
+	}
+	return options
+}
+
+// sizeQuickReplies offers the sizes of the item being discussed: the one named in the reply,
+// then in the customer's message, then in the last quote. Items with one size get none.
+func sizeQuickReplies(userId, customerText, reply string) []QuickReplyOption {
+	engine := pricingEngineFor(userId)
+	if engine.Config == nil {
+		return nil
+	}
+	itemKey := engine.ExtractSize(reply).ItemKey
+	if itemKey == "" {
+		itemKey = engine.ExtractSize(normalizeInboundText(customerText)).ItemKey
+	}
+	if itemKey == "" {
+		lastPricingLock.Lock()
+		itemKey = lastPricing[userId].ItemKey
+		lastPricingLock.Unlock()
+	}
+	item, ok := engine.Config.Items[itemKey]
+	if !ok || len(item.Sizes) < 2 {
+		return nil
+	}
+	return itemSizeOptions(item)
+}
+
+// itemSizeOptions is one quick reply per size of an item, at most LINE's 13.
+func itemSizeOptions(item pricing.Item) []QuickReplyOption {
+	keys := make([]string, 0, len(item.Sizes))
+	for key := range item.Sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 13 {
+		keys = keys[:13]
+	}
+	options := make([]QuickReplyOption, 0, len(keys))
+	for _, key := range keys {
+		size := item.Sizes[key].Name
+		options = append(options, QuickReplyOption{Label: truncateRunes(size, 20), Text: item.Name + " " + size})
+	}
+	return options
+}
+
+// monthQuickReplies offers free slots today and in this and the next two months, answered by
+// the slots postback without an assistant turn.
+func monthQuickReplies() []QuickReplyOption {
+	now := bangkokNow()
+	options := []QuickReplyOption{{Label: "จองวันนี้", Text: "ขอจองวันนี้", Data: "action=slots&when=today"}}
+	for i := 0; i < 3; i++ {
+		month := thaiMonthYear(now.AddDate(0, i, 1-now.Day()))
+		options = append(options, QuickReplyOption{
+			Label: month,
+			Text:  "ขอดูวันว่างเดือน" + month,
+			Data:  "action=slots&month=" + url.QueryEscape(month),
+		})
+	}
+	return options
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// quoteCardNote is appended to a get_ncs_pricing result when the quote goes out as a card,
+// so the model summarises instead of repeating every price tier.
+const quoteCardNote = "\n\n[ระบบส่งการ์ดราคานี้ให้ลูกค้าพร้อมข้อความตอบกลับแล้ว ไม่ต้องพิมพ์ราคาทุกระดับซ้ำ สรุปราคาที่แนะนำสั้นๆ แล้วชวนจองคิว]"
+
+// flexMessage wraps a bubble or carousel into a LINE Flex message.
+func flexMessage(altText string, contents map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "flex",
+		"altText":  truncateRunes(altText, 400),
+		"contents": contents,
+	}
+}
+
+// quotePartial is the quote as a customer-ready message, sent ahead of a slow reply: the
+// price tiers, any running promotion and the customer-facing notes.
+func quotePartial(q pricing.ItemQuote, notes string) string {
+	text := pricing.FormatPrice(q.Price, q.Service, q.Item, q.Size, q.Customer)
+	if q.Promotion != nil {
+		text += pricing.FormatPromotion(*q.Promotion, q.Price.BestPrice(), q.PromoPrice)
+	}
+	return text + notes
+}
+
+// quoteFlex renders one item quote as a Flex bubble: item and size, the price tiers, any
+// running promotion, notes and buttons for the next step. notes is shown to the customer as
+// written, so it must only hold customer-facing lines (branch, surcharge, voucher).
+func quoteFlex(q pricing.ItemQuote, notes string) map[string]interface{} {
+	best := q.Price.Discount50
+	if best == 0 {
+		best = q.Price.Discount35
+	}
+	if q.Promotion != nil {
+		best = q.PromoPrice
+		notes = q.Promotion.Describe() + "\n" + notes
+	}
+	priceRow := func(label string, amount int) map[string]interface{} {
+		value := map[string]interface{}{"type": "text", "text": pricing.FormatNumber(amount) + " บาท", "size": "sm", "align": "end", "flex": 3}
+		switch {
+		case amount == best || best == 0:
+			value["weight"] = "bold"
+			value["color"] = "#1DB446"
+			value["size"] = "md"
+		case amount == q.Price.FullPrice:
+			value["decoration"] = "line-through"
+		}
+		return map[string]interface{}{
+			"type": "box", "layout": "horizontal",
+			"contents": []interface{}{
+				map[string]interface{}{"type": "text", "text": label, "size": "sm", "color": "#555555", "flex": 2},
+				value,
+			},
+		}
+	}
+	var rows []interface{}
+	if q.Price.FullPrice > 0 {
+		rows = append(rows, priceRow("ราคาเต็ม", q.Price.FullPrice))
+	}
+	if q.Price.Discount35 > 0 {
+		rows = append(rows, priceRow("ลด 35%", q.Price.Discount35))
+	}
+	if q.Price.Discount50 > 0 {
+		rows = append(rows, priceRow("ลด 50%", q.Price.Discount50))
+	}
+	if q.Promotion != nil {
+		rows = append(rows, priceRow("โปรโมชั่น", q.PromoPrice))
+	}
+
+	body := []interface{}{
+		map[string]interface{}{"type": "text", "text": q.Service, "size": "xs", "color": "#1DB446", "weight": "bold"},
+		map[string]interface{}{"type": "text", "text": q.Item + " " + q.Size, "size": "lg", "weight": "bold", "wrap": true},
+	}
+	if q.Customer != "" {
+		body = append(body, map[string]interface{}{"type": "text", "text": "ราคาสำหรับ" + q.Customer, "size": "xs", "color": "#aaaaaa"})
+	}
+	body = append(body, map[string]interface{}{"type": "separator", "margin": "md"})
+	body = append(body, map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "margin": "md", "contents": rows})
+	if notes = strings.TrimSpace(notes); notes != "" {
+		body = append(body,
+			map[string]interface{}{"type": "separator", "margin": "md"},
+			map[string]interface{}{"type": "text", "text": notes, "size": "xs", "color": "#888888", "wrap": true, "margin": "md"},
+		)
+	}
+
+	button := func(style string, action map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "button", "style": style, "height": "sm", "action": action}
+	}
+	bubble := map[string]interface{}{
+		"type": "bubble",
+		"body": map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "contents": body},
+		"footer": map[string]interface{}{
+			"type": "box", "layout": "vertical", "spacing": "sm",
+			"contents": []interface{}{
+				button("primary", map[string]interface{}{"type": "message", "label": "จองคิว", "text": fmt.Sprintf("ต้องการจองคิว%s %s %s", q.Service, q.Item, q.Size)}),
+				button("secondary", map[string]interface{}{"type": "postback", "label": "ดูวันว่าง", "data": "action=slots", "displayText": "ขอดูวันว่าง"}),
+				button("secondary", map[string]interface{}{"type": "postback", "label": "คุยกับเจ้าหน้าที่", "data": "action=contact_staff", "displayText": "ขอคุยกับเจ้าหน้าที่"}),
+			},
+		},
+	}
+	alt := fmt.Sprintf("ราคา%s %s %s", q.Service, q.Item, q.Size)
+	if best > 0 {
+		alt += ": " + pricing.FormatNumber(best) + " บาท"
+	} else if q.Price.FullPrice > 0 {
+		alt += ": " + pricing.FormatNumber(q.Price.FullPrice) + " บาท"
+	}
+	return flexMessage(alt, bubble)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// A quotation is an agreed quote the customer can forward to whoever decides: the
+// send_quotation tool stores the items, prices and deposit in quotes.json, renders a PNG with
+// the Thai font in QUOTE_FONT_FILE (e.g. Sarabun) and sends it with the reply. The image can
+// be downloaded again from GET /quotes/:id, whose ID is unguessable.
+
+// Quotation is a quote sent to a customer as an image.
+type Quotation struct {
+	ID            string        `json:"id"`
+	UserID        string        `json:"user_id"`
+	CustomerName  string        `json:"customer_name,omitempty"`
+	Items         []BookingItem `json:"items"`
+	Total         int           `json:"total"`
+	DepositAmount int           `json:"deposit_amount"`
+	ValidUntil    string        `json:"valid_until"` // YYYY-MM-DD
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+var quotesFile = "quotes.json"
+
+var (
+	quoteLock  sync.Mutex
+	quotations []*Quotation
+)
+
+// quoteValidDays reads QUOTE_VALID_DAYS (default 14).
+func quoteValidDays() int {
+	return appConfig.QuoteValidDays
+}
+
+var (
+	quoteFontOnce sync.Once
+	quoteFont     *opentype.Font
+	quoteFontErr  error
+)
+
+// loadQuoteFont parses QUOTE_FONT_FILE once. The bitmap font used for photo labels has no
+// Thai glyphs, so quotations can't be drawn without it.
+func loadQuoteFont() (*opentype.Font, error) {
+	quoteFontOnce.Do(func() {
+		path := appConfig.QuoteFontFile
+		if path == "" {
+			quoteFontErr = errors.New("QUOTE_FONT_FILE is not configured")
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			quoteFontErr = fmt.Errorf("failed to read quote font: %w", err)
+			return
+		}
+		quoteFont, quoteFontErr = opentype.Parse(data)
+	})
+	return quoteFont, quoteFontErr
+}
+
+var (
+	quoteBrandColor = color.RGBA{0x1D, 0xB4, 0x46, 0xff} // as on the quote card
+	quoteTextColor  = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	quoteMutedColor = color.RGBA{0x88, 0x88, 0x88, 0xff}
+	quoteRuleColor  = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+)
+
+const quoteImageWidth = 1080
+
+// quoteCanvas draws text lines top to bottom.
+type quoteCanvas struct {
+	img   *image.RGBA
+	font  *opentype.Font
+	faces map[float64]font.Face
+	y     int
+}
+
+func (c *quoteCanvas) face(size float64) font.Face {
+	if f, ok := c.faces[size]; ok {
+		return f
+	}
+	f, err := opentype.NewFace(c.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		log.Printf("Failed to create quote font face: %v", err)
+		return nil
+	}
+	c.faces[size] = f
+	return f
+}
+
+// text draws s with its baseline at the current line; align is "left" or "right" of x.
+func (c *quoteCanvas) text(s string, x int, size float64, col color.Color, align string) {
+	face := c.face(size)
+	if face == nil {
+		return
+	}
+	if align == "right" {
+		x -= font.MeasureString(face, s).Ceil()
+	}
+	d := &font.Drawer{Dst: c.img, Src: image.NewUniform(col), Face: face, Dot: fixed.P(x, c.y)}
+	d.DrawString(s)
+}
+
+func (c *quoteCanvas) rule(height int, col color.Color) {
+	draw.Draw(c.img, image.Rect(60, c.y, quoteImageWidth-60, c.y+height), image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// renderQuotation draws the quotation as a PNG.
+func renderQuotation(q Quotation) ([]byte, error) {
+	f, err := loadQuoteFont()
+	if err != nil {
+		return nil, err
+	}
+	height := 760 + 90*len(q.Items)
+	c := &quoteCanvas{img: image.NewRGBA(image.Rect(0, 0, quoteImageWidth, height)), font: f, faces: make(map[float64]font.Face)}
+	draw.Draw(c.img, c.img.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(c.img, image.Rect(0, 0, quoteImageWidth, 180), image.NewUniform(quoteBrandColor), image.Point{}, draw.Src)
+
+	const left, right = 60, quoteImageWidth - 60
+	c.y = 95
+	c.text("NCS", left, 56, color.White, "left")
+	c.text("ใบเสนอราคา", right, 48, color.White, "right")
+	c.y = 145
+	c.text("บริการทำความสะอาดที่นอน โซฟา ม่าน พรม", left, 26, color.White, "left")
+	c.text("Quotation", right, 26, color.White, "right")
+
+	c.y = 250
+	c.text("เลขที่ "+q.ID, left, 28, quoteTextColor, "left")
+	c.text("วันที่ "+formatThaiDate(q.CreatedAt.In(bangkokNow().Location()).Format("2006-01-02")), right, 28, quoteTextColor, "right")
+	if q.CustomerName != "" {
+		c.y += 45
+		c.text("ลูกค้า "+q.CustomerName, left, 28, quoteTextColor, "left")
+	}
+
+	c.y += 40
+	c.rule(3, quoteBrandColor)
+	c.y += 50
+	c.text("รายการ", left, 28, quoteMutedColor, "left")
+	c.text("จำนวน", 760, 28, quoteMutedColor, "right")
+	c.text("ราคา (บาท)", right, 28, quoteMutedColor, "right")
+	for _, item := range q.Items {
+		c.y += 30
+		c.rule(1, quoteRuleColor)
+		c.y += 45
+		c.text(itemDisplayName(item), left, 32, quoteTextColor, "left")
+		c.text(strconv.Itoa(item.Quantity), 760, 32, quoteTextColor, "right")
+		c.text(pricing.FormatNumber(item.Price), right, 32, quoteTextColor, "right")
+		if pricingConfig != nil {
+			c.y += 35
+			c.text(pricingConfig.Services[item.ServiceKey].Name, left, 24, quoteMutedColor, "left")
+		}
+	}
+	c.y += 30
+	c.rule(3, quoteBrandColor)
+	c.y += 60
+	c.text("ยอดรวม", left, 36, quoteTextColor, "left")
+	c.text(pricing.FormatNumber(q.Total)+" บาท", right, 40, quoteBrandColor, "right")
+	if q.DepositAmount > 0 {
+		c.y += 55
+		c.text("มัดจำเพื่อยืนยันคิว", left, 30, quoteTextColor, "left")
+		c.text(pricing.FormatNumber(q.DepositAmount)+" บาท", right, 30, quoteTextColor, "right")
+	}
+	c.y += 80
+	c.text("ราคานี้ใช้ได้ถึงวันที่ "+formatThaiDate(q.ValidUntil), left, 26, quoteMutedColor, "left")
+	c.y += 40
+	c.text("จองคิวหรือสอบถามเพิ่มเติมได้ทางแชท LINE นี้", left, 26, quoteMutedColor, "left")
+
+	out := c.img.SubImage(image.Rect(0, 0, quoteImageWidth, c.y+60))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, fmt.Errorf("failed to encode quotation: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newQuotationID() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("QT%d", time.Now().UnixNano())
+	}
+	return "QT" + bangkokNow().Format("060102") + "-" + hex.EncodeToString(buf)
+}
+
+// sendQuotation handles the send_quotation tool: the quote is stored, drawn and queued as an
+// image message to go out with the assistant's reply.
+func sendQuotation(userId string, items []ChatBookingItem, depositAmount int) (string, error) {
+	const failed = "สร้างใบเสนอราคาไม่สำเร็จ ให้สรุปราคาเป็นข้อความให้ลูกค้าแทน"
+	toolErr := func(msg string, err error) (string, error) {
+		return msg, &ToolError{Tool: "send_quotation", Err: err}
+	}
+	bookingItems, total, err := chatBookingItems(pricingEngineFor(userId), userId, items)
+	if err != nil {
+		return toolErr("ข้อมูลรายการไม่ถูกต้อง: "+err.Error(), err)
+	}
+	if depositAmount < 0 || depositAmount > total {
+		return toolErr(fmt.Sprintf("มัดจำต้องอยู่ระหว่าง 0 ถึงยอดรวม %s บาท", pricing.FormatNumber(total)), fmt.Errorf("deposit %d outside 0..%d", depositAmount, total))
+	}
+
+	now := time.Now()
+	q := &Quotation{
+		ID:            newQuotationID(),
+		UserID:        userId,
+		Items:         bookingItems,
+		Total:         total,
+		DepositAmount: depositAmount,
+		ValidUntil:    bangkokNow().AddDate(0, 0, quoteValidDays()).Format("2006-01-02"),
+		CreatedAt:     now,
+	}
+	userThreadLock.Lock()
+	if conv, ok := userConversations[userId]; ok {
+		q.CustomerName = conv.Profile.FullName
+		if q.CustomerName == "" {
+			q.CustomerName = conv.DisplayName
+		}
+	}
+	userThreadLock.Unlock()
+
+	data, err := renderQuotation(*q)
+	if err != nil {
+		log.Printf("Failed to render quotation for %s: %v", userId, err)
+		return toolErr(failed, err)
+	}
+	preview, err := makePreviewImage(data)
+	if err != nil {
+		preview = data
+	}
+	originalURL, err := mediaStore.Put(newMediaName("quote", "png"), "image/png", data)
+	if err != nil {
+		log.Printf("Failed to store quotation for %s: %v", userId, err)
+		return failed, &UpstreamError{Service: "media_store", Err: err}
+	}
+	previewURL, err := mediaStore.Put(newMediaName("quote_preview", "jpg"), "image/jpeg", preview)
+	if err != nil {
+		previewURL = originalURL
+	}
+
+	quoteLock.Lock()
+	quotations = append(quotations, q)
+	quoteLock.Unlock()
+	go saveQuotations()
+	queueReplyAttachment(userId, map[string]interface{}{
+		"type":               "image",
+		"originalContentUrl": originalURL,
+		"previewContentUrl":  previewURL,
+	})
+	appMetrics.inc("quotations_sent")
+	log.Printf("Sent quotation %s to %s: %d baht", q.ID, userId, total)
+	return fmt.Sprintf("ส่งใบเสนอราคาเลขที่ %s (ยอดรวม %s บาท ใช้ได้ถึง %s) เป็นรูปให้ลูกค้าพร้อมคำตอบนี้แล้ว ลูกค้าส่งต่อรูปนี้ให้ผู้ตัดสินใจได้ ไม่ต้องพิมพ์รายการซ้ำ",
+		q.ID, pricing.FormatNumber(total), formatThaiDate(q.ValidUntil)), nil
+}
+
+func saveQuotations() {
+	// held through the write so concurrent saves don't share the temp file
+	quoteLock.Lock()
+	defer quoteLock.Unlock()
+	data, err := json.Marshal(quotations)
+	if err != nil {
+		log.Printf("Failed to marshal quotations: %v", err)
+		return
+	}
+	tmpPath := quotesFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("Failed to save quotations: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, quotesFile); err != nil {
+		log.Printf("Failed to replace quotations file: %v", err)
+	}
+}
+
+func loadQuotations() {
+	data, err := os.ReadFile(quotesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read quotations file: %v", err)
+		}
+		return
+	}
+	quoteLock.Lock()
+	defer quoteLock.Unlock()
+	if err := json.Unmarshal(data, &quotations); err != nil {
+		log.Printf("Failed to parse quotations file: %v", err)
+	}
+}
+
+func findQuotation(id string) (Quotation, bool) {
+	quoteLock.Lock()
+	defer quoteLock.Unlock()
+	for _, q := range quotations {
+		if q.ID == id {
+			return *q, true
+		}
+	}
+	return Quotation{}, false
+}
+
+// handleGetQuotation serves the quotation image for download, redrawn from the stored quote.
+func handleGetQuotation(c *fiber.Ctx) error {
+	q, ok := findQuotation(c.Params("id"))
+	if !ok {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	data, err := renderQuotation(q)
+	if err != nil {
+		log.Printf("Failed to render quotation %s: %v", q.ID, err)
+		return respondError(c, fiber.StatusServiceUnavailable, "quotation image unavailable")
+	}
+	c.Set("Content-Type", "image/png")
+	c.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.png"`, q.ID))
+	return c.Send(data)
+}
+
+// handleGetQuotations lists quotations, newest first; ?user_id= narrows to one customer.
+func handleGetQuotations(c *fiber.Ctx) error {
+	userId := c.Query("user_id")
+	quoteLock.Lock()
+	defer quoteLock.Unlock()
+	list := make([]Quotation, 0, len(quotations))
+	for i := len(quotations) - 1; i >= 0; i-- {
+		if userId == "" || quotations[i].UserID == userId {
+			list = append(list, *quotations[i])
+		}
+	}
+	return c.JSON(list)
+}
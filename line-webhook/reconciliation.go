@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReconciliationIssue is one inconsistency found by a nightly check,
+// e.g. a booking without a deposit or a calendar event without a booking.
+type ReconciliationIssue struct {
+	Check   string `json:"check"`
+	Ref     string `json:"ref"` // booking ID, sheet row, payment reference...
+	Message string `json:"message"`
+}
+
+// ReconciliationReport is the outcome of one reconciliation run.
+type ReconciliationReport struct {
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Checks     []string              `json:"checks"`
+	Issues     []ReconciliationIssue `json:"issues"`
+	Errors     map[string]string     `json:"errors,omitempty"` // check name -> error
+}
+
+// reconciliationCheck compares two sources of truth and returns the mismatches.
+type reconciliationCheck struct {
+	Name string
+	Run  func(ctx context.Context) ([]ReconciliationIssue, error)
+}
+
+// reconciliationChecks run nightly in order.
+var reconciliationChecks = []reconciliationCheck{
+	{Name: "booking_deposit", Run: checkBookingDeposits},
+	{Name: "deposit_payment", Run: checkDepositPayments},
+	{Name: "booking_calendar", Run: checkBookingCalendar},
+	{Name: "contract_usage", Run: checkContractUsage},
+	{Name: "slot_months", Run: checkSlotMonths},
+}
+
+var reconciliationReportFile = "reconciliation_report.json"
+
+var (
+	reconciliationLock       sync.Mutex
+	lastReconciliationReport *ReconciliationReport
+)
+
+// runReconciliation executes every check and stores the report.
+func runReconciliation(ctx context.Context) *ReconciliationReport {
+	report := &ReconciliationReport{StartedAt: time.Now(), Issues: []ReconciliationIssue{}, Checks: []string{}}
+	for _, check := range reconciliationChecks {
+		report.Checks = append(report.Checks, check.Name)
+		issues, err := check.Run(ctx)
+		if err != nil {
+			log.Printf("Reconciliation check %s failed: %v", check.Name, err)
+			if report.Errors == nil {
+				report.Errors = make(map[string]string)
+			}
+			report.Errors[check.Name] = err.Error()
+			continue
+		}
+		for i := range issues {
+			issues[i].Check = check.Name
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+	report.FinishedAt = time.Now()
+
+	reconciliationLock.Lock()
+	lastReconciliationReport = report
+	reconciliationLock.Unlock()
+	saveReconciliationReport(report)
+	appMetrics.setGauge("reconciliation_issues", float64(len(report.Issues)))
+	log.Printf("Reconciliation finished: %d checks, %d issues, %d errors", len(report.Checks), len(report.Issues), len(report.Errors))
+	return report
+}
+
+// reconciliationMessage renders the report for the staff LINE push (capped to stay readable).
+func reconciliationMessage(report *ReconciliationReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 รายงานตรวจสอบข้อมูลประจำคืน %s\n", report.StartedAt.In(bangkokNow().Location()).Format("2006-01-02"))
+	fmt.Fprintf(&b, "พบความไม่ตรงกัน %d รายการ", len(report.Issues))
+	for i, issue := range report.Issues {
+		if i == 20 {
+			fmt.Fprintf(&b, "\n… และอีก %d รายการ (ดูทั้งหมดที่ /admin/reconciliation)", len(report.Issues)-20)
+			break
+		}
+		fmt.Fprintf(&b, "\n• [%s] %s: %s", issue.Check, issue.Ref, issue.Message)
+	}
+	for name, msg := range report.Errors {
+		fmt.Fprintf(&b, "\n⚠️ ตรวจ %s ไม่สำเร็จ: %s", name, msg)
+	}
+	return b.String()
+}
+
+// startReconciliationJob runs the checks every night at 02:00 Bangkok time and
+// pushes the report to staff when something needs attention.
+func startReconciliationJob() {
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 2, 0, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			report := runReconciliation(ctx)
+			cancel()
+			if len(report.Issues) == 0 && len(report.Errors) == 0 {
+				continue
+			}
+			msg := reconciliationMessage(report)
+			for _, staffId := range staffAlertRecipients() {
+				if err := pushLineMessageWithPriority(staffId, msg, pushTransactional, "reconciliation"); err != nil {
+					log.Printf("Failed to send reconciliation report to %s: %v", staffId, err)
+				}
+			}
+		}
+	}()
+}
+
+func saveReconciliationReport(report *ReconciliationReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to marshal reconciliation report: %v", err)
+		return
+	}
+	if err := os.WriteFile(reconciliationReportFile, data, 0644); err != nil {
+		log.Printf("Failed to save reconciliation report: %v", err)
+	}
+}
+
+func loadReconciliationReport() {
+	data, err := os.ReadFile(reconciliationReportFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read reconciliation report: %v", err)
+		}
+		return
+	}
+	report := &ReconciliationReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		log.Printf("Failed to parse reconciliation report: %v", err)
+		return
+	}
+	reconciliationLock.Lock()
+	lastReconciliationReport = report
+	reconciliationLock.Unlock()
+}
+
+func handleGetReconciliation(c *fiber.Ctx) error {
+	reconciliationLock.Lock()
+	report := lastReconciliationReport
+	reconciliationLock.Unlock()
+	if report == nil {
+		return respondError(c, fiber.StatusNotFound, "no reconciliation has run yet")
+	}
+	return c.JSON(report)
+}
+
+// handleRunReconciliation runs the checks immediately without pushing to staff.
+func handleRunReconciliation(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	return c.JSON(runReconciliation(ctx))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSheetSlotMarks(t *testing.T) {
+	values := [][]interface{}{
+		{"วันที่", "รอบ 1", "รอบ 2"},
+		{"20/10/2569", "09:00-12:00: hold bk_1", "09:00-12:00"},
+		{"2026-10-21", "13:00-16:00: booked bk_2", "๑๓:๐๐-๑๖:๐๐: booked bk_3", "ปิดรับ"},
+	}
+	want := []sheetSlotMark{
+		{Date: "2026-10-20", TimeSlot: "09:00-12:00", Mark: "hold", Ref: "bk_1"},
+		{Date: "2026-10-21", TimeSlot: "13:00-16:00", Mark: "booked", Ref: "bk_2"},
+		{Date: "2026-10-21", TimeSlot: "13:00-16:00", Mark: "booked", Ref: "bk_3"},
+	}
+	got := sheetSlotMarks(values)
+	if len(got) != len(want) {
+		t.Fatalf("sheetSlotMarks() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sheetSlotMarks()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckDepositPayments(t *testing.T) {
+	date := bangkokNow().AddDate(0, 0, 2).Format("2006-01-02")
+	bookingLock.Lock()
+	savedBookings := bookings
+	bookings = []*Booking{
+		{ID: "bk_ok", Date: date, DepositAmount: 500, DepositStatus: "paid"},
+		{ID: "bk_no_payment", Date: date, DepositAmount: 500, DepositStatus: "paid"},
+		{ID: "bk_not_marked", Date: date, DepositAmount: 500, DepositStatus: "pending"},
+		{ID: "bk_amount", Date: date, DepositAmount: 500, DepositStatus: "paid"},
+		{ID: "bk_old", Date: "2020-01-01", DepositAmount: 500, DepositStatus: "paid"},
+	}
+	bookingLock.Unlock()
+	paymentLock.Lock()
+	savedPayments := payments
+	payments = []*Payment{
+		{ID: "pay_ok", Purpose: "deposit", Reference: "bk_ok", Amount: 500, Status: "paid", PaidAt: time.Now()},
+		{ID: "pay_not_marked", Purpose: "deposit", Reference: "bk_not_marked", Amount: 500, Status: "paid"},
+		{ID: "pay_amount", Purpose: "deposit", Reference: "bk_amount", Amount: 300, Status: "paid"},
+		{ID: "pay_pending", Purpose: "deposit", Reference: "bk_no_payment", Amount: 500, Status: "pending"},
+		{ID: "pay_orphan", Purpose: "deposit", Reference: "bk_gone", Amount: 500, Status: "paid"},
+	}
+	paymentLock.Unlock()
+	defer func() {
+		bookingLock.Lock()
+		bookings = savedBookings
+		bookingLock.Unlock()
+		paymentLock.Lock()
+		payments = savedPayments
+		paymentLock.Unlock()
+	}()
+
+	issues, err := checkDepositPayments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []string
+	for _, issue := range issues {
+		refs = append(refs, issue.Ref)
+	}
+	want := []string{"bk_no_payment", "bk_not_marked", "bk_amount", "pay_orphan"}
+	if len(refs) != len(want) {
+		t.Fatalf("checkDepositPayments() flagged %v, want %v", refs, want)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("checkDepositPayments() flagged %v, want %v", refs, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RetentionPolicy sets how many days each kind of data is kept; 0 keeps it forever.
+type RetentionPolicy struct {
+	TranscriptDays int  `json:"transcript_days"` // conversation messages, hot and archived
+	MediaDays      int  `json:"media_days"`      // generated images and files customers sent
+	PaymentDays    int  `json:"payment_days"`    // paid or cancelled payment records
+	AnalyticsDays  int  `json:"analytics_days"`  // NPS surveys and finished outbound deliveries
+	CacheDays      int  `json:"cache_days"`      // cached answers of idle customers
+	DryRun         bool `json:"dry_run"`         // scheduled runs only report what they would purge
+}
+
+// RetentionResult is what one purge did (or would do, in a dry run) for one data type.
+type RetentionResult struct {
+	Type   string    `json:"type"`
+	Days   int       `json:"days"`
+	Cutoff time.Time `json:"cutoff"`
+	Purged int       `json:"purged"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// RetentionReport is the outcome of one retention run.
+type RetentionReport struct {
+	DryRun  bool              `json:"dry_run"`
+	RanAt   time.Time         `json:"ran_at"`
+	Results []RetentionResult `json:"results"`
+}
+
+var retentionPolicyFile = "retention_policy.json"
+
+var (
+	retentionLock       sync.Mutex
+	retentionPolicy     RetentionPolicy
+	lastRetentionReport *RetentionReport
+)
+
+// retentionPurgers run in order; each returns how many records it removed (or would remove).
+var retentionPurgers = []struct {
+	Type  string
+	days  func(p RetentionPolicy) int
+	purge func(ctx context.Context, cutoff time.Time, dryRun bool) (int, error)
+}{
+	{"transcripts", func(p RetentionPolicy) int { return p.TranscriptDays }, purgeTranscripts},
+	{"archived_transcripts", func(p RetentionPolicy) int { return p.TranscriptDays }, purgeArchivedTranscripts},
+	{"conversation_log", func(p RetentionPolicy) int { return p.TranscriptDays }, purgeConversationLog},
+	{"media", func(p RetentionPolicy) int { return p.MediaDays }, purgeMedia},
+	{"payments", func(p RetentionPolicy) int { return p.PaymentDays }, purgePayments},
+	{"analytics", func(p RetentionPolicy) int { return p.AnalyticsDays }, purgeAnalytics},
+	{"caches", func(p RetentionPolicy) int { return p.CacheDays }, purgeCaches},
+}
+
+func (p RetentionPolicy) validate() error {
+	for name, days := range map[string]int{
+		"transcript_days": p.TranscriptDays, "media_days": p.MediaDays, "payment_days": p.PaymentDays,
+		"analytics_days": p.AnalyticsDays, "cache_days": p.CacheDays,
+	} {
+		if days < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	// surveys inside the NPS window feed the rolling score and stop repeat surveys
+	if p.AnalyticsDays > 0 && p.AnalyticsDays < npsWindowDays() {
+		return fmt.Errorf("analytics_days must be at least NPS_WINDOW_DAYS (%d)", npsWindowDays())
+	}
+	return nil
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.TranscriptDays > 0 || p.MediaDays > 0 || p.PaymentDays > 0 || p.AnalyticsDays > 0 || p.CacheDays > 0
+}
+
+// purgeTranscripts drops messages older than the cutoff from hot conversations. The
+// conversation itself (profile, membership, attribution) is kept. Conversations waiting on
+// staff are skipped so the team keeps its context.
+func purgeTranscripts(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	stamp := cutoff.Format("2006-01-02T15:04:05")
+	purged := 0
+	userThreadLock.Lock()
+	for _, conv := range userConversations {
+		if conv.Takeover || conv.WantsHuman {
+			continue
+		}
+		keep := 0
+		for keep < len(conv.Messages) && conv.Messages[keep].Timestamp < stamp {
+			keep++
+		}
+		purged += keep
+		if !dryRun && keep > 0 {
+			conv.Messages = append([]ConversationMessage(nil), conv.Messages[keep:]...)
+		}
+	}
+	userThreadLock.Unlock()
+	if !dryRun && purged > 0 {
+		saveConversations()
+	}
+	return purged, nil
+}
+
+// purgeArchivedTranscripts empties the messages of archived conversations last seen before
+// the cutoff, rewriting the archive so the customer record survives a later restore.
+func purgeArchivedTranscripts(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	stamp := cutoff.Format("2006-01-02T15:04:05")
+	var due []ArchivedConversation
+	archiveLock.Lock()
+	for _, a := range archivedConversations {
+		if a.MessageCount > 0 && a.LastSeen < stamp {
+			due = append(due, a)
+		}
+	}
+	archiveLock.Unlock()
+	if dryRun {
+		return len(due), nil
+	}
+
+	purged := 0
+	for _, a := range due {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		if err := rewriteArchiveWithoutMessages(ctx, a.Key); err != nil {
+			return purged, fmt.Errorf("failed to purge archive of %s: %w", a.UserID, err)
+		}
+		archiveLock.Lock()
+		if entry, ok := archivedConversations[a.UserID]; ok {
+			entry.MessageCount = 0
+			archivedConversations[a.UserID] = entry
+			saveArchivedConversations()
+		}
+		archiveLock.Unlock()
+		purged++
+	}
+	return purged, nil
+}
+
+func rewriteArchiveWithoutMessages(ctx context.Context, key string) error {
+	data, err := coldStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	var conv UserConversation
+	if err := json.NewDecoder(gz).Decode(&conv); err != nil {
+		return err
+	}
+	conv.Messages = nil
+	plain, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(plain); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return coldStore.Put(ctx, key, buf.Bytes())
+}
+
+// purgeMedia removes generated media and, with the local cold store, files customers sent.
+// Files in a bucket are left to the bucket's own lifecycle rules.
+func purgeMedia(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	dirs := []string{mediaDir}
+	if _, local := coldStore.(*localColdStore); local {
+		dirs = append(dirs, filepath.Join(archiveDir, "files"))
+	}
+	purged := 0
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+			if !dryRun {
+				if err := os.Remove(path); err != nil {
+					return err
+				}
+			}
+			purged++
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purgePayments removes settled payments; pending ones are always kept.
+func purgePayments(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	paymentLock.Lock()
+	kept := make([]*Payment, 0, len(payments))
+	for _, p := range payments {
+		settled := p.PaidAt
+		if settled.IsZero() {
+			settled = p.CreatedAt
+		}
+		if p.Status != "pending" && settled.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	purged := len(payments) - len(kept)
+	if !dryRun {
+		payments = kept
+	}
+	paymentLock.Unlock()
+	if !dryRun && purged > 0 {
+		savePayments()
+	}
+	return purged, nil
+}
+
+// purgeAnalytics removes old NPS surveys and delivered or failed outbound events.
+func purgeAnalytics(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	npsLock.Lock()
+	surveys := make([]*NPSSurvey, 0, len(nps.Surveys))
+	for _, s := range nps.Surveys {
+		if !s.SentAt.Before(cutoff) {
+			surveys = append(surveys, s)
+		}
+	}
+	purged := len(nps.Surveys) - len(surveys)
+	if !dryRun && len(surveys) < len(nps.Surveys) {
+		nps.Surveys = surveys
+		saveNPS()
+	}
+	npsLock.Unlock()
+
+	outboundLock.Lock()
+	deliveries := make([]*OutboundDelivery, 0, len(outboundDeliveries))
+	for _, d := range outboundDeliveries {
+		if d.Status == "pending" || !d.CreatedAt.Before(cutoff) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	dropped := len(outboundDeliveries) - len(deliveries)
+	if !dryRun {
+		outboundDeliveries = deliveries
+	}
+	outboundLock.Unlock()
+	if !dryRun && dropped > 0 {
+		saveOutboundDeliveries()
+	}
+	return purged + dropped, nil
+}
+
+// purgeCaches forgets the cached answers of customers idle since before the cutoff.
+func purgeCaches(ctx context.Context, cutoff time.Time, dryRun bool) (int, error) {
+	stamp := cutoff.Format("2006-01-02T15:04:05")
+	purged := 0
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	for uid := range userAnswerCache {
+		if conv, ok := userConversations[uid]; ok && conv.LastSeen >= stamp {
+			continue
+		}
+		purged++
+		if !dryRun {
+			delete(userAnswerCache, uid)
+		}
+	}
+	return purged, nil
+}
+
+// runRetention applies the policy to every data type with a lifetime set.
+func runRetention(ctx context.Context, policy RetentionPolicy, dryRun bool) RetentionReport {
+	report := RetentionReport{DryRun: dryRun, RanAt: time.Now(), Results: []RetentionResult{}}
+	for _, p := range retentionPurgers {
+		days := p.days(policy)
+		if days == 0 {
+			continue
+		}
+		result := RetentionResult{Type: p.Type, Days: days, Cutoff: bangkokNow().AddDate(0, 0, -days)}
+		n, err := p.purge(ctx, result.Cutoff, dryRun)
+		result.Purged = n
+		if err != nil {
+			result.Error = err.Error()
+			log.Printf("Retention purge of %s failed: %v", p.Type, err)
+		}
+		if n > 0 && !dryRun {
+			appMetrics.add("retention_purged_"+p.Type, int64(n))
+		}
+		report.Results = append(report.Results, result)
+	}
+	log.Printf("Retention run (dry_run=%v): %+v", dryRun, report.Results)
+
+	retentionLock.Lock()
+	lastRetentionReport = &report
+	retentionLock.Unlock()
+	return report
+}
+
+func loadRetentionPolicy() {
+	data, err := os.ReadFile(retentionPolicyFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read retention policy file: %v", err)
+		}
+		return
+	}
+	var policy RetentionPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		log.Printf("Failed to parse retention policy file: %v", err)
+		return
+	}
+	if err := policy.validate(); err != nil {
+		log.Printf("Invalid retention policy file: %v", err)
+		return
+	}
+	retentionLock.Lock()
+	retentionPolicy = policy
+	retentionLock.Unlock()
+}
+
+func currentRetentionPolicy() RetentionPolicy {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	return retentionPolicy
+}
+
+// startRetentionJob applies the retention policy daily at 03:30 Bangkok time, after archival.
+func startRetentionJob() {
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 3, 30, 0, 0, now.Location())
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+			time.Sleep(next.Sub(now))
+			policy := currentRetentionPolicy()
+			if !policy.enabled() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			runRetention(ctx, policy, policy.DryRun)
+			cancel()
+		}
+	}()
+}
+
+func handleGetRetentionPolicy(c *fiber.Ctx) error {
+	return c.JSON(currentRetentionPolicy())
+}
+
+func handleReplaceRetentionPolicy(c *fiber.Ctx) error {
+	var incoming RetentionPolicy
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to encode retention policy")
+	}
+	if err := os.WriteFile(retentionPolicyFile, data, 0644); err != nil {
+		log.Printf("Failed to save retention policy: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save retention policy")
+	}
+	retentionLock.Lock()
+	retentionPolicy = incoming
+	retentionLock.Unlock()
+	log.Printf("Retention policy updated: %+v", incoming)
+	return c.JSON(incoming)
+}
+
+// handleRunRetention runs the retention policy now; ?dry_run=true only reports.
+func handleRunRetention(c *fiber.Ctx) error {
+	policy := currentRetentionPolicy()
+	if !policy.enabled() {
+		return respondError(c, fiber.StatusBadRequest, "no retention lifetimes are configured")
+	}
+	return c.JSON(runRetention(c.Context(), policy, c.QueryBool("dry_run", false)))
+}
+
+func handleGetRetentionReport(c *fiber.Ctx) error {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	if lastRetentionReport == nil {
+		return c.JSON(fiber.Map{})
+	}
+	return c.JSON(lastRetentionReport)
+}
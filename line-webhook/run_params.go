@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/openai"
+)
+
+// RunParams are the Responses API settings for one assistant turn. Zero values
+// inherit from the "default" entry.
+type RunParams struct {
+	Model           string   `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens int      `json:"max_output_tokens,omitempty"`
+	Truncation      string   `json:"truncation,omitempty"` // "auto" or "disabled"
+}
+
+// RunParamsConfig maps workflow steps to run parameters. Step keys are
+// "greeting", "image_analysis" and "step_1".."step_5" (see getCurrentWorkflowStep).
+type RunParamsConfig struct {
+	Default RunParams            `json:"default"`
+	Steps   map[string]RunParams `json:"steps"`
+}
+
+var runParamsFile = "run_params.json"
+
+var (
+	runParamsLock   sync.RWMutex
+	runParamsConfig = &RunParamsConfig{Default: RunParams{Model: "gpt-4.1"}, Steps: map[string]RunParams{}}
+)
+
+func (p RunParams) validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p.MaxOutputTokens < 0 {
+		return fmt.Errorf("max_output_tokens must not be negative")
+	}
+	if p.Truncation != "" && p.Truncation != "auto" && p.Truncation != "disabled" {
+		return fmt.Errorf("truncation must be 'auto' or 'disabled'")
+	}
+	return nil
+}
+
+func (cfg *RunParamsConfig) validate() error {
+	if cfg.Default.Model == "" {
+		return fmt.Errorf("default.model is required")
+	}
+	if err := cfg.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for step, p := range cfg.Steps {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("steps.%s: %w", step, err)
+		}
+	}
+	return nil
+}
+
+// merged overlays the non-zero fields of p onto base.
+func (p RunParams) merged(base RunParams) RunParams {
+	if p.Model != "" {
+		base.Model = p.Model
+	}
+	if p.Temperature != nil {
+		base.Temperature = p.Temperature
+	}
+	if p.MaxOutputTokens > 0 {
+		base.MaxOutputTokens = p.MaxOutputTokens
+	}
+	if p.Truncation != "" {
+		base.Truncation = p.Truncation
+	}
+	return base
+}
+
+// applyTo sets the parameters on a Responses API request.
+func (p RunParams) applyTo(req *openai.ResponseRequest) {
+	req.Model = p.Model
+	req.Temperature = p.Temperature
+	req.MaxOutputTokens = p.MaxOutputTokens
+	req.Truncation = p.Truncation
+}
+
+var greetingWords = []string{"สวัสดี", "หวัดดี", "ดีค่ะ", "ดีครับ", "hello", "hi", "hey"}
+
+// isGreetingOnly reports whether a (normalized) message is just a short hello.
+func isGreetingOnly(msg string) bool {
+	msg = strings.ToLower(strings.TrimSpace(msg))
+	if utf8.RuneCountInString(msg) > 20 {
+		return false
+	}
+	for _, w := range greetingWords {
+		if strings.HasPrefix(msg, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// runStepFor classifies a turn into the step key used for run parameter overrides.
+func runStepFor(message string) string {
+	if strings.Contains(message, "data:image") {
+		return "image_analysis"
+	}
+	normalized := normalizeInboundText(message)
+	if isGreetingOnly(normalized) {
+		return "greeting"
+	}
+	return fmt.Sprintf("step_%d", getCurrentWorkflowStep(normalized, "", ""))
+}
+
+// runParamsFor returns the effective run parameters for a step.
+func runParamsFor(step string) RunParams {
+	runParamsLock.RLock()
+	defer runParamsLock.RUnlock()
+	return runParamsConfig.Steps[step].merged(runParamsConfig.Default)
+}
+
+func loadRunParams() {
+	data, err := os.ReadFile(runParamsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read run params file: %v", err)
+		}
+		return
+	}
+	cfg := &RunParamsConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		log.Printf("Failed to parse run params file: %v", err)
+		return
+	}
+	if err := cfg.validate(); err != nil {
+		log.Printf("Ignoring invalid run params file: %v", err)
+		return
+	}
+	if cfg.Steps == nil {
+		cfg.Steps = map[string]RunParams{}
+	}
+	runParamsLock.Lock()
+	runParamsConfig = cfg
+	runParamsLock.Unlock()
+	log.Printf("Loaded run params: default model %s, %d step overrides", cfg.Default.Model, len(cfg.Steps))
+}
+
+func saveRunParams(cfg *RunParamsConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run params: %w", err)
+	}
+	tmpPath := runParamsFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp run params: %w", err)
+	}
+	if err := os.Rename(tmpPath, runParamsFile); err != nil {
+		return fmt.Errorf("failed to replace run params: %w", err)
+	}
+	return nil
+}
+
+func handleGetRunParams(c *fiber.Ctx) error {
+	runParamsLock.RLock()
+	defer runParamsLock.RUnlock()
+	return c.JSON(runParamsConfig)
+}
+
+func handleReplaceRunParams(c *fiber.Ctx) error {
+	var incoming RunParamsConfig
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if incoming.Steps == nil {
+		incoming.Steps = map[string]RunParams{}
+	}
+	if err := saveRunParams(&incoming); err != nil {
+		log.Printf("Failed to persist run params: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save run params")
+	}
+	runParamsLock.Lock()
+	runParamsConfig = &incoming
+	runParamsLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "run_params": incoming})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The run limiter caps concurrent assistant runs at MAX_CONCURRENT_RUNS (0 = unlimited).
+// Customers over the limit wait in a FIFO queue, get position/ETA updates by push, and can
+// type "ยกเลิก" to leave the queue.
+
+const (
+	queueNoticeDelay   = 5 * time.Second  // short waits go unannounced
+	queueRequeueWindow = 30 * time.Second // newer input keeps the customer's place for this long
+	defaultRunEstimate = 20 * time.Second // ETA basis until runs have been measured
+)
+
+type runWaiter struct {
+	userId     string
+	enqueuedAt time.Time
+	ready      chan struct{} // closed when a slot is handed over
+	cancelled  chan struct{} // closed when the customer leaves the queue
+}
+
+var (
+	runQueueLock   sync.Mutex
+	runsActive     int
+	runWaiters     []*runWaiter
+	runAvgDuration time.Duration
+	// where customers were in the queue when newer input restarted their run
+	runRequeueAt = map[string]time.Time{}
+)
+
+func maxConcurrentRuns() int {
+	return appConfig.MaxConcurrentRuns
+}
+
+func queueUpdateInterval() time.Duration {
+	return appConfig.QueueUpdateInterval
+}
+
+// queuePositionLocked returns the 1-based place of w and the estimated wait. Caller must hold runQueueLock.
+func queuePositionLocked(w *runWaiter) (int, time.Duration) {
+	pos := 0
+	for i, other := range runWaiters {
+		if other == w {
+			pos = i + 1
+			break
+		}
+	}
+	avg := runAvgDuration
+	if avg == 0 {
+		avg = defaultRunEstimate
+	}
+	max := maxConcurrentRuns()
+	if max == 0 {
+		max = 1
+	}
+	rounds := (pos + max - 1) / max
+	return pos, time.Duration(rounds) * avg
+}
+
+func removeWaiterLocked(w *runWaiter) {
+	for i, other := range runWaiters {
+		if other == w {
+			runWaiters = append(runWaiters[:i], runWaiters[i+1:]...)
+			break
+		}
+	}
+	appMetrics.setGauge("run_queue_length", float64(len(runWaiters)))
+}
+
+func queueNoticeText(pos int, eta time.Duration) string {
+	minutes := int(eta.Round(time.Minute) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return fmt.Sprintf("ขณะนี้มีลูกค้าติดต่อเข้ามาจำนวนมาก คุณลูกค้าอยู่คิวที่ %d รอประมาณ %d นาทีค่ะ 🙏\nหากไม่ต้องการรอ พิมพ์ \"ยกเลิก\" เพื่อออกจากคิวรอได้เลยค่ะ", pos, minutes)
+}
+
+// acquireRunSlot waits for a free assistant run slot. It returns a release func when the run
+// may start, or ok=false when ctx was cancelled or the customer left the queue.
+func acquireRunSlot(ctx context.Context, userId string) (release func(), ok bool) {
+	max := maxConcurrentRuns()
+	if max == 0 {
+		return func() {}, true
+	}
+
+	runQueueLock.Lock()
+	if runsActive < max && len(runWaiters) == 0 {
+		runsActive++
+		appMetrics.setGauge("runs_active", float64(runsActive))
+		runQueueLock.Unlock()
+		return newRunRelease(), true
+	}
+	w := &runWaiter{userId: userId, enqueuedAt: time.Now(), ready: make(chan struct{}), cancelled: make(chan struct{})}
+	if at, ok := runRequeueAt[userId]; ok && time.Since(at) < queueRequeueWindow {
+		w.enqueuedAt = at
+	}
+	delete(runRequeueAt, userId)
+	runWaiters = append(runWaiters, w)
+	sort.SliceStable(runWaiters, func(i, j int) bool { return runWaiters[i].enqueuedAt.Before(runWaiters[j].enqueuedAt) })
+	appMetrics.setGauge("run_queue_length", float64(len(runWaiters)))
+	pos, _ := queuePositionLocked(w)
+	runQueueLock.Unlock()
+	log.Printf("Run limit reached; user %s queued at position %d", userId, pos)
+	started := time.Now()
+
+	notice := time.NewTimer(queueNoticeDelay)
+	defer notice.Stop()
+	lastPos := 0
+	for {
+		select {
+		case <-w.ready:
+			wait := time.Since(started)
+			appMetrics.observe("run_queue_wait", wait)
+			appMetrics.inc("run_queue_waits")
+			log.Printf("User %s left the run queue after %s", userId, wait.Round(time.Second))
+			return newRunRelease(), true
+		case <-w.cancelled:
+			appMetrics.inc("run_queue_cancelled")
+			return nil, false
+		case <-ctx.Done():
+			runQueueLock.Lock()
+			select {
+			case <-w.ready:
+				// a slot was handed over just now; pass it on
+				handOffSlotLocked()
+				runQueueLock.Unlock()
+			default:
+				removeWaiterLocked(w)
+				for id, at := range runRequeueAt {
+					if time.Since(at) > queueRequeueWindow {
+						delete(runRequeueAt, id)
+					}
+				}
+				runRequeueAt[userId] = w.enqueuedAt
+				runQueueLock.Unlock()
+			}
+			return nil, false
+		case <-notice.C:
+			runQueueLock.Lock()
+			pos, eta := queuePositionLocked(w)
+			runQueueLock.Unlock()
+			notice.Reset(queueUpdateInterval())
+			if pos == 0 || pos == lastPos {
+				continue // not moved since the last update; don't repeat it
+			}
+			lastPos = pos
+			if err := pushLineMessageWithPriority(userId, queueNoticeText(pos, eta), pushTransactional, "run_queue"); err != nil {
+				log.Printf("Failed to send queue position to %s: %v", userId, err)
+			}
+			appMetrics.inc("run_queue_updates")
+		}
+	}
+}
+
+// newRunRelease returns the func that ends a run: it hands the slot to the next waiter or frees it.
+func newRunRelease() func() {
+	started := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d := time.Since(started)
+			runQueueLock.Lock()
+			defer runQueueLock.Unlock()
+			if runAvgDuration == 0 {
+				runAvgDuration = d
+			} else {
+				runAvgDuration = (runAvgDuration*4 + d) / 5
+			}
+			handOffSlotLocked()
+		})
+	}
+}
+
+// handOffSlotLocked gives a finished run's slot to the first waiter, or frees it.
+// Caller must hold runQueueLock.
+func handOffSlotLocked() {
+	if len(runWaiters) > 0 {
+		next := runWaiters[0]
+		removeWaiterLocked(next)
+		close(next.ready)
+		return
+	}
+	runsActive--
+	appMetrics.setGauge("runs_active", float64(runsActive))
+}
+
+// cancelQueuedRun takes the user out of the run queue and reports whether they were in it.
+func cancelQueuedRun(userId string) bool {
+	runQueueLock.Lock()
+	defer runQueueLock.Unlock()
+	for _, w := range runWaiters {
+		if w.userId == userId {
+			removeWaiterLocked(w)
+			close(w.cancelled)
+			return true
+		}
+	}
+	return false
+}
+
+// queueCancelPattern is a bare "ยกเลิก" with polite particles. "ยกเลิกคิว" or "ยกเลิกการจอง"
+// are about a booking and go to the assistant.
+var queueCancelPattern = regexp.MustCompile(`^ยกเลิก\s*(?:(?:นะ|ค่ะ|คะ|ค่า|ครับ|คับ|ฮะ|จ้า|จ้ะ|จ๊ะ|ด้วย)\s*)*[.!~]*$`)
+
+// answerQueueCancel handles "ยกเลิก" from a queued customer: they leave the waiting line, the
+// pending turn is dropped and the message is not buffered for the assistant.
+func answerQueueCancel(userId, replyToken, messageContent string) bool {
+	text := strings.TrimSpace(normalizeInboundText(messageContent))
+	if !queueCancelPattern.MatchString(text) || !cancelQueuedRun(userId) {
+		return false
+	}
+	const reply = "ออกจากคิวรอเรียบร้อยแล้วค่ะ (คิวจองบริการที่มีอยู่ไม่ได้ถูกยกเลิก) หากต้องการสอบถามเพิ่มเติม พิมพ์ข้อความมาได้ทุกเมื่อเลยนะคะ 😊"
+	deliverReply(userId, replyToken, reply, nil)
+
+	userThreadLock.Lock()
+	buf := userMsgBuffer[userId]
+	if n := len(buf); n > 0 && buf[n-1] == messageContent {
+		userMsgBuffer[userId] = buf[:n-1]
+	}
+	if conv, ok := userConversations[userId]; ok {
+		conv.appendMessage("ai", reply)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	log.Printf("User %s left the run queue", userId)
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// customerSegment is a named audience computed from persisted conversation data.
+type customerSegment struct {
+	ID          string
+	Description string
+	Match       func(conv *UserConversation, now time.Time) bool
+}
+
+// SegmentMember is one user in a segment listing.
+type SegmentMember struct {
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+}
+
+func highSpenderThreshold() int {
+	return appConfig.HighSpenderMin
+}
+
+// customerSegments are evaluated in this order for listings.
+var customerSegments = []customerSegment{
+	{
+		ID:          "members",
+		Description: "NCS Family Members",
+		Match: func(conv *UserConversation, now time.Time) bool {
+			return conv.Profile.MembershipTier != ""
+		},
+	},
+	{
+		ID:          "lapsed_customers",
+		Description: "Customers whose last completed service was more than 6 months ago",
+		Match: func(conv *UserConversation, now time.Time) bool {
+			last, err := time.Parse("2006-01-02", conv.Profile.LastServiceDate)
+			return err == nil && now.Sub(last) > 183*24*time.Hour
+		},
+	},
+	{
+		ID:          "abandoned_quotes",
+		Description: "Users who received a quote over 24 hours ago and did not book afterwards",
+		Match: func(conv *UserConversation, now time.Time) bool {
+			q := conv.Profile.LastQuoteAt
+			return !q.IsZero() && now.Sub(q) > 24*time.Hour && conv.Profile.LastBookingAt.Before(q)
+		},
+	},
+	{
+		ID:          "high_spenders",
+		Description: "Customers whose lifetime spend reaches SEGMENT_HIGH_SPENDER_MIN (default 10,000 baht)",
+		Match: func(conv *UserConversation, now time.Time) bool {
+			return conv.Profile.TotalSpend >= highSpenderThreshold()
+		},
+	},
+	{
+		ID:          "all",
+		Description: "Everyone who has chatted with the bot",
+		Match: func(conv *UserConversation, now time.Time) bool {
+			return true
+		},
+	},
+}
+
+func findSegment(id string) (customerSegment, bool) {
+	for _, s := range customerSegments {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return customerSegment{}, false
+}
+
+// segmentMembers returns the users in a segment, sorted by userId for stable output.
+func segmentMembers(seg customerSegment) []SegmentMember {
+	now := time.Now()
+	userThreadLock.Lock()
+	members := make([]SegmentMember, 0)
+	for _, conv := range userConversations {
+		if !isSimulatedUser(conv.UserID) && seg.Match(conv, now) {
+			members = append(members, SegmentMember{UserID: conv.UserID, DisplayName: conv.DisplayName, Nickname: conv.Nickname})
+		}
+	}
+	userThreadLock.Unlock()
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members
+}
+
+func handleGetSegments(c *fiber.Ctx) error {
+	result := make([]fiber.Map, 0, len(customerSegments))
+	for _, seg := range customerSegments {
+		result = append(result, fiber.Map{
+			"id":          seg.ID,
+			"description": seg.Description,
+			"size":        len(segmentMembers(seg)),
+		})
+	}
+	return c.JSON(result)
+}
+
+func handleGetSegmentMembers(c *fiber.Ctx) error {
+	seg, ok := findSegment(c.Params("id"))
+	if !ok {
+		return respondError(c, fiber.StatusNotFound, "segment not found")
+	}
+	members := segmentMembers(seg)
+	return c.JSON(fiber.Map{"id": seg.ID, "size": len(members), "members": members})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ServiceAreaCollection is a GeoJSON FeatureCollection of serviceable areas. Each feature is a
+// Polygon or MultiPolygon with properties identifying the branch and its travel surcharge. A
+// feature may instead (or also) list provinces, matched against the address of a location;
+// such features can have a null geometry.
+type ServiceAreaCollection struct {
+	Type     string               `json:"type"` // always "FeatureCollection"
+	Features []ServiceAreaFeature `json:"features"`
+}
+
+type ServiceAreaFeature struct {
+	Type       string                `json:"type"` // always "Feature"
+	Properties ServiceAreaProperties `json:"properties"`
+	Geometry   *GeoJSONGeometry      `json:"geometry"`
+}
+
+type ServiceAreaProperties struct {
+	ID        string `json:"id"`
+	Branch    string `json:"branch"`
+	Name      string `json:"name"`
+	Surcharge int    `json:"surcharge"` // travel surcharge in baht, 0 for the core area
+	// Provinces match addresses that name them, e.g. "นนทบุรี" or "Nonthaburi"
+	Provinces []string `json:"provinces,omitempty"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string          `json:"type"` // "Polygon" or "MultiPolygon"
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// geoRing is a closed ring of [lng, lat] positions as in GeoJSON.
+type geoRing [][2]float64
+
+// geoPolygon is an outer ring followed by optional holes.
+type geoPolygon []geoRing
+
+// ServiceAreaMatch is the result of a location lookup.
+type ServiceAreaMatch struct {
+	ID        string `json:"id"`
+	Branch    string `json:"branch"`
+	Name      string `json:"name"`
+	Surcharge int    `json:"surcharge"`
+}
+
+var serviceAreasFile = "service_areas.json"
+
+var (
+	serviceAreaLock sync.RWMutex
+	serviceAreas    = &ServiceAreaCollection{Type: "FeatureCollection"}
+	// parsed polygons per feature index, rebuilt whenever serviceAreas changes
+	serviceAreaPolygons [][]geoPolygon
+)
+
+// polygons decodes the geometry into a list of polygons.
+func (g GeoJSONGeometry) polygons() ([]geoPolygon, error) {
+	switch g.Type {
+	case "Polygon":
+		var p geoPolygon
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		return []geoPolygon{p}, nil
+	case "MultiPolygon":
+		var mp []geoPolygon
+		if err := json.Unmarshal(g.Coordinates, &mp); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		return mp, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type '%s' (use Polygon or MultiPolygon)", g.Type)
+}
+
+// polygons decodes the feature's geometry; nil for a province-only feature.
+func (f ServiceAreaFeature) polygons() ([]geoPolygon, error) {
+	if f.Geometry == nil {
+		return nil, nil
+	}
+	return f.Geometry.polygons()
+}
+
+func (f *ServiceAreaFeature) normalize() {
+	f.Type = "Feature"
+	f.Properties.ID = strings.TrimSpace(f.Properties.ID)
+	f.Properties.Branch = strings.TrimSpace(f.Properties.Branch)
+	f.Properties.Name = strings.TrimSpace(f.Properties.Name)
+	provinces := f.Properties.Provinces[:0]
+	for _, p := range f.Properties.Provinces {
+		if p = strings.TrimSpace(p); p != "" {
+			provinces = append(provinces, p)
+		}
+	}
+	f.Properties.Provinces = provinces
+}
+
+func (f ServiceAreaFeature) validate() error {
+	if f.Properties.ID == "" {
+		return errors.New("properties.id is required")
+	}
+	if f.Properties.Branch == "" {
+		return errors.New("properties.branch is required")
+	}
+	if f.Properties.Surcharge < 0 {
+		return errors.New("properties.surcharge must not be negative")
+	}
+	if f.Geometry == nil {
+		if len(f.Properties.Provinces) == 0 {
+			return errors.New("geometry or properties.provinces is required")
+		}
+		return nil
+	}
+	polys, err := f.polygons()
+	if err != nil {
+		return err
+	}
+	if len(polys) == 0 {
+		return errors.New("geometry has no polygons")
+	}
+	for _, p := range polys {
+		if len(p) == 0 {
+			return errors.New("polygon has no rings")
+		}
+		for _, ring := range p {
+			if len(ring) < 4 {
+				return errors.New("each ring needs at least 4 positions (closed)")
+			}
+			for _, pos := range ring {
+				if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+					return fmt.Errorf("position [%g, %g] is out of range ([lng, lat])", pos[0], pos[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateServiceAreas(c *ServiceAreaCollection) error {
+	seen := make(map[string]bool)
+	for i := range c.Features {
+		c.Features[i].normalize()
+		if err := c.Features[i].validate(); err != nil {
+			return fmt.Errorf("feature %d: %w", i, err)
+		}
+		if seen[c.Features[i].Properties.ID] {
+			return fmt.Errorf("duplicate area id '%s'", c.Features[i].Properties.ID)
+		}
+		seen[c.Features[i].Properties.ID] = true
+	}
+	c.Type = "FeatureCollection"
+	return nil
+}
+
+// setServiceAreas swaps in a validated collection and its parsed polygons.
+func setServiceAreas(c *ServiceAreaCollection) {
+	parsed := make([][]geoPolygon, len(c.Features))
+	for i, f := range c.Features {
+		parsed[i], _ = f.polygons()
+	}
+	serviceAreaLock.Lock()
+	serviceAreas = c
+	serviceAreaPolygons = parsed
+	serviceAreaLock.Unlock()
+}
+
+func loadServiceAreas() {
+	data, err := os.ReadFile(serviceAreasFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read service areas file: %v", err)
+		}
+		return
+	}
+	var c ServiceAreaCollection
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Printf("Failed to parse service areas file: %v", err)
+		return
+	}
+	if err := validateServiceAreas(&c); err != nil {
+		log.Printf("Invalid service areas file: %v", err)
+		return
+	}
+	setServiceAreas(&c)
+	log.Printf("Loaded %d service areas", len(c.Features))
+}
+
+func saveServiceAreasToFile(c *ServiceAreaCollection) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal service areas: %w", err)
+	}
+	tmpPath := serviceAreasFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp service areas: %w", err)
+	}
+	if err := os.Rename(tmpPath, serviceAreasFile); err != nil {
+		return fmt.Errorf("failed to replace service areas: %w", err)
+	}
+	return nil
+}
+
+// pointInRing uses ray casting; ring positions are [lng, lat].
+func pointInRing(lat, lng float64, ring geoRing) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func pointInPolygon(lat, lng float64, p geoPolygon) bool {
+	if len(p) == 0 || !pointInRing(lat, lng, p[0]) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if pointInRing(lat, lng, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// findServiceArea returns the area containing the point. When areas overlap, the one with
+// the lowest surcharge wins so customers are never charged more than necessary.
+func findServiceArea(lat, lng float64) (ServiceAreaMatch, bool) {
+	serviceAreaLock.RLock()
+	defer serviceAreaLock.RUnlock()
+	var best ServiceAreaMatch
+	found := false
+	for i, f := range serviceAreas.Features {
+		for _, p := range serviceAreaPolygons[i] {
+			if !pointInPolygon(lat, lng, p) {
+				continue
+			}
+			if !found || f.Properties.Surcharge < best.Surcharge {
+				best = ServiceAreaMatch{
+					ID:        f.Properties.ID,
+					Branch:    f.Properties.Branch,
+					Name:      f.Properties.Name,
+					Surcharge: f.Properties.Surcharge,
+				}
+				found = true
+			}
+			break
+		}
+	}
+	return best, found
+}
+
+// findServiceAreaByAddress returns the area listing a province named in the address, lowest
+// surcharge first like findServiceArea.
+func findServiceAreaByAddress(address string) (ServiceAreaMatch, bool) {
+	lower := strings.ToLower(normalizeInboundText(address))
+	if lower == "" {
+		return ServiceAreaMatch{}, false
+	}
+	serviceAreaLock.RLock()
+	defer serviceAreaLock.RUnlock()
+	var best ServiceAreaMatch
+	found := false
+	for _, f := range serviceAreas.Features {
+		for _, province := range f.Properties.Provinces {
+			if !strings.Contains(lower, strings.ToLower(province)) {
+				continue
+			}
+			if !found || f.Properties.Surcharge < best.Surcharge {
+				best = ServiceAreaMatch{
+					ID:        f.Properties.ID,
+					Branch:    f.Properties.Branch,
+					Name:      f.Properties.Name,
+					Surcharge: f.Properties.Surcharge,
+				}
+				found = true
+			}
+			break
+		}
+	}
+	return best, found
+}
+
+// locateServiceArea checks the point against the polygons first and falls back to the
+// provinces named in the address.
+func locateServiceArea(lat, lng float64, address string) (ServiceAreaMatch, bool) {
+	if m, ok := findServiceArea(lat, lng); ok {
+		return m, true
+	}
+	return findServiceAreaByAddress(address)
+}
+
+// hasServiceAreas reports whether any service area is configured.
+func hasServiceAreas() bool {
+	serviceAreaLock.RLock()
+	defer serviceAreaLock.RUnlock()
+	return len(serviceAreas.Features) > 0
+}
+
+// travelSurchargeFor returns the service area of the customer's last shared location, and
+// false when they haven't shared one or it is outside the service areas.
+func travelSurchargeFor(userId string) (ServiceAreaMatch, bool) {
+	userThreadLock.Lock()
+	var loc *SharedLocation
+	if conv, ok := userConversations[userId]; ok {
+		loc = conv.Profile.LastLocation
+	}
+	userThreadLock.Unlock()
+	if loc == nil {
+		return ServiceAreaMatch{}, false
+	}
+	return locateServiceArea(loc.Latitude, loc.Longitude, loc.Address)
+}
+
+// --- Admin API ---
+
+func cloneServiceAreas() *ServiceAreaCollection {
+	serviceAreaLock.RLock()
+	defer serviceAreaLock.RUnlock()
+	c := &ServiceAreaCollection{Type: "FeatureCollection", Features: make([]ServiceAreaFeature, len(serviceAreas.Features))}
+	copy(c.Features, serviceAreas.Features)
+	return c
+}
+
+func handleGetServiceAreas(c *fiber.Ctx) error {
+	return c.JSON(cloneServiceAreas())
+}
+
+func handleReplaceServiceAreas(c *fiber.Ctx) error {
+	var incoming ServiceAreaCollection
+	if err := json.Unmarshal(c.Body(), &incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid GeoJSON payload")
+	}
+	if err := validateServiceAreas(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err := saveServiceAreasToFile(&incoming); err != nil {
+		log.Printf("Failed to persist service areas: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save service areas")
+	}
+	setServiceAreas(&incoming)
+	return c.JSON(fiber.Map{"status": "ok", "areas": len(incoming.Features)})
+}
+
+func handleUpsertServiceArea(c *fiber.Ctx) error {
+	var feature ServiceAreaFeature
+	if err := json.Unmarshal(c.Body(), &feature); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid GeoJSON feature")
+	}
+	feature.Properties.ID = c.Params("id")
+	feature.normalize()
+	if err := feature.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	workingCopy := cloneServiceAreas()
+	replaced := false
+	for i := range workingCopy.Features {
+		if workingCopy.Features[i].Properties.ID == feature.Properties.ID {
+			workingCopy.Features[i] = feature
+			replaced = true
+		}
+	}
+	if !replaced {
+		workingCopy.Features = append(workingCopy.Features, feature)
+	}
+	if err := saveServiceAreasToFile(workingCopy); err != nil {
+		log.Printf("Failed to persist service areas: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save service areas")
+	}
+	setServiceAreas(workingCopy)
+	return c.JSON(fiber.Map{"status": "ok", "area": feature})
+}
+
+func handleDeleteServiceArea(c *fiber.Ctx) error {
+	id := c.Params("id")
+	workingCopy := cloneServiceAreas()
+	kept := workingCopy.Features[:0]
+	for _, f := range workingCopy.Features {
+		if f.Properties.ID != id {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) == len(workingCopy.Features) {
+		return respondError(c, fiber.StatusNotFound, "service area not found")
+	}
+	workingCopy.Features = kept
+	if err := saveServiceAreasToFile(workingCopy); err != nil {
+		log.Printf("Failed to persist service areas: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save service areas")
+	}
+	setServiceAreas(workingCopy)
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// handleCheckServiceArea answers GET /admin/service-areas/check?lat=..&lng=..&address=.. for
+// testing polygons and province lists. Either a location or an address is required.
+func handleCheckServiceArea(c *fiber.Ctx) error {
+	lat := c.QueryFloat("lat", 999)
+	lng := c.QueryFloat("lng", 999)
+	address := c.Query("address")
+	if (lat < -90 || lat > 90 || lng < -180 || lng > 180) && address == "" {
+		return respondError(c, fiber.StatusBadRequest, "lat and lng, or address, are required")
+	}
+	match, ok := locateServiceArea(lat, lng, address)
+	if !ok {
+		return c.JSON(fiber.Map{"serviceable": false})
+	}
+	result := fiber.Map{"serviceable": true, "area": match}
+	if b, ok := branchForLocation(lat, lng, address); ok {
+		result["branch"] = b
+	}
+	return c.JSON(result)
+}
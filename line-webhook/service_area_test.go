@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTravelSurchargeLine(t *testing.T) {
+	areas := &ServiceAreaCollection{Features: []ServiceAreaFeature{
+		{Properties: ServiceAreaProperties{ID: "core", Branch: "default", Name: "กรุงเทพฯ"},
+			Geometry: &GeoJSONGeometry{Type: "Polygon", Coordinates: json.RawMessage(`[[[100.4,13.6],[100.7,13.6],[100.7,13.9],[100.4,13.9],[100.4,13.6]]]`)}},
+		{Properties: ServiceAreaProperties{ID: "nonthaburi", Branch: "default", Name: "นนทบุรี", Surcharge: 300, Provinces: []string{"นนทบุรี"}}},
+	}}
+	if err := validateServiceAreas(areas); err != nil {
+		t.Fatal(err)
+	}
+	saved := cloneServiceAreas()
+	setServiceAreas(areas)
+	defer setServiceAreas(saved)
+
+	userId := "U-travel-surcharge-test"
+	userThreadLock.Lock()
+	userConversations[userId] = &UserConversation{UserID: userId}
+	userThreadLock.Unlock()
+	defer func() {
+		userThreadLock.Lock()
+		delete(userConversations, userId)
+		userThreadLock.Unlock()
+	}()
+
+	share := func(address string, lat, lng float64) {
+		userThreadLock.Lock()
+		userConversations[userId].Profile.LastLocation = &SharedLocation{Latitude: lat, Longitude: lng, Address: address}
+		userThreadLock.Unlock()
+	}
+
+	if line := travelSurchargeLine(userId); line != "" {
+		t.Errorf("travelSurchargeLine() without a shared location = %q, want none", line)
+	}
+	share("สยาม กรุงเทพมหานคร", 13.75, 100.53)
+	if line := travelSurchargeLine(userId); line != "" {
+		t.Errorf("travelSurchargeLine() in the core area = %q, want none", line)
+	}
+	share("ปากเกร็ด นนทบุรี", 13.91, 100.49)
+	if line := travelSurchargeLine(userId); !strings.Contains(line, "นนทบุรี") || !strings.Contains(line, "+300 บาท") {
+		t.Errorf("travelSurchargeLine() in Nonthaburi = %q, want its 300 baht surcharge", line)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// ServiceProfile describes one service option for compare_services. Conditions are the
+// tags (and Thai words) the service is the answer to, e.g. "dust_mites" or "คราบ".
+type ServiceProfile struct {
+	Name       string   `json:"name"`
+	Removes    []string `json:"removes"`
+	Duration   string   `json:"duration"`
+	Drying     string   `json:"drying,omitempty"` // empty: not set, the assistant must not guess
+	Conditions []string `json:"conditions,omitempty"`
+}
+
+// compareServiceKeys are the options compared; "both" is disinfection plus washing.
+var compareServiceKeys = []string{"disinfection", "washing", "both"}
+
+var serviceComparisonFile = "service_comparison.json"
+
+var (
+	serviceComparisonLock sync.RWMutex
+	serviceProfiles       = defaultServiceProfiles()
+)
+
+func defaultServiceProfiles() map[string]ServiceProfile {
+	return map[string]ServiceProfile{
+		"disinfection": {
+			Name:       "กำจัดเชื้อโรค-ไรฝุ่น",
+			Removes:    []string{"เชื้อโรค", "ไรฝุ่น", "แบคทีเรีย"},
+			Duration:   "ประมาณ 2-3 ชั่วโมง",
+			Conditions: []string{"dust_mites", "germs", "bacteria", "allergy", "ไรฝุ่น", "เชื้อโรค", "แบคทีเรีย", "ภูมิแพ้", "จาม", "คัน"},
+		},
+		"washing": {
+			Name:       "ซักขจัดคราบ-กลิ่น",
+			Removes:    []string{"คราบสกปรก", "กลิ่น", "ฟื้นฟูเนื้อผ้า"},
+			Duration:   "ประมาณ 4-6 ชั่วโมง",
+			Conditions: []string{"stains", "odor", "urine", "spill", "dirty", "คราบ", "กลิ่น", "ฉี่", "ปัสสาวะ", "หก", "สกปรก", "เหลือง"},
+		},
+		"both": {
+			Name:     "กำจัดเชื้อโรค + ซักขจัดคราบ",
+			Removes:  []string{"เชื้อโรค", "ไรฝุ่น", "แบคทีเรีย", "คราบสกปรก", "กลิ่น"},
+			Duration: "ประมาณ 6-8 ชั่วโมง",
+		},
+	}
+}
+
+func validateServiceProfiles(profiles map[string]ServiceProfile) error {
+	for _, key := range compareServiceKeys {
+		p, ok := profiles[key]
+		if !ok {
+			return fmt.Errorf("missing service %q", key)
+		}
+		if strings.TrimSpace(p.Name) == "" || len(p.Removes) == 0 || strings.TrimSpace(p.Duration) == "" {
+			return fmt.Errorf("service %q needs name, removes and duration", key)
+		}
+	}
+	for key := range profiles {
+		if key != "disinfection" && key != "washing" && key != "both" {
+			return fmt.Errorf("unknown service %q", key)
+		}
+	}
+	return nil
+}
+
+// matchConditions maps the condition tags to the services that address them.
+func matchConditions(profiles map[string]ServiceProfile, tags []string) (map[string][]string, []string) {
+	matched := map[string][]string{}
+	var unknown []string
+	for _, tag := range tags {
+		t := strings.ToLower(strings.TrimSpace(normalizeInboundText(tag)))
+		if t == "" {
+			continue
+		}
+		found := false
+		for _, key := range []string{"disinfection", "washing"} {
+			for _, c := range profiles[key].Conditions {
+				c = strings.ToLower(c)
+				if strings.Contains(t, c) || strings.Contains(c, t) {
+					matched[key] = append(matched[key], tag)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			unknown = append(unknown, tag)
+		}
+	}
+	return matched, unknown
+}
+
+// lowestTier is the lowest price the customer can pay for a price entry.
+func lowestTier(p pricing.Price) int {
+	for _, v := range []int{p.Discount50, p.Discount35, p.FullPrice} {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// comparisonPrice returns the price of a service for the item and size, or the lowest size
+// price ("from") when the size is not known.
+func comparisonPrice(engine *pricing.Engine, serviceKey, itemKey, sizeKey, customerKey string) (int, bool) {
+	item := engine.Config.Items[itemKey]
+	if sizeKey != "" {
+		p, ok := engine.Config.ItemPrice(serviceKey, itemKey, sizeKey, customerKey, "regular")
+		return lowestTier(p), ok && lowestTier(p) > 0
+	}
+	best := 0
+	for key := range item.Sizes {
+		if p, ok := engine.Config.ItemPrice(serviceKey, itemKey, key, customerKey, "regular"); ok {
+			if v := lowestTier(p); v > 0 && (best == 0 || v < best) {
+				best = v
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// compareServices answers the compare_services tool: what each option removes, how long it
+// takes, drying time and price difference for one item, plus a recommendation for the
+// customer's conditions.
+func compareServices(userId, itemType, size string, conditions []string) (string, error) {
+	engine := pricingEngineFor(userId)
+	if engine.Config == nil {
+		return "ระบบราคายังไม่พร้อมใช้งาน", &ToolError{Tool: "compare_services", Err: fmt.Errorf("pricing not loaded")}
+	}
+	itemKey := engine.ItemKey(itemType)
+	sizeKey := ""
+	if itemKey == "" {
+		ex := engine.ExtractSize(strings.TrimSpace(itemType + " " + size))
+		itemKey, sizeKey = ex.ItemKey, ex.SizeKey
+	} else if size != "" {
+		if sizeKey = engine.SizeKey(size, engine.Config.Items[itemKey].Sizes); sizeKey == "" {
+			sizeKey = engine.ExtractSize(engine.Config.Items[itemKey].Name + " " + size).SizeKey
+		}
+	}
+	if itemKey == "" {
+		return fmt.Sprintf("ไม่พบรายการ \"%s\" ในระบบ ถามลูกค้าว่าเป็นที่นอน โซฟา หรือม่าน/พรม", itemType), &ToolError{Tool: "compare_services", Err: fmt.Errorf("unknown item %q", itemType)}
+	}
+	customerKey := "new"
+	if isMember(userId) {
+		customerKey = "member"
+	}
+
+	serviceComparisonLock.RLock()
+	profiles := serviceProfiles
+	serviceComparisonLock.RUnlock()
+
+	item := engine.Config.Items[itemKey]
+	label := item.Name
+	if sizeKey != "" {
+		label += " " + item.Sizes[sizeKey].Name
+	}
+	prices := map[string]int{}
+	for _, key := range []string{"disinfection", "washing"} {
+		if v, ok := comparisonPrice(engine, key, itemKey, sizeKey, customerKey); ok {
+			prices[key] = v
+		}
+	}
+	if prices["disinfection"] > 0 && prices["washing"] > 0 {
+		prices["both"] = prices["disinfection"] + prices["washing"]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "เปรียบเทียบบริการสำหรับ%s (%s)", label, engine.Config.CustomerTypes[customerKey].Name)
+	b.WriteString("\nราคาเป็นราคาหลังส่วนลดสูงสุด ใช้ get_ncs_pricing เมื่อเสนอราคาจริง")
+	if sizeKey == "" {
+		b.WriteString("\nยังไม่ทราบขนาด ราคาด้านล่างเป็นราคาเริ่มต้น")
+	}
+	for _, key := range compareServiceKeys {
+		p := profiles[key]
+		fmt.Fprintf(&b, "\n\n• %s\n  ขจัด: %s\n  ระยะเวลา: %s", p.Name, strings.Join(p.Removes, ", "), p.Duration)
+		if p.Drying != "" {
+			fmt.Fprintf(&b, "\n  เวลารอแห้ง: %s", p.Drying)
+		} else {
+			b.WriteString("\n  เวลารอแห้ง: ไม่มีข้อมูล (ห้ามเดา แจ้งว่าเจ้าหน้าที่จะยืนยัน)")
+		}
+		price, ok := prices[key]
+		switch {
+		case !ok:
+			b.WriteString("\n  ราคา: ไม่มีราคาสำหรับรายการนี้")
+		case key == "both":
+			fmt.Fprintf(&b, "\n  ราคา: %s บาท (รวมสองบริการ ไม่มีราคาแพ็กรวม)", pricing.FormatNumber(price))
+		default:
+			fmt.Fprintf(&b, "\n  ราคา: %s บาท", pricing.FormatNumber(price))
+		}
+		if ok && key != "disinfection" && prices["disinfection"] > 0 {
+			delta, sign := price-prices["disinfection"], "+"
+			if delta < 0 {
+				delta, sign = -delta, "-"
+			}
+			fmt.Fprintf(&b, " (%s%s บาท จาก%s)", sign, pricing.FormatNumber(delta), profiles["disinfection"].Name)
+		}
+	}
+
+	matched, unknown := matchConditions(profiles, conditions)
+	recommended := ""
+	switch {
+	case len(matched["disinfection"]) > 0 && len(matched["washing"]) > 0:
+		recommended = "both"
+	case len(matched["disinfection"]) > 0:
+		recommended = "disinfection"
+	case len(matched["washing"]) > 0:
+		recommended = "washing"
+	}
+	if len(conditions) > 0 {
+		b.WriteString("\n")
+		keys := make([]string, 0, len(matched))
+		for k := range matched {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "\nอาการ %s → %s", strings.Join(matched[k], ", "), profiles[k].Name)
+		}
+		if len(unknown) > 0 {
+			fmt.Fprintf(&b, "\nอาการที่ไม่ตรงกับบริการใด: %s (ถามลูกค้าเพิ่มเติม)", strings.Join(unknown, ", "))
+		}
+	}
+	if recommended != "" {
+		fmt.Fprintf(&b, "\n\n✅ แนะนำ: %s", profiles[recommended].Name)
+	} else {
+		b.WriteString("\n\nยังแนะนำไม่ได้ ถามลูกค้าว่ามีปัญหาไรฝุ่น/ภูมิแพ้ หรือคราบ/กลิ่น")
+	}
+	appMetrics.inc("service_comparisons")
+	return b.String(), nil
+}
+
+func loadServiceComparison() {
+	data, err := os.ReadFile(serviceComparisonFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read service comparison file: %v", err)
+		}
+		return
+	}
+	var profiles map[string]ServiceProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		log.Printf("Failed to parse service comparison file: %v", err)
+		return
+	}
+	if err := validateServiceProfiles(profiles); err != nil {
+		log.Printf("Invalid service comparison file: %v", err)
+		return
+	}
+	serviceComparisonLock.Lock()
+	serviceProfiles = profiles
+	serviceComparisonLock.Unlock()
+}
+
+func handleGetServiceComparison(c *fiber.Ctx) error {
+	serviceComparisonLock.RLock()
+	defer serviceComparisonLock.RUnlock()
+	return c.JSON(serviceProfiles)
+}
+
+func handleReplaceServiceComparison(c *fiber.Ctx) error {
+	var incoming map[string]ServiceProfile
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := validateServiceProfiles(incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to encode service comparison")
+	}
+	if err := os.WriteFile(serviceComparisonFile, data, 0644); err != nil {
+		log.Printf("Failed to save service comparison: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save service comparison")
+	}
+	serviceComparisonLock.Lock()
+	serviceProfiles = incoming
+	serviceComparisonLock.Unlock()
+	log.Printf("Service comparison profiles updated")
+	return c.JSON(incoming)
+}
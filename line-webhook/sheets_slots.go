@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sheets_api provider talks to the scheduling spreadsheet (GOOGLE_SHEETS_ID) without the
+// Apps Script. Each month is a sheet named like "ตุลาคม 2569" whose rows are a date followed
+// by one cell per bookable place: "09:00-12:00", optionally with a status. A slot with team
+// capacity 2 has two cells.
+//
+// With GOOGLE_SERVICE_ACCOUNT_FILE (the sheet shared with the service account as editor),
+// bookings are written into the cells: "09:00-12:00: hold bk_123" while the deposit is
+// pending, "09:00-12:00: booked bk_123" once it is paid or waived, and back to "09:00-12:00"
+// when the booking is cancelled or moved. Without it, GOOGLE_SHEETS_API_KEY reads a sheet
+// shared by link and staff enter bookings by hand.
+
+type sheetsAPISlotProvider struct {
+	SpreadsheetID string
+	APIKey        string
+	Credentials   *googleServiceAccount // preferred over APIKey; required for writes
+	Client        *http.Client
+}
+
+const sheetsAPIBaseURL = "https://sheets.googleapis.com/v4/spreadsheets/"
+
+// sheetsWriteLock keeps one read-modify-write of the sheet at a time, so two customers can't
+// take the same cell. Staff editing the sheet meanwhile is not guarded against.
+var sheetsWriteLock sync.Mutex
+
+func (p *sheetsAPISlotProvider) Name() string { return "sheets_api" }
+
+func (p *sheetsAPISlotProvider) MonthSlots(ctx context.Context, thaiMonthYear string) (AvailabilityResult, error) {
+	body, _, err := p.monthValues(ctx, thaiMonthYear)
+	if err != nil {
+		return AvailabilityResult{}, err
+	}
+	return parseAvailability(p.Name(), body, "sheets", thaiMonthYear)
+}
+
+func (p *sheetsAPISlotProvider) HoldSlot(ctx context.Context, date, timeSlot, ref string, tentative bool) error {
+	mark := "booked"
+	if tentative {
+		mark = "hold"
+	}
+	sheetsWriteLock.Lock()
+	defer sheetsWriteLock.Unlock()
+	month, values, err := p.dateValues(ctx, date)
+	if err != nil {
+		return err
+	}
+	row, col, ok := findSheetSlotCell(values, date, timeSlot, ref)
+	if !ok {
+		row, col, ok = findSheetSlotCell(values, date, timeSlot, "")
+	}
+	if !ok {
+		return errSlotTaken
+	}
+	if err := p.writeCell(ctx, month, row, col, fmt.Sprintf("%s: %s %s", timeSlot, mark, ref)); err != nil {
+		return err
+	}
+	appMetrics.inc("slot_holds_written")
+	log.Printf("Marked %s %s as %s %s in the scheduling sheet", date, timeSlot, mark, ref)
+	return nil
+}
+
+func (p *sheetsAPISlotProvider) ReleaseSlot(ctx context.Context, date, timeSlot, ref string) error {
+	sheetsWriteLock.Lock()
+	defer sheetsWriteLock.Unlock()
+	month, values, err := p.dateValues(ctx, date)
+	if err != nil {
+		return err
+	}
+	row, col, ok := findSheetSlotCell(values, date, timeSlot, ref)
+	if !ok {
+		log.Printf("No place held by %s on %s %s in the scheduling sheet", ref, date, timeSlot)
+		return nil
+	}
+	if err := p.writeCell(ctx, month, row, col, timeSlot); err != nil {
+		return err
+	}
+	appMetrics.inc("slot_holds_released")
+	return nil
+}
+
+// dateValues reads the sheet of the month containing date ("2026-10-15").
+func (p *sheetsAPISlotProvider) dateValues(ctx context.Context, date string) (string, [][]interface{}, error) {
+	day, ok := parseSlotDate(date)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid date %q", date)
+	}
+	month := thaiMonthYear(day)
+	_, values, err := p.monthValues(ctx, month)
+	return month, values, err
+}
+
+// monthValues reads a month's sheet as the raw response and its rows. A missing or empty
+// sheet wraps errEmptySlotData.
+func (p *sheetsAPISlotProvider) monthValues(ctx context.Context, thaiMonthYear string) (string, [][]interface{}, error) {
+	body, err := p.call(ctx, "GET", "/values/"+url.PathEscape(sheetRange(thaiMonthYear, ""))+"?valueRenderOption=FORMATTED_VALUE", nil)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) && upstream.StatusCode == http.StatusBadRequest && strings.Contains(upstream.Err.Error(), "Unable to parse range") {
+		return "", nil, &UpstreamError{Service: "scheduling", StatusCode: upstream.StatusCode, Err: errEmptySlotData}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var resp struct {
+		Values [][]interface{} `json:"values"`
+	}
+	if json.Unmarshal([]byte(body), &resp) != nil || len(resp.Values) == 0 {
+		return "", nil, &UpstreamError{Service: "scheduling", Err: errEmptySlotData}
+	}
+	return body, resp.Values, nil
+}
+
+// writeCell sets one cell; row and col are 0-based indexes into the month's values.
+func (p *sheetsAPISlotProvider) writeCell(ctx context.Context, thaiMonthYear string, row, col int, text string) error {
+	cell := sheetRange(thaiMonthYear, fmt.Sprintf("%s%d", sheetColumn(col), row+1))
+	_, err := p.call(ctx, "PUT", "/values/"+url.PathEscape(cell)+"?valueInputOption=RAW",
+		map[string]interface{}{"range": cell, "values": [][]string{{text}}})
+	return err
+}
+
+// call sends a Sheets API request for the spreadsheet, authenticated with the service
+// account or, for reads, the API key.
+func (p *sheetsAPISlotProvider) call(ctx context.Context, method, path string, payload interface{}) (string, error) {
+	reqURL := sheetsAPIBaseURL + url.PathEscape(p.SpreadsheetID) + path
+	var token string
+	if p.Credentials != nil {
+		var err error
+		if token, err = p.Credentials.AccessToken(ctx); err != nil {
+			return "", err
+		}
+	} else if method == "GET" {
+		reqURL += "&key=" + url.QueryEscape(p.APIKey)
+	} else {
+		return "", errors.New("writing to the scheduling sheet needs GOOGLE_SERVICE_ACCOUNT_FILE")
+	}
+	var body io.Reader
+	if payload != nil {
+		data, _ := json.Marshal(payload)
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return "", err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doSlotRequest(p.Client, req)
+}
+
+// findSheetSlotCell finds the cell of timeSlot on date marked with ref, or with ref "" the
+// first free one.
+func findSheetSlotCell(values [][]interface{}, date, timeSlot, ref string) (int, int, bool) {
+	for r, row := range values {
+		if len(row) == 0 {
+			continue
+		}
+		first, _ := row[0].(string)
+		if d, ok := parseSlotDate(first); !ok || d.Format("2006-01-02") != date {
+			continue
+		}
+		for c := 1; c < len(row); c++ {
+			cell, _ := row[c].(string)
+			found := slotTimePattern.FindString(convertThaiDigits(cell))
+			if found == "" || uniqueSortedSlots([]string{found})[0] != timeSlot {
+				continue
+			}
+			if ref != "" && strings.Contains(cell, ref) {
+				return r, c, true
+			}
+			if ref == "" && !slotIsFull(cell) {
+				return r, c, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// sheetSlotMark is a place in the scheduling sheet held for a booking.
+type sheetSlotMark struct {
+	Date     string // YYYY-MM-DD
+	TimeSlot string
+	Mark     string // "hold" or "booked"
+	Ref      string // booking ID
+}
+
+var sheetSlotMarkPattern = regexp.MustCompile(`\b(hold|booked) (\S+)`)
+
+// sheetSlotMarks lists the places marked for bookings in a month's rows.
+func sheetSlotMarks(values [][]interface{}) []sheetSlotMark {
+	var marks []sheetSlotMark
+	for _, row := range values {
+		if len(row) == 0 {
+			continue
+		}
+		first, _ := row[0].(string)
+		d, ok := parseSlotDate(first)
+		if !ok {
+			continue
+		}
+		for c := 1; c < len(row); c++ {
+			cell, _ := row[c].(string)
+			found := slotTimePattern.FindString(convertThaiDigits(cell))
+			m := sheetSlotMarkPattern.FindStringSubmatch(cell)
+			if found == "" || m == nil {
+				continue
+			}
+			marks = append(marks, sheetSlotMark{Date: d.Format("2006-01-02"), TimeSlot: uniqueSortedSlots([]string{found})[0], Mark: m[1], Ref: m[2]})
+		}
+	}
+	return marks
+}
+
+// checkBookingCalendar compares upcoming bookings with the places marked in the scheduling
+// sheet, both ways. Only the sheets_api calendar records which booking holds a place, so
+// Apps Script calendars and branches with their own slots_url are not checked.
+func checkBookingCalendar(ctx context.Context) ([]ReconciliationIssue, error) {
+	p, ok := branchSlotProvider(Branch{}).(*sheetsAPISlotProvider)
+	if !ok {
+		return nil, nil
+	}
+	now := bangkokNow()
+	today := now.Format("2006-01-02")
+	read := map[string]bool{}
+	marks := map[string][]sheetSlotMark{}
+	var order []sheetSlotMark
+	for i := 0; i < slotSeedMonthsAhead(); i++ {
+		month := thaiMonthYear(time.Date(now.Year(), now.Month()+time.Month(i), 1, 0, 0, 0, 0, now.Location()))
+		_, values, err := p.monthValues(ctx, month)
+		if errors.Is(err, errEmptySlotData) {
+			continue // reported by slot_months
+		}
+		if err != nil {
+			return nil, err
+		}
+		read[month] = true
+		for _, m := range sheetSlotMarks(values) {
+			if m.Date >= today {
+				marks[m.Ref] = append(marks[m.Ref], m)
+				order = append(order, m)
+			}
+		}
+	}
+
+	bookingLock.Lock()
+	byID := make(map[string]Booking, len(bookings))
+	var upcoming []Booking
+	for _, b := range bookings {
+		byID[b.ID] = *b
+		if b.Status == "confirmed" && b.Date >= today && read[bookingMonth(b.Date)] {
+			upcoming = append(upcoming, *b)
+		}
+	}
+	bookingLock.Unlock()
+
+	var issues []ReconciliationIssue
+	for _, b := range upcoming {
+		if branch, _ := customerBranch(b.UserID); branch.SlotsURL != "" {
+			continue
+		}
+		if !slices.ContainsFunc(marks[b.ID], func(m sheetSlotMark) bool { return m.Date == b.Date && m.TimeSlot == b.TimeSlot }) {
+			issues = append(issues, ReconciliationIssue{Ref: b.ID, Message: fmt.Sprintf("คิววันที่ %s %s ไม่มีในตารางคิว", b.Date, b.TimeSlot)})
+		}
+	}
+	for _, m := range order {
+		b, ok := byID[m.Ref]
+		var msg string
+		switch {
+		case !ok:
+			msg = "ไม่พบการจองนี้ในระบบ"
+		case b.Status == "cancelled":
+			msg = "การจองนี้ถูกยกเลิกแล้ว"
+		case b.Date != m.Date || b.TimeSlot != m.TimeSlot:
+			msg = fmt.Sprintf("การจองนี้อยู่วันที่ %s %s", b.Date, b.TimeSlot)
+		case m.Mark == "hold" && b.DepositStatus != "pending":
+			msg = "ยังเป็น hold ทั้งที่มัดจำ " + b.DepositStatus + " แล้ว"
+		default:
+			continue
+		}
+		issues = append(issues, ReconciliationIssue{Ref: m.Ref, Message: fmt.Sprintf("ตารางคิววันที่ %s %s: %s", m.Date, m.TimeSlot, msg)})
+	}
+	return issues, nil
+}
+
+// sheetRange is an A1 range on a month's sheet; cell "" is the whole sheet.
+func sheetRange(thaiMonthYear, cell string) string {
+	r := "'" + strings.ReplaceAll(thaiMonthYear, "'", "''") + "'"
+	if cell != "" {
+		r += "!" + cell
+	}
+	return r
+}
+
+// sheetColumn names a 0-based column: A, B, ... Z, AA, AB ...
+func sheetColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Simulated users live in their own namespace so QA runs never reach LINE or the shop's
+// records: replies, pushes and staff alerts for them are captured instead of sent, tools that
+// would write bookings, payments, vouchers, memberships, contracts or media answer without
+// running, and outbound webhook events about them are dropped.
+const simulatedUserPrefix = "sim:"
+
+// simulatedNoOpTools answer simulated users with simulatedToolResult instead of running.
+// create_booking is not listed: createBooking validates the booking and checks the slot for
+// them, stopping before anything is reserved or stored.
+var simulatedNoOpTools = map[string]bool{
+	"cancel_booking":         true,
+	"redeem_coupon":          true,
+	"purchase_membership":    true,
+	"purchase_gift_voucher":  true,
+	"redeem_gift_voucher":    true,
+	"book_with_contract":     true,
+	"update_booking_details": true,
+	"reschedule_booking":     true,
+	"send_quotation":         true,
+	"export_my_chat_history": true,
+}
+
+// simulatedToolResult is what the model sees when a simulated user's tool call is skipped.
+func simulatedToolResult(name string) string {
+	return fmt.Sprintf("[simulation] %s ทำรายการสำเร็จ (บทสนทนาจำลอง ไม่มีการบันทึกข้อมูลจริง) ตอบลูกค้าตามปกติเหมือนทำรายการสำเร็จ", name)
+}
+
+// CapturedLineMessage is a LINE API call that would have been made for a simulated user.
+type CapturedLineMessage struct {
+	Kind     string                   `json:"kind"` // "reply", "push" or "staff_alert"
+	Messages []map[string]interface{} `json:"messages"`
+	At       time.Time                `json:"at"`
+}
+
+var (
+	lineCaptureLock sync.Mutex
+	lineCaptures    = make(map[string][]CapturedLineMessage) // simulated user ID -> captured calls
+)
+
+func isSimulatedUser(userId string) bool {
+	return strings.HasPrefix(userId, simulatedUserPrefix)
+}
+
+// simulatedReplyToken encodes the user in the reply token so replyToLine can capture it.
+func simulatedReplyToken(userId string) string {
+	return userId
+}
+
+// captureLineMessage records an outbound call for a simulated user and reports whether it did.
+func captureLineMessage(userId, kind string, messages []map[string]interface{}) bool {
+	if !isSimulatedUser(userId) {
+		return false
+	}
+	lineCaptureLock.Lock()
+	lineCaptures[userId] = append(lineCaptures[userId], CapturedLineMessage{Kind: kind, Messages: messages, At: time.Now()})
+	lineCaptureLock.Unlock()
+	return true
+}
+
+func takeLineCaptures(userId string) []CapturedLineMessage {
+	lineCaptureLock.Lock()
+	defer lineCaptureLock.Unlock()
+	captured := lineCaptures[userId]
+	delete(lineCaptures, userId)
+	if captured == nil {
+		captured = []CapturedLineMessage{}
+	}
+	return captured
+}
+
+type SimulateRequest struct {
+	UserID   string   `json:"userId"`
+	Messages []string `json:"messages"`
+	Keep     bool     `json:"keep"` // keep the simulated conversation for inspection in the admin UI
+}
+
+// SimulatedTurn is one input message and everything the bot sent because of it.
+type SimulatedTurn struct {
+	Input    string                `json:"input"`
+	Outbound []CapturedLineMessage `json:"outbound"`
+}
+
+// handleSimulate runs messages through the real webhook pipeline (buffering, takeover,
+// urgency, tools, assistant) for a namespaced user and returns the captured LINE traffic.
+// Each message is its own turn; the 15-second buffer wait is skipped. Staff alerts are sent
+// from goroutines, so they show up in whichever turn is running when they finish.
+func handleSimulate(c *fiber.Ctx) error {
+	var req SimulateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if len(req.Messages) == 0 {
+		return respondError(c, fiber.StatusBadRequest, "messages must not be empty")
+	}
+	if req.UserID == "" {
+		req.UserID = fmt.Sprintf("qa-%d", time.Now().UnixNano())
+	}
+	userId := req.UserID
+	if !isSimulatedUser(userId) {
+		userId = simulatedUserPrefix + userId
+	}
+	log.Printf("Simulating %d message(s) for %s", len(req.Messages), userId)
+
+	turns := make([]SimulatedTurn, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		replyToken := simulatedReplyToken(userId)
+		isNewUser := recordInboundMessage(userId, msg)
+		if answerKeywordTrigger(userId, replyToken, msg) {
+			turns = append(turns, SimulatedTurn{Input: msg, Outbound: takeLineCaptures(userId)})
+			continue
+		}
+		if !isNewUser && answerPricingClarification(userId, replyToken, msg) {
+			turns = append(turns, SimulatedTurn{Input: msg, Outbound: takeLineCaptures(userId)})
+			continue
+		}
+		if isNewUser && sendFirstTimeGreeting(userId, replyToken) {
+			replyToken = ""
+		}
+		flushUserBuffer(userId, replyToken)
+		turns = append(turns, SimulatedTurn{Input: msg, Outbound: takeLineCaptures(userId)})
+	}
+
+	userThreadLock.Lock()
+	var conv UserConversation
+	if existing, ok := userConversations[userId]; ok {
+		conv = *existing
+	}
+	if !req.Keep {
+		delete(userConversations, userId)
+		delete(userAnswerCache, userId)
+		delete(userMsgBuffer, userId)
+	}
+	userThreadLock.Unlock()
+	if !req.Keep {
+		go saveConversations()
+	}
+
+	return c.JSON(fiber.Map{
+		"user_id":      userId,
+		"turns":        turns,
+		"conversation": conv,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// TestSimulatedBookingLeavesRecordsAlone books through create_booking as a simulated user
+// against a fake Apps Script calendar: the slot is read, but nothing is reserved or stored.
+func TestSimulatedBookingLeavesRecordsAlone(t *testing.T) {
+	if err := loadPricingConfig(); err != nil {
+		t.Fatal(err)
+	}
+	date := bangkokNow().AddDate(0, 0, 1).Format("2006-01-02")
+	var reads, writes atomic.Int32
+	calendar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writes.Add(1)
+			fmt.Fprint(w, `{"ok": true}`)
+			return
+		}
+		reads.Add(1)
+		fmt.Fprintf(w, `[{"date": %q, "slots": ["09:00-12:00", "13:00-16:00"]}]`, date)
+	}))
+	defer calendar.Close()
+
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	cfg := *appConfig
+	appConfig = &cfg
+	appConfig.SlotsURL = calendar.URL
+	appConfig.SlotsFormat = "apps_script"
+	appConfig.SlotsProvider = "apps_script"
+
+	bookingLock.Lock()
+	bookingsBefore := len(bookings)
+	bookingLock.Unlock()
+	paymentLock.Lock()
+	paymentsBefore := len(payments)
+	paymentLock.Unlock()
+
+	quote, ok := pricingEngine().QuoteItem(pricing.QuoteRequest{ServiceType: "washing", ItemType: "curtain", Size: "sqm"})
+	if !ok {
+		t.Fatal("no quote for washing a curtain per sqm")
+	}
+	items := []ChatBookingItem{{ServiceType: "washing", ItemType: "curtain", Size: "sqm", Quantity: 1, Price: quote.Price.BestPrice()}}
+	result, err := createBooking(simulatedUserPrefix+"qa-booking", date, "09:00-12:00", "1 ถนนทดสอบ", items, 100)
+	if err != nil {
+		t.Fatalf("createBooking() error: %v (%s)", err, result)
+	}
+	if !strings.HasPrefix(result, "[simulation]") {
+		t.Errorf("createBooking() = %q, want a simulated result", result)
+	}
+	if reads.Load() == 0 {
+		t.Error("the calendar was not checked for the slot")
+	}
+	if n := writes.Load(); n != 0 {
+		t.Errorf("the calendar got %d write(s), want none", n)
+	}
+
+	bookingLock.Lock()
+	bookingsAfter := len(bookings)
+	bookingLock.Unlock()
+	paymentLock.Lock()
+	paymentsAfter := len(payments)
+	paymentLock.Unlock()
+	if bookingsAfter != bookingsBefore {
+		t.Errorf("bookings went from %d to %d, want unchanged", bookingsBefore, bookingsAfter)
+	}
+	if paymentsAfter != paymentsBefore {
+		t.Errorf("payments went from %d to %d, want unchanged", paymentsBefore, paymentsAfter)
+	}
+}
+
+func TestSimulatedUserSkipsMutatingTools(t *testing.T) {
+	for _, name := range []string{"cancel_booking", "reschedule_booking", "book_with_contract", "purchase_membership", "redeem_gift_voucher"} {
+		if !simulatedNoOpTools[name] {
+			t.Errorf("%s is not skipped for simulated users", name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Free slots are cached per calendar and month for SLOTS_CACHE_SECONDS (default 60; 0
+// disables), so customers asking about the same month one after another don't each wait for
+// the calendar. A month is dropped from the cache whenever a booking is written to or freed
+// from it. Checks right before booking (slotStillFree) and month seeding always read the
+// calendar.
+
+type cachedSlotMonth struct {
+	result  AvailabilityResult
+	at      time.Time
+	dropped bool // invalidated at `at`; reads started before then are not stored
+}
+
+var (
+	slotCacheLock sync.Mutex
+	slotCache     = make(map[string]cachedSlotMonth) // calendar + "|" + month -> slots
+)
+
+// cachedSlotProvider serves a calendar's months from slotCache.
+type cachedSlotProvider struct {
+	SlotProvider
+	calendar string
+}
+
+// slotCalendarKey identifies a branch calendar; branches without slots_url share the default.
+func slotCalendarKey(b Branch) string {
+	if b.SlotsURL != "" {
+		return b.SlotsURL
+	}
+	return "default"
+}
+
+func (p *cachedSlotProvider) MonthSlots(ctx context.Context, thaiMonthYear string) (AvailabilityResult, error) {
+	ttl := appConfig.SlotsCacheTTL
+	if ttl <= 0 {
+		return p.SlotProvider.MonthSlots(ctx, thaiMonthYear)
+	}
+	key := p.calendar + "|" + thaiMonthYear
+	slotCacheLock.Lock()
+	cached, ok := slotCache[key]
+	slotCacheLock.Unlock()
+	if ok && !cached.dropped && time.Since(cached.at) < ttl {
+		appMetrics.inc("slot_cache_hits")
+		return cached.result, nil
+	}
+	appMetrics.inc("slot_cache_misses")
+	fetched := time.Now()
+	result, err := p.SlotProvider.MonthSlots(ctx, thaiMonthYear)
+	if err != nil {
+		return result, err
+	}
+	slotCacheLock.Lock()
+	// a booking written while this read was in flight may be missing from it
+	if prev, ok := slotCache[key]; !ok || !prev.at.After(fetched) {
+		slotCache[key] = cachedSlotMonth{result: result, at: fetched}
+	}
+	slotCacheLock.Unlock()
+	return result, nil
+}
+
+// invalidateSlotMonth drops a month of a branch calendar from the cache.
+func invalidateSlotMonth(b Branch, thaiMonthYear string) {
+	slotCacheLock.Lock()
+	slotCache[slotCalendarKey(b)+"|"+thaiMonthYear] = cachedSlotMonth{at: time.Now(), dropped: true}
+	slotCacheLock.Unlock()
+}
+
+// bookingMonth is the Thai month-year of a YYYY-MM-DD booking date.
+func bookingMonth(date string) string {
+	day, _ := time.Parse("2006-01-02", date)
+	return thaiMonthYear(day)
+}
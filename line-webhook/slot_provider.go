@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Free slots are read through a SlotProvider, so the calendar behind a branch can change
+// without touching the tools:
+//   - apps_script (default): the scheduling endpoint at SLOTS_URL, or a branch's slots_url,
+//     whose response is read with the branch's slots_format;
+//   - sheets_api: the month's sheet read directly with the Google Sheets API (GOOGLE_SHEETS_ID),
+//     skipping the Apps Script hop, see sheets_slots.go. With a service account it also writes
+//     bookings into the sheet. Branches with their own slots_url keep using it.
+//
+// Reads time out after SLOTS_TIMEOUT_SECONDS and 5xx/429 answers are retried
+// SLOTS_RETRY_ATTEMPTS times. A response that isn't in the expected shape is a
+// *SlotFormatError, which keeps the body. Other bookings, cancellations and month seeding
+// are written through the Apps Script.
+
+// SlotProvider reads one month of free slots from a calendar.
+type SlotProvider interface {
+	// Name identifies the provider in logs and results, e.g. "apps_script" or "sheets_api".
+	Name() string
+	// MonthSlots returns the free slots of a Thai month-year ("ตุลาคม 2569"). A month the
+	// calendar doesn't have yet is an error wrapping errEmptySlotData.
+	MonthSlots(ctx context.Context, thaiMonthYear string) (AvailabilityResult, error)
+}
+
+// SlotFormatError is a calendar response that doesn't match the provider's format.
+type SlotFormatError struct {
+	Provider string
+	Format   string
+	Body     string
+}
+
+func (e *SlotFormatError) Error() string {
+	return fmt.Sprintf("%s response is not in the %s format", e.Provider, e.Format)
+}
+
+// slotProviderFor returns the calendar of the user's branch, served from the slot cache.
+func slotProviderFor(userId string) SlotProvider {
+	b, _ := customerBranch(userId)
+	return &cachedSlotProvider{SlotProvider: branchSlotProvider(b), calendar: slotCalendarKey(b)}
+}
+
+// branchSlotProvider returns a branch calendar; the zero Branch is the default calendar.
+func branchSlotProvider(b Branch) SlotProvider {
+	client := &http.Client{Timeout: appConfig.SlotsTimeout, Transport: schedulingTransport}
+	if b.SlotsURL == "" && appConfig.SlotsProvider == "sheets_api" {
+		return &sheetsAPISlotProvider{SpreadsheetID: appConfig.GoogleSheetsID, APIKey: appConfig.GoogleSheetsAPIKey,
+			Credentials: googleCredentials, Client: client}
+	}
+	return &appsScriptSlotProvider{Branch: b, Format: branchSlotsFormat(b), Client: client}
+}
+
+// slotWriter is implemented by providers that write bookings into the calendar themselves
+// instead of through the Apps Script.
+type slotWriter interface {
+	// HoldSlot takes a free place in the slot for ref, marked tentative until the deposit is
+	// paid. Holding a slot ref already has updates its mark. errSlotTaken means it is full.
+	HoldSlot(ctx context.Context, date, timeSlot, ref string, tentative bool) error
+	// ReleaseSlot frees the place ref holds; a slot ref doesn't hold is left alone.
+	ReleaseSlot(ctx context.Context, date, timeSlot, ref string) error
+}
+
+// branchSlotWriter returns the writer of a branch calendar, or nil when bookings go through
+// the Apps Script.
+func branchSlotWriter(b Branch) slotWriter {
+	if p, ok := branchSlotProvider(b).(*sheetsAPISlotProvider); ok && p.Credentials != nil {
+		return p
+	}
+	return nil
+}
+
+// appsScriptSlotProvider calls a scheduling endpoint with the month as ?sheet=.
+type appsScriptSlotProvider struct {
+	Branch Branch
+	Format string // a slotsParsers key
+	Client *http.Client
+}
+
+func (p *appsScriptSlotProvider) Name() string { return "apps_script" }
+
+func (p *appsScriptSlotProvider) MonthSlots(ctx context.Context, thaiMonthYear string) (AvailabilityResult, error) {
+	body, err := getSlotData(ctx, p.Client, branchSlotsURL(p.Branch, thaiMonthYear))
+	if err != nil {
+		return AvailabilityResult{}, err
+	}
+	// the sheet always lists the month's days, so a near-empty answer means no sheet
+	if body == "" || body == "[]" || body == "{}" || len(body) < 20 {
+		log.Printf("Slot API returned no data for %s, flagging for admin", thaiMonthYear)
+		return AvailabilityResult{}, &UpstreamError{Service: "scheduling", Err: errEmptySlotData}
+	}
+	return parseAvailability(p.Name(), body, p.Format, thaiMonthYear)
+}
+
+// getSlotData fetches a calendar response.
+func getSlotData(ctx context.Context, client *http.Client, reqURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	return doSlotRequest(client, req)
+}
+
+// doSlotRequest sends a calendar request; anything but 200 is an *UpstreamError.
+func doSlotRequest(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error calling scheduling API: %v", err)
+		return "", classifyRequestError("scheduling", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", classifyRequestError("scheduling", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &UpstreamError{Service: "scheduling", StatusCode: resp.StatusCode, Err: errors.New(truncateRunes(string(body), 300))}
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// slotMonthExists reports whether a branch calendar has the month. A month whose data can't
+// be read still exists.
+func slotMonthExists(ctx context.Context, b Branch, thaiMonthYear string) (bool, error) {
+	_, err := branchSlotProvider(b).MonthSlots(ctx, thaiMonthYear)
+	var formatErr *SlotFormatError
+	switch {
+	case err == nil, errors.As(err, &formatErr):
+		return true, nil
+	case errors.Is(err, errEmptySlotData):
+		return false, nil
+	}
+	return false, err
+}
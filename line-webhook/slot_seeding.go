@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/thaidate"
+)
+
+// A month missing from the scheduling sheet makes every slot lookup for it fail. New months
+// are generated from a template (working days, time slots, team capacity) and sent to the
+// branch's Apps Script as a "seed_month" POST, which creates the month's sheet:
+//
+//	{"action": "seed_month", "sheet": "พฤศจิกายน 2569",
+//	 "days": [{"date": "2026-11-02", "weekday": "จันทร์", "slots": {"09:00-12:00": 2}}]}
+//
+// Each slot maps to the number of jobs the team can take, which the apps_script parser reads
+// as free while it is above 0. The nightly reconciliation reports months that are missing,
+// and creates them when SLOT_AUTO_SEED is set.
+
+// SlotTemplate describes a month of appointment slots.
+type SlotTemplate struct {
+	WorkingDays    []int          `json:"working_days"`              // 0 = Sunday … 6 = Saturday
+	Slots          []string       `json:"slots"`                     // "HH:MM-HH:MM"
+	TeamCapacity   int            `json:"team_capacity"`             // jobs per slot
+	BranchCapacity map[string]int `json:"branch_capacity,omitempty"` // team_capacity per branch ID
+	Holidays       []string       `json:"holidays,omitempty"`        // YYYY-MM-DD days left out
+}
+
+// SeededDay is one day of a generated month.
+type SeededDay struct {
+	Date    string         `json:"date"`
+	Weekday string         `json:"weekday"`
+	Slots   map[string]int `json:"slots"`
+}
+
+// SlotSeedResult reports a seeding request.
+type SlotSeedResult struct {
+	Month   string      `json:"month"`
+	Branch  string      `json:"branch,omitempty"`
+	DryRun  bool        `json:"dry_run"`
+	Created bool        `json:"created"`
+	Days    []SeededDay `json:"days"`
+}
+
+var slotTemplateFile = "slot_template.json"
+
+var slotRangePattern = regexp.MustCompile(`^\d{1,2}[:.]\d{2}\s*[-–]\s*\d{1,2}[:.]\d{2}$`)
+
+var (
+	slotTemplateLock sync.RWMutex
+	slotTemplate     = SlotTemplate{
+		WorkingDays:  []int{1, 2, 3, 4, 5, 6},
+		Slots:        []string{"09:00-12:00", "13:00-16:00"},
+		TeamCapacity: 1,
+	}
+)
+
+func (t SlotTemplate) validate() error {
+	if len(t.WorkingDays) == 0 || len(t.Slots) == 0 {
+		return fmt.Errorf("working_days and slots are required")
+	}
+	for _, d := range t.WorkingDays {
+		if d < 0 || d > 6 {
+			return fmt.Errorf("working_days must be 0 (Sunday) to 6 (Saturday)")
+		}
+	}
+	for _, s := range t.Slots {
+		if !slotRangePattern.MatchString(strings.TrimSpace(s)) {
+			return fmt.Errorf("slot %q must look like 09:00-12:00", s)
+		}
+	}
+	if t.TeamCapacity < 1 {
+		return fmt.Errorf("team_capacity must be at least 1")
+	}
+	for id, n := range t.BranchCapacity {
+		if n < 1 {
+			return fmt.Errorf("branch_capacity.%s must be at least 1", id)
+		}
+	}
+	for _, h := range t.Holidays {
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return fmt.Errorf("holiday %q must be YYYY-MM-DD", h)
+		}
+	}
+	return nil
+}
+
+// generateSlotMonth lays the template out over the month's days.
+func generateSlotMonth(t SlotTemplate, first time.Time, branchID string) []SeededDay {
+	capacity := t.TeamCapacity
+	if n, ok := t.BranchCapacity[branchID]; ok {
+		capacity = n
+	}
+	working := map[time.Weekday]bool{}
+	for _, d := range t.WorkingDays {
+		working[time.Weekday(d)] = true
+	}
+	holidays := map[string]bool{}
+	for _, h := range t.Holidays {
+		holidays[h] = true
+	}
+	slots := uniqueSortedSlots(t.Slots)
+	days := []SeededDay{}
+	for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		if !working[d.Weekday()] || holidays[date] {
+			continue
+		}
+		day := SeededDay{Date: date, Weekday: thaidate.Weekdays[d.Weekday()], Slots: map[string]int{}}
+		for _, s := range slots {
+			day.Slots[s] = capacity
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// seedSlotMonth creates a month in a branch calendar from the template. A month that already
+// has data is left alone. The zero Branch is the default calendar.
+func seedSlotMonth(b Branch, month string, dryRun bool) (*SlotSeedResult, error) {
+	first, ok := thaidate.ParseMonthKey(month, bangkokNow().Location())
+	if !ok {
+		return nil, fmt.Errorf("month must look like %q", thaiMonthYear(bangkokNow()))
+	}
+	if format := branchSlotsFormat(b); format != "apps_script" {
+		return nil, fmt.Errorf("months can only be created in apps_script calendars, not %s", format)
+	}
+	slotTemplateLock.RLock()
+	t := slotTemplate
+	slotTemplateLock.RUnlock()
+	result := &SlotSeedResult{Month: month, Branch: b.ID, DryRun: dryRun, Days: generateSlotMonth(t, first, b.ID)}
+
+	if exists, err := slotMonthExists(context.Background(), b, month); err != nil {
+		return nil, fmt.Errorf("could not check %s: %w", month, err)
+	} else if exists {
+		return nil, fmt.Errorf("%s already exists", month)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if _, err := postSchedulingAction(b, map[string]interface{}{"action": "seed_month", "sheet": month, "days": result.Days}); err != nil {
+		return nil, err
+	}
+	result.Created = true
+	invalidateSlotMonth(b, month)
+	log.Printf("Created %s in calendar %q with %d days", month, b.ID, len(result.Days))
+	appMetrics.inc("slot_months_seeded")
+	return result, nil
+}
+
+// slotCalendars lists the default calendar and every branch calendar with its own URL.
+func slotCalendars() []Branch {
+	list := []Branch{{}}
+	seen := map[string]bool{appConfig.SlotsURL: true}
+	branchLock.RLock()
+	defer branchLock.RUnlock()
+	for _, b := range branches {
+		if b.SlotsURL != "" && !seen[b.SlotsURL] {
+			seen[b.SlotsURL] = true
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+// slotSeedMonthsAhead is how many months from the current one must exist in every calendar
+// (SLOT_SEED_MONTHS_AHEAD, default 2: this month and the next).
+func slotSeedMonthsAhead() int {
+	return appConfig.SlotSeedMonthsAhead
+}
+
+// checkSlotMonths reports calendar months that are missing, creating them from the template
+// when SLOT_AUTO_SEED is set.
+func checkSlotMonths(ctx context.Context) ([]ReconciliationIssue, error) {
+	autoSeed := appConfig.SlotAutoSeed
+	now := bangkokNow()
+	var issues []ReconciliationIssue
+	for _, b := range slotCalendars() {
+		if branchSlotsFormat(b) != "apps_script" {
+			continue
+		}
+		name := b.ID
+		if name == "" {
+			name = "default"
+		}
+		for i := 0; i < slotSeedMonthsAhead(); i++ {
+			if err := ctx.Err(); err != nil {
+				return issues, err
+			}
+			month := thaiMonthYear(time.Date(now.Year(), now.Month()+time.Month(i), 1, 0, 0, 0, 0, now.Location()))
+			exists, err := slotMonthExists(ctx, b, month)
+			if exists {
+				continue
+			}
+			ref := name + " " + month
+			if err != nil {
+				issues = append(issues, ReconciliationIssue{Ref: ref, Message: "could not check the calendar: " + err.Error()})
+				continue
+			}
+			if !autoSeed {
+				issues = append(issues, ReconciliationIssue{Ref: ref, Message: "month is missing; create it with POST /admin/slots/seed"})
+				continue
+			}
+			if _, err := seedSlotMonth(b, month, false); err != nil {
+				issues = append(issues, ReconciliationIssue{Ref: ref, Message: "month is missing and could not be created: " + err.Error()})
+				continue
+			}
+			issues = append(issues, ReconciliationIssue{Ref: ref, Message: "month was missing and has been created from the template"})
+		}
+	}
+	return issues, nil
+}
+
+func loadSlotTemplate() {
+	data, err := os.ReadFile(slotTemplateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read slot template file: %v", err)
+		}
+		return
+	}
+	var t SlotTemplate
+	if err := json.Unmarshal(data, &t); err != nil {
+		log.Printf("Failed to parse slot template file: %v", err)
+		return
+	}
+	if err := t.validate(); err != nil {
+		log.Printf("Invalid slot template file: %v", err)
+		return
+	}
+	slotTemplateLock.Lock()
+	slotTemplate = t
+	slotTemplateLock.Unlock()
+}
+
+func handleGetSlotTemplate(c *fiber.Ctx) error {
+	slotTemplateLock.RLock()
+	defer slotTemplateLock.RUnlock()
+	return c.JSON(slotTemplate)
+}
+
+func handleReplaceSlotTemplate(c *fiber.Ctx) error {
+	var incoming SlotTemplate
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save slot template")
+	}
+	if err := os.WriteFile(slotTemplateFile, data, 0644); err != nil {
+		log.Printf("Failed to save slot template: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save slot template")
+	}
+	slotTemplateLock.Lock()
+	slotTemplate = incoming
+	slotTemplateLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "template": incoming})
+}
+
+// resolveSeedBranch finds the calendar to seed: a branch ID, or "" for the default calendar.
+func resolveSeedBranch(id string) (Branch, error) {
+	if id == "" {
+		return Branch{}, nil
+	}
+	b, ok := findBranch(id)
+	if !ok {
+		return Branch{}, fmt.Errorf("unknown branch %q", id)
+	}
+	return b, nil
+}
+
+func handleSeedSlotMonth(c *fiber.Ctx) error {
+	var req struct {
+		Month  string `json:"month"`
+		Branch string `json:"branch"`
+		DryRun bool   `json:"dry_run"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	b, err := resolveSeedBranch(req.Branch)
+	if err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	result, err := seedSlotMonth(b, strings.TrimSpace(req.Month), req.DryRun)
+	if err != nil {
+		var upstream *UpstreamError
+		var timeout *TimeoutError
+		if errors.As(err, &upstream) || errors.As(err, &timeout) {
+			return respondError(c, fiber.StatusBadGateway, err.Error())
+		}
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ncs-chatbot/line-webhook/thaidate"
+)
+
+var (
+	slotDateKeys     = []string{"date", "วันที่", "day", "วัน"}
+	slotListKeys     = []string{"slots", "available_slots", "times", "time_slots", "available", "ช่วงเวลา", "เวลา", "คิวว่าง"}
+	slotTimePattern  = regexp.MustCompile(`\d{1,2}[:.]\d{2}(\s*[-–]\s*\d{1,2}[:.]\d{2})?`)
+	slotTextLine     = regexp.MustCompile(`^\s*(\S+)\s*[:：]\s*(.+)$`)
+	slotFullMarkers  = []string{"เต็ม", "full", "ไม่ว่าง", "booked", "hold", "closed", "ปิด"}
+	slotFreeMarkers  = []string{"ว่าง", "available", "free", "open", "true"}
+	slotScheduleNote = "[ระบบ] ส่งตารางด้านบนให้ลูกค้าตามนี้ทุกบรรทัด ห้ามเปลี่ยนวัน เวลา หรือเพิ่มคิวที่ไม่มีในตาราง แล้วถามว่าสะดวกวันและช่วงเวลาใด"
+)
+
+// parseSlotDate reads the date formats the scheduling sheet produces. Apps Script serialises
+// dates as UTC timestamps, so those are converted to Bangkok time first.
+func parseSlotDate(v string) (time.Time, bool) {
+	v = strings.TrimSpace(convertThaiDigits(v))
+	loc := bangkokNow().Location()
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), true
+	}
+	for _, layout := range []string{"2006-01-02", "2/1/2006", "2-1-2006"} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			if t.Year() > 2400 {
+				t = t.AddDate(-543, 0, 0)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// slotStrings turns a slot value into free time slots: a list, a comma separated string,
+// or a map of slot -> status where only free ones are kept.
+func slotStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		if slotIsFull(val) {
+			return nil
+		}
+		return slotTimePattern.FindAllString(convertThaiDigits(val), -1)
+	case []interface{}:
+		var out []string
+		for _, item := range val {
+			if m, ok := item.(map[string]interface{}); ok {
+				if status, ok := m["status"].(string); ok && slotIsFull(status) {
+					continue
+				}
+				if avail, ok := m["available"].(bool); ok && !avail {
+					continue
+				}
+				for _, key := range []string{"time", "slot", "time_slot", "เวลา"} {
+					if s, ok := m[key].(string); ok {
+						out = append(out, slotStrings(s)...)
+						break
+					}
+				}
+				continue
+			}
+			out = append(out, slotStrings(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		var out []string
+		for slot, status := range val {
+			free := false
+			switch s := status.(type) {
+			case bool:
+				free = s
+			case string:
+				free = !slotIsFull(s) && slotIsFree(s)
+			case float64:
+				free = s > 0 // remaining capacity
+			}
+			if free {
+				out = append(out, slotStrings(slot)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func slotIsFull(s string) bool {
+	s = strings.ToLower(s)
+	for _, m := range slotFullMarkers {
+		if strings.Contains(s, m) && !strings.Contains(s, "ไม่เต็ม") {
+			return true
+		}
+	}
+	return false
+}
+
+func slotIsFree(s string) bool {
+	s = strings.ToLower(s)
+	for _, m := range slotFreeMarkers {
+		if strings.Contains(s, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstKey(m map[string]interface{}, keys []string) (interface{}, bool) {
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// parseAppsScriptSlots reads the scheduling Apps Script response. It understands a list of
+// day rows, an object keyed by date (optionally wrapped in "data"/"slots"), and "date: times"
+// text lines.
+func parseAppsScriptSlots(body string) (map[time.Time][]string, bool) {
+	byDate := map[time.Time][]string{}
+	add := func(dateVal interface{}, slots []string) bool {
+		s, ok := dateVal.(string)
+		if !ok {
+			return false
+		}
+		d, ok := parseSlotDate(s)
+		if !ok {
+			return false
+		}
+		byDate[d] = append(byDate[d], slots...)
+		return true
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(body), &parsed); err == nil {
+		if m, ok := parsed.(map[string]interface{}); ok {
+			for _, wrapper := range []string{"data", "slots", "days", "result"} {
+				if inner, ok := m[wrapper]; ok {
+					parsed = inner
+					break
+				}
+			}
+		}
+		recognised := false
+		switch val := parsed.(type) {
+		case []interface{}:
+			for _, row := range val {
+				m, ok := row.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				dateVal, ok := firstKey(m, slotDateKeys)
+				if !ok {
+					continue
+				}
+				slotsVal, _ := firstKey(m, slotListKeys)
+				if status, ok := m["status"].(string); ok && slotIsFull(status) {
+					slotsVal = nil
+				}
+				recognised = add(dateVal, slotStrings(slotsVal)) || recognised
+			}
+		case map[string]interface{}:
+			for k, v := range val {
+				recognised = add(k, slotStrings(v)) || recognised
+			}
+		}
+		if !recognised {
+			return nil, false
+		}
+	} else {
+		for _, line := range strings.Split(body, "\n") {
+			m := slotTextLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			add(m[1], slotStrings(m[2]))
+		}
+		if len(byDate) == 0 {
+			return nil, false
+		}
+	}
+
+	return byDate, true
+}
+
+// uniqueSortedSlots normalises "9.00 - 12.00" to "09:00-12:00" and orders by start time.
+func uniqueSortedSlots(slots []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range slots {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, " ", ""), "–", "-")
+		s = strings.ReplaceAll(s, ".", ":")
+		parts := strings.Split(s, "-")
+		for i, p := range parts {
+			if h, m, ok := strings.Cut(p, ":"); ok {
+				if n, err := strconv.Atoi(h); err == nil {
+					parts[i] = fmt.Sprintf("%02d:%s", n, m)
+				}
+			}
+		}
+		s = strings.Join(parts, "-")
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// formatSlotSchedule renders free days as a bulleted list in Thai (Buddhist-era dates) or English.
+func formatSlotSchedule(result AvailabilityResult, lang string) string {
+	days, thaiMonthYear := result.Days, result.Month
+	var b strings.Builder
+	if lang == "en" {
+		if len(days) > 0 {
+			first := days[0].Date
+			fmt.Fprintf(&b, "📅 Available slots: %s %d (B.E. %d)", first.Month(), first.Year(), first.Year()+543)
+		} else {
+			fmt.Fprintf(&b, "📅 Available slots (%s)", thaiMonthYear)
+		}
+		if len(days) == 0 {
+			b.WriteString("\nNo free slots left this month.")
+		}
+		for _, d := range days {
+			fmt.Fprintf(&b, "\n• %s %d %s: %s", d.Date.Weekday().String()[:3], d.Date.Day(), d.Date.Month().String()[:3], strings.Join(d.Slots, ", "))
+		}
+		return b.String()
+	}
+	fmt.Fprintf(&b, "📅 คิวว่างเดือน%s", thaiMonthYear)
+	if len(days) == 0 {
+		b.WriteString("\nเดือนนี้คิวเต็มแล้วค่ะ")
+	}
+	for _, d := range days {
+		fmt.Fprintf(&b, "\n• %s %d %s %d: %s", thaidate.Weekdays[d.Date.Weekday()], d.Date.Day(), thaidate.MonthAbbrs[d.Date.Month()-1], d.Date.Year()+543, strings.Join(d.Slots, ", "))
+	}
+	return b.String()
+}
+
+// customerLanguage picks "en" when the customer writes without Thai script, else "th", for
+// the slot schedule and error replies.
+func customerLanguage(userId, requested string) string {
+	if requested == "th" || requested == "en" {
+		return requested
+	}
+	for _, text := range recentCustomerText(userId, 3) {
+		for _, r := range text {
+			if unicode.Is(unicode.Thai, r) {
+				return "th"
+			}
+		}
+		if strings.IndexFunc(text, unicode.IsLetter) >= 0 {
+			return "en"
+		}
+	}
+	return "th"
+}
+
+// formatSlotsResult is the get_available_slots_with_months output: the formatted schedule
+// plus a note for the model with the dates the booking tools need.
+func formatSlotsResult(userId string, result AvailabilityResult, lang string) string {
+	var note strings.Builder
+	note.WriteString("\n\n" + slotScheduleNote)
+	if len(result.Days) > 0 {
+		note.WriteString("\nวันที่สำหรับเครื่องมือจองคิว (YYYY-MM-DD):")
+		for _, d := range result.Days {
+			fmt.Fprintf(&note, " %s", d.Date.Format("2006-01-02"))
+		}
+	}
+	return formatSlotSchedule(result, customerLanguage(userId, lang)) + note.String()
+}
+
+// slotSchedulePartial strips the model note so a formatted schedule can go to the customer
+// as a partial answer.
+func slotSchedulePartial(result string) (string, bool) {
+	schedule, _, ok := strings.Cut(result, "\n\n"+slotScheduleNote)
+	return schedule, ok
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Stickers and emoji-only messages are read as a sentiment. The sentiment of a sticker comes
+// from sticker_replies.json (by package, or by package/sticker) and otherwise from the
+// keywords LINE sends with most stickers. Greetings and thanks get an instant canned reply
+// instead of an assistant turn. Agreement, confusion and unknown stickers go to the assistant
+// as a short note, since "OK" after a quote or "?" after an answer matter to the conversation.
+
+const (
+	sentimentGreeting  = "greeting"
+	sentimentThanks    = "thanks"
+	sentimentAgree     = "agree"
+	sentimentConfusion = "confusion"
+)
+
+// StickerReply is a canned answer: text, a LINE sticker, or both.
+type StickerReply struct {
+	Text      string `json:"text,omitempty"`
+	PackageID string `json:"package_id,omitempty"`
+	StickerID string `json:"sticker_id,omitempty"`
+}
+
+// StickerConfig maps stickers to sentiments and sentiments to canned replies.
+type StickerConfig struct {
+	Packages map[string]string       `json:"packages,omitempty"` // package ID -> sentiment
+	Stickers map[string]string       `json:"stickers,omitempty"` // "packageId/stickerId" -> sentiment
+	Replies  map[string]StickerReply `json:"replies"`            // greeting and thanks
+}
+
+var stickerRepliesFile = "sticker_replies.json"
+
+var (
+	stickerLock   sync.RWMutex
+	stickerConfig = StickerConfig{
+		Replies: map[string]StickerReply{
+			sentimentGreeting: {Text: "สวัสดีค่ะ 😊 NCS ยินดีให้บริการค่ะ สนใจทำความสะอาดที่นอน โซฟา ม่าน หรือพรม บอกได้เลยนะคะ"},
+			sentimentThanks:   {Text: "ยินดีค่ะ 🙏 มีอะไรให้ช่วยเพิ่มเติม ทักมาได้ตลอดเลยนะคะ"},
+		},
+	}
+)
+
+// sentimentKeywords match the English keywords LINE attaches to stickers.
+var sentimentKeywords = map[string][]string{
+	sentimentGreeting:  {"hello", "hi", "hey", "greeting", "good morning", "good evening", "nice to meet"},
+	sentimentThanks:    {"thank", "grateful", "appreciate", "bow"},
+	sentimentAgree:     {"ok", "okay", "yes", "agree", "sure", "got it", "understood", "thumbs up", "good", "like"},
+	sentimentConfusion: {"confused", "question", "what", "huh", "hmm", "thinking", "wonder", "puzzled", "why"},
+}
+
+var emojiSentiments = map[string]string{
+	"👋": sentimentGreeting,
+	"🙏": sentimentThanks, "💕": sentimentThanks, "❤": sentimentThanks, "🥰": sentimentThanks,
+	"👍": sentimentAgree, "👌": sentimentAgree, "🆗": sentimentAgree, "✅": sentimentAgree,
+	"🤔": sentimentConfusion, "❓": sentimentConfusion, "😕": sentimentConfusion, "🧐": sentimentConfusion,
+}
+
+var sentimentNotes = map[string]string{
+	sentimentGreeting:  "ทักทาย",
+	sentimentThanks:    "ขอบคุณ",
+	sentimentAgree:     "ตกลง/โอเค",
+	sentimentConfusion: "สงสัย/ไม่เข้าใจ",
+}
+
+func (s StickerConfig) validate() error {
+	for key, sentiment := range s.Packages {
+		if _, ok := sentimentKeywords[sentiment]; !ok {
+			return fmt.Errorf("packages[%s]: unknown sentiment '%s'", key, sentiment)
+		}
+	}
+	for key, sentiment := range s.Stickers {
+		if _, ok := sentimentKeywords[sentiment]; !ok {
+			return fmt.Errorf("stickers[%s]: unknown sentiment '%s'", key, sentiment)
+		}
+		if !strings.Contains(key, "/") {
+			return fmt.Errorf("stickers[%s]: key must be packageId/stickerId", key)
+		}
+	}
+	for sentiment, r := range s.Replies {
+		if sentiment != sentimentGreeting && sentiment != sentimentThanks {
+			return fmt.Errorf("replies: only greeting and thanks have canned replies, not '%s'", sentiment)
+		}
+		if r.Text == "" && r.StickerID == "" {
+			return fmt.Errorf("replies[%s]: text or sticker_id is required", sentiment)
+		}
+		if (r.PackageID == "") != (r.StickerID == "") {
+			return fmt.Errorf("replies[%s]: package_id and sticker_id go together", sentiment)
+		}
+	}
+	return nil
+}
+
+// stickerSentiment classifies a sticker; "" when nothing matches.
+func stickerSentiment(packageID, stickerID string, keywords []string) string {
+	stickerLock.RLock()
+	if s, ok := stickerConfig.Stickers[packageID+"/"+stickerID]; ok {
+		stickerLock.RUnlock()
+		return s
+	}
+	if s, ok := stickerConfig.Packages[packageID]; ok {
+		stickerLock.RUnlock()
+		return s
+	}
+	stickerLock.RUnlock()
+	for _, sentiment := range []string{sentimentThanks, sentimentGreeting, sentimentConfusion, sentimentAgree} {
+		for _, kw := range keywords {
+			padded := " " + strings.ToLower(kw) + " "
+			for _, want := range sentimentKeywords[sentiment] {
+				// whole words only, so "hi" doesn't match "white"; a plural s is allowed
+				if strings.Contains(padded, " "+want+" ") || strings.Contains(padded, " "+want+"s ") {
+					return sentiment
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// emojiSentiment classifies a text message made only of emoji; "" for anything else.
+func emojiSentiment(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	for _, r := range text {
+		if !unicode.Is(unicode.So, r) && !unicode.Is(unicode.Sk, r) && !unicode.IsSpace(r) &&
+			r != '\u200d' && !unicode.Is(unicode.Mn, r) {
+			return ""
+		}
+	}
+	for emoji, sentiment := range emojiSentiments {
+		if strings.Contains(text, emoji) {
+			return sentiment
+		}
+	}
+	return ""
+}
+
+// stickerMessageText is what a sticker adds to the conversation and, unless answered with a
+// canned reply, what the assistant sees.
+func stickerMessageText(sentiment, text string, keywords []string) string {
+	switch {
+	case sentiment != "":
+		return "[ลูกค้าส่งสติกเกอร์: " + sentimentNotes[sentiment] + "]"
+	case text != "":
+		return "[ลูกค้าส่งสติกเกอร์ข้อความ: " + text + "]"
+	case len(keywords) > 0:
+		n := len(keywords)
+		if n > 5 {
+			n = 5
+		}
+		return "[ลูกค้าส่งสติกเกอร์: " + strings.Join(keywords[:n], ", ") + "]"
+	}
+	return "[ลูกค้าส่งสติกเกอร์]"
+}
+
+// answerSentiment sends the canned reply for greetings and thanks and reports whether the
+// message was answered. A new customer's greeting gets the first-time greeting if enabled.
+func answerSentiment(userId, replyToken, sentiment, messageContent string, isNewUser bool) bool {
+	if sentiment != sentimentGreeting && sentiment != sentimentThanks {
+		return false
+	}
+	started := time.Now()
+	userThreadLock.Lock()
+	conv := userConversations[userId]
+	if conv != nil && conv.Takeover {
+		userThreadLock.Unlock()
+		return false // staff are handling the chat
+	}
+	if len(userMsgBuffer[userId]) > 1 {
+		userThreadLock.Unlock()
+		return false // answered together with the messages before it
+	}
+	userThreadLock.Unlock()
+
+	note := "[ตอบสติกเกอร์อัตโนมัติ: " + sentiment + "]"
+	if isNewUser && sentiment == sentimentGreeting && sendFirstTimeGreeting(userId, replyToken) {
+		note = "[ส่งข้อความต้อนรับอัตโนมัติ]"
+	} else {
+		stickerLock.RLock()
+		reply, ok := stickerConfig.Replies[sentiment]
+		stickerLock.RUnlock()
+		if !ok {
+			return false
+		}
+		var messages []map[string]interface{}
+		if reply.Text != "" {
+			messages = append(messages, map[string]interface{}{"type": "text", "text": reply.Text})
+		}
+		if reply.StickerID != "" {
+			messages = append(messages, map[string]interface{}{"type": "sticker", "packageId": reply.PackageID, "stickerId": reply.StickerID})
+		}
+		if replyToken != "" {
+			replyOrPush(userId, replyToken, messages)
+		} else if err := pushLineMessages(userId, messages); err != nil {
+			log.Printf("Failed to push %s sticker reply to %s: %v", sentiment, userId, err)
+			return false
+		}
+		if reply.Text != "" {
+			note = reply.Text
+		}
+	}
+
+	stats := &TurnStats{Path: "fast_path"}
+	finishTurnStats(stats, started)
+	userThreadLock.Lock()
+	buf := userMsgBuffer[userId]
+	if n := len(buf); n > 0 && buf[n-1] == messageContent {
+		userMsgBuffer[userId] = buf[:n-1]
+	}
+	if conv, ok := userConversations[userId]; ok {
+		conv.appendTurn(note, stats)
+	}
+	userThreadLock.Unlock()
+	go saveConversations()
+	appMetrics.inc("sticker_replies_" + sentiment)
+	return true
+}
+
+func loadStickerConfig() {
+	data, err := os.ReadFile(stickerRepliesFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read sticker replies file: %v", err)
+		}
+		return
+	}
+	var cfg StickerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Printf("Failed to parse sticker replies file: %v", err)
+		return
+	}
+	if err := cfg.validate(); err != nil {
+		log.Printf("Invalid sticker replies file: %v", err)
+		return
+	}
+	stickerLock.Lock()
+	stickerConfig = cfg
+	stickerLock.Unlock()
+}
+
+func handleGetStickerConfig(c *fiber.Ctx) error {
+	stickerLock.RLock()
+	defer stickerLock.RUnlock()
+	return c.JSON(stickerConfig)
+}
+
+func handleReplaceStickerConfig(c *fiber.Ctx) error {
+	var incoming StickerConfig
+	if err := c.BodyParser(&incoming); err != nil {
+		return respondError(c, fiber.StatusBadRequest, "invalid JSON payload")
+	}
+	if err := incoming.validate(); err != nil {
+		return respondError(c, fiber.StatusBadRequest, err.Error())
+	}
+	data, err := json.MarshalIndent(incoming, "", "  ")
+	if err != nil {
+		return respondError(c, fiber.StatusInternalServerError, "unable to save sticker replies")
+	}
+	if err := os.WriteFile(stickerRepliesFile, data, 0644); err != nil {
+		log.Printf("Failed to save sticker replies: %v", err)
+		return respondError(c, fiber.StatusInternalServerError, "unable to save sticker replies")
+	}
+	stickerLock.Lock()
+	stickerConfig = incoming
+	stickerLock.Unlock()
+	return c.JSON(fiber.Map{"status": "ok", "sticker_replies": incoming})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"unicode/utf8"
+)
+
+// Logs are JSON lines written through log/slog; LOG_FORMAT=text gives plain key=value lines for
+// local runs. The standard log package goes through the same handler, so every log.Printf line
+// is a record with level INFO and a msg field. Lines about a customer turn also carry user_id,
+// request_id (the /webhook call that brought the message) and run_id (the assistant turn), so
+// one conversation can be followed across buffering, tool calls and the reply. With
+// LOG_REDACT_CONTENT=true, message text and model output are logged as their length only;
+// otherwise personal data in them is masked (pii.go).
+
+type loggerKey struct{}
+
+// setupLogging installs the JSON (or text) handler as the default logger. Debug records are
+// always passed to the handler; debugf decides whether to write them (see LogControls).
+func setupLogging() {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, opts)
+	if appConfig.LogFormat == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	if logMaskPII() {
+		handler = piiMaskingHandler{handler}
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// newLogID returns a short random ID for correlating log lines.
+func newLogID() string {
+	buf := make([]byte, 6)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// withLogger attaches a logger carrying correlation attributes to ctx.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger attached to ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func redactLogContent() bool {
+	return appConfig.LogRedactContent
+}
+
+// logContent returns customer or model text for a log line, or only its length when
+// LOG_REDACT_CONTENT is set.
+func logContent(s string) string {
+	if redactLogContent() {
+		return fmt.Sprintf("[redacted %d chars]", utf8.RuneCountInString(s))
+	}
+	return s
+}
@@ -150,5 +150,6 @@ echo "2. Set environment variables:"
 echo "   export LINE_CHANNEL_ACCESS_TOKEN=your_token"
 echo "   export OPENAI_API_KEY=your_openai_key"
 echo "   export OPENAI_ASSISTANT_ID=your_assistant_id"
+echo "   export ALLOW_UNSIGNED_WEBHOOKS=true  # these requests are not signed"
 echo "3. Check server logs for responses"
 echo "4. For image testing, you need a valid LINE_CHANNEL_ACCESS_TOKEN"
@@ -0,0 +1,525 @@
+// Package thaidate reads the ways customers write dates in Thai chats — "15 ส.ค. 68",
+// "เสาร์นี้", "ต้นเดือนหน้า", "พฤศจิกา", Buddhist-era years, Thai digits — and turns them
+// into the forms the tools use: month keys named like the scheduling sheets ("ตุลาคม 2569")
+// and ISO dates ("2026-10-15"). Everything is relative to a caller-supplied now, in its
+// location, so the package has no clock of its own.
+package thaidate
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonthNames are the full Thai month names, January first.
+var MonthNames = []string{"มกราคม", "กุมภาพันธ์", "มีนาคม", "เมษายน", "พฤษภาคม", "มิถุนายน",
+	"กรกฎาคม", "สิงหาคม", "กันยายน", "ตุลาคม", "พฤศจิกายน", "ธันวาคม"}
+
+// MonthAbbrs are the abbreviated Thai month names, January first.
+var MonthAbbrs = []string{"ม.ค.", "ก.พ.", "มี.ค.", "เม.ย.", "พ.ค.", "มิ.ย.", "ก.ค.", "ส.ค.", "ก.ย.", "ต.ค.", "พ.ย.", "ธ.ค."}
+
+// Weekdays are the Thai day names, Sunday first like time.Weekday.
+var Weekdays = []string{"อาทิตย์", "จันทร์", "อังคาร", "พุธ", "พฤหัสบดี", "ศุกร์", "เสาร์"}
+
+// beOffset converts Buddhist-era years to Gregorian.
+const beOffset = 543
+
+// Span is the days a phrase refers to, From and To inclusive, both at midnight.
+type Span struct {
+	From, To time.Time
+}
+
+// Day returns the day of a one-day span.
+func (s Span) Day() (time.Time, bool) {
+	return s.From, s.From.Equal(s.To)
+}
+
+// MonthKeys names every month the span touches, e.g. ["ตุลาคม 2569", "พฤศจิกายน 2569"].
+func (s Span) MonthKeys() []string {
+	var keys []string
+	for m := firstOfMonth(s.From); !m.After(s.To); m = m.AddDate(0, 1, 0) {
+		keys = append(keys, MonthKey(m))
+	}
+	return keys
+}
+
+// MonthKey names the month of t as the scheduling sheets do: "ตุลาคม 2569".
+func MonthKey(t time.Time) string {
+	return MonthNames[t.Month()-1] + " " + strconv.Itoa(t.Year()+beOffset)
+}
+
+// ISO formats the day of t as YYYY-MM-DD.
+func ISO(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// NormalizeMonth returns the month key of a month phrase ("ต.ค. 68", "เดือนหน้า", "October").
+// A phrase naming a day or a period resolves to the month it starts in.
+func NormalizeMonth(s string, now time.Time) (string, bool) {
+	span, ok := Parse(s, now)
+	if !ok {
+		return "", false
+	}
+	return MonthKey(span.From), true
+}
+
+// NormalizeDate returns the ISO date of a phrase naming one day ("15 ส.ค. 68", "เสาร์หน้า").
+func NormalizeDate(s string, now time.Time) (string, bool) {
+	span, ok := Parse(s, now)
+	if !ok {
+		return "", false
+	}
+	day, ok := span.Day()
+	if !ok {
+		return "", false
+	}
+	return ISO(day), true
+}
+
+// ParseMonthKey reads a month key ("พฤศจิกายน 2569") strictly, as the first day of the month.
+func ParseMonthKey(s string, loc *time.Location) (time.Time, bool) {
+	fields := strings.Fields(convertDigits(s))
+	if len(fields) != 2 {
+		return time.Time{}, false
+	}
+	year, err := strconv.Atoi(fields[1])
+	if err != nil || year < 2400 {
+		return time.Time{}, false
+	}
+	for i, name := range MonthNames {
+		if fields[0] == name {
+			return time.Date(year-beOffset, time.Month(i+1), 1, 0, 0, 0, 0, loc), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse finds the first date expression in s. Years may be Buddhist era (2569, 69) or
+// Gregorian (2026); without one, the next occurrence from now is meant. "นี้" after a weekday
+// is its coming occurrence and "หน้า" the one in next week (weeks start on Monday).
+func Parse(s string, now time.Time) (Span, bool) {
+	text := normalize(s)
+	if text == "" {
+		return Span{}, false
+	}
+	today := midnight(now)
+	for _, parse := range parsers {
+		if span, ok := parse(text, today); ok {
+			return span, true
+		}
+	}
+	return Span{}, false
+}
+
+// parsers are tried in order; more specific forms come first.
+var parsers = []func(text string, today time.Time) (Span, bool){
+	parseISODate,
+	parseNumericDate,
+	parseDayMonthName,
+	parseMonthName,
+	parseNumericMonth,
+	parseRelativeDay,
+	parseWeekday,
+	parseWeek,
+	parseRelativeMonth,
+	parseDayOfMonth,
+}
+
+var (
+	isoDatePattern      = regexp.MustCompile(`(\d{4})-(\d{1,2})-(\d{1,2})`)
+	numericDatePattern  = regexp.MustCompile(`(?:^|[^\d/])(\d{1,2})/(\d{1,2})(?:/(\d{2,4}))?(?:$|[^\d/])`)
+	numericMonthPattern = regexp.MustCompile(`(?:^|[^\d/-])(?:(\d{1,2})/(\d{4})|(\d{4})-(\d{1,2}))(?:$|[^\d/-])`)
+	dayOfMonthPattern   = regexp.MustCompile(`(?:วันที่|ที่)\s*(\d{1,2})(?:$|\D)`)
+	monthsAheadPattern  = regexp.MustCompile(`อีก\s*(\d{1,2})\s*เดือน`)
+	// a year after a month name: 4 digits, or 2 after พ.ศ./ค.ศ. or a separator ("ต.ค./68")
+	yearPattern = regexp.MustCompile(`^\s*(?:(พ\.?\s*ศ\.?|ค\.?\s*ศ\.?)\s*(\d{4}|\d{2})|(\d{4})|[/'-]\s*(\d{2}))(?:$|\D)`)
+	// a 2-digit year right after an abbreviated month ("15 ส.ค. 68"); "ตุลาคม 10 โมง" has none
+	shortYearPattern = regexp.MustCompile(`^\s*(\d{2})(?:$|\D)`)
+	// period of a month: ต้นเดือน 1-10, กลางเดือน 11-20, ปลาย/สิ้นเดือน 21-end
+	periodPattern     = regexp.MustCompile(`(ต้น|กลาง|ปลาย|สิ้น)\s*(?:เดือน)?\s*$`)
+	barePeriodPattern = regexp.MustCompile(`(ต้น|กลาง|ปลาย|สิ้น)\s*เดือน`)
+)
+
+// monthTokens are the spellings of each month, longest first so "มี.ค." wins over "ม.ค."
+// and "ตุลาคม" over "ตุลา".
+var monthTokens = func() []monthToken {
+	var tokens []monthToken
+	colloquial := []string{"มกรา", "กุมภา", "มีนา", "เมษา", "พฤษภา", "มิถุนา", "กรกฎา", "สิงหา", "กันยา", "ตุลา", "พฤศจิกา", "ธันวา"}
+	english := []string{"january", "february", "march", "april", "may", "june", "july", "august", "september", "october", "november", "december"}
+	for i := 0; i < 12; i++ {
+		m := time.Month(i + 1)
+		tokens = append(tokens,
+			monthToken{MonthNames[i], m},
+			monthToken{colloquial[i], m},
+			monthToken{MonthAbbrs[i], m},
+			monthToken{strings.TrimSuffix(MonthAbbrs[i], "."), m}, // "ส.ค 68"
+			monthToken{english[i], m},
+		)
+		if len(english[i]) > 3 {
+			tokens = append(tokens, monthToken{english[i][:3], m})
+		}
+	}
+	tokens = append(tokens, monthToken{"sept", time.September})
+	sort.SliceStable(tokens, func(a, b int) bool { return len(tokens[a].text) > len(tokens[b].text) })
+	return tokens
+}()
+
+type monthToken struct {
+	text  string
+	month time.Month
+}
+
+// findMonth returns the first month named in text and the byte offsets of the name.
+func findMonth(text string) (time.Month, int, int, bool) {
+	best, bestStart, bestEnd := time.Month(0), -1, -1
+	for _, tok := range monthTokens {
+		i := indexWord(text, tok.text)
+		if i >= 0 && (bestStart < 0 || i < bestStart) {
+			best, bestStart, bestEnd = tok.month, i, i+len(tok.text)
+		}
+	}
+	return best, bestStart, bestEnd, bestStart >= 0
+}
+
+// indexWord finds tok in text; Latin tokens must not touch other letters ("mar" in "market"),
+// and "may" must be next to a number ("may 15", "15 may"), since it is usually the verb.
+func indexWord(text, tok string) int {
+	latin := tok[0] < 0x80
+	for from := 0; ; {
+		i := strings.Index(text[from:], tok)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		end := i + len(tok)
+		if !latin {
+			return i
+		}
+		if (i == 0 || !isLetter(text[i-1])) && (end == len(text) || !isLetter(text[end])) &&
+			(tok != "may" || nextToNumber(text, i, end)) {
+			return i
+		}
+		from = i + 1
+	}
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// nextToNumber reports whether text[start:end] has a number right before or after it.
+func nextToNumber(text string, start, end int) bool {
+	before := strings.TrimRight(text[:start], " ")
+	after := strings.TrimLeft(text[end:], " ")
+	return (before != "" && isDigit(before[len(before)-1])) || (after != "" && isDigit(after[0]))
+}
+
+func parseISODate(text string, today time.Time) (Span, bool) {
+	m := isoDatePattern.FindStringSubmatch(text)
+	if m == nil {
+		return Span{}, false
+	}
+	year, _ := strconv.Atoi(m[1])
+	return daySpan(fullYear(year), atoi(m[2]), atoi(m[3]), today)
+}
+
+func parseNumericDate(text string, today time.Time) (Span, bool) {
+	m := numericDatePattern.FindStringSubmatch(text)
+	if m == nil {
+		return Span{}, false
+	}
+	day, month := atoi(m[1]), atoi(m[2])
+	if m[3] == "" {
+		return nextDay(month, day, today)
+	}
+	year := fullYear(atoi(m[3]))
+	if !plausibleYear(year, today) {
+		return Span{}, false
+	}
+	return daySpan(year, month, day, today)
+}
+
+func parseDayMonthName(text string, today time.Time) (Span, bool) {
+	month, start, end, ok := findMonth(text)
+	if !ok {
+		return Span{}, false
+	}
+	before := strings.TrimRight(strings.TrimSuffix(strings.TrimRight(text[:start], " "), "เดือน"), " ")
+	digits := len(before)
+	for digits > 0 && before[digits-1] >= '0' && before[digits-1] <= '9' {
+		digits--
+	}
+	if digits == len(before) || len(before)-digits > 2 {
+		return Span{}, false
+	}
+	day := atoi(before[digits:])
+	if year, ok := yearAfter(text[start:end], text[end:], today); ok {
+		return daySpan(year, int(month), day, today)
+	}
+	return nextDay(int(month), day, today)
+}
+
+func parseMonthName(text string, today time.Time) (Span, bool) {
+	month, start, end, ok := findMonth(text)
+	if !ok {
+		return Span{}, false
+	}
+	var first time.Time
+	if year, ok := yearAfter(text[start:end], text[end:], today); ok {
+		first = time.Date(year, month, 1, 0, 0, 0, 0, today.Location())
+	} else {
+		first = time.Date(today.Year(), month, 1, 0, 0, 0, 0, today.Location())
+		if first.Before(firstOfMonth(today)) {
+			first = first.AddDate(1, 0, 0)
+		}
+	}
+	prefix := strings.TrimSuffix(strings.TrimRight(text[:start], " "), "เดือน")
+	return monthSpan(first, periodOf(prefix), today), true
+}
+
+func parseNumericMonth(text string, today time.Time) (Span, bool) {
+	m := numericMonthPattern.FindStringSubmatch(text)
+	if m == nil {
+		return Span{}, false
+	}
+	month, year := atoi(m[1]), atoi(m[2])
+	if m[3] != "" {
+		year, month = atoi(m[3]), atoi(m[4])
+	}
+	if month < 1 || month > 12 || !plausibleYear(fullYear(year), today) {
+		return Span{}, false
+	}
+	return monthSpan(time.Date(fullYear(year), time.Month(month), 1, 0, 0, 0, 0, today.Location()), "", today), true
+}
+
+func parseRelativeDay(text string, today time.Time) (Span, bool) {
+	switch {
+	case strings.Contains(text, "มะรืน"):
+		return oneDay(today.AddDate(0, 0, 2)), true
+	case strings.Contains(text, "พรุ่งนี้") || strings.Contains(text, "tomorrow"):
+		return oneDay(today.AddDate(0, 0, 1)), true
+	case strings.Contains(text, "วันนี้") || strings.Contains(text, "today"):
+		return oneDay(today), true
+	}
+	return Span{}, false
+}
+
+// weekdayTokens map day names to weekdays, "พฤหัสบดี" before its short form.
+var weekdayTokens = []struct {
+	text string
+	day  time.Weekday
+}{
+	{"พฤหัสบดี", time.Thursday}, {"อาทิตย์", time.Sunday}, {"จันทร์", time.Monday},
+	{"อังคาร", time.Tuesday}, {"พฤหัส", time.Thursday}, {"ศุกร์", time.Friday},
+	{"เสาร์", time.Saturday}, {"พุธ", time.Wednesday},
+}
+
+func parseWeekday(text string, today time.Time) (Span, bool) {
+	for _, tok := range weekdayTokens {
+		i := strings.Index(text, tok.text)
+		if i < 0 {
+			continue
+		}
+		rest := strings.TrimLeft(text[i+len(tok.text):], " ")
+		// "อาทิตย์นี้/หน้า" without "วัน" means the week, not Sunday
+		if tok.day == time.Sunday && !strings.HasSuffix(strings.TrimRight(text[:i], " "), "วัน") &&
+			(strings.HasPrefix(rest, "นี้") || strings.HasPrefix(rest, "หน้า") || strings.HasPrefix(rest, "ที่แล้ว")) {
+			continue
+		}
+		if strings.HasPrefix(rest, "หน้า") || strings.HasPrefix(rest, "ถัดไป") {
+			nextMonday := weekStart(today).AddDate(0, 0, 7)
+			return oneDay(nextMonday.AddDate(0, 0, (int(tok.day)+6)%7)), true
+		}
+		ahead := (int(tok.day) - int(today.Weekday()) + 7) % 7
+		return oneDay(today.AddDate(0, 0, ahead)), true
+	}
+	return Span{}, false
+}
+
+func parseWeek(text string, today time.Time) (Span, bool) {
+	for _, word := range []string{"สัปดาห์", "อาทิตย์", "week"} {
+		i := strings.Index(text, word)
+		if i < 0 {
+			continue
+		}
+		rest := strings.TrimLeft(text[i+len(word):], " ")
+		switch {
+		case strings.HasPrefix(rest, "หน้า") || strings.HasPrefix(rest, "ถัดไป") || strings.HasSuffix(strings.TrimRight(text[:i], " "), "next"):
+			from := weekStart(today).AddDate(0, 0, 7)
+			return Span{From: from, To: from.AddDate(0, 0, 6)}, true
+		case strings.HasPrefix(rest, "นี้") || strings.HasSuffix(strings.TrimRight(text[:i], " "), "this"):
+			return Span{From: today, To: weekStart(today).AddDate(0, 0, 6)}, true
+		}
+	}
+	return Span{}, false
+}
+
+func parseRelativeMonth(text string, today time.Time) (Span, bool) {
+	this := firstOfMonth(today)
+	if m := monthsAheadPattern.FindStringSubmatch(text); m != nil {
+		return monthSpan(this.AddDate(0, atoi(m[1]), 0), "", today), true
+	}
+	for _, rel := range []struct {
+		word  string
+		ahead int
+	}{{"เดือนหน้า", 1}, {"เดือนถัดไป", 1}, {"next month", 1}, {"เดือนนี้", 0}, {"this month", 0}} {
+		if i := strings.Index(text, rel.word); i >= 0 {
+			return monthSpan(this.AddDate(0, rel.ahead, 0), periodOf(text[:i]), today), true
+		}
+	}
+	// "ปลายเดือน" alone is this month's
+	if m := barePeriodPattern.FindStringSubmatch(text); m != nil {
+		return monthSpan(this, m[1], today), true
+	}
+	return Span{}, false
+}
+
+func parseDayOfMonth(text string, today time.Time) (Span, bool) {
+	m := dayOfMonthPattern.FindStringSubmatch(text)
+	if m == nil {
+		return Span{}, false
+	}
+	day := atoi(m[1])
+	d := time.Date(today.Year(), today.Month(), day, 0, 0, 0, 0, today.Location())
+	if d.Day() != day {
+		return Span{}, false
+	}
+	if d.Before(today) {
+		d = time.Date(today.Year(), today.Month()+1, day, 0, 0, 0, 0, today.Location())
+		if d.Day() != day {
+			return Span{}, false
+		}
+	}
+	return oneDay(d), true
+}
+
+// periodOf returns the month period a phrase ends with ("ต้น", "กลาง", "ปลาย", "สิ้น"), or "".
+func periodOf(prefix string) string {
+	if m := periodPattern.FindStringSubmatch(strings.TrimRight(prefix, " ")); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// monthSpan is the whole month, or the period of it, from today on.
+func monthSpan(first time.Time, period string, today time.Time) Span {
+	last := first.AddDate(0, 1, -1)
+	span := Span{From: first, To: last}
+	switch period {
+	case "ต้น":
+		span.To = first.AddDate(0, 0, 9)
+	case "กลาง":
+		span.From, span.To = first.AddDate(0, 0, 10), first.AddDate(0, 0, 19)
+	case "ปลาย", "สิ้น":
+		span.From = first.AddDate(0, 0, 20)
+	}
+	if span.From.Before(today) && !span.To.Before(today) {
+		span.From = today
+	}
+	return span
+}
+
+// daySpan checks a full date.
+func daySpan(year, month, day int, today time.Time) (Span, bool) {
+	if month < 1 || month > 12 || day < 1 {
+		return Span{}, false
+	}
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, today.Location())
+	if d.Day() != day {
+		return Span{}, false
+	}
+	return oneDay(d), true
+}
+
+// nextDay is the next day/month from today on.
+func nextDay(month, day int, today time.Time) (Span, bool) {
+	span, ok := daySpan(today.Year(), month, day, today)
+	if ok && span.From.Before(today) {
+		return daySpan(today.Year()+1, month, day, today)
+	}
+	return span, ok
+}
+
+// yearAfter reads a year in rest, the text after the month name monthText: "2569", "2026",
+// "พ.ศ. 2569", "ค.ศ. 26", "/69", or "68" after an abbreviation such as "ส.ค.". Other numbers
+// ("ตุลาคม 10 โมง") and years more than maxYearsAway from today are not years.
+func yearAfter(monthText, rest string, today time.Time) (int, bool) {
+	year := 0
+	if m := yearPattern.FindStringSubmatch(rest); m != nil {
+		switch {
+		case m[2] != "" && len(m[2]) == 2 && strings.HasPrefix(m[1], "ค"):
+			year = 2000 + atoi(m[2])
+		case m[2] != "":
+			year = fullYear(atoi(m[2]))
+		case m[3] != "":
+			year = fullYear(atoi(m[3]))
+		default:
+			year = fullYear(atoi(m[4]))
+		}
+	} else if m := shortYearPattern.FindStringSubmatch(rest); m != nil && strings.Contains(monthText, ".") {
+		year = fullYear(atoi(m[1]))
+	}
+	if year == 0 || !plausibleYear(year, today) {
+		return 0, false
+	}
+	return year, true
+}
+
+// maxYearsAway bounds the years customers plausibly mean, so a stray number isn't read as
+// a year decades away.
+const maxYearsAway = 2
+
+func plausibleYear(year int, today time.Time) bool {
+	return year >= today.Year()-maxYearsAway && year <= today.Year()+maxYearsAway
+}
+
+// fullYear reads Buddhist-era years, two-digit ones included ("68" is 2568), as Gregorian.
+func fullYear(y int) int {
+	switch {
+	case y < 100:
+		return 2500 + y - beOffset
+	case y >= 2400:
+		return y - beOffset
+	}
+	return y
+}
+
+func oneDay(d time.Time) Span { return Span{From: d, To: d} }
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func firstOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// weekStart is the Monday of t's week.
+func weekStart(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// normalize lower-cases Latin text, maps Thai digits to ASCII and collapses spaces.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(convertDigits(s))), " ")
+}
+
+func convertDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '๐' && r <= '๙' {
+			return '0' + (r - '๐')
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,203 @@
+package thaidate
+
+import (
+	"testing"
+	"time"
+)
+
+var bangkok = time.FixedZone("Asia/Bangkok", 7*60*60)
+
+// now is Thursday 15 October 2026 (2569), mid-afternoon.
+var now = time.Date(2026, time.October, 15, 14, 30, 0, 0, bangkok)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, bangkok)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to time.Time
+	}{
+		// full and numeric dates
+		{"2026-11-03", date(2026, 11, 3), date(2026, 11, 3)},
+		{"15/11", date(2026, 11, 15), date(2026, 11, 15)},
+		{"1/10", date(2027, 10, 1), date(2027, 10, 1)},
+		{"15/11/69", date(2026, 11, 15), date(2026, 11, 15)},
+		{"15/11/2569", date(2026, 11, 15), date(2026, 11, 15)},
+		{"15/11/2026", date(2026, 11, 15), date(2026, 11, 15)},
+		{"11/2026", date(2026, 11, 1), date(2026, 11, 30)},
+		{"2026-12", date(2026, 12, 1), date(2026, 12, 31)},
+
+		// day and month name
+		{"15 ส.ค. 68", date(2025, 8, 15), date(2025, 8, 15)},
+		{"20 ต.ค. 69", date(2026, 10, 20), date(2026, 10, 20)},
+		{"20 ต.ค 69", date(2026, 10, 20), date(2026, 10, 20)},
+		{"๒๐ ต.ค. ๖๙", date(2026, 10, 20), date(2026, 10, 20)},
+		{"3 พฤศจิกายน", date(2026, 11, 3), date(2026, 11, 3)},
+		{"3 พฤศจิกา", date(2026, 11, 3), date(2026, 11, 3)},
+		{"วันที่ 3 เดือนพฤศจิกายน 2569", date(2026, 11, 3), date(2026, 11, 3)},
+		{"1 มี.ค.", date(2027, 3, 1), date(2027, 3, 1)},
+		{"may 15", date(2027, 5, 1), date(2027, 5, 31)},
+		{"15 may", date(2027, 5, 15), date(2027, 5, 15)},
+		{"3 November 2026", date(2026, 11, 3), date(2026, 11, 3)},
+
+		// month name, with year or period
+		{"พฤศจิกายน 2569", date(2026, 11, 1), date(2026, 11, 30)},
+		{"ต.ค. 2569", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ตุลาคม พ.ศ. 2569", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ตุลาคม ค.ศ. 26", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ต.ค./69", date(2026, 10, 15), date(2026, 10, 31)},
+		{"มกราคม", date(2027, 1, 1), date(2027, 1, 31)},
+		{"december 2026", date(2026, 12, 1), date(2026, 12, 31)},
+		{"sept", date(2027, 9, 1), date(2027, 9, 30)},
+		{"ต้นพฤศจิกา", date(2026, 11, 1), date(2026, 11, 10)},
+		{"กลางเดือนธันวาคม", date(2026, 12, 11), date(2026, 12, 20)},
+		{"ปลายเดือนพฤศจิกายน", date(2026, 11, 21), date(2026, 11, 30)},
+
+		// numbers after a month that are not years
+		{"ตุลาคม 10 โมง", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ปลายตุลาคม 10 โมง", date(2026, 10, 21), date(2026, 10, 31)},
+		{"ต.ค. 10 โมง", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ตุลาคม 2510", date(2026, 10, 15), date(2026, 10, 31)},
+		{"พฤศจิกายน 30 ท่าน", date(2026, 11, 1), date(2026, 11, 30)},
+
+		// relative days and weekdays
+		{"วันนี้", date(2026, 10, 15), date(2026, 10, 15)},
+		{"พรุ่งนี้ว่างไหม", date(2026, 10, 16), date(2026, 10, 16)},
+		{"มะรืนนี้", date(2026, 10, 17), date(2026, 10, 17)},
+		{"you may come tomorrow", date(2026, 10, 16), date(2026, 10, 16)},
+		{"เสาร์นี้", date(2026, 10, 17), date(2026, 10, 17)},
+		{"เสาร์หน้า", date(2026, 10, 24), date(2026, 10, 24)},
+		{"วันจันทร์", date(2026, 10, 19), date(2026, 10, 19)},
+		{"พฤหัสนี้", date(2026, 10, 15), date(2026, 10, 15)},
+		{"พฤหัสบดีหน้า", date(2026, 10, 22), date(2026, 10, 22)},
+		{"วันอาทิตย์หน้า", date(2026, 10, 25), date(2026, 10, 25)},
+
+		// weeks and months
+		{"อาทิตย์หน้า", date(2026, 10, 19), date(2026, 10, 25)},
+		{"สัปดาห์นี้", date(2026, 10, 15), date(2026, 10, 18)},
+		{"next week", date(2026, 10, 19), date(2026, 10, 25)},
+		{"เดือนหน้า", date(2026, 11, 1), date(2026, 11, 30)},
+		{"ปลายเดือนหน้า", date(2026, 11, 21), date(2026, 11, 30)},
+		{"เดือนนี้", date(2026, 10, 15), date(2026, 10, 31)},
+		{"ปลายเดือน", date(2026, 10, 21), date(2026, 10, 31)},
+		{"อีก 2 เดือน", date(2026, 12, 1), date(2026, 12, 31)},
+		{"next month", date(2026, 11, 1), date(2026, 11, 30)},
+
+		// day of this or next month
+		{"วันที่ 20", date(2026, 10, 20), date(2026, 10, 20)},
+		{"วันที่ 5", date(2026, 11, 5), date(2026, 11, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			span, ok := Parse(tt.in, now)
+			if !ok {
+				t.Fatalf("Parse(%q) found no date", tt.in)
+			}
+			if !span.From.Equal(tt.from) || !span.To.Equal(tt.to) {
+				t.Errorf("Parse(%q) = %s..%s, want %s..%s", tt.in, ISO(span.From), ISO(span.To), ISO(tt.from), ISO(tt.to))
+			}
+		})
+	}
+}
+
+func TestParseNoDate(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"สวัสดีค่ะ",
+		"ซักโซฟาราคาเท่าไร",
+		"may i book a sofa cleaning",
+		"you may want the deep clean",
+		"10/10/10",
+		"15/11/2590",
+		"market price",
+	} {
+		t.Run(in, func(t *testing.T) {
+			if span, ok := Parse(in, now); ok {
+				t.Errorf("Parse(%q) = %s..%s, want no date", in, ISO(span.From), ISO(span.To))
+			}
+		})
+	}
+}
+
+func TestNormalizeMonth(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"ต.ค. 69", "ตุลาคม 2569", true},
+		{"ตุลาคม 2569", "ตุลาคม 2569", true},
+		{"เดือนหน้า", "พฤศจิกายน 2569", true},
+		{"October", "ตุลาคม 2569", true},
+		{"มกรา", "มกราคม 2570", true},
+		{"15 ส.ค. 68", "สิงหาคม 2568", true},
+		{"ปลายตุลาคม 10 โมง", "ตุลาคม 2569", true},
+		{"may i book", "", false},
+		{"ราคาเท่าไร", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := NormalizeMonth(tt.in, now)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("NormalizeMonth(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"15 ส.ค. 68", "2025-08-15", true},
+		{"2026-11-03", "2026-11-03", true},
+		{"เสาร์หน้า", "2026-10-24", true},
+		{"พรุ่งนี้", "2026-10-16", true},
+		{"วันที่ 20", "2026-10-20", true},
+		{"เดือนหน้า", "", false},
+		{"ปลายตุลาคม 10 โมง", "", false},
+		{"สวัสดี", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := NormalizeDate(tt.in, now)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("NormalizeDate(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseMonthKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"พฤศจิกายน 2569", date(2026, 11, 1), true},
+		{"มกราคม ๒๕๗๐", date(2027, 1, 1), true},
+		{"พฤศจิกายน 69", time.Time{}, false},
+		{"พ.ย. 2569", time.Time{}, false},
+		{"November 2569", time.Time{}, false},
+		{"พฤศจิกายน", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := ParseMonthKey(tt.in, bangkok)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("ParseMonthKey(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSpanMonthKeys(t *testing.T) {
+	span := Span{From: date(2026, 12, 28), To: date(2027, 1, 3)}
+	got := span.MonthKeys()
+	want := []string{"ธันวาคม 2569", "มกราคม 2570"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("MonthKeys() = %v, want %v", got, want)
+	}
+}
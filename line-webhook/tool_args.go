@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// toolSchema is the subset of JSON Schema that gpt_functions.json uses.
+type toolSchema struct {
+	Required   []string                   `json:"required"`
+	Properties map[string]toolParamSchema `json:"properties"`
+}
+
+type toolParamSchema struct {
+	Type string        `json:"type"`
+	Enum []interface{} `json:"enum,omitempty"`
+}
+
+// toolSchemas maps tool name to its parameter schema; filled by loadToolDefinitions.
+var toolSchemas = map[string]toolSchema{}
+
+// toolArgRepairers fill missing or invalid arguments from what the session already knows.
+// invalid maps field name to the problem found by toolSchema.check.
+var toolArgRepairers = map[string]func(userId string, args map[string]interface{}, invalid map[string]string){
+	"get_ncs_pricing": repairPricingArgs,
+}
+
+// pricingContext is what the last successful quote in a session was about, as config keys.
+type pricingContext struct {
+	ServiceKey string
+	ItemKey    string
+	SizeKey    string
+}
+
+var (
+	lastPricingLock sync.Mutex
+	lastPricing     = make(map[string]pricingContext) // userId -> last quoted service/item/size
+)
+
+func (s toolSchema) isRequired(field string) bool {
+	for _, r := range s.Required {
+		if r == field {
+			return true
+		}
+	}
+	return false
+}
+
+// check coerces argument types where the intent is clear ("2" for an integer) and
+// returns the remaining problems by field.
+func (s toolSchema) check(args map[string]interface{}) map[string]string {
+	invalid := map[string]string{}
+	for _, field := range s.Required {
+		if v, ok := args[field]; !ok || v == nil || v == "" {
+			invalid[field] = "is required"
+		}
+	}
+	for field, v := range args {
+		param, ok := s.Properties[field]
+		if !ok || v == nil || invalid[field] != "" {
+			continue
+		}
+		coerced, ok := coerceToolArg(v, param.Type)
+		if !ok {
+			invalid[field] = "must be " + param.Type
+			continue
+		}
+		args[field] = coerced
+		if len(param.Enum) > 0 && !inToolEnum(coerced, param.Enum) {
+			invalid[field] = fmt.Sprintf("must be one of %v", param.Enum)
+		}
+	}
+	return invalid
+}
+
+func coerceToolArg(v interface{}, typ string) (interface{}, bool) {
+	switch typ {
+	case "string":
+		switch x := v.(type) {
+		case string:
+			return strings.TrimSpace(x), true
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), true
+		}
+	case "integer", "number":
+		switch x := v.(type) {
+		case float64:
+			if typ == "integer" && x != float64(int64(x)) {
+				return nil, false
+			}
+			return x, true
+		case string:
+			if n, ok := pricing.ParseThaiNumber(x); ok && (typ == "number" || n == float64(int64(n))) {
+				return n, true
+			}
+		}
+	case "boolean":
+		switch x := v.(type) {
+		case bool:
+			return x, true
+		case string:
+			if b, err := strconv.ParseBool(x); err == nil {
+				return b, true
+			}
+		}
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return v, ok
+	case "array":
+		_, ok := v.([]interface{})
+		return v, ok
+	default:
+		return v, true
+	}
+	return nil, false
+}
+
+func inToolEnum(v interface{}, enum []interface{}) bool {
+	s := fmt.Sprint(v)
+	for _, e := range enum {
+		if strings.EqualFold(fmt.Sprint(e), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareToolArguments validates model-provided arguments against the tool's schema before
+// the handler runs. Missing or invalid fields are repaired from the session where possible;
+// invalid optional fields are dropped; anything still wrong with a required field is an error.
+func prepareToolArguments(name string, raw json.RawMessage, userId string) (json.RawMessage, error) {
+	schema, ok := toolSchemas[name]
+	if !ok {
+		return raw, nil
+	}
+	args := map[string]interface{}{}
+	if len(strings.TrimSpace(string(raw))) > 0 {
+		decoded, err := decodeToolArgs(raw)
+		if err != nil {
+			appMetrics.inc("tool_args_invalid")
+			return raw, fmt.Errorf("arguments are not a JSON object: %w", err)
+		}
+		if decoded != nil {
+			args = decoded
+		}
+	}
+	invalid := schema.check(args)
+	if len(invalid) > 0 {
+		if repair, ok := toolArgRepairers[name]; ok {
+			repair(userId, args, invalid)
+			if remaining := schema.check(args); len(remaining) < len(invalid) {
+				log.Printf("Repaired %s arguments for %s: %v -> %v", name, userId, invalid, remaining)
+				appMetrics.inc("tool_args_repaired")
+				invalid = remaining
+			}
+		}
+	}
+	for field, problem := range invalid {
+		if !schema.isRequired(field) {
+			log.Printf("Dropping invalid %s argument %s (%s) for %s", name, field, problem, userId)
+			delete(args, field)
+			delete(invalid, field)
+		}
+	}
+	if len(invalid) > 0 {
+		appMetrics.inc("tool_args_invalid")
+		problems := make([]string, 0, len(invalid))
+		for field, problem := range invalid {
+			problems = append(problems, field+" "+problem)
+		}
+		sort.Strings(problems)
+		return raw, errors.New(strings.Join(problems, "; "))
+	}
+	return json.Marshal(args)
+}
+
+// recentCustomerText returns the customer's last few messages, newest first.
+func recentCustomerText(userId string, n int) []string {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	conv, ok := userConversations[userId]
+	if !ok {
+		return nil
+	}
+	var texts []string
+	for i := len(conv.Messages) - 1; i >= 0 && len(texts) < n; i-- {
+		if conv.Messages[i].Role == "customer" {
+			texts = append(texts, conv.Messages[i].original())
+		}
+	}
+	return texts
+}
+
+// rememberPricingContext keeps the keys of a successful quote as session context for repairs.
+func rememberPricingContext(userId string, engine *pricing.Engine, serviceType, itemType, size string) {
+	ctx := pricingContext{ServiceKey: engine.ServiceKey(serviceType), ItemKey: engine.ItemKey(itemType)}
+	if ctx.ItemKey != "" {
+		ctx.SizeKey = engine.SizeKey(size, engine.Config.Items[ctx.ItemKey].Sizes)
+	}
+	lastPricingLock.Lock()
+	lastPricing[userId] = ctx
+	lastPricingLock.Unlock()
+}
+
+// repairPricingArgs fills item, size, service and customer type for get_ncs_pricing from
+// the arguments themselves ("ที่นอนหกฟุต" as item_type), then the customer's recent
+// messages, then the last successful quote in this session.
+func repairPricingArgs(userId string, args map[string]interface{}, invalid map[string]string) {
+	engine := pricingEngineFor(userId)
+	if engine.Config == nil {
+		return
+	}
+	str := func(field string) string {
+		s, _ := args[field].(string)
+		return s
+	}
+	written := strings.TrimSpace(str("item_type") + " " + str("size"))
+	for _, field := range []string{"service_type", "item_type", "customer_type"} {
+		if _, bad := invalid[field]; bad {
+			delete(args, field)
+		}
+	}
+
+	itemKey := engine.ItemKey(str("item_type"))
+	sizeKnown := itemKey != "" && str("size") != "" && engine.SizeKey(str("size"), engine.Config.Items[itemKey].Sizes) != ""
+	recent := recentCustomerText(userId, 3)
+	for _, text := range append([]string{written}, recent...) {
+		if itemKey != "" && sizeKnown {
+			break
+		}
+		ex := engine.ExtractSize(text)
+		if ex.ItemKey == "" || (itemKey != "" && ex.ItemKey != itemKey) {
+			continue
+		}
+		if itemKey == "" {
+			itemKey = ex.ItemKey
+			args["item_type"] = itemKey
+		}
+		if ex.SizeKey != "" && !sizeKnown {
+			args["size"] = ex.SizeKey
+			sizeKnown = true
+		}
+	}
+
+	if str("service_type") == "" {
+		for _, text := range recent {
+			if key := engine.ServiceIn(text); key != "" {
+				args["service_type"] = key
+				break
+			}
+		}
+	}
+	if str("customer_type") == "" {
+		args["customer_type"] = "new"
+		if isMember(userId) {
+			args["customer_type"] = "member"
+		}
+	}
+
+	lastPricingLock.Lock()
+	last, ok := lastPricing[userId]
+	lastPricingLock.Unlock()
+	if !ok {
+		return
+	}
+	if str("service_type") == "" && last.ServiceKey != "" {
+		args["service_type"] = last.ServiceKey
+	}
+	if itemKey == "" && last.ItemKey != "" {
+		itemKey = last.ItemKey
+		args["item_type"] = itemKey
+	}
+	if !sizeKnown && itemKey == last.ItemKey && last.SizeKey != "" {
+		args["size"] = last.SizeKey
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tools that mutate state must be called twice: the first call returns a summary and a
+// confirmation token, and only a second call carrying that token (with identical arguments)
+// executes. This stops the model from double-booking or cancelling on a misread message.
+var confirmationRequiredTools = map[string]bool{
+	"create_booking":         true,
+	"cancel_booking":         true,
+	"redeem_coupon":          true,
+	"purchase_membership":    true,
+	"purchase_gift_voucher":  true,
+	"redeem_gift_voucher":    true,
+	"book_with_contract":     true,
+	"update_booking_details": true,
+	"reschedule_booking":     true,
+}
+
+// toolConfirmationSummaries lets a tool describe its pending action in customer-facing Thai.
+// Tools without an entry get a generic key/value summary.
+var toolConfirmationSummaries = map[string]func(args map[string]interface{}) string{
+	"create_booking":         createBookingSummary,
+	"purchase_membership":    membershipConfirmationSummary,
+	"purchase_gift_voucher":  giftVoucherConfirmationSummary,
+	"redeem_gift_voucher":    redeemGiftVoucherSummary,
+	"book_with_contract":     bookWithContractSummary,
+	"update_booking_details": updateBookingDetailsSummary,
+	"cancel_booking":         cancelBookingSummary,
+	"reschedule_booking":     rescheduleBookingSummary,
+}
+
+const toolConfirmationTTL = 15 * time.Minute
+
+type pendingToolConfirmation struct {
+	UserID    string
+	Tool      string
+	ArgsHash  string
+	CreatedAt time.Time
+	Running   bool // claimed by a call that hasn't finished yet
+	Committed bool
+	Result    string // result of the committed call, returned again on replays
+}
+
+var (
+	toolConfirmationLock sync.Mutex
+	toolConfirmations    = make(map[string]*pendingToolConfirmation) // token -> pending action
+)
+
+// decodeToolArgs parses tool arguments into a map, unwrapping string-encoded JSON.
+func decodeToolArgs(arguments json.RawMessage) (map[string]interface{}, error) {
+	var args map[string]interface{}
+	if err := json.Unmarshal(arguments, &args); err == nil {
+		return args, nil
+	}
+	var s string
+	if err := json.Unmarshal(arguments, &s); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(s), &args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// hashToolArgs returns a stable hash of the arguments, ignoring the confirmation token.
+func hashToolArgs(args map[string]interface{}) string {
+	clean := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		if k != "confirmation_token" {
+			clean[k] = v
+		}
+	}
+	data, _ := json.Marshal(clean) // map keys are marshaled in sorted order
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newConfirmationToken() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return strings.ToUpper(hex.EncodeToString(buf))
+}
+
+func describeToolAction(name string, args map[string]interface{}) string {
+	if fn, ok := toolConfirmationSummaries[name]; ok {
+		return fn(args)
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		if k != "confirmation_token" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteString(fmt.Sprintf("\n• %s: %v", k, args[k]))
+	}
+	return b.String()
+}
+
+// gateToolConfirmation decides whether a mutating tool call may run. It returns proceed=true
+// when the call carries a valid token, which it claims so a second call with the same token
+// is turned away while the first runs; otherwise it returns the message for the model
+// (a new token with a summary, or the reason the token was rejected).
+// Call completeToolConfirmation with the result once the tool has executed.
+func gateToolConfirmation(userId, name string, arguments json.RawMessage) (proceed bool, token string, message string) {
+	args, err := decodeToolArgs(arguments)
+	if err != nil {
+		return false, "", "Error parsing " + name + " arguments: " + err.Error()
+	}
+	argsHash := hashToolArgs(args)
+	provided, _ := args["confirmation_token"].(string)
+	provided = strings.ToUpper(strings.TrimSpace(provided))
+
+	toolConfirmationLock.Lock()
+	defer toolConfirmationLock.Unlock()
+
+	now := time.Now()
+	for t, p := range toolConfirmations {
+		if now.Sub(p.CreatedAt) > toolConfirmationTTL && !p.Running {
+			delete(toolConfirmations, t)
+		}
+	}
+
+	if provided == "" {
+		token := newConfirmationToken()
+		toolConfirmations[token] = &pendingToolConfirmation{
+			UserID:    userId,
+			Tool:      name,
+			ArgsHash:  argsHash,
+			CreatedAt: now,
+		}
+		log.Printf("Tool %s for user %s awaiting confirmation (token %s)", name, userId, token)
+		return false, "", fmt.Sprintf("ยังไม่ได้ดำเนินการ — ต้องยืนยันกับลูกค้าก่อน\nสรุปรายการ:\n%s\n\nให้สรุปรายละเอียดนี้ให้ลูกค้าและถามว่ายืนยันหรือไม่ เมื่อลูกค้ายืนยันชัดเจนแล้ว ให้เรียก %s อีกครั้งด้วยข้อมูลเดิมทุกช่องพร้อม confirmation_token=\"%s\" (หมดอายุใน %d นาที)",
+			describeToolAction(name, args), name, token, int(toolConfirmationTTL.Minutes()))
+	}
+
+	p, ok := toolConfirmations[provided]
+	switch {
+	case !ok:
+		return false, "", "confirmation_token ไม่ถูกต้องหรือหมดอายุแล้ว ให้เรียก " + name + " ใหม่โดยไม่ใส่ confirmation_token เพื่อขอสรุปและยืนยันกับลูกค้าอีกครั้ง"
+	case p.UserID != userId || p.Tool != name:
+		return false, "", "confirmation_token นี้ไม่ได้ออกให้สำหรับรายการนี้ ให้เรียก " + name + " ใหม่โดยไม่ใส่ confirmation_token"
+	case p.ArgsHash != argsHash:
+		return false, "", "ข้อมูลไม่ตรงกับที่ลูกค้ายืนยันไว้ ให้เรียก " + name + " ใหม่โดยไม่ใส่ confirmation_token เพื่อสรุปข้อมูลล่าสุดให้ลูกค้ายืนยัน"
+	case p.Committed:
+		log.Printf("Ignoring replayed %s for user %s (token %s)", name, userId, provided)
+		return false, "", "รายการนี้ดำเนินการไปแล้ว ห้ามทำซ้ำ ผลลัพธ์เดิม: " + p.Result
+	case p.Running:
+		log.Printf("Ignoring concurrent %s for user %s (token %s)", name, userId, provided)
+		return false, "", "รายการนี้กำลังดำเนินการอยู่ ห้ามเรียกซ้ำ รอผลลัพธ์จากการเรียกครั้งแรก"
+	}
+	// claimed under the lock, so two calls with the same token can't both run
+	p.Running = true
+	return true, provided, ""
+}
+
+// completeToolConfirmation marks a token as used so replays return the original result.
+func completeToolConfirmation(token, result string) {
+	toolConfirmationLock.Lock()
+	if p, ok := toolConfirmations[token]; ok {
+		p.Running = false
+		p.Committed = true
+		p.Result = result
+	}
+	toolConfirmationLock.Unlock()
+}
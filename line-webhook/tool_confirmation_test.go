@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGateToolConfirmationClaimsToken(t *testing.T) {
+	const userId, tool = "test-confirm", "cancel_booking"
+	proceed, _, message := gateToolConfirmation(userId, tool, json.RawMessage(`{"booking_id":"bk_1"}`))
+	if proceed {
+		t.Fatal("first call without a token proceeded")
+	}
+	var token string
+	toolConfirmationLock.Lock()
+	for tok, p := range toolConfirmations {
+		if p.UserID == userId {
+			token = tok
+		}
+	}
+	toolConfirmationLock.Unlock()
+	if token == "" {
+		t.Fatalf("no token issued; message %q", message)
+	}
+	confirmed := json.RawMessage(`{"booking_id":"bk_1","confirmation_token":"` + token + `"}`)
+
+	proceed, claimed, _ := gateToolConfirmation(userId, tool, confirmed)
+	if !proceed || claimed != token {
+		t.Fatalf("confirmed call = %v, %q, want to proceed with %q", proceed, claimed, token)
+	}
+	if proceed, _, message := gateToolConfirmation(userId, tool, confirmed); proceed {
+		t.Fatal("a second call with the same token proceeded while the first was running")
+	} else if message == "" {
+		t.Error("a second call got no message for the model")
+	}
+
+	completeToolConfirmation(token, "ยกเลิกแล้ว")
+	proceed, _, message = gateToolConfirmation(userId, tool, confirmed)
+	if proceed {
+		t.Fatal("a replay after completion proceeded")
+	}
+	if want := "ผลลัพธ์เดิม: ยกเลิกแล้ว"; !strings.Contains(message, want) {
+		t.Errorf("replay message = %q, want it to contain %q", message, want)
+	}
+}
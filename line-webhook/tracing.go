@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A customer turn is traced as spans, so a slow answer can be pinned on OpenAI, a tool (the
+// Apps Script calendar is behind get_available_slots_with_months) or LINE:
+//
+//	line.webhook → buffer.flush → assistant.turn → llm.respond / tool <name> → line.reply
+//
+// The webhook and the turn are linked through the job's trace_parent (W3C traceparent), which
+// survives the turn queue and restarts. Spans carry user_id, request_id and run_id like the
+// logs, and no message text. They are exported in batches with OTLP/HTTP (JSON encoding) to
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or OTEL_EXPORTER_OTLP_ENDPOINT plus /v1/traces, with
+// OTEL_EXPORTER_OTLP_HEADERS (k=v,k2=v2) and OTEL_SERVICE_NAME. Without an endpoint, tracing is
+// off and costs nothing.
+
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+type spanAttr struct {
+	Key   string
+	Value interface{} // string, int, int64, bool or float64
+}
+
+// Span is one timed operation. A nil *Span (tracing off) ignores every call.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	errMsg   string
+}
+
+type spanKey struct{}
+
+// tracer is nil when no OTLP endpoint is configured.
+var tracer *spanExporter
+
+func tracingEndpoint() string {
+	if url := appConfig.OTLPTracesEndpoint; url != "" {
+		return url
+	}
+	if base := appConfig.OTLPEndpoint; base != "" {
+		return strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// startTracing starts the exporter when an endpoint is configured.
+func startTracing() {
+	endpoint := tracingEndpoint()
+	if endpoint == "" {
+		return
+	}
+	tracer = &spanExporter{
+		endpoint: endpoint,
+		service:  appConfig.OTelServiceName,
+		headers:  appConfig.OTLPHeaders,
+		spans:    make(chan *Span, 2048),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	go tracer.run()
+	log.Printf("Exporting traces to %s as %s", endpoint, tracer.service)
+}
+
+// startSpan starts a span as a child of the span in ctx, if any, and returns ctx with it.
+// attrs are key, value pairs.
+func startSpan(ctx context.Context, name string, kind int, attrs ...interface{}) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.SetAttrs(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// withTraceParent makes a W3C traceparent the parent of spans started from ctx.
+func withTraceParent(ctx context.Context, traceParent string) context.Context {
+	parts := strings.Split(traceParent, "-")
+	if tracer == nil || len(parts) != 4 {
+		return ctx
+	}
+	var remote Span
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || len(traceID) != 16 || len(spanID) != 8 {
+		return ctx
+	}
+	copy(remote.traceID[:], traceID)
+	copy(remote.spanID[:], spanID)
+	return context.WithValue(ctx, spanKey{}, &remote)
+}
+
+// TraceParent returns the span's W3C traceparent, "" for a nil span.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// SetAttrs adds key, value pairs.
+func (s *Span) SetAttrs(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if key, ok := attrs[i].(string); ok {
+			s.attrs = append(s.attrs, spanAttr{Key: key, Value: attrs[i+1]})
+		}
+	}
+}
+
+// SetError marks the span failed; nil is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.errMsg = err.Error()
+	s.attrs = append(s.attrs, spanAttr{Key: "error.kind", Value: errorKind(err)})
+}
+
+// End finishes the span, marking it failed when err is set, and queues it for export.
+func (s *Span) End(err error) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	s.end = time.Now()
+	s.SetError(err)
+	select {
+	case tracer.spans <- s:
+	default:
+		appMetrics.inc("tracing_spans_dropped")
+	}
+}
+
+type spanExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	spans    chan *Span
+	client   *http.Client
+}
+
+// run sends spans every 5 seconds, or as soon as 256 are waiting.
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) < 256 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+			appMetrics.add("tracing_spans_dropped", int64(len(batch)))
+		} else {
+			appMetrics.add("tracing_spans_exported", int64(len(batch)))
+		}
+		batch = nil
+	}
+}
+
+// export posts an OTLP ExportTraceServiceRequest in its JSON encoding.
+func (e *spanExporter) export(batch []*Span) error {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.errMsg}
+		}
+		spans = append(spans, span)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes([]spanAttr{{Key: "service.name", Value: e.service}})},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "ncs-chatbot/line-webhook"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return classifyRequestError("otlp", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &UpstreamError{Service: "otlp", StatusCode: resp.StatusCode, Err: fmt.Errorf("HTTP %d", resp.StatusCode)}
+	}
+	return nil
+}
+
+func otlpAttributes(attrs []spanAttr) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch v := a.Value.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]interface{}{"key": a.Key, "value": value})
+	}
+	return out
+}
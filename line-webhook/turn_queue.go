@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Flushing a customer's buffer doesn't call the assistant directly: it enqueues a turn job,
+// which is saved to turn_queue.json, and a pool of TURN_WORKERS workers runs the jobs. A job
+// is removed only once its turn has replied, so turns that were running or waiting when the
+// process stopped are run again on the next start (their reply tokens have expired by then,
+// so the answer is pushed). A job queued behind an in-flight run is kept as well until the
+// flush that picks its messages back up has been saved. A job that was already started turnMaxAttempts times is dropped,
+// so a turn that crashes the process can't keep crashing it.
+
+// TurnJob is one assistant turn waiting for or being run by a worker.
+type TurnJob struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	ReplyToken  string    `json:"reply_token,omitempty"`
+	Messages    []string  `json:"messages"`
+	RequestID   string    `json:"request_id,omitempty"`
+	TraceParent string    `json:"trace_parent,omitempty"` // W3C traceparent of the buffer flush (tracing.go)
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	Attempts    int       `json:"attempts"`            // process starts that picked the job up
+	HeldBack    int       `json:"held_back,omitempty"` // messages left in the buffer for the next turn
+
+	held     bool       // queued behind an in-flight run; its messages are back in the buffer
+	replaces []*TurnJob // held jobs whose messages this job took from the buffer
+}
+
+const turnMaxAttempts = 3
+
+var turnQueueFile = "turn_queue.json"
+
+var (
+	turnQueueLock sync.Mutex
+	turnJobs      []*TurnJob // pending and running jobs, oldest first
+	turnJobCh     = make(chan *TurnJob, 4096)
+)
+
+// userHeldTurnJobs are jobs whose messages went back to the buffer behind an in-flight run
+// (inflight_runs.go). They stay in the queue file until the next flush of that buffer has
+// been enqueued, so a restart in between still replays the messages.
+var userHeldTurnJobs = make(map[string][]*TurnJob) // guarded by userThreadLock
+
+func turnWorkers() int {
+	return appConfig.TurnWorkers
+}
+
+// enqueueTurn saves the job and hands it to a worker. The held jobs it replaces leave the
+// queue file in the same save.
+func enqueueTurn(job *TurnJob) {
+	turnQueueLock.Lock()
+	turnJobs = append(withoutTurnJobs(turnJobs, job.replaces), job)
+	depth := len(turnJobs)
+	turnQueueLock.Unlock()
+	saveTurnQueue()
+	appMetrics.inc("turn_jobs_enqueued")
+	appMetrics.setGauge("turn_queue_depth", float64(depth))
+	turnJobCh <- job
+}
+
+// completeTurn removes finished jobs from the queue file.
+func completeTurn(jobs ...*TurnJob) {
+	turnQueueLock.Lock()
+	turnJobs = withoutTurnJobs(turnJobs, jobs)
+	depth := len(turnJobs)
+	turnQueueLock.Unlock()
+	saveTurnQueue()
+	appMetrics.setGauge("turn_queue_depth", float64(depth))
+}
+
+func withoutTurnJobs(jobs, drop []*TurnJob) []*TurnJob {
+	if len(drop) == 0 {
+		return jobs
+	}
+	kept := jobs[:0]
+	for _, j := range jobs {
+		if !slices.Contains(drop, j) {
+			kept = append(kept, j)
+		}
+	}
+	return kept
+}
+
+// startTurnWorkers starts the worker pool and replays the jobs left by the previous process.
+func startTurnWorkers() {
+	for i := 0; i < turnWorkers(); i++ {
+		go func() {
+			for job := range turnJobCh {
+				runTurnJob(job)
+			}
+		}()
+	}
+
+	turnQueueLock.Lock()
+	var replay []*TurnJob
+	kept := turnJobs[:0]
+	for _, job := range turnJobs {
+		job.Attempts++
+		if job.Attempts > turnMaxAttempts {
+			log.Printf("Dropping turn job %s for user %s after %d attempts", job.ID, job.UserID, turnMaxAttempts)
+			appMetrics.inc("turn_jobs_dropped")
+			continue
+		}
+		job.ReplyToken = "" // long expired
+		kept = append(kept, job)
+		replay = append(replay, job)
+	}
+	turnJobs = kept
+	turnQueueLock.Unlock()
+	if len(replay) == 0 {
+		return
+	}
+	saveTurnQueue()
+	log.Printf("Replaying %d turn job(s) left by the previous run", len(replay))
+	appMetrics.add("turn_jobs_replayed", int64(len(replay)))
+	go func() {
+		for _, job := range replay {
+			turnJobCh <- job
+		}
+	}()
+}
+
+// runTurnJob runs one job; a panic is logged and the job left in the file for the next start.
+func runTurnJob(job *TurnJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Turn job %s for user %s panicked: %v", job.ID, job.UserID, r)
+			appMetrics.inc("turn_jobs_failed")
+			return
+		}
+		if !job.held {
+			completeTurn(job)
+		}
+	}()
+	appMetrics.observe("turn_queue_wait", time.Since(job.EnqueuedAt))
+	runTurn(job)
+}
+
+func saveTurnQueue() {
+	// held through the write so concurrent saves don't share the temp file
+	turnQueueLock.Lock()
+	defer turnQueueLock.Unlock()
+	data, err := json.Marshal(turnJobs)
+	if err != nil {
+		log.Printf("Failed to marshal turn queue: %v", err)
+		return
+	}
+	tmpPath := turnQueueFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("Failed to save turn queue: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, turnQueueFile); err != nil {
+		log.Printf("Failed to replace turn queue: %v", err)
+	}
+}
+
+func loadTurnQueue() {
+	data, err := os.ReadFile(turnQueueFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read turn queue file: %v", err)
+		}
+		return
+	}
+	turnQueueLock.Lock()
+	defer turnQueueLock.Unlock()
+	if err := json.Unmarshal(data, &turnJobs); err != nil {
+		log.Printf("Failed to parse turn queue file: %v", err)
+	}
+}
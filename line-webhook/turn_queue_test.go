@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestQueuedTurnJobKeptUntilReflushed queues a turn behind an in-flight run and checks the job
+// stays in the queue file until the buffer flush that picks its messages back up.
+func TestQueuedTurnJobKeptUntilReflushed(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	cfg := *appConfig
+	appConfig = &cfg
+	appConfig.InflightPolicy = "queue"
+	savedFile := turnQueueFile
+	defer func() { turnQueueFile = savedFile }()
+	turnQueueFile = filepath.Join(t.TempDir(), "turn_queue.json")
+
+	userId := "U-turn-queue-test"
+	userThreadLock.Lock()
+	active := &inflightRun{cancel: func() {}}
+	userInflightRuns[userId] = active
+	userThreadLock.Unlock()
+	defer func() {
+		userThreadLock.Lock()
+		delete(userInflightRuns, userId)
+		delete(userMsgBuffer, userId)
+		delete(userHeldTurnJobs, userId)
+		userThreadLock.Unlock()
+	}()
+
+	job := &TurnJob{ID: "held", UserID: userId, Messages: []string{"ซักโซฟา"}}
+	turnQueueLock.Lock()
+	turnJobs = append(turnJobs, job)
+	turnQueueLock.Unlock()
+	if _, _, _, queued := beginInflightRun(job, job.Messages); !queued {
+		t.Fatal("beginInflightRun() did not queue behind the active run")
+	}
+	if !job.held {
+		t.Fatal("queued job is not held")
+	}
+
+	turnQueueLock.Lock()
+	kept := slices.Contains(turnJobs, job)
+	turnQueueLock.Unlock()
+	if !kept {
+		t.Fatal("queued job left the turn queue before its messages were flushed again")
+	}
+
+	userThreadLock.Lock()
+	held := userHeldTurnJobs[userId]
+	delete(userHeldTurnJobs, userId)
+	msgs := userMsgBuffer[userId]
+	delete(userMsgBuffer, userId)
+	userThreadLock.Unlock()
+	if len(msgs) != 1 || msgs[0] != "ซักโซฟา" {
+		t.Fatalf("buffer = %q, want the queued message back", msgs)
+	}
+	followUp := &TurnJob{ID: "follow-up", UserID: userId, Messages: msgs, replaces: held}
+	enqueueTurn(followUp)
+	<-turnJobCh
+	defer completeTurn(followUp)
+	turnQueueLock.Lock()
+	hasHeld, hasFollowUp := slices.Contains(turnJobs, job), slices.Contains(turnJobs, followUp)
+	turnQueueLock.Unlock()
+	if hasHeld || !hasFollowUp {
+		t.Errorf("after the follow-up flush: held job kept %v, follow-up queued %v; want false, true", hasHeld, hasFollowUp)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// TurnStats annotates an AI reply with what it cost to produce. Path is how the turn was
+// answered: "assistant" (model run), "cache" (a repeated question, see answer_cache.go), "faq"
+// (curated answer, see faq_cache.go) or "fast_path" (keyword trigger, no model call).
+type TurnStats struct {
+	Path         string   `json:"path"`
+	Model        string   `json:"model,omitempty"`
+	Variant      string   `json:"variant,omitempty"`     // instructions experiment variant, if one is running
+	ModelCalls   int      `json:"model_calls,omitempty"` // Responses API requests, one per tool round
+	InputTokens  int      `json:"input_tokens,omitempty"`
+	OutputTokens int      `json:"output_tokens,omitempty"`
+	Tools        []string `json:"tools,omitempty"` // tool calls in the order they were made
+	LatencyMs    int64    `json:"latency_ms"`      // from flushing the buffer to sending the reply
+	Error        string   `json:"error,omitempty"` // error kind when the turn failed
+}
+
+func (s *TurnStats) totalTokens() int { return s.InputTokens + s.OutputTokens }
+
+type turnStatsKey struct{}
+
+// withTurnStats attaches stats to ctx for getAssistantResponse to fill in.
+func withTurnStats(ctx context.Context, stats *TurnStats) context.Context {
+	return context.WithValue(ctx, turnStatsKey{}, stats)
+}
+
+// turnStatsFrom returns the stats attached to ctx, or a throwaway value when there are none.
+func turnStatsFrom(ctx context.Context) *TurnStats {
+	if stats, ok := ctx.Value(turnStatsKey{}).(*TurnStats); ok {
+		return stats
+	}
+	return &TurnStats{}
+}
+
+// finishTurnStats stamps the latency and counts the turn in the metrics.
+func finishTurnStats(stats *TurnStats, started time.Time) {
+	stats.LatencyMs = time.Since(started).Milliseconds()
+	appMetrics.inc("turns_" + stats.Path)
+	if n := stats.totalTokens(); n > 0 {
+		appMetrics.add("assistant_tokens", int64(n))
+	}
+	if len(stats.Tools) > 0 {
+		appMetrics.add("assistant_tool_calls", int64(len(stats.Tools)))
+	}
+}
+
+// appendTurn records an AI reply together with its turn annotation.
+func (c *UserConversation) appendTurn(text string, stats *TurnStats) {
+	c.appendMessage("ai", text)
+	c.Messages[len(c.Messages)-1].Turn = stats
+}
+
+// TurnTotals sums the annotated turns of a conversation for the dashboard list.
+type TurnTotals struct {
+	Turns        int   `json:"turns"`
+	Tokens       int   `json:"tokens"`
+	ToolCalls    int   `json:"tool_calls"`
+	AvgLatencyMs int64 `json:"avg_latency_ms"`
+}
+
+func conversationTurnTotals(conv *UserConversation) TurnTotals {
+	var totals TurnTotals
+	var latency int64
+	for _, m := range conv.Messages {
+		if m.Turn == nil {
+			continue
+		}
+		totals.Turns++
+		totals.Tokens += m.Turn.totalTokens()
+		totals.ToolCalls += len(m.Turn.Tools)
+		latency += m.Turn.LatencyMs
+	}
+	if totals.Turns > 0 {
+		totals.AvgLatencyMs = latency / int64(totals.Turns)
+	}
+	return totals
+}
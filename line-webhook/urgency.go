@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+const (
+	urgencyUrgent     = "urgent"     // spill/accident or explicit rush request
+	urgencyFrustrated = "frustrated" // soft profanity; staff should look in
+)
+
+// urgentPhrases signal a time-critical job. Matched against normalized text.
+var urgentPhrases = []string{
+	"ด่วน", "เร่งด่วน", "ฉุกเฉิน", "วันนี้เลย", "พรุ่งนี้เลย", "ตอนนี้เลย",
+	"น้ำยาซึม", "น้ำซึม", "ซึมลง", "ทำหก", "หกใส่", "หกลง", "ฉี่ใส่", "ฉี่รด", "อาเจียนใส่", "อ้วกใส่",
+	"urgent", "asap", "emergency",
+}
+
+// urgentPhrasesLongestFirst lets "เร่งด่วน" claim its text before "ด่วน" does.
+var urgentPhrasesLongestFirst = func() []string {
+	sorted := append([]string(nil), urgentPhrases...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return sorted
+}()
+
+// Negations turn the urgent phrase right after them into its opposite. "ยังไม่" ends in "ไม่"
+// and is covered by it.
+var (
+	thaiUrgencyNegations    = []string{"ไม่", "ไม่ได้", "ไม่ได้เป็น", "ไม่ต้อง", "ไม่ค่อย", "ไม่ใช่", "ไม่ใช่งาน", "ไม่ถึงกับ"}
+	englishUrgencyNegations = map[string]bool{"not": true, "no": true, "non": true, "isn't": true, "nothing": true}
+)
+
+// unnegatedPhraseIn reports whether text has one of phrases, longest first, that isn't
+// negated. A phrase's text is claimed by the first phrase matching it, so the ด่วน inside a
+// negated "ไม่เร่งด่วน" isn't counted on its own.
+func unnegatedPhraseIn(text string, phrases []string) bool {
+	claimed := make([]bool, len(text))
+	for _, p := range phrases {
+		for start := 0; ; {
+			i := strings.Index(text[start:], p)
+			if i < 0 {
+				break
+			}
+			i += start
+			start = i + len(p)
+			if claimed[i] {
+				continue
+			}
+			for j := i; j < start; j++ {
+				claimed[j] = true
+			}
+			if !urgencyNegated(text[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// urgencyNegated reports whether the text before a phrase ends in a negation.
+func urgencyNegated(before string) bool {
+	before = strings.TrimRight(before, " ")
+	for _, n := range thaiUrgencyNegations {
+		if strings.HasSuffix(before, n) {
+			return true
+		}
+	}
+	words := strings.Fields(before)
+	switch {
+	case len(words) >= 1 && englishUrgencyNegations[words[len(words)-1]]:
+		return true
+	case len(words) >= 2 && words[len(words)-2] == "not": // "not so urgent", "not really urgent"
+		return true
+	}
+	return false
+}
+
+// frustrationPhrases are mild profanity that usually means the customer is upset with the bot.
+var frustrationPhrases = []string{"แม่ง", "เชี่ย", "ห่วย", "โง่", "ควาย", "wtf", "stupid"}
+
+// urgentFollowUp is how long a conversation stays on the priority flow.
+const urgentFollowUp = 48 * time.Hour
+
+// urgentSurcharge is the rush-job fee in baht (URGENT_SURCHARGE, default 500).
+func urgentSurcharge() int {
+	return appConfig.UrgentSurcharge
+}
+
+// classifyUrgency returns urgencyUrgent, urgencyFrustrated or "" for a normalized message.
+// Negated urgency ("ไม่ด่วน", "ยังไม่ฉุกเฉิน", "not urgent") doesn't count.
+func classifyUrgency(msg string) string {
+	lower := strings.ToLower(msg)
+	if unnegatedPhraseIn(lower, urgentPhrasesLongestFirst) {
+		return urgencyUrgent
+	}
+	for _, p := range frustrationPhrases {
+		if strings.Contains(lower, p) {
+			return urgencyFrustrated
+		}
+	}
+	return ""
+}
+
+// routeUrgency moves a conversation onto the priority flow and alerts staff (at most hourly).
+// Caller must hold userThreadLock.
+func routeUrgency(conv *UserConversation, kind, message string) {
+	if kind == "" {
+		return
+	}
+	if kind == urgencyUrgent {
+		if !isUrgentLocked(conv) {
+			conv.UrgentSince = time.Now()
+		}
+	}
+	appMetrics.inc("urgency_" + kind)
+	if time.Since(conv.UrgencyAlertAt) < time.Hour {
+		return
+	}
+	conv.UrgencyAlertAt = time.Now()
+	log.Printf("Routing user %s to priority flow (%s)", conv.UserID, kind)
+	go sendUrgencyAlert(conv.UserID, conv.DisplayName, kind, message)
+}
+
+// isUrgentLocked reports whether the conversation is on the priority flow. Caller must hold userThreadLock.
+func isUrgentLocked(conv *UserConversation) bool {
+	return !conv.UrgentSince.IsZero() && time.Since(conv.UrgentSince) < urgentFollowUp
+}
+
+func sendUrgencyAlert(userId, name, kind, message string) {
+	if name == "" {
+		name = "…" + userId[max(0, len(userId)-8):]
+	}
+	title := "⚡ งานด่วน"
+	if kind == urgencyFrustrated {
+		title = "😠 ลูกค้าไม่พอใจ"
+	}
+	alert := fmt.Sprintf("%s: ลูกค้า %s\n\"%s\"", title, name, message)
+	alertStaff(userId, alert, "urgency")
+}
+
+// urgentTurnNote is added to the model input while a conversation is on the priority flow.
+func urgentTurnNote() string {
+	return fmt.Sprintf("[ระบบ] ลูกค้ารายนี้เป็นงานด่วน: ตรวจคิวว่างของเดือนนี้ด้วย get_available_slots_with_months ทันทีและเสนอคิวที่เร็วที่สุด "+
+		"แจ้งค่าบริการด่วนเพิ่ม %s บาทอย่างชัดเจนในใบเสนอราคา และแนะนำการปฐมพยาบาลคราบเบื้องต้น (ซับ ไม่ถู)", pricing.FormatNumber(urgentSurcharge()))
+}
+
+// urgentSurchargeLine is appended to price quotes for conversations on the priority flow.
+func urgentSurchargeLine() string {
+	return fmt.Sprintf("\n⚡ ค่าบริการด่วน (เข้าบริการภายใน 24-48 ชม.): +%s บาท", pricing.FormatNumber(urgentSurcharge()))
+}
+
+// isUrgentConversation reports whether the user is currently on the priority flow.
+func isUrgentConversation(userId string) bool {
+	userThreadLock.Lock()
+	defer userThreadLock.Unlock()
+	conv, ok := userConversations[userId]
+	return ok && isUrgentLocked(conv)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestClassifyUrgency(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"ด่วนค่ะ น้ำยาซึมลงที่นอน", urgencyUrgent},
+		{"ขอคิวด่วนได้ไหมคะ", urgencyUrgent},
+		{"เร่งด่วนมากค่ะ", urgencyUrgent},
+		{"ลูกฉี่ใส่โซฟา", urgencyUrgent},
+		{"need it cleaned asap", urgencyUrgent},
+		{"it's urgent", urgencyUrgent},
+
+		// negated
+		{"ไม่ด่วนค่ะ", ""},
+		{"ไม่ ด่วน นะคะ", ""},
+		{"ยังไม่ด่วนค่ะ สะดวกเดือนหน้า", ""},
+		{"ไม่เร่งด่วนค่ะ", ""},
+		{"ไม่ได้ด่วนอะไร", ""},
+		{"ไม่ต้องด่วนก็ได้ค่ะ", ""},
+		{"ไม่ค่อยด่วน", ""},
+		{"ไม่ใช่งานด่วนค่ะ", ""},
+		{"ไม่ฉุกเฉินค่ะ", ""},
+		{"not urgent, next month is fine", ""},
+		{"no rush, not so urgent", ""},
+
+		// a negated phrase doesn't hide an urgent one
+		{"ไม่ด่วนมาก แต่น้ำซึมลงที่นอนค่ะ", urgencyUrgent},
+		{"not urgent but it's an emergency now", urgencyUrgent},
+
+		{"โซฟาห่วยมาก", urgencyFrustrated},
+		{"ซักโซฟาราคาเท่าไหร่", ""},
+		{"ที่นอนหกฟุต", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := classifyUrgency(tt.msg); got != tt.want {
+				t.Errorf("classifyUrgency(%q) = %q, want %q", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
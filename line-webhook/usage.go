@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every model call adds its tokens and estimated cost to the customer's totals for the day
+// (Bangkok time) in usage.json. That covers assistant turns and the one-off calls made for a
+// customer (handoff briefs, slips, documents). Cost uses USD prices per million tokens:
+// defaults for common models, overridden by MODEL_PRICES, e.g.
+// "gpt-4.1-mini=0.40/1.60,gpt-4.1=2/8" (input/output). Days older than usageKeepDays are dropped.
+
+// UsageTotals are the tokens and cost of a set of model calls.
+type UsageTotals struct {
+	ModelCalls   int     `json:"model_calls"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+func (t *UsageTotals) addTotals(o UsageTotals) {
+	t.ModelCalls += o.ModelCalls
+	t.InputTokens += o.InputTokens
+	t.OutputTokens += o.OutputTokens
+	t.CostUSD += o.CostUSD
+}
+
+const usageKeepDays = 400
+
+var usageFile = "usage.json"
+
+var (
+	usageLock sync.Mutex
+	usageDays = make(map[string]map[string]*UsageTotals) // day -> user ID -> totals
+)
+
+// defaultModelPrices are USD per million input and output tokens.
+var defaultModelPrices = map[string][2]float64{
+	"gpt-4.1":      {2.00, 8.00},
+	"gpt-4.1-mini": {0.40, 1.60},
+	"gpt-4.1-nano": {0.10, 0.40},
+	"gpt-4o":       {2.50, 10.00},
+	"gpt-4o-mini":  {0.15, 0.60},
+
+	"text-embedding-3-small": {0.02, 0},
+	"text-embedding-3-large": {0.13, 0},
+}
+
+// modelPrice returns the input and output price of model per million tokens. Dated snapshots
+// such as gpt-4.1-mini-2025-04-14 use the price of their base model.
+func modelPrice(model string) ([2]float64, bool) {
+	prices := make(map[string][2]float64, len(defaultModelPrices))
+	for m, p := range defaultModelPrices {
+		prices[m] = p
+	}
+	for m, p := range appConfig.ModelPrices {
+		prices[m] = p
+	}
+	best := ""
+	for m := range prices {
+		if (model == m || strings.HasPrefix(model, m+"-")) && len(m) > len(best) {
+			best = m
+		}
+	}
+	if best == "" {
+		return [2]float64{}, false
+	}
+	return prices[best], true
+}
+
+type usageUserKey struct{}
+
+// withUsageUser attributes the model calls made with ctx to userId.
+func withUsageUser(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, usageUserKey{}, userId)
+}
+
+func usageUserFrom(ctx context.Context) string {
+	userId, _ := ctx.Value(usageUserKey{}).(string)
+	return userId
+}
+
+// recordUsage adds one model call to the user's totals for today. Calls not made for a
+// customer are recorded under "-".
+func recordUsage(userId, model string, inputTokens, outputTokens int) {
+	if userId == "" {
+		userId = "-"
+	}
+	price, ok := modelPrice(model)
+	if !ok {
+		appMetrics.inc("usage_unpriced_calls")
+	}
+	cost := (float64(inputTokens)*price[0] + float64(outputTokens)*price[1]) / 1e6
+
+	day := bangkokNow().Format("2006-01-02")
+	usageLock.Lock()
+	users, ok := usageDays[day]
+	if !ok {
+		users = make(map[string]*UsageTotals)
+		usageDays[day] = users
+	}
+	totals, ok := users[userId]
+	if !ok {
+		totals = &UsageTotals{}
+		users[userId] = totals
+	}
+	totals.addTotals(UsageTotals{ModelCalls: 1, InputTokens: inputTokens, OutputTokens: outputTokens, CostUSD: cost})
+	usageLock.Unlock()
+	appMetrics.add("usage_cost_microdollars", int64(cost*1e6))
+	go saveUsage()
+}
+
+// UserUsage is one customer's totals over a range of days.
+type UserUsage struct {
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	UsageTotals
+}
+
+// DayUsage is the totals of one day.
+type DayUsage struct {
+	Day   string `json:"day"`
+	Users int    `json:"users"`
+	UsageTotals
+}
+
+// usageBetween sums the days from..to (inclusive, YYYY-MM-DD), optionally for one user.
+func usageBetween(from, to, userId string) (UsageTotals, []DayUsage, []UserUsage) {
+	var total UsageTotals
+	days := []DayUsage{}
+	byUser := make(map[string]*UserUsage)
+	usageLock.Lock()
+	for day, users := range usageDays {
+		if day < from || day > to {
+			continue
+		}
+		d := DayUsage{Day: day}
+		for uid, t := range users {
+			if userId != "" && uid != userId {
+				continue
+			}
+			d.Users++
+			d.addTotals(*t)
+			u, ok := byUser[uid]
+			if !ok {
+				u = &UserUsage{UserID: uid}
+				byUser[uid] = u
+			}
+			u.addTotals(*t)
+		}
+		if d.Users > 0 {
+			days = append(days, d)
+			total.addTotals(d.UsageTotals)
+		}
+	}
+	usageLock.Unlock()
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+	users := make([]UserUsage, 0, len(byUser))
+	userThreadLock.Lock()
+	for _, u := range byUser {
+		if conv, ok := userConversations[u.UserID]; ok {
+			u.DisplayName = conv.DisplayName
+		}
+		users = append(users, *u)
+	}
+	userThreadLock.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].CostUSD > users[j].CostUSD })
+	return total, days, users
+}
+
+// handleGetUsage returns token usage and cost per day and per customer, most expensive
+// customers first. ?from and ?to (YYYY-MM-DD) default to the last 30 days, ?user_id narrows
+// it to one customer and ?limit caps the customer list (default 50).
+func handleGetUsage(c *fiber.Ctx) error {
+	today := bangkokNow()
+	from := c.Query("from", today.AddDate(0, 0, -29).Format("2006-01-02"))
+	to := c.Query("to", today.Format("2006-01-02"))
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return respondError(c, fiber.StatusBadRequest, "from and to must be YYYY-MM-DD")
+		}
+	}
+	limit := c.QueryInt("limit", 50)
+	total, days, users := usageBetween(from, to, c.Query("user_id"))
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return c.JSON(fiber.Map{"from": from, "to": to, "totals": total, "days": days, "users": users})
+}
+
+// startUsageReportJob logs the previous day's usage shortly after midnight and drops old days.
+func startUsageReportJob() {
+	go func() {
+		for {
+			now := bangkokNow()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 0, 5, 0, 0, now.Location()).AddDate(0, 0, 1)
+			time.Sleep(next.Sub(now))
+
+			day := next.AddDate(0, 0, -1).Format("2006-01-02")
+			log.Print(usageReportLine(day))
+			pruneUsage(next.AddDate(0, 0, -usageKeepDays).Format("2006-01-02"))
+		}
+	}()
+}
+
+func usageReportLine(day string) string {
+	total, _, users := usageBetween(day, day, "")
+	line := fmt.Sprintf("Usage for %s: %d model calls, %d input + %d output tokens, $%.2f across %d users",
+		day, total.ModelCalls, total.InputTokens, total.OutputTokens, total.CostUSD, len(users))
+	if len(users) > 0 {
+		line += fmt.Sprintf("; most expensive %s ($%.2f)", users[0].UserID, users[0].CostUSD)
+	}
+	return line
+}
+
+func pruneUsage(before string) {
+	usageLock.Lock()
+	removed := 0
+	for day := range usageDays {
+		if day < before {
+			delete(usageDays, day)
+			removed++
+		}
+	}
+	usageLock.Unlock()
+	if removed > 0 {
+		saveUsage()
+	}
+}
+
+func saveUsage() {
+	// held through the write so concurrent saves don't share the temp file
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	data, err := json.Marshal(usageDays)
+	if err != nil {
+		log.Printf("Failed to marshal usage: %v", err)
+		return
+	}
+	tmpPath := usageFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("Failed to save usage: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, usageFile); err != nil {
+		log.Printf("Failed to replace usage file: %v", err)
+	}
+}
+
+func loadUsage() {
+	data, err := os.ReadFile(usageFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read usage file: %v", err)
+		}
+		return
+	}
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	if err := json.Unmarshal(data, &usageDays); err != nil {
+		log.Printf("Failed to parse usage file: %v", err)
+	}
+}
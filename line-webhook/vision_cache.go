@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Answers to photo turns are cached by the photos' content hash. The same photos sent again
+// with the same question get the stored answer without another vision call, and text
+// follow-ups about the customer's latest photo ("แล้วถ้าซักอย่างเดียวล่ะ") get the stored
+// answer as context instead of the photo being sent to the model again.
+
+// visionAnswer is the assistant's answer to a turn with photos.
+type visionAnswer struct {
+	Answer string
+	At     time.Time
+}
+
+const visionCacheTTL = 24 * time.Hour
+
+// maxPhotoContextRunes keeps the follow-up note short; the first part of an answer about a
+// photo is the analysis, the rest is usually pricing and booking.
+const maxPhotoContextRunes = 600
+
+var (
+	visionCacheLock sync.Mutex
+	visionByTurn    = make(map[string]visionAnswer) // photo hashes + question -> answer
+	visionByPhoto   = make(map[string]visionAnswer) // photo hash -> first answer about it
+)
+
+// imageHash identifies a photo by its decoded content, so the same picture matches however
+// LINE delivered it.
+func imageHash(dataURL string) string {
+	data, err := decodeDataURL(dataURL)
+	if err != nil {
+		data = []byte(dataURL)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// visionTurnKey returns the hashes of the photos in a turn and the cache key made of them and
+// the text sent with them. ok is false for turns without photos.
+func visionTurnKey(message string) (key string, hashes []string, ok bool) {
+	locs := imageDataURLPattern.FindAllStringIndex(message, -1)
+	if len(locs) == 0 {
+		return "", nil, false
+	}
+	var text strings.Builder
+	prev := 0
+	for _, loc := range locs {
+		text.WriteString(message[prev:loc[0]])
+		hashes = append(hashes, imageHash(message[loc[0]:loc[1]]))
+		prev = loc[1]
+	}
+	text.WriteString(message[prev:])
+	question := strings.Join(strings.Fields(normalizeInboundText(strings.ReplaceAll(text.String(), "ลูกค้าส่งรูปภาพ:", ""))), " ")
+	return strings.Join(hashes, ",") + "|" + question, hashes, true
+}
+
+// cachedVisionAnswer returns the stored answer for the same photos and question.
+func cachedVisionAnswer(message string) (string, bool) {
+	key, _, ok := visionTurnKey(message)
+	if !ok {
+		return "", false
+	}
+	visionCacheLock.Lock()
+	defer visionCacheLock.Unlock()
+	v, ok := visionByTurn[key]
+	if !ok || time.Since(v.At) > visionCacheTTL {
+		return "", false
+	}
+	return v.Answer, true
+}
+
+// storeVisionAnswer caches the answer to a turn with photos and drops expired entries.
+func storeVisionAnswer(message, answer string) {
+	key, hashes, ok := visionTurnKey(message)
+	if !ok || answer == "" {
+		return
+	}
+	now := time.Now()
+	visionCacheLock.Lock()
+	defer visionCacheLock.Unlock()
+	for k, v := range visionByTurn {
+		if now.Sub(v.At) > visionCacheTTL {
+			delete(visionByTurn, k)
+		}
+	}
+	for k, v := range visionByPhoto {
+		if now.Sub(v.At) > visionCacheTTL {
+			delete(visionByPhoto, k)
+		}
+	}
+	visionByTurn[key] = visionAnswer{Answer: answer, At: now}
+	for _, h := range hashes {
+		if _, seen := visionByPhoto[h]; !seen {
+			visionByPhoto[h] = visionAnswer{Answer: answer, At: now}
+		}
+	}
+}
+
+// photoContextNote is a developer note with the stored answer about the customer's latest
+// photo, for turns that don't include it.
+func photoContextNote(userId string) (string, bool) {
+	userThreadLock.Lock()
+	img, ok := userLastImage[userId]
+	userThreadLock.Unlock()
+	if !ok || time.Since(img.ReceivedAt) > customerImageTTL {
+		return "", false
+	}
+	visionCacheLock.Lock()
+	v, ok := visionByPhoto[img.Hash]
+	visionCacheLock.Unlock()
+	if !ok {
+		return "", false
+	}
+	return "[ระบบ] รูปภาพล่าสุดของลูกค้าวิเคราะห์แล้ว ใช้ผลนี้ตอบคำถามต่อเนื่องเกี่ยวกับรูปได้เลย ไม่ต้องขอให้ส่งรูปใหม่:\n" +
+		truncateRunes(v.Answer, maxPhotoContextRunes), true
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"ncs-chatbot/line-webhook/pricing"
+)
+
+// GiftVoucher is a prepaid code a customer buys for someone else.
+type GiftVoucher struct {
+	Code            string    `json:"code"`
+	Kind            string    `json:"kind"`   // "amount" or "package"
+	Amount          int       `json:"amount"` // value in baht (package vouchers: the package price at purchase)
+	Description     string    `json:"description"`
+	PurchaserID     string    `json:"purchaser_id"`
+	RecipientName   string    `json:"recipient_name,omitempty"`
+	Message         string    `json:"message,omitempty"`
+	Status          string    `json:"status"` // "pending_payment", "active", "redeemed" or "cancelled"
+	PaymentID       string    `json:"payment_id"`
+	CreatedAt       time.Time `json:"created_at"`
+	ActivatedAt     time.Time `json:"activated_at,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at,omitempty"`
+	RedeemedBy      string    `json:"redeemed_by,omitempty"`
+	RedeemedBooking string    `json:"redeemed_booking,omitempty"`
+	RedeemedAt      time.Time `json:"redeemed_at,omitempty"`
+}
+
+const giftVoucherValidity = 365 * 24 * time.Hour
+
+var giftVouchersFile = "gift_vouchers.json"
+
+var (
+	giftVoucherLock sync.Mutex
+	giftVouchers    = make(map[string]*GiftVoucher) // code -> voucher
+)
+
+func normalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// giftVoucherValue resolves what a voucher purchase is worth and how to describe it.
+func giftVoucherValue(kind string, amount int, packageType, serviceType string, quantity int) (int, string, error) {
+	switch kind {
+	case "amount":
+		if amount < 100 {
+			return 0, "", fmt.Errorf("amount must be at least 100 baht")
+		}
+		return amount, fmt.Sprintf("บัตรของขวัญมูลค่า %s บาท", pricing.FormatNumber(amount)), nil
+	case "package":
+		if pricingConfig == nil {
+			return 0, "", fmt.Errorf("pricing config not loaded")
+		}
+		engine := pricingEngine()
+		packageKey := engine.PackageKey(packageType)
+		serviceKey := engine.ServiceKey(serviceType)
+		price, ok := pricingConfig.PackagePriceFor(packageKey, serviceKey, quantity)
+		if !ok {
+			return 0, "", fmt.Errorf("no package price for '%s' / '%s' x%d", packageType, serviceType, quantity)
+		}
+		desc := fmt.Sprintf("บัตรของขวัญ %s %d ใบ บริการ%s", pricingConfig.Packages[packageKey].Name, quantity, pricingConfig.Services[serviceKey].Name)
+		return price.SalePrice, desc, nil
+	}
+	return 0, "", fmt.Errorf("kind must be 'amount' or 'package'")
+}
+
+func giftVoucherConfirmationSummary(args map[string]interface{}) string {
+	kind, _ := args["kind"].(string)
+	amount, _ := args["amount"].(float64)
+	packageType, _ := args["package_type"].(string)
+	serviceType, _ := args["service_type"].(string)
+	quantity, _ := args["quantity"].(float64)
+	recipient, _ := args["recipient_name"].(string)
+	value, desc, err := giftVoucherValue(kind, int(amount), packageType, serviceType, int(quantity))
+	if err != nil {
+		return "ซื้อบัตรของขวัญ (ข้อมูลไม่ครบ: " + err.Error() + ")"
+	}
+	summary := fmt.Sprintf("ซื้อ%s ราคา %s บาท", desc, pricing.FormatNumber(value))
+	if recipient != "" {
+		summary += " ให้คุณ" + recipient
+	}
+	return summary
+}
+
+// purchaseGiftVoucher handles the confirmed purchase_gift_voucher tool. The code becomes
+// usable once its payment is marked paid.
+func purchaseGiftVoucher(userId, kind string, amount int, packageType, serviceType string, quantity int, recipient, message string) (string, error) {
+	value, desc, err := giftVoucherValue(kind, amount, packageType, serviceType, quantity)
+	if err != nil {
+		return "ไม่สามารถสร้างบัตรของขวัญ: " + err.Error(), &ToolError{Tool: "purchase_gift_voucher", Err: err}
+	}
+	code := "GV" + newConfirmationToken()
+	payment := createPayment(userId, "voucher", code, value)
+	v := &GiftVoucher{
+		Code:          code,
+		Kind:          kind,
+		Amount:        value,
+		Description:   desc,
+		PurchaserID:   userId,
+		RecipientName: strings.TrimSpace(recipient),
+		Message:       strings.TrimSpace(message),
+		Status:        "pending_payment",
+		PaymentID:     payment.ID,
+		CreatedAt:     time.Now(),
+	}
+	giftVoucherLock.Lock()
+	giftVouchers[code] = v
+	giftVoucherLock.Unlock()
+	go saveGiftVouchers()
+	return fmt.Sprintf("สร้าง%sแล้ว ชำระเงินเพื่อรับรหัสบัตรของขวัญ (ระบบจะส่งข้อความสำหรับส่งต่อให้ผู้รับทันทีที่ยืนยันการชำระ):\n%s", desc, paymentInstructions(payment)), nil
+}
+
+// activateGiftVoucher is the paid handler for voucher payments: it activates the code and
+// sends the purchaser a message ready to forward to the recipient.
+func activateGiftVoucher(p Payment) {
+	giftVoucherLock.Lock()
+	v, ok := giftVouchers[p.Reference]
+	if !ok {
+		giftVoucherLock.Unlock()
+		log.Printf("Paid voucher payment %s references unknown code %s", p.ID, p.Reference)
+		return
+	}
+	v.Status = "active"
+	v.ActivatedAt = time.Now()
+	v.ExpiresAt = v.ActivatedAt.Add(giftVoucherValidity)
+	voucher := *v
+	giftVoucherLock.Unlock()
+	go saveGiftVouchers()
+	appMetrics.inc("gift_vouchers_activated")
+
+	var b strings.Builder
+	b.WriteString("🎁 บัตรของขวัญ NCS")
+	if voucher.RecipientName != "" {
+		fmt.Fprintf(&b, " สำหรับคุณ%s", voucher.RecipientName)
+	}
+	fmt.Fprintf(&b, "\n%s\nรหัส: %s\nใช้ได้ถึง %s", voucher.Description, voucher.Code, formatThaiDate(voucher.ExpiresAt.Format("2006-01-02")))
+	if voucher.Message != "" {
+		fmt.Fprintf(&b, "\n💌 \"%s\"", voucher.Message)
+	}
+	b.WriteString("\nใช้งาน: แอดไลน์ NCS แล้วพิมพ์รหัสนี้ตอนจองคิว")
+	intro := "ได้รับชำระเงินเรียบร้อยแล้วค่ะ 🙏 ส่งต่อข้อความด้านล่างให้ผู้รับได้เลยนะคะ"
+	for _, msg := range []string{intro, b.String()} {
+		if err := pushLineMessageWithPriority(voucher.PurchaserID, msg, pushTransactional, "gift_voucher"); err != nil {
+			log.Printf("Failed to deliver gift voucher %s: %v", voucher.Code, err)
+		}
+	}
+}
+
+// lookupGiftVoucher returns a usable voucher or a Thai explanation of why it can't be used.
+func lookupGiftVoucher(code string) (GiftVoucher, string) {
+	giftVoucherLock.Lock()
+	defer giftVoucherLock.Unlock()
+	v, ok := giftVouchers[normalizeVoucherCode(code)]
+	switch {
+	case !ok:
+		return GiftVoucher{}, "ไม่พบรหัสบัตรของขวัญนี้"
+	case v.Status == "pending_payment":
+		return GiftVoucher{}, "บัตรของขวัญนี้ยังไม่ได้ชำระเงิน"
+	case v.Status == "redeemed":
+		return GiftVoucher{}, "บัตรของขวัญนี้ถูกใช้ไปแล้ว"
+	case v.Status != "active":
+		return GiftVoucher{}, "บัตรของขวัญนี้ใช้งานไม่ได้"
+	case time.Now().After(v.ExpiresAt):
+		return GiftVoucher{}, "บัตรของขวัญนี้หมดอายุแล้ว"
+	}
+	return *v, ""
+}
+
+// giftVoucherQuoteLine is appended to price quotes that mention a voucher code.
+func giftVoucherQuoteLine(code string) string {
+	v, problem := lookupGiftVoucher(code)
+	if problem != "" {
+		return "\n🎁 " + problem
+	}
+	return fmt.Sprintf("\n🎁 หักบัตรของขวัญ %s (%s): -%s บาท จากยอดชำระเมื่อจองคิว", v.Code, v.Description, pricing.FormatNumber(v.Amount))
+}
+
+func redeemGiftVoucherSummary(args map[string]interface{}) string {
+	code, _ := args["code"].(string)
+	bookingID, _ := args["booking_id"].(string)
+	v, problem := lookupGiftVoucher(code)
+	if problem != "" {
+		return "ใช้บัตรของขวัญ " + code + " (" + problem + ")"
+	}
+	return fmt.Sprintf("ใช้บัตรของขวัญ %s มูลค่า %s บาท กับคิว %s", v.Code, pricing.FormatNumber(v.Amount), bookingID)
+}
+
+// redeemGiftVoucher handles the confirmed redeem_gift_voucher tool: it deducts the voucher
+// from one of the user's confirmed bookings.
+func redeemGiftVoucher(userId, code, bookingID string) (string, error) {
+	code = normalizeVoucherCode(code)
+	if _, problem := lookupGiftVoucher(code); problem != "" {
+		return problem, &ToolError{Tool: "redeem_gift_voucher", Err: fmt.Errorf("voucher %s unusable", code)}
+	}
+
+	bookingLock.Lock()
+	var booking *Booking
+	for _, b := range bookings {
+		if b.ID == bookingID && b.UserID == userId && b.Status == "confirmed" {
+			booking = b
+		}
+	}
+	if booking == nil {
+		bookingLock.Unlock()
+		return "ไม่พบคิวที่ยืนยันแล้วของลูกค้าตามเลขที่นี้ ใช้ get_my_booking เพื่อดูเลขคิว", &ToolError{Tool: "redeem_gift_voucher", Err: fmt.Errorf("booking %s not found", bookingID)}
+	}
+	if booking.VoucherCode != "" {
+		bookingLock.Unlock()
+		return "คิวนี้ใช้บัตรของขวัญไปแล้ว 1 ใบ", &ToolError{Tool: "redeem_gift_voucher", Err: fmt.Errorf("booking %s already has a voucher", bookingID)}
+	}
+
+	giftVoucherLock.Lock()
+	v := giftVouchers[code]
+	if v.Status != "active" { // re-check under both locks
+		giftVoucherLock.Unlock()
+		bookingLock.Unlock()
+		return "บัตรของขวัญนี้ใช้งานไม่ได้แล้ว", &ToolError{Tool: "redeem_gift_voucher", Err: fmt.Errorf("voucher %s no longer active", code)}
+	}
+	discount := v.Amount
+	if discount > booking.Total {
+		discount = booking.Total
+	}
+	v.Status = "redeemed"
+	v.RedeemedBy = userId
+	v.RedeemedBooking = booking.ID
+	v.RedeemedAt = time.Now()
+	booking.VoucherCode = code
+	booking.VoucherDiscount = discount
+	booking.Total -= discount
+	booking.UpdatedAt = time.Now()
+	remaining := booking.Total
+	giftVoucherLock.Unlock()
+	bookingLock.Unlock()
+
+	go saveGiftVouchers()
+	go saveBookings()
+	appMetrics.inc("gift_vouchers_redeemed")
+	return fmt.Sprintf("ใช้บัตรของขวัญ %s กับคิว %s แล้ว หักไป %s บาท ยอดคงเหลือ %s บาท",
+		code, bookingID, pricing.FormatNumber(discount), pricing.FormatNumber(remaining)), nil
+}
+
+func saveGiftVouchers() {
+	giftVoucherLock.Lock()
+	data, err := json.Marshal(giftVouchers)
+	giftVoucherLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to marshal gift vouchers: %v", err)
+		return
+	}
+	if err := os.WriteFile(giftVouchersFile, data, 0644); err != nil {
+		log.Printf("Failed to save gift vouchers: %v", err)
+	}
+}
+
+func loadGiftVouchers() {
+	data, err := os.ReadFile(giftVouchersFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read gift vouchers file: %v", err)
+		}
+		return
+	}
+	giftVoucherLock.Lock()
+	defer giftVoucherLock.Unlock()
+	if err := json.Unmarshal(data, &giftVouchers); err != nil {
+		log.Printf("Failed to parse gift vouchers file: %v", err)
+	}
+}
+
+func handleGetGiftVouchers(c *fiber.Ctx) error {
+	status := c.Query("status")
+	giftVoucherLock.Lock()
+	defer giftVoucherLock.Unlock()
+	result := make([]GiftVoucher, 0, len(giftVouchers))
+	for _, v := range giftVouchers {
+		if status == "" || v.Status == status {
+			result = append(result, *v)
+		}
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LINE redelivers a webhook when the first delivery timed out or failed, with the same
+// webhookEventId and deliveryContext.isRedelivery set. Event IDs seen in the last
+// webhookEventTTL are kept in webhook_events.json, so a redelivered event is skipped even
+// after a restart instead of starting a second assistant run and reply.
+
+const webhookEventTTL = 24 * time.Hour
+
+var webhookEventsFile = "webhook_events.json"
+
+var (
+	webhookEventLock sync.Mutex
+	webhookEvents    = make(map[string]time.Time) // event ID -> first seen
+)
+
+// markWebhookEvent records the event and reports whether it is new. Events without an ID
+// (older test payloads) are always new.
+func markWebhookEvent(id string) bool {
+	if id == "" {
+		return true
+	}
+	now := time.Now()
+	webhookEventLock.Lock()
+	if seen, ok := webhookEvents[id]; ok && now.Sub(seen) < webhookEventTTL {
+		webhookEventLock.Unlock()
+		return false
+	}
+	webhookEvents[id] = now
+	webhookEventLock.Unlock()
+	go saveWebhookEvents()
+	return true
+}
+
+// startWebhookEventPruneJob drops expired event IDs every hour.
+func startWebhookEventPruneJob() {
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+			cutoff := time.Now().Add(-webhookEventTTL)
+			webhookEventLock.Lock()
+			for id, seen := range webhookEvents {
+				if seen.Before(cutoff) {
+					delete(webhookEvents, id)
+				}
+			}
+			appMetrics.setGauge("webhook_events_tracked", float64(len(webhookEvents)))
+			webhookEventLock.Unlock()
+			saveWebhookEvents()
+		}
+	}()
+}
+
+func saveWebhookEvents() {
+	// held through the write so concurrent saves don't share the temp file
+	webhookEventLock.Lock()
+	defer webhookEventLock.Unlock()
+	data, err := json.Marshal(webhookEvents)
+	if err != nil {
+		log.Printf("Failed to marshal webhook events: %v", err)
+		return
+	}
+	tmpPath := webhookEventsFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("Failed to save webhook events: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, webhookEventsFile); err != nil {
+		log.Printf("Failed to replace webhook events file: %v", err)
+	}
+}
+
+func loadWebhookEvents() {
+	data, err := os.ReadFile(webhookEventsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read webhook events file: %v", err)
+		}
+		return
+	}
+	webhookEventLock.Lock()
+	defer webhookEventLock.Unlock()
+	if err := json.Unmarshal(data, &webhookEvents); err != nil {
+		log.Printf("Failed to parse webhook events file: %v", err)
+	}
+}